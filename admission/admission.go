@@ -0,0 +1,153 @@
+// Package admission limits the number of simultaneous confirmed dialogs, globally and per peer,
+// as trunks of limited capacity do, and the rate of REGISTER and INVITE requests of every AOR.
+// Requests beyond the limits are rejected by the transaction manager.
+package admission
+
+import (
+	"sync"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/transaction"
+)
+
+// Limits of simultaneous confirmed dialogs, 0 means unlimited.
+type Limits struct {
+	// Global limits the dialogs of all peers, beyond it new INVITE requests are rejected with 503 Service Unavailable.
+	Global int
+	// PerPeer limits the dialogs of every peer, beyond it new INVITE requests are rejected with 486 Busy Here.
+	PerPeer int
+}
+
+// Stats is the snapshot of the controller counters.
+type Stats struct {
+	// Active is the number of confirmed dialogs.
+	Active int
+	// Peers is the number of confirmed dialogs by peer.
+	Peers map[string]int
+	// Admitted is the number of admitted INVITE requests.
+	Admitted uint64
+	// RejectedGlobal is the number of INVITE requests rejected by the global limit.
+	RejectedGlobal uint64
+	// RejectedPeer is the number of INVITE requests rejected by the per peer limit.
+	RejectedPeer uint64
+}
+
+// PeerFunc identifies the peer sent the request, e.g. the trunk it came from.
+type PeerFunc func(req *base.Request) string
+
+// Controller counts confirmed dialogs and admits new INVITE requests while the counts are within the limits.
+// The dialogs are reported by the application with Confirmed and Terminated.
+// Only confirmed dialogs are counted, so the INVITE requests admitted at the same time may exceed the limits slightly.
+type Controller struct {
+	limits  Limits
+	peer    PeerFunc
+	dialogs map[base.DialogId]string // Peer of each confirmed dialog.
+	peers   map[string]int
+	stats   Stats
+	lock    sync.Mutex
+}
+
+// NewController creates the controller with the limits.
+// nil peer identifies peers by the host of the top Via header of the request.
+func NewController(limits Limits, peer PeerFunc) *Controller {
+	if peer == nil {
+		peer = ViaHost
+	}
+	return &Controller{
+		limits:  limits,
+		peer:    peer,
+		dialogs: make(map[base.DialogId]string),
+		peers:   make(map[string]int),
+	}
+}
+
+// ViaHost identifies the peer by the host of the top Via header of the request.
+func ViaHost(req *base.Request) string {
+	hop, err := req.ViaHop()
+	if err != nil {
+		return ""
+	}
+	return hop.Host
+}
+
+// Limits returns the current limits.
+func (ctrl *Controller) Limits() Limits {
+	ctrl.lock.Lock()
+	defer ctrl.lock.Unlock()
+	return ctrl.limits
+}
+
+// SetLimits changes the limits at runtime. Confirmed dialogs beyond the new limits are kept,
+// new INVITE requests are rejected until the counts fall below the limits.
+func (ctrl *Controller) SetLimits(limits Limits) {
+	ctrl.lock.Lock()
+	defer ctrl.lock.Unlock()
+	ctrl.limits = limits
+}
+
+// Admit decides on the new INVITE request, zero status admits it.
+func (ctrl *Controller) Admit(req *base.Request) (status uint16, reason string) {
+	peer := ctrl.peer(req)
+
+	ctrl.lock.Lock()
+	defer ctrl.lock.Unlock()
+	switch {
+	case ctrl.limits.Global > 0 && len(ctrl.dialogs) >= ctrl.limits.Global:
+		ctrl.stats.RejectedGlobal++
+		return 503, "Service Unavailable"
+	case ctrl.limits.PerPeer > 0 && ctrl.peers[peer] >= ctrl.limits.PerPeer:
+		ctrl.stats.RejectedPeer++
+		return 486, "Busy Here"
+	}
+	ctrl.stats.Admitted++
+	return 0, ""
+}
+
+// Policy returns the admission policy of the transaction manager backed by the controller.
+func (ctrl *Controller) Policy() transaction.AdmissionPolicy {
+	return ctrl.Admit
+}
+
+// Confirmed counts the confirmed dialog of the peer of the request created it, see Peer.
+// The dialog confirmed again is counted once.
+func (ctrl *Controller) Confirmed(id base.DialogId, peer string) {
+	ctrl.lock.Lock()
+	defer ctrl.lock.Unlock()
+	if _, ok := ctrl.dialogs[id]; ok {
+		return
+	}
+	ctrl.dialogs[id] = peer
+	ctrl.peers[peer]++
+}
+
+// Terminated stops counting the dialog, unknown dialogs are ignored.
+func (ctrl *Controller) Terminated(id base.DialogId) {
+	ctrl.lock.Lock()
+	defer ctrl.lock.Unlock()
+	peer, ok := ctrl.dialogs[id]
+	if !ok {
+		return
+	}
+	delete(ctrl.dialogs, id)
+	if ctrl.peers[peer]--; ctrl.peers[peer] <= 0 {
+		delete(ctrl.peers, peer)
+	}
+}
+
+// Peer identifies the peer of the request the same way as the controller admits it.
+func (ctrl *Controller) Peer(req *base.Request) string {
+	return ctrl.peer(req)
+}
+
+// Stats returns the snapshot of the counters.
+func (ctrl *Controller) Stats() Stats {
+	ctrl.lock.Lock()
+	defer ctrl.lock.Unlock()
+	stats := ctrl.stats
+	stats.Active = len(ctrl.dialogs)
+	stats.Peers = make(map[string]int, len(ctrl.peers))
+	for peer, count := range ctrl.peers {
+		stats.Peers[peer] = count
+	}
+	return stats
+}
@@ -0,0 +1,56 @@
+package admission
+
+import (
+	"testing"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+func TestControllerLimits(t *testing.T) {
+	invite := func(host string) *base.Request {
+		uri := &base.SipUri{User: base.String{S: "bob"}, Host: "example.com", UriParams: base.NewParams(), Headers: base.NewParams()}
+		via := base.ViaHeader{base.NewViaHop("UDP", host, 5060, "")}
+		return base.NewRequest(base.INVITE, uri, "SIP/2.0", []base.SipHeader{&via}, "", log.StandardLogger())
+	}
+	dialog := func(callId string) base.DialogId {
+		return base.DialogId{CallId: callId, LocalTag: "1", RemoteTag: "2"}
+	}
+	admit := func(ctrl *Controller, req *base.Request, expected uint16) {
+		t.Helper()
+		if status, reason := ctrl.Admit(req); status != expected {
+			t.Errorf("[FAIL] expected INVITE from %s admitted with status %d, got %d %s", ctrl.Peer(req), expected, status, reason)
+		}
+	}
+
+	ctrl := NewController(Limits{Global: 3, PerPeer: 2}, nil)
+	trunk1, trunk2 := invite("10.0.0.1"), invite("10.0.0.2")
+
+	admit(ctrl, trunk1, 0)
+	ctrl.Confirmed(dialog("a"), ctrl.Peer(trunk1))
+	ctrl.Confirmed(dialog("a"), ctrl.Peer(trunk1))
+	admit(ctrl, trunk1, 0)
+	ctrl.Confirmed(dialog("b"), ctrl.Peer(trunk1))
+	admit(ctrl, trunk1, 486)
+	admit(ctrl, trunk2, 0)
+	ctrl.Confirmed(dialog("c"), ctrl.Peer(trunk2))
+	admit(ctrl, trunk2, 503)
+
+	ctrl.Terminated(dialog("a"))
+	ctrl.Terminated(dialog("unknown"))
+	admit(ctrl, trunk1, 0)
+
+	ctrl.SetLimits(Limits{Global: 2})
+	admit(ctrl, trunk2, 503)
+	ctrl.SetLimits(Limits{})
+	admit(ctrl, trunk2, 0)
+
+	stats := ctrl.Stats()
+	if stats.Active != 2 || stats.Peers["10.0.0.1"] != 1 || stats.Peers["10.0.0.2"] != 1 {
+		t.Errorf("[FAIL] unexpected dialog counts: %d active, %v by peer", stats.Active, stats.Peers)
+	}
+	if stats.Admitted != 5 || stats.RejectedGlobal != 2 || stats.RejectedPeer != 1 {
+		t.Errorf("[FAIL] unexpected counters: %d admitted, %d rejected globally, %d rejected by peer",
+			stats.Admitted, stats.RejectedGlobal, stats.RejectedPeer)
+	}
+}
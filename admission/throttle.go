@@ -0,0 +1,174 @@
+package admission
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/timing"
+	"github.com/ghettovoice/gossip/transaction"
+)
+
+// RateLimits of the requests of every AOR in an interval, 0 means unlimited.
+type RateLimits struct {
+	Interval time.Duration
+	// Register limits REGISTER requests of the AOR.
+	Register int
+	// Invite limits new INVITE requests of the AOR.
+	Invite int
+	// Status answers the requests beyond the limits: 503 Service Unavailable by default, carrying Retry-After
+	// of the rest of the interval, or 603 Decline to turn away the endpoints that don't respect Retry-After.
+	Status uint16
+}
+
+// AORFunc identifies the address of record the request is sent on behalf of.
+type AORFunc func(req *base.Request) string
+
+// Throttle counts REGISTER and INVITE requests of every AOR in fixed intervals and rejects them beyond the limits,
+// to contain malfunctioning endpoints hammering the server.
+type Throttle struct {
+	limits    RateLimits
+	aor       AORFunc
+	windows   map[string]*rateWindow
+	swept     time.Time // Moment the expired windows were last removed.
+	throttled uint64
+	lock      sync.Mutex
+}
+
+// rateWindow is the count of the requests of an AOR in the current interval.
+type rateWindow struct {
+	start     time.Time
+	registers int
+	invites   int
+}
+
+// NewThrottle creates the throttle with the limits.
+// nil aor identifies AORs with RequestAOR.
+func NewThrottle(limits RateLimits, aor AORFunc) *Throttle {
+	if aor == nil {
+		aor = RequestAOR
+	}
+	return &Throttle{
+		limits:  limits,
+		aor:     aor,
+		windows: make(map[string]*rateWindow),
+		swept:   timing.Now(),
+	}
+}
+
+// RequestAOR identifies the AOR by the To URI of REGISTER requests, i.e. the AOR being registered,
+// and by the From URI of the other requests. SIP URIs are reduced to the scheme, the user and the host.
+func RequestAOR(req *base.Request) string {
+	var uri base.Uri
+	if req.Method == base.REGISTER {
+		to, err := req.To()
+		if err != nil {
+			return ""
+		}
+		uri = to.Address
+	} else {
+		from, err := req.From()
+		if err != nil {
+			return ""
+		}
+		uri = from.Address
+	}
+
+	switch uri := uri.(type) {
+	case nil:
+		return ""
+	case *base.SipUri:
+		aor := &base.SipUri{
+			IsEncrypted: uri.IsEncrypted,
+			User:        uri.User,
+			Host:        strings.ToLower(uri.Host),
+			UriParams:   base.NewParams(),
+			Headers:     base.NewParams(),
+		}
+		return aor.String()
+	default:
+		return uri.String()
+	}
+}
+
+// Limits returns the current limits.
+func (throttle *Throttle) Limits() RateLimits {
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+	return throttle.limits
+}
+
+// SetLimits changes the limits at runtime, the requests counted in the current intervals are kept.
+func (throttle *Throttle) SetLimits(limits RateLimits) {
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+	throttle.limits = limits
+}
+
+// Check counts the request of its AOR, zero status passes it.
+// Methods other than REGISTER and INVITE are never throttled.
+func (throttle *Throttle) Check(req *base.Request) (status uint16, reason string, retryAfter time.Duration) {
+	if req.Method != base.REGISTER && req.Method != base.INVITE {
+		return 0, "", 0
+	}
+	aor := throttle.aor(req)
+	now := timing.Now()
+
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+	limits := throttle.limits
+	if limits.Interval <= 0 {
+		return 0, "", 0
+	}
+	throttle.sweep(now)
+
+	window, ok := throttle.windows[aor]
+	if !ok || !now.Before(window.start.Add(limits.Interval)) {
+		window = &rateWindow{start: now}
+		throttle.windows[aor] = window
+	}
+	var count *int
+	limit := limits.Register
+	if req.Method == base.REGISTER {
+		count = &window.registers
+	} else {
+		count = &window.invites
+		limit = limits.Invite
+	}
+	if limit <= 0 || *count < limit {
+		*count++
+		return 0, "", 0
+	}
+
+	throttle.throttled++
+	if limits.Status == 603 {
+		return 603, "Decline", 0
+	}
+	return 503, "Service Unavailable", window.start.Add(limits.Interval).Sub(now)
+}
+
+// sweep removes the windows of the past intervals, once an interval.
+func (throttle *Throttle) sweep(now time.Time) {
+	if now.Sub(throttle.swept) < throttle.limits.Interval {
+		return
+	}
+	for aor, window := range throttle.windows {
+		if !now.Before(window.start.Add(throttle.limits.Interval)) {
+			delete(throttle.windows, aor)
+		}
+	}
+	throttle.swept = now
+}
+
+// Policy returns the throttle policy of the transaction manager backed by the throttle.
+func (throttle *Throttle) Policy() transaction.ThrottlePolicy {
+	return throttle.Check
+}
+
+// Throttled returns the number of the requests rejected so far.
+func (throttle *Throttle) Throttled() uint64 {
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+	return throttle.throttled
+}
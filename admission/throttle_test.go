@@ -0,0 +1,54 @@
+package admission
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/timing"
+)
+
+func TestThrottle(t *testing.T) {
+	timing.MockMode = true
+	newRequest := func(method base.Method, user string) *base.Request {
+		uri := &base.SipUri{User: base.String{S: user}, Host: "Example.com", UriParams: base.NewParams(), Headers: base.NewParams()}
+		params := base.NewParams().Add("tag", base.String{S: base.GenerateTag()})
+		from := &base.FromHeader{DisplayName: base.NoString{}, Address: uri.Copy(), Params: params}
+		to := &base.ToHeader{DisplayName: base.NoString{}, Address: uri.Copy(), Params: base.NewParams()}
+		return base.NewRequest(method, uri, "SIP/2.0", []base.SipHeader{from, to}, "", log.StandardLogger())
+	}
+	check := func(throttle *Throttle, req *base.Request, expected uint16, retryAfter time.Duration) {
+		t.Helper()
+		status, reason, after := throttle.Check(req)
+		if status != expected || after != retryAfter {
+			t.Errorf("[FAIL] expected %s of %s checked with status %d retry after %v, got %d %s retry after %v",
+				req.Method, RequestAOR(req), expected, retryAfter, status, reason, after)
+		}
+	}
+
+	if aor := RequestAOR(newRequest(base.REGISTER, "alice")); aor != "sip:alice@example.com" {
+		t.Errorf("[FAIL] unexpected AOR %s", aor)
+	}
+
+	throttle := NewThrottle(RateLimits{Interval: time.Minute, Register: 2, Invite: 1}, nil)
+	check(throttle, newRequest(base.REGISTER, "alice"), 0, 0)
+	check(throttle, newRequest(base.INVITE, "alice"), 0, 0)
+	timing.Elapse(20 * time.Second)
+	check(throttle, newRequest(base.REGISTER, "alice"), 0, 0)
+	check(throttle, newRequest(base.REGISTER, "alice"), 503, 40*time.Second)
+	check(throttle, newRequest(base.INVITE, "alice"), 503, 40*time.Second)
+	// Other AORs and methods are not affected.
+	check(throttle, newRequest(base.REGISTER, "bob"), 0, 0)
+	check(throttle, newRequest(base.OPTIONS, "alice"), 0, 0)
+
+	timing.Elapse(40 * time.Second)
+	check(throttle, newRequest(base.INVITE, "alice"), 0, 0)
+	throttle.SetLimits(RateLimits{Interval: time.Minute, Invite: 1, Status: 603})
+	check(throttle, newRequest(base.INVITE, "alice"), 603, 0)
+	check(throttle, newRequest(base.REGISTER, "alice"), 0, 0)
+
+	if throttled := throttle.Throttled(); throttled != 3 {
+		t.Errorf("[FAIL] expected 3 requests throttled, got %d", throttled)
+	}
+}
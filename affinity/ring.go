@@ -0,0 +1,171 @@
+// Package affinity keeps all messages of a call on the same backend of a cluster
+// by consistent hashing of Call-ID, so that the backends need no shared state.
+package affinity
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/transaction"
+)
+
+// Number of points of each backend on the ring, evens out the distribution of keys.
+const DefaultReplicas = 100
+
+// Ring maps routing keys to backends by consistent hashing:
+// adding or removing a backend moves only the keys of that backend.
+type Ring struct {
+	replicas int
+	points   []uint32          // Sorted hashes of the backend points.
+	owners   map[uint32]string // Backend of each point.
+	backends map[string]bool
+	lock     sync.RWMutex
+}
+
+// NewRing creates the ring of the backends, e.g. host:port addresses.
+// replicas of 0 or less means DefaultReplicas.
+func NewRing(replicas int, backends ...string) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	ring := &Ring{
+		replicas: replicas,
+		owners:   make(map[uint32]string),
+		backends: make(map[string]bool),
+	}
+	for _, backend := range backends {
+		ring.Add(backend)
+	}
+	return ring
+}
+
+// Add puts the backend on the ring.
+func (ring *Ring) Add(backend string) {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+	if ring.backends[backend] {
+		return
+	}
+	ring.backends[backend] = true
+	for i := 0; i < ring.replicas; i++ {
+		point := hash(backend + "#" + strconv.Itoa(i))
+		// Collisions are resolved in favor of the lower backend, so the ring doesn't depend on the order of adding.
+		if owner, ok := ring.owners[point]; ok && owner < backend {
+			continue
+		}
+		ring.owners[point] = backend
+	}
+	ring.sort()
+}
+
+// Remove takes the backend off the ring, its keys move to the next backends on the ring.
+func (ring *Ring) Remove(backend string) {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+	if !ring.backends[backend] {
+		return
+	}
+	delete(ring.backends, backend)
+	for point, owner := range ring.owners {
+		if owner == backend {
+			delete(ring.owners, point)
+		}
+	}
+	// Re-add the other backends, so the points they lost in collisions are restored.
+	for other := range ring.backends {
+		for i := 0; i < ring.replicas; i++ {
+			point := hash(other + "#" + strconv.Itoa(i))
+			if owner, ok := ring.owners[point]; !ok || other < owner {
+				ring.owners[point] = other
+			}
+		}
+	}
+	ring.sort()
+}
+
+// Backends returns the backends on the ring in lexical order.
+func (ring *Ring) Backends() []string {
+	ring.lock.RLock()
+	defer ring.lock.RUnlock()
+	backends := make([]string, 0, len(ring.backends))
+	for backend := range ring.backends {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+	return backends
+}
+
+// Get returns the backend of the routing key, empty string if the ring is empty.
+func (ring *Ring) Get(key string) string {
+	ring.lock.RLock()
+	defer ring.lock.RUnlock()
+	if len(ring.points) == 0 {
+		return ""
+	}
+
+	h := hash(key)
+	idx := sort.Search(len(ring.points), func(i int) bool { return ring.points[i] >= h })
+	if idx == len(ring.points) {
+		idx = 0
+	}
+	return ring.owners[ring.points[idx]]
+}
+
+// Backend returns the backend of the call the message belongs to.
+func (ring *Ring) Backend(msg base.SipMessage) (string, error) {
+	key, err := Key(msg)
+	if err != nil {
+		return "", err
+	}
+	backend := ring.Get(key)
+	if backend == "" {
+		return "", fmt.Errorf("no backends on the ring")
+	}
+	return backend, nil
+}
+
+// Router returns transaction.Router forwarding requests to the backend of their call.
+// Requests of the calls owned by self, e.g. the address of this instance, are passed to the next router, if any.
+func (ring *Ring) Router(self string, next transaction.Router) transaction.Router {
+	return func(req *base.Request) transaction.Route {
+		backend, err := ring.Backend(req)
+		if err != nil {
+			req.Log().Debugf("no affinity of request %s: %s", req.Short(), err)
+		} else if backend != self {
+			return transaction.Route{Forward: backend}
+		}
+
+		if next != nil {
+			return next(req)
+		}
+		return transaction.Route{}
+	}
+}
+
+// Key returns the stable routing key of the message, the value of its Call-ID.
+func Key(msg base.SipMessage) (string, error) {
+	callId, err := msg.CallId()
+	if err != nil {
+		return "", err
+	}
+	return string(*callId), nil
+}
+
+func (ring *Ring) sort() {
+	ring.points = ring.points[:0]
+	for point := range ring.owners {
+		ring.points = append(ring.points, point)
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+}
+
+// hash spreads similar keys, such as the points of the same backend, uniformly over the ring.
+func hash(key string) uint32 {
+	sum := md5.Sum([]byte(key))
+	return binary.BigEndian.Uint32(sum[:])
+}
@@ -0,0 +1,78 @@
+package affinity
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+func TestRingStability(t *testing.T) {
+	backends := []string{"10.0.0.1:5060", "10.0.0.2:5060", "10.0.0.3:5060"}
+	ring := NewRing(0, backends...)
+	reversed := NewRing(0, backends[2], backends[1], backends[0])
+
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("call-%d@example.com", i)
+		owners[key] = ring.Get(key)
+		counts[owners[key]]++
+		if reversed.Get(key) != owners[key] {
+			t.Fatalf("[FAIL] ring depends on the order of adding backends, key %s", key)
+		}
+	}
+	for _, backend := range backends {
+		if counts[backend] < 500 {
+			t.Errorf("[FAIL] backend %s got only %d of 3000 keys", backend, counts[backend])
+		}
+	}
+
+	ring.Remove(backends[1])
+	for key, owner := range owners {
+		got := ring.Get(key)
+		if owner != backends[1] && got != owner {
+			t.Errorf("[FAIL] key %s moved from %s to %s after removal of another backend", key, owner, got)
+		}
+		if got == backends[1] {
+			t.Errorf("[FAIL] key %s still routed to the removed backend", key)
+		}
+	}
+
+	ring.Add(backends[1])
+	for key, owner := range owners {
+		if got := ring.Get(key); got != owner {
+			t.Errorf("[FAIL] key %s routed to %s after the backend returned, expected %s", key, got, owner)
+		}
+	}
+}
+
+func TestRingRouter(t *testing.T) {
+	ring := NewRing(0, "10.0.0.1:5060", "10.0.0.2:5060")
+	newRequest := func(callId string) *base.Request {
+		id := base.CallId(callId)
+		uri := &base.SipUri{User: base.String{S: "bob"}, Host: "example.com", UriParams: base.NewParams(), Headers: base.NewParams()}
+		return base.NewRequest(base.INVITE, uri, "SIP/2.0", []base.SipHeader{&id}, "", log.StandardLogger())
+	}
+
+	req := newRequest("a84b4c76e66710")
+	owner, err := ring.Backend(req)
+	if err != nil {
+		t.Fatalf("[FAIL] %s", err)
+	}
+	other := ring.Backends()[0]
+	if other == owner {
+		other = ring.Backends()[1]
+	}
+
+	if route := ring.Router(other, nil)(req); route.Forward != owner {
+		t.Errorf("[FAIL] expected request forwarded to %s, got %v", owner, route)
+	}
+	if route := ring.Router(owner, nil)(req); !route.IsLocal() {
+		t.Errorf("[FAIL] expected request of own call handled locally, got %v", route)
+	}
+	if route := NewRing(0).Router(owner, nil)(req); !route.IsLocal() {
+		t.Errorf("[FAIL] expected request handled locally with empty ring, got %v", route)
+	}
+}
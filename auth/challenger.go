@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/timing"
+	"github.com/ghettovoice/gossip/utils"
+)
+
+// Defaults of the nonce lifetime.
+const (
+	DefaultNonceExpiry = 5 * time.Minute
+	DefaultNonceUses   = 100
+)
+
+// Kinds of errors reported by Challenger.Verify.
+// Use errors.Is to check the kind of a returned error.
+var (
+	// ErrNoAuthorization means the request has no credentials of the realm, challenge it.
+	ErrNoAuthorization = errors.New("no authorization")
+	// ErrStaleNonce means the credentials are valid but the nonce expired or was used up, challenge with stale=true.
+	ErrStaleNonce = errors.New("stale nonce")
+	// ErrUnauthorized means the credentials are wrong, e.g. unknown user or wrong password.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrReplayed means the credentials are valid but were used before with the same nonce count,
+	// e.g. captured and sent again, challenge without stale.
+	ErrReplayed = errors.New("replayed")
+)
+
+// Width of the window of nonce counts accepted out of order, e.g. of requests sent in parallel.
+const nonceCountWindow = 64
+
+// Store is the pluggable source of passwords of users, e.g. a database of a registrar.
+type Store interface {
+	Password(username string, realm string) (string, bool)
+}
+
+// MemoryStore is the Store of passwords by username, for tests and small static deployments.
+type MemoryStore map[string]string
+
+func (store MemoryStore) Password(username string, realm string) (string, bool) {
+	password, ok := store[username]
+	return password, ok
+}
+
+// HA1Store is the pluggable source of HA1 hashes of the passwords, for stores not keeping the passwords in clear,
+// e.g. ha1 column of a subscriber table. The hash must be computed with the algorithm given, see HA1.
+type HA1Store interface {
+	HA1(username string, realm string, algorithm string) (string, bool)
+}
+
+// MemoryHA1Store is the HA1Store of hashes by username, all of the same realm and algorithm.
+type MemoryHA1Store map[string]string
+
+func (store MemoryHA1Store) HA1(username string, realm string, algorithm string) (string, bool) {
+	ha1, ok := store[username]
+	return ha1, ok
+}
+
+// passwordStore computes HA1 of the passwords of Store.
+type passwordStore struct {
+	Store
+}
+
+func (store passwordStore) HA1(username string, realm string, algorithm string) (string, bool) {
+	password, ok := store.Password(username, realm)
+	if !ok {
+		return "", false
+	}
+	return HA1(algorithm, username, realm, password), true
+}
+
+type nonceState struct {
+	expires time.Time
+	uses    int
+	nc      uint64 // Highest nonce count seen.
+	seen    uint64 // Counts seen in the window below nc, bit i is set if nc-i was seen.
+}
+
+// count marks the nonce count seen, fails if it was seen before or is too old to tell - RFC 2617 3.2.2.
+func (state *nonceState) count(count uint64) error {
+	switch {
+	case count > state.nc:
+		if shift := count - state.nc; shift < nonceCountWindow {
+			state.seen = state.seen<<shift | 1
+		} else {
+			state.seen = 1
+		}
+		state.nc = count
+	case state.nc-count >= nonceCountWindow:
+		return fmt.Errorf("nonce count %08x is out of window of %08x", count, state.nc)
+	case state.seen&(1<<(state.nc-count)) != 0:
+		return fmt.Errorf("nonce count %08x replayed", count)
+	default:
+		state.seen |= 1 << (state.nc - count)
+	}
+	return nil
+}
+
+// Challenger issues digest challenges of the realm and verifies the answers to them - RFC 3261 22.1.
+// Every nonce is valid until it expires or is used the limited number of times.
+type Challenger struct {
+	realm     string
+	algorithm string
+	opaque    string
+	expiry    time.Duration
+	maxUses   int
+	store     HA1Store
+	nonces    map[string]*nonceState
+	lock      sync.Mutex
+}
+
+// NewChallenger creates the challenger of the realm, with MD5 algorithm and default nonce lifetime.
+func NewChallenger(realm string, store Store) *Challenger {
+	return NewHA1Challenger(realm, passwordStore{store})
+}
+
+// NewHA1Challenger creates the challenger of the realm verifying the credentials by HA1 hashes of the store,
+// with MD5 algorithm and default nonce lifetime.
+func NewHA1Challenger(realm string, store HA1Store) *Challenger {
+	return &Challenger{
+		realm:     realm,
+		algorithm: MD5,
+		opaque:    utils.RandStr(16),
+		expiry:    DefaultNonceExpiry,
+		maxUses:   DefaultNonceUses,
+		store:     store,
+		nonces:    make(map[string]*nonceState),
+	}
+}
+
+// SetAlgorithm sets the digest algorithm of the challenges, MD5 or SHA-256.
+func (ch *Challenger) SetAlgorithm(algorithm string) error {
+	if newHash(algorithm) == nil {
+		return fmt.Errorf("unsupported digest algorithm %s", algorithm)
+	}
+	ch.lock.Lock()
+	defer ch.lock.Unlock()
+	ch.algorithm = strings.ToUpper(algorithm)
+	return nil
+}
+
+// SetNonceLifetime limits the time and the number of requests a nonce is valid for, 0 means the default.
+func (ch *Challenger) SetNonceLifetime(expiry time.Duration, maxUses int) {
+	if expiry <= 0 {
+		expiry = DefaultNonceExpiry
+	}
+	if maxUses <= 0 {
+		maxUses = DefaultNonceUses
+	}
+	ch.lock.Lock()
+	defer ch.lock.Unlock()
+	ch.expiry = expiry
+	ch.maxUses = maxUses
+}
+
+// Realm returns the realm of the challenger.
+func (ch *Challenger) Realm() string {
+	return ch.realm
+}
+
+// Challenge issues a new nonce and returns WWW-Authenticate or Proxy-Authenticate header value with it.
+// stale tells the client its credentials are valid and it should retry with the new nonce - RFC 2617 3.2.1.
+func (ch *Challenger) Challenge(stale bool) string {
+	nonce := utils.RandStr(32)
+
+	ch.lock.Lock()
+	now := timing.Now()
+	for value, state := range ch.nonces {
+		if !now.Before(state.expires) {
+			delete(ch.nonces, value)
+		}
+	}
+	ch.nonces[nonce] = &nonceState{expires: now.Add(ch.expiry)}
+	algorithm := ch.algorithm
+	ch.lock.Unlock()
+
+	value := fmt.Sprintf("Digest realm=\"%s\", nonce=\"%s\", opaque=\"%s\", algorithm=%s, qop=\"auth\"",
+		ch.realm, nonce, ch.opaque, algorithm)
+	if stale {
+		value += ", stale=true"
+	}
+	return value
+}
+
+// Respond builds the challenge response to the request: 401 Unauthorized with WWW-Authenticate header,
+// or 407 Proxy Authentication Required with Proxy-Authenticate header if proxy is set - RFC 3261 22.2, 22.3.
+func (ch *Challenger) Respond(req *base.Request, proxy bool, stale bool) *base.Response {
+	res := base.NewResponseFromRequest(req, 401, "Unauthorized", "")
+	name := "WWW-Authenticate"
+	if proxy {
+		res = base.NewResponseFromRequest(req, 407, "Proxy Authentication Required", "")
+		name = "Proxy-Authenticate"
+	}
+	res.AddHeader(&base.GenericHeader{HeaderName: name, Contents: ch.Challenge(stale)})
+	return res
+}
+
+// Verify checks Authorization, or Proxy-Authorization if proxy is set, of the realm in the request,
+// returns the authenticated username, or the username claimed by the credentials along with the error.
+// Errors are of kinds ErrNoAuthorization, ErrStaleNonce, ErrReplayed and ErrUnauthorized.
+// Only valid credentials of an expired or unknown nonce are stale, so that the client retries with the new one.
+func (ch *Challenger) Verify(req *base.Request, proxy bool) (string, error) {
+	name := "Authorization"
+	if proxy {
+		name = "Proxy-Authorization"
+	}
+
+	var params map[string]string
+	for _, h := range req.Headers(name) {
+		value := strings.TrimSpace(headerValue(h))
+		if len(value) < 7 || !strings.EqualFold(value[:7], "Digest ") {
+			continue
+		}
+		if candidate := parseParams(value[7:]); candidate["realm"] == ch.realm {
+			params = candidate
+			break
+		}
+	}
+	if params == nil {
+		return "", base.NewError(ErrNoAuthorization, nil, "request %s has no %s of realm %s", req.Short(), name, ch.realm)
+	}
+
+	username := params["username"]
+	algorithm := params["algorithm"]
+	if algorithm == "" {
+		algorithm = MD5
+	}
+	if newHash(algorithm) == nil {
+		return username, base.NewError(ErrUnauthorized, nil, "unsupported digest algorithm %s of user %s", algorithm, username)
+	}
+	if uri := params["uri"]; uri != req.Recipient.String() {
+		// The credentials of another request can't be reused - RFC 2617 3.2.2.5.
+		return username, base.NewError(ErrUnauthorized, nil, "digest URI %s of user %s doesn't match Request-URI %s",
+			uri, username, req.Recipient)
+	}
+	qop, nc := params["qop"], params["nc"]
+	if qop != "" && (!strings.EqualFold(qop, "auth") || len(nc) != 8 || params["cnonce"] == "") {
+		return username, base.NewError(ErrUnauthorized, nil, "invalid qop %s of user %s", qop, username)
+	}
+	ha1, ok := ch.store.HA1(username, ch.realm, algorithm)
+	if !ok {
+		return username, base.NewError(ErrUnauthorized, nil, "unknown user %s", username)
+	}
+	expected := responseOfHA1(algorithm, ha1, params["nonce"],
+		req.Method, params["uri"], qop, nc, params["cnonce"])
+	if params["response"] != expected {
+		return username, base.NewError(ErrUnauthorized, nil, "wrong credentials of user %s", username)
+	}
+
+	// The credentials are valid from here on, the nonce decides whether they are fresh - RFC 2617 3.2.1.
+	ch.lock.Lock()
+	current := params["opaque"] == ch.opaque && strings.EqualFold(algorithm, ch.algorithm)
+	ch.lock.Unlock()
+	if !current {
+		return username, base.NewError(ErrStaleNonce, nil, "credentials of %s answer another challenge", username)
+	}
+	if err := ch.useNonce(params["nonce"], qop, nc); err != nil {
+		return username, fmt.Errorf("credentials of %s are not accepted: %w", username, err)
+	}
+	return username, nil
+}
+
+// useNonce counts the use of the nonce, returns the kind of error if the nonce is unknown, expired or used up,
+// or the nonce count is replayed.
+func (ch *Challenger) useNonce(nonce string, qop string, nc string) error {
+	ch.lock.Lock()
+	defer ch.lock.Unlock()
+
+	state, ok := ch.nonces[nonce]
+	if !ok {
+		return base.NewError(ErrStaleNonce, nil, "unknown nonce %s", nonce)
+	}
+	if !timing.Now().Before(state.expires) || state.uses >= ch.maxUses {
+		delete(ch.nonces, nonce)
+		return base.NewError(ErrStaleNonce, nil, "nonce %s expired", nonce)
+	}
+	if qop != "" {
+		count, err := strconv.ParseUint(nc, 16, 32)
+		if err != nil || count == 0 {
+			return base.NewError(ErrUnauthorized, err, "invalid nonce count %s", nc)
+		}
+		if err := state.count(count); err != nil {
+			return base.NewError(ErrReplayed, err, "nonce %s", nonce)
+		}
+	}
+	state.uses++
+	return nil
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/timing"
+)
+
+func TestChallengerVerify(t *testing.T) {
+	timing.MockMode = true
+	ch := NewChallenger("example.com", MemoryStore{"alice": "secret"})
+	ch.SetNonceLifetime(time.Minute, 0)
+	assertNoError := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("[FAIL] unexpected error: %s", err)
+		}
+	}
+	newRequest := func() *base.Request {
+		uri := &base.SipUri{Host: "example.com", UriParams: base.NewParams(), Headers: base.NewParams()}
+		return base.NewRequest(base.REGISTER, uri, "SIP/2.0", []base.SipHeader{}, "", log.StandardLogger())
+	}
+	answer := func(password string, stale bool) *base.Request {
+		req := newRequest()
+		res := ch.Respond(req, false, stale)
+		if res.StatusCode != 401 {
+			t.Fatalf("[FAIL] expected 401 challenge, got %s", res.Short())
+		}
+		hdrs, err := Authorize(req, res, Static(Credentials{"alice", password}))
+		assertNoError(err)
+		for _, h := range hdrs {
+			req.AddHeader(h)
+		}
+		return req
+	}
+	verify := func(req *base.Request, kind error) {
+		t.Helper()
+		username, err := ch.Verify(req, false)
+		switch {
+		case kind == nil && err != nil:
+			t.Errorf("[FAIL] unexpected verification error: %s", err)
+		case kind == nil && username != "alice":
+			t.Errorf("[FAIL] expected user alice authenticated, got '%s'", username)
+		case kind != nil && !errors.Is(err, kind):
+			t.Errorf("[FAIL] expected error of kind '%s', got %v", kind, err)
+		}
+	}
+
+	req := answer("secret", false)
+	verify(req, nil)
+	// The same nonce count is a replay.
+	verify(req, ErrReplayed)
+	verify(answer("wrong", false), ErrUnauthorized)
+	verify(newRequest(), ErrNoAuthorization)
+
+	req = answer("secret", true)
+	timing.Elapse(time.Minute)
+	verify(req, ErrStaleNonce)
+
+	assertNoError(ch.SetAlgorithm(SHA256))
+	verify(answer("secret", false), nil)
+	if err := ch.SetAlgorithm("SHA-512-256"); err == nil {
+		t.Errorf("[FAIL] expected unsupported algorithm error")
+	}
+}
+
+func TestHA1Challenger(t *testing.T) {
+	store := MemoryHA1Store{"alice": HA1(MD5, "alice", "example.com", "secret")}
+	ch := NewHA1Challenger("example.com", store)
+	uri := &base.SipUri{Host: "example.com", UriParams: base.NewParams(), Headers: base.NewParams()}
+	for password, authorized := range map[string]bool{"secret": true, "wrong": false} {
+		req := base.NewRequest(base.REGISTER, uri, "SIP/2.0", []base.SipHeader{}, "", log.StandardLogger())
+		hdrs, err := Authorize(req, ch.Respond(req, true, false), Static(Credentials{"alice", password}))
+		if err != nil {
+			t.Fatalf("[FAIL] unexpected error: %s", err)
+		}
+		req.AddHeader(hdrs[0])
+		username, err := ch.Verify(req, true)
+		if authorized && (err != nil || username != "alice") {
+			t.Errorf("[FAIL] expected user alice authenticated by HA1, got '%s': %v", username, err)
+		}
+		if !authorized && !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("[FAIL] expected error of kind '%s' of password %s, got %v", ErrUnauthorized, password, err)
+		}
+	}
+}
+
+func TestChallengerNonceCount(t *testing.T) {
+	ch := NewChallenger("example.com", MemoryStore{"alice": "secret"})
+	uri := &base.SipUri{Host: "example.com", UriParams: base.NewParams(), Headers: base.NewParams()}
+	req := base.NewRequest(base.REGISTER, uri, "SIP/2.0", []base.SipHeader{}, "", log.StandardLogger())
+	chal, err := ParseChallenge(headerValue(ch.Respond(req, false, false).Headers("WWW-Authenticate")[0]))
+	if err != nil {
+		t.Fatalf("[FAIL] unexpected error: %s", err)
+	}
+	// answer builds the credentials of the nonce count, as Challenge.Authorization does with the next one.
+	answer := func(nc uint64, password string, opaque string) *base.Request {
+		cred := Credentials{"alice", password}
+		ncValue := fmt.Sprintf("%08x", nc)
+		response := Response(chal.Algorithm, cred, chal.Realm, chal.Nonce, req.Method, uri.String(), "auth", ncValue, "c1")
+		authorized := req.Copy()
+		authorized.AddHeader(&base.GenericHeader{HeaderName: "Authorization", Contents: fmt.Sprintf(
+			"Digest username=\"alice\", realm=\"%s\", nonce=\"%s\", uri=\"%s\", response=\"%s\", algorithm=%s, "+
+				"opaque=\"%s\", qop=auth, nc=%s, cnonce=\"c1\"",
+			chal.Realm, chal.Nonce, uri, response, chal.Algorithm, opaque, ncValue)})
+		return authorized
+	}
+
+	for _, test := range []struct {
+		req  *base.Request
+		kind error
+	}{
+		{answer(2, "secret", chal.Opaque), nil},
+		// Requests sent in parallel may arrive out of order.
+		{answer(1, "secret", chal.Opaque), nil},
+		{answer(1, "secret", chal.Opaque), ErrReplayed},
+		{answer(100, "secret", chal.Opaque), nil},
+		{answer(3, "secret", chal.Opaque), ErrReplayed},
+		// Wrong credentials are never stale, even answering another challenge.
+		{answer(101, "wrong", "other"), ErrUnauthorized},
+		{answer(101, "secret", "other"), ErrStaleNonce},
+	} {
+		username, err := ch.Verify(test.req, false)
+		switch {
+		case test.kind == nil && (err != nil || username != "alice"):
+			t.Errorf("[FAIL] expected user alice authenticated, got '%s': %v", username, err)
+		case test.kind != nil && !errors.Is(err, test.kind):
+			t.Errorf("[FAIL] expected error of kind '%s', got %v", test.kind, err)
+		}
+	}
+
+	other := answer(102, "secret", chal.Opaque)
+	other.Recipient = &base.SipUri{Host: "other.example.com", UriParams: base.NewParams(), Headers: base.NewParams()}
+	if _, err := ch.Verify(other, false); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("[FAIL] expected credentials of another Request-URI unauthorized, got %v", err)
+	}
+}
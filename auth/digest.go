@@ -0,0 +1,228 @@
+// Package auth implements SIP digest authentication - RFC 3261 22.4, RFC 2617 and RFC 8760.
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/utils"
+)
+
+// Digest algorithms.
+const (
+	MD5    = "MD5"
+	SHA256 = "SHA-256"
+)
+
+// Credentials of the user in a realm.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// CredentialsLookup returns the credentials of the realm, false if there are none.
+type CredentialsLookup func(realm string) (Credentials, bool)
+
+// Static returns the lookup answering every realm with the same credentials.
+func Static(cred Credentials) CredentialsLookup {
+	return func(string) (Credentials, bool) {
+		return cred, true
+	}
+}
+
+// Challenge is a digest challenge of WWW-Authenticate or Proxy-Authenticate header.
+type Challenge struct {
+	Realm     string
+	Nonce     string
+	Opaque    string
+	Algorithm string
+	Qop       []string
+	Stale     bool
+}
+
+// ParseChallenge parses WWW-Authenticate or Proxy-Authenticate header value.
+func ParseChallenge(value string) (*Challenge, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 7 || !strings.EqualFold(value[:7], "Digest ") {
+		return nil, fmt.Errorf("unsupported authentication scheme in '%s'", value)
+	}
+
+	chal := &Challenge{Algorithm: MD5}
+	for key, val := range parseParams(value[7:]) {
+		switch key {
+		case "realm":
+			chal.Realm = val
+		case "nonce":
+			chal.Nonce = val
+		case "opaque":
+			chal.Opaque = val
+		case "algorithm":
+			chal.Algorithm = strings.ToUpper(val)
+		case "qop":
+			for _, option := range strings.Split(val, ",") {
+				chal.Qop = append(chal.Qop, strings.TrimSpace(option))
+			}
+		case "stale":
+			chal.Stale = strings.EqualFold(val, "true")
+		}
+	}
+
+	if chal.Nonce == "" {
+		return nil, fmt.Errorf("no nonce in challenge '%s'", value)
+	}
+	if newHash(chal.Algorithm) == nil {
+		return nil, fmt.Errorf("unsupported digest algorithm %s", chal.Algorithm)
+	}
+
+	return chal, nil
+}
+
+// Authorization builds Authorization or Proxy-Authorization header value answering the challenge for the request.
+// qop=auth is used if the challenge offers it.
+func (chal *Challenge) Authorization(method base.Method, uri string, cred Credentials) string {
+	var qop, cnonce, nc string
+	for _, option := range chal.Qop {
+		if option == "auth" {
+			qop = "auth"
+		}
+	}
+	if qop != "" {
+		cnonce = utils.RandStr(8)
+		nc = "00000001"
+	}
+	response := Response(chal.Algorithm, cred, chal.Realm, chal.Nonce, method, uri, qop, nc, cnonce)
+
+	value := fmt.Sprintf(
+		"Digest username=\"%s\", realm=\"%s\", nonce=\"%s\", uri=\"%s\", response=\"%s\", algorithm=%s",
+		cred.Username, chal.Realm, chal.Nonce, uri, response, chal.Algorithm,
+	)
+	if chal.Opaque != "" {
+		value += fmt.Sprintf(", opaque=\"%s\"", chal.Opaque)
+	}
+	if qop != "" {
+		value += fmt.Sprintf(", qop=%s, nc=%s, cnonce=\"%s\"", qop, nc, cnonce)
+	}
+
+	return value
+}
+
+// Response computes the digest response - RFC 2617 3.2.2.1. Empty qop means the RFC 2069 compatible digest.
+func Response(algorithm string, cred Credentials, realm, nonce string, method base.Method, uri, qop, nc, cnonce string) string {
+	return responseOfHA1(algorithm, HA1(algorithm, cred.Username, realm, cred.Password), nonce, method, uri, qop, nc, cnonce)
+}
+
+// HA1 computes the hash of the credentials in the realm the digest response is based on - RFC 2617 3.2.2.2.
+// Stores keep it instead of the password, see HA1Store.
+func HA1(algorithm string, username, realm, password string) string {
+	return hexDigest(algorithm, username+":"+realm+":"+password)
+}
+
+func responseOfHA1(algorithm string, ha1, nonce string, method base.Method, uri, qop, nc, cnonce string) string {
+	ha2 := hexDigest(algorithm, string(method)+":"+uri)
+	if qop == "" {
+		return hexDigest(algorithm, ha1+":"+nonce+":"+ha2)
+	}
+	return hexDigest(algorithm, strings.Join([]string{ha1, nonce, nc, cnonce, qop, ha2}, ":"))
+}
+
+// Authorize answers the challenges of 401 Unauthorized or 407 Proxy Authentication Required response to the request,
+// returns Authorization or Proxy-Authorization headers, one per challenge with known credentials - RFC 3261 22.2, 22.3.
+func Authorize(req *base.Request, res *base.Response, creds CredentialsLookup) ([]base.SipHeader, error) {
+	challengeName, authName := "WWW-Authenticate", "Authorization"
+	if res.StatusCode == 407 {
+		challengeName, authName = "Proxy-Authenticate", "Proxy-Authorization"
+	}
+
+	challenges := res.Headers(challengeName)
+	if len(challenges) == 0 {
+		return nil, fmt.Errorf("response %s has no %s header", res.Short(), challengeName)
+	}
+
+	hdrs := make([]base.SipHeader, 0, len(challenges))
+	var lastErr error
+	for _, h := range challenges {
+		chal, err := ParseChallenge(headerValue(h))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		cred, ok := creds(chal.Realm)
+		if !ok {
+			lastErr = fmt.Errorf("no credentials for realm '%s'", chal.Realm)
+			continue
+		}
+		hdrs = append(hdrs, &base.GenericHeader{
+			HeaderName: authName,
+			Contents:   chal.Authorization(req.Method, req.Recipient.String(), cred),
+		})
+	}
+	if len(hdrs) == 0 {
+		return nil, lastErr
+	}
+
+	return hdrs, nil
+}
+
+// IsChallenge reports whether the response challenges the request - RFC 3261 22.
+func IsChallenge(res *base.Response) bool {
+	return res.StatusCode == 401 || res.StatusCode == 407
+}
+
+func newHash(algorithm string) hash.Hash {
+	switch strings.ToUpper(algorithm) {
+	case MD5:
+		return md5.New()
+	case SHA256:
+		return sha256.New()
+	default:
+		return nil
+	}
+}
+
+func hexDigest(algorithm string, s string) string {
+	h := newHash(algorithm)
+	if h == nil {
+		h = md5.New()
+	}
+	h.Write([]byte(s))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// parseParams parses comma separated auth-params, ignoring commas inside quoted strings.
+// Keys are lower cased, values are unquoted.
+func parseParams(s string) map[string]string {
+	params := make(map[string]string)
+	quoted := false
+	start := 0
+	add := func(part string) {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return
+		}
+		params[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.Trim(strings.TrimSpace(kv[1]), "\"")
+	}
+	for i, c := range s {
+		switch c {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				add(s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	add(s[start:])
+	return params
+}
+
+func headerValue(h base.SipHeader) string {
+	if generic, ok := h.(*base.GenericHeader); ok {
+		return generic.Contents
+	}
+	return ""
+}
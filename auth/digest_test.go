@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+func TestResponse(t *testing.T) {
+	// RFC 2617 3.5 and RFC 7616 3.9.1 examples.
+	md5 := Response(MD5, Credentials{"Mufasa", "Circle Of Life"}, "testrealm@host.com",
+		"dcd98b7102dd2f0e8b11d0f600bfb0c093", "GET", "/dir/index.html", "auth", "00000001", "0a4f113b")
+	if md5 != "6629fae49393a05397450978507c4ef1" {
+		t.Errorf("[FAIL] unexpected MD5 digest response %s", md5)
+	}
+	sha := Response(SHA256, Credentials{"Mufasa", "Circle of Life"}, "http-auth@example.org",
+		"7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", "GET", "/dir/index.html", "auth", "00000001",
+		"f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ")
+	if sha != "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1" {
+		t.Errorf("[FAIL] unexpected SHA-256 digest response %s", sha)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	uri := &base.SipUri{Host: "example.com", UriParams: base.NewParams(), Headers: base.NewParams()}
+	req := base.NewRequest(base.INVITE, uri, "SIP/2.0", []base.SipHeader{}, "", log.StandardLogger())
+	res := base.NewResponse("SIP/2.0", 407, "Proxy Authentication Required", []base.SipHeader{
+		&base.GenericHeader{
+			HeaderName: "Proxy-Authenticate",
+			Contents:   `Digest realm="unknown.com", nonce="abc"`,
+		},
+		&base.GenericHeader{
+			HeaderName: "Proxy-Authenticate",
+			Contents:   `Digest realm="example.com", nonce="def", opaque="xyz", algorithm=SHA-256, qop="auth-int,auth"`,
+		},
+	}, "", log.StandardLogger())
+
+	creds := func(realm string) (Credentials, bool) {
+		return Credentials{"alice", "secret"}, realm == "example.com"
+	}
+	hdrs, err := Authorize(req, res, creds)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to answer challenge: %s", err)
+	}
+	if len(hdrs) != 1 || hdrs[0].Name() != "Proxy-Authorization" {
+		t.Fatalf("[FAIL] expected one Proxy-Authorization header, got %v", hdrs)
+	}
+	value := hdrs[0].(*base.GenericHeader).Contents
+	for _, part := range []string{`username="alice"`, `realm="example.com"`, `uri="sip:example.com"`,
+		"algorithm=SHA-256", `opaque="xyz"`, "qop=auth,", "nc=00000001"} {
+		if !strings.Contains(value, part) {
+			t.Errorf("[FAIL] expected '%s' in Proxy-Authorization header '%s'", part, value)
+		}
+	}
+
+	if _, err := ParseChallenge(`Digest realm="example.com", nonce="abc", algorithm=SHA-512-256`); err == nil {
+		t.Errorf("[FAIL] expected unsupported algorithm error")
+	}
+	if _, err := Authorize(req, res, func(string) (Credentials, bool) { return Credentials{}, false }); err == nil {
+		t.Errorf("[FAIL] expected error without credentials")
+	}
+}
@@ -0,0 +1,106 @@
+package base
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Requesting answering modes - RFC 5373.
+
+// Values of Answer-Mode and Priv-Answer-Mode headers.
+const (
+	AnswerModeManual = "Manual"
+	AnswerModeAuto   = "Auto"
+)
+
+// Option tag of the answering modes extension - RFC 5373 8.
+const OptionTagAnswerMode = "answermode"
+
+// AnswerModeHeader represents Answer-Mode or Priv-Answer-Mode header - RFC 5373 7.
+type AnswerModeHeader struct {
+	// Priv-Answer-Mode if true, which asks to override the callee's privacy settings, e.g. do not disturb.
+	Private bool
+	// Requested answer mode, usually AnswerModeAuto or AnswerModeManual.
+	Mode string
+	// Require tells the UAS to reject the request rather than answer it in a different mode.
+	Require bool
+	// Any other parameters present in the header.
+	Params Params
+}
+
+func NewAnswerModeHeader(mode string, require bool) *AnswerModeHeader {
+	return &AnswerModeHeader{Mode: mode, Require: require, Params: NewParams()}
+}
+
+func NewPrivAnswerModeHeader(mode string, require bool) *AnswerModeHeader {
+	return &AnswerModeHeader{Private: true, Mode: mode, Require: require, Params: NewParams()}
+}
+
+// IsAuto reports whether the automatic answer is requested.
+func (header *AnswerModeHeader) IsAuto() bool {
+	return strings.EqualFold(header.Mode, AnswerModeAuto)
+}
+
+func (header *AnswerModeHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(header.Name())
+	buffer.WriteString(": ")
+	buffer.WriteString(header.Mode)
+	if header.Require {
+		buffer.WriteString(";require")
+	}
+	if header.Params != nil && header.Params.Length() > 0 {
+		buffer.WriteString(";")
+		buffer.WriteString(header.Params.ToString(';'))
+	}
+	return buffer.String()
+}
+
+func (header *AnswerModeHeader) Name() string {
+	if header.Private {
+		return "Priv-Answer-Mode"
+	}
+	return "Answer-Mode"
+}
+
+func (header *AnswerModeHeader) Copy() SipHeader {
+	return &AnswerModeHeader{header.Private, header.Mode, header.Require, copyWithNil(header.Params)}
+}
+
+// RequestedAnswerMode returns the answer mode requested by the INVITE.
+// Priv-Answer-Mode takes precedence over Answer-Mode - RFC 5373 5. Returns nil if none was requested.
+func RequestedAnswerMode(req *Request) *AnswerModeHeader {
+	for _, name := range []string{"Priv-Answer-Mode", "Answer-Mode"} {
+		for _, h := range req.Headers(name) {
+			if header, ok := h.(*AnswerModeHeader); ok {
+				return header
+			}
+		}
+	}
+	return nil
+}
+
+// AnswerModePolicy tells whether the UA agrees to answer the request in the requested mode.
+// Typically an intercom UA honours Auto from trusted callers only,
+// and Priv-Answer-Mode only from authorized ones - RFC 5373 9.
+type AnswerModePolicy func(req *Request, requested *AnswerModeHeader) bool
+
+// HandleAnswerMode applies the UA policy to the received INVITE.
+// It returns whether the UA should answer automatically, and the response rejecting the request
+// if the required mode can't be honoured - RFC 5373 6.2.
+// The UA should add Answer-Mode header with the actually used mode to the 2xx response.
+func HandleAnswerMode(req *Request, policy AnswerModePolicy) (auto bool, reject *Response) {
+	requested := RequestedAnswerMode(req)
+	if requested == nil {
+		return false, nil
+	}
+
+	honoured := policy != nil && policy(req, requested)
+	if honoured {
+		return requested.IsAuto(), nil
+	}
+	if requested.Require {
+		return false, NewResponseFromRequest(req, 403, "Forbidden", "")
+	}
+	return false, nil
+}
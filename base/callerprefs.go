@@ -0,0 +1,380 @@
+package base
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Callee capabilities - RFC 3840, caller preferences - RFC 3841.
+
+// Base media feature tags, Contact parameters without + prefix - RFC 3840 9, 10.
+// Other feature tags are the parameters with + prefix, e.g. ContactInstance or ContactSrc.
+const (
+	FeatureAudio       = "audio"
+	FeatureVideo       = "video"
+	FeatureText        = "text"
+	FeatureData        = "data"
+	FeatureControl     = "control"
+	FeatureApplication = "application"
+	FeatureType        = "type"
+	FeatureAutomata    = "automata"
+	FeatureClass       = "class"
+	FeatureDuplex      = "duplex"
+	FeatureMobility    = "mobility"
+	FeatureDescription = "description"
+	FeatureEvents      = "events"
+	FeaturePriority    = "priority"
+	FeatureMethods     = "methods"
+	FeatureExtensions  = "extensions"
+	FeatureSchemes     = "schemes"
+	FeatureActor       = "actor"
+	FeatureIsFocus     = "isfocus"
+	FeatureLanguage    = "language"
+)
+
+var baseFeatureTags = map[string]bool{
+	FeatureAudio: true, FeatureVideo: true, FeatureText: true, FeatureData: true, FeatureControl: true,
+	FeatureApplication: true, FeatureType: true, FeatureAutomata: true, FeatureClass: true, FeatureDuplex: true,
+	FeatureMobility: true, FeatureDescription: true, FeatureEvents: true, FeaturePriority: true, FeatureMethods: true,
+	FeatureExtensions: true, FeatureSchemes: true, FeatureActor: true, FeatureIsFocus: true, FeatureLanguage: true,
+}
+
+// IsFeatureTag reports whether the Contact parameter is a media feature tag, a base one or one with + prefix.
+func IsFeatureTag(name string) bool {
+	return strings.HasPrefix(name, "+") || baseFeatureTags[strings.ToLower(name)]
+}
+
+// Features maps the media feature tags, lower case, to their values: tokens, e.g. INVITE,
+// numbers and ranges, e.g. #>=2, and strings in angle brackets, e.g. <urn:uuid:...>.
+// Boolean tags have TRUE or FALSE value, the tags without values are TRUE.
+type Features map[string][]string
+
+// featuresOf collects the feature parameters.
+func featuresOf(params Params) Features {
+	features := make(Features)
+	if params == nil {
+		return features
+	}
+	for _, name := range params.Keys() {
+		if !IsFeatureTag(name) {
+			continue
+		}
+		value, _ := params.Get(name)
+		features[strings.ToLower(name)] = splitFeatureValues(value)
+	}
+	return features
+}
+
+// splitFeatureValues splits the quoted value list, e.g. "INVITE,BYE", commas in strings are kept.
+func splitFeatureValues(value MaybeString) []string {
+	if value == nil || value.String() == "" {
+		return []string{"TRUE"}
+	}
+	text := strings.Trim(value.String(), "\"")
+	values := make([]string, 0)
+	start, inString := 0, false
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '<':
+			inString = true
+		case '>':
+			inString = false
+		case ',':
+			if !inString {
+				values = append(values, strings.TrimSpace(text[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(values, strings.TrimSpace(text[start:]))
+}
+
+// setFeature adds the feature parameter, without value if no values are given.
+func setFeature(params Params, tag string, values []string) {
+	if len(values) == 0 {
+		params.Add(tag, NoString{})
+		return
+	}
+	params.Add(tag, QuotedString{S: strings.Join(values, ",")})
+}
+
+// Features returns the media feature tags of the contact - RFC 3840 9.
+func (contact *ContactHeader) Features() Features {
+	return featuresOf(contact.Params)
+}
+
+// HasFeature reports whether the contact has the feature tag, whatever its value.
+func (contact *ContactHeader) HasFeature(tag string) bool {
+	_, ok := contact.Features()[strings.ToLower(tag)]
+	return ok
+}
+
+// SetFeature adds the feature tag to the contact, without value for boolean true,
+// otherwise with the list of its values, e.g. SetFeature(FeatureMethods, "INVITE", "BYE").
+func (contact *ContactHeader) SetFeature(tag string, values ...string) {
+	if contact.Params == nil {
+		contact.Params = NewParams()
+	}
+	setFeature(contact.Params, tag, values)
+}
+
+// ContactPredicateHeader represents Accept-Contact or Reject-Contact header - RFC 3841 10.
+// The feature parameters are the caller preferences the contacts of the target are matched against,
+// see MatchCallerPreferences.
+type ContactPredicateHeader struct {
+	// Reject-Contact if true.
+	Reject bool
+	// Feature parameters of the predicate, see SetFeature.
+	Features Params
+	// Require discards the contacts not matching the predicate rather than preferring the matching ones, Accept-Contact only.
+	Require bool
+	// Explicit counts only the contacts declaring all the features of the predicate as matching, Accept-Contact only.
+	Explicit bool
+}
+
+func NewAcceptContactHeader(require bool, explicit bool) *ContactPredicateHeader {
+	return &ContactPredicateHeader{Features: NewParams(), Require: require, Explicit: explicit}
+}
+
+func NewRejectContactHeader() *ContactPredicateHeader {
+	return &ContactPredicateHeader{Reject: true, Features: NewParams()}
+}
+
+// SetFeature adds the feature parameter to the predicate, like ContactHeader.SetFeature.
+// Values prefixed by ! are negated, e.g. SetFeature(FeatureMethods, "!MESSAGE").
+func (header *ContactPredicateHeader) SetFeature(tag string, values ...string) *ContactPredicateHeader {
+	if header.Features == nil {
+		header.Features = NewParams()
+	}
+	setFeature(header.Features, tag, values)
+	return header
+}
+
+func (header *ContactPredicateHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(header.Name())
+	buffer.WriteString(": *")
+	if header.Features != nil && header.Features.Length() > 0 {
+		buffer.WriteString(";")
+		buffer.WriteString(header.Features.ToString(';'))
+	}
+	if header.Require {
+		buffer.WriteString(";require")
+	}
+	if header.Explicit {
+		buffer.WriteString(";explicit")
+	}
+	return buffer.String()
+}
+
+func (header *ContactPredicateHeader) Name() string {
+	if header.Reject {
+		return "Reject-Contact"
+	}
+	return "Accept-Contact"
+}
+
+func (header *ContactPredicateHeader) Copy() SipHeader {
+	return &ContactPredicateHeader{header.Reject, copyWithNil(header.Features), header.Require, header.Explicit}
+}
+
+// ContactPredicates returns the Accept-Contact predicates of the request, or Reject-Contact ones if reject is true.
+func ContactPredicates(req *Request, reject bool) []*ContactPredicateHeader {
+	name := "Accept-Contact"
+	if reject {
+		name = "Reject-Contact"
+	}
+	predicates := make([]*ContactPredicateHeader, 0)
+	for _, h := range req.Headers(name) {
+		if predicate, ok := h.(*ContactPredicateHeader); ok {
+			predicates = append(predicates, predicate)
+		}
+	}
+	return predicates
+}
+
+// MatchCallerPreferences applies the caller preferences of the request to the contacts of its target,
+// e.g. the bindings of the location service, and returns the contacts to try - RFC 3841 7.2:
+//   - the contacts matching a Reject-Contact predicate, declaring all of its features, are discarded;
+//   - the contacts not matching an Accept-Contact predicate with require are discarded,
+//     the rest are scored by the share of the features of each predicate they match, Qa is the average score;
+//   - the contacts declaring methods without the method of the request are discarded unless Accept-Contact
+//     predicates tell the methods, so are the contacts declaring events without the package of SUBSCRIBE.
+//
+// The contacts without feature tags are immune to the preferences, they are kept with Qa of 1.
+// The contacts are ordered by q-value, then by Qa, the order is kept otherwise.
+func MatchCallerPreferences(req *Request, contacts []*ContactHeader) []*ContactHeader {
+	type scored struct {
+		contact *ContactHeader
+		q, qa   float64
+	}
+	accepts, rejects := ContactPredicates(req, false), ContactPredicates(req, true)
+
+	matched := make([]scored, 0, len(contacts))
+contacts:
+	for _, contact := range contacts {
+		q, _ := contact.Q()
+		features := contact.Features()
+		if len(features) == 0 {
+			matched = append(matched, scored{contact, q, 1})
+			continue
+		}
+
+		for _, reject := range rejects {
+			if score, ok := matchPredicate(reject, features); ok && score == 1 {
+				continue contacts
+			}
+		}
+		if !matchImplicit(req, accepts, features) {
+			continue
+		}
+		qa := 1.0
+		if len(accepts) > 0 {
+			total := 0.0
+			for _, accept := range accepts {
+				score, ok := matchPredicate(accept, features)
+				if !ok || accept.Explicit && score < 1 {
+					if accept.Require {
+						continue contacts
+					}
+					score = 0
+				}
+				total += score
+			}
+			qa = total / float64(len(accepts))
+		}
+		matched = append(matched, scored{contact, q, qa})
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		if matched[i].q != matched[j].q {
+			return matched[i].q > matched[j].q
+		}
+		return matched[i].qa > matched[j].qa
+	})
+	result := make([]*ContactHeader, 0, len(matched))
+	for _, m := range matched {
+		result = append(result, m.contact)
+	}
+	return result
+}
+
+// matchPredicate matches the features against the predicate. ok is false if a feature declared by both doesn't match,
+// otherwise the score is the share of the features of the predicate declared, 1 for the predicate without features.
+func matchPredicate(predicate *ContactPredicateHeader, features Features) (score float64, ok bool) {
+	wanted := featuresOf(predicate.Features)
+	if len(wanted) == 0 {
+		return 1, true
+	}
+	declared := 0
+	for tag, terms := range wanted {
+		values, ok := features[tag]
+		if !ok {
+			continue
+		}
+		if !matchFeature(terms, values) {
+			return 0, false
+		}
+		declared++
+	}
+	return float64(declared) / float64(len(wanted)), true
+}
+
+// matchImplicit applies the implicit preferences for the method and the event package of the request,
+// unless the Accept-Contact predicates tell them.
+func matchImplicit(req *Request, accepts []*ContactPredicateHeader, features Features) bool {
+	told := func(tag string) bool {
+		for _, accept := range accepts {
+			if _, ok := featuresOf(accept.Features)[tag]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	if methods, ok := features[FeatureMethods]; ok && !told(FeatureMethods) &&
+		!matchFeature([]string{string(req.Method)}, methods) {
+		return false
+	}
+	if req.Method != SUBSCRIBE || told(FeatureEvents) {
+		return true
+	}
+	events, ok := features[FeatureEvents]
+	if !ok {
+		return true
+	}
+	for _, h := range req.Headers("Event") {
+		if event, ok := h.(*GenericHeader); ok {
+			pkg := strings.TrimSpace(strings.SplitN(event.Contents, ";", 2)[0])
+			if pkg != "" && !matchFeature([]string{pkg}, events) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchFeature reports whether any term of the predicate, possibly negated by ! prefix, matches any value.
+func matchFeature(terms []string, values []string) bool {
+	for _, term := range terms {
+		negated := strings.HasPrefix(term, "!")
+		term = strings.TrimPrefix(term, "!")
+		for _, value := range values {
+			if matchFeatureValue(term, value) != negated {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchFeatureValue compares the values: strings case-sensitively, numeric ranges by intersection,
+// tokens and booleans ignoring case.
+func matchFeatureValue(term string, value string) bool {
+	if strings.HasPrefix(term, "<") || strings.HasPrefix(value, "<") {
+		return term == value
+	}
+	if strings.HasPrefix(term, "#") || strings.HasPrefix(value, "#") {
+		termLow, termHigh, ok := numericRange(term)
+		if !ok {
+			return false
+		}
+		valueLow, valueHigh, ok := numericRange(value)
+		return ok && termLow <= valueHigh && valueLow <= termHigh
+	}
+	return strings.EqualFold(term, value)
+}
+
+// numericRange parses the numeric value, #=n, #<=n, #>=n or #n:m, into the range it covers.
+func numericRange(value string) (low float64, high float64, ok bool) {
+	if !strings.HasPrefix(value, "#") {
+		return 0, 0, false
+	}
+	value = value[1:]
+	parse := func(s string) (float64, bool) {
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		return n, err == nil
+	}
+	switch {
+	case strings.HasPrefix(value, "<="):
+		high, ok = parse(value[2:])
+		return math.Inf(-1), high, ok
+	case strings.HasPrefix(value, ">="):
+		low, ok = parse(value[2:])
+		return low, math.Inf(1), ok
+	case strings.HasPrefix(value, "="):
+		low, ok = parse(value[1:])
+		return low, low, ok
+	}
+	bounds := strings.SplitN(value, ":", 2)
+	if len(bounds) != 2 {
+		return 0, 0, false
+	}
+	if low, ok = parse(bounds[0]); !ok {
+		return 0, 0, false
+	}
+	high, ok = parse(bounds[1])
+	return low, high, ok && low <= high
+}
@@ -0,0 +1,117 @@
+package base
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/log"
+)
+
+func newFeatureContact(user string, q float64, features ...string) *ContactHeader {
+	contact := NewContactHeader(&SipUri{User: String{user}, Host: "192.0.2.1", UriParams: NewParams(), Headers: NewParams()})
+	if q != 1 {
+		contact.SetQ(q)
+	}
+	// Pairs of the tags and the values as parsed, empty for the tags without values.
+	for i := 0; i < len(features); i += 2 {
+		if features[i+1] == "" {
+			contact.Params.Add(features[i], NoString{})
+		} else {
+			contact.Params.Add(features[i], String{features[i+1]})
+		}
+	}
+	return contact
+}
+
+func TestContactFeatures(t *testing.T) {
+	contact := NewContactHeader(&SipUri{User: String{"alice"}, Host: "192.0.2.1", UriParams: NewParams(), Headers: NewParams()})
+	contact.SetFeature(FeatureAudio)
+	contact.SetFeature(FeatureMethods, "INVITE", "BYE")
+	contact.SetFeature(FeatureActor, "principal")
+	contact.SetFeature("+sip.newparam", "<a, b>", "#>=2")
+	contact.SetExpires(time.Minute)
+
+	expected := "Contact: <sip:alice@192.0.2.1>;audio;methods=\"INVITE,BYE\";actor=\"principal\";" +
+		"+sip.newparam=\"<a, b>,#>=2\";expires=60"
+	if contact.String() != expected {
+		t.Errorf("[FAIL] expected %q, got %q", expected, contact.String())
+	}
+	features := contact.Features()
+	if len(features) != 4 || features[FeatureAudio][0] != "TRUE" || len(features[FeatureMethods]) != 2 ||
+		features["+sip.newparam"][0] != "<a, b>" || features["+sip.newparam"][1] != "#>=2" {
+		t.Errorf("[FAIL] unexpected features %v", features)
+	}
+	if !contact.HasFeature("Audio") || contact.HasFeature(FeatureVideo) || contact.HasFeature(ContactExpires) {
+		t.Errorf("[FAIL] unexpected feature tags of %s", contact)
+	}
+
+	predicate := NewAcceptContactHeader(true, false).SetFeature(FeatureVideo).SetFeature(FeatureMethods, "!MESSAGE")
+	if predicate.String() != "Accept-Contact: *;video;methods=\"!MESSAGE\";require" {
+		t.Errorf("[FAIL] unexpected predicate %q", predicate)
+	}
+
+	for _, tc := range []struct {
+		term, value string
+		match       bool
+	}{
+		{"INVITE", "invite", true},
+		{"<Alice>", "<alice>", false},
+		{"#>=2", "#=3", true},
+		{"#<=1", "#2:5", false},
+		{"#2:5", "#>=4", true},
+		{"#=2", "2", false},
+		{"TRUE", "FALSE", false},
+	} {
+		if matchFeatureValue(tc.term, tc.value) != tc.match {
+			t.Errorf("[FAIL] expected match of %s against %s to be %v", tc.term, tc.value, tc.match)
+		}
+	}
+	if !matchFeature([]string{"!MESSAGE"}, []string{"MESSAGE", "INVITE"}) || matchFeature([]string{"!MESSAGE"}, []string{"MESSAGE"}) {
+		t.Errorf("[FAIL] unexpected match of negated value")
+	}
+}
+
+func TestMatchCallerPreferences(t *testing.T) {
+	uri := &SipUri{User: String{"bob"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	invite := NewRequest(INVITE, uri, "SIP/2.0", []SipHeader{
+		NewAcceptContactHeader(true, false).SetFeature(FeatureAudio),
+		NewAcceptContactHeader(false, false).SetFeature(FeatureVideo),
+		NewRejectContactHeader().SetFeature(FeatureActor, "msg-taker"),
+	}, "", log.StandardLogger())
+
+	audio := newFeatureContact("audio", 1, FeatureAudio, "", FeatureMethods, "INVITE,BYE")
+	video := newFeatureContact("video", 1, FeatureAudio, "", FeatureVideo, "")
+	immune := newFeatureContact("immune", 1)
+	messaging := newFeatureContact("messaging", 1, FeatureMethods, "MESSAGE")
+	voicemail := newFeatureContact("voicemail", 1, FeatureAudio, "", FeatureActor, "msg-taker")
+	mute := newFeatureContact("mute", 1, FeatureAudio, "FALSE", FeatureVideo, "")
+	backup := newFeatureContact("backup", 0.5, FeatureAudio, "", FeatureVideo, "")
+
+	targets := MatchCallerPreferences(invite, []*ContactHeader{backup, audio, video, immune, messaging, voicemail, mute})
+	expected := []*ContactHeader{video, immune, audio, backup}
+	if len(targets) != len(expected) {
+		t.Fatalf("[FAIL] expected %d targets, got %d: %v", len(expected), len(targets), targets)
+	}
+	for i, contact := range expected {
+		if targets[i] != contact {
+			t.Errorf("[FAIL] expected target %d to be %s, got %s", i, contact, targets[i])
+		}
+	}
+
+	// Explicit predicate requires the contacts to declare all its features.
+	explicit := NewRequest(INVITE, uri, "SIP/2.0", []SipHeader{
+		NewAcceptContactHeader(true, true).SetFeature(FeatureAudio).SetFeature(FeatureVideo),
+	}, "", log.StandardLogger())
+	if targets := MatchCallerPreferences(explicit, []*ContactHeader{audio, video, immune}); len(targets) != 2 ||
+		targets[0] != video || targets[1] != immune {
+		t.Errorf("[FAIL] unexpected targets of explicit predicate %v", targets)
+	}
+
+	// Implicit preference of the event package of SUBSCRIBE.
+	presence := newFeatureContact("presence", 1, FeatureEvents, "presence,dialog")
+	subscribe := NewRequest(SUBSCRIBE, uri, "SIP/2.0", []SipHeader{&GenericHeader{HeaderName: "Event", Contents: "message-summary"}},
+		"", log.StandardLogger())
+	if targets := MatchCallerPreferences(subscribe, []*ContactHeader{presence, immune}); len(targets) != 1 || targets[0] != immune {
+		t.Errorf("[FAIL] unexpected targets of SUBSCRIBE %v", targets)
+	}
+}
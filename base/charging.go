@@ -0,0 +1,142 @@
+package base
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ghettovoice/gossip/utils"
+)
+
+// IMS charging correlation headers - RFC 7315 4.6, 4.5.
+
+// GenerateIcid returns a new IMS Charging Identity, unique in time and across hosts.
+func GenerateIcid() string {
+	return fmt.Sprintf("%x%s", time.Now().UnixNano(), utils.RandStr(8))
+}
+
+// ChargingVectorHeader represents P-Charging-Vector header - RFC 7315 4.6.
+type ChargingVectorHeader struct {
+	// IMS Charging Identity, the only mandatory part of the header.
+	Icid string
+
+	// Any other parameters present in the header, e.g. icid-generated-at, orig-ioi and term-ioi.
+	Params Params
+}
+
+// NewChargingVectorHeader builds P-Charging-Vector header with a new icid-value
+// generated by the element with the given address.
+func NewChargingVectorHeader(generatedAt string) *ChargingVectorHeader {
+	header := &ChargingVectorHeader{Icid: GenerateIcid(), Params: NewParams()}
+	if generatedAt != "" {
+		header.Params = withParam(header.Params, "icid-generated-at", generatedAt)
+	}
+	return header
+}
+
+// WithOrigIoi sets the originating Inter Operator Identifier.
+func (header *ChargingVectorHeader) WithOrigIoi(ioi string) *ChargingVectorHeader {
+	header.Params = withParam(header.Params, "orig-ioi", ioi)
+	return header
+}
+
+// WithTermIoi sets the terminating Inter Operator Identifier.
+func (header *ChargingVectorHeader) WithTermIoi(ioi string) *ChargingVectorHeader {
+	header.Params = withParam(header.Params, "term-ioi", ioi)
+	return header
+}
+
+// GeneratedAt returns the value of icid-generated-at parameter or empty string.
+func (header *ChargingVectorHeader) GeneratedAt() string {
+	return paramValue(header.Params, "icid-generated-at")
+}
+
+// OrigIoi returns the value of orig-ioi parameter or empty string.
+func (header *ChargingVectorHeader) OrigIoi() string {
+	return paramValue(header.Params, "orig-ioi")
+}
+
+// TermIoi returns the value of term-ioi parameter or empty string.
+func (header *ChargingVectorHeader) TermIoi() string {
+	return paramValue(header.Params, "term-ioi")
+}
+
+func (header *ChargingVectorHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("P-Charging-Vector: icid-value=")
+	buffer.WriteString(header.Icid)
+	if header.Params != nil && header.Params.Length() > 0 {
+		buffer.WriteString(";")
+		buffer.WriteString(header.Params.ToString(';'))
+	}
+	return buffer.String()
+}
+
+func (header *ChargingVectorHeader) Name() string { return "P-Charging-Vector" }
+
+func (header *ChargingVectorHeader) Copy() SipHeader {
+	return &ChargingVectorHeader{header.Icid, copyWithNil(header.Params)}
+}
+
+// ChargingFunctionAddressesHeader represents P-Charging-Function-Addresses header - RFC 7315 4.5.
+// Addresses are listed in order of preference and may be repeated, so they are not kept in Params.
+type ChargingFunctionAddressesHeader struct {
+	// Charging Collection Function addresses.
+	Ccf []string
+	// Event Charging Function addresses.
+	Ecf []string
+
+	// Any extension parameters present in the header.
+	Params Params
+}
+
+func (header *ChargingFunctionAddressesHeader) String() string {
+	parts := make([]string, 0, len(header.Ccf)+len(header.Ecf)+1)
+	for _, addr := range header.Ccf {
+		parts = append(parts, "ccf="+quoteChargingAddr(addr))
+	}
+	for _, addr := range header.Ecf {
+		parts = append(parts, "ecf="+quoteChargingAddr(addr))
+	}
+	if header.Params != nil && header.Params.Length() > 0 {
+		parts = append(parts, header.Params.ToString(';'))
+	}
+	return "P-Charging-Function-Addresses: " + strings.Join(parts, ";")
+}
+
+func (header *ChargingFunctionAddressesHeader) Name() string { return "P-Charging-Function-Addresses" }
+
+func (header *ChargingFunctionAddressesHeader) Copy() SipHeader {
+	return &ChargingFunctionAddressesHeader{
+		append([]string(nil), header.Ccf...),
+		append([]string(nil), header.Ecf...),
+		copyWithNil(header.Params),
+	}
+}
+
+// IPv6 references contain colons and have to be sent as quoted strings - RFC 7315 4.5.
+func quoteChargingAddr(addr string) string {
+	if strings.Contains(addr, ":") && !strings.HasPrefix(addr, "\"") {
+		return "\"" + addr + "\""
+	}
+	return addr
+}
+
+// ChargingVector returns P-Charging-Vector header of the message or nil.
+func ChargingVector(msg SipMessage) *ChargingVectorHeader {
+	for _, h := range msg.Headers("P-Charging-Vector") {
+		if header, ok := h.(*ChargingVectorHeader); ok {
+			return header
+		}
+	}
+	return nil
+}
+
+// CopyChargingVector copies P-Charging-Vector header from the request to the response,
+// so that the charging records of both sides can be correlated by the icid-value.
+func CopyChargingVector(req *Request, res *Response) {
+	if header := ChargingVector(req); header != nil {
+		res.SetHeader(header.Copy(), true)
+	}
+}
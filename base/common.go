@@ -1,17 +1,48 @@
 package base
 
 import (
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/ghettovoice/gossip/utils"
 )
 
 const RFC3261BranchMagicCookie = "z9hG4bK"
 
+// idSalt is a process-unique prefix of generated branches and tags,
+// so that identifiers generated after a restart can't collide with the ones of the previous run.
+var idSalt = newIdSalt()
+
+// newIdSalt combines the process start time with a random part.
+func newIdSalt() string {
+	return fmt.Sprintf("%x%s", time.Now().Unix(), utils.RandStr(4))
+}
+
+// IdSalt returns the process-unique salt of generated branches and tags.
+func IdSalt() string {
+	return idSalt
+}
+
+// SetIdSalt overrides the salt of generated branches and tags, e.g. with a persistent instance identifier.
+// Empty salt generates a new one. Should be called before the stack starts generating identifiers.
+func SetIdSalt(salt string) {
+	if salt == "" {
+		salt = newIdSalt()
+	}
+	idSalt = salt
+}
+
 // GenerateBranch returns random unique branch ID.
 func GenerateBranch() string {
 	return strings.Join([]string{
 		RFC3261BranchMagicCookie,
-		utils.RandStr(16),
+		idSalt,
+		utils.RandStr(8),
 	}, "")
 }
+
+// GenerateTag returns random unique From or To tag - RFC 3261 19.3.
+func GenerateTag() string {
+	return idSalt + utils.RandStr(4)
+}
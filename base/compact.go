@@ -0,0 +1,88 @@
+package base
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Compact header forms - RFC 3261 7.3.3 and the IANA SIP header fields registry.
+var compactHeaderNames = map[string]string{
+	"a": "Accept-Contact",
+	"b": "Referred-By",
+	"c": "Content-Type",
+	"d": "Request-Disposition",
+	"e": "Content-Encoding",
+	"f": "From",
+	"i": "Call-ID",
+	"j": "Reject-Contact",
+	"k": "Supported",
+	"l": "Content-Length",
+	"m": "Contact",
+	"n": "Identity-Info",
+	"o": "Event",
+	"r": "Refer-To",
+	"s": "Subject",
+	"t": "To",
+	"u": "Allow-Events",
+	"v": "Via",
+	"x": "Session-Expires",
+	"y": "Identity",
+}
+
+// fullHeaderNames maps the lower case full names to the compact ones.
+var fullHeaderNames = func() map[string]string {
+	names := make(map[string]string, len(compactHeaderNames))
+	for compact, full := range compactHeaderNames {
+		names[strings.ToLower(full)] = compact
+	}
+	return names
+}()
+
+// ExpandHeaderName returns the full name of the compact header name, e.g. Call-ID of i,
+// other names are returned as is.
+func ExpandHeaderName(name string) string {
+	if full, ok := compactHeaderNames[strings.ToLower(name)]; ok {
+		return full
+	}
+	return name
+}
+
+// CompactHeaderName returns the compact name of the header, e.g. v of Via, false if it has none.
+func CompactHeaderName(name string) (string, bool) {
+	compact, ok := fullHeaderNames[strings.ToLower(ExpandHeaderName(name))]
+	return compact, ok
+}
+
+// headerKey is the key of the header in the message, the same for the full and the compact name.
+func headerKey(name string) string {
+	return strings.ToLower(ExpandHeaderName(name))
+}
+
+// compactHeader renders the header using the compact name if it has one.
+func compactHeader(h SipHeader) string {
+	text := h.String()
+	compact, ok := CompactHeaderName(h.Name())
+	if !ok {
+		return text
+	}
+	idx := strings.Index(text, ":")
+	if idx < 0 {
+		return text
+	}
+	var buffer bytes.Buffer
+	buffer.WriteString(compact)
+	buffer.WriteString(":")
+	buffer.WriteString(text[idx+1:])
+	return buffer.String()
+}
+
+// SetCompactForm makes String render the headers in the compact form, e.g. to keep requests sent over UDP
+// below the path MTU - RFC 3261 18.1.1.
+func (msg *message) SetCompactForm(compact bool) {
+	msg.compact = compact
+}
+
+// CompactForm reports whether String renders the headers in the compact form.
+func (msg *message) CompactForm() bool {
+	return msg.compact
+}
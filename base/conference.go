@@ -0,0 +1,113 @@
+package base
+
+import (
+	"fmt"
+
+	"github.com/ghettovoice/gossip/utils"
+)
+
+// Conferencing for user agents - RFC 4579.
+
+// IsFocus reports whether the contact has isfocus feature tag, i.e. it's the conference URI of a focus - RFC 4579 3.
+func (contact *ContactHeader) IsFocus() bool {
+	return contact.HasFeature(FeatureIsFocus)
+}
+
+// SetFocus adds isfocus feature tag, the focus advertises it in Contact of the conference URI - RFC 4579 5.3.
+func (contact *ContactHeader) SetFocus() {
+	contact.SetFeature(FeatureIsFocus)
+}
+
+// NewConferenceUri generates the URI of a new ad-hoc conference of the focus at the host, unique and hard to guess
+// so the conference isn't joined by chance - RFC 4579 5.2.
+func NewConferenceUri(host string, port uint16) *SipUri {
+	uri := &SipUri{
+		User:      String{S: utils.RandStr(8, "conf-")},
+		Password:  NoString{},
+		Host:      host,
+		UriParams: NewParams(),
+		Headers:   NewParams(),
+	}
+	if port != 0 {
+		uri.Port = &port
+	}
+	return uri
+}
+
+// ConferenceAction is what REFER to the conference URI asks the focus to do with the participant.
+type ConferenceAction int
+
+const (
+	// ConferenceAdd invites the participant to the conference - RFC 4579 5.5.
+	ConferenceAdd ConferenceAction = iota
+	// ConferenceRemove disconnects the participant from the conference by BYE - RFC 4579 5.6.
+	ConferenceRemove
+)
+
+func (action ConferenceAction) String() string {
+	switch action {
+	case ConferenceAdd:
+		return "add"
+	case ConferenceRemove:
+		return "remove"
+	default:
+		return "unknown"
+	}
+}
+
+// NewConferenceRefer builds REFER to the conference URI asking the focus to add the participant or to remove it.
+// The request is sent outside of a dialog, see NewReferToParticipant for the other way of adding the participant.
+func NewConferenceRefer(conference *SipUri, participant Uri, action ConferenceAction, hdrs ...SipHeader) *Request {
+	target := participant.Copy()
+	if action == ConferenceRemove {
+		if uri, ok := target.(*SipUri); ok {
+			if uri.UriParams == nil {
+				uri.UriParams = NewParams()
+			}
+			uri.UriParams.Add("method", String{S: string(BYE)})
+		}
+	}
+	hdrs = append([]SipHeader{NewReferToHeader(target)}, hdrs...)
+	return NewRequest(REFER, conference.Copy(), "SIP/2.0", hdrs, "", nil)
+}
+
+// ConferenceRefer tells what REFER received by the focus asks to do - RFC 4579 5.5, 5.6:
+// Refer-To of INVITE adds the participant, of BYE removes it. Other methods are not supported.
+func ConferenceRefer(req *Request) (participant Uri, action ConferenceAction, err error) {
+	if req.Method != REFER {
+		return nil, 0, fmt.Errorf("request %s is not REFER", req.Short())
+	}
+	referTo, err := ReferTo(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch referTo.Method() {
+	case INVITE:
+		action = ConferenceAdd
+	case BYE:
+		action = ConferenceRemove
+	default:
+		return nil, 0, fmt.Errorf("method %s of Refer-To %s is not supported by the focus", referTo.Method(), referTo.Address)
+	}
+	participant = referTo.Address.Copy()
+	if uri, ok := participant.(*SipUri); ok && uri.UriParams != nil {
+		uri.UriParams.Remove("method")
+	}
+	return participant, action, nil
+}
+
+// IsConferenceReferral reports whether REFER asks the recipient to join the conference, i.e. its Refer-To
+// is the conference URI of the focus, as advertised by isfocus in Contact of the dialog with it - RFC 4579 5.4.
+// The conferences are recognized by the URIs known to the application, e.g. from the Contacts with isfocus.
+func IsConferenceReferral(req *Request, conferences ...Uri) bool {
+	referTo, err := ReferTo(req)
+	if err != nil || referTo.Method() != INVITE {
+		return false
+	}
+	for _, conference := range conferences {
+		if conference.Equals(referTo.Address) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package base
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConferenceFocus(t *testing.T) {
+	conference := NewConferenceUri("focus.example.com", 5070)
+	user, _ := conference.User.(String)
+	if !strings.HasPrefix(user.S, "conf-") || len(user.S) != len("conf-")+16 {
+		t.Errorf("[FAIL] unexpected conference user %q", user.S)
+	}
+	if other := NewConferenceUri("focus.example.com", 5070); other.Equals(conference) {
+		t.Errorf("[FAIL] expected unique conference URIs, got %s twice", conference)
+	}
+
+	contact := NewContactHeader(conference)
+	if contact.IsFocus() {
+		t.Errorf("[FAIL] contact %s is not a focus", contact)
+	}
+	contact.SetFocus()
+	if !contact.IsFocus() || !strings.HasSuffix(contact.String(), ">;isfocus") {
+		t.Errorf("[FAIL] expected isfocus in %s", contact)
+	}
+}
+
+func TestConferenceRefer(t *testing.T) {
+	conference := &SipUri{User: String{"conf-1"}, Host: "focus.example.com", UriParams: NewParams(), Headers: NewParams()}
+	bob := &SipUri{User: String{"bob"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+
+	for _, action := range []ConferenceAction{ConferenceAdd, ConferenceRemove} {
+		req := NewConferenceRefer(conference, bob, action)
+		if req.Method != REFER || !req.Recipient.Equals(conference) {
+			t.Errorf("[FAIL] unexpected request %s", req.Short())
+		}
+		participant, got, err := ConferenceRefer(req)
+		if err != nil {
+			t.Errorf("[FAIL] %s REFER: %s", action, err)
+			continue
+		}
+		if got != action || !participant.Equals(bob) {
+			t.Errorf("[FAIL] expected %s of %s, got %s of %s", action, bob, got, participant)
+		}
+	}
+	if referTo, _ := ReferTo(NewConferenceRefer(conference, bob, ConferenceRemove)); referTo.String() != "Refer-To: <sip:bob@example.com;method=BYE>" {
+		t.Errorf("[FAIL] unexpected %s", referTo)
+	}
+	if len(bob.UriParams.Keys()) != 0 {
+		t.Errorf("[FAIL] participant URI %s modified", bob)
+	}
+
+	req := NewConferenceRefer(conference, bob, ConferenceAdd)
+	referTo, _ := ReferTo(req)
+	referTo.Address.(*SipUri).UriParams.Add("method", String{"MESSAGE"})
+	if _, _, err := ConferenceRefer(req); err == nil {
+		t.Errorf("[FAIL] expected MESSAGE referral rejected")
+	}
+	req.AddHeader(NewReferToHeader(bob))
+	if _, _, err := ConferenceRefer(req); err == nil {
+		t.Errorf("[FAIL] expected REFER with two Refer-To headers rejected")
+	}
+	if _, _, err := ConferenceRefer(NewRequest(INVITE, conference, "SIP/2.0", nil, "", nil)); err == nil {
+		t.Errorf("[FAIL] expected INVITE rejected")
+	}
+
+	// The participant is referred to the conference it's invited to.
+	invite := NewRequest(REFER, bob, "SIP/2.0", []SipHeader{NewReferToHeader(conference.Copy())}, "", nil)
+	if !IsConferenceReferral(invite, conference) {
+		t.Errorf("[FAIL] expected referral to %s", conference)
+	}
+	if IsConferenceReferral(invite, bob) {
+		t.Errorf("[FAIL] unexpected referral to %s", bob)
+	}
+}
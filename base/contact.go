@@ -0,0 +1,159 @@
+package base
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parameters of Contact header - RFC 3261 20.10, RFC 5626 4.1.
+const (
+	ContactQ        = "q"
+	ContactExpires  = "expires"
+	ContactInstance = "+sip.instance"
+	ContactRegId    = "reg-id"
+)
+
+// NewContactHeader creates Contact header of the URI without display name and parameters.
+func NewContactHeader(uri ContactUri) *ContactHeader {
+	return &ContactHeader{DisplayName: NoString{}, Address: uri, Params: NewParams()}
+}
+
+// NewWildcardContact creates 'Contact: *' header removing all bindings of REGISTER request - RFC 3261 10.2.2.
+func NewWildcardContact() *ContactHeader {
+	return &ContactHeader{DisplayName: NoString{}, Address: &WildcardUri{}, Params: NewParams()}
+}
+
+// IsWildcard reports whether the header is 'Contact: *'.
+func (contact *ContactHeader) IsWildcard() bool {
+	return contact.Address != nil && contact.Address.IsWildcard()
+}
+
+// param returns the value of the parameter, false if the parameter is absent or has no value.
+func (contact *ContactHeader) param(name string) (string, bool) {
+	if contact.Params == nil {
+		return "", false
+	}
+	value, ok := contact.Params.Get(name)
+	if !ok {
+		return "", false
+	}
+	if s, ok := value.(String); ok {
+		return s.S, true
+	}
+	return "", false
+}
+
+func (contact *ContactHeader) setParam(name string, value string) {
+	if contact.Params == nil {
+		contact.Params = NewParams()
+	}
+	contact.Params.Add(name, String{S: value})
+}
+
+// Q returns the preference of the contact from 0 to 1 - RFC 3261 20.10.
+// Contacts without valid q-value have the preference of 1, ok is false then.
+func (contact *ContactHeader) Q() (q float64, ok bool) {
+	value, ok := contact.param(ContactQ)
+	if !ok {
+		return 1, false
+	}
+	q, err := strconv.ParseFloat(value, 64)
+	if err != nil || q < 0 || q > 1 {
+		return 1, false
+	}
+	return q, true
+}
+
+// SetQ sets q-value, it is rounded to 3 decimals and clamped to 0..1 - RFC 3261 25.1.
+func (contact *ContactHeader) SetQ(q float64) {
+	q = math.Max(0, math.Min(1, math.Round(q*1000)/1000))
+	contact.setParam(ContactQ, strconv.FormatFloat(q, 'f', -1, 64))
+}
+
+// Expires returns the expiration interval of the contact, false if it has no valid expires parameter.
+func (contact *ContactHeader) Expires() (time.Duration, bool) {
+	value, ok := contact.param(ContactExpires)
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// SetExpires sets expires parameter in seconds, rounded down.
+func (contact *ContactHeader) SetExpires(expires time.Duration) {
+	contact.setParam(ContactExpires, strconv.Itoa(int(expires/time.Second)))
+}
+
+// Instance returns the instance ID of the user agent without quotes, e.g. <urn:uuid:00000000-0000-1000-8000-000A95A0E128>,
+// false if there is no +sip.instance parameter - RFC 5626 4.1.
+func (contact *ContactHeader) Instance() (string, bool) {
+	value, ok := contact.param(ContactInstance)
+	if !ok {
+		return "", false
+	}
+	return strings.Trim(value, "\""), true
+}
+
+// SetInstance sets +sip.instance parameter of the instance ID, e.g. a URN in angle brackets.
+func (contact *ContactHeader) SetInstance(instance string) {
+	contact.setParam(ContactInstance, instance)
+}
+
+// RegId returns the registration flow ID, false if there is no valid reg-id parameter - RFC 5626 4.2.
+func (contact *ContactHeader) RegId() (int, bool) {
+	value, ok := contact.param(ContactRegId)
+	if !ok {
+		return 0, false
+	}
+	regId, err := strconv.Atoi(value)
+	if err != nil || regId <= 0 {
+		return 0, false
+	}
+	return regId, true
+}
+
+// Contacts returns the Contact headers of the message in order,
+// several contacts listed in one header line are returned one by one.
+func (msg *message) Contacts() []*ContactHeader {
+	contacts := make([]*ContactHeader, 0)
+	for _, h := range msg.Headers("Contact") {
+		if contact, ok := h.(*ContactHeader); ok {
+			contacts = append(contacts, contact)
+		}
+	}
+	return contacts
+}
+
+// CheckWildcardContact verifies the request using 'Contact: *' removes all bindings properly:
+// the wildcard is the only contact and Expires is 0 - RFC 3261 10.3 item 6.
+// The error is of ErrMalformedMessage kind, the request should be answered with 400 Bad Request.
+func CheckWildcardContact(req *Request) error {
+	contacts := req.Contacts()
+	wildcard := false
+	for _, contact := range contacts {
+		wildcard = wildcard || contact.IsWildcard()
+	}
+	if !wildcard {
+		return nil
+	}
+	if len(contacts) > 1 {
+		return NewError(ErrMalformedMessage, nil, "wildcard contact is not the only contact of %s", req.Short())
+	}
+
+	for _, h := range req.Headers("Expires") {
+		text := h.String()
+		if idx := strings.Index(text, ":"); idx >= 0 {
+			text = text[idx+1:]
+		}
+		if strings.TrimSpace(text) == "0" {
+			return nil
+		}
+	}
+	return NewError(ErrMalformedMessage, nil, "wildcard contact of %s requires Expires: 0", req.Short())
+}
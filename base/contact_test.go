@@ -0,0 +1,79 @@
+package base
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/log"
+)
+
+func TestContactParams(t *testing.T) {
+	contact := NewContactHeader(&SipUri{User: String{"alice"}, Host: "10.0.0.1", UriParams: NewParams(), Headers: NewParams()})
+	if q, ok := contact.Q(); ok || q != 1 {
+		t.Errorf("[FAIL] expected default q-value 1, got %v", q)
+	}
+	if _, ok := contact.Expires(); ok {
+		t.Errorf("[FAIL] unexpected expires")
+	}
+
+	contact.SetQ(0.71234)
+	contact.SetExpires(3600 * time.Second)
+	contact.SetInstance("<urn:uuid:00000000-0000-1000-8000-000A95A0E128>")
+	contact.Params.Add(ContactRegId, String{"1"})
+
+	if q, ok := contact.Q(); !ok || q != 0.712 {
+		t.Errorf("[FAIL] expected q-value 0.712, got %v", q)
+	}
+	if expires, ok := contact.Expires(); !ok || expires != time.Hour {
+		t.Errorf("[FAIL] expected expires 1h, got %v", expires)
+	}
+	if instance, ok := contact.Instance(); !ok || instance != "<urn:uuid:00000000-0000-1000-8000-000A95A0E128>" {
+		t.Errorf("[FAIL] unexpected instance %s", instance)
+	}
+	if regId, ok := contact.RegId(); !ok || regId != 1 {
+		t.Errorf("[FAIL] expected reg-id 1, got %d", regId)
+	}
+
+	expected := "Contact: <sip:alice@10.0.0.1>;q=0.712;expires=3600;" +
+		"+sip.instance=\"<urn:uuid:00000000-0000-1000-8000-000A95A0E128>\";reg-id=1"
+	if contact.String() != expected {
+		t.Errorf("[FAIL] expected %s, got %s", expected, contact.String())
+	}
+
+	contact.SetQ(2)
+	if q, _ := contact.Q(); q != 1 {
+		t.Errorf("[FAIL] expected q-value clamped to 1, got %v", q)
+	}
+}
+
+func TestCheckWildcardContact(t *testing.T) {
+	uri := &SipUri{User: String{"alice"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	register := func(hdrs ...SipHeader) *Request {
+		return NewRequest(REGISTER, uri, "SIP/2.0", hdrs, "", log.StandardLogger())
+	}
+	contact := NewContactHeader(uri)
+
+	if err := CheckWildcardContact(register(contact)); err != nil {
+		t.Errorf("[FAIL] unexpected error: %s", err)
+	}
+	if err := CheckWildcardContact(register(NewWildcardContact(), &GenericHeader{"Expires", "0"})); err != nil {
+		t.Errorf("[FAIL] unexpected error: %s", err)
+	}
+	for _, req := range []*Request{
+		register(NewWildcardContact()),
+		register(NewWildcardContact(), &GenericHeader{"Expires", "3600"}),
+		register(NewWildcardContact(), contact, &GenericHeader{"Expires", "0"}),
+	} {
+		if err := CheckWildcardContact(req); !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("[FAIL] expected malformed message error on\n%s\ngot %v", req, err)
+		}
+	}
+
+	if got := NewWildcardContact().String(); got != "Contact: *" {
+		t.Errorf("[FAIL] expected 'Contact: *', got %s", got)
+	}
+	if got := (&ContactHeader{DisplayName: NoString{}, Address: WildcardUri{}, Params: NewParams()}).String(); got != "Contact: *" {
+		t.Errorf("[FAIL] expected 'Contact: *', got %s", got)
+	}
+}
@@ -0,0 +1,84 @@
+package base
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// MaxCSeq is the largest permitted CSeq number - RFC 3261 8.1.1.5.
+const MaxCSeq = 1<<31 - 1
+
+// NewCSeqNumber returns a random initial CSeq number - RFC 3261 8.1.1.5.
+// It's picked from the lower half of the permitted range, leaving room for 2^30 requests.
+func NewCSeqNumber() uint32 {
+	return uint32(rand.Int31n(1<<30)) + 1
+}
+
+// CSeqSequence tracks local and remote CSeq numbers of a dialog or another sequence of requests
+// sharing Call-Id - RFC 3261 12.2.1.1, 12.2.2.
+// Violations are reported as errors of ErrInvalidCSeq kind.
+type CSeqSequence struct {
+	local     uint32
+	remote    uint32
+	hasRemote bool
+	lock      sync.Mutex
+}
+
+// NewCSeqSequence creates the sequence starting local requests from the number, 0 picks a random one.
+func NewCSeqSequence(start uint32) *CSeqSequence {
+	if start == 0 {
+		start = NewCSeqNumber()
+	}
+	return &CSeqSequence{local: start - 1}
+}
+
+// Next returns the number of the next local request.
+// When the numbers are exhausted the sequence can't be continued, e.g. the dialog should be terminated
+// or the registration continued with a new Call-Id.
+func (seq *CSeqSequence) Next() (uint32, error) {
+	seq.lock.Lock()
+	defer seq.lock.Unlock()
+
+	if seq.local >= MaxCSeq {
+		return 0, NewError(ErrInvalidCSeq, nil, "local CSeq %d reached maximum %d", seq.local, MaxCSeq)
+	}
+	seq.local++
+	return seq.local, nil
+}
+
+// Local returns the number of the last local request, it's used by ACK and CANCEL requests.
+func (seq *CSeqSequence) Local() uint32 {
+	seq.lock.Lock()
+	defer seq.lock.Unlock()
+	return seq.local
+}
+
+// Remote returns the number of the last accepted remote request, false if none was accepted yet.
+func (seq *CSeqSequence) Remote() (uint32, bool) {
+	seq.lock.Lock()
+	defer seq.lock.Unlock()
+	return seq.remote, seq.hasRemote
+}
+
+// ReceiveRemote validates the CSeq of the remote request and remembers it.
+// Numbers lower than the last one, or equal for methods other than ACK and CANCEL,
+// are out of order and should be answered with 500 Server Internal Error - RFC 3261 12.2.2.
+func (seq *CSeqSequence) ReceiveRemote(cseq *CSeq) error {
+	seq.lock.Lock()
+	defer seq.lock.Unlock()
+
+	if cseq.SeqNo > MaxCSeq {
+		return NewError(ErrInvalidCSeq, nil, "remote CSeq %d exceeds maximum %d", cseq.SeqNo, MaxCSeq)
+	}
+	if seq.hasRemote {
+		inOrder := cseq.SeqNo > seq.remote ||
+			(cseq.SeqNo == seq.remote && (cseq.MethodName == ACK || cseq.MethodName == CANCEL))
+		if !inOrder {
+			return NewError(ErrInvalidCSeq, nil, "remote CSeq %d is out of order, last was %d", cseq.SeqNo, seq.remote)
+		}
+	}
+
+	seq.remote = cseq.SeqNo
+	seq.hasRemote = true
+	return nil
+}
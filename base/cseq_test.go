@@ -0,0 +1,49 @@
+package base
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCSeqSequence(t *testing.T) {
+	if start := NewCSeqNumber(); start == 0 || start > MaxCSeq/2+1 {
+		t.Errorf("[FAIL] random initial CSeq %d out of range", start)
+	}
+
+	seq := NewCSeqSequence(MaxCSeq - 1)
+	if next, err := seq.Next(); err != nil || next != MaxCSeq-1 {
+		t.Errorf("[FAIL] expected first CSeq %d, got %d (%v)", MaxCSeq-1, next, err)
+	}
+	seq.Next()
+	if _, err := seq.Next(); !errors.Is(err, ErrInvalidCSeq) {
+		t.Errorf("[FAIL] expected CSeq overflow error, got %v", err)
+	}
+	if seq.Local() != MaxCSeq {
+		t.Errorf("[FAIL] overflow changed local CSeq to %d", seq.Local())
+	}
+
+	tests := []struct {
+		cseq CSeq
+		ok   bool
+	}{
+		{CSeq{10, INVITE}, true},
+		{CSeq{10, ACK}, true},
+		{CSeq{10, CANCEL}, true},
+		{CSeq{10, BYE}, false},
+		{CSeq{9, BYE}, false},
+		{CSeq{11, BYE}, true},
+		{CSeq{MaxCSeq + 1, BYE}, false},
+	}
+	for _, test := range tests {
+		err := seq.ReceiveRemote(&test.cseq)
+		if test.ok && err != nil {
+			t.Errorf("[FAIL] unexpected error on remote %s: %s", test.cseq.String(), err)
+		}
+		if !test.ok && !errors.Is(err, ErrInvalidCSeq) {
+			t.Errorf("[FAIL] expected %s to be rejected, got %v", test.cseq.String(), err)
+		}
+	}
+	if remote, ok := seq.Remote(); !ok || remote != 11 {
+		t.Errorf("[FAIL] expected last remote CSeq 11, got %d", remote)
+	}
+}
@@ -0,0 +1,141 @@
+package base
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DialogId identifies a dialog from the point of view of one of its participants - RFC 3261 12.
+type DialogId struct {
+	CallId    string
+	LocalTag  string
+	RemoteTag string
+}
+
+func (id DialogId) String() string {
+	return fmt.Sprintf("%s;local-tag=%s;remote-tag=%s", id.CallId, id.LocalTag, id.RemoteTag)
+}
+
+// DialogLookup is implemented by dialog stores able to tell whether a dialog exists.
+type DialogLookup interface {
+	HasDialog(id DialogId) bool
+}
+
+// Option tag of Target-Dialog extension - RFC 4538 6.
+const OptionTagTargetDialog = "tdialog"
+
+// TargetDialogHeader references an existing dialog from an out-of-dialog request - RFC 4538 7.
+// Tags are given from the perspective of the sender of the request.
+type TargetDialogHeader struct {
+	CallId string
+
+	// Any parameters present in the header, including local-tag and remote-tag.
+	Params Params
+}
+
+// NewTargetDialogHeader builds Target-Dialog header referencing the dialog of the sender.
+func NewTargetDialogHeader(dialog DialogId) *TargetDialogHeader {
+	params := NewParams()
+	if dialog.LocalTag != "" {
+		params.Add("local-tag", String{dialog.LocalTag})
+	}
+	if dialog.RemoteTag != "" {
+		params.Add("remote-tag", String{dialog.RemoteTag})
+	}
+	return &TargetDialogHeader{CallId: dialog.CallId, Params: params}
+}
+
+// DialogId returns the referenced dialog from the perspective of the recipient of the request,
+// i.e. the sender's remote tag becomes the local one.
+func (header *TargetDialogHeader) DialogId() DialogId {
+	return DialogId{
+		CallId:    header.CallId,
+		LocalTag:  paramValue(header.Params, "remote-tag"),
+		RemoteTag: paramValue(header.Params, "local-tag"),
+	}
+}
+
+func (header *TargetDialogHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("Target-Dialog: ")
+	buffer.WriteString(header.CallId)
+	if header.Params != nil && header.Params.Length() > 0 {
+		buffer.WriteString(";")
+		buffer.WriteString(header.Params.ToString(';'))
+	}
+	return buffer.String()
+}
+
+func (header *TargetDialogHeader) Name() string { return "Target-Dialog" }
+
+func (header *TargetDialogHeader) Copy() SipHeader {
+	return &TargetDialogHeader{header.CallId, copyWithNil(header.Params)}
+}
+
+// AuthorizeTargetDialog checks that the request references an existing dialog of the recipient - RFC 4538 5.
+// Returns nil if the request is authorized by the dialog, error of ErrNoDialog kind if the dialog is unknown,
+// which should be answered with 481 Call/Transaction Does Not Exist.
+// Requests without Target-Dialog header are not authorized by this mechanism.
+func AuthorizeTargetDialog(req *Request, dialogs DialogLookup) error {
+	hdrs := req.Headers("Target-Dialog")
+	if len(hdrs) == 0 {
+		return fmt.Errorf("no Target-Dialog header in request %s", req.Short())
+	}
+	header, ok := hdrs[0].(*TargetDialogHeader)
+	if !ok {
+		return fmt.Errorf("Headers('Target-Dialog') returned non 'Target-Dialog' header")
+	}
+
+	id := header.DialogId()
+	if id.LocalTag == "" || id.RemoteTag == "" {
+		return NewError(ErrNoDialog, nil, "Target-Dialog %s misses dialog tags", id)
+	}
+	if !dialogs.HasDialog(id) {
+		return NewError(ErrNoDialog, nil, "target dialog %s not found", id)
+	}
+
+	return nil
+}
+
+// IsInDialog reports whether the request is sent within a dialog, i.e. has To tag - RFC 3261 12.2.
+func IsInDialog(req *Request) bool {
+	tag, err := req.ToTag()
+	return err == nil && tag != nil && tag.String() != ""
+}
+
+// RequestDialogId returns the dialog the in-dialog request belongs to, from the perspective of the recipient - RFC 3261 12.2.2.
+func RequestDialogId(req *Request) (DialogId, error) {
+	callId, err := req.CallId()
+	if err != nil {
+		return DialogId{}, err
+	}
+	toTag, err := req.ToTag()
+	if err != nil {
+		return DialogId{}, err
+	}
+	fromTag, err := req.FromTag()
+	if err != nil {
+		return DialogId{}, err
+	}
+
+	return DialogId{CallId: string(*callId), LocalTag: toTag.String(), RemoteTag: fromTag.String()}, nil
+}
+
+// ValidateInDialog checks that the in-dialog request matches an existing dialog by Call-ID and both tags.
+// Returns error of ErrNoDialog kind, which should be answered with 481 Call/Transaction Does Not Exist - RFC 3261 12.2.2.
+// Out-of-dialog requests are always valid.
+func ValidateInDialog(req *Request, dialogs DialogLookup) error {
+	if !IsInDialog(req) {
+		return nil
+	}
+
+	id, err := RequestDialogId(req)
+	if err != nil {
+		return NewError(ErrNoDialog, err, "in-dialog request %s misses dialog identifiers", req.Short())
+	}
+	if id.RemoteTag == "" || !dialogs.HasDialog(id) {
+		return NewError(ErrNoDialog, nil, "dialog %s of request %s not found", id, req.Short())
+	}
+
+	return nil
+}
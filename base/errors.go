@@ -0,0 +1,51 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kinds of errors reported by the stack layers.
+// Use errors.Is to check the kind of a returned error.
+var (
+	ErrTimeout           = errors.New("timeout")
+	ErrTransport         = errors.New("transport error")
+	ErrMalformedMessage  = errors.New("malformed message")
+	ErrRejectedMessage   = errors.New("rejected message")
+	ErrTransactionExists = errors.New("transaction already exists")
+	ErrNoDialog          = errors.New("dialog does not exist")
+	ErrInvalidCSeq       = errors.New("invalid CSeq")
+	ErrCancelled         = errors.New("cancelled")
+)
+
+// Error is an error of one of the known kinds, optionally wrapping the underlying cause.
+type Error struct {
+	// Kind is one of the Err* values above.
+	Kind error
+	// Msg describes what happened.
+	Msg string
+	// Cause is the underlying error, may be nil.
+	Cause error
+}
+
+// NewError creates an error of the given kind with formatted message.
+func NewError(kind error, cause error, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Msg: fmt.Sprintf(format, args...), Cause: cause}
+}
+
+func (err *Error) Error() string {
+	if err.Cause == nil {
+		return err.Msg
+	}
+	return fmt.Sprintf("%s: %s", err.Msg, err.Cause)
+}
+
+// Is reports whether the error is of the target kind.
+func (err *Error) Is(target error) bool {
+	return err.Kind == target
+}
+
+// Unwrap returns the underlying cause.
+func (err *Error) Unwrap() error {
+	return err.Cause
+}
@@ -0,0 +1,185 @@
+package base
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Location conveyance - RFC 6442.
+
+// Content type of the PIDF-LO location object - RFC 4119.
+const ContentTypePidf = "application/pidf+xml"
+
+// GeolocationHeader references the location of the target either by value,
+// as a cid: URI of the PIDF-LO body part, or by reference, e.g. as a http: or sip: URI - RFC 6442 4.1.
+type GeolocationHeader struct {
+	Uri string
+
+	// Any parameters present in the header.
+	Params Params
+}
+
+// NewGeolocationHeader builds Geolocation header referencing the location by URI.
+func NewGeolocationHeader(uri string) *GeolocationHeader {
+	return &GeolocationHeader{Uri: uri, Params: NewParams()}
+}
+
+// NewGeolocationCidHeader builds Geolocation header referencing the body part with the Content-ID.
+// The Content-ID may be given with or without the enclosing angle brackets.
+func NewGeolocationCidHeader(contentId string) *GeolocationHeader {
+	return NewGeolocationHeader("cid:" + strings.Trim(contentId, "<>"))
+}
+
+// IsByValue reports whether the location is carried in the message body.
+func (header *GeolocationHeader) IsByValue() bool {
+	return strings.HasPrefix(strings.ToLower(header.Uri), "cid:")
+}
+
+// ContentId returns Content-ID of the body part carrying the location, without angle brackets.
+func (header *GeolocationHeader) ContentId() (string, bool) {
+	if !header.IsByValue() {
+		return "", false
+	}
+	return header.Uri[len("cid:"):], true
+}
+
+func (header *GeolocationHeader) String() string {
+	return "Geolocation: " + uriWithParams(header.Uri, header.Params)
+}
+
+func (header *GeolocationHeader) Name() string { return "Geolocation" }
+
+func (header *GeolocationHeader) Copy() SipHeader {
+	return &GeolocationHeader{header.Uri, copyWithNil(header.Params)}
+}
+
+// GeolocationRoutingHeader tells whether intermediaries may use the location for routing - RFC 6442 4.2.
+type GeolocationRoutingHeader struct {
+	Allowed bool
+
+	// Any extension parameters present in the header.
+	Params Params
+}
+
+func NewGeolocationRoutingHeader(allowed bool) *GeolocationRoutingHeader {
+	return &GeolocationRoutingHeader{Allowed: allowed, Params: NewParams()}
+}
+
+func (header *GeolocationRoutingHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("Geolocation-Routing: ")
+	if header.Allowed {
+		buffer.WriteString("yes")
+	} else {
+		buffer.WriteString("no")
+	}
+	if header.Params != nil && header.Params.Length() > 0 {
+		buffer.WriteString(";")
+		buffer.WriteString(header.Params.ToString(';'))
+	}
+	return buffer.String()
+}
+
+func (header *GeolocationRoutingHeader) Name() string { return "Geolocation-Routing" }
+
+func (header *GeolocationRoutingHeader) Copy() SipHeader {
+	return &GeolocationRoutingHeader{header.Allowed, copyWithNil(header.Params)}
+}
+
+// GeolocationRoutingAllowed returns the routing permission of the message.
+// Absent header means routing is not allowed - RFC 6442 3.2.
+func GeolocationRoutingAllowed(msg SipMessage) bool {
+	for _, h := range msg.Headers("Geolocation-Routing") {
+		if routing, ok := h.(*GeolocationRoutingHeader); ok {
+			return routing.Allowed
+		}
+	}
+	return false
+}
+
+// Geolocations returns all Geolocation headers of the message.
+func Geolocations(msg SipMessage) []*GeolocationHeader {
+	locations := make([]*GeolocationHeader, 0)
+	for _, h := range msg.Headers("Geolocation") {
+		if location, ok := h.(*GeolocationHeader); ok {
+			locations = append(locations, location)
+		}
+	}
+	return locations
+}
+
+// PidfLoPoint is a geodetic point location of the entity - RFC 5491 5.2.1.
+type PidfLoPoint struct {
+	// Presence entity the location belongs to, e.g. pres:alice@example.com.
+	Entity    string
+	Latitude  float64
+	Longitude float64
+}
+
+// Body renders the point as PIDF-LO document, to be sent with ContentTypePidf.
+func (point *PidfLoPoint) Body() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\r\n")
+	buffer.WriteString(`<presence xmlns="urn:ietf:params:xml:ns:pidf"` +
+		` xmlns:gp="urn:ietf:params:xml:ns:pidf:geopriv10"` +
+		` xmlns:gml="http://www.opengis.net/gml"` +
+		` entity="`)
+	xml.EscapeText(&buffer, []byte(point.Entity))
+	buffer.WriteString(`">` + "\r\n")
+	buffer.WriteString(`<tuple id="location"><status><gp:geopriv><gp:location-info>` +
+		`<gml:Point srsName="urn:ogc:def:crs:EPSG::4326"><gml:pos>`)
+	buffer.WriteString(strconv.FormatFloat(point.Latitude, 'f', -1, 64))
+	buffer.WriteString(" ")
+	buffer.WriteString(strconv.FormatFloat(point.Longitude, 'f', -1, 64))
+	buffer.WriteString(`</gml:pos></gml:Point></gp:location-info>` +
+		`<gp:usage-rules/></gp:geopriv></status></tuple>` + "\r\n")
+	buffer.WriteString("</presence>\r\n")
+	return buffer.String()
+}
+
+// ParsePidfLoPoint extracts the first geodetic point from PIDF-LO document.
+func ParsePidfLoPoint(body string) (*PidfLoPoint, error) {
+	point := &PidfLoPoint{}
+	decoder := xml.NewDecoder(strings.NewReader(body))
+	inPos := false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("no location point found in PIDF-LO document: %s", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "presence":
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "entity" {
+						point.Entity = attr.Value
+					}
+				}
+			case "pos":
+				inPos = true
+			}
+		case xml.EndElement:
+			inPos = false
+		case xml.CharData:
+			if !inPos {
+				continue
+			}
+			coords := strings.Fields(string(t))
+			if len(coords) < 2 {
+				return nil, fmt.Errorf("malformed location point '%s' in PIDF-LO document", t)
+			}
+			if point.Latitude, err = strconv.ParseFloat(coords[0], 64); err != nil {
+				return nil, err
+			}
+			if point.Longitude, err = strconv.ParseFloat(coords[1], 64); err != nil {
+				return nil, err
+			}
+			return point, nil
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package base
+
+import (
+	"testing"
+
+	"github.com/ghettovoice/gossip/log"
+)
+
+func TestGeolocationHelpers(t *testing.T) {
+	uri := &SipUri{User: String{"bob"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	point := &PidfLoPoint{Entity: "pres:alice@atlanta.example.com", Latitude: 33.001111, Longitude: -96.68142}
+	invite := NewRequest(INVITE, uri, "SIP/2.0", []SipHeader{
+		NewGeolocationCidHeader("<target123@atlanta.example.com>"),
+		NewGeolocationRoutingHeader(true),
+		&GenericHeader{"Content-Type", ContentTypePidf},
+	}, point.Body(), log.StandardLogger())
+
+	if !GeolocationRoutingAllowed(invite) {
+		t.Errorf("[FAIL] expected geolocation routing to be allowed")
+	}
+
+	locations := Geolocations(invite)
+	if len(locations) != 1 {
+		t.Fatalf("[FAIL] expected 1 Geolocation header, got %d", len(locations))
+	}
+	if cid, ok := locations[0].ContentId(); !ok || cid != "target123@atlanta.example.com" {
+		t.Errorf("[FAIL] unexpected Content-ID reference %s", locations[0])
+	}
+	if NewGeolocationHeader("https://lis.example.com/loc/123").IsByValue() {
+		t.Errorf("[FAIL] https location reference is reported as location by value")
+	}
+
+	parsed, err := ParsePidfLoPoint(invite.Body())
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse PIDF-LO body: %s", err)
+	}
+	if *parsed != *point {
+		t.Errorf("[FAIL] expected location %v, got %v", point, parsed)
+	}
+
+	if _, err := ParsePidfLoPoint("<presence/>"); err == nil {
+		t.Errorf("[FAIL] expected error on PIDF-LO document without location")
+	}
+}
@@ -39,6 +39,18 @@ func (s String) String() string {
 	return s.S
 }
 
+// QuotedString is a parameter value always rendered quoted, e.g. of media feature tags - RFC 3840 9.
+// Parsed values are String without the quotes.
+type QuotedString struct {
+	S string
+}
+
+func (s QuotedString) implementsMaybeString() {}
+
+func (s QuotedString) String() string {
+	return s.S
+}
+
 // A single logical header from a SIP message.
 type SipHeader interface {
 	// Produce the string representation of the header.
@@ -197,7 +209,7 @@ func (uri *SipUri) String() string {
 	}
 
 	// Compulsory hostname.
-	buffer.WriteString(uri.Host)
+	buffer.WriteString(FormatHost(uri.Host))
 
 	// Optional port number.
 	if uri.Port != nil {
@@ -245,10 +257,33 @@ func (uri WildcardUri) Equals(other Uri) bool {
 	}
 }
 
+// A URI of a schema gossip does not natively support, e.g. http: or tel: - RFC 3261 25.1 absoluteURI.
+// It's kept verbatim, so the stack can answer 416 Unsupported URI Scheme.
+type AbsoluteUri struct {
+	Scheme string
+	// Everything after the colon.
+	Opaque string
+}
+
+func (uri *AbsoluteUri) Copy() Uri {
+	return &AbsoluteUri{uri.Scheme, uri.Opaque}
+}
+
+func (uri *AbsoluteUri) String() string {
+	return uri.Scheme + ":" + uri.Opaque
+}
+
+// Schemes are compared case-insensitively, the rest of the URI exactly.
+func (uri *AbsoluteUri) Equals(other Uri) bool {
+	otherUri, ok := other.(*AbsoluteUri)
+	return ok && strings.EqualFold(uri.Scheme, otherUri.Scheme) && uri.Opaque == otherUri.Opaque
+}
+
 // Generic list of parameters on a header.
 type Params interface {
 	Get(k string) (MaybeString, bool)
 	Add(k string, v MaybeString) Params
+	Remove(k string) Params
 	Copy() Params
 	Equals(p Params) bool
 	ToString(sep uint8) string
@@ -273,8 +308,11 @@ func (p *params) Items() map[string]MaybeString {
 }
 
 // Returns a slice of keys, in order.
+// The slice is a copy, so callers can't break the parameter order by modifying it.
 func (p *params) Keys() []string {
-	return p.paramOrder
+	keys := make([]string, len(p.paramOrder))
+	copy(keys, p.paramOrder)
+	return keys
 }
 
 // Returns the requested parameter value.
@@ -297,6 +335,22 @@ func (p *params) Add(k string, v MaybeString) Params {
 	return p
 }
 
+// Remove a parameter, keeping the order of the remaining ones.
+func (p *params) Remove(k string) Params {
+	if _, ok := p.params[k]; ok {
+		delete(p.params, k)
+		for i, key := range p.paramOrder {
+			if key == k {
+				p.paramOrder = append(p.paramOrder[:i], p.paramOrder[i+1:]...)
+				break
+			}
+		}
+	}
+
+	// Return the params so calls can be chained.
+	return p
+}
+
 // Copy a list of params.
 func (p *params) Copy() Params {
 	dup := NewParams()
@@ -333,11 +387,14 @@ func (p *params) ToString(sep uint8) string {
 
 		switch v := v.(type) {
 		case String:
-			if strings.ContainsAny(v.String(), c_ABNF_WS) {
+			// Values which are not tokens, e.g. +sip.instance="<urn:uuid:...>", must be quoted.
+			if strings.ContainsAny(v.String(), c_ABNF_WS+"<>,;") {
 				buffer.WriteString(fmt.Sprintf("=\"%s\"", v.String()))
 			} else {
 				buffer.WriteString(fmt.Sprintf("=%s", v.String()))
 			}
+		case QuotedString:
+			buffer.WriteString(fmt.Sprintf("=\"%s\"", v.String()))
 		}
 	}
 
@@ -489,11 +546,10 @@ func (contact *ContactHeader) String() string {
 		buffer.WriteString(fmt.Sprintf("\"%s\" ", s.String()))
 	}
 
-	switch contact.Address.(type) {
-	case *WildcardUri:
+	if contact.IsWildcard() {
 		// Treat the Wildcard URI separately as it must not be contained in < > angle brackets.
 		buffer.WriteString("*")
-	default:
+	} else {
 		buffer.WriteString(fmt.Sprintf("<%s>", contact.Address.String()))
 	}
 
@@ -582,7 +638,7 @@ func (hop *ViaHop) String() string {
 	buffer.WriteString(fmt.Sprintf("%s/%s/%s %s",
 		hop.ProtocolName, hop.ProtocolVersion,
 		hop.Transport,
-		hop.Host))
+		FormatHost(hop.Host)))
 	if hop.Port != nil {
 		buffer.WriteString(fmt.Sprintf(":%d", *hop.Port))
 	}
@@ -612,6 +668,50 @@ func (hop *ViaHop) Copy() *ViaHop {
 	}
 }
 
+// NewViaHop creates a SIP/2.0 Via hop for the given transport and sent-by address.
+// Port 0 means the port is omitted, an empty branch is replaced with a newly generated one.
+func NewViaHop(transport string, host string, port uint16, branch string) *ViaHop {
+	hop := &ViaHop{
+		ProtocolName:    "SIP",
+		ProtocolVersion: "2.0",
+		Transport:       strings.ToUpper(transport),
+		Host:            host,
+		Params:          NewParams(),
+	}
+	if port != 0 {
+		hop.Port = &port
+	}
+	if branch == "" {
+		branch = GenerateBranch()
+	}
+	hop.Params.Add("branch", String{branch})
+
+	return hop
+}
+
+// WithRport adds an empty 'rport' parameter to the hop, requesting symmetric response routing - RFC 3581.
+func (hop *ViaHop) WithRport() *ViaHop {
+	if _, ok := hop.Params.Get("rport"); !ok {
+		hop.Params.Add("rport", NoString{})
+	}
+	return hop
+}
+
+// PushHop adds the hop to the top of the Via header.
+func (via *ViaHeader) PushHop(hop *ViaHop) {
+	*via = append(ViaHeader{hop}, *via...)
+}
+
+// PopHop removes the top hop from the Via header and returns it.
+func (via *ViaHeader) PopHop() (*ViaHop, error) {
+	if len(*via) == 0 {
+		return nil, fmt.Errorf("no hops found in the 'Via' header")
+	}
+	hop := (*via)[0]
+	*via = (*via)[1:]
+	return hop, nil
+}
+
 func (via ViaHeader) String() string {
 	var buffer bytes.Buffer
 	buffer.WriteString("Via: ")
@@ -627,12 +727,13 @@ func (via ViaHeader) String() string {
 
 func (h ViaHeader) Name() string { return "Via" }
 
+// Copy returns a pointer to the copied header, the same as the parser produces.
 func (h ViaHeader) Copy() SipHeader {
-	dup := make([]*ViaHop, 0, len(h))
+	dup := make(ViaHeader, 0, len(h))
 	for _, hop := range h {
 		dup = append(dup, hop.Copy())
 	}
-	return ViaHeader(dup)
+	return &dup
 }
 
 type RequireHeader struct {
@@ -686,6 +787,34 @@ func (h *ProxyRequireHeader) Copy() SipHeader {
 	return &ProxyRequireHeader{dup}
 }
 
+// OptionTags returns the option tags listed by the headers of the name, e.g. Supported or Require,
+// whether they are parsed into typed headers or not - RFC 3261 20.32, 20.37.
+func OptionTags(msg SipMessage, name string) []string {
+	tags := make([]string, 0)
+	for _, h := range msg.Headers(name) {
+		text := h.String()
+		if idx := strings.Index(text, ":"); idx >= 0 {
+			text = text[idx+1:]
+		}
+		for _, tag := range strings.Split(text, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// HasOptionTag reports whether the headers of the name, e.g. Supported or Require, list the option tag, ignoring case.
+func HasOptionTag(msg SipMessage, name string, tag string) bool {
+	for _, option := range OptionTags(msg, name) {
+		if strings.EqualFold(option, tag) {
+			return true
+		}
+	}
+	return false
+}
+
 // 'Unsupported:' is a SIP header type - this doesn't indicate that the
 // header itself is not supported by gossip!
 type UnsupportedHeader struct {
@@ -704,3 +833,140 @@ func (h *UnsupportedHeader) Copy() SipHeader {
 	copy(h.Options, dup)
 	return &UnsupportedHeader{dup}
 }
+
+// CallInfoHeader carries additional information about the caller or callee - RFC 3261 20.9.
+// One header holds a single URI, the parser splits comma-separated lists.
+type CallInfoHeader struct {
+	// Absolute URI of the information, not necessarily a SIP one.
+	Uri string
+
+	// Any parameters present in the header, e.g. purpose or appearance-index.
+	Params Params
+}
+
+// Well-known values of the Call-Info purpose parameter - RFC 3261 20.9.
+const (
+	CallInfoPurposeIcon = "icon"
+	CallInfoPurposeInfo = "info"
+	CallInfoPurposeCard = "card"
+)
+
+// NewCallInfoHeader builds Call-Info header, empty purpose is omitted.
+func NewCallInfoHeader(uri string, purpose string) *CallInfoHeader {
+	header := &CallInfoHeader{Uri: uri, Params: NewParams()}
+	if purpose != "" {
+		header.Params.Add("purpose", String{purpose})
+	}
+	return header
+}
+
+// WithAppearance sets appearance-index parameter used for shared line appearances - RFC 7463 6.2.
+func (header *CallInfoHeader) WithAppearance(index int) *CallInfoHeader {
+	header.Params = withParam(header.Params, "appearance-index", strconv.Itoa(index))
+	return header
+}
+
+// Purpose returns the value of the purpose parameter or empty string.
+func (header *CallInfoHeader) Purpose() string {
+	return paramValue(header.Params, "purpose")
+}
+
+// Appearance returns the value of the appearance-index parameter.
+func (header *CallInfoHeader) Appearance() (int, bool) {
+	return intParam(header.Params, "appearance-index")
+}
+
+func (header *CallInfoHeader) String() string {
+	return "Call-Info: " + uriWithParams(header.Uri, header.Params)
+}
+
+func (header *CallInfoHeader) Name() string { return "Call-Info" }
+
+func (header *CallInfoHeader) Copy() SipHeader {
+	return &CallInfoHeader{header.Uri, copyWithNil(header.Params)}
+}
+
+// AlertInfoHeader points to an alternative ring tone for the UAS - RFC 3261 20.4.
+// It is also used to pass distinctive ring cues, either as URN - RFC 7462,
+// or as the widely deployed non-standard info parameter.
+type AlertInfoHeader struct {
+	// Absolute URI of the ring tone or alert URN.
+	Uri string
+
+	// Any parameters present in the header.
+	Params Params
+}
+
+// NewAlertInfoHeader builds Alert-Info header.
+func NewAlertInfoHeader(uri string) *AlertInfoHeader {
+	return &AlertInfoHeader{Uri: uri, Params: NewParams()}
+}
+
+// WithInfo sets info parameter carrying the distinctive ring cue, e.g. info=alert-internal.
+func (header *AlertInfoHeader) WithInfo(info string) *AlertInfoHeader {
+	header.Params = withParam(header.Params, "info", info)
+	return header
+}
+
+// WithAppearance sets appearance parameter selecting the line to ring on.
+func (header *AlertInfoHeader) WithAppearance(index int) *AlertInfoHeader {
+	header.Params = withParam(header.Params, "appearance", strconv.Itoa(index))
+	return header
+}
+
+// Info returns the value of the info parameter or empty string.
+func (header *AlertInfoHeader) Info() string {
+	return paramValue(header.Params, "info")
+}
+
+// Appearance returns the value of the appearance parameter.
+func (header *AlertInfoHeader) Appearance() (int, bool) {
+	return intParam(header.Params, "appearance")
+}
+
+func (header *AlertInfoHeader) String() string {
+	return "Alert-Info: " + uriWithParams(header.Uri, header.Params)
+}
+
+func (header *AlertInfoHeader) Name() string { return "Alert-Info" }
+
+func (header *AlertInfoHeader) Copy() SipHeader {
+	return &AlertInfoHeader{header.Uri, copyWithNil(header.Params)}
+}
+
+// uriWithParams formats <uri>;param=value header value.
+func uriWithParams(uri string, params Params) string {
+	var buffer bytes.Buffer
+	buffer.WriteString("<" + uri + ">")
+	if params != nil && params.Length() > 0 {
+		buffer.WriteString(";")
+		buffer.WriteString(params.ToString(';'))
+	}
+	return buffer.String()
+}
+
+// withParam replaces the parameter keeping its position, creates params if nil.
+func withParam(params Params, key string, value string) Params {
+	if params == nil {
+		params = NewParams()
+	}
+	return params.Add(key, String{value})
+}
+
+func paramValue(params Params, key string) string {
+	if params == nil {
+		return ""
+	}
+	if value, ok := params.Get(key); ok {
+		return value.String()
+	}
+	return ""
+}
+
+func intParam(params Params, key string) (int, bool) {
+	value, err := strconv.Atoi(paramValue(params, key))
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
@@ -0,0 +1,81 @@
+package base
+
+import (
+	"strings"
+)
+
+// Headers scoped to a single hop, transaction or dialog, which must not leak from one B2BUA leg to another.
+// The new leg generates its own values of them.
+var legScopedHeaders = []string{
+	"Via",
+	"Route",
+	"Record-Route",
+	"Contact",
+	"Call-Id",
+	"CSeq",
+	"Max-Forwards",
+	"Content-Length",
+	"Authorization",
+	"Proxy-Authorization",
+	"Proxy-Require",
+	"Path",
+	"Service-Route",
+	"Security-Client",
+	"Security-Verify",
+	"Target-Dialog",
+	"Replaces",
+}
+
+// LegHeaderPolicy adjusts the set of headers dropped when a request is copied to a new B2BUA leg.
+// Header names are case-insensitive.
+type LegHeaderPolicy struct {
+	// Allow keeps headers which are dropped by default, e.g. Max-Forwards to be decremented by the application.
+	Allow []string
+	// Deny drops additional headers, e.g. P-Asserted-Identity when the other leg is not trusted.
+	Deny []string
+}
+
+func (policy *LegHeaderPolicy) keeps(name string) bool {
+	if policy != nil {
+		for _, denied := range policy.Deny {
+			if strings.EqualFold(denied, name) {
+				return false
+			}
+		}
+		for _, allowed := range policy.Allow {
+			if strings.EqualFold(allowed, name) {
+				return true
+			}
+		}
+	}
+	for _, scoped := range legScopedHeaders {
+		if strings.EqualFold(scoped, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewLegRequest produces a clean copy of the request for a new B2BUA leg.
+// Method, Request-URI, body and end-to-end headers are preserved,
+// hop-by-hop, transaction and dialog scoped headers are dropped according to the policy, nil means the default one.
+// From and To headers are kept without tags, so the new leg establishes its own dialog.
+func NewLegRequest(req *Request, policy *LegHeaderPolicy) *Request {
+	hdrs := make([]SipHeader, 0)
+	for _, h := range req.AllHeaders() {
+		if !policy.keeps(h.Name()) {
+			continue
+		}
+
+		h = h.Copy()
+		switch header := h.(type) {
+		case *FromHeader:
+			header.Params.Remove("tag")
+		case *ToHeader:
+			header.Params.Remove("tag")
+		}
+		hdrs = append(hdrs, h)
+	}
+
+	return NewRequest(req.Method, req.Recipient.Copy(), req.SipVersion(), hdrs, req.Body(), req.log)
+}
@@ -0,0 +1,51 @@
+package base
+
+import (
+	"testing"
+
+	"github.com/ghettovoice/gossip/log"
+)
+
+func TestNewLegRequest(t *testing.T) {
+	uri := &SipUri{User: String{"bob"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	callId := CallId("leg-a")
+	invite := NewRequest(INVITE, uri, "SIP/2.0", []SipHeader{
+		&ViaHeader{NewViaHop("udp", "10.0.0.1", 5060, "")},
+		&FromHeader{DisplayName: String{"Alice"}, Address: uri.Copy(), Params: NewParams().Add("tag", String{"a1"})},
+		&ToHeader{DisplayName: NoString{}, Address: uri.Copy(), Params: NewParams()},
+		&callId,
+		&CSeq{SeqNo: 1, MethodName: INVITE},
+		MaxForwards(70),
+		&ContactHeader{DisplayName: NoString{}, Address: uri.Copy().(ContactUri), Params: NewParams()},
+		&GenericHeader{"Record-Route", "<sip:proxy.example.com;lr>"},
+		&GenericHeader{"Subject", "lunch"},
+		&GenericHeader{"P-Asserted-Identity", "<sip:alice@example.com>"},
+		&GenericHeader{"Content-Type", "application/sdp"},
+	}, "v=0\r\n", log.StandardLogger())
+
+	leg := NewLegRequest(invite, nil)
+	for _, name := range []string{"Via", "Call-Id", "CSeq", "Max-Forwards", "Contact", "Record-Route"} {
+		if len(leg.Headers(name)) != 0 {
+			t.Errorf("[FAIL] %s header leaked to the new leg", name)
+		}
+	}
+	for _, name := range []string{"Subject", "P-Asserted-Identity", "Content-Type"} {
+		if len(leg.Headers(name)) != 1 {
+			t.Errorf("[FAIL] %s header was not preserved", name)
+		}
+	}
+	if tag, err := leg.FromTag(); err == nil {
+		t.Errorf("[FAIL] From tag %s leaked to the new leg", tag)
+	}
+	if leg.Body() != invite.Body() || leg.Recipient.String() != invite.Recipient.String() {
+		t.Errorf("[FAIL] Request-URI or body was not preserved: %s", leg.String())
+	}
+	if tag, _ := invite.FromTag(); tag == nil || tag.String() != "a1" {
+		t.Errorf("[FAIL] original request was modified: %s", invite.String())
+	}
+
+	leg = NewLegRequest(invite, &LegHeaderPolicy{Allow: []string{"max-forwards"}, Deny: []string{"P-Asserted-Identity"}})
+	if len(leg.Headers("Max-Forwards")) != 1 || len(leg.Headers("P-Asserted-Identity")) != 0 {
+		t.Errorf("[FAIL] header policy was not applied: %s", leg.String())
+	}
+}
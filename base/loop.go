@@ -0,0 +1,129 @@
+package base
+
+import (
+	"crypto/md5"
+	"fmt"
+	"strings"
+
+	"github.com/ghettovoice/gossip/utils"
+)
+
+// LoopStatus is the result of loop detection on a request received by a proxy - RFC 3261 16.3 item 4.
+type LoopStatus int
+
+const (
+	// NoLoop means the request doesn't carry Via of the proxy.
+	NoLoop LoopStatus = iota
+	// Spiral means the request passed the proxy before, but was changed since, e.g. retargeted,
+	// and must be processed as a new one.
+	Spiral
+	// Loop means the request passed the proxy before unchanged, it should be rejected with 482 Loop Detected.
+	Loop
+)
+
+func (status LoopStatus) String() string {
+	switch status {
+	case NoLoop:
+		return "NoLoop"
+	case Spiral:
+		return "Spiral"
+	case Loop:
+		return "Loop"
+	default:
+		return "Unknown"
+	}
+}
+
+// HopMatcher reports whether the Via hop was added by this proxy, e.g. by its sent-by address.
+type HopMatcher func(hop *ViaHop) bool
+
+// LoopHash hashes the fields of the request deciding its routing: Request-URI, From and To tags, Call-ID, CSeq number,
+// Proxy-Require, Proxy-Authorization and Route headers - RFC 3261 16.6 item 8.
+// Via, Record-Route and Max-Forwards are changed by every hop, so they are not hashed.
+// Compute it on the received request after removing the Route of the proxy itself.
+func LoopHash(req *Request) string {
+	return hashRouting(req, true)
+}
+
+func hashRouting(req *Request, withToTag bool) string {
+	fields := []string{req.Recipient.String()}
+	if tag, err := req.FromTag(); err == nil && tag != nil {
+		fields = append(fields, tag.String())
+	}
+	if tag, err := req.ToTag(); withToTag && err == nil && tag != nil {
+		fields = append(fields, tag.String())
+	}
+	if callId, err := req.CallId(); err == nil {
+		fields = append(fields, callId.String())
+	}
+	if cseq, err := req.CSeq(); err == nil {
+		fields = append(fields, fmt.Sprint(cseq.SeqNo))
+	}
+	for _, name := range []string{"Proxy-Require", "Proxy-Authorization", "Route"} {
+		for _, h := range req.Headers(name) {
+			fields = append(fields, h.String())
+		}
+	}
+	return fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(fields, "\n"))))
+}
+
+// GenerateLoopBranch returns the branch of the Via hop the proxy adds forwarding the request,
+// it carries LoopHash of the request the proxy received, so that DetectLoop recognizes it - RFC 3261 16.6 item 8.
+func GenerateLoopBranch(received *Request) string {
+	return RFC3261BranchMagicCookie + LoopHash(received) + "." + idSalt + utils.RandStr(8)
+}
+
+// GenerateStatelessBranch returns the branch of the Via hop a stateless proxy adds forwarding the request.
+// Unlike GenerateLoopBranch it is the same for the retransmissions of the request and for its CANCEL,
+// as it's derived from the branch of the top Via hop instead of a random part - RFC 3261 16.11.
+// It carries LoopHash of the received request as well, except for ACK: ACK of a non-2xx response carries To tag
+// the INVITE had not, so it's hashed without it to get the branch of the INVITE - RFC 3261 17.2.3.
+func GenerateStatelessBranch(received *Request) string {
+	var top string
+	if hop, err := received.ViaHop(); err == nil {
+		if branch, ok := hop.Params.Get("branch"); ok && branch != nil {
+			top = branch.String()
+		}
+	}
+	return RFC3261BranchMagicCookie + hashRouting(received, !received.IsAck()) + "." + fmt.Sprintf("%x", md5.Sum([]byte(top)))
+}
+
+// DetectLoop checks whether the request received by the proxy passed it before - RFC 3261 16.3 item 4.
+// The Via hops of the proxy are recognized by own, their branches must be generated by GenerateLoopBranch.
+// A hop of the proxy with the hash of the request means a loop, hops with other hashes mean a spiral.
+func DetectLoop(req *Request, own HopMatcher) LoopStatus {
+	status := NoLoop
+	var hash string
+	for _, h := range req.Headers("Via") {
+		via, ok := h.(*ViaHeader)
+		if !ok {
+			continue
+		}
+		for _, hop := range *via {
+			if !own(hop) {
+				continue
+			}
+			if hash == "" {
+				hash = LoopHash(req)
+			}
+			if loopHash(hop) == hash {
+				return Loop
+			}
+			status = Spiral
+		}
+	}
+	return status
+}
+
+// loopHash extracts the hash generated by GenerateLoopBranch from the branch of the hop.
+func loopHash(hop *ViaHop) string {
+	branch, ok := hop.Params.Get("branch")
+	if !ok || branch == nil {
+		return ""
+	}
+	value := strings.TrimPrefix(branch.String(), RFC3261BranchMagicCookie)
+	if idx := strings.Index(value, "."); idx > 0 {
+		return value[:idx]
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+package base
+
+import (
+	"testing"
+
+	"github.com/ghettovoice/gossip/log"
+)
+
+func TestDetectLoopWithSpirals(t *testing.T) {
+	own := func(hop *ViaHop) bool { return hop.Host == "proxy.example.com" }
+	uri := func(user, host string) *SipUri {
+		return &SipUri{User: String{user}, Host: host, UriParams: NewParams(), Headers: NewParams()}
+	}
+	callId := CallId("spiral1")
+	invite := NewRequest(INVITE, uri("bob", "example.com"), "SIP/2.0", []SipHeader{
+		&ViaHeader{NewViaHop("udp", "10.0.0.1", 5060, "")},
+		&FromHeader{DisplayName: NoString{}, Address: uri("alice", "example.com"), Params: NewParams().Add("tag", String{"a1"})},
+		&ToHeader{DisplayName: NoString{}, Address: uri("bob", "example.com"), Params: NewParams()},
+		&callId,
+		&CSeq{SeqNo: 1, MethodName: INVITE},
+		MaxForwards(70),
+	}, "", log.StandardLogger())
+	// forward passes the request through a hop adding its Via and Record-Route, as proxies do.
+	forward := func(req *Request, host string, branch string) *Request {
+		fwd := req.Copy()
+		fwd.AddFrontHeader(&ViaHeader{NewViaHop("udp", host, 5060, branch)})
+		fwd.AddFrontHeader(&GenericHeader{"Record-Route", "<sip:" + host + ";lr>"})
+		return fwd
+	}
+
+	if status := DetectLoop(invite, own); status != NoLoop {
+		t.Fatalf("[FAIL] expected %s of the new request, got %s", NoLoop, status)
+	}
+	toHome := forward(invite, "proxy.example.com", GenerateLoopBranch(invite))
+
+	// The home proxy of bob retargets the request to his device and routes it through the proxy again.
+	retargeted := forward(toHome, "home.example.com", "")
+	retargeted.Recipient = uri("bob", "10.0.0.5")
+	if status := DetectLoop(retargeted, own); status != Spiral {
+		t.Fatalf("[FAIL] expected %s of the retargeted request, got %s", Spiral, status)
+	}
+	toDevice := forward(retargeted, "proxy.example.com", GenerateLoopBranch(retargeted))
+
+	// A misconfigured hop sends the request back unchanged.
+	looped := forward(toDevice, "home.example.com", "")
+	if status := DetectLoop(looped, own); status != Loop {
+		t.Errorf("[FAIL] expected %s of the request returned unchanged, got %s", Loop, status)
+	}
+	if len(looped.Headers("Record-Route")) != 4 {
+		t.Errorf("[FAIL] expected Record-Route of every hop in the looped request")
+	}
+}
+
+func TestGenerateStatelessBranch(t *testing.T) {
+	own := func(hop *ViaHop) bool { return hop.Host == "proxy.example.com" }
+	uri := &SipUri{User: String{"bob"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	callId := CallId("stateless1")
+	invite := NewRequest(INVITE, uri, "SIP/2.0", []SipHeader{
+		&ViaHeader{NewViaHop("udp", "10.0.0.1", 5060, "z9hG4bK1")},
+		&callId,
+		&CSeq{SeqNo: 1, MethodName: INVITE},
+	}, "", log.StandardLogger())
+
+	branch := GenerateStatelessBranch(invite)
+	if retransmitted := GenerateStatelessBranch(invite.Copy()); retransmitted != branch {
+		t.Errorf("[FAIL] expected branch %s of the retransmission, got %s", branch, retransmitted)
+	}
+	ack := invite.Copy()
+	ack.Method = ACK
+	ack.SetHeader(&CSeq{SeqNo: 1, MethodName: ACK}, true)
+	ack.SetHeader(&ToHeader{DisplayName: NoString{}, Address: uri.Copy(), Params: NewParams().Add("tag", String{"totag"})}, true)
+	if ackBranch := GenerateStatelessBranch(ack); ackBranch != branch {
+		t.Errorf("[FAIL] expected branch %s of ACK on non-2xx response, got %s", branch, ackBranch)
+	}
+	other := invite.Copy()
+	via, _ := other.Via()
+	(*via)[0] = NewViaHop("udp", "10.0.0.1", 5060, "z9hG4bK2")
+	if GenerateStatelessBranch(other) == branch {
+		t.Errorf("[FAIL] expected another branch of another transaction")
+	}
+
+	looped := invite.Copy()
+	looped.AddFrontHeader(&ViaHeader{NewViaHop("udp", "proxy.example.com", 5060, branch)})
+	if status := DetectLoop(looped, own); status != Loop {
+		t.Errorf("[FAIL] expected %s of the request returned unchanged, got %s", Loop, status)
+	}
+}
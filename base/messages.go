@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/ghettovoice/gossip/log"
 )
@@ -36,6 +37,7 @@ const (
 	SUBSCRIBE Method = "SUBSCRIBE"
 	NOTIFY    Method = "NOTIFY"
 	REFER     Method = "REFER"
+	UPDATE    Method = "UPDATE"
 )
 
 // Internal representation of a SIP message - either a Request or a Response.
@@ -71,6 +73,8 @@ type SipMessage interface {
 
 	// Set the body of the message.
 	SetBody(body string)
+	// SetCompactForm makes String render the headers in the compact form where they have one.
+	SetCompactForm(compact bool)
 	// StartLine returns first line of message.
 	StartLine() string
 	// Helper getters
@@ -84,15 +88,25 @@ type SipMessage interface {
 	To() (*ToHeader, error)
 	ToTag() (MaybeString, error)
 	CSeq() (*CSeq, error)
+	// Contacts returns the typed Contact headers.
+	Contacts() []*ContactHeader
+	// Routes and RecordRoutes return the typed Route and Record-Route headers.
+	Routes() []*RouteHeader
+	RecordRoutes() []*RecordRouteHeader
 }
 
 // A shared type for holding headers and their ordering.
+// Headers are guarded by the lock, since transactions, transports and the TU all touch the same message.
+// The lock guards the header list only, the header values are still mutable by whoever holds them,
+// so pass a copy of the message when it must not change under the receiver.
 type headers struct {
 	// The logical SIP headers attached to this message.
 	headers map[string][]SipHeader
 
 	// The order the headers should be displayed in.
 	headerOrder []string
+
+	lock sync.RWMutex
 }
 
 func newHeaders(hdrs []SipHeader) *headers {
@@ -100,18 +114,30 @@ func newHeaders(hdrs []SipHeader) *headers {
 	hs.headers = make(map[string][]SipHeader)
 	hs.headerOrder = make([]string, 0)
 	for _, header := range hdrs {
-		hs.AddHeader(header)
+		hs.addHeader(header)
 	}
 	return hs
 }
 
-func (hs headers) String() string {
+func (hs *headers) String() string {
+	return hs.string(false)
+}
+
+// string renders the headers, in the compact form if asked.
+func (hs *headers) string(compact bool) string {
+	hs.lock.RLock()
+	defer hs.lock.RUnlock()
+
 	buffer := bytes.Buffer{}
 	// Construct each header in turn and add it to the message.
 	for typeIdx, name := range hs.headerOrder {
 		headers := hs.headers[name]
 		for idx, header := range headers {
-			buffer.WriteString(header.String())
+			if compact {
+				buffer.WriteString(compactHeader(header))
+			} else {
+				buffer.WriteString(header.String())
+			}
 			if typeIdx < len(hs.headerOrder) || idx < len(headers) {
 				buffer.WriteString("\r\n")
 			}
@@ -122,83 +148,117 @@ func (hs headers) String() string {
 
 // Add the given header.
 func (hs *headers) AddHeader(h SipHeader) {
-	name := strings.ToLower(h.Name())
-	if _, ok := hs.headers[name]; ok {
-		hs.headers[name] = append(hs.headers[name], h)
-	} else {
-		hs.headers[name] = []SipHeader{h}
-		hs.headerOrder = append(hs.headerOrder, name)
-	}
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	hs.addHeader(h)
 }
 
 // SetHeader works like AddHeader but can drop existing header.
 func (hs *headers) SetHeader(h SipHeader, remove bool) {
-	name := strings.ToLower(h.Name())
-	_, found := hs.headers[name]
-	if remove && found {
-		delete(hs.headers, name)
-		for i, entry := range hs.headerOrder {
-			if entry == name {
-				hs.headerOrder = append(hs.headerOrder[:i], hs.headerOrder[i+1:]...)
-			}
-		}
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	if remove {
+		hs.removeHeaders(headerKey(h.Name()))
 	}
-
-	hs.AddHeader(h)
+	hs.addHeader(h)
 }
 
 // SetHeader works like AddFrontHeader but can drop existing header.
 func (hs *headers) SetFrontHeader(h SipHeader, remove bool) {
-	name := strings.ToLower(h.Name())
-	_, found := hs.headers[name]
-	if remove && found {
-		delete(hs.headers, name)
-		for i, entry := range hs.headerOrder {
-			if entry == name {
-				hs.headerOrder = append(hs.headerOrder[:i], hs.headerOrder[i+1:]...)
-			}
-		}
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	if remove {
+		hs.removeHeaders(headerKey(h.Name()))
 	}
-
-	hs.AddFrontHeader(h)
+	hs.addFrontHeader(h)
 }
 
 // AddFrontHeader adds header to the front of header list
 // if there is no header has h's name, add h to the tail of all headers
 // if there are some headers have h's name, add h to front of the sublist
 func (hs *headers) AddFrontHeader(h SipHeader) {
-	name := strings.ToLower(h.Name())
-	if hdrs, ok := hs.headers[name]; ok {
-		newHdrs := make([]SipHeader, 1, len(hdrs)+1)
-		newHdrs[0] = h
-		hs.headers[name] = append(newHdrs, hdrs...)
-	} else {
-		hs.headers[name] = []SipHeader{h}
-		hs.headerOrder = append(hs.headerOrder, name)
-	}
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	hs.addFrontHeader(h)
 }
 
 // Gets some headers.
+// The returned slice is a copy, changing it doesn't change the message.
 func (hs *headers) Headers(name string) []SipHeader {
-	name = strings.ToLower(name)
+	name = headerKey(name)
+	hs.lock.RLock()
+	defer hs.lock.RUnlock()
+	return append([]SipHeader{}, hs.headers[name]...)
+}
+
+func (hs *headers) AllHeaders() []SipHeader {
+	hs.lock.RLock()
+	defer hs.lock.RUnlock()
+	allHeaders := make([]SipHeader, 0)
+	for _, key := range hs.headerOrder {
+		allHeaders = append(allHeaders, hs.headers[key]...)
+	}
+
+	return allHeaders
+}
+
+// replaceHeader replaces the first header of h's name, or adds h if there is none, keeping the header order.
+func (hs *headers) replaceHeader(h SipHeader) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	name := headerKey(h.Name())
+	if hdrs, ok := hs.headers[name]; ok && len(hdrs) > 0 {
+		hdrs[0] = h
+		return
+	}
+	hs.addHeader(h)
+}
+
+// The methods below must be called under the lock.
+
+func (hs *headers) init() {
 	if hs.headers == nil {
 		hs.headers = map[string][]SipHeader{}
 		hs.headerOrder = []string{}
 	}
-	if headers, ok := hs.headers[name]; ok {
-		return headers
+}
+
+func (hs *headers) addHeader(h SipHeader) {
+	hs.init()
+	name := headerKey(h.Name())
+	if _, ok := hs.headers[name]; ok {
+		hs.headers[name] = append(hs.headers[name], h)
 	} else {
-		return []SipHeader{}
+		hs.headers[name] = []SipHeader{h}
+		hs.headerOrder = append(hs.headerOrder, name)
 	}
 }
 
-func (hs *headers) AllHeaders() []SipHeader {
-	allHeaders := make([]SipHeader, 0)
-	for _, key := range hs.headerOrder {
-		allHeaders = append(allHeaders, hs.headers[key]...)
+func (hs *headers) addFrontHeader(h SipHeader) {
+	hs.init()
+	name := headerKey(h.Name())
+	if hdrs, ok := hs.headers[name]; ok {
+		newHdrs := make([]SipHeader, 1, len(hdrs)+1)
+		newHdrs[0] = h
+		hs.headers[name] = append(newHdrs, hdrs...)
+	} else {
+		hs.headers[name] = []SipHeader{h}
+		hs.headerOrder = append(hs.headerOrder, name)
 	}
+}
 
-	return allHeaders
+// removeHeaders drops all the headers of the lower case name.
+func (hs *headers) removeHeaders(name string) {
+	if _, found := hs.headers[name]; !found {
+		return
+	}
+	delete(hs.headers, name)
+	for i, entry := range hs.headerOrder {
+		if entry == name {
+			hs.headerOrder = append(hs.headerOrder[:i], hs.headerOrder[i+1:]...)
+			break
+		}
+	}
 }
 
 func (hs *headers) CallId() (*CallId, error) {
@@ -313,8 +373,10 @@ func (hs *headers) RemoveHeader(header SipHeader) error {
 		"cannot remove header '%s' from message as it is not present",
 		header.String(),
 	)
-	name := strings.ToLower(header.Name())
+	name := headerKey(header.Name())
 
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
 	headersOfSameType, isMatch := hs.headers[name]
 	if !isMatch || len(headersOfSameType) == 0 {
 		return errNoMatch
@@ -336,13 +398,7 @@ func (hs *headers) RemoveHeader(header SipHeader) error {
 		// The header we removed was the only one of its type.
 		// Tidy up the header structure by removing the empty list value from the header map,
 		// and removing the entry from the headerOrder list.
-		delete(hs.headers, name)
-
-		for idx, entry := range hs.headerOrder {
-			if entry == name {
-				hs.headerOrder = append(hs.headerOrder[:idx], hs.headerOrder[idx+1:]...)
-			}
-		}
+		hs.removeHeaders(name)
 	}
 
 	return nil
@@ -373,7 +429,9 @@ type message struct {
 	sipVersion string
 	// The application data of the message.
 	body string
-	log  log.Logger
+	// Render the headers in the compact form.
+	compact bool
+	log     log.Logger
 }
 
 func (msg *message) SipVersion() string {
@@ -417,13 +475,7 @@ func (msg *message) Body() string {
 
 func (msg *message) SetBody(body string) {
 	msg.body = body
-	hdrs := msg.Headers("Content-Length")
-	if len(hdrs) == 0 {
-		length := ContentLength(len(body))
-		msg.AddHeader(length)
-	} else {
-		hdrs[0] = ContentLength(len(body))
-	}
+	msg.replaceHeader(ContentLength(len(body)))
 }
 
 func (msg *message) Log() log.Logger {
@@ -495,7 +547,7 @@ func (request *Request) String() string {
 	// write message start line
 	buffer.WriteString(request.StartLine() + "\r\n")
 	// Write the headers.
-	buffer.WriteString(request.headers.String())
+	buffer.WriteString(request.headers.string(request.compact))
 	// If the request has a message body, add it.
 	buffer.WriteString("\r\n" + request.Body())
 
@@ -510,6 +562,80 @@ func (request *Request) IsAck() bool {
 	return request.Method == ACK
 }
 
+// Copy returns a deep copy of the request.
+func (request *Request) Copy() *Request {
+	hdrs := make([]SipHeader, 0)
+	for _, h := range request.AllHeaders() {
+		hdrs = append(hdrs, h.Copy())
+	}
+	return NewRequest(request.Method, request.Recipient.Copy(), request.SipVersion(), hdrs, request.Body(), request.log)
+}
+
+// NewCancelRequest creates CANCEL request for the pending request - RFC 3261 9.1.
+// Request-URI, Call-Id, To, From, the CSeq number and Route headers are copied from the request,
+// Via consists of the top hop of the request, so that CANCEL matches the same transaction.
+func NewCancelRequest(req *Request) (*Request, error) {
+	hop, err := req.ViaHop()
+	if err != nil {
+		return nil, err
+	}
+	cseq, err := req.CSeq()
+	if err != nil {
+		return nil, err
+	}
+
+	cancel := NewRequest(CANCEL, req.Recipient.Copy(), req.SipVersion(), []SipHeader{}, "", req.log)
+	cancel.AddHeader(&ViaHeader{hop.Copy()})
+	CopyHeaders("From", req, cancel)
+	CopyHeaders("To", req, cancel)
+	CopyHeaders("Call-Id", req, cancel)
+	cancel.AddHeader(&CSeq{SeqNo: cseq.SeqNo, MethodName: CANCEL})
+	CopyHeaders("Route", req, cancel)
+
+	return cancel, nil
+}
+
+// NewAckRequest creates ACK request on 2xx response to INVITE, it is a transaction of its own - RFC 3261 13.2.2.4.
+// The request is built as an in-dialog one: Request-URI is Contact of the response and Route headers are
+// the route set of Record-Route headers of the response, Via has the top hop of INVITE with a new branch.
+// From, Call-Id, the CSeq number and the credentials are copied from INVITE, To from the response.
+func NewAckRequest(invite *Request, res *Response) (*Request, error) {
+	hop, err := invite.ViaHop()
+	if err != nil {
+		return nil, err
+	}
+	cseq, err := invite.CSeq()
+	if err != nil {
+		return nil, err
+	}
+	var target Uri
+	for _, contact := range res.Contacts() {
+		if !contact.IsWildcard() {
+			target = contact.Address
+			break
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("response %s has no Contact to send ACK to", res.Short())
+	}
+
+	via := hop.Copy()
+	via.Params.Add("branch", String{S: GenerateBranch()})
+	ack := NewRequest(ACK, target.Copy(), invite.SipVersion(), []SipHeader{}, "", invite.log)
+	ack.AddHeader(&ViaHeader{via})
+	CopyHeaders("From", invite, ack)
+	CopyHeaders("To", res, ack)
+	CopyHeaders("Call-Id", invite, ack)
+	ack.AddHeader(&CSeq{SeqNo: cseq.SeqNo, MethodName: ACK})
+	ack.AddHeader(MaxForwards(70))
+	CopyHeaders("Authorization", invite, ack)
+	CopyHeaders("Proxy-Authorization", invite, ack)
+	ack.AddHeader(ContentLength(0))
+	ApplyRouteSet(ack, ReverseRecordRoutes(res), target)
+
+	return ack, nil
+}
+
 // A SIP response object  (c.f. RFC 3261 section 7.2).
 type Response struct {
 	message
@@ -542,6 +668,32 @@ func NewResponse(
 	return
 }
 
+// NewResponseFromRequest creates a response to the request - RFC 3261 - 8.2.6.2.
+// Via, From, To, Call-Id and CSeq headers are copied from the request.
+func NewResponseFromRequest(
+	req *Request,
+	statusCode uint16,
+	reason string,
+	body string,
+) *Response {
+	res := NewResponse(
+		req.SipVersion(),
+		statusCode,
+		reason,
+		[]SipHeader{},
+		"",
+		req.Log(),
+	)
+	CopyHeaders("Via", req, res)
+	CopyHeaders("From", req, res)
+	CopyHeaders("To", req, res)
+	CopyHeaders("Call-Id", req, res)
+	CopyHeaders("CSeq", req, res)
+	res.SetBody(body)
+
+	return res
+}
+
 // StartLine returns Response Status Line - RFC 2361 7.2.
 func (response *Response) StartLine() string {
 	var buffer bytes.Buffer
@@ -578,13 +730,22 @@ func (response *Response) String() string {
 	// write message start line
 	buffer.WriteString(response.StartLine() + "\r\n")
 	// Write the headers.
-	buffer.WriteString(response.headers.String())
+	buffer.WriteString(response.headers.string(response.compact))
 	// If the request has a message body, add it.
 	buffer.WriteString("\r\n" + response.Body())
 
 	return buffer.String()
 }
 
+// Copy returns a deep copy of the response.
+func (response *Response) Copy() *Response {
+	hdrs := make([]SipHeader, 0)
+	for _, h := range response.AllHeaders() {
+		hdrs = append(hdrs, h.Copy())
+	}
+	return NewResponse(response.SipVersion(), response.StatusCode, response.Reason, hdrs, response.Body(), response.log)
+}
+
 func (response *Response) IsProvisional() bool {
 	return response.StatusCode < 200
 }
@@ -0,0 +1,223 @@
+package base
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/sdp"
+)
+
+func TestHeadersConcurrentAccess(t *testing.T) {
+	uri := &SipUri{User: String{"bob"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	req := NewRequest(OPTIONS, uri, "SIP/2.0", []SipHeader{
+		&CSeq{SeqNo: 1, MethodName: OPTIONS},
+	}, "", log.StandardLogger())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				req.AddHeader(&GenericHeader{"X-Writer", fmt.Sprintf("%d-%d", i, j)})
+				req.SetHeader(&GenericHeader{"X-Last", fmt.Sprint(i)}, true)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_ = req.String()
+				_, _ = req.CSeq()
+				_ = req.Headers("X-Writer")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count := len(req.Headers("X-Writer")); count != 400 {
+		t.Errorf("[FAIL] expected 400 headers added concurrently, got %d", count)
+	}
+	if count := len(req.Headers("X-Last")); count != 1 {
+		t.Errorf("[FAIL] expected the only replaced header, got %d", count)
+	}
+}
+
+func TestHeadersReturnsCopy(t *testing.T) {
+	uri := &SipUri{User: String{"bob"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	req := NewRequest(OPTIONS, uri, "SIP/2.0", []SipHeader{&GenericHeader{"Subject", "lunch"}}, "", log.StandardLogger())
+
+	hdrs := req.Headers("Subject")
+	hdrs[0] = &GenericHeader{"Subject", "dinner"}
+	if got := req.Headers("Subject")[0].String(); got != "Subject: lunch" {
+		t.Errorf("[FAIL] header changed through the returned slice: %s", got)
+	}
+
+	req.SetBody("hello")
+	if got := req.Headers("Content-Length"); len(got) != 1 || got[0].String() != "Content-Length: 5" {
+		t.Errorf("[FAIL] expected Content-Length replaced, got %v", got)
+	}
+}
+
+func TestResponseCopy(t *testing.T) {
+	res := NewResponse("SIP/2.0", 200, "OK", []SipHeader{
+		&CSeq{SeqNo: 1, MethodName: INVITE},
+		&ToHeader{DisplayName: NoString{}, Address: &SipUri{Host: "example.com", UriParams: NewParams(), Headers: NewParams()}, Params: NewParams()},
+	}, "v=0\r\n", log.StandardLogger())
+
+	copied := res.Copy()
+	if copied.String() != res.String() {
+		t.Fatalf("[FAIL] expected copy:\n%s\ngot:\n%s", res.String(), copied.String())
+	}
+	to, _ := copied.To()
+	to.Params.Add("tag", String{"changed"})
+	if _, err := res.ToTag(); err == nil {
+		t.Errorf("[FAIL] changing the copy changed the original response")
+	}
+}
+
+func TestSDP(t *testing.T) {
+	uri := &SipUri{User: String{"bob"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	invite := NewRequest(INVITE, uri, "SIP/2.0", []SipHeader{}, "", log.StandardLogger())
+	if _, err := invite.SDP(); err == nil {
+		t.Errorf("[FAIL] expected error getting SDP of the request without body")
+	}
+
+	session := &sdp.Session{
+		Origin:     sdp.Origin{Username: "alice", SessionId: 1, SessionVersion: 1, NetType: "IN", AddrType: "IP4", Address: "192.0.2.1"},
+		Connection: sdp.NewConnection("192.0.2.1"),
+		Media:      []*sdp.Media{{Type: "audio", Port: 49170, Proto: "RTP/AVP", Formats: []string{"0"}}},
+	}
+	invite.SetSDP(session)
+	if hdrs := invite.Headers("Content-Type"); len(hdrs) != 1 || hdrs[0].(*GenericHeader).Contents != sdp.ContentType {
+		t.Errorf("[FAIL] expected Content-Type: %s, got %v", sdp.ContentType, hdrs)
+	}
+	parsed, err := invite.SDP()
+	if err != nil {
+		t.Fatalf("[FAIL] failed to get SDP: %s", err)
+	}
+	if parsed.String() != session.String() {
+		t.Errorf("[FAIL] unexpected SDP of the request:\n%s", parsed.String())
+	}
+
+	invite.SetHeader(&GenericHeader{HeaderName: "Content-Type", Contents: "text/plain"}, true)
+	if _, err := invite.SDP(); err == nil {
+		t.Errorf("[FAIL] expected error getting SDP of text/plain body")
+	}
+}
+
+func TestCompactForm(t *testing.T) {
+	uri := &SipUri{User: String{"bob"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	callId := CallId("a84b4c76e66710")
+	req := NewRequest(OPTIONS, uri, "SIP/2.0", []SipHeader{
+		&callId,
+		&CSeq{SeqNo: 1, MethodName: OPTIONS},
+		&GenericHeader{"s", "lunch"},
+	}, "hello", log.StandardLogger())
+
+	if got, err := req.CallId(); err != nil || *got != callId {
+		t.Errorf("[FAIL] expected Call-ID %s, got %v: %v", callId, got, err)
+	}
+	if got := req.Headers("Subject"); len(got) != 1 {
+		t.Errorf("[FAIL] expected Subject added by the compact name, got %v", got)
+	}
+	if got := req.Headers("l"); len(got) != 1 || got[0].String() != "Content-Length: 5" {
+		t.Errorf("[FAIL] expected Content-Length looked up by the compact name, got %v", got)
+	}
+
+	full := "OPTIONS sip:bob@example.com SIP/2.0\r\n" +
+		"Call-Id: a84b4c76e66710\r\n" +
+		"CSeq: 1 OPTIONS\r\n" +
+		"s: lunch\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\nhello"
+	if got := req.String(); got != full {
+		t.Errorf("[FAIL] expected\n%s\ngot\n%s", full, got)
+	}
+
+	req.SetCompactForm(true)
+	compact := "OPTIONS sip:bob@example.com SIP/2.0\r\n" +
+		"i: a84b4c76e66710\r\n" +
+		"CSeq: 1 OPTIONS\r\n" +
+		"s: lunch\r\n" +
+		"l: 5\r\n" +
+		"\r\nhello"
+	if got := req.String(); got != compact {
+		t.Errorf("[FAIL] expected\n%s\ngot\n%s", compact, got)
+	}
+}
+
+func TestCompactHeaderName(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		compact string
+		full    string
+	}{
+		{"Via", "v", "Via"},
+		{"call-id", "i", "call-id"},
+		{"I", "i", "Call-ID"},
+		{"Content-Type", "c", "Content-Type"},
+		{"Refer-To", "r", "Refer-To"},
+		{"CSeq", "", "CSeq"},
+	} {
+		compact, ok := CompactHeaderName(tc.name)
+		if compact != tc.compact || ok != (tc.compact != "") {
+			t.Errorf("[FAIL] expected compact name '%s' of %s, got '%s'", tc.compact, tc.name, compact)
+		}
+		if full := ExpandHeaderName(tc.name); full != tc.full {
+			t.Errorf("[FAIL] expected full name %s of %s, got %s", tc.full, tc.name, full)
+		}
+	}
+}
+
+func TestNewAckRequest(t *testing.T) {
+	alice := &SipUri{User: String{"alice"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	bob := &SipUri{User: String{"bob"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	callId := CallId("ack1")
+	invite := NewRequest(INVITE, bob, "SIP/2.0", []SipHeader{
+		&ViaHeader{NewViaHop("UDP", "192.0.2.1", 5060, "z9hG4bKinvite")},
+		&FromHeader{DisplayName: NoString{}, Address: alice, Params: NewParams().Add("tag", String{"a1"})},
+		&ToHeader{DisplayName: NoString{}, Address: bob, Params: NewParams()},
+		&callId,
+		&CSeq{SeqNo: 7, MethodName: INVITE},
+		&GenericHeader{HeaderName: "Proxy-Authorization", Contents: "Digest username=\"alice\""},
+	}, "", log.StandardLogger())
+
+	res := NewResponseFromRequest(invite, 200, "OK", "")
+	to, _ := res.To()
+	to.Params.Add("tag", String{"b1"})
+	device := &SipUri{User: String{"bob"}, Host: "192.0.2.4", UriParams: NewParams(), Headers: NewParams()}
+	res.AddHeader(&ContactHeader{DisplayName: NoString{}, Address: device, Params: NewParams()})
+	res.AddHeader(NewRecordRouteHeader(routeUri("p2.example.com", true)))
+	res.AddHeader(NewRecordRouteHeader(routeUri("p1.example.com", true)))
+
+	ack, err := NewAckRequest(invite, res)
+	if err != nil {
+		t.Fatalf("[FAIL] unexpected error: %s", err)
+	}
+	if ack.Method != ACK || ack.Recipient.String() != "sip:bob@192.0.2.4" {
+		t.Errorf("[FAIL] expected ACK to the Contact of the response, got %s", ack.Short())
+	}
+	assertRoutes(t, ack.Routes(), "Route: <sip:p1.example.com;lr>", "Route: <sip:p2.example.com;lr>")
+	if tag, err := ack.ToTag(); err != nil || tag.String() != "b1" {
+		t.Errorf("[FAIL] expected To tag of the response, got %v", tag)
+	}
+	if cseq, err := ack.CSeq(); err != nil || cseq.SeqNo != 7 || cseq.MethodName != ACK {
+		t.Errorf("[FAIL] expected CSeq 7 ACK, got %v", cseq)
+	}
+	if branch, err := ack.Branch(); err != nil || branch.String() == "z9hG4bKinvite" {
+		t.Errorf("[FAIL] expected a new branch, got %v", branch)
+	}
+	if len(ack.Headers("Proxy-Authorization")) != 1 {
+		t.Errorf("[FAIL] expected the credentials of INVITE copied")
+	}
+	if hop, err := NextHop(ack); err != nil || hop != "p1.example.com:5060" {
+		t.Errorf("[FAIL] expected next hop of the first route, got %s: %v", hop, err)
+	}
+
+	res.RemoveHeader(res.Contacts()[0])
+	if _, err := NewAckRequest(invite, res); err == nil {
+		t.Errorf("[FAIL] expected error of the response without Contact")
+	}
+}
@@ -0,0 +1,216 @@
+package base
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"sort"
+	"strings"
+
+	"github.com/ghettovoice/gossip/utils"
+)
+
+// Multipart message bodies - RFC 5621.
+
+// Content type of multipart bodies.
+const ContentTypeMultipartMixed = "multipart/mixed"
+
+// BodyPart is a part of the multipart body with its own headers.
+type BodyPart struct {
+	// Header holds the part headers by their canonical names, e.g. Content-Type.
+	Header map[string]string
+	Body   string
+}
+
+// NewBodyPart creates the part of the content type, e.g. ContentTypePidf.
+func NewBodyPart(contentType string, body string) *BodyPart {
+	return &BodyPart{Header: map[string]string{"Content-Type": contentType}, Body: body}
+}
+
+// ContentType returns the content type of the part, text/plain by default - RFC 2046 5.1.
+func (part *BodyPart) ContentType() string {
+	if contentType, ok := part.Header["Content-Type"]; ok {
+		return contentType
+	}
+	return "text/plain"
+}
+
+// ContentDisposition returns the content disposition of the part, e.g. session or render, if any.
+func (part *BodyPart) ContentDisposition() string {
+	return part.Header["Content-Disposition"]
+}
+
+// ContentId returns the Content-ID of the part without angle brackets, referenced by cid: URIs, e.g. of Geolocation.
+func (part *BodyPart) ContentId() string {
+	return strings.Trim(part.Header["Content-Id"], "<>")
+}
+
+// MultipartBody is the body of multipart/mixed or other multipart content type.
+type MultipartBody struct {
+	// MediaType is multipart/mixed unless given.
+	MediaType string
+	Boundary  string
+	Parts     []*BodyPart
+}
+
+// NewMultipartBody creates multipart/mixed body of the parts with a random boundary.
+func NewMultipartBody(parts ...*BodyPart) *MultipartBody {
+	return &MultipartBody{MediaType: ContentTypeMultipartMixed, Boundary: "gossip-" + utils.RandStr(16), Parts: parts}
+}
+
+// ParseMultipartBody splits the body of the multipart content type, e.g. multipart/mixed;boundary=unique, into parts.
+func ParseMultipartBody(contentType string, body string) (*MultipartBody, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type %s: %s", contentType, err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, fmt.Errorf("content type %s is not multipart", contentType)
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, fmt.Errorf("no boundary in content type %s", contentType)
+	}
+
+	// Delimiters start lines, the line break before a delimiter belongs to it - RFC 2046 5.1.1.
+	// Bodies are kept intact, they may be binary, e.g. ISUP.
+	chunks := strings.Split("\n"+body, "\n--"+boundary)
+	if len(chunks) < 2 {
+		return nil, fmt.Errorf("no parts delimited by boundary %s", boundary)
+	}
+
+	multipart := &MultipartBody{MediaType: mediaType, Boundary: boundary}
+	closed := false
+	// The preamble before the first delimiter is ignored.
+	for _, chunk := range chunks[1:] {
+		if strings.HasPrefix(chunk, "--") {
+			closed = true
+			break
+		}
+		part, err := parseBodyPart(strings.TrimSuffix(chunk, "\r"))
+		if err != nil {
+			return nil, err
+		}
+		multipart.Parts = append(multipart.Parts, part)
+	}
+	if !closed {
+		return nil, fmt.Errorf("multipart body is not closed by boundary %s", boundary)
+	}
+	return multipart, nil
+}
+
+// parseBodyPart parses the part following the delimiter, starting with the rest of the delimiter line.
+func parseBodyPart(chunk string) (*BodyPart, error) {
+	idx := strings.Index(chunk, "\n")
+	if idx < 0 || strings.TrimSpace(chunk[:idx]) != "" {
+		return nil, fmt.Errorf("invalid multipart delimiter line")
+	}
+	chunk = chunk[idx+1:]
+
+	part := &BodyPart{Header: make(map[string]string)}
+	for {
+		idx := strings.Index(chunk, "\n")
+		if idx < 0 {
+			return nil, fmt.Errorf("body part headers are not terminated by empty line")
+		}
+		line := strings.TrimSuffix(chunk[:idx], "\r")
+		chunk = chunk[idx+1:]
+		if line == "" {
+			break
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid body part header '%s'", line)
+		}
+		part.Header[canonicalPartHeader(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	part.Body = chunk
+	return part, nil
+}
+
+// canonicalPartHeader capitalizes the header name, e.g. content-type to Content-Type.
+func canonicalPartHeader(name string) string {
+	words := strings.Split(strings.ToLower(strings.TrimSpace(name)), "-")
+	for i, word := range words {
+		if word != "" {
+			words[i] = strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return strings.Join(words, "-")
+}
+
+// ContentType returns the value of Content-Type header of the body.
+func (multipart *MultipartBody) ContentType() string {
+	mediaType := multipart.MediaType
+	if mediaType == "" {
+		mediaType = ContentTypeMultipartMixed
+	}
+	return mime.FormatMediaType(mediaType, map[string]string{"boundary": multipart.Boundary})
+}
+
+// Part returns the first part of the content type, ignoring its parameters, nil if there is none.
+func (multipart *MultipartBody) Part(contentType string) *BodyPart {
+	for _, part := range multipart.Parts {
+		mediaType := strings.TrimSpace(strings.SplitN(part.ContentType(), ";", 2)[0])
+		if strings.EqualFold(mediaType, contentType) {
+			return part
+		}
+	}
+	return nil
+}
+
+// String renders the body with CRLF line endings.
+// Content-Type, Content-Disposition and Content-ID of the parts go first, the other headers in lexical order.
+func (multipart *MultipartBody) String() string {
+	var buffer bytes.Buffer
+	for _, part := range multipart.Parts {
+		buffer.WriteString("--" + multipart.Boundary + "\r\n")
+		names := make([]string, 0, len(part.Header))
+		for name := range part.Header {
+			switch name {
+			case "Content-Type", "Content-Disposition", "Content-Id":
+			default:
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range append([]string{"Content-Type", "Content-Disposition", "Content-Id"}, names...) {
+			if value, ok := part.Header[name]; ok {
+				if name == "Content-Id" {
+					name = "Content-ID"
+				}
+				buffer.WriteString(name + ": " + value + "\r\n")
+			}
+		}
+		buffer.WriteString("\r\n")
+		buffer.WriteString(part.Body)
+		buffer.WriteString("\r\n")
+	}
+	buffer.WriteString("--" + multipart.Boundary + "--\r\n")
+	return buffer.String()
+}
+
+// Multipart parses the multipart body of the message.
+func (msg *message) Multipart() (*MultipartBody, error) {
+	contentType, ok := msg.contentType()
+	if !ok {
+		return nil, fmt.Errorf("message has no Content-Type")
+	}
+	return ParseMultipartBody(contentType, msg.Body())
+}
+
+// SetMultipart sets the multipart body with its Content-Type, Content-Length is recomputed.
+func (msg *message) SetMultipart(multipart *MultipartBody) {
+	msg.SetHeader(&GenericHeader{HeaderName: "Content-Type", Contents: multipart.ContentType()}, true)
+	msg.SetBody(multipart.String())
+}
+
+// contentType returns the value of Content-Type header, if any.
+func (msg *message) contentType() (string, bool) {
+	for _, h := range msg.Headers("Content-Type") {
+		if contentType, ok := h.(*GenericHeader); ok {
+			return contentType.Contents, true
+		}
+	}
+	return "", false
+}
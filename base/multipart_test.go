@@ -0,0 +1,74 @@
+package base
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ghettovoice/gossip/log"
+)
+
+func TestMultipartBody(t *testing.T) {
+	// SDP and ISUP body - RFC 3204 example, LF line endings and binary ISUP with a line break inside.
+	isup := "\x01\x00\x49\x00\x00\x03\x02\x00\x07\x04\x10\n\x00\x33\x63\x21\x43\x00\x00\x03"
+	body := "preamble\n" +
+		"--unique-boundary-1\n" +
+		"Content-Type: application/sdp\n" +
+		"\n" +
+		"v=0\n" +
+		"o=ali 1122334455 3344556677 IN IP4 host.wcom.com\n" +
+		"s=-\n" +
+		"c=IN IP4 host.wcom.com\n" +
+		"t=0 0\n" +
+		"m=audio 49230 RTP/AVP 0\n" +
+		"\n" +
+		"--unique-boundary-1\n" +
+		"content-type: application/ISUP;version=nxv3;base=etsi121\n" +
+		"Content-Disposition: signal;handling=optional\n" +
+		"\n" +
+		isup + "\n" +
+		"--unique-boundary-1--\n"
+
+	uri := &SipUri{User: String{"bob"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	invite := NewRequest(INVITE, uri, "SIP/2.0", []SipHeader{
+		&GenericHeader{"Content-Type", "multipart/mixed; boundary=unique-boundary-1"},
+	}, body, log.StandardLogger())
+
+	multipart, err := invite.Multipart()
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse multipart body: %s", err)
+	}
+	if len(multipart.Parts) != 2 {
+		t.Fatalf("[FAIL] expected 2 body parts, got %d", len(multipart.Parts))
+	}
+	part := multipart.Part("application/isup")
+	if part == nil || part.Body != isup || part.ContentDisposition() != "signal;handling=optional" {
+		t.Errorf("[FAIL] unexpected ISUP part %v", part)
+	}
+	session, err := invite.SDP()
+	if err != nil || session.Media[0].Port != 49230 {
+		t.Errorf("[FAIL] failed to get SDP of the multipart body: %v", err)
+	}
+
+	pidf := NewBodyPart(ContentTypePidf, (&PidfLoPoint{Entity: "pres:alice@example.com"}).Body())
+	pidf.Header["Content-Id"] = "<target123@atlanta.example.com>"
+	multipart.Parts = append(multipart.Parts, pidf)
+	invite.SetMultipart(multipart)
+
+	if length := invite.Headers("Content-Length"); len(length) != 1 || length[0].String() != fmt.Sprintf("Content-Length: %d", len(invite.Body())) {
+		t.Errorf("[FAIL] Content-Length %v doesn't match the body length %d", length, len(invite.Body()))
+	}
+	reparsed, err := invite.Multipart()
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse serialized multipart body: %s", err)
+	}
+	if len(reparsed.Parts) != 3 || reparsed.Parts[1].Body != isup {
+		t.Fatalf("[FAIL] body parts changed by round trip:\n%s", invite.Body())
+	}
+	if id := reparsed.Part(ContentTypePidf).ContentId(); id != "target123@atlanta.example.com" {
+		t.Errorf("[FAIL] unexpected Content-ID of PIDF-LO part: %s", id)
+	}
+
+	if _, err := ParseMultipartBody("multipart/mixed;boundary=x", "--x\r\n\r\nunterminated"); err == nil {
+		t.Errorf("[FAIL] expected error parsing multipart body without close delimiter")
+	}
+}
@@ -0,0 +1,125 @@
+package base
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UserPhone is the value of user URI parameter marking the user part as a telephone number - RFC 3261 19.1.1.
+const UserPhone = "phone"
+
+// Visual separators of telephone numbers - RFC 3966 3.
+const visualSeparators = "-.()"
+
+// IsPhone reports whether the user part of the URI is a telephone number, the URI has user=phone parameter.
+func (uri *SipUri) IsPhone() bool {
+	if uri.UriParams == nil {
+		return false
+	}
+	user, ok := uri.UriParams.Get("user")
+	if !ok {
+		return false
+	}
+	value, ok := user.(String)
+	return ok && strings.EqualFold(value.S, UserPhone)
+}
+
+// IsTelUri reports whether the URI is tel URI - RFC 3966.
+func IsTelUri(uri Uri) bool {
+	absUri, ok := uri.(*AbsoluteUri)
+	return ok && strings.EqualFold(absUri.Scheme, "tel")
+}
+
+// PhoneNumber returns the telephone-subscriber of tel URI or of the user part of SIP URI with user=phone,
+// including its parameters, e.g. +1-555-1234;ext=22. ok is false if the URI carries no telephone number.
+func PhoneNumber(uri Uri) (number string, ok bool) {
+	switch u := uri.(type) {
+	case *AbsoluteUri:
+		if IsTelUri(u) {
+			return u.Opaque, true
+		}
+	case *SipUri:
+		if !u.IsPhone() {
+			return "", false
+		}
+		if user, ok := u.User.(String); ok {
+			return user.S, true
+		}
+	}
+	return "", false
+}
+
+// PrefixRule rewrites the numbers starting with the prefix, e.g. the international prefix 00 to +
+// or the national prefix 0 to +44.
+type PrefixRule struct {
+	Prefix      string
+	Replacement string
+}
+
+// NormalizeNumber strips the parameters and the visual separators from the telephone number,
+// rewrites it by the longest matching prefix rule and checks the result is a global E.164 number - RFC 3966 5.1.1.
+// E.g. 0 (20) 7946-0018 becomes +442079460018 by the rule 0 to +44.
+func NormalizeNumber(number string, rules ...PrefixRule) (string, error) {
+	digits := strings.SplitN(number, ";", 2)[0]
+	var buffer strings.Builder
+	for idx, ch := range digits {
+		switch {
+		case ch == '+' && idx == 0:
+			buffer.WriteRune(ch)
+		case ch >= '0' && ch <= '9':
+			buffer.WriteRune(ch)
+		case strings.ContainsRune(visualSeparators, ch):
+		default:
+			return "", fmt.Errorf("invalid character %q in telephone number '%s'", ch, number)
+		}
+	}
+
+	normalized := buffer.String()
+	var best *PrefixRule
+	for idx := range rules {
+		rule := &rules[idx]
+		if strings.HasPrefix(normalized, rule.Prefix) && (best == nil || len(rule.Prefix) > len(best.Prefix)) {
+			best = rule
+		}
+	}
+	if best != nil {
+		normalized = best.Replacement + normalized[len(best.Prefix):]
+	}
+
+	if !strings.HasPrefix(normalized, "+") || len(normalized) < 2 || len(normalized) > 16 ||
+		strings.Contains(normalized[1:], "+") {
+		return "", fmt.Errorf("'%s' is not a global E.164 number", number)
+	}
+	return normalized, nil
+}
+
+// TelToSip converts tel URI to SIP URI of the host with user=phone,
+// the telephone-subscriber becomes the user part as is - RFC 3261 19.1.6.
+func TelToSip(uri Uri, host string) (*SipUri, error) {
+	if !IsTelUri(uri) {
+		return nil, fmt.Errorf("URI %v is not tel URI", uri)
+	}
+	params := NewParams()
+	params.Add("user", String{UserPhone})
+	return &SipUri{
+		User:      String{uri.(*AbsoluteUri).Opaque},
+		Password:  NoString{},
+		Host:      host,
+		UriParams: params,
+		Headers:   NewParams(),
+	}, nil
+}
+
+// SipToTel converts SIP URI with user=phone to tel URI of its user part - RFC 3261 19.1.6.
+// The host, the port and the other parameters of the SIP URI are dropped.
+func SipToTel(uri Uri) (*AbsoluteUri, error) {
+	sipUri, ok := uri.(*SipUri)
+	if !ok {
+		return nil, fmt.Errorf("URI %v is not SIP URI", uri)
+	}
+	number, ok := PhoneNumber(sipUri)
+	if !ok {
+		return nil, fmt.Errorf("URI %v has no telephone number", uri)
+	}
+	return &AbsoluteUri{Scheme: "tel", Opaque: number}, nil
+}
@@ -0,0 +1,68 @@
+package base
+
+import (
+	"testing"
+)
+
+func TestNormalizeNumber(t *testing.T) {
+	rules := []PrefixRule{{Prefix: "00", Replacement: "+"}, {Prefix: "0", Replacement: "+44"}}
+	for number, expected := range map[string]string{
+		"+1-555-123.4":       "+15551234",
+		"+1 (555) 123-4;x=1": "",
+		"+1(555)123-4;ext=1": "+15551234",
+		"0 20 7946 0018":     "",
+		"0(20)7946-0018":     "+442079460018",
+		"0049-30-1234":       "+49301234",
+		"5551234":            "",
+		"+":                  "",
+		"+1555abc":           "",
+		"0+1555":             "",
+	} {
+		normalized, err := NormalizeNumber(number, rules...)
+		switch {
+		case expected == "" && err == nil:
+			t.Errorf("[FAIL] expected '%s' rejected, got %s", number, normalized)
+		case expected != "" && err != nil:
+			t.Errorf("[FAIL] unexpected error normalizing '%s': %s", number, err)
+		case normalized != expected:
+			t.Errorf("[FAIL] expected '%s' normalized to %s, got %s", number, expected, normalized)
+		}
+	}
+}
+
+func TestTelSipConversion(t *testing.T) {
+	tel := &AbsoluteUri{Scheme: "tel", Opaque: "+358-555-1234567;postd=pp22"}
+	if number, ok := PhoneNumber(tel); !ok || number != "+358-555-1234567;postd=pp22" {
+		t.Errorf("[FAIL] unexpected number %s of %s", number, tel)
+	}
+
+	sipUri, err := TelToSip(tel, "foo.com")
+	if err != nil {
+		t.Fatalf("[FAIL] unexpected error: %s", err)
+	}
+	if expected := "sip:+358-555-1234567;postd=pp22@foo.com;user=phone"; sipUri.String() != expected {
+		t.Errorf("[FAIL] expected %s, got %s", expected, sipUri)
+	}
+	if !sipUri.IsPhone() {
+		t.Errorf("[FAIL] expected %s to be phone URI", sipUri)
+	}
+
+	back, err := SipToTel(sipUri)
+	if err != nil {
+		t.Fatalf("[FAIL] unexpected error: %s", err)
+	}
+	if !back.Equals(tel) {
+		t.Errorf("[FAIL] expected %s, got %s", tel, back)
+	}
+
+	plain := &SipUri{User: String{"+15551234"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	if plain.IsPhone() {
+		t.Errorf("[FAIL] unexpected phone URI %s", plain)
+	}
+	if _, err := SipToTel(plain); err == nil {
+		t.Errorf("[FAIL] expected %s without user=phone rejected", plain)
+	}
+	if _, err := TelToSip(plain, "foo.com"); err == nil {
+		t.Errorf("[FAIL] expected SIP URI rejected as tel URI")
+	}
+}
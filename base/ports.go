@@ -0,0 +1,118 @@
+package base
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default ports of the transports, used when URIs and Via hops omit the port - RFC 3261 19.1.2, 18.2.2,
+// RFC 7118 for WebSocket. Transports not listed default to 5060.
+var (
+	defaultPorts = map[string]uint16{
+		"UDP":  5060,
+		"TCP":  5060,
+		"SCTP": 5060,
+		"TLS":  5061,
+		"WS":   80,
+		"WSS":  443,
+	}
+	defaultPortsLock sync.RWMutex
+)
+
+// DefaultPort returns the port of the transport, e.g. UDP or TLS, used when the port is omitted.
+func DefaultPort(transport string) uint16 {
+	defaultPortsLock.RLock()
+	defer defaultPortsLock.RUnlock()
+	if port, ok := defaultPorts[strings.ToUpper(transport)]; ok {
+		return port
+	}
+	return 5060
+}
+
+// SetDefaultPort overrides the default port of the transport for nonstandard deployments,
+// e.g. WS served on 8080. Should be called before the stack starts sending and receiving messages.
+func SetDefaultPort(transport string, port uint16) {
+	defaultPortsLock.Lock()
+	defer defaultPortsLock.Unlock()
+	defaultPorts[strings.ToUpper(transport)] = port
+}
+
+// UriTransport returns the transport of the SIP URI: its transport parameter, UDP by default;
+// SIPS URIs default to TLS and turn WS to WSS - RFC 3261 19.1.2, RFC 7118 5.2.
+func UriTransport(uri *SipUri) string {
+	transport := "UDP"
+	if uri.UriParams != nil {
+		if param, ok := uri.UriParams.Get("transport"); ok && param != nil && param.String() != "" {
+			transport = strings.ToUpper(param.String())
+		}
+	}
+	if uri.IsEncrypted {
+		switch transport {
+		case "UDP", "TCP":
+			transport = "TLS"
+		case "WS":
+			transport = "WSS"
+		}
+	}
+	return transport
+}
+
+// UriPort returns the port of the SIP URI, the default port of its transport if omitted.
+func UriPort(uri *SipUri) uint16 {
+	if uri.Port != nil && *uri.Port != 0 {
+		return *uri.Port
+	}
+	return DefaultPort(UriTransport(uri))
+}
+
+// FormatHost returns the host as written in URIs and Via sent-by: IPv6 addresses enclosed in brackets,
+// e.g. [2001:db8::1] - RFC 3261 25.1, RFC 5118 4.1. The hosts of the parsed messages are kept without them.
+func FormatHost(host string) string {
+	if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// HostAddr returns host:port of the host, with or without brackets, and the port, e.g. [2001:db8::1]:5060,
+// suitable for the transports.
+func HostAddr(host string, port uint16) string {
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), strconv.Itoa(int(port)))
+}
+
+// UriAddr returns host:port the SIP URI points to, with the default port of its transport if omitted.
+func UriAddr(uri *SipUri) string {
+	return HostAddr(uri.Host, UriPort(uri))
+}
+
+// HopPort returns the sent-by port of the Via hop, the default port of its transport if omitted - RFC 3261 18.2.2.
+func HopPort(hop *ViaHop) uint16 {
+	if hop.Port != nil && *hop.Port != 0 {
+		return *hop.Port
+	}
+	return DefaultPort(hop.Transport)
+}
+
+// HopAddr returns the sent-by host:port of the Via hop, with the default port of its transport if omitted.
+func HopAddr(hop *ViaHop) string {
+	return HostAddr(hop.Host, HopPort(hop))
+}
+
+// ResponseAddr returns host:port the responses are sent to by the top Via hop of the request - RFC 3261 18.2.2, RFC 3581 4:
+// the host of its received parameter if there is one, otherwise the sent-by host;
+// the port of its rport parameter if it has a value, otherwise the sent-by port.
+func ResponseAddr(hop *ViaHop) string {
+	host := hop.Host
+	if received, ok := hop.Params.Get("received"); ok && received != nil && received.String() != "" {
+		host = received.String()
+	}
+	port := HopPort(hop)
+	if rport, ok := hop.Params.Get("rport"); ok && rport != nil {
+		if value, err := strconv.ParseUint(rport.String(), 10, 16); err == nil && value != 0 {
+			port = uint16(value)
+		}
+	}
+	return HostAddr(host, port)
+}
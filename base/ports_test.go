@@ -0,0 +1,74 @@
+package base
+
+import "testing"
+
+func TestDefaultPorts(t *testing.T) {
+	port := uint16(5080)
+	cases := []struct {
+		uri  *SipUri
+		addr string
+	}{
+		{&SipUri{Host: "example.com"}, "example.com:5060"},
+		{&SipUri{Host: "example.com", Port: &port}, "example.com:5080"},
+		{&SipUri{IsEncrypted: true, Host: "example.com", UriParams: NewParams()}, "example.com:5061"},
+		{&SipUri{Host: "example.com", UriParams: NewParams().Add("transport", String{"ws"})}, "example.com:80"},
+		{&SipUri{IsEncrypted: true, Host: "example.com", UriParams: NewParams().Add("transport", String{"ws"})}, "example.com:443"},
+	}
+	for _, c := range cases {
+		if addr := UriAddr(c.uri); addr != c.addr {
+			t.Errorf("[FAIL] expected %s address %s, got %s", c.uri, c.addr, addr)
+		}
+	}
+
+	if addr := HopAddr(NewViaHop("TLS", "example.com", 0, "")); addr != "example.com:5061" {
+		t.Errorf("[FAIL] expected TLS hop address example.com:5061, got %s", addr)
+	}
+
+	SetDefaultPort("ws", 8080)
+	defer SetDefaultPort("WS", 80)
+	if addr := HopAddr(NewViaHop("WS", "example.com", 0, "")); addr != "example.com:8080" {
+		t.Errorf("[FAIL] expected overridden WS hop address example.com:8080, got %s", addr)
+	}
+}
+
+func TestResponseAddr(t *testing.T) {
+	cases := []struct {
+		hop  *ViaHop
+		addr string
+	}{
+		{NewViaHop("UDP", "client.example.com", 5070, ""), "client.example.com:5070"},
+		{NewViaHop("UDP", "client.example.com", 0, "").WithRport(), "client.example.com:5060"},
+		{withParams(NewViaHop("UDP", "client.example.com", 5070, ""), "received", "10.0.0.1"), "10.0.0.1:5070"},
+		{withParams(NewViaHop("UDP", "client.example.com", 5070, ""), "received", "10.0.0.1", "rport", "40000"), "10.0.0.1:40000"},
+		{withParams(NewViaHop("TLS", "client.example.com", 0, ""), "received", "10.0.0.1"), "10.0.0.1:5061"},
+		{withParams(NewViaHop("UDP", "2001:db8::1", 5070, ""), "received", "2001:db8::2", "rport", "40000"), "[2001:db8::2]:40000"},
+		{withParams(NewViaHop("UDP", "2001:db8::1", 5070, ""), "received", "[2001:db8::2]"), "[2001:db8::2]:5070"},
+	}
+	for _, c := range cases {
+		if addr := ResponseAddr(c.hop); addr != c.addr {
+			t.Errorf("[FAIL] expected response address of %s %s, got %s", c.hop, c.addr, addr)
+		}
+	}
+}
+
+func withParams(hop *ViaHop, params ...string) *ViaHop {
+	for i := 0; i+1 < len(params); i += 2 {
+		hop.Params.Add(params[i], String{params[i+1]})
+	}
+	return hop
+}
+
+func TestIPv6Hosts(t *testing.T) {
+	port := uint16(5080)
+	uri := &SipUri{User: String{"bob"}, Host: "2001:db8::1", Port: &port}
+	if uri.String() != "sip:bob@[2001:db8::1]:5080" || UriAddr(uri) != "[2001:db8::1]:5080" {
+		t.Errorf("[FAIL] unexpected IPv6 URI %s, address %s", uri, UriAddr(uri))
+	}
+	hop := NewViaHop("TCP", "[2001:db8::1]", 0, "z9hG4bK1")
+	if hop.String() != "SIP/2.0/TCP [2001:db8::1];branch=z9hG4bK1" || HopAddr(hop) != "[2001:db8::1]:5060" {
+		t.Errorf("[FAIL] unexpected IPv6 Via hop %s, address %s", hop, HopAddr(hop))
+	}
+	if FormatHost("192.0.2.1") != "192.0.2.1" || FormatHost("example.com") != "example.com" {
+		t.Errorf("[FAIL] hosts other than IPv6 addresses are formatted")
+	}
+}
@@ -0,0 +1,134 @@
+package base
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Communications resource priority - RFC 4412.
+
+// ResourcePriority is a single r-value: namespace and priority value, e.g. dsn.flash.
+type ResourcePriority struct {
+	Namespace string
+	Priority  string
+}
+
+// Priority values of the namespaces registered in RFC 4412 12.6, lowest first.
+var resourcePriorityNamespaces = map[string][]string{
+	"dsn":  {"routine", "priority", "immediate", "flash", "flash-override"},
+	"drsn": {"routine", "priority", "immediate", "flash", "flash-override", "flash-override-override"},
+	"q735": {"4", "3", "2", "1", "0"},
+	"ets":  {"4", "3", "2", "1", "0"},
+	"wps":  {"4", "3", "2", "1", "0"},
+}
+
+// ParseResourcePriority parses r-value of namespace.priority form.
+func ParseResourcePriority(value string) (ResourcePriority, error) {
+	value = strings.TrimSpace(value)
+	idx := strings.LastIndex(value, ".")
+	if idx <= 0 || idx == len(value)-1 {
+		return ResourcePriority{}, fmt.Errorf("invalid resource priority value '%s'", value)
+	}
+
+	// Namespaces and priorities are case-insensitive - RFC 4412 3.1.
+	return ResourcePriority{
+		Namespace: strings.ToLower(value[:idx]),
+		Priority:  strings.ToLower(value[idx+1:]),
+	}, nil
+}
+
+func (rp ResourcePriority) String() string {
+	return rp.Namespace + "." + rp.Priority
+}
+
+// IsKnown reports whether the namespace and the priority value are registered ones.
+func (rp ResourcePriority) IsKnown() bool {
+	return rp.level() >= 0
+}
+
+// Compare compares priorities of the same namespace,
+// returns -1, 0 or 1 if rp is lower, equal or higher than other.
+// The second result is false if the values are not comparable.
+func (rp ResourcePriority) Compare(other ResourcePriority) (int, bool) {
+	if rp.Namespace != other.Namespace {
+		return 0, false
+	}
+	a, b := rp.level(), other.level()
+	if a < 0 || b < 0 {
+		return 0, false
+	}
+
+	switch {
+	case a < b:
+		return -1, true
+	case a > b:
+		return 1, true
+	default:
+		return 0, true
+	}
+}
+
+// level returns index of the priority within the namespace or -1 if unknown.
+func (rp ResourcePriority) level() int {
+	for idx, priority := range resourcePriorityNamespaces[rp.Namespace] {
+		if priority == rp.Priority {
+			return idx
+		}
+	}
+	return -1
+}
+
+// ResourcePriorityHeader lists the resource priorities of the request - RFC 4412 3.1.
+type ResourcePriorityHeader struct {
+	Values []ResourcePriority
+}
+
+func (header *ResourcePriorityHeader) String() string {
+	return "Resource-Priority: " + joinResourcePriorities(header.Values)
+}
+
+func (header *ResourcePriorityHeader) Name() string { return "Resource-Priority" }
+
+func (header *ResourcePriorityHeader) Copy() SipHeader {
+	return &ResourcePriorityHeader{copyResourcePriorities(header.Values)}
+}
+
+// AcceptResourcePriorityHeader lists the resource priorities the server accepts - RFC 4412 3.2.
+type AcceptResourcePriorityHeader struct {
+	Values []ResourcePriority
+}
+
+func (header *AcceptResourcePriorityHeader) String() string {
+	return "Accept-Resource-Priority: " + joinResourcePriorities(header.Values)
+}
+
+func (header *AcceptResourcePriorityHeader) Name() string { return "Accept-Resource-Priority" }
+
+func (header *AcceptResourcePriorityHeader) Copy() SipHeader {
+	return &AcceptResourcePriorityHeader{copyResourcePriorities(header.Values)}
+}
+
+// ResourcePriorities returns all r-values from Resource-Priority headers of the message.
+func ResourcePriorities(msg SipMessage) []ResourcePriority {
+	values := make([]ResourcePriority, 0)
+	for _, h := range msg.Headers("Resource-Priority") {
+		if header, ok := h.(*ResourcePriorityHeader); ok {
+			values = append(values, header.Values...)
+		}
+	}
+	return values
+}
+
+func joinResourcePriorities(values []ResourcePriority) string {
+	strs := make([]string, len(values))
+	for idx, value := range values {
+		strs[idx] = value.String()
+	}
+	return strings.Join(strs, ", ")
+}
+
+func copyResourcePriorities(values []ResourcePriority) []ResourcePriority {
+	dup := make([]ResourcePriority, len(values))
+	copy(dup, values)
+	return dup
+}
@@ -0,0 +1,75 @@
+package base
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Call transfer and other referrals - RFC 3515.
+
+// Content type of NOTIFY bodies reporting the progress of the referred request - RFC 3515 2.4.5.
+const ContentTypeSipfrag = "message/sipfrag;version=2.0"
+
+// Event package of the implicit subscription created by REFER - RFC 3515 2.4.4.
+const EventRefer = "refer"
+
+// ReferToHeader represents Refer-To header, the URI the recipient of REFER is asked to send the request to - RFC 3515 2.1.
+type ReferToHeader struct {
+	// The display name from the header, may be omitted.
+	DisplayName MaybeString
+
+	Address Uri
+
+	// Any parameters present in the header.
+	Params Params
+}
+
+// NewReferToHeader creates Refer-To header of the URI without display name and parameters.
+func NewReferToHeader(uri Uri) *ReferToHeader {
+	return &ReferToHeader{DisplayName: NoString{}, Address: uri, Params: NewParams()}
+}
+
+// Refer-To URI is always enclosed in angle brackets, it may carry parameters and headers of the referred request.
+func (header *ReferToHeader) String() string {
+	return "Refer-To: " + nameAddr(header.DisplayName, header.Address, header.Params)
+}
+
+func (header *ReferToHeader) Name() string { return "Refer-To" }
+
+func (header *ReferToHeader) Copy() SipHeader {
+	return &ReferToHeader{header.DisplayName, header.Address.Copy(), copyParams(header.Params)}
+}
+
+// Method returns the method of the referred request, given by method parameter of SIP URI, INVITE by default - RFC 3515 2.4.2.
+func (header *ReferToHeader) Method() Method {
+	if uri, ok := header.Address.(*SipUri); ok && uri.UriParams != nil {
+		if method, ok := uri.UriParams.Get("method"); ok && method != nil && method.String() != "" {
+			return Method(strings.ToUpper(method.String()))
+		}
+	}
+	return INVITE
+}
+
+// ReferTo returns Refer-To header of REFER request, it must have exactly one - RFC 3515 2.4.1.
+func ReferTo(req *Request) (*ReferToHeader, error) {
+	var referTo *ReferToHeader
+	for _, h := range req.Headers("Refer-To") {
+		header, ok := h.(*ReferToHeader)
+		if !ok {
+			continue
+		}
+		if referTo != nil {
+			return nil, fmt.Errorf("request %s has more than one Refer-To header", req.Short())
+		}
+		referTo = header
+	}
+	if referTo == nil {
+		return nil, fmt.Errorf("request %s has no Refer-To header", req.Short())
+	}
+	return referTo, nil
+}
+
+// ReferProgress returns the body of NOTIFY reporting the status of the referred request, e.g. SIP/2.0 200 OK - RFC 3515 2.4.5.
+func ReferProgress(code uint16, reason string) string {
+	return fmt.Sprintf("SIP/2.0 %d %s\r\n", code, reason)
+}
@@ -0,0 +1,212 @@
+package base
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// RouteHeader is Route header holding one route of the route set - RFC 3261 20.34.
+type RouteHeader struct {
+	// The display name from the header, may be omitted.
+	DisplayName MaybeString
+
+	Address Uri
+
+	// Any parameters present in the header.
+	Params Params
+}
+
+// NewRouteHeader creates Route header of the URI without display name and parameters.
+func NewRouteHeader(uri Uri) *RouteHeader {
+	return &RouteHeader{DisplayName: NoString{}, Address: uri, Params: NewParams()}
+}
+
+func (route *RouteHeader) String() string {
+	return "Route: " + nameAddr(route.DisplayName, route.Address, route.Params)
+}
+
+func (route *RouteHeader) Name() string { return "Route" }
+
+// Copy the header.
+func (route *RouteHeader) Copy() SipHeader {
+	return &RouteHeader{route.DisplayName, route.Address.Copy(), copyParams(route.Params)}
+}
+
+// IsLoose reports whether the route is a loose router, the URI has lr parameter - RFC 3261 19.1.1.
+func (route *RouteHeader) IsLoose() bool {
+	return isLooseRouter(route.Address)
+}
+
+// RecordRouteHeader is Record-Route header inserted by a proxy to stay on the path of the dialog - RFC 3261 20.30.
+type RecordRouteHeader struct {
+	// The display name from the header, may be omitted.
+	DisplayName MaybeString
+
+	Address Uri
+
+	// Any parameters present in the header.
+	Params Params
+}
+
+// NewRecordRouteHeader creates Record-Route header of the URI without display name and parameters.
+func NewRecordRouteHeader(uri Uri) *RecordRouteHeader {
+	return &RecordRouteHeader{DisplayName: NoString{}, Address: uri, Params: NewParams()}
+}
+
+func (rr *RecordRouteHeader) String() string {
+	return "Record-Route: " + nameAddr(rr.DisplayName, rr.Address, rr.Params)
+}
+
+func (rr *RecordRouteHeader) Name() string { return "Record-Route" }
+
+// Copy the header.
+func (rr *RecordRouteHeader) Copy() SipHeader {
+	return &RecordRouteHeader{rr.DisplayName, rr.Address.Copy(), copyParams(rr.Params)}
+}
+
+// IsLoose reports whether the proxy is a loose router, the URI has lr parameter - RFC 3261 19.1.1.
+func (rr *RecordRouteHeader) IsLoose() bool {
+	return isLooseRouter(rr.Address)
+}
+
+// Route returns Route header of the same value, an entry of the route set of the dialog.
+func (rr *RecordRouteHeader) Route() *RouteHeader {
+	return &RouteHeader{rr.DisplayName, rr.Address.Copy(), copyParams(rr.Params)}
+}
+
+func nameAddr(displayName MaybeString, uri Uri, params Params) string {
+	var buffer bytes.Buffer
+	switch s := displayName.(type) {
+	case String:
+		buffer.WriteString(fmt.Sprintf("\"%s\" ", s.String()))
+	}
+
+	buffer.WriteString(fmt.Sprintf("<%s>", uri))
+
+	if params != nil && params.Length() > 0 {
+		buffer.WriteString(";")
+		buffer.WriteString(params.ToString(';'))
+	}
+	return buffer.String()
+}
+
+func copyParams(params Params) Params {
+	if params == nil {
+		return NewParams()
+	}
+	return params.Copy()
+}
+
+func isLooseRouter(uri Uri) bool {
+	sipUri, ok := uri.(*SipUri)
+	if !ok || sipUri.UriParams == nil {
+		return false
+	}
+	_, ok = sipUri.UriParams.Get("lr")
+	return ok
+}
+
+// Routes returns the Route headers of the message in order.
+func (msg *message) Routes() []*RouteHeader {
+	routes := make([]*RouteHeader, 0)
+	for _, h := range msg.Headers("Route") {
+		if route, ok := h.(*RouteHeader); ok {
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+// RecordRoutes returns the Record-Route headers of the message in order.
+func (msg *message) RecordRoutes() []*RecordRouteHeader {
+	rrs := make([]*RecordRouteHeader, 0)
+	for _, h := range msg.Headers("Record-Route") {
+		if rr, ok := h.(*RecordRouteHeader); ok {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs
+}
+
+// PushRoute adds the route on top of the Route headers of the request,
+// e.g. of the outbound proxy or the preloaded route set - RFC 3261 16.6 item 7.
+func PushRoute(req *Request, route *RouteHeader) {
+	req.AddFrontHeader(route)
+}
+
+// PopRoute removes the topmost Route header of the request and returns it, nil if there is none.
+// A proxy removes the route of its own before forwarding the request - RFC 3261 16.4.
+func PopRoute(req *Request) *RouteHeader {
+	routes := req.Routes()
+	if len(routes) == 0 {
+		return nil
+	}
+	if err := req.RemoveHeader(routes[0]); err != nil {
+		return nil
+	}
+	return routes[0]
+}
+
+// PushRecordRoute adds the Record-Route header of the proxy on top of the others - RFC 3261 16.6 item 4.
+func PushRecordRoute(req *Request, rr *RecordRouteHeader) {
+	req.AddFrontHeader(rr)
+}
+
+// RecordRouteSet returns the route set of the UAS built of Record-Route headers of the request in order - RFC 3261 12.1.1.
+func RecordRouteSet(msg SipMessage) []*RouteHeader {
+	routes := make([]*RouteHeader, 0)
+	for _, h := range msg.Headers("Record-Route") {
+		if rr, ok := h.(*RecordRouteHeader); ok {
+			routes = append(routes, rr.Route())
+		}
+	}
+	return routes
+}
+
+// ReverseRecordRoutes returns the route set of the UAC built of Record-Route headers of the response
+// in reverse order - RFC 3261 12.1.2.
+func ReverseRecordRoutes(msg SipMessage) []*RouteHeader {
+	routes := RecordRouteSet(msg)
+	for i, j := 0, len(routes)-1; i < j; i, j = i+1, j-1 {
+		routes[i], routes[j] = routes[j], routes[i]
+	}
+	return routes
+}
+
+// ApplyRouteSet sets Request-URI and Route headers of the request sent to the remote target by the route set,
+// replacing the existing Route headers - RFC 3261 12.2.1.1.
+// If the first route is a loose router, Request-URI is the remote target and Route headers are the route set.
+// Otherwise the first route is a strict router and becomes Request-URI without parameters, Route headers are
+// the rest of the route set followed by the remote target.
+func ApplyRouteSet(req *Request, routes []*RouteHeader, target Uri) {
+	for _, route := range req.Routes() {
+		req.RemoveHeader(route)
+	}
+
+	req.Recipient = target.Copy()
+	if len(routes) > 0 && !routes[0].IsLoose() {
+		strict := routes[0].Address.Copy()
+		if sipUri, ok := strict.(*SipUri); ok {
+			sipUri.Headers = NewParams()
+		}
+		req.Recipient = strict
+		routes = append(append([]*RouteHeader(nil), routes[1:]...), NewRouteHeader(target.Copy()))
+	}
+	for _, route := range routes {
+		req.AddHeader(route.Copy())
+	}
+}
+
+// NextHop returns host:port the request is sent to: of the top Route, or of Request-URI if there is none
+// - RFC 3261 8.1.2. The port defaults to the one of the transport of the URI, see UriPort.
+func NextHop(req *Request) (string, error) {
+	uri := req.Recipient
+	if routes := req.Routes(); len(routes) > 0 {
+		uri = routes[0].Address
+	}
+	sipUri, ok := uri.(*SipUri)
+	if !ok {
+		return "", fmt.Errorf("next hop %v of request %s is not SIP URI", uri, req.Short())
+	}
+	return UriAddr(sipUri), nil
+}
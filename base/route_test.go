@@ -0,0 +1,106 @@
+package base
+
+import (
+	"testing"
+
+	"github.com/ghettovoice/gossip/log"
+)
+
+func routeUri(host string, loose bool) *SipUri {
+	uri := &SipUri{Host: host, UriParams: NewParams(), Headers: NewParams()}
+	if loose {
+		uri.UriParams.Add("lr", NoString{})
+	}
+	return uri
+}
+
+func routeStrings(routes []*RouteHeader) []string {
+	strs := make([]string, 0, len(routes))
+	for _, route := range routes {
+		strs = append(strs, route.String())
+	}
+	return strs
+}
+
+func assertRoutes(t *testing.T, routes []*RouteHeader, expected ...string) {
+	got := routeStrings(routes)
+	if len(got) != len(expected) {
+		t.Errorf("[FAIL] expected routes %v, got %v", expected, got)
+		return
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("[FAIL] expected route %d %s, got %s", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestPushPopRoute(t *testing.T) {
+	target := &SipUri{User: String{"bob"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	req := NewRequest(INVITE, target, "SIP/2.0", []SipHeader{NewRouteHeader(routeUri("p2.example.com", true))},
+		"", log.StandardLogger())
+
+	PushRoute(req, NewRouteHeader(routeUri("p1.example.com", true)))
+	assertRoutes(t, req.Routes(), "Route: <sip:p1.example.com;lr>", "Route: <sip:p2.example.com;lr>")
+
+	if route := PopRoute(req); route == nil || route.String() != "Route: <sip:p1.example.com;lr>" {
+		t.Errorf("[FAIL] expected p1 popped, got %v", route)
+	}
+	if route := PopRoute(req); route == nil || !route.IsLoose() {
+		t.Errorf("[FAIL] expected loose p2 popped, got %v", route)
+	}
+	if route := PopRoute(req); route != nil {
+		t.Errorf("[FAIL] expected no route, got %s", route)
+	}
+}
+
+func TestRecordRouteSet(t *testing.T) {
+	target := &SipUri{User: String{"bob"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	req := NewRequest(INVITE, target, "SIP/2.0", []SipHeader{}, "", log.StandardLogger())
+	PushRecordRoute(req, NewRecordRouteHeader(routeUri("p3.example.com", false)))
+	PushRecordRoute(req, NewRecordRouteHeader(routeUri("p2.example.com", true)))
+	PushRecordRoute(req, NewRecordRouteHeader(routeUri("p1.example.com", true)))
+
+	assertRoutes(t, RecordRouteSet(req),
+		"Route: <sip:p1.example.com;lr>", "Route: <sip:p2.example.com;lr>", "Route: <sip:p3.example.com>")
+	assertRoutes(t, ReverseRecordRoutes(req),
+		"Route: <sip:p3.example.com>", "Route: <sip:p2.example.com;lr>", "Route: <sip:p1.example.com;lr>")
+
+	if rrs := req.RecordRoutes(); len(rrs) != 3 || rrs[0].String() != "Record-Route: <sip:p1.example.com;lr>" {
+		t.Errorf("[FAIL] unexpected Record-Route headers %v", rrs)
+	}
+}
+
+func TestApplyRouteSet(t *testing.T) {
+	target := &SipUri{User: String{"bob"}, Host: "192.0.2.4", UriParams: NewParams(), Headers: NewParams()}
+	newRequest := func() *Request {
+		return NewRequest(BYE, target, "SIP/2.0", []SipHeader{NewRouteHeader(routeUri("stale.example.com", true))},
+			"", log.StandardLogger())
+	}
+
+	loose := newRequest()
+	ApplyRouteSet(loose, []*RouteHeader{
+		NewRouteHeader(routeUri("p1.example.com", true)),
+		NewRouteHeader(routeUri("p2.example.com", true)),
+	}, target)
+	if loose.Recipient.String() != "sip:bob@192.0.2.4" {
+		t.Errorf("[FAIL] expected Request-URI of the remote target, got %s", loose.Recipient)
+	}
+	assertRoutes(t, loose.Routes(), "Route: <sip:p1.example.com;lr>", "Route: <sip:p2.example.com;lr>")
+
+	strictUri := routeUri("p1.example.com", false)
+	strictUri.Headers.Add("subject", String{"route"})
+	strict := newRequest()
+	ApplyRouteSet(strict, []*RouteHeader{
+		NewRouteHeader(strictUri),
+		NewRouteHeader(routeUri("p2.example.com", true)),
+	}, target)
+	if strict.Recipient.String() != "sip:p1.example.com" {
+		t.Errorf("[FAIL] expected Request-URI of the strict router, got %s", strict.Recipient)
+	}
+	assertRoutes(t, strict.Routes(), "Route: <sip:p2.example.com;lr>", "Route: <sip:bob@192.0.2.4>")
+
+	direct := newRequest()
+	ApplyRouteSet(direct, nil, target)
+	assertRoutes(t, direct.Routes())
+}
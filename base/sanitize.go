@@ -0,0 +1,127 @@
+package base
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rules checked by Sanitizer.
+const (
+	// SanitizeDuplicateHeader is violated by repeated headers allowed to appear only once, e.g. two Content-Length.
+	SanitizeDuplicateHeader = "duplicate-header"
+	// SanitizeIllegalChar is violated by NUL, CR or LF characters injected into header values.
+	SanitizeIllegalChar = "illegal-char"
+	// SanitizeUriTooLong is violated by Request-URI or address URIs exceeding the length limit.
+	SanitizeUriTooLong = "uri-too-long"
+)
+
+// DefaultMaxUriLength is the URI length limit used by Sanitizer with zero MaxUriLength.
+const DefaultMaxUriLength = 2048
+
+// Headers which must not be repeated in a message - RFC 3261 7.3.1, 20.
+// Conflicting copies let intermediaries and endpoints disagree on how to interpret the message.
+var singleInstanceHeaders = []string{
+	"From",
+	"To",
+	"Call-Id",
+	"CSeq",
+	"Max-Forwards",
+	"Content-Length",
+	"Content-Type",
+}
+
+// SanitizeError describes the rule violated by the message.
+// It is of ErrMalformedMessage kind, and the message should be answered with 400 Bad Request.
+type SanitizeError struct {
+	// Rule is one of the Sanitize* rules above.
+	Rule string
+	// Header is the name of the offending header, or empty for Request-URI.
+	Header string
+	// Msg describes the violation.
+	Msg string
+}
+
+func (err *SanitizeError) Error() string {
+	if err.Header == "" {
+		return fmt.Sprintf("%s: %s", err.Rule, err.Msg)
+	}
+	return fmt.Sprintf("%s: %s %s", err.Rule, err.Header, err.Msg)
+}
+
+// Is reports whether the target is ErrMalformedMessage.
+func (err *SanitizeError) Is(target error) bool {
+	return target == ErrMalformedMessage
+}
+
+// Sanitizer checks received messages against header smuggling tricks,
+// i.e. messages crafted to be interpreted differently by different elements on the path.
+type Sanitizer struct {
+	// MaxUriLength limits the length of Request-URI and From, To and Contact URIs.
+	// Zero means DefaultMaxUriLength, negative disables the check.
+	MaxUriLength int
+}
+
+// Check returns *SanitizeError describing the first violated rule, or nil if the message is clean.
+func (s *Sanitizer) Check(msg SipMessage) error {
+	for _, name := range singleInstanceHeaders {
+		hdrs := msg.Headers(name)
+		if len(hdrs) > 1 {
+			return &SanitizeError{
+				Rule:   SanitizeDuplicateHeader,
+				Header: hdrs[0].Name(),
+				Msg:    fmt.Sprintf("appears %d times", len(hdrs)),
+			}
+		}
+	}
+
+	for _, header := range msg.AllHeaders() {
+		if idx := strings.IndexAny(header.String(), "\x00\r\n"); idx >= 0 {
+			return &SanitizeError{
+				Rule:   SanitizeIllegalChar,
+				Header: header.Name(),
+				Msg:    fmt.Sprintf("contains character %q", header.String()[idx]),
+			}
+		}
+	}
+
+	limit := s.MaxUriLength
+	if limit == 0 {
+		limit = DefaultMaxUriLength
+	}
+	if limit < 0 {
+		return nil
+	}
+
+	if req, ok := msg.(*Request); ok && req.Recipient != nil {
+		if length := len(req.Recipient.String()); length > limit {
+			return &SanitizeError{
+				Rule: SanitizeUriTooLong,
+				Msg:  fmt.Sprintf("Request-URI length %d exceeds %d", length, limit),
+			}
+		}
+	}
+
+	for _, header := range msg.AllHeaders() {
+		var uri Uri
+		switch h := header.(type) {
+		case *FromHeader:
+			uri = h.Address
+		case *ToHeader:
+			uri = h.Address
+		case *ContactHeader:
+			uri = h.Address
+		}
+		if uri == nil {
+			continue
+		}
+		if length := len(uri.String()); length > limit {
+			return &SanitizeError{
+				Rule:   SanitizeUriTooLong,
+				Header: header.Name(),
+				Msg:    fmt.Sprintf("URI length %d exceeds %d", length, limit),
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package base
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ghettovoice/gossip/log"
+)
+
+func TestSanitizer(t *testing.T) {
+	newInvite := func(user string) *Request {
+		uri := &SipUri{User: String{user}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+		from := &FromHeader{Address: uri.Copy(), Params: NewParams()}
+		return NewRequest(INVITE, uri, "SIP/2.0", []SipHeader{from}, "", log.StandardLogger())
+	}
+
+	tests := []struct {
+		name   string
+		msg    *Request
+		rule   string
+		header string
+	}{
+		{"clean", newInvite("bob"), "", ""},
+		{"duplicate Content-Length", newInvite("bob"), SanitizeDuplicateHeader, "Content-Length"},
+		{"duplicate From", newInvite("bob"), SanitizeDuplicateHeader, "From"},
+		{"NUL in header", newInvite("bob"), SanitizeIllegalChar, "Subject"},
+		{"CR in header", newInvite("bob"), SanitizeIllegalChar, "Subject"},
+		{"long Request-URI", newInvite(strings.Repeat("a", DefaultMaxUriLength)), SanitizeUriTooLong, ""},
+	}
+	tests[1].msg.AddHeader(ContentLength(10))
+	tests[2].msg.AddHeader(&FromHeader{Address: tests[2].msg.Recipient.Copy(), Params: NewParams()})
+	tests[3].msg.AddHeader(&GenericHeader{"Subject", "hello\x00world"})
+	tests[4].msg.AddHeader(&GenericHeader{"Subject", "hello\rContent-Length: 10"})
+
+	sanitizer := &Sanitizer{}
+	for _, test := range tests {
+		err := sanitizer.Check(test.msg)
+		if test.rule == "" {
+			if err != nil {
+				t.Errorf("[FAIL] %s: unexpected error %s", test.name, err)
+			}
+			continue
+		}
+
+		serr, ok := err.(*SanitizeError)
+		if !ok {
+			t.Errorf("[FAIL] %s: expected sanitize error, got %v", test.name, err)
+			continue
+		}
+		if serr.Rule != test.rule || serr.Header != test.header {
+			t.Errorf("[FAIL] %s: expected rule %s of header '%s', got %s of '%s'",
+				test.name, test.rule, test.header, serr.Rule, serr.Header)
+		}
+		if !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("[FAIL] %s: error is not of malformed message kind", test.name)
+		}
+	}
+
+	disabled := &Sanitizer{MaxUriLength: -1}
+	if err := disabled.Check(tests[5].msg); err != nil {
+		t.Errorf("[FAIL] URI length check was not disabled: %s", err)
+	}
+}
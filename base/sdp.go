@@ -0,0 +1,49 @@
+package base
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghettovoice/gossip/sdp"
+)
+
+// SDP parses the session description of the message body - RFC 3264.
+// The session description may be a part of multipart body, e.g. along with ISUP or PIDF-LO - RFC 5621.
+// Fails if the message has no body or the body is of another content type.
+func (msg *message) SDP() (*sdp.Session, error) {
+	if msg.Body() == "" {
+		return nil, fmt.Errorf("message has no body")
+	}
+	contentType, ok := msg.contentType()
+	if !ok || isContentType(contentType, sdp.ContentType) {
+		return sdp.Parse(msg.Body())
+	}
+	if !isContentType(contentType, "multipart/*") {
+		return nil, fmt.Errorf("message body is of content type %s", contentType)
+	}
+
+	multipart, err := ParseMultipartBody(contentType, msg.Body())
+	if err != nil {
+		return nil, err
+	}
+	part := multipart.Part(sdp.ContentType)
+	if part == nil {
+		return nil, fmt.Errorf("multipart body has no %s part", sdp.ContentType)
+	}
+	return sdp.Parse(part.Body)
+}
+
+// SetSDP sets the session description as the message body with Content-Type: application/sdp.
+func (msg *message) SetSDP(session *sdp.Session) {
+	msg.SetHeader(&GenericHeader{HeaderName: "Content-Type", Contents: sdp.ContentType}, true)
+	msg.SetBody(session.String())
+}
+
+// isContentType compares the media type ignoring parameters and case, type/* matches all subtypes.
+func isContentType(contentType string, mediaType string) bool {
+	actual := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	if strings.HasSuffix(mediaType, "/*") {
+		return len(actual) > len(mediaType)-1 && strings.EqualFold(actual[:len(mediaType)-1], mediaType[:len(mediaType)-1])
+	}
+	return strings.EqualFold(actual, mediaType)
+}
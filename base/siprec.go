@@ -0,0 +1,191 @@
+package base
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	"github.com/ghettovoice/gossip/sdp"
+)
+
+// Session recording - RFC 7866, metadata model - RFC 7865.
+
+// Option tag of the session recording protocol, required by the recording sessions - RFC 7866 6.1.1.
+const OptionTagSiprec = "siprec"
+
+// Feature tags in Contact header of the recording client and server - RFC 7866 6.1.
+const (
+	ContactSrc = "+sip.src"
+	ContactSrs = "+sip.srs"
+)
+
+// Content type and disposition of the recording metadata body part - RFC 7866 9.1.
+const (
+	ContentTypeRecordingMetadata = "application/rs-metadata+xml"
+	DispositionRecordingSession  = "recording-session"
+)
+
+// Namespace of the recording metadata document - RFC 7865 8.
+const RecordingMetadataNamespace = "urn:ietf:params:xml:ns:recording:1"
+
+// Values of datamode element of the recording metadata - RFC 7865 6.1.1.
+const (
+	RecordingDataComplete = "complete"
+	RecordingDataPartial  = "partial"
+)
+
+// IsSrc reports whether the contact has +sip.src feature tag of the recording client.
+func (contact *ContactHeader) IsSrc() bool {
+	return contact.HasFeature(ContactSrc)
+}
+
+// SetSrc adds +sip.src feature tag of the recording client, e.g. to the Contact of INVITE of the recording session.
+func (contact *ContactHeader) SetSrc() {
+	contact.SetFeature(ContactSrc)
+}
+
+// IsSrs reports whether the contact has +sip.srs feature tag of the recording server.
+func (contact *ContactHeader) IsSrs() bool {
+	return contact.HasFeature(ContactSrs)
+}
+
+// SetSrs adds +sip.srs feature tag of the recording server.
+func (contact *ContactHeader) SetSrs() {
+	contact.SetFeature(ContactSrs)
+}
+
+// IsRecordingSession reports whether INVITE starts the recording session:
+// it requires siprec and its Contact has +sip.src feature tag - RFC 7866 6.1.1.
+func IsRecordingSession(req *Request) bool {
+	if !req.IsInvite() || !HasOptionTag(req, "Require", OptionTagSiprec) {
+		return false
+	}
+	for _, contact := range req.Contacts() {
+		if contact.IsSrc() {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordingMetadata is the metadata of the recording session: the recorded communication sessions,
+// their participants and media streams, and the associations between them - RFC 7865 6.
+type RecordingMetadata struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:recording:1 recording"`
+	// DataMode is RecordingDataComplete or RecordingDataPartial, the latter updates the previously sent metadata.
+	DataMode                 string                             `xml:"datamode,omitempty"`
+	Sessions                 []RecordingSessionMetadata         `xml:"session"`
+	Participants             []RecordingParticipant             `xml:"participant"`
+	Streams                  []RecordingStream                  `xml:"stream"`
+	SessionRecordingAssocs   []RecordingSessionAssociation      `xml:"sessionrecordingassoc"`
+	ParticipantSessionAssocs []RecordingParticipantSessionAssoc `xml:"participantsessionassoc"`
+	ParticipantStreamAssocs  []RecordingParticipantStreamAssoc  `xml:"participantstreamassoc"`
+}
+
+// RecordingSessionMetadata is the recorded communication session - RFC 7865 6.3.
+type RecordingSessionMetadata struct {
+	Id string `xml:"session_id,attr"`
+	// SipSessionIds are Session-ID header values of the recorded SIP sessions - RFC 7989.
+	SipSessionIds []string   `xml:"sipSessionID"`
+	StartTime     *time.Time `xml:"start-time,omitempty"`
+	EndTime       *time.Time `xml:"end-time,omitempty"`
+}
+
+// RecordingParticipant is the participant of the recorded sessions - RFC 7865 6.5.
+type RecordingParticipant struct {
+	Id      string            `xml:"participant_id,attr"`
+	NameIds []RecordingNameId `xml:"nameID"`
+}
+
+// RecordingNameId is the address of record and the display name of the participant.
+type RecordingNameId struct {
+	Aor  string `xml:"aor,attr"`
+	Name string `xml:"name,omitempty"`
+}
+
+// RecordingStream is the media stream of the recorded session, labeled by a=label of SDP of the recording session - RFC 7865 6.6.
+type RecordingStream struct {
+	Id        string `xml:"stream_id,attr"`
+	SessionId string `xml:"session_id,attr"`
+	Label     string `xml:"label"`
+}
+
+// RecordingSessionAssociation tells when the session was recorded by the recording session - RFC 7865 6.4.
+type RecordingSessionAssociation struct {
+	SessionId        string     `xml:"session_id,attr"`
+	AssociateTime    *time.Time `xml:"associate-time,omitempty"`
+	DisassociateTime *time.Time `xml:"disassociate-time,omitempty"`
+}
+
+// RecordingParticipantSessionAssoc tells when the participant took part in the session - RFC 7865 6.7.
+type RecordingParticipantSessionAssoc struct {
+	ParticipantId    string     `xml:"participant_id,attr"`
+	SessionId        string     `xml:"session_id,attr"`
+	AssociateTime    *time.Time `xml:"associate-time,omitempty"`
+	DisassociateTime *time.Time `xml:"disassociate-time,omitempty"`
+}
+
+// RecordingParticipantStreamAssoc lists the streams the participant sends and receives by their IDs - RFC 7865 6.8.
+type RecordingParticipantStreamAssoc struct {
+	ParticipantId string   `xml:"participant_id,attr"`
+	Send          []string `xml:"send"`
+	Recv          []string `xml:"recv"`
+}
+
+// NewRecordingMetadataId generates the identifier of the metadata element, base64 encoded 128 bit random value - RFC 7865 6.9.
+func NewRecordingMetadataId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return base64.StdEncoding.EncodeToString(id)
+}
+
+// Body renders the metadata document, to be sent with ContentTypeRecordingMetadata.
+func (metadata *RecordingMetadata) Body() string {
+	// The model has no values failing to marshal.
+	data, _ := xml.MarshalIndent(metadata, "", "  ")
+	return xml.Header + string(data) + "\r\n"
+}
+
+// ParseRecordingMetadata parses the recording metadata document.
+func ParseRecordingMetadata(body string) (*RecordingMetadata, error) {
+	metadata := &RecordingMetadata{}
+	if err := xml.Unmarshal([]byte(body), metadata); err != nil {
+		return nil, fmt.Errorf("invalid recording metadata: %s", err)
+	}
+	return metadata, nil
+}
+
+// NewRecordingSessionBody builds the body of INVITE of the recording session: the session description
+// and the recording metadata with Content-Disposition: recording-session - RFC 7866 9.1. See SetMultipart.
+func NewRecordingSessionBody(session *sdp.Session, metadata *RecordingMetadata) *MultipartBody {
+	metadataPart := NewBodyPart(ContentTypeRecordingMetadata, metadata.Body())
+	metadataPart.Header["Content-Disposition"] = DispositionRecordingSession
+	return NewMultipartBody(NewBodyPart(sdp.ContentType, session.String()), metadataPart)
+}
+
+// RecordingMetadata parses the recording metadata of the message body, either the whole body,
+// e.g. of UPDATE sending only the metadata, or its part along with the session description - RFC 7866 9.
+func (msg *message) RecordingMetadata() (*RecordingMetadata, error) {
+	contentType, ok := msg.contentType()
+	if !ok {
+		return nil, fmt.Errorf("message has no Content-Type")
+	}
+	if isContentType(contentType, ContentTypeRecordingMetadata) {
+		return ParseRecordingMetadata(msg.Body())
+	}
+	if !isContentType(contentType, "multipart/*") {
+		return nil, fmt.Errorf("message body is of content type %s", contentType)
+	}
+
+	multipart, err := ParseMultipartBody(contentType, msg.Body())
+	if err != nil {
+		return nil, err
+	}
+	part := multipart.Part(ContentTypeRecordingMetadata)
+	if part == nil {
+		return nil, fmt.Errorf("multipart body has no %s part", ContentTypeRecordingMetadata)
+	}
+	return ParseRecordingMetadata(part.Body)
+}
@@ -0,0 +1,100 @@
+package base
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/sdp"
+)
+
+func TestRecordingSession(t *testing.T) {
+	uri := &SipUri{User: String{"srs"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	contact := NewContactHeader(&SipUri{User: String{"src"}, Host: "192.0.2.1", UriParams: NewParams(), Headers: NewParams()})
+	contact.SetSrc()
+	require := &GenericHeader{HeaderName: "Require", Contents: "timer, " + OptionTagSiprec}
+	invite := NewRequest(INVITE, uri, "SIP/2.0", []SipHeader{contact, require}, "", log.StandardLogger())
+
+	if !contact.IsSrc() || contact.IsSrs() {
+		t.Errorf("[FAIL] unexpected feature tags of %s", contact)
+	}
+	if !strings.HasSuffix(contact.String(), ";"+ContactSrc) {
+		t.Errorf("[FAIL] expected %s feature tag without value, got %s", ContactSrc, contact)
+	}
+	if tags := OptionTags(invite, "Require"); len(tags) != 2 || tags[0] != "timer" {
+		t.Errorf("[FAIL] unexpected option tags %v", tags)
+	}
+	if !IsRecordingSession(invite) {
+		t.Errorf("[FAIL] expected INVITE to start the recording session")
+	}
+	invite.SetHeader(&SupportedHeader{Options: []string{"SIPREC"}}, true)
+	if !HasOptionTag(invite, "Supported", OptionTagSiprec) {
+		t.Errorf("[FAIL] expected typed Supported header to list %s ignoring case", OptionTagSiprec)
+	}
+	invite.RemoveHeader(require)
+	if IsRecordingSession(invite) {
+		t.Errorf("[FAIL] INVITE not requiring %s is reported as the recording session", OptionTagSiprec)
+	}
+
+	start := time.Date(2010, 12, 16, 23, 41, 7, 0, time.UTC)
+	sessionId, aliceId, streamId := NewRecordingMetadataId(), NewRecordingMetadataId(), NewRecordingMetadataId()
+	metadata := &RecordingMetadata{
+		DataMode:               RecordingDataComplete,
+		Sessions:               []RecordingSessionMetadata{{Id: sessionId, SipSessionIds: []string{"ab30317f1a784dc48ff824d0d3715d86"}, StartTime: &start}},
+		Participants:           []RecordingParticipant{{Id: aliceId, NameIds: []RecordingNameId{{Aor: "sip:alice@atlanta.example.com", Name: "Alice"}}}},
+		Streams:                []RecordingStream{{Id: streamId, SessionId: sessionId, Label: "96"}},
+		SessionRecordingAssocs: []RecordingSessionAssociation{{SessionId: sessionId, AssociateTime: &start}},
+		ParticipantStreamAssocs: []RecordingParticipantStreamAssoc{
+			{ParticipantId: aliceId, Send: []string{streamId}},
+		},
+	}
+	if len(sessionId) != 24 || sessionId == aliceId {
+		t.Errorf("[FAIL] unexpected metadata IDs %s, %s", sessionId, aliceId)
+	}
+	if !strings.Contains(metadata.Body(), `<recording xmlns="`+RecordingMetadataNamespace+`">`) {
+		t.Errorf("[FAIL] unexpected metadata document:\n%s", metadata.Body())
+	}
+
+	session := &sdp.Session{
+		Origin:     sdp.Origin{Username: "src", SessionId: 1, SessionVersion: 1, NetType: "IN", AddrType: "IP4", Address: "192.0.2.1"},
+		Connection: sdp.NewConnection("192.0.2.1"),
+		Media:      []*sdp.Media{{Type: "audio", Port: 49170, Proto: "RTP/AVP", Formats: []string{"0"}}},
+	}
+	body := NewRecordingSessionBody(session, metadata)
+	if part := body.Part(ContentTypeRecordingMetadata); part == nil || part.ContentDisposition() != DispositionRecordingSession {
+		t.Errorf("[FAIL] expected %s part with disposition %s", ContentTypeRecordingMetadata, DispositionRecordingSession)
+	}
+	invite.SetMultipart(body)
+
+	if _, err := invite.SDP(); err != nil {
+		t.Errorf("[FAIL] failed to get SDP of the recording session: %s", err)
+	}
+	parsed, err := invite.RecordingMetadata()
+	if err != nil {
+		t.Fatalf("[FAIL] failed to get recording metadata: %s", err)
+	}
+	if parsed.DataMode != RecordingDataComplete || len(parsed.Sessions) != 1 || len(parsed.Participants) != 1 {
+		t.Fatalf("[FAIL] unexpected recording metadata %+v", parsed)
+	}
+	if s := parsed.Sessions[0]; s.Id != sessionId || len(s.SipSessionIds) != 1 || s.StartTime == nil || !s.StartTime.Equal(start) || s.EndTime != nil {
+		t.Errorf("[FAIL] unexpected recorded session %+v", s)
+	}
+	if p := parsed.Participants[0]; len(p.NameIds) != 1 || p.NameIds[0].Aor != "sip:alice@atlanta.example.com" || p.NameIds[0].Name != "Alice" {
+		t.Errorf("[FAIL] unexpected participant %+v", p)
+	}
+	if len(parsed.ParticipantStreamAssocs) != 1 || len(parsed.ParticipantStreamAssocs[0].Send) != 1 ||
+		parsed.ParticipantStreamAssocs[0].Send[0] != streamId || len(parsed.ParticipantStreamAssocs[0].Recv) != 0 {
+		t.Errorf("[FAIL] unexpected participant stream associations %+v", parsed.ParticipantStreamAssocs)
+	}
+
+	// UPDATE of the metadata only.
+	invite.SetHeader(&GenericHeader{HeaderName: "Content-Type", Contents: ContentTypeRecordingMetadata}, true)
+	invite.SetBody(metadata.Body())
+	if parsed, err := invite.RecordingMetadata(); err != nil || len(parsed.Streams) != 1 || parsed.Streams[0].Label != "96" {
+		t.Errorf("[FAIL] failed to get recording metadata of the whole body: %v, %v", parsed, err)
+	}
+	if _, err := ParseRecordingMetadata(`<presence xmlns="urn:ietf:params:xml:ns:pidf"/>`); err == nil {
+		t.Errorf("[FAIL] expected error parsing other XML document as recording metadata")
+	}
+}
@@ -3,7 +3,9 @@ package base
 // These tests confirm that our various structures stringify correctly.
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/ghettovoice/gossip/log"
@@ -222,6 +224,10 @@ func TestHeaders(t *testing.T) {
 			&ViaHop{"SIP", "2.0", "TCP", "looking-glass.net", &port6060, NewParams().Add("food", String{"cake"})},
 			&ViaHop{"SIP", "2.0", "UDP", "oxford.co.uk", nil, NewParams().Add("delicious", NoString{})},
 		}, "Via: SIP/2.0/UDP wonderland.com:5060, SIP/2.0/TCP looking-glass.net:6060;food=cake, SIP/2.0/UDP oxford.co.uk;delicious"},
+		{"Via Header with built hop", ViaHeader{NewViaHop("udp", "wonderland.com", 5060, "z9hG4bK776")},
+			"Via: SIP/2.0/UDP wonderland.com:5060;branch=z9hG4bK776"},
+		{"Via Header with built hop and rport", ViaHeader{NewViaHop("tcp", "wonderland.com", 0, "z9hG4bK776").WithRport()},
+			"Via: SIP/2.0/TCP wonderland.com;branch=z9hG4bK776;rport"},
 
 		// Require Headers.
 		{"Require Header (empty)", &RequireHeader{[]string{}}, "Require: "},
@@ -250,3 +256,170 @@ func TestHeaders(t *testing.T) {
 		{"Content Length Header", ContentLength(70), "Content-Length: 70"},
 	}, t)
 }
+
+func TestViaHeader_PushPopHop(t *testing.T) {
+	via := &ViaHeader{NewViaHop("UDP", "wonderland.com", 5060, "z9hG4bK1")}
+	via.PushHop(NewViaHop("TCP", "looking-glass.net", 0, "z9hG4bK2"))
+
+	expected := "Via: SIP/2.0/TCP looking-glass.net;branch=z9hG4bK2, SIP/2.0/UDP wonderland.com:5060;branch=z9hG4bK1"
+	if via.String() != expected {
+		t.Errorf("[FAIL] PushHop: Expected: \"%v\", Got: \"%v\"", expected, via.String())
+	}
+
+	hop, err := via.PopHop()
+	if err != nil {
+		t.Fatalf("[FAIL] PopHop: unexpected error: %s", err)
+	}
+	if hop.Host != "looking-glass.net" || len(*via) != 1 {
+		t.Errorf("[FAIL] PopHop: popped %v, remaining %v", hop, via)
+	}
+
+	via.PopHop()
+	if _, err := via.PopHop(); err == nil {
+		t.Errorf("[FAIL] PopHop: expected error on empty Via header")
+	}
+}
+
+func TestInfoHeaderBuilders(t *testing.T) {
+	callInfo := NewCallInfoHeader("http://www.example.com/alice/photo.jpg", CallInfoPurposeIcon).WithAppearance(2)
+	if s := callInfo.String(); s != "Call-Info: <http://www.example.com/alice/photo.jpg>;purpose=icon;appearance-index=2" {
+		t.Errorf("[FAIL] unexpected Call-Info header: %s", s)
+	}
+	if index, ok := callInfo.WithAppearance(1).Appearance(); !ok || index != 1 {
+		t.Errorf("[FAIL] expected appearance-index 1, got %s", callInfo)
+	}
+
+	alertInfo := NewAlertInfoHeader("http://127.0.0.1/Bellcore-dr2").WithInfo("alert-external").WithAppearance(1)
+	if s := alertInfo.String(); s != "Alert-Info: <http://127.0.0.1/Bellcore-dr2>;info=alert-external;appearance=1" {
+		t.Errorf("[FAIL] unexpected Alert-Info header: %s", s)
+	}
+
+	dup := alertInfo.Copy().(*AlertInfoHeader)
+	dup.WithInfo("alert-internal")
+	if alertInfo.Info() != "alert-external" || dup.Info() != "alert-internal" {
+		t.Errorf("[FAIL] copy of Alert-Info header shares parameters with the original")
+	}
+}
+
+type dialogSet map[DialogId]bool
+
+func (dialogs dialogSet) HasDialog(id DialogId) bool {
+	return dialogs[id]
+}
+
+func TestTargetDialog(t *testing.T) {
+	// Bob references his dialog with Alice in a request sent to Alice.
+	bobSide := DialogId{CallId: "fa77as7dad8-sd98ajzz@host.example.com", LocalTag: "bob-tag", RemoteTag: "alice-tag"}
+	aliceSide := DialogId{CallId: bobSide.CallId, LocalTag: "alice-tag", RemoteTag: "bob-tag"}
+
+	header := NewTargetDialogHeader(bobSide)
+	if s := header.String(); s != "Target-Dialog: fa77as7dad8-sd98ajzz@host.example.com;local-tag=bob-tag;remote-tag=alice-tag" {
+		t.Errorf("[FAIL] unexpected Target-Dialog header: %s", s)
+	}
+	if id := header.DialogId(); id != aliceSide {
+		t.Errorf("[FAIL] expected recipient's dialog %s, got %s", aliceSide, id)
+	}
+
+	uri := &SipUri{User: String{"alice"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	refer := NewRequest(REFER, uri, "SIP/2.0", []SipHeader{header}, "", log.StandardLogger())
+	if err := AuthorizeTargetDialog(refer, dialogSet{aliceSide: true}); err != nil {
+		t.Errorf("[FAIL] expected request to be authorized: %s", err)
+	}
+	if err := AuthorizeTargetDialog(refer, dialogSet{bobSide: true}); !errors.Is(err, ErrNoDialog) {
+		t.Errorf("[FAIL] expected ErrNoDialog, got %v", err)
+	}
+
+	plain := NewRequest(REFER, uri, "SIP/2.0", []SipHeader{}, "", log.StandardLogger())
+	if err := AuthorizeTargetDialog(plain, dialogSet{aliceSide: true}); err == nil {
+		t.Errorf("[FAIL] expected request without Target-Dialog not to be authorized")
+	}
+}
+
+func TestHandleAnswerMode(t *testing.T) {
+	uri := &SipUri{User: String{"intercom"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	newInvite := func(hdrs ...SipHeader) *Request {
+		return NewRequest(INVITE, uri, "SIP/2.0", hdrs, "", log.StandardLogger())
+	}
+	honour := func(req *Request, requested *AnswerModeHeader) bool { return !requested.Private }
+
+	if auto, reject := HandleAnswerMode(newInvite(), honour); auto || reject != nil {
+		t.Errorf("[FAIL] expected manual answer of INVITE without answer mode")
+	}
+	if auto, reject := HandleAnswerMode(newInvite(NewAnswerModeHeader(AnswerModeAuto, false)), honour); !auto || reject != nil {
+		t.Errorf("[FAIL] expected automatic answer")
+	}
+
+	// Priv-Answer-Mode takes precedence and is not honoured by the policy.
+	invite := newInvite(NewAnswerModeHeader(AnswerModeAuto, false), NewPrivAnswerModeHeader(AnswerModeAuto, false))
+	if auto, reject := HandleAnswerMode(invite, honour); auto || reject != nil {
+		t.Errorf("[FAIL] expected manual answer when Priv-Answer-Mode is not honoured")
+	}
+
+	invite = newInvite(NewPrivAnswerModeHeader(AnswerModeAuto, true))
+	if _, reject := HandleAnswerMode(invite, honour); reject == nil || reject.StatusCode != 403 {
+		t.Errorf("[FAIL] expected 403 when required answer mode is not honoured, got %v", reject)
+	}
+	if s := NewPrivAnswerModeHeader(AnswerModeAuto, true).String(); s != "Priv-Answer-Mode: Auto;require" {
+		t.Errorf("[FAIL] unexpected Priv-Answer-Mode header: %s", s)
+	}
+}
+
+func TestChargingHeaderBuilders(t *testing.T) {
+	vector := NewChargingVectorHeader("192.0.6.8").WithOrigIoi("home1.net")
+	if vector.Icid == "" || vector.Icid == NewChargingVectorHeader("").Icid {
+		t.Errorf("[FAIL] expected unique icid-value, got '%s'", vector.Icid)
+	}
+	expected := "P-Charging-Vector: icid-value=" + vector.Icid + ";icid-generated-at=192.0.6.8;orig-ioi=home1.net"
+	if vector.String() != expected {
+		t.Errorf("[FAIL] expected '%s', got '%s'", expected, vector.String())
+	}
+
+	uri := &SipUri{User: String{"bob"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	req := NewRequest(INVITE, uri, "SIP/2.0", []SipHeader{vector}, "", log.StandardLogger())
+	res := NewResponseFromRequest(req, 200, "OK", "")
+	CopyChargingVector(req, res)
+	if copied := ChargingVector(res); copied == nil || copied.Icid != vector.Icid || copied.OrigIoi() != "home1.net" {
+		t.Errorf("[FAIL] P-Charging-Vector was not copied to the response: %s", res.String())
+	}
+}
+
+func TestGeneratedIdsSalt(t *testing.T) {
+	defer SetIdSalt(IdSalt())
+
+	branch, tag := GenerateBranch(), GenerateTag()
+	if !strings.HasPrefix(branch, RFC3261BranchMagicCookie+IdSalt()) || !strings.HasPrefix(tag, IdSalt()) {
+		t.Errorf("[FAIL] generated branch %s and tag %s miss salt %s", branch, tag, IdSalt())
+	}
+	if branch == GenerateBranch() || tag == GenerateTag() {
+		t.Errorf("[FAIL] generated identifiers are not unique")
+	}
+
+	SetIdSalt("instance1")
+	if branch := GenerateBranch(); !strings.HasPrefix(branch, RFC3261BranchMagicCookie+"instance1") {
+		t.Errorf("[FAIL] generated branch %s misses configured salt", branch)
+	}
+}
+
+func TestValidateInDialog(t *testing.T) {
+	uri := &SipUri{User: String{"bob"}, Host: "example.com", UriParams: NewParams(), Headers: NewParams()}
+	newRequest := func(toTag string) *Request {
+		from := &FromHeader{Address: uri.Copy(), Params: NewParams().Add("tag", String{"remote"})}
+		to := &ToHeader{Address: uri.Copy(), Params: NewParams()}
+		if toTag != "" {
+			to.Params.Add("tag", String{toTag})
+		}
+		callId := CallId("dialog1")
+		return NewRequest(BYE, uri, "SIP/2.0", []SipHeader{from, to, &callId}, "", log.StandardLogger())
+	}
+	dialogs := dialogSet{DialogId{CallId: "dialog1", LocalTag: "local", RemoteTag: "remote"}: true}
+
+	if err := ValidateInDialog(newRequest("local"), dialogs); err != nil {
+		t.Errorf("[FAIL] request of existing dialog rejected: %s", err)
+	}
+	if err := ValidateInDialog(newRequest(""), dialogs); err != nil {
+		t.Errorf("[FAIL] out-of-dialog request rejected: %s", err)
+	}
+	if err := ValidateInDialog(newRequest("other"), dialogs); !errors.Is(err, ErrNoDialog) {
+		t.Errorf("[FAIL] expected request with wrong To tag rejected, got %v", err)
+	}
+}
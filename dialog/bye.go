@@ -0,0 +1,104 @@
+package dialog
+
+import (
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/timing"
+	"github.com/ghettovoice/gossip/transaction"
+)
+
+// c_ACK_WAIT is the default time BYE received before ACK of 2xx to INVITE waits for the ACK, see SetByeBeforeAck.
+const c_ACK_WAIT = 2 * time.Second
+
+// ByeBeforeAck is the treatment of BYE received before ACK of 2xx to INVITE, e.g. the ACK was delayed
+// or took another path. Such BYE waits for the ACK, so the ACK and the BYE are passed to Requests in order;
+// if the ACK doesn't arrive in time the BYE is treated by the policy.
+type ByeBeforeAck int
+
+const (
+	// ByeAcceptLate terminates the dialog and passes the BYE to Requests without the ACK. Default.
+	ByeAcceptLate ByeBeforeAck = iota
+	// ByeRejectLate answers the BYE with 481 Call/Transaction Does Not Exist, the dialog is kept
+	// until the remote side sends the ACK and BYE again or the dialog is terminated locally.
+	ByeRejectLate
+)
+
+// SetByeBeforeAck sets the treatment of BYE received before ACK of 2xx to INVITE and the time it waits for the ACK.
+// Non-positive wait passes such BYE to Requests at once, like the ACK was received.
+func (dlg *Dialog) SetByeBeforeAck(policy ByeBeforeAck, wait time.Duration) {
+	dlg.lock.Lock()
+	defer dlg.lock.Unlock()
+	dlg.byePolicy = policy
+	dlg.ackWait = wait
+}
+
+// expectAck marks the 2xx to INVITE of the CSeq number sent, its ACK is expected.
+func (dlg *Dialog) expectAck(seqNo uint32) {
+	dlg.lock.Lock()
+	defer dlg.lock.Unlock()
+	dlg.ackSeq = seqNo
+	dlg.awaitingAck = true
+}
+
+// isExpectedAck reports whether the ACK of the CSeq number acknowledges 2xx to INVITE,
+// it is in order even if BYE of a higher CSeq number arrived first.
+func (dlg *Dialog) isExpectedAck(seqNo uint32) bool {
+	dlg.lock.RLock()
+	defer dlg.lock.RUnlock()
+	return dlg.awaitingAck && seqNo == dlg.ackSeq
+}
+
+// holdBye keeps BYE received before the expected ACK until the ACK or the wait runs out,
+// returns false if no ACK is expected.
+func (dlg *Dialog) holdBye(tx *transaction.ServerTransaction) bool {
+	dlg.lock.Lock()
+	defer dlg.lock.Unlock()
+	if !dlg.awaitingAck || dlg.ackWait <= 0 || dlg.pendingBye != nil {
+		return false
+	}
+	dlg.Log().Debugf("holding %s until ACK is received", tx.Origin().Short())
+	dlg.pendingBye = tx
+	dlg.byeTimer = timing.AfterFunc(dlg.ackWait, dlg.ackTimeout)
+	return true
+}
+
+// ackReceived passes BYE held until the ACK to Requests.
+func (dlg *Dialog) ackReceived() {
+	dlg.lock.Lock()
+	dlg.awaitingAck = false
+	tx := dlg.pendingBye
+	dlg.pendingBye = nil
+	if dlg.byeTimer != nil {
+		dlg.byeTimer.Stop()
+		dlg.byeTimer = nil
+	}
+	dlg.lock.Unlock()
+
+	if tx != nil {
+		dlg.Terminate()
+		dlg.requests <- tx
+	}
+}
+
+// ackTimeout treats BYE held until the ACK that didn't arrive by the policy.
+func (dlg *Dialog) ackTimeout() {
+	dlg.lock.Lock()
+	tx := dlg.pendingBye
+	dlg.pendingBye = nil
+	dlg.byeTimer = nil
+	policy := dlg.byePolicy
+	dlg.lock.Unlock()
+	if tx == nil {
+		return
+	}
+
+	if policy == ByeRejectLate {
+		dlg.Log().Warnf("rejecting %s, ACK was not received", tx.Origin().Short())
+		tx.Respond(base.NewResponseFromRequest(tx.Origin(), 481, "Call/Transaction Does Not Exist", ""))
+		return
+	}
+	dlg.Log().Debugf("passing %s up, ACK was not received", tx.Origin().Short())
+	dlg.Terminate()
+	dlg.requests <- tx
+}
@@ -0,0 +1,121 @@
+package dialog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/transaction"
+)
+
+// establish makes INVITE - 200 between the managers without ACK, returns UAC and UAS dialogs and 200.
+func establish(t *testing.T, uac, uas *transaction.Manager, callId string) (*Dialog, *Dialog, *base.Response) {
+	invite := parseRequest(t,
+		"INVITE sip:uas@"+c_UAS+" SIP/2.0",
+		"Via: SIP/2.0/UDP "+c_UAC+";branch="+base.GenerateBranch(),
+		"From: <sip:uac@uac.test>;tag=1928301774",
+		"To: <sip:uas@uas.test>",
+		"Call-Id: "+callId,
+		"CSeq: 1 INVITE",
+		"Contact: <sip:uac@"+c_UAC+">",
+		"Content-Length: 0",
+	)
+	clientTx := uac.Send(invite, c_UAS)
+
+	inviteTx := serverTx(t, uas.Requests())
+	ok := base.NewResponseFromRequest(inviteTx.Origin(), 200, "OK", "")
+	to, _ := ok.To()
+	to.Params.Add("tag", base.String{S: base.GenerateTag()})
+	ok.AddHeader(&base.ContactHeader{
+		DisplayName: base.NoString{},
+		Address:     &base.SipUri{User: base.String{S: "uas"}, Host: "uas.test", UriParams: base.NewParams(), Headers: base.NewParams()},
+		Params:      base.NewParams(),
+	})
+	uasDlg, err := NewUasDialog(uas, inviteTx, ok)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create UAS dialog: %s", err)
+	}
+	inviteTx.Respond(ok)
+
+	res := finalResponse(t, clientTx)
+	uacDlg, err := NewUacDialog(uac, clientTx, res)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create UAC dialog: %s", err)
+	}
+	return uacDlg, uasDlg, res
+}
+
+// Test that BYE overtaking ACK of 200 is held until the ACK, then both are passed up in order.
+func TestByeBeforeAck(t *testing.T) {
+	uac := newManager(t, c_UAC)
+	defer uac.Stop()
+	uas := newManager(t, c_UAS)
+	defer uas.Stop()
+	uacDlg, uasDlg, res := establish(t, uac, uas, "bye-before-ack")
+
+	byeTx, err := uacDlg.Bye()
+	if err != nil {
+		t.Fatalf("[FAIL] failed to send BYE: %s", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if uasDlg.State() != StateConfirmed {
+		t.Errorf("[FAIL] expected UAS dialog confirmed while BYE waits for ACK, got %s", uasDlg.State())
+	}
+	select {
+	case tx := <-uasDlg.Requests():
+		t.Fatalf("[FAIL] expected BYE held until ACK, got %s", tx.Origin().Short())
+	default:
+	}
+
+	if err := uacDlg.Ack(res); err != nil {
+		t.Fatalf("[FAIL] failed to send ACK: %s", err)
+	}
+	if req := serverTx(t, uasDlg.Requests()).Origin(); !req.IsAck() {
+		t.Fatalf("[FAIL] expected ACK first, got %s", req.Short())
+	}
+	tx := serverTx(t, uasDlg.Requests())
+	if tx.Origin().Method != base.BYE {
+		t.Fatalf("[FAIL] expected BYE after ACK, got %s", tx.Origin().Short())
+	}
+	if uasDlg.State() != StateTerminated {
+		t.Errorf("[FAIL] expected UAS dialog terminated by BYE, got %s", uasDlg.State())
+	}
+	tx.Respond(base.NewResponseFromRequest(tx.Origin(), 200, "OK", ""))
+	if res := finalResponse(t, byeTx); res.StatusCode != 200 {
+		t.Errorf("[FAIL] expected 200 on BYE, got %s", res.Short())
+	}
+}
+
+// Test the policies of BYE whose ACK doesn't arrive.
+func TestByeWithoutAck(t *testing.T) {
+	uac := newManager(t, c_UAC)
+	defer uac.Stop()
+	uas := newManager(t, c_UAS)
+	defer uas.Stop()
+
+	uacDlg, uasDlg, _ := establish(t, uac, uas, "bye-without-ack-1")
+	uasDlg.SetByeBeforeAck(ByeRejectLate, 50*time.Millisecond)
+	byeTx, err := uacDlg.Bye()
+	if err != nil {
+		t.Fatalf("[FAIL] failed to send BYE: %s", err)
+	}
+	if res := finalResponse(t, byeTx); res.StatusCode != 481 {
+		t.Errorf("[FAIL] expected 481 on BYE without ACK, got %s", res.Short())
+	}
+	if uasDlg.State() != StateConfirmed {
+		t.Errorf("[FAIL] expected UAS dialog kept after rejected BYE, got %s", uasDlg.State())
+	}
+	uasDlg.Terminate()
+
+	uacDlg, uasDlg, _ = establish(t, uac, uas, "bye-without-ack-2")
+	uasDlg.SetByeBeforeAck(ByeAcceptLate, 50*time.Millisecond)
+	if _, err := uacDlg.Bye(); err != nil {
+		t.Fatalf("[FAIL] failed to send BYE: %s", err)
+	}
+	if tx := serverTx(t, uasDlg.Requests()); tx.Origin().Method != base.BYE {
+		t.Errorf("[FAIL] expected BYE passed up after the wait, got %s", tx.Origin().Short())
+	}
+	if uasDlg.State() != StateTerminated {
+		t.Errorf("[FAIL] expected UAS dialog terminated by BYE, got %s", uasDlg.State())
+	}
+}
@@ -0,0 +1,537 @@
+// Package dialog tracks SIP dialogs on top of transaction.Manager - RFC 3261 12.
+// A Dialog keeps the dialog identifiers, the local and remote CSeq numbers, the remote target and the route set,
+// so that in-dialog requests such as BYE and re-INVITE are built without repeating the bookkeeping.
+package dialog
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/sdp"
+	"github.com/ghettovoice/gossip/timing"
+	"github.com/ghettovoice/gossip/transaction"
+	"github.com/ghettovoice/gossip/transport"
+)
+
+const c_REQUESTS_QUEUE_SIZE = 5
+
+// State of the dialog - RFC 3261 12.
+type State int
+
+const (
+	// StateEarly dialog is created by a provisional response.
+	StateEarly State = iota
+	// StateConfirmed dialog is created or confirmed by a 2xx response.
+	StateConfirmed
+	// StateTerminated dialog is ended by BYE or locally.
+	StateTerminated
+)
+
+func (s State) String() string {
+	switch s {
+	case StateEarly:
+		return "Early"
+	case StateConfirmed:
+		return "Confirmed"
+	case StateTerminated:
+		return "Terminated"
+	default:
+		return "Unknown"
+	}
+}
+
+// Dialog is a peer-to-peer relationship between two user agents - RFC 3261 12.
+// In-dialog requests received from the remote side are delivered on Requests.
+type Dialog struct {
+	id            base.DialogId
+	state         State
+	localUri      base.Uri
+	remoteUri     base.Uri
+	remoteTarget  base.Uri
+	routeSet      []*base.RouteHeader // Route set in the order of Route headers.
+	cseq          *base.CSeqSequence
+	inviteSeq     uint32       // CSeq number of the last INVITE sent, used by ACK.
+	via           *base.ViaHop // Template of Via of the requests sent in the dialog.
+	offer         OfferState
+	localSession  *sdp.Session
+	remoteSession *sdp.Session
+	answeredSeq   uint32 // CSeq number of the last request sent whose final response was applied.
+	policy        MediaPolicy
+	ackSeq        uint32 // CSeq number of the INVITE whose 2xx was sent, see awaitingAck.
+	awaitingAck   bool   // 2xx to INVITE was sent, its ACK was not received yet.
+	pendingBye    *transaction.ServerTransaction
+	byeTimer      timing.Timer
+	byePolicy     ByeBeforeAck
+	ackWait       time.Duration
+	tm            *transaction.Manager
+	transport     transport.Manager
+	requests      chan *transaction.ServerTransaction
+	lock          sync.RWMutex
+	log           log.Logger
+}
+
+// NewUacDialog creates the dialog of the UAC from the response to the dialog creating request, e.g. INVITE - RFC 3261 12.1.2.
+// The response must carry To tag; provisional responses create early dialogs, 2xx responses confirmed ones.
+// The session description of the request is the local offer, the one of 2xx is its answer or,
+// if the request had no offer, the remote offer to answer with AckAnswer.
+// In-dialog requests of the dialog are taken from the transaction manager and passed to Requests.
+func NewUacDialog(tm *transaction.Manager, tx *transaction.ClientTransaction, res *base.Response) (*Dialog, error) {
+	req := tx.Origin()
+	id, err := responseDialogId(res)
+	if err != nil {
+		return nil, err
+	}
+	from, err := req.From()
+	if err != nil {
+		return nil, err
+	}
+	to, err := res.To()
+	if err != nil {
+		return nil, err
+	}
+	cseq, err := req.CSeq()
+	if err != nil {
+		return nil, err
+	}
+	hop, err := req.ViaHop()
+	if err != nil {
+		return nil, err
+	}
+	target, err := contactUri(res)
+	if err != nil {
+		return nil, err
+	}
+
+	// The UAC route set is the reversed Record-Route of the response - RFC 3261 12.1.2.
+	routes := base.ReverseRecordRoutes(res)
+
+	dlg := newDialog(tm, tx.Transport(), id, res)
+	dlg.localUri = from.Address.Copy()
+	dlg.remoteUri = to.Address.Copy()
+	dlg.remoteTarget = target
+	dlg.routeSet = routes
+	// The dialog continues the sequence of the dialog creating request.
+	dlg.cseq = base.NewCSeqSequence(cseq.SeqNo + 1)
+	dlg.inviteSeq = cseq.SeqNo
+	dlg.via = hop.Copy()
+	if err := dlg.sendSession(req, true); err != nil {
+		dlg.Log().Warnf("request %s carries no valid offer: %s", req.Short(), err)
+	}
+	if err := dlg.receiveAnswer(res); err != nil {
+		dlg.Log().Warnf("failed to apply session description of %s: %s", res.Short(), err)
+	}
+	dlg.register()
+
+	return dlg, nil
+}
+
+// NewUasDialog creates the dialog of the UAS from the dialog creating request and the response to it - RFC 3261 12.1.1.
+// The response must carry To tag and Contact of the UAS; it is not sent, respond with it as usual.
+// The session description of the request is the remote offer, 2xx must answer it; if the request had no offer,
+// 2xx may carry the local offer answered by ACK - RFC 3261 13.2.1. Invalid offer of the request fails with
+// ErrNotAcceptable, reject the request with 488 Not Acceptable Here then.
+// The final response of the early dialog created by a provisional response should be sent with Respond.
+// In-dialog requests of the dialog are taken from the transaction manager and passed to Requests.
+func NewUasDialog(tm *transaction.Manager, tx *transaction.ServerTransaction, res *base.Response) (*Dialog, error) {
+	req := tx.Origin()
+	callId, err := req.CallId()
+	if err != nil {
+		return nil, err
+	}
+	fromTag, err := req.FromTag()
+	if err != nil {
+		return nil, err
+	}
+	toTag, err := res.ToTag()
+	if err != nil {
+		return nil, fmt.Errorf("response %s creates no dialog: %s", res.Short(), err)
+	}
+	id := base.DialogId{CallId: string(*callId), LocalTag: toTag.String(), RemoteTag: fromTag.String()}
+
+	from, err := req.From()
+	if err != nil {
+		return nil, err
+	}
+	to, err := req.To()
+	if err != nil {
+		return nil, err
+	}
+	cseq, err := req.CSeq()
+	if err != nil {
+		return nil, err
+	}
+	hop, err := req.ViaHop()
+	if err != nil {
+		return nil, err
+	}
+	target, err := contactUri(req)
+	if err != nil {
+		return nil, err
+	}
+	local, err := contactUri(res)
+	if err != nil {
+		return nil, err
+	}
+	localSip, ok := local.(*base.SipUri)
+	if !ok {
+		return nil, fmt.Errorf("contact %s of response %s is not SIP URI", local, res.Short())
+	}
+	port := uint16(0)
+	if localSip.Port != nil {
+		port = *localSip.Port
+	}
+
+	dlg := newDialog(tm, tx.Transport(), id, res)
+	dlg.localUri = to.Address.Copy()
+	dlg.remoteUri = from.Address.Copy()
+	dlg.remoteTarget = target
+	// The UAS route set is Record-Route of the request in order - RFC 3261 12.1.1.
+	dlg.routeSet = base.RecordRouteSet(req)
+	dlg.cseq = base.NewCSeqSequence(0)
+	dlg.cseq.ReceiveRemote(cseq)
+	dlg.via = base.NewViaHop(hop.Transport, localSip.Host, port, "")
+	if err := dlg.receiveSession(req, true); err != nil {
+		return nil, err
+	}
+	if !res.IsProvisional() {
+		if err := dlg.sendAnswer(res); err != nil {
+			return nil, err
+		}
+	}
+	if req.IsInvite() && res.IsSuccess() {
+		dlg.expectAck(cseq.SeqNo)
+	}
+	dlg.register()
+
+	return dlg, nil
+}
+
+func newDialog(tm *transaction.Manager, tp transport.Manager, id base.DialogId, res *base.Response) *Dialog {
+	state := StateConfirmed
+	if res.IsProvisional() {
+		state = StateEarly
+	}
+	return &Dialog{
+		id:        id,
+		state:     state,
+		tm:        tm,
+		transport: tp,
+		requests:  make(chan *transaction.ServerTransaction, c_REQUESTS_QUEUE_SIZE),
+		ackWait:   c_ACK_WAIT,
+		log:       log.WithField("dialog", id.String()),
+	}
+}
+
+func (dlg *Dialog) Log() log.Logger {
+	return dlg.log
+}
+
+// Id returns the dialog identifier from the local perspective.
+func (dlg *Dialog) Id() base.DialogId {
+	return dlg.id
+}
+
+func (dlg *Dialog) State() State {
+	dlg.lock.RLock()
+	defer dlg.lock.RUnlock()
+	return dlg.state
+}
+
+// RemoteTarget returns the URI in-dialog requests are sent to, the last Contact of the remote side.
+func (dlg *Dialog) RemoteTarget() base.Uri {
+	dlg.lock.RLock()
+	defer dlg.lock.RUnlock()
+	return dlg.remoteTarget.Copy()
+}
+
+// RouteSet returns the copy of Route headers of in-dialog requests.
+func (dlg *Dialog) RouteSet() []*base.RouteHeader {
+	dlg.lock.RLock()
+	defer dlg.lock.RUnlock()
+	routes := make([]*base.RouteHeader, 0, len(dlg.routeSet))
+	for _, route := range dlg.routeSet {
+		routes = append(routes, route.Copy().(*base.RouteHeader))
+	}
+	return routes
+}
+
+// LocalCSeq returns the CSeq number of the last request sent in the dialog.
+func (dlg *Dialog) LocalCSeq() uint32 {
+	return dlg.cseq.Local()
+}
+
+// RemoteCSeq returns the CSeq number of the last request received in the dialog, false if there was none.
+func (dlg *Dialog) RemoteCSeq() (uint32, bool) {
+	return dlg.cseq.Remote()
+}
+
+// Requests returns the channel of in-dialog requests received from the remote side, including ACK on 2xx.
+// BYE received before ACK on 2xx is passed after the ACK, see SetByeBeforeAck.
+func (dlg *Dialog) Requests() <-chan *transaction.ServerTransaction {
+	return dlg.requests
+}
+
+// Update applies the response to a request sent in the dialog, e.g. re-INVITE or the dialog creating INVITE:
+// a 2xx response confirms the early dialog and refreshes the remote target from Contact - RFC 3261 12.2.1.2.
+// The session description of the final response to INVITE or UPDATE is applied, see OfferState.
+func (dlg *Dialog) Update(res *base.Response) {
+	if err := dlg.receiveAnswer(res); err != nil {
+		dlg.Log().Warnf("failed to apply session description of %s: %s", res.Short(), err)
+	}
+	if !res.IsSuccess() {
+		return
+	}
+
+	dlg.lock.Lock()
+	defer dlg.lock.Unlock()
+	if dlg.state == StateEarly {
+		dlg.state = StateConfirmed
+	}
+	if target, err := contactUri(res); err == nil {
+		dlg.remoteTarget = target
+	}
+}
+
+// NewRequest builds a request within the dialog - RFC 3261 12.2.1.1.
+// The CSeq number is taken from the local sequence, ACK should be built with Ack instead.
+// The body of INVITE and UPDATE is the local offer, it fails with ErrOfferPending while another offer is not answered.
+func (dlg *Dialog) NewRequest(method base.Method, body string, hdrs ...base.SipHeader) (*base.Request, error) {
+	if dlg.State() == StateTerminated {
+		return nil, fmt.Errorf("dialog %s is terminated", dlg.id)
+	}
+	offer := body != "" && (method == base.INVITE || method == base.UPDATE)
+	if offer && dlg.OfferState() != OfferNone {
+		return nil, base.NewError(ErrOfferPending, nil, "offer of dialog %s is not answered yet", dlg.id)
+	}
+	seqNo, err := dlg.cseq.Next()
+	if err != nil {
+		return nil, err
+	}
+	if method == base.INVITE {
+		dlg.lock.Lock()
+		dlg.inviteSeq = seqNo
+		dlg.lock.Unlock()
+	}
+
+	req, err := dlg.request(method, seqNo, body, hdrs)
+	if err != nil {
+		return nil, err
+	}
+	if offer {
+		if err := dlg.sendSession(req, true); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// Send starts the client transaction of the in-dialog request to the next hop of the dialog.
+func (dlg *Dialog) Send(req *base.Request) (*transaction.ClientTransaction, error) {
+	dest, err := dlg.nextHop()
+	if err != nil {
+		return nil, err
+	}
+	return dlg.tm.Send(req, dest), nil
+}
+
+// Ack sends ACK on the 2xx response to INVITE sent in the dialog, it's not a transaction of its own - RFC 3261 13.2.2.4.
+// It fails with ErrOfferPending if the 2xx carries the offer, answer it with AckAnswer instead.
+func (dlg *Dialog) Ack(res *base.Response) error {
+	dlg.Update(res)
+	if dlg.OfferState() == OfferRemote {
+		return base.NewError(ErrOfferPending, nil, "offer of %s must be answered in ACK", res.Short())
+	}
+	return dlg.ack(nil)
+}
+
+// AckAnswer sends ACK on the 2xx response carrying the offer, with the answer to it - RFC 3261 13.2.1.
+// On the retransmissions of the 2xx the ACK with the answer is sent again.
+func (dlg *Dialog) AckAnswer(res *base.Response, answer *sdp.Session) error {
+	dlg.Update(res)
+	return dlg.ack(answer)
+}
+
+func (dlg *Dialog) ack(answer *sdp.Session) error {
+	dlg.lock.RLock()
+	seqNo := dlg.inviteSeq
+	dlg.lock.RUnlock()
+	ack, err := dlg.request(base.ACK, seqNo, "", nil)
+	if err != nil {
+		return err
+	}
+	if answer != nil {
+		ack.SetSDP(answer)
+		if dlg.OfferState() == OfferRemote {
+			if err := dlg.sendSession(ack, false); err != nil {
+				return err
+			}
+		}
+	}
+	dest, err := dlg.nextHop()
+	if err != nil {
+		return err
+	}
+	return dlg.transport.Send(dest, ack)
+}
+
+// Bye sends BYE and terminates the dialog - RFC 3261 15.1.1.
+func (dlg *Dialog) Bye() (*transaction.ClientTransaction, error) {
+	req, err := dlg.NewRequest(base.BYE, "")
+	if err != nil {
+		return nil, err
+	}
+	dlg.Terminate()
+	return dlg.Send(req)
+}
+
+// Terminate ends the dialog locally, in-dialog requests are no longer passed to Requests.
+func (dlg *Dialog) Terminate() {
+	dlg.lock.Lock()
+	defer dlg.lock.Unlock()
+	if dlg.state == StateTerminated {
+		return
+	}
+	dlg.state = StateTerminated
+	dlg.tm.RemoveDialogHandler(dlg.id)
+	dlg.Log().Debugf("dialog %s terminated", dlg.id)
+}
+
+func (dlg *Dialog) register() {
+	dlg.tm.HandleDialog(dlg.id, dlg.handle)
+	dlg.Log().Debugf("dialog %s created in state %s", dlg.id, dlg.state)
+}
+
+// handle checks the in-dialog request and passes it up - RFC 3261 12.2.2.
+func (dlg *Dialog) handle(tx *transaction.ServerTransaction) {
+	req := tx.Origin()
+	cseq, err := req.CSeq()
+	// ACK of 2xx carries CSeq of the INVITE, so it's lower than of BYE that overtook it.
+	if err == nil && !(req.IsAck() && dlg.isExpectedAck(cseq.SeqNo)) {
+		err = dlg.cseq.ReceiveRemote(cseq)
+	}
+	if err != nil {
+		// Requests out of order are rejected with 500 Server Internal Error - RFC 3261 12.2.2.
+		dlg.Log().Warnf("rejecting request %s: %s", req.Short(), err)
+		if !req.IsAck() {
+			tx.Respond(base.NewResponseFromRequest(req, 500, "Server Internal Error", ""))
+		}
+		return
+	}
+
+	switch req.Method {
+	case base.ACK:
+		if err := dlg.receiveAck(req); err != nil {
+			// The session can't be established without the answer, so the dialog is ended - RFC 3261 13.3.1.4.
+			dlg.Log().Warnf("hanging up: %s", err)
+			if _, err := dlg.Bye(); err != nil {
+				dlg.Log().Warnf("failed to hang up: %s", err)
+			}
+		}
+	case base.INVITE, base.UPDATE:
+		if err := dlg.receiveSession(req, true); err != nil {
+			dlg.Log().Warnf("rejecting request %s: %s", req.Short(), err)
+			rejectOffer(tx, err)
+			return
+		}
+		// Target refresh requests replace the remote target - RFC 3261 12.2.2.
+		if target, err := contactUri(req); err == nil {
+			dlg.lock.Lock()
+			dlg.remoteTarget = target
+			dlg.lock.Unlock()
+		}
+	case base.BYE:
+		if dlg.holdBye(tx) {
+			return
+		}
+		dlg.Terminate()
+	}
+
+	dlg.requests <- tx
+	if req.IsAck() {
+		dlg.ackReceived()
+	}
+}
+
+func (dlg *Dialog) request(method base.Method, seqNo uint32, body string, hdrs []base.SipHeader) (*base.Request, error) {
+	dlg.lock.RLock()
+	defer dlg.lock.RUnlock()
+
+	via := dlg.via.Copy()
+	via.Params = base.NewParams().Add("branch", base.String{S: base.GenerateBranch()})
+	if _, ok := dlg.via.Params.Get("rport"); ok {
+		via.WithRport()
+	}
+	callId := base.CallId(dlg.id.CallId)
+
+	headers := []base.SipHeader{
+		&base.ViaHeader{via},
+		&base.FromHeader{
+			DisplayName: base.NoString{},
+			Address:     dlg.localUri.Copy(),
+			Params:      base.NewParams().Add("tag", base.String{S: dlg.id.LocalTag}),
+		},
+		&base.ToHeader{
+			DisplayName: base.NoString{},
+			Address:     dlg.remoteUri.Copy(),
+			Params:      base.NewParams().Add("tag", base.String{S: dlg.id.RemoteTag}),
+		},
+		&callId,
+		&base.CSeq{SeqNo: seqNo, MethodName: method},
+		base.MaxForwards(70),
+	}
+	headers = append(headers, hdrs...)
+	headers = append(headers, base.ContentLength(len(body)))
+
+	req := base.NewRequest(method, dlg.remoteTarget.Copy(), "SIP/2.0", headers, body, dlg.Log())
+	// Strict router becomes the Request-URI, the remote target is the last route - RFC 3261 12.2.1.1.
+	base.ApplyRouteSet(req, dlg.routeSet, dlg.remoteTarget)
+	return req, nil
+}
+
+// nextHop returns the address the in-dialog requests are sent to: the first route or the remote target.
+func (dlg *Dialog) nextHop() (string, error) {
+	dlg.lock.RLock()
+	defer dlg.lock.RUnlock()
+
+	if len(dlg.routeSet) > 0 {
+		uri, ok := dlg.routeSet[0].Address.(*base.SipUri)
+		if !ok {
+			return "", fmt.Errorf("route %s of dialog %s is not SIP URI", dlg.routeSet[0], dlg.id)
+		}
+		return base.UriAddr(uri), nil
+	}
+	uri, ok := dlg.remoteTarget.(*base.SipUri)
+	if !ok {
+		return "", fmt.Errorf("remote target %s of dialog %s is not SIP URI", dlg.remoteTarget, dlg.id)
+	}
+	return base.UriAddr(uri), nil
+}
+
+func responseDialogId(res *base.Response) (base.DialogId, error) {
+	callId, err := res.CallId()
+	if err != nil {
+		return base.DialogId{}, err
+	}
+	fromTag, err := res.FromTag()
+	if err != nil {
+		return base.DialogId{}, err
+	}
+	toTag, err := res.ToTag()
+	if err != nil {
+		return base.DialogId{}, fmt.Errorf("response %s creates no dialog: %s", res.Short(), err)
+	}
+	return base.DialogId{CallId: string(*callId), LocalTag: fromTag.String(), RemoteTag: toTag.String()}, nil
+}
+
+// contactUri returns the URI of the first Contact header of the message.
+func contactUri(msg base.SipMessage) (base.Uri, error) {
+	for _, contact := range msg.Contacts() {
+		if !contact.IsWildcard() {
+			return contact.Address.Copy(), nil
+		}
+	}
+	return nil, fmt.Errorf("no Contact header in %s", msg.Short())
+}
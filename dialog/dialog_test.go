@@ -0,0 +1,458 @@
+package dialog
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+	"github.com/ghettovoice/gossip/sdp"
+	"github.com/ghettovoice/gossip/transaction"
+	"github.com/ghettovoice/gossip/transport"
+)
+
+const (
+	c_UAC = "uac.test:5060"
+	c_UAS = "uas.test:5060"
+)
+
+func newManager(t *testing.T, addr string) *transaction.Manager {
+	tp, err := transport.NewManager("memory")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create transport: %s", err)
+	}
+	tm, err := transaction.NewManager(tp, addr)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create transaction manager: %s", err)
+	}
+	return tm
+}
+
+func parseRequest(t *testing.T, lines ...string) *base.Request {
+	raw := ""
+	for _, line := range lines {
+		raw += line + "\r\n"
+	}
+	msg, err := parser.ParseMessage([]byte(raw+"\r\n"), log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse request: %s", err)
+	}
+	return msg.(*base.Request)
+}
+
+func serverTx(t *testing.T, c <-chan *transaction.ServerTransaction) *transaction.ServerTransaction {
+	select {
+	case tx := <-c:
+		return tx
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] request was not received")
+	}
+	return nil
+}
+
+func finalResponse(t *testing.T, tx *transaction.ClientTransaction) *base.Response {
+	for {
+		select {
+		case res := <-tx.Responses():
+			if !res.IsProvisional() {
+				return res
+			}
+		case err := <-tx.Errors():
+			t.Fatalf("[FAIL] client transaction failed: %s", err)
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] final response was not received")
+		}
+	}
+}
+
+// Test the dialog lifecycle: INVITE - 200 - ACK - BYE - 200 between two managers.
+func TestDialogLifecycle(t *testing.T) {
+	uac := newManager(t, c_UAC)
+	defer uac.Stop()
+	uas := newManager(t, c_UAS)
+	defer uas.Stop()
+
+	invite := parseRequest(t,
+		"INVITE sip:uas@"+c_UAS+" SIP/2.0",
+		"Via: SIP/2.0/UDP "+c_UAC+";branch=z9hG4bK776asdhds",
+		"From: <sip:uac@uac.test>;tag=1928301774",
+		"To: <sip:uas@uas.test>",
+		"Call-Id: a84b4c76e66710",
+		"CSeq: 314159 INVITE",
+		"Contact: <sip:uac@"+c_UAC+">",
+		"Content-Length: 0",
+	)
+	clientTx := uac.Send(invite, c_UAS)
+
+	inviteTx := serverTx(t, uas.Requests())
+	ok := base.NewResponseFromRequest(inviteTx.Origin(), 200, "OK", "")
+	to, _ := ok.To()
+	to.Params.Add("tag", base.String{S: "a6c85cf"})
+	ok.AddHeader(&base.ContactHeader{
+		DisplayName: base.NoString{},
+		Address:     &base.SipUri{User: base.String{S: "uas"}, Host: "uas.test", UriParams: base.NewParams(), Headers: base.NewParams()},
+		Params:      base.NewParams(),
+	})
+	uasDlg, err := NewUasDialog(uas, inviteTx, ok)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create UAS dialog: %s", err)
+	}
+	inviteTx.Respond(ok)
+
+	res := finalResponse(t, clientTx)
+	uacDlg, err := NewUacDialog(uac, clientTx, res)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create UAC dialog: %s", err)
+	}
+	if uacDlg.State() != StateConfirmed || uasDlg.State() != StateConfirmed {
+		t.Fatalf("[FAIL] expected confirmed dialogs, got UAC %s, UAS %s", uacDlg.State(), uasDlg.State())
+	}
+	if uacDlg.Id().LocalTag != uasDlg.Id().RemoteTag || uacDlg.Id().RemoteTag != uasDlg.Id().LocalTag {
+		t.Errorf("[FAIL] dialog ids don't match: UAC %s, UAS %s", uacDlg.Id(), uasDlg.Id())
+	}
+
+	if err := uacDlg.Ack(res); err != nil {
+		t.Fatalf("[FAIL] failed to send ACK: %s", err)
+	}
+	ack := serverTx(t, uasDlg.Requests()).Origin()
+	if !ack.IsAck() {
+		t.Fatalf("[FAIL] expected ACK, got %s", ack.Short())
+	}
+	if cseq, _ := ack.CSeq(); cseq.SeqNo != 314159 {
+		t.Errorf("[FAIL] expected ACK CSeq 314159, got %d", cseq.SeqNo)
+	}
+
+	byeTx, err := uacDlg.Bye()
+	if err != nil {
+		t.Fatalf("[FAIL] failed to send BYE: %s", err)
+	}
+	if uacDlg.State() != StateTerminated {
+		t.Errorf("[FAIL] expected UAC dialog terminated, got %s", uacDlg.State())
+	}
+	tx := serverTx(t, uasDlg.Requests())
+	bye := tx.Origin()
+	if bye.Method != base.BYE {
+		t.Fatalf("[FAIL] expected BYE, got %s", bye.Short())
+	}
+	if cseq, _ := bye.CSeq(); cseq.SeqNo != 314160 {
+		t.Errorf("[FAIL] expected BYE CSeq 314160, got %d", cseq.SeqNo)
+	}
+	if bye.Recipient.String() != "sip:uas@uas.test" {
+		t.Errorf("[FAIL] expected BYE to remote target sip:uas@uas.test, got %s", bye.Recipient)
+	}
+	tx.Respond(base.NewResponseFromRequest(bye, 200, "OK", ""))
+
+	if res := finalResponse(t, byeTx); res.StatusCode != 200 {
+		t.Errorf("[FAIL] expected 200 on BYE, got %s", res.Short())
+	}
+	if uasDlg.State() != StateTerminated {
+		t.Errorf("[FAIL] expected UAS dialog terminated, got %s", uasDlg.State())
+	}
+	if uas.HasDialog(uasDlg.Id()) || uac.HasDialog(uacDlg.Id()) {
+		t.Errorf("[FAIL] expected dialog handlers removed")
+	}
+}
+
+func testSession(user string) *sdp.Session {
+	return &sdp.Session{
+		Origin:     sdp.Origin{Username: user, SessionId: 1, SessionVersion: 1, NetType: "IN", AddrType: "IP4", Address: "192.0.2.1"},
+		Connection: sdp.NewConnection("192.0.2.1"),
+		Media: []*sdp.Media{{
+			Type:       "audio",
+			Port:       49170,
+			Proto:      "RTP/AVP",
+			Formats:    []string{"0"},
+			Attributes: sdp.Attributes{{Name: "rtpmap", Value: "0 PCMU/8000"}},
+		}},
+	}
+}
+
+// Test INVITE without offer: the offer is in 200, the answer in ACK, then the offer/answer violations of re-INVITE.
+func TestDelayedOffer(t *testing.T) {
+	const uacAddr, uasAddr = "uac-offer.test:5060", "uas-offer.test:5060"
+	uac := newManager(t, uacAddr)
+	defer uac.Stop()
+	uas := newManager(t, uasAddr)
+	defer uas.Stop()
+
+	invite := parseRequest(t,
+		"INVITE sip:uas@"+uasAddr+" SIP/2.0",
+		"Via: SIP/2.0/UDP "+uacAddr+";branch=z9hG4bK776asoffer",
+		"From: <sip:uac@uac.test>;tag=1928301774",
+		"To: <sip:uas@uas.test>",
+		"Call-Id: delayed-offer",
+		"CSeq: 1 INVITE",
+		"Contact: <sip:uac@"+uacAddr+">",
+		"Content-Length: 0",
+	)
+	clientTx := uac.Send(invite, uasAddr)
+
+	inviteTx := serverTx(t, uas.Requests())
+	ok := base.NewResponseFromRequest(inviteTx.Origin(), 200, "OK", "")
+	to, _ := ok.To()
+	to.Params.Add("tag", base.String{S: "a6c85cf"})
+	ok.AddHeader(&base.ContactHeader{
+		DisplayName: base.NoString{},
+		Address:     &base.SipUri{User: base.String{S: "uas"}, Host: "uas-offer.test", UriParams: base.NewParams(), Headers: base.NewParams()},
+		Params:      base.NewParams(),
+	})
+	ok.SetSDP(testSession("uas"))
+	uasDlg, err := NewUasDialog(uas, inviteTx, ok)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create UAS dialog: %s", err)
+	}
+	if uasDlg.OfferState() != OfferLocal {
+		t.Errorf("[FAIL] expected local offer of UAS in 200, got %s", uasDlg.OfferState())
+	}
+	inviteTx.Respond(ok)
+
+	res := finalResponse(t, clientTx)
+	uacDlg, err := NewUacDialog(uac, clientTx, res)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create UAC dialog: %s", err)
+	}
+	if uacDlg.OfferState() != OfferRemote || uacDlg.RemoteSession() == nil {
+		t.Fatalf("[FAIL] expected remote offer of UAC in 200, got %s", uacDlg.OfferState())
+	}
+	if err := uacDlg.Ack(res); !errors.Is(err, ErrOfferPending) {
+		t.Errorf("[FAIL] expected ACK without answer refused, got %v", err)
+	}
+	answer := testSession("uac")
+	if err := uacDlg.AckAnswer(res, answer); err != nil {
+		t.Fatalf("[FAIL] failed to send ACK: %s", err)
+	}
+	if ack := serverTx(t, uasDlg.Requests()).Origin(); !ack.IsAck() || ack.Body() != answer.String() {
+		t.Fatalf("[FAIL] expected ACK with answer, got %s:\n%s", ack.Short(), ack.Body())
+	}
+	if uacDlg.OfferState() != OfferNone || uasDlg.OfferState() != OfferNone {
+		t.Errorf("[FAIL] expected offer answered, got UAC %s, UAS %s", uacDlg.OfferState(), uasDlg.OfferState())
+	}
+	if remote := uasDlg.RemoteSession(); remote == nil || remote.String() != answer.String() {
+		t.Errorf("[FAIL] expected answer of UAC as remote session of UAS, got %v", remote)
+	}
+
+	contentType := &base.GenericHeader{HeaderName: "Content-Type", Contents: sdp.ContentType}
+	reinvite := func(body string) *base.Response {
+		t.Helper()
+		req, err := uacDlg.NewRequest(base.INVITE, "", contentType)
+		if err != nil {
+			t.Fatalf("[FAIL] failed to create re-INVITE: %s", err)
+		}
+		req.SetBody(body)
+		tx, err := uacDlg.Send(req)
+		if err != nil {
+			t.Fatalf("[FAIL] failed to send re-INVITE: %s", err)
+		}
+		return finalResponse(t, tx)
+	}
+	if res := reinvite("broken"); res.StatusCode != 488 {
+		t.Errorf("[FAIL] expected invalid offer rejected with 488, got %s", res.Short())
+	}
+	if _, err := uasDlg.NewRequest(base.INVITE, testSession("uas").String(), contentType); err != nil {
+		t.Fatalf("[FAIL] failed to create re-INVITE: %s", err)
+	}
+	if res := reinvite(testSession("uac").String()); res.StatusCode != 491 {
+		t.Errorf("[FAIL] expected offer during pending offer rejected with 491, got %s", res.Short())
+	}
+	if _, err := uasDlg.NewRequest(base.UPDATE, testSession("uas").String(), contentType); !errors.Is(err, ErrOfferPending) {
+		t.Errorf("[FAIL] expected second offer refused, got %v", err)
+	}
+}
+
+// Test the offers rejected by the media policy with 488 and Warning: of the dialog creating INVITE and of re-INVITE.
+func TestMediaPolicy(t *testing.T) {
+	const uacAddr, uasAddr = "uac-policy.test:5060", "uas-policy.test:5060"
+	uac := newManager(t, uacAddr)
+	defer uac.Stop()
+	uas := newManager(t, uasAddr)
+	defer uas.Stop()
+	policy := LocalMediaPolicy(testSession("uas"))
+
+	video := testSession("uac")
+	video.Media[0].Type = "video"
+	pcma := testSession("uac")
+	pcma.Media[0].Formats = []string{"8"}
+	pcma.Media[0].Attributes = sdp.Attributes{{Name: "rtpmap", Value: "8 PCMA/8000"}}
+	expectWarning := func(res *base.Response, code string) {
+		t.Helper()
+		if res.StatusCode != 488 {
+			t.Fatalf("[FAIL] expected offer rejected with 488, got %s", res.Short())
+		}
+		warnings := res.Headers("Warning")
+		if len(warnings) != 1 || warnings[0].(*base.GenericHeader).Contents[:3] != code {
+			t.Errorf("[FAIL] expected Warning %s, got %v", code, warnings)
+		}
+	}
+
+	invite := func(callId string, offer *sdp.Session) (*transaction.ClientTransaction, *transaction.ServerTransaction) {
+		t.Helper()
+		req := parseRequest(t,
+			"INVITE sip:uas@"+uasAddr+" SIP/2.0",
+			"Via: SIP/2.0/UDP "+uacAddr+";branch=z9hG4bK776as"+callId,
+			"From: <sip:uac@uac.test>;tag=1928301774",
+			"To: <sip:uas@uas.test>",
+			"Call-Id: "+callId,
+			"CSeq: 1 INVITE",
+			"Contact: <sip:uac@"+uacAddr+">",
+			"Content-Length: 0",
+		)
+		req.SetSDP(offer)
+		clientTx := uac.Send(req, uasAddr)
+		return clientTx, serverTx(t, uas.Requests())
+	}
+
+	clientTx, inviteTx := invite("policy-video", video)
+	if err := CheckOffer(inviteTx, policy); !errors.Is(err, ErrNotAcceptable) {
+		t.Errorf("[FAIL] expected video offer rejected, got %v", err)
+	}
+	expectWarning(finalResponse(t, clientTx), "304")
+
+	clientTx, inviteTx = invite("policy-pcma", pcma)
+	if err := CheckOffer(inviteTx, policy); !errors.Is(err, ErrNotAcceptable) {
+		t.Errorf("[FAIL] expected PCMA offer rejected, got %v", err)
+	}
+	expectWarning(finalResponse(t, clientTx), "305")
+
+	clientTx, inviteTx = invite("policy-pcmu", testSession("uac"))
+	if err := CheckOffer(inviteTx, policy); err != nil {
+		t.Fatalf("[FAIL] expected PCMU offer accepted, got %s", err)
+	}
+	ok := base.NewResponseFromRequest(inviteTx.Origin(), 200, "OK", "")
+	to, _ := ok.To()
+	to.Params.Add("tag", base.String{S: "a6c85cf"})
+	ok.AddHeader(&base.ContactHeader{
+		DisplayName: base.NoString{},
+		Address:     &base.SipUri{User: base.String{S: "uas"}, Host: "uas-policy.test", UriParams: base.NewParams(), Headers: base.NewParams()},
+		Params:      base.NewParams(),
+	})
+	ok.SetSDP(testSession("uas"))
+	uasDlg, err := NewUasDialog(uas, inviteTx, ok)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create UAS dialog: %s", err)
+	}
+	uasDlg.SetMediaPolicy(policy)
+	inviteTx.Respond(ok)
+
+	res := finalResponse(t, clientTx)
+	uacDlg, err := NewUacDialog(uac, clientTx, res)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create UAC dialog: %s", err)
+	}
+	if err := uacDlg.Ack(res); err != nil {
+		t.Fatalf("[FAIL] failed to send ACK: %s", err)
+	}
+	serverTx(t, uasDlg.Requests())
+
+	reinvite, err := uacDlg.NewRequest(base.INVITE, video.String(), &base.GenericHeader{HeaderName: "Content-Type", Contents: sdp.ContentType})
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create re-INVITE: %s", err)
+	}
+	tx, err := uacDlg.Send(reinvite)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to send re-INVITE: %s", err)
+	}
+	expectWarning(finalResponse(t, tx), "304")
+	if uasDlg.OfferState() != OfferNone || uasDlg.RemoteSession().Media[0].Type != "audio" {
+		t.Errorf("[FAIL] expected rejected offer not applied, got %s", uasDlg.OfferState())
+	}
+}
+
+// Test hold and resume by re-INVITE of UAC answered by UAS with Respond.
+func TestHoldAndResume(t *testing.T) {
+	const uacAddr, uasAddr = "uac-hold.test:5060", "uas-hold.test:5060"
+	uac := newManager(t, uacAddr)
+	defer uac.Stop()
+	uas := newManager(t, uasAddr)
+	defer uas.Stop()
+
+	invite := parseRequest(t,
+		"INVITE sip:uas@"+uasAddr+" SIP/2.0",
+		"Via: SIP/2.0/UDP "+uacAddr+";branch=z9hG4bK776ashold",
+		"From: <sip:uac@uac.test>;tag=1928301774",
+		"To: <sip:uas@uas.test>",
+		"Call-Id: hold",
+		"CSeq: 1 INVITE",
+		"Contact: <sip:uac@"+uacAddr+">",
+		"Content-Length: 0",
+	)
+	invite.SetSDP(testSession("uac"))
+	clientTx := uac.Send(invite, uasAddr)
+
+	local := testSession("uas")
+	inviteTx := serverTx(t, uas.Requests())
+	ok := inviteTx.NewResponse(200, "OK", &base.ContactHeader{
+		DisplayName: base.NoString{},
+		Address:     &base.SipUri{User: base.String{S: "uas"}, Host: "uas-hold.test", UriParams: base.NewParams(), Headers: base.NewParams()},
+		Params:      base.NewParams(),
+	})
+	offer, _ := inviteTx.Origin().SDP()
+	answer, err := sdp.Answer(offer, local)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to answer: %s", err)
+	}
+	ok.SetSDP(answer)
+	uasDlg, err := NewUasDialog(uas, inviteTx, ok)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create UAS dialog: %s", err)
+	}
+	inviteTx.Respond(ok)
+
+	res := finalResponse(t, clientTx)
+	uacDlg, err := NewUacDialog(uac, clientTx, res)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create UAC dialog: %s", err)
+	}
+	if err := uacDlg.Ack(res); err != nil {
+		t.Fatalf("[FAIL] failed to send ACK: %s", err)
+	}
+	serverTx(t, uasDlg.Requests())
+
+	// answerReinvite answers the re-INVITE and returns the direction of its offer.
+	answerReinvite := func() string {
+		t.Helper()
+		tx := serverTx(t, uasDlg.Requests())
+		offer, err := tx.Origin().SDP()
+		if tx.Origin().Method != base.INVITE || err != nil {
+			t.Fatalf("[FAIL] expected re-INVITE with offer, got %s: %v", tx.Origin().Short(), err)
+		}
+		answer, err := sdp.Answer(offer, local)
+		if err != nil {
+			t.Fatalf("[FAIL] failed to answer: %s", err)
+		}
+		ok := tx.NewResponse(200, "OK")
+		ok.SetSDP(answer)
+		if err := uasDlg.Respond(tx, ok); err != nil {
+			t.Fatalf("[FAIL] failed to respond: %s", err)
+		}
+		if ack := serverTx(t, uasDlg.Requests()).Origin(); !ack.IsAck() {
+			t.Fatalf("[FAIL] expected ACK, got %s", ack.Short())
+		}
+		return offer.Direction(offer.Media[0])
+	}
+	renegotiate := func(name string, do func() (*base.Response, error), offered string, answered string) {
+		t.Helper()
+		version := uacDlg.LocalSession().Origin.SessionVersion
+		done := make(chan error, 1)
+		go func() {
+			_, err := do()
+			done <- err
+		}()
+		if dir := answerReinvite(); dir != offered {
+			t.Errorf("[FAIL] expected %s offering %s, got %s", name, offered, dir)
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("[FAIL] %s failed: %s", name, err)
+		}
+		if got := uacDlg.LocalSession().Origin.SessionVersion; got != version+1 {
+			t.Errorf("[FAIL] expected %s with version %d, got %d", name, version+1, got)
+		}
+		remote := uacDlg.RemoteSession()
+		if dir := remote.Direction(remote.Media[0]); dir != answered || uacDlg.OfferState() != OfferNone {
+			t.Errorf("[FAIL] expected %s answered with %s, got %s in state %s", name, answered, dir, uacDlg.OfferState())
+		}
+	}
+	renegotiate("hold", uacDlg.Hold, sdp.SendOnly, sdp.RecvOnly)
+	renegotiate("resume", uacDlg.Resume, sdp.SendRecv, sdp.SendRecv)
+}
@@ -0,0 +1,226 @@
+package dialog
+
+import (
+	"errors"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/sdp"
+	"github.com/ghettovoice/gossip/transaction"
+)
+
+// OfferState is the state of the offer/answer exchange of the dialog - RFC 3261 13.2.1, RFC 3264.
+// Offers are carried by INVITE and UPDATE requests or, for INVITE without an offer (delayed offer),
+// by the 2xx response to it; the answer of the offer in 2xx is carried by ACK.
+type OfferState int
+
+const (
+	// OfferNone means no offer waits for an answer.
+	OfferNone OfferState = iota
+	// OfferLocal means the offer sent to the remote side waits for its answer.
+	OfferLocal
+	// OfferRemote means the offer received from the remote side waits for the local answer.
+	OfferRemote
+)
+
+func (s OfferState) String() string {
+	switch s {
+	case OfferNone:
+		return "None"
+	case OfferLocal:
+		return "Local"
+	case OfferRemote:
+		return "Remote"
+	default:
+		return "Unknown"
+	}
+}
+
+// Errors of the offer/answer exchange, use errors.Is to check them.
+var (
+	// ErrOfferPending means a new offer while the previous one is not answered, or a missing answer - RFC 3261 14.1.
+	// In-dialog requests with such offers are rejected with 491 Request Pending.
+	ErrOfferPending = errors.New("offer pending")
+	// ErrNotAcceptable means the session description is invalid or misplaced,
+	// in-dialog requests with it are rejected with 488 Not Acceptable Here.
+	ErrNotAcceptable = errors.New("session description not acceptable")
+)
+
+// sessionMessage is a request or a response possibly carrying the session description.
+type sessionMessage interface {
+	Short() string
+	Body() string
+	SDP() (*sdp.Session, error)
+}
+
+// OfferState returns the state of the offer/answer exchange of the dialog.
+func (dlg *Dialog) OfferState() OfferState {
+	dlg.lock.RLock()
+	defer dlg.lock.RUnlock()
+	return dlg.offer
+}
+
+// LocalSession returns the last session description sent in the dialog, the offer or the answer, nil if none.
+func (dlg *Dialog) LocalSession() *sdp.Session {
+	dlg.lock.RLock()
+	defer dlg.lock.RUnlock()
+	return dlg.localSession
+}
+
+// RemoteSession returns the last session description received in the dialog, the offer or the answer, nil if none.
+func (dlg *Dialog) RemoteSession() *sdp.Session {
+	dlg.lock.RLock()
+	defer dlg.lock.RUnlock()
+	return dlg.remoteSession
+}
+
+// Respond sends the response to INVITE or UPDATE received in the dialog, applying its session description:
+// 2xx answers the offer of the request or, if the request had none, may carry the offer answered by ACK.
+// Final non-2xx response rejects the offer of the request, the session is left as it was.
+// Other requests are responded as is.
+func (dlg *Dialog) Respond(tx *transaction.ServerTransaction, res *base.Response) error {
+	method := tx.Origin().Method
+	if (method == base.INVITE || method == base.UPDATE) && !res.IsProvisional() {
+		if err := dlg.sendAnswer(res); err != nil {
+			return err
+		}
+	}
+	if method == base.INVITE && res.IsSuccess() {
+		if cseq, err := tx.Origin().CSeq(); err == nil {
+			dlg.expectAck(cseq.SeqNo)
+		}
+	}
+	tx.Respond(res)
+	return nil
+}
+
+// sendAnswer applies the final response to the request carrying the remote offer or asking for the local one.
+func (dlg *Dialog) sendAnswer(res *base.Response) error {
+	if !res.IsSuccess() {
+		dlg.lock.Lock()
+		if dlg.offer == OfferRemote {
+			dlg.offer = OfferNone
+		}
+		dlg.lock.Unlock()
+		return nil
+	}
+	if res.Body() == "" && dlg.OfferState() == OfferRemote {
+		return base.NewError(ErrOfferPending, nil, "response %s doesn't answer the offer of dialog %s", res.Short(), dlg.id)
+	}
+	return dlg.sendSession(res, false)
+}
+
+// receiveAnswer applies the final response to INVITE or UPDATE sent in the dialog once,
+// so that the retransmissions of 2xx are not taken for new offers.
+func (dlg *Dialog) receiveAnswer(res *base.Response) error {
+	cseq, err := res.CSeq()
+	if err != nil {
+		return err
+	}
+	if res.IsProvisional() || (cseq.MethodName != base.INVITE && cseq.MethodName != base.UPDATE) {
+		return nil
+	}
+
+	dlg.lock.Lock()
+	if cseq.SeqNo <= dlg.answeredSeq {
+		dlg.lock.Unlock()
+		return nil
+	}
+	dlg.answeredSeq = cseq.SeqNo
+	if !res.IsSuccess() {
+		// The rejected offer is withdrawn, the session is left as it was - RFC 3261 14.1.
+		if dlg.offer == OfferLocal {
+			dlg.offer = OfferNone
+		}
+		dlg.lock.Unlock()
+		return nil
+	}
+	missing := res.Body() == "" && dlg.offer == OfferLocal
+	dlg.lock.Unlock()
+
+	if missing {
+		return base.NewError(ErrNotAcceptable, nil, "response %s doesn't answer the offer of dialog %s", res.Short(), dlg.id)
+	}
+	return dlg.receiveSession(res, false)
+}
+
+// withdrawOffer withdraws the local offer left without the answer, e.g. its transaction timed out.
+func (dlg *Dialog) withdrawOffer() {
+	dlg.lock.Lock()
+	defer dlg.lock.Unlock()
+	if dlg.offer == OfferLocal {
+		dlg.offer = OfferNone
+	}
+}
+
+// receiveAck applies ACK of 2xx, it must carry the answer if the 2xx carried the offer - RFC 3261 13.2.1.
+// ACK can't carry an offer, so its body is ignored otherwise.
+func (dlg *Dialog) receiveAck(ack *base.Request) error {
+	if dlg.OfferState() != OfferLocal {
+		if ack.Body() != "" {
+			dlg.Log().Warnf("ignoring session description of %s, no offer to answer", ack.Short())
+		}
+		return nil
+	}
+	if ack.Body() == "" {
+		return base.NewError(ErrNotAcceptable, nil, "%s doesn't answer the offer of dialog %s", ack.Short(), dlg.id)
+	}
+	return dlg.receiveSession(ack, false)
+}
+
+// sendSession applies the session description of the message sent in the dialog:
+// it answers the pending remote offer or makes a new offer. Requests always make offers.
+func (dlg *Dialog) sendSession(msg sessionMessage, request bool) error {
+	if msg.Body() == "" {
+		return nil
+	}
+	session, err := msg.SDP()
+	if err != nil {
+		return base.NewError(ErrNotAcceptable, err, "invalid session description of %s", msg.Short())
+	}
+
+	dlg.lock.Lock()
+	defer dlg.lock.Unlock()
+	switch {
+	case dlg.offer == OfferLocal || (request && dlg.offer == OfferRemote):
+		return base.NewError(ErrOfferPending, nil, "offer of dialog %s is not answered yet", dlg.id)
+	case dlg.offer == OfferRemote:
+		dlg.offer = OfferNone
+	default:
+		dlg.offer = OfferLocal
+	}
+	dlg.localSession = session
+	return nil
+}
+
+// receiveSession applies the session description of the message received in the dialog:
+// it answers the pending local offer or makes a new offer. Requests always make offers, checked by the media policy.
+func (dlg *Dialog) receiveSession(msg sessionMessage, request bool) error {
+	if msg.Body() == "" {
+		return nil
+	}
+	session, err := msg.SDP()
+	if err != nil {
+		return base.NewError(ErrNotAcceptable, err, "invalid session description of %s", msg.Short())
+	}
+	if request {
+		dlg.lock.RLock()
+		policy := dlg.policy
+		dlg.lock.RUnlock()
+		if err := checkOffer(msg, session, policy); err != nil {
+			return err
+		}
+	}
+
+	dlg.lock.Lock()
+	defer dlg.lock.Unlock()
+	switch {
+	case dlg.offer == OfferRemote || (request && dlg.offer == OfferLocal):
+		return base.NewError(ErrOfferPending, nil, "offer of dialog %s is not answered yet", dlg.id)
+	case dlg.offer == OfferLocal:
+		dlg.offer = OfferNone
+	default:
+		dlg.offer = OfferRemote
+	}
+	dlg.remoteSession = session
+	return nil
+}
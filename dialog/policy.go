@@ -0,0 +1,124 @@
+package dialog
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/sdp"
+	"github.com/ghettovoice/gossip/transaction"
+)
+
+// Warning codes of the offers rejected by the media policy - RFC 3261 20.43.
+const (
+	// WarnMediaTypeNotAvailable means none of the offered media types is supported, e.g. video only offer to audio only UA.
+	WarnMediaTypeNotAvailable = 304
+	// WarnIncompatibleMediaFormat means none of the offered formats of the supported media types is supported.
+	WarnIncompatibleMediaFormat = 305
+)
+
+// MediaPolicy decides whether the offer received from the remote side is acceptable, returns *MediaError to reject it.
+// The requests with rejected offers are answered with 488 Not Acceptable Here carrying Warning of the MediaError,
+// other errors reject them with 488 without Warning - RFC 3261 13.3.1.3, 21.4.26.
+type MediaPolicy func(offer *sdp.Session) error
+
+// MediaError describes the unacceptable media of the offer rejected by MediaPolicy.
+type MediaError struct {
+	// Code is the warn-code of Warning, e.g. WarnMediaTypeNotAvailable.
+	Code uint16
+	// Text is the warn-text of Warning, e.g. "Incompatible media format".
+	Text string
+}
+
+func (err *MediaError) Error() string {
+	return fmt.Sprintf("%d %s", err.Code, err.Text)
+}
+
+// header returns Warning header of the rejection.
+func (err *MediaError) header() base.SipHeader {
+	return &base.GenericHeader{
+		HeaderName: "Warning",
+		Contents:   fmt.Sprintf("%d gossip %q", err.Code, err.Text),
+	}
+}
+
+// LocalMediaPolicy accepts the offers sdp.Answer can answer with the local capabilities, see sdp.Answer:
+// the offers without active streams of the local media types are rejected with WarnMediaTypeNotAvailable,
+// the offers without formats supported by the local media with WarnIncompatibleMediaFormat.
+func LocalMediaPolicy(local *sdp.Session) MediaPolicy {
+	return func(offer *sdp.Session) error {
+		available := false
+		for _, offered := range offer.Media {
+			if offered.Port == 0 {
+				continue
+			}
+			for _, supported := range local.Media {
+				if supported.Type == offered.Type && supported.Proto == offered.Proto {
+					available = true
+				}
+			}
+		}
+		if !available {
+			return &MediaError{Code: WarnMediaTypeNotAvailable, Text: "Media type not available"}
+		}
+		if _, err := sdp.Answer(offer, local); err != nil {
+			return &MediaError{Code: WarnIncompatibleMediaFormat, Text: "Incompatible media format"}
+		}
+		return nil
+	}
+}
+
+// SetMediaPolicy sets the policy checking the offers of in-dialog requests, e.g. re-INVITE and UPDATE,
+// nil accepts every valid offer. Check the offer of the dialog creating request with CheckOffer.
+func (dlg *Dialog) SetMediaPolicy(policy MediaPolicy) {
+	dlg.lock.Lock()
+	defer dlg.lock.Unlock()
+	dlg.policy = policy
+}
+
+// CheckOffer checks the offer of the dialog creating request received on the transaction, e.g. INVITE, with the policy.
+// The invalid offer or the one rejected by the policy is answered with 488 Not Acceptable Here, with Warning
+// describing the unacceptable media, and the error of kind ErrNotAcceptable is returned.
+// The request without the offer passes, the offer is then made by the response.
+func CheckOffer(tx *transaction.ServerTransaction, policy MediaPolicy) error {
+	req := tx.Origin()
+	if req.Body() == "" {
+		return nil
+	}
+	offer, err := req.SDP()
+	if err != nil {
+		err = base.NewError(ErrNotAcceptable, err, "invalid session description of %s", req.Short())
+	} else {
+		err = checkOffer(req, offer, policy)
+	}
+	if err != nil {
+		req.Log().Warnf("rejecting request %s: %s", req.Short(), err)
+		rejectOffer(tx, err)
+	}
+	return err
+}
+
+// checkOffer checks the offer of the received message with the policy.
+func checkOffer(msg sessionMessage, offer *sdp.Session, policy MediaPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if err := policy(offer); err != nil {
+		return base.NewError(ErrNotAcceptable, err, "offer of %s rejected by media policy", msg.Short())
+	}
+	return nil
+}
+
+// rejectOffer answers the request with the offer violating the offer/answer model or rejected by the media policy.
+func rejectOffer(tx *transaction.ServerTransaction, err error) {
+	if errors.Is(err, ErrOfferPending) {
+		tx.RespondWithStatus(491, "Request Pending")
+		return
+	}
+	var hdrs []base.SipHeader
+	var mediaErr *MediaError
+	if errors.As(err, &mediaErr) {
+		hdrs = append(hdrs, mediaErr.header())
+	}
+	tx.RespondWithStatus(488, "Not Acceptable Here", hdrs...)
+}
@@ -0,0 +1,39 @@
+package dialog
+
+import (
+	"fmt"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/transaction"
+)
+
+// Refer sends REFER asking the remote party to send the request to the target, INVITE unless the target URI
+// has method parameter - RFC 3515 2.4.1. The progress is reported by NOTIFYs of refer event passed to Requests.
+// Refer of the conference URI invites the remote party to the conference - RFC 4579 5.4.
+func (dlg *Dialog) Refer(target base.Uri, hdrs ...base.SipHeader) (*transaction.ClientTransaction, error) {
+	hdrs = append([]base.SipHeader{base.NewReferToHeader(target)}, hdrs...)
+	req, err := dlg.NewRequest(base.REFER, "", hdrs...)
+	if err != nil {
+		return nil, err
+	}
+	return dlg.Send(req)
+}
+
+// NotifyRefer reports the status of the request sent on REFER received in the dialog by NOTIFY with message/sipfrag
+// of the status line - RFC 3515 2.4.4, 2.4.5. The final status terminates the implicit subscription.
+func (dlg *Dialog) NotifyRefer(code uint16, reason string) (*transaction.ClientTransaction, error) {
+	state := "active"
+	if code >= 200 {
+		state = "terminated;reason=noresource"
+	}
+	body := base.ReferProgress(code, reason)
+	req, err := dlg.NewRequest(base.NOTIFY, body,
+		&base.GenericHeader{HeaderName: "Event", Contents: base.EventRefer},
+		&base.GenericHeader{HeaderName: "Subscription-State", Contents: state},
+		&base.GenericHeader{HeaderName: "Content-Type", Contents: base.ContentTypeSipfrag},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build NOTIFY of REFER progress: %s", err)
+	}
+	return dlg.Send(req)
+}
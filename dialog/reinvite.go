@@ -0,0 +1,80 @@
+package dialog
+
+import (
+	"fmt"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/sdp"
+)
+
+// Renegotiate modifies the session by re-INVITE offering the session description - RFC 3261 14.1, RFC 3264 8.
+// The offer keeps the origin of the last local session description with the version incremented.
+// It waits for the final response, acknowledges 2xx and applies the answer, see RemoteSession.
+// The final response is returned; non-2xx responses reject the offer, leaving the session as it was,
+// and fail, with ErrOfferPending on 491 Request Pending: the offer crossed the one of the remote side
+// and may be retried after a while - RFC 3261 14.1.
+func (dlg *Dialog) Renegotiate(session *sdp.Session) (*base.Response, error) {
+	offer := session.Copy()
+	if local := dlg.LocalSession(); local != nil {
+		offer.Origin = local.Origin
+		offer.NextVersion()
+	}
+	req, err := dlg.NewRequest(base.INVITE, offer.String(), &base.GenericHeader{HeaderName: "Content-Type", Contents: sdp.ContentType})
+	if err != nil {
+		return nil, err
+	}
+	tx, err := dlg.Send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		select {
+		case res := <-tx.Responses():
+			if res.IsProvisional() {
+				continue
+			}
+			if !res.IsSuccess() {
+				dlg.Update(res)
+				if res.StatusCode == 491 {
+					return res, base.NewError(ErrOfferPending, nil, "re-INVITE of dialog %s rejected with %s", dlg.id, res.Short())
+				}
+				return res, fmt.Errorf("re-INVITE of dialog %s rejected with %s", dlg.id, res.Short())
+			}
+			if err := dlg.Ack(res); err != nil {
+				return res, err
+			}
+			if dlg.OfferState() != OfferNone {
+				return res, base.NewError(ErrNotAcceptable, nil, "response %s doesn't answer the offer of dialog %s", res.Short(), dlg.id)
+			}
+			return res, nil
+		case err := <-tx.Errors():
+			// The offer got no answer, so it's withdrawn.
+			dlg.withdrawOffer()
+			return nil, err
+		}
+	}
+}
+
+// Hold puts the session on hold by re-INVITE: the media streams sent by the remote side are stopped,
+// sendrecv streams become sendonly, recvonly ones inactive - RFC 3264 8.4. See Renegotiate.
+func (dlg *Dialog) Hold() (*base.Response, error) {
+	local := dlg.LocalSession()
+	if local == nil {
+		return nil, fmt.Errorf("dialog %s has no session to hold", dlg.id)
+	}
+	held := local.Copy()
+	held.Hold()
+	return dlg.Renegotiate(held)
+}
+
+// Resume takes the session put on hold by Hold off it by re-INVITE. See Renegotiate.
+func (dlg *Dialog) Resume() (*base.Response, error) {
+	local := dlg.LocalSession()
+	if local == nil {
+		return nil, fmt.Errorf("dialog %s has no session to resume", dlg.id)
+	}
+	resumed := local.Copy()
+	resumed.Resume()
+	return dlg.Renegotiate(resumed)
+}
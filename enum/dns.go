@@ -0,0 +1,232 @@
+package enum
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	c_DNS_TYPE_NAPTR     = 35
+	c_DNS_CLASS_IN       = 1
+	c_DNS_RCODE_NXDOMAIN = 3
+	c_DNS_TIMEOUT        = 2 * time.Second
+	c_DNS_MAX_SIZE       = 4096
+)
+
+// Naptr is a NAPTR resource record - RFC 3403 4.
+type Naptr struct {
+	Order       uint16
+	Preference  uint16
+	Flags       string
+	Services    string
+	Regexp      string
+	Replacement string
+	// Time to live of the record.
+	TTL time.Duration
+}
+
+// NaptrLookup queries NAPTR records of the domain.
+// Non-existent domain is reported as no records and nil error.
+type NaptrLookup func(domain string) ([]Naptr, error)
+
+// DnsLookup returns NaptrLookup querying the DNS server over UDP.
+// Empty server means the first nameserver from /etc/resolv.conf.
+func DnsLookup(server string) NaptrLookup {
+	return func(domain string) ([]Naptr, error) {
+		addr := server
+		if addr == "" {
+			var err error
+			if addr, err = systemNameserver(); err != nil {
+				return nil, err
+			}
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "53")
+		}
+
+		return queryNaptr(addr, domain)
+	}
+}
+
+func systemNameserver() (string, error) {
+	file, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return fields[1], nil
+		}
+	}
+	return "", fmt.Errorf("no nameserver found in /etc/resolv.conf")
+}
+
+func queryNaptr(addr string, domain string) ([]Naptr, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	id := uint16(rand.Intn(0x10000))
+	query, err := newNaptrQuery(id, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(c_DNS_TIMEOUT))
+	if _, err = conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, c_DNS_MAX_SIZE)
+	for {
+		num, err := conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("NAPTR query of %s to %s failed: %s", domain, addr, err)
+		}
+		// Responses with other ids are late answers to the previous queries.
+		if num >= 2 && binary.BigEndian.Uint16(buf) == id {
+			return parseNaptrResponse(buf[:num])
+		}
+	}
+}
+
+// newNaptrQuery builds DNS query of NAPTR records with recursion desired - RFC 1035 4.1.
+func newNaptrQuery(id uint16, domain string) ([]byte, error) {
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], 0x0100)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+
+	for _, label := range strings.Split(strings.TrimSuffix(domain, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid domain name '%s'", domain)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, 0, c_DNS_TYPE_NAPTR, 0, c_DNS_CLASS_IN)
+
+	return msg, nil
+}
+
+// parseNaptrResponse extracts NAPTR records from the answer section of DNS response.
+func parseNaptrResponse(msg []byte) ([]Naptr, error) {
+	if len(msg) < 12 {
+		return nil, fmt.Errorf("DNS response too short")
+	}
+
+	rcode := msg[3] & 0x0f
+	if rcode == c_DNS_RCODE_NXDOMAIN {
+		return []Naptr{}, nil
+	}
+	if rcode != 0 {
+		return nil, fmt.Errorf("DNS query failed with rcode %d", rcode)
+	}
+
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+	offset := 12
+	var err error
+	for i := 0; i < qdcount; i++ {
+		if _, offset, err = readName(msg, offset); err != nil {
+			return nil, err
+		}
+		offset += 4
+	}
+
+	records := make([]Naptr, 0, ancount)
+	for i := 0; i < ancount; i++ {
+		if _, offset, err = readName(msg, offset); err != nil {
+			return nil, err
+		}
+		if offset+10 > len(msg) {
+			return nil, fmt.Errorf("truncated DNS resource record")
+		}
+		rrtype := binary.BigEndian.Uint16(msg[offset:])
+		ttl := binary.BigEndian.Uint32(msg[offset+4:])
+		rdlength := int(binary.BigEndian.Uint16(msg[offset+8:]))
+		offset += 10
+		if offset+rdlength > len(msg) {
+			return nil, fmt.Errorf("truncated DNS resource record data")
+		}
+
+		if rrtype == c_DNS_TYPE_NAPTR {
+			record, err := parseNaptr(msg, offset, offset+rdlength)
+			if err != nil {
+				return nil, err
+			}
+			record.TTL = time.Duration(ttl) * time.Second
+			records = append(records, record)
+		}
+		offset += rdlength
+	}
+
+	return records, nil
+}
+
+func parseNaptr(msg []byte, offset int, end int) (record Naptr, err error) {
+	if offset+4 > end {
+		return record, fmt.Errorf("truncated NAPTR record")
+	}
+	record.Order = binary.BigEndian.Uint16(msg[offset:])
+	record.Preference = binary.BigEndian.Uint16(msg[offset+2:])
+	offset += 4
+
+	for _, field := range []*string{&record.Flags, &record.Services, &record.Regexp} {
+		if offset >= end || offset+1+int(msg[offset]) > end {
+			return record, fmt.Errorf("truncated NAPTR record")
+		}
+		*field = string(msg[offset+1 : offset+1+int(msg[offset])])
+		offset += 1 + int(msg[offset])
+	}
+
+	record.Replacement, _, err = readName(msg, offset)
+	return
+}
+
+// readName reads possibly compressed domain name - RFC 1035 4.1.4.
+// Returns the name and the offset right after it.
+func readName(msg []byte, offset int) (string, int, error) {
+	labels := make([]string, 0)
+	next := -1
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, fmt.Errorf("truncated DNS name")
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(msg) || jumps > 10 {
+				return "", 0, fmt.Errorf("invalid DNS name compression")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3fff)
+			jumps++
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, fmt.Errorf("truncated DNS name")
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package enum
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// naptrResponse answers the query with the records, the replacement is compressed to the query name.
+func naptrResponse(query []byte, records []Naptr) []byte {
+	msg := append([]byte{}, query...)
+	msg[2] |= 0x80
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(records)))
+	for _, record := range records {
+		rdata := make([]byte, 4)
+		binary.BigEndian.PutUint16(rdata, record.Order)
+		binary.BigEndian.PutUint16(rdata[2:], record.Preference)
+		for _, field := range []string{record.Flags, record.Services, record.Regexp} {
+			rdata = append(rdata, byte(len(field)))
+			rdata = append(rdata, field...)
+		}
+		rdata = append(rdata, 0)
+
+		rr := []byte{0xc0, 12, 0, c_DNS_TYPE_NAPTR, 0, c_DNS_CLASS_IN, 0, 0, 0x0e, 0x10, 0, 0}
+		binary.BigEndian.PutUint16(rr[10:], uint16(len(rdata)))
+		msg = append(msg, rr...)
+		msg = append(msg, rdata...)
+	}
+	return msg
+}
+
+func TestDnsLookup(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to start DNS server: %s", err)
+	}
+	defer server.Close()
+
+	record := Naptr{Order: 100, Preference: 10, Flags: "u", Services: "E2U+sip", Regexp: "!^.*$!sip:bob@example.com!"}
+	go func() {
+		buf := make([]byte, 512)
+		num, addr, err := server.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		server.WriteTo(naptrResponse(buf[:num], []Naptr{record}), addr)
+	}()
+
+	records, err := DnsLookup(server.LocalAddr().String())("4.3.2.1.5.5.5.1.e164.arpa")
+	if err != nil {
+		t.Fatalf("[FAIL] NAPTR lookup failed: %s", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("[FAIL] expected 1 NAPTR record, got %d", len(records))
+	}
+	expected := record
+	expected.TTL = time.Hour
+	if records[0] != expected {
+		t.Errorf("[FAIL] expected record %v, got %v", expected, records[0])
+	}
+}
@@ -0,0 +1,302 @@
+// Package enum resolves E.164 telephone numbers to SIP URIs via ENUM - RFC 6116.
+package enum
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+	"github.com/ghettovoice/gossip/timing"
+	"github.com/ghettovoice/gossip/transaction"
+)
+
+const (
+	// Default ENUM zone - RFC 6116 4.
+	DefaultSuffix = "e164.arpa"
+	// ENUM service of SIP - RFC 3764.
+	ServiceSip = "E2U+sip"
+	// Lifetime of cached results, including negative ones, if not limited by the records TTL.
+	DefaultCacheTTL = 5 * time.Minute
+)
+
+// Config of the Resolver.
+type Config struct {
+	// Zones to query in order, the first one with matching records wins. Defaults to DefaultSuffix.
+	Suffixes []string
+	// Function querying NAPTR records, defaults to DnsLookup of the system nameserver.
+	Lookup NaptrLookup
+	// Upper limit of the cache lifetime, 0 means DefaultCacheTTL, negative disables the cache.
+	CacheTTL time.Duration
+}
+
+// Resolver maps E.164 numbers to SIP URIs using e2u+sip NAPTR records.
+type Resolver struct {
+	cfg   Config
+	cache map[string]cacheEntry
+	lock  sync.Mutex
+}
+
+type cacheEntry struct {
+	uris    []string
+	expires time.Time
+}
+
+func NewResolver(cfg Config) *Resolver {
+	if len(cfg.Suffixes) == 0 {
+		cfg.Suffixes = []string{DefaultSuffix}
+	}
+	if cfg.Lookup == nil {
+		cfg.Lookup = DnsLookup("")
+	}
+	if cfg.CacheTTL == 0 {
+		cfg.CacheTTL = DefaultCacheTTL
+	}
+
+	return &Resolver{
+		cfg:   cfg,
+		cache: make(map[string]cacheEntry),
+	}
+}
+
+// Lookup returns URIs of the SIP service of the E.164 number in order of preference.
+// Empty result means the number is not provisioned in ENUM.
+func (r *Resolver) Lookup(number string) ([]string, error) {
+	number, err := NormalizeNumber(number)
+	if err != nil {
+		return nil, err
+	}
+
+	if uris, ok := r.cached(number); ok {
+		return uris, nil
+	}
+
+	var uris []string
+	ttl := r.cfg.CacheTTL
+	for _, suffix := range r.cfg.Suffixes {
+		records, err := r.cfg.Lookup(Domain(number, suffix))
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			if record.TTL < ttl {
+				ttl = record.TTL
+			}
+		}
+
+		if uris, err = applyNaptrs(number, records); err != nil {
+			return nil, err
+		}
+		if len(uris) > 0 {
+			break
+		}
+	}
+
+	r.store(number, uris, ttl)
+	return uris, nil
+}
+
+// Resolve maps the SIP URI carrying E.164 number in the user part, e.g. sip:+15551234@example.com;user=phone,
+// or tel URI to the SIP URI of the number's SIP service.
+func (r *Resolver) Resolve(uri base.Uri) (*base.SipUri, error) {
+	number, err := UriNumber(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	uris, err := r.Lookup(number)
+	if err != nil {
+		return nil, err
+	}
+	for _, str := range uris {
+		parsed, err := parser.ParseUri(str)
+		if err != nil {
+			log.Debugf("skipping ENUM result '%s' of %s: %s", str, number, err)
+			continue
+		}
+		if sipUri, ok := parsed.(*base.SipUri); ok {
+			return sipUri, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no SIP URI found in ENUM for %s", number)
+}
+
+// Router returns transaction.Router forwarding requests to E.164 numbers to the SIP URIs found in ENUM.
+// Requests to other targets, or to numbers not found in ENUM, are passed to the next router, if any.
+func (r *Resolver) Router(next transaction.Router) transaction.Router {
+	return func(req *base.Request) transaction.Route {
+		if _, err := UriNumber(req.Recipient); err == nil {
+			target, err := r.Resolve(req.Recipient)
+			if err == nil {
+				return transaction.Route{Recipient: target, Forward: uriAddr(target)}
+			}
+			req.Log().Debugf("ENUM resolution of %s failed: %s", req.Recipient, err)
+		}
+
+		if next != nil {
+			return next(req)
+		}
+		return transaction.Route{}
+	}
+}
+
+// HealthCheck checks that the ENUM zones are reachable by querying their apexes, bypassing the cache.
+// Suitable as transaction.HealthCheck of the manager readiness.
+func (r *Resolver) HealthCheck() error {
+	for _, suffix := range r.cfg.Suffixes {
+		if _, err := r.cfg.Lookup(strings.Trim(suffix, ".")); err != nil {
+			return fmt.Errorf("ENUM zone %s is unreachable: %s", suffix, err)
+		}
+	}
+	return nil
+}
+
+// Domain returns the ENUM domain of the number in the zone - RFC 6116 2.4,
+// e.g. 4.3.2.1.5.5.5.1.e164.arpa for +15551234.
+func Domain(number string, suffix string) string {
+	digits := strings.TrimPrefix(number, "+")
+	labels := make([]string, 0, len(digits)+1)
+	for i := len(digits) - 1; i >= 0; i-- {
+		labels = append(labels, digits[i:i+1])
+	}
+	labels = append(labels, strings.Trim(suffix, "."))
+	return strings.Join(labels, ".")
+}
+
+// NormalizeNumber strips visual separators from the E.164 number - RFC 3966 5.1.1.
+func NormalizeNumber(number string) (string, error) {
+	return base.NormalizeNumber(number)
+}
+
+// UriNumber extracts the global E.164 number from tel URI or the user part of the SIP URI.
+func UriNumber(uri base.Uri) (string, error) {
+	if number, ok := base.PhoneNumber(uri); ok {
+		return NormalizeNumber(number)
+	}
+	sipUri, ok := uri.(*base.SipUri)
+	if !ok || sipUri.User == nil {
+		return "", fmt.Errorf("URI %v has no telephone number", uri)
+	}
+	user, ok := sipUri.User.(base.String)
+	if !ok {
+		return "", fmt.Errorf("URI %v has no telephone number", uri)
+	}
+	// The user part may carry telephone-subscriber parameters - RFC 3261 19.1.6.
+	return NormalizeNumber(user.S)
+}
+
+// applyNaptrs selects terminal e2u+sip records with the lowest order
+// and applies their substitution expressions to the number - RFC 6116 3.2.
+// Non-terminal records are not supported and skipped.
+func applyNaptrs(number string, records []Naptr) ([]string, error) {
+	candidates := make([]Naptr, 0, len(records))
+	for _, record := range records {
+		if strings.EqualFold(record.Flags, "u") && hasSipService(record.Services) {
+			candidates = append(candidates, record)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Order != candidates[j].Order {
+			return candidates[i].Order < candidates[j].Order
+		}
+		return candidates[i].Preference < candidates[j].Preference
+	})
+
+	uris := make([]string, 0, len(candidates))
+	for _, record := range candidates {
+		// Records of higher order are used only if none of the lower order ones match - RFC 3403 4.
+		if len(uris) > 0 && record.Order != candidates[0].Order {
+			break
+		}
+		uri, ok, err := substitute(record.Regexp, number)
+		if err != nil {
+			log.Debugf("skipping invalid NAPTR regexp '%s': %s", record.Regexp, err)
+			continue
+		}
+		if ok {
+			uris = append(uris, uri)
+		}
+	}
+
+	return uris, nil
+}
+
+// hasSipService checks whether the services field lists E2U+sip, e.g. E2U+sip or E2U+voice:sip.
+func hasSipService(services string) bool {
+	parts := strings.Split(strings.ToLower(services), "+")
+	if len(parts) < 2 || parts[0] != "e2u" {
+		return false
+	}
+	for _, service := range parts[1:] {
+		if service == "sip" || strings.HasSuffix(service, ":sip") {
+			return true
+		}
+	}
+	return false
+}
+
+// substitute applies delim-char ere delim-char repl delim-char *flags expression - RFC 3402 3.2.
+func substitute(expr string, aus string) (string, bool, error) {
+	if len(expr) < 3 {
+		return "", false, fmt.Errorf("expression too short")
+	}
+	parts := strings.Split(expr[1:], expr[:1])
+	if len(parts) != 3 {
+		return "", false, fmt.Errorf("expected 3 delimited parts")
+	}
+
+	pattern := parts[0]
+	if strings.Contains(parts[2], "i") {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", false, err
+	}
+
+	match := re.FindStringSubmatchIndex(aus)
+	if match == nil {
+		return "", false, nil
+	}
+	// Backreferences are \1..\9 in the replacement.
+	repl := regexp.MustCompile(`\\([0-9])`).ReplaceAllString(parts[1], "$${$1}")
+	return string(re.ExpandString(nil, repl, aus, match)), true, nil
+}
+
+// uriAddr returns host:port the SIP URI points to.
+func uriAddr(uri *base.SipUri) string {
+	return net.JoinHostPort(uri.Host, strconv.Itoa(int(base.UriPort(uri))))
+}
+
+func (r *Resolver) cached(number string) ([]string, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	entry, ok := r.cache[number]
+	if !ok {
+		return nil, false
+	}
+	if timing.Now().After(entry.expires) {
+		delete(r.cache, number)
+		return nil, false
+	}
+	return entry.uris, true
+}
+
+func (r *Resolver) store(number string, uris []string, ttl time.Duration) {
+	if r.cfg.CacheTTL < 0 {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cache[number] = cacheEntry{uris: uris, expires: timing.Now().Add(ttl)}
+}
@@ -0,0 +1,126 @@
+package enum
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/timing"
+)
+
+func TestDomain(t *testing.T) {
+	number, err := NormalizeNumber("+1-555-123.4")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to normalize number: %s", err)
+	}
+	if domain := Domain(number, DefaultSuffix); domain != "4.3.2.1.5.5.5.1.e164.arpa" {
+		t.Errorf("[FAIL] unexpected ENUM domain %s", domain)
+	}
+
+	for _, invalid := range []string{"5551234", "+", "+1555abc"} {
+		if _, err := NormalizeNumber(invalid); err == nil {
+			t.Errorf("[FAIL] expected '%s' to be rejected", invalid)
+		}
+	}
+
+	if number, err := UriNumber(&base.AbsoluteUri{Scheme: "tel", Opaque: "+1-555-1234;ext=22"}); err != nil || number != "+15551234" {
+		t.Errorf("[FAIL] expected number +15551234 of tel URI, got '%s': %v", number, err)
+	}
+}
+
+func TestResolverLookup(t *testing.T) {
+	timing.MockMode = true
+	queries := make([]string, 0)
+	records := map[string][]Naptr{
+		"4.3.2.1.5.5.5.1.e164.example.net": {
+			{Order: 100, Preference: 20, Flags: "u", Services: "E2U+sip", Regexp: "!^\\+1(.*)$!sip:\\1@backup.example.com!", TTL: time.Hour},
+			{Order: 100, Preference: 10, Flags: "u", Services: "E2U+sip", Regexp: "!^.*$!sip:bob@example.com!", TTL: time.Minute},
+			{Order: 100, Preference: 5, Flags: "u", Services: "E2U+email:mailto", Regexp: "!^.*$!mailto:bob@example.com!"},
+			{Order: 200, Preference: 1, Flags: "u", Services: "E2U+sip", Regexp: "!^.*$!sip:never@example.com!"},
+		},
+	}
+	resolver := NewResolver(Config{
+		Suffixes: []string{"e164.arpa", "e164.example.net"},
+		Lookup: func(domain string) ([]Naptr, error) {
+			queries = append(queries, domain)
+			return records[domain], nil
+		},
+	})
+
+	uris, err := resolver.Lookup("+15551234")
+	if err != nil {
+		t.Fatalf("[FAIL] lookup failed: %s", err)
+	}
+	if len(uris) != 2 || uris[0] != "sip:bob@example.com" || uris[1] != "sip:5551234@backup.example.com" {
+		t.Errorf("[FAIL] unexpected lookup result %v", uris)
+	}
+	if len(queries) != 2 {
+		t.Errorf("[FAIL] expected both zones queried, got %v", queries)
+	}
+
+	// The result is cached for the lowest TTL of the records.
+	resolver.Lookup("+15551234")
+	if len(queries) != 2 {
+		t.Errorf("[FAIL] cached result was not used, queries %v", queries)
+	}
+	timing.Elapse(2 * time.Minute)
+	resolver.Lookup("+15551234")
+	if len(queries) != 4 {
+		t.Errorf("[FAIL] expired result was used, queries %v", queries)
+	}
+}
+
+func TestResolverRouter(t *testing.T) {
+	resolver := NewResolver(Config{
+		Lookup: func(domain string) ([]Naptr, error) {
+			if domain != "4.3.2.1.5.5.5.1.e164.arpa" {
+				return nil, nil
+			}
+			return []Naptr{{Order: 10, Flags: "U", Services: "E2U+voice:sip", Regexp: "!^.*$!sip:bob@gw.example.com:5080!"}}, nil
+		},
+		CacheTTL: -1,
+	})
+	router := resolver.Router(nil)
+
+	newRequest := func(user string) *base.Request {
+		uri := &base.SipUri{User: base.String{S: user}, Host: "example.com", UriParams: base.NewParams(), Headers: base.NewParams()}
+		return base.NewRequest(base.INVITE, uri, "SIP/2.0", []base.SipHeader{}, "", log.StandardLogger())
+	}
+
+	route := router(newRequest("+15551234"))
+	if route.Recipient == nil || route.Recipient.String() != "sip:bob@gw.example.com:5080" || route.Forward != "gw.example.com:5080" {
+		t.Errorf("[FAIL] unexpected route %v of E.164 number", route)
+	}
+	if route := router(newRequest("+15550000")); !route.IsLocal() || route.Recipient != nil {
+		t.Errorf("[FAIL] number not found in ENUM was routed to %v", route)
+	}
+	if route := router(newRequest("alice")); !route.IsLocal() {
+		t.Errorf("[FAIL] request to alice was routed to %v", route)
+	}
+}
+
+func TestResolverHealthCheck(t *testing.T) {
+	var failure error
+	queries := make([]string, 0)
+	resolver := NewResolver(Config{
+		Suffixes: []string{"e164.arpa."},
+		Lookup: func(domain string) ([]Naptr, error) {
+			queries = append(queries, domain)
+			return nil, failure
+		},
+	})
+
+	if err := resolver.HealthCheck(); err != nil {
+		t.Errorf("[FAIL] reachable zone reported unhealthy: %s", err)
+	}
+	if len(queries) != 1 || queries[0] != "e164.arpa" {
+		t.Errorf("[FAIL] expected the zone apex queried, got %v", queries)
+	}
+
+	failure = fmt.Errorf("i/o timeout")
+	if err := resolver.HealthCheck(); err == nil {
+		t.Errorf("[FAIL] unreachable zone reported healthy")
+	}
+}
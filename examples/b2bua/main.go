@@ -0,0 +1,271 @@
+// Command b2bua is a back-to-back user agent bridging every call to the target as a new call of its own,
+// a starting point of SBCs and PBXs - RFC 3261 6.
+// Calls are answered once the target answers, hanging up either leg hangs up the other one.
+// Session descriptions are relayed between the legs, including the delayed offer of the target answered in ACK.
+//
+//	b2bua -listen 127.0.0.1:5060 -target 127.0.0.1:5070
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/dialog"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/transaction"
+	"github.com/ghettovoice/gossip/transport"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:5060", "address to listen on, host:port")
+	transportType := flag.String("transport", "udp", "transport: udp, tcp, ws")
+	target := flag.String("target", "127.0.0.1:5070", "address the calls are bridged to, host:port")
+	flag.Parse()
+
+	tp, err := transport.NewManager(*transportType)
+	if err != nil {
+		log.Fatalf("failed to create transport: %s", err)
+	}
+	tm, err := transaction.NewManager(tp, *listen)
+	if err != nil {
+		log.Fatalf("failed to listen on %s: %s", *listen, err)
+	}
+	defer tm.Stop()
+
+	b, err := newB2bua(tm, *listen, *transportType, *target)
+	if err != nil {
+		log.Fatalf("invalid listen address %s: %s", *listen, err)
+	}
+	go b.Serve()
+	log.Infof("bridging calls received on %s to %s", *listen, *target)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+}
+
+// b2bua bridges the calls received by the manager to the target.
+// The incoming call is leg A, the outgoing call to the target is leg B.
+type b2bua struct {
+	tm        *transaction.Manager
+	transport string
+	host      string
+	port      uint16
+	target    string
+}
+
+func newB2bua(tm *transaction.Manager, listen string, transportType string, target string) (*b2bua, error) {
+	host, portStr, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	// 2xx of leg A is retransmitted until the caller acknowledges it - RFC 3261 13.3.1.4.
+	tm.SetRetransmit2xx(true)
+	return &b2bua{tm: tm, transport: transportType, host: host, port: uint16(port), target: target}, nil
+}
+
+// Serve handles the new requests until the manager is stopped.
+func (b *b2bua) Serve() {
+	for tx := range b.tm.Requests() {
+		go b.handle(tx)
+	}
+}
+
+func (b *b2bua) handle(tx *transaction.ServerTransaction) {
+	req := tx.Origin()
+	switch req.Method {
+	case base.INVITE:
+		b.bridge(tx)
+	case base.OPTIONS:
+		tx.Ok()
+	case base.ACK:
+		// ACK of a call not known anymore, nothing to answer.
+	default:
+		tx.RespondWithStatus(405, "Method Not Allowed")
+	}
+}
+
+// contact returns Contact of the B2BUA used on both legs.
+func (b *b2bua) contact() *base.ContactHeader {
+	port := b.port
+	return &base.ContactHeader{
+		DisplayName: base.NoString{},
+		Address:     &base.SipUri{Host: b.host, Port: &port, UriParams: base.NewParams(), Headers: base.NewParams()},
+		Params:      base.NewParams(),
+	}
+}
+
+// newInvite builds INVITE of leg B: the Request-URI, To and the body are taken from INVITE of leg A,
+// everything identifying the call is new, so the legs share no Call-ID, tags or branches.
+func (b *b2bua) newInvite(req *base.Request) (*base.Request, error) {
+	from, err := req.From()
+	if err != nil {
+		return nil, err
+	}
+	to, err := req.To()
+	if err != nil {
+		return nil, err
+	}
+	callId := base.CallId(base.GenerateTag() + "@" + b.host)
+	maxForwards := base.MaxForwards(70)
+	invite := base.NewRequest(
+		base.INVITE,
+		req.Recipient.Copy(),
+		"SIP/2.0",
+		[]base.SipHeader{
+			&base.ViaHeader{base.NewViaHop(b.transport, b.host, b.port, base.GenerateBranch())},
+			&base.FromHeader{
+				DisplayName: from.DisplayName,
+				Address:     from.Address.Copy(),
+				Params:      base.NewParams().Add("tag", base.String{S: base.GenerateTag()}),
+			},
+			&base.ToHeader{DisplayName: to.DisplayName, Address: to.Address.Copy(), Params: base.NewParams()},
+			&callId,
+			&base.CSeq{SeqNo: 1, MethodName: base.INVITE},
+			b.contact(),
+			&maxForwards,
+		},
+		"",
+		req.Log(),
+	)
+	base.CopyHeaders("Content-Type", req, invite)
+	invite.SetBody(req.Body())
+	return invite, nil
+}
+
+// bridge calls the target and answers the caller as the target answers.
+func (b *b2bua) bridge(txA *transaction.ServerTransaction) {
+	invite, err := b.newInvite(txA.Origin())
+	if err != nil {
+		txA.Log().Warnf("failed to bridge %s: %s", txA.Origin().Short(), err)
+		txA.ServerError()
+		return
+	}
+	txB := b.tm.Send(invite, b.target)
+
+	for {
+		select {
+		case res := <-txB.Responses():
+			switch {
+			case res.StatusCode == 100:
+				// Leg A is already answered with 100 Trying by its own transaction.
+			case res.IsProvisional():
+				txA.RespondWithStatus(res.StatusCode, res.Reason)
+			case res.IsSuccess():
+				b.connect(txA, txB, res)
+				return
+			default:
+				txA.RespondWithStatus(res.StatusCode, res.Reason)
+				return
+			}
+		case err := <-txB.Errors():
+			txA.Log().Warnf("failed to call %s: %s", b.target, err)
+			if errors.Is(err, base.ErrTimeout) {
+				txA.RespondWithStatus(408, "Request Timeout")
+			} else {
+				txA.RespondWithStatus(503, "Service Unavailable")
+			}
+			return
+		}
+	}
+}
+
+// connect acknowledges the answer of leg B, answers leg A and relays the dialogs until either leg hangs up.
+// The offer of the answer of leg B is relayed to leg A, so leg B is acknowledged with the answer in ACK of leg A.
+func (b *b2bua) connect(txA *transaction.ServerTransaction, txB *transaction.ClientTransaction, res *base.Response) {
+	dlgB, err := dialog.NewUacDialog(b.tm, txB, res)
+	if err != nil {
+		txA.Log().Warnf("failed to bridge %s: %s", txA.Origin().Short(), err)
+		txA.ServerError()
+		return
+	}
+	offered := dlgB.OfferState() == dialog.OfferRemote
+	if !offered {
+		if err := dlgB.Ack(res); err != nil {
+			dlgB.Log().Warnf("failed to acknowledge %s: %s", res.Short(), err)
+		}
+	}
+
+	okA := txA.NewResponse(200, "OK", b.contact())
+	base.CopyHeaders("Content-Type", res, okA)
+	okA.SetBody(res.Body())
+	dlgA, err := dialog.NewUasDialog(b.tm, txA, okA)
+	if err != nil {
+		txA.Log().Warnf("failed to answer %s: %s", txA.Origin().Short(), err)
+		txA.ServerError()
+		hangup(dlgB)
+		return
+	}
+	txA.Respond(okA)
+	dlgA.Log().Infof("call bridged to dialog %s", dlgB.Id())
+
+	for {
+		select {
+		case req := <-dlgA.Requests():
+			if offered && req.Origin().IsAck() {
+				offered = false
+				if err := ackAnswer(dlgA, dlgB, res); err != nil {
+					dlgB.Log().Warnf("failed to acknowledge %s: %s", res.Short(), err)
+					hangup(dlgA)
+					hangup(dlgB)
+					return
+				}
+				continue
+			}
+			if relay(req, dlgB) {
+				return
+			}
+		case req := <-dlgB.Requests():
+			if relay(req, dlgA) {
+				return
+			}
+		case err := <-txA.Errors():
+			// The caller never acknowledged the answer.
+			dlgA.Log().Warnf("call failed: %s", err)
+			hangup(dlgA)
+			hangup(dlgB)
+			return
+		}
+	}
+}
+
+// ackAnswer acknowledges the answer of leg B carrying the offer with the answer of leg A to it.
+func ackAnswer(dlgA *dialog.Dialog, dlgB *dialog.Dialog, res *base.Response) error {
+	answer := dlgA.RemoteSession()
+	if dlgA.OfferState() != dialog.OfferNone || answer == nil {
+		return fmt.Errorf("no answer to the offer of %s", res.Short())
+	}
+	return dlgB.AckAnswer(res, answer)
+}
+
+// relay answers the in-dialog request of one leg, reports whether the call is over.
+// BYE hangs up the other leg, other requests but ACK are not supported by the example.
+func relay(req *transaction.ServerTransaction, other *dialog.Dialog) bool {
+	switch req.Origin().Method {
+	case base.ACK:
+		return false
+	case base.BYE:
+		req.Ok()
+		hangup(other)
+		return true
+	default:
+		req.RespondWithStatus(501, "Not Implemented")
+		return false
+	}
+}
+
+func hangup(dlg *dialog.Dialog) {
+	if _, err := dlg.Bye(); err != nil {
+		dlg.Log().Warnf("failed to hang up: %s", err)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/dialog"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+	"github.com/ghettovoice/gossip/sdp"
+	"github.com/ghettovoice/gossip/transaction"
+	"github.com/ghettovoice/gossip/transport"
+)
+
+func newManager(t *testing.T, addr string) *transaction.Manager {
+	tp, err := transport.NewManager("memory")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create memory transport: %s", err)
+	}
+	tm, err := transaction.NewManager(tp, addr)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to listen on %s: %s", addr, err)
+	}
+	return tm
+}
+
+func newInvite(t *testing.T, callerAddr string, callId string) *base.Request {
+	msg, err := parser.ParseMessage([]byte(strings.Join([]string{
+		"INVITE sip:bob@callee.test SIP/2.0",
+		"Via: SIP/2.0/UDP " + callerAddr + ";branch=" + base.GenerateBranch(),
+		"From: <sip:alice@caller.test>;tag=" + base.GenerateTag(),
+		"To: <sip:bob@callee.test>",
+		"Call-Id: " + callId,
+		"CSeq: 1 INVITE",
+		"Contact: <sip:alice@" + callerAddr + ">",
+		"Max-Forwards: 70",
+		"Content-Length: 0",
+		"",
+		"",
+	}, "\r\n")), log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse request: %s", err)
+	}
+	return msg.(*base.Request)
+}
+
+func audioSession(user string, port int) *sdp.Session {
+	return &sdp.Session{
+		Origin:     sdp.Origin{Username: user, SessionId: 1, SessionVersion: 1, NetType: "IN", AddrType: "IP4", Address: "192.0.2.1"},
+		Connection: sdp.NewConnection("192.0.2.1"),
+		Media: []*sdp.Media{{
+			Type:       "audio",
+			Port:       port,
+			Proto:      "RTP/AVP",
+			Formats:    []string{"0"},
+			Attributes: sdp.Attributes{{Name: "rtpmap", Value: "0 PCMU/8000"}},
+		}},
+	}
+}
+
+func finalResponse(t *testing.T, tx *transaction.ClientTransaction) *base.Response {
+	t.Helper()
+	for {
+		select {
+		case res := <-tx.Responses():
+			if !res.IsProvisional() {
+				return res
+			}
+		case err := <-tx.Errors():
+			t.Fatalf("[FAIL] %s failed: %s", tx.Origin().Short(), err)
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] timed out waiting for response to %s", tx.Origin().Short())
+		}
+	}
+}
+
+// serveCallee answers the calls with 200 OK, or rejects them with 486 Busy Here if busy,
+// and reports the requests received in the calls. The offer of INVITE is answered,
+// INVITE without the offer is answered with the offer of the callee.
+func serveCallee(tm *transaction.Manager, addr string, busy bool, received chan<- *base.Request) {
+	port := uint16(5060)
+	contact := &base.ContactHeader{
+		DisplayName: base.NoString{},
+		Address:     &base.SipUri{Host: strings.Split(addr, ":")[0], Port: &port, UriParams: base.NewParams(), Headers: base.NewParams()},
+		Params:      base.NewParams(),
+	}
+	local := audioSession("bob", 3456)
+	for tx := range tm.Requests() {
+		if tx.Origin().Method != base.INVITE {
+			continue
+		}
+		received <- tx.Origin()
+		if busy {
+			tx.RespondWithStatus(486, "Busy Here")
+			continue
+		}
+		ok := tx.NewResponse(200, "OK", contact)
+		if offer, err := tx.Origin().SDP(); err == nil {
+			answer, err := sdp.Answer(offer, local)
+			if err != nil {
+				tx.RespondWithStatus(488, "Not Acceptable Here")
+				continue
+			}
+			ok.SetSDP(answer)
+		} else {
+			ok.SetSDP(local)
+		}
+		dlg, err := dialog.NewUasDialog(tm, tx, ok)
+		if err != nil {
+			tx.ServerError()
+			continue
+		}
+		tx.Respond(ok)
+		go func() {
+			for req := range dlg.Requests() {
+				received <- req.Origin()
+				if req.Origin().Method == base.BYE {
+					req.Ok()
+					return
+				}
+			}
+		}()
+	}
+}
+
+type b2buaTest struct {
+	t        *testing.T
+	addr     string
+	caller   *transaction.Manager
+	received chan *base.Request
+	stop     func()
+}
+
+func newB2buaTest(t *testing.T, name string, busy bool) *b2buaTest {
+	b2buaAddr, callerAddr, calleeAddr := "b2bua-"+name+".test:5060", "caller-"+name+".test:5060", "callee-"+name+".test:5060"
+	tm := newManager(t, b2buaAddr)
+	b, err := newB2bua(tm, b2buaAddr, "UDP", calleeAddr)
+	if err != nil {
+		t.Fatalf("[FAIL] unexpected error: %s", err)
+	}
+	go b.Serve()
+
+	callee := newManager(t, calleeAddr)
+	received := make(chan *base.Request, 4)
+	go serveCallee(callee, calleeAddr, busy, received)
+
+	caller := newManager(t, callerAddr)
+	return &b2buaTest{
+		t:        t,
+		addr:     b2buaAddr,
+		caller:   caller,
+		received: received,
+		stop: func() {
+			caller.Stop()
+			callee.Stop()
+			tm.Stop()
+		},
+	}
+}
+
+// call sends INVITE from the caller through the B2BUA.
+func (test *b2buaTest) call(invite *base.Request) *transaction.ClientTransaction {
+	return test.caller.Send(invite, test.addr)
+}
+
+// expect checks the next request received by the callee.
+func (test *b2buaTest) expect(method base.Method) *base.Request {
+	test.t.Helper()
+	select {
+	case req := <-test.received:
+		if req.Method != method {
+			test.t.Fatalf("[FAIL] expected %s at the callee, got %s", method, req.Short())
+		}
+		return req
+	case <-time.After(time.Second):
+		test.t.Fatalf("[FAIL] %s did not reach the callee", method)
+	}
+	return nil
+}
+
+func TestB2bua(t *testing.T) {
+	test := newB2buaTest(t, "offer", false)
+	defer test.stop()
+
+	invite := newInvite(t, "caller-offer.test:5060", "leg-a")
+	offer := audioSession("alice", 49170)
+	invite.SetSDP(offer)
+	tx := test.call(invite)
+
+	req := test.expect(base.INVITE)
+	if callId, err := req.CallId(); err != nil || *callId == "leg-a" {
+		t.Errorf("[FAIL] expected a new Call-ID on leg B, got %v", req.Headers("Call-Id"))
+	}
+	if req.Body() != offer.String() {
+		t.Errorf("[FAIL] expected the offer relayed to leg B, got '%s'", req.Body())
+	}
+	ok := finalResponse(t, tx)
+	if ok.StatusCode != 200 {
+		t.Fatalf("[FAIL] expected INVITE answered with 200, got %s", ok.Short())
+	}
+	if answer, err := ok.SDP(); err != nil || answer.Media[0].Port != 3456 {
+		t.Errorf("[FAIL] expected the answer of the callee relayed to leg A, got '%s'", ok.Body())
+	}
+	test.expect(base.ACK)
+
+	dlg, err := dialog.NewUacDialog(test.caller, tx, ok)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create dialog: %s", err)
+	}
+	if err := dlg.Ack(ok); err != nil {
+		t.Fatalf("[FAIL] failed to acknowledge the answer: %s", err)
+	}
+	bye, err := dlg.Bye()
+	if err != nil {
+		t.Fatalf("[FAIL] failed to hang up: %s", err)
+	}
+	if res := finalResponse(t, bye); res.StatusCode != 200 {
+		t.Errorf("[FAIL] expected BYE answered with 200, got %s", res.Short())
+	}
+	test.expect(base.BYE)
+}
+
+// The callee's offer of the INVITE without one is answered by ACK of the caller relayed to leg B.
+func TestB2buaDelayedOffer(t *testing.T) {
+	test := newB2buaTest(t, "delayed", false)
+	defer test.stop()
+
+	tx := test.call(newInvite(t, "caller-delayed.test:5060", base.GenerateTag()))
+	if req := test.expect(base.INVITE); req.Body() != "" {
+		t.Errorf("[FAIL] expected INVITE without offer on leg B, got '%s'", req.Body())
+	}
+	ok := finalResponse(t, tx)
+	offer, err := ok.SDP()
+	if err != nil {
+		t.Fatalf("[FAIL] expected the offer of the callee relayed to leg A: %s", err)
+	}
+
+	dlg, err := dialog.NewUacDialog(test.caller, tx, ok)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create dialog: %s", err)
+	}
+	answer, err := sdp.Answer(offer, audioSession("alice", 49170))
+	if err != nil {
+		t.Fatalf("[FAIL] failed to answer: %s", err)
+	}
+	if err := dlg.AckAnswer(ok, answer); err != nil {
+		t.Fatalf("[FAIL] failed to acknowledge the offer: %s", err)
+	}
+	if ack := test.expect(base.ACK); ack.Body() != answer.String() {
+		t.Errorf("[FAIL] expected the answer relayed to leg B in ACK, got '%s'", ack.Body())
+	}
+
+	bye, err := dlg.Bye()
+	if err != nil {
+		t.Fatalf("[FAIL] failed to hang up: %s", err)
+	}
+	if res := finalResponse(t, bye); res.StatusCode != 200 {
+		t.Errorf("[FAIL] expected BYE answered with 200, got %s", res.Short())
+	}
+	test.expect(base.BYE)
+}
+
+func TestB2buaRejected(t *testing.T) {
+	test := newB2buaTest(t, "busy", true)
+	defer test.stop()
+
+	if res := finalResponse(t, test.call(newInvite(t, "caller-busy.test:5060", base.GenerateTag()))); res.StatusCode != 486 {
+		t.Errorf("[FAIL] expected the rejection relayed to leg A, got %s", res.Short())
+	}
+}
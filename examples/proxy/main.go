@@ -0,0 +1,134 @@
+// Command proxy is a stateless proxy relaying every request to the next hop and the responses back,
+// a starting point of edge proxies and load balancers - RFC 3261 16.11.
+// Requests are sent to the next address if given, otherwise to their top Route or Request-URI.
+//
+//	proxy -listen 127.0.0.1:5060 -next 127.0.0.1:5070
+package main
+
+import (
+	"flag"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/transaction"
+	"github.com/ghettovoice/gossip/transport"
+)
+
+// Max-Forwards of the relayed requests without it - RFC 3261 16.6 item 3.
+const defaultMaxForwards = 70
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:5060", "address to listen on, host:port")
+	transportType := flag.String("transport", "udp", "transport: udp, tcp, ws")
+	next := flag.String("next", "", "address of the next hop, host:port; empty routes by Route and Request-URI")
+	flag.Parse()
+
+	tp, err := transport.NewManager(*transportType)
+	if err != nil {
+		log.Fatalf("failed to create transport: %s", err)
+	}
+	tm, err := transaction.NewManager(tp, *listen)
+	if err != nil {
+		log.Fatalf("failed to listen on %s: %s", *listen, err)
+	}
+	defer tm.Stop()
+
+	p, err := newStatelessProxy(tm, *listen, *transportType, *next)
+	if err != nil {
+		log.Fatalf("invalid listen address %s: %s", *listen, err)
+	}
+	go p.Serve()
+	log.Infof("relaying requests received on %s", *listen)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+}
+
+// statelessProxy relays the requests received by the manager without transactions.
+type statelessProxy struct {
+	tm        *transaction.Manager
+	transport string
+	host      string
+	port      uint16
+	next      string
+}
+
+// newStatelessProxy makes the manager relay all the new requests by the proxy listening on the address.
+func newStatelessProxy(tm *transaction.Manager, listen string, transportType string, next string) (*statelessProxy, error) {
+	host, portStr, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	p := &statelessProxy{tm: tm, transport: transportType, host: host, port: uint16(port), next: next}
+	// Requests coming back through the proxy unchanged are answered with 482 Loop Detected.
+	tm.SetLoopDetection(p.ownHop)
+	tm.SetStatelessHandler(p.forward)
+	return p, nil
+}
+
+// Serve relays the responses back until the manager is stopped.
+// Responses to the relayed requests match no client transaction, so they all arrive to Responses.
+func (p *statelessProxy) Serve() {
+	for res := range p.tm.Responses() {
+		if err := p.tm.ForwardResponseStateless(res); err != nil {
+			res.Log().Warnf("failed to relay response %s: %s", res.Short(), err)
+		}
+	}
+}
+
+func (p *statelessProxy) ownHop(hop *base.ViaHop) bool {
+	port := uint16(5060)
+	if hop.Port != nil {
+		port = *hop.Port
+	}
+	return hop.Host == p.host && port == p.port
+}
+
+// forward relays the request to the next hop, retransmissions are relayed the same way.
+func (p *statelessProxy) forward(req *base.Request) bool {
+	fwd := req.Copy()
+	maxForwards := base.MaxForwards(defaultMaxForwards)
+	if hdrs := fwd.Headers("Max-Forwards"); len(hdrs) > 0 {
+		switch h := hdrs[0].(type) {
+		case *base.MaxForwards:
+			maxForwards = *h
+		case base.MaxForwards:
+			maxForwards = h
+		}
+		if maxForwards == 0 {
+			req.Log().Infof("request %s dropped: no hops left", req.Short())
+			return true
+		}
+		maxForwards--
+	}
+	fwd.SetHeader(maxForwards, true)
+
+	hop := base.NewViaHop(p.transport, p.host, p.port, base.GenerateStatelessBranch(req))
+	if via, err := fwd.Via(); err == nil {
+		via.PushHop(hop)
+	} else {
+		fwd.AddFrontHeader(&base.ViaHeader{hop})
+	}
+
+	dest := p.next
+	if dest == "" {
+		var err error
+		if dest, err = base.NextHop(fwd); err != nil {
+			req.Log().Warnf("request %s dropped: %s", req.Short(), err)
+			return true
+		}
+	}
+	if err := p.tm.ForwardStateless(fwd, dest); err != nil {
+		req.Log().Warnf("failed to relay request %s to %s: %s", req.Short(), dest, err)
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+	"github.com/ghettovoice/gossip/transaction"
+	"github.com/ghettovoice/gossip/transport"
+)
+
+func newManager(t *testing.T, addr string) *transaction.Manager {
+	tp, err := transport.NewManager("memory")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create memory transport: %s", err)
+	}
+	tm, err := transaction.NewManager(tp, addr)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to listen on %s: %s", addr, err)
+	}
+	return tm
+}
+
+func finalResponse(t *testing.T, tx *transaction.ClientTransaction) *base.Response {
+	t.Helper()
+	for {
+		select {
+		case res := <-tx.Responses():
+			if !res.IsProvisional() {
+				return res
+			}
+		case err := <-tx.Errors():
+			t.Fatalf("[FAIL] %s failed: %s", tx.Origin().Short(), err)
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] timed out waiting for response to %s", tx.Origin().Short())
+		}
+	}
+}
+
+func TestStatelessProxy(t *testing.T) {
+	const proxyAddr, callerAddr, calleeAddr = "proxy.test:5060", "caller.test:5060", "callee.test:5060"
+	proxy := newManager(t, proxyAddr)
+	defer proxy.Stop()
+	p, err := newStatelessProxy(proxy, proxyAddr, "UDP", "")
+	if err != nil {
+		t.Fatalf("[FAIL] unexpected error: %s", err)
+	}
+	go p.Serve()
+
+	// The callee rejects calls, so ACK of the rejection is relayed hop-by-hop too.
+	callee := newManager(t, calleeAddr)
+	defer callee.Stop()
+	received := make(chan *base.Request, 3)
+	go func() {
+		for tx := range callee.Requests() {
+			received <- tx.Origin()
+			switch tx.Origin().Method {
+			case base.INVITE:
+				tx.RespondWithStatus(486, "Busy Here")
+				select {
+				case ack := <-tx.Ack():
+					received <- ack
+				case <-time.After(time.Second):
+				}
+			case base.OPTIONS:
+				tx.Ok()
+			}
+		}
+	}()
+	relayed := func(method base.Method) *base.Request {
+		t.Helper()
+		select {
+		case req := <-received:
+			if req.Method != method {
+				t.Fatalf("[FAIL] expected %s relayed, got %s", method, req.Short())
+			}
+			return req
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] %s was not relayed", method)
+		}
+		return nil
+	}
+
+	caller := newManager(t, callerAddr)
+	defer caller.Stop()
+	request := func(method base.Method) *base.Request {
+		msg, err := parser.ParseMessage([]byte(strings.Join([]string{
+			string(method) + " sip:bob@" + calleeAddr + " SIP/2.0",
+			"Via: SIP/2.0/UDP " + callerAddr + ";branch=" + base.GenerateBranch(),
+			"From: <sip:alice@caller.test>;tag=" + base.GenerateTag(),
+			"To: <sip:bob@callee.test>",
+			"Call-Id: " + base.GenerateTag(),
+			"CSeq: 1 " + string(method),
+			"Max-Forwards: 10",
+			"Content-Length: 0",
+			"",
+			"",
+		}, "\r\n")), log.StandardLogger())
+		if err != nil {
+			t.Fatalf("[FAIL] failed to parse request: %s", err)
+		}
+		return msg.(*base.Request)
+	}
+
+	options := caller.Send(request(base.OPTIONS), proxyAddr)
+	req := relayed(base.OPTIONS)
+	if via, err := req.Via(); err != nil || len(*via) != 2 || (*via)[0].Host != "proxy.test" {
+		t.Errorf("[FAIL] expected Via of the proxy on top of the caller's one, got %v", req.Headers("Via"))
+	}
+	if hdrs := req.Headers("Max-Forwards"); len(hdrs) != 1 || hdrs[0].String() != "Max-Forwards: 9" {
+		t.Errorf("[FAIL] expected Max-Forwards decremented, got %v", hdrs)
+	}
+	res := finalResponse(t, options)
+	if res.StatusCode != 200 {
+		t.Errorf("[FAIL] expected OPTIONS answered with 200, got %s", res.Short())
+	}
+	if via, err := res.Via(); err != nil || len(*via) != 1 {
+		t.Errorf("[FAIL] expected the Via of the proxy removed from the response, got %v", res.Headers("Via"))
+	}
+
+	invite := caller.Send(request(base.INVITE), proxyAddr)
+	relayed(base.INVITE)
+	if res := finalResponse(t, invite); res.StatusCode != 486 {
+		t.Errorf("[FAIL] expected INVITE answered with 486, got %s", res.Short())
+	}
+	relayed(base.ACK)
+}
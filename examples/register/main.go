@@ -0,0 +1,95 @@
+// Command register keeps a binding of the AOR registered at the registrar until interrupted,
+// then removes it. Digest challenges of the registrar are answered with the credentials.
+//
+//	register -aor sip:alice@example.com -contact sip:alice@127.0.0.1:5061 -listen 127.0.0.1:5061 \
+//		-registrar 127.0.0.1:5060 -password secret
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+	"github.com/ghettovoice/gossip/registration"
+	"github.com/ghettovoice/gossip/transaction"
+	"github.com/ghettovoice/gossip/transport"
+)
+
+func main() {
+	aor := flag.String("aor", "", "address-of-record to register, e.g. sip:alice@example.com")
+	contact := flag.String("contact", "", "contact bound to the AOR, e.g. sip:alice@127.0.0.1:5061")
+	listen := flag.String("listen", "127.0.0.1:5061", "address to listen on, host:port")
+	registrar := flag.String("registrar", "127.0.0.1:5060", "address of the registrar, host:port")
+	transportType := flag.String("transport", "udp", "transport: udp, tcp, ws")
+	username := flag.String("username", "", "username of the digest credentials, the user of the AOR by default")
+	password := flag.String("password", "", "password of the digest credentials")
+	expires := flag.Duration("expires", time.Hour, "registration interval requested")
+	flag.Parse()
+
+	cfg, err := config(*aor, *contact, *registrar)
+	if err != nil {
+		log.Fatalf("invalid configuration: %s", err)
+	}
+	cfg.Transport = *transportType
+	cfg.Username = *username
+	cfg.Password = *password
+	cfg.Expires = *expires
+
+	tp, err := transport.NewManager(*transportType)
+	if err != nil {
+		log.Fatalf("failed to create transport: %s", err)
+	}
+	tm, err := transaction.NewManager(tp, *listen)
+	if err != nil {
+		log.Fatalf("failed to listen on %s: %s", *listen, err)
+	}
+	defer tm.Stop()
+
+	client := registration.NewClient(tm, cfg)
+	if err := register(client, 10*time.Second); err != nil {
+		client.Stop()
+		log.Fatalf("registration failed: %s", err)
+	}
+	log.Infof("%s registered for %s", *aor, client.Expires())
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+	if err := client.Unregister(); err != nil {
+		log.Fatalf("unregistration failed: %s", err)
+	}
+}
+
+// config parses the addresses of the registration.
+func config(aor string, contact string, registrar string) (registration.Config, error) {
+	aorUri, err := parser.ParseSipUri(aor)
+	if err != nil {
+		return registration.Config{}, fmt.Errorf("invalid AOR '%s': %s", aor, err)
+	}
+	contactUri, err := parser.ParseSipUri(contact)
+	if err != nil {
+		return registration.Config{}, fmt.Errorf("invalid contact '%s': %s", contact, err)
+	}
+	return registration.Config{AOR: &aorUri, Contact: &contactUri, Registrar: registrar}, nil
+}
+
+// register starts the client and waits for the outcome of the first registration.
+func register(client *registration.Client, timeout time.Duration) error {
+	client.Start()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		switch client.State() {
+		case registration.StateRegistered:
+			return nil
+		case registration.StateFailed:
+			return client.LastError()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return base.NewError(base.ErrTimeout, nil, "not registered in %v", timeout)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/auth"
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/registration"
+	"github.com/ghettovoice/gossip/transaction"
+	"github.com/ghettovoice/gossip/transport"
+)
+
+func newManager(t *testing.T, addr string) *transaction.Manager {
+	tp, err := transport.NewManager("memory")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create memory transport: %s", err)
+	}
+	tm, err := transaction.NewManager(tp, addr)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to listen on %s: %s", addr, err)
+	}
+	return tm
+}
+
+// serveRegistrar accepts the authenticated REGISTER requests, reporting the expiry of the bindings.
+func serveRegistrar(tm *transaction.Manager, bindings chan<- string) {
+	for tx := range tm.Requests() {
+		req := tx.Origin()
+		if req.Method != base.REGISTER {
+			tx.RespondWithStatus(405, "Method Not Allowed")
+			continue
+		}
+		res := tx.NewResponse(200, "OK")
+		base.CopyHeaders("Contact", req, res)
+		base.CopyHeaders("Expires", req, res)
+		tx.Respond(res)
+		for _, h := range req.Headers("Expires") {
+			bindings <- tx.User() + " " + h.String()
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	const registrarAddr, clientAddr = "registrar.test:5060", "alice.test:5060"
+	registrar := newManager(t, registrarAddr)
+	defer registrar.Stop()
+	challenger := auth.NewChallenger("example.com", auth.MemoryStore{"alice": "secret"})
+	registrar.SetAuthenticator(transaction.NewAuthenticator(challenger, false, base.REGISTER))
+	bindings := make(chan string, 2)
+	go serveRegistrar(registrar, bindings)
+
+	tm := newManager(t, clientAddr)
+	defer tm.Stop()
+	binding := func(expected string) {
+		t.Helper()
+		select {
+		case got := <-bindings:
+			if got != expected {
+				t.Errorf("[FAIL] expected binding '%s', got '%s'", expected, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] timed out waiting for binding '%s'", expected)
+		}
+	}
+
+	cfg, err := config("sip:alice@example.com", "sip:alice@"+clientAddr, registrarAddr)
+	if err != nil {
+		t.Fatalf("[FAIL] unexpected error: %s", err)
+	}
+	if _, err := config("alice", "sip:alice@"+clientAddr, registrarAddr); err == nil {
+		t.Errorf("[FAIL] expected invalid AOR rejected")
+	}
+
+	cfg.Password = "wrong"
+	client := registration.NewClient(tm, cfg)
+	if err := register(client, time.Second); err == nil {
+		t.Errorf("[FAIL] expected registration with wrong password failed")
+	}
+	client.Stop()
+
+	cfg.Password = "secret"
+	cfg.Expires = time.Minute
+	client = registration.NewClient(tm, cfg)
+	if err := register(client, time.Second); err != nil {
+		t.Fatalf("[FAIL] registration failed: %s", err)
+	}
+	binding("alice Expires: 60")
+	if expires := client.Expires(); expires != time.Minute {
+		t.Errorf("[FAIL] expected registration for 1m, got %v", expires)
+	}
+
+	if err := client.Unregister(); err != nil {
+		t.Fatalf("[FAIL] unregistration failed: %s", err)
+	}
+	binding("alice Expires: 0")
+}
@@ -0,0 +1,134 @@
+// Command uas is a user agent server answering every call, a starting point of IVRs and test endpoints.
+// Calls are answered with 180 Ringing and 200 OK and kept until the caller hangs up.
+//
+//	uas -listen 127.0.0.1:5060 -transport udp
+package main
+
+import (
+	"flag"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/dialog"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/transaction"
+	"github.com/ghettovoice/gossip/transport"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:5060", "address to listen on, host:port")
+	transportType := flag.String("transport", "udp", "transport: udp, tcp, ws")
+	flag.Parse()
+
+	contact, err := contactUri(*listen)
+	if err != nil {
+		log.Fatalf("invalid listen address %s: %s", *listen, err)
+	}
+	tp, err := transport.NewManager(*transportType)
+	if err != nil {
+		log.Fatalf("failed to create transport: %s", err)
+	}
+	tm, err := transaction.NewManager(tp, *listen)
+	if err != nil {
+		log.Fatalf("failed to listen on %s: %s", *listen, err)
+	}
+	defer tm.Stop()
+
+	go newAutoAnswer(tm, contact).Serve()
+	log.Infof("answering calls on %s", *listen)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+}
+
+// contactUri builds the SIP URI of the listening address.
+func contactUri(addr string) (*base.SipUri, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	p := uint16(port)
+	return &base.SipUri{Host: host, Port: &p, UriParams: base.NewParams(), Headers: base.NewParams()}, nil
+}
+
+// autoAnswer answers the calls received by the manager.
+type autoAnswer struct {
+	tm      *transaction.Manager
+	contact *base.SipUri
+}
+
+func newAutoAnswer(tm *transaction.Manager, contact *base.SipUri) *autoAnswer {
+	// 2xx is retransmitted until the caller acknowledges it - RFC 3261 13.3.1.4.
+	tm.SetRetransmit2xx(true)
+	return &autoAnswer{tm: tm, contact: contact}
+}
+
+// Serve handles the new requests until the manager is stopped.
+func (uas *autoAnswer) Serve() {
+	for tx := range uas.tm.Requests() {
+		go uas.handle(tx)
+	}
+}
+
+func (uas *autoAnswer) handle(tx *transaction.ServerTransaction) {
+	req := tx.Origin()
+	switch req.Method {
+	case base.INVITE:
+		uas.answer(tx)
+	case base.OPTIONS:
+		tx.Ok()
+	case base.ACK:
+		// ACK of a call not known anymore, nothing to answer.
+	default:
+		tx.RespondWithStatus(405, "Method Not Allowed")
+	}
+}
+
+// answer accepts the call and serves its dialog until BYE.
+func (uas *autoAnswer) answer(tx *transaction.ServerTransaction) {
+	tx.Ringing()
+	ok := tx.NewResponse(200, "OK", &base.ContactHeader{
+		DisplayName: base.NoString{},
+		Address:     uas.contact.Copy().(*base.SipUri),
+		Params:      base.NewParams(),
+	})
+	dlg, err := dialog.NewUasDialog(uas.tm, tx, ok)
+	if err != nil {
+		tx.Log().Warnf("failed to answer %s: %s", tx.Origin().Short(), err)
+		tx.ServerError()
+		return
+	}
+	tx.Respond(ok)
+	dlg.Log().Infof("call answered")
+
+	for {
+		select {
+		case req := <-dlg.Requests():
+			switch req.Origin().Method {
+			case base.ACK:
+				dlg.Log().Infof("call established")
+			case base.BYE:
+				req.Ok()
+				dlg.Log().Infof("call ended")
+				return
+			default:
+				req.Ok()
+			}
+		case err := <-tx.Errors():
+			// The caller never acknowledged the answer.
+			dlg.Log().Warnf("call failed: %s", err)
+			if _, err := dlg.Bye(); err != nil {
+				dlg.Log().Warnf("failed to hang up: %s", err)
+			}
+			return
+		}
+	}
+}
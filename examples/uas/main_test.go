@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/dialog"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+	"github.com/ghettovoice/gossip/transaction"
+	"github.com/ghettovoice/gossip/transport"
+)
+
+func newManager(t *testing.T, addr string) *transaction.Manager {
+	tp, err := transport.NewManager("memory")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create memory transport: %s", err)
+	}
+	tm, err := transaction.NewManager(tp, addr)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to listen on %s: %s", addr, err)
+	}
+	return tm
+}
+
+func newRequest(t *testing.T, lines ...string) *base.Request {
+	msg, err := parser.ParseMessage([]byte(strings.Join(append(lines, "Content-Length: 0", "", ""), "\r\n")), log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse request: %s", err)
+	}
+	return msg.(*base.Request)
+}
+
+// finalResponse waits for the final response of the transaction, returns the provisional ones received before.
+func finalResponse(t *testing.T, tx *transaction.ClientTransaction) (*base.Response, []*base.Response) {
+	t.Helper()
+	var provisional []*base.Response
+	for {
+		select {
+		case res := <-tx.Responses():
+			if !res.IsProvisional() {
+				return res, provisional
+			}
+			provisional = append(provisional, res)
+		case err := <-tx.Errors():
+			t.Fatalf("[FAIL] %s failed: %s", tx.Origin().Short(), err)
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] timed out waiting for response to %s", tx.Origin().Short())
+		}
+	}
+}
+
+func TestAutoAnswer(t *testing.T) {
+	const uasAddr, uacAddr = "uas.test:5060", "uac.test:5060"
+	uas := newManager(t, uasAddr)
+	defer uas.Stop()
+	contact, err := contactUri(uasAddr)
+	if err != nil {
+		t.Fatalf("[FAIL] unexpected error: %s", err)
+	}
+	go newAutoAnswer(uas, contact).Serve()
+	uac := newManager(t, uacAddr)
+	defer uac.Stop()
+
+	request := func(method base.Method) *base.Request {
+		return newRequest(t,
+			string(method)+" sip:bob@"+uasAddr+" SIP/2.0",
+			"Via: SIP/2.0/UDP "+uacAddr+";branch="+base.GenerateBranch(),
+			"From: <sip:alice@uac.test>;tag="+base.GenerateTag(),
+			"To: <sip:bob@uas.test>",
+			"Call-Id: "+base.GenerateTag(),
+			"CSeq: 1 "+string(method),
+			"Contact: <sip:alice@"+uacAddr+">",
+			"Max-Forwards: 70",
+		)
+	}
+
+	if res, _ := finalResponse(t, uac.Send(request(base.OPTIONS), uasAddr)); res.StatusCode != 200 {
+		t.Errorf("[FAIL] expected OPTIONS answered with 200, got %s", res.Short())
+	}
+	if res, _ := finalResponse(t, uac.Send(request("MESSAGE"), uasAddr)); res.StatusCode != 405 {
+		t.Errorf("[FAIL] expected MESSAGE answered with 405, got %s", res.Short())
+	}
+
+	tx := uac.Send(request(base.INVITE), uasAddr)
+	ok, provisional := finalResponse(t, tx)
+	if ok.StatusCode != 200 {
+		t.Fatalf("[FAIL] expected INVITE answered with 200, got %s", ok.Short())
+	}
+	if len(provisional) == 0 || provisional[len(provisional)-1].StatusCode != 180 {
+		t.Errorf("[FAIL] expected 180 Ringing before the answer, got %v", provisional)
+	}
+	dlg, err := dialog.NewUacDialog(uac, tx, ok)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create dialog: %s", err)
+	}
+	if err := dlg.Ack(ok); err != nil {
+		t.Fatalf("[FAIL] failed to acknowledge the answer: %s", err)
+	}
+
+	bye, err := dlg.Bye()
+	if err != nil {
+		t.Fatalf("[FAIL] failed to hang up: %s", err)
+	}
+	if res, _ := finalResponse(t, bye); res.StatusCode != 200 {
+		t.Errorf("[FAIL] expected BYE answered with 200, got %s", res.Short())
+	}
+}
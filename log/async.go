@@ -0,0 +1,195 @@
+package log
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AsyncConfig configures AsyncWriter.
+type AsyncConfig struct {
+	// QueueSize is the number of entries waiting to be written, 10000 by default.
+	// The entries beyond it are dropped rather than blocking the logging goroutine, see AsyncWriter.Dropped.
+	QueueSize int
+	// BufferSize is the number of bytes buffered before they are written to the output, 64 KiB by default.
+	BufferSize int
+	// FlushInterval is the longest time the written entries stay in the buffer, 100ms by default.
+	FlushInterval time.Duration
+}
+
+// AsyncWriter writes the log entries to the wrapped output from a goroutine of its own in batches,
+// so logging, e.g. full messages at debug level under load, doesn't block the callers on disk I/O.
+// Use it as the output of the loggers, see SetAsyncOutput.
+type AsyncWriter struct {
+	out       io.Writer
+	cfg       AsyncConfig
+	queue     chan []byte
+	flushes   chan chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	written   uint64
+	dropped   uint64
+	failed    uint64
+	queueLock sync.RWMutex // Guards sending to the queue against closing it.
+	closed    bool
+	outLock   sync.Mutex // Serializes the entries written to the output once the writer is closed.
+}
+
+// NewAsyncWriter wraps the output, the entries are written to it until the writer is closed.
+func NewAsyncWriter(out io.Writer, cfg AsyncConfig) *AsyncWriter {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 10000
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 64 * 1024
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 100 * time.Millisecond
+	}
+	w := &AsyncWriter{
+		out:     out,
+		cfg:     cfg,
+		queue:   make(chan []byte, cfg.QueueSize),
+		flushes: make(chan chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// SetAsyncOutput sets the output of the standard logger to AsyncWriter of the output and returns the writer,
+// to be closed before the application exits so the queued entries are not lost.
+func SetAsyncOutput(out io.Writer, cfg AsyncConfig) *AsyncWriter {
+	w := NewAsyncWriter(out, cfg)
+	logrus.SetOutput(w)
+	return w
+}
+
+// Write queues the entry and never fails; the entry is dropped if the queue is full.
+// Once the writer is closed the entries are written to the output at once.
+func (w *AsyncWriter) Write(p []byte) (int, error) {
+	w.queueLock.RLock()
+	defer w.queueLock.RUnlock()
+	if w.closed {
+		// Wait for the queued entries, so they precede this one.
+		<-w.done
+		w.outLock.Lock()
+		defer w.outLock.Unlock()
+		return w.out.Write(p)
+	}
+	// The loggers reuse the buffer of the entry.
+	entry := make([]byte, len(p))
+	copy(entry, p)
+	select {
+	case w.queue <- entry:
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+	}
+	return len(p), nil
+}
+
+// Flush waits until the entries queued so far are written to the output.
+func (w *AsyncWriter) Flush() {
+	ack := make(chan struct{})
+	select {
+	case w.flushes <- ack:
+		<-ack
+	case <-w.done:
+	}
+}
+
+// Close writes the queued entries and stops the writer. The wrapped output is not closed.
+func (w *AsyncWriter) Close() error {
+	w.closeOnce.Do(func() {
+		w.queueLock.Lock()
+		w.closed = true
+		close(w.queue)
+		w.queueLock.Unlock()
+		<-w.done
+	})
+	return nil
+}
+
+// Written returns the number of the entries written to the output.
+func (w *AsyncWriter) Written() uint64 {
+	return atomic.LoadUint64(&w.written)
+}
+
+// Dropped returns the number of the entries dropped because the queue was full.
+func (w *AsyncWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// Failed returns the number of the entries the output failed to write.
+func (w *AsyncWriter) Failed() uint64 {
+	return atomic.LoadUint64(&w.failed)
+}
+
+// run buffers the queued entries and writes them to the output once the buffer is full,
+// on every flush interval and on Flush, until the queue is closed.
+func (w *AsyncWriter) run() {
+	defer close(w.done)
+	buf := bufio.NewWriterSize(w.out, w.cfg.BufferSize)
+	ticker := time.NewTicker(w.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	// pending is the number of the entries in the buffer.
+	pending := uint64(0)
+	flush := func() {
+		if pending == 0 {
+			return
+		}
+		if err := buf.Flush(); err != nil {
+			// The buffer keeps failing once the output failed, the buffered entries are lost.
+			atomic.AddUint64(&w.failed, pending)
+			buf.Reset(w.out)
+		} else {
+			atomic.AddUint64(&w.written, pending)
+		}
+		pending = 0
+	}
+	add := func(entry []byte) {
+		if buf.Available() < len(entry) {
+			flush()
+		}
+		if _, err := buf.Write(entry); err != nil {
+			atomic.AddUint64(&w.failed, pending+1)
+			buf.Reset(w.out)
+			pending = 0
+			return
+		}
+		pending++
+	}
+
+	for {
+		select {
+		case entry, ok := <-w.queue:
+			if !ok {
+				flush()
+				return
+			}
+			add(entry)
+		case <-ticker.C:
+			flush()
+		case ack := <-w.flushes:
+		drain:
+			for {
+				select {
+				case entry, ok := <-w.queue:
+					if !ok {
+						break drain
+					}
+					add(entry)
+				default:
+					break drain
+				}
+			}
+			flush()
+			close(ack)
+		}
+	}
+}
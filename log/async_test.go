@@ -0,0 +1,118 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingWriter holds the writes until it's released.
+type blockingWriter struct {
+	entered chan struct{}
+	release chan struct{}
+	lock    sync.Mutex
+	buf     bytes.Buffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	select {
+	case w.entered <- struct{}{}:
+	default:
+	}
+	<-w.release
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *blockingWriter) String() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.buf.String()
+}
+
+// The entries beyond the queue are dropped rather than blocking the caller.
+func TestAsyncWriterDropsOnFullQueue(t *testing.T) {
+	out := &blockingWriter{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	w := NewAsyncWriter(out, AsyncConfig{QueueSize: 1, BufferSize: 1})
+
+	w.Write([]byte("a\n"))
+	select {
+	case <-out.entered:
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] the first entry was not written to the output")
+	}
+	// The writer is stuck on the first entry: the second one waits in the queue, the third one is dropped.
+	w.Write([]byte("b\n"))
+	if n, err := w.Write([]byte("c\n")); n != 2 || err != nil {
+		t.Errorf("[FAIL] expected the dropped entry reported written, got %d, %v", n, err)
+	}
+	if w.Dropped() != 1 {
+		t.Errorf("[FAIL] expected 1 dropped entry, got %d", w.Dropped())
+	}
+
+	close(out.release)
+	w.Close()
+	if out.String() != "a\nb\n" {
+		t.Errorf("[FAIL] unexpected output %q", out.String())
+	}
+	if w.Written() != 2 {
+		t.Errorf("[FAIL] expected 2 written entries, got %d", w.Written())
+	}
+}
+
+// Flush writes the entries queued so far in their order, without waiting for the flush interval.
+func TestAsyncWriterFlush(t *testing.T) {
+	out := &blockingWriter{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	close(out.release)
+	w := NewAsyncWriter(out, AsyncConfig{FlushInterval: time.Hour})
+	defer w.Close()
+
+	expected := ""
+	for i := 0; i < 100; i++ {
+		entry := fmt.Sprintf("entry %d\n", i)
+		w.Write([]byte(entry))
+		expected += entry
+	}
+	w.Flush()
+	if out.String() != expected {
+		t.Errorf("[FAIL] expected entries flushed in order, got %q", out.String())
+	}
+	if w.Written() != 100 {
+		t.Errorf("[FAIL] expected 100 written entries, got %d", w.Written())
+	}
+}
+
+// Close drains the queue, the entries written afterwards go to the output at once.
+func TestAsyncWriterClose(t *testing.T) {
+	var out bytes.Buffer
+	w := NewAsyncWriter(&out, AsyncConfig{FlushInterval: time.Hour})
+	for i := 0; i < 10; i++ {
+		w.Write([]byte("queued\n"))
+	}
+	w.Close()
+	if out.String() != strings.Repeat("queued\n", 10) {
+		t.Errorf("[FAIL] expected queued entries written on close, got %q", out.String())
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.Write([]byte("late\n"))
+		}()
+	}
+	wg.Wait()
+	if out.String() != strings.Repeat("queued\n", 10)+strings.Repeat("late\n", 10) {
+		t.Errorf("[FAIL] expected entries written after close, got %q", out.String())
+	}
+	if w.Written() != 10 {
+		t.Errorf("[FAIL] expected 10 entries written by the writer, got %d", w.Written())
+	}
+	// Closing again is harmless.
+	w.Close()
+}
@@ -0,0 +1,21 @@
+package parser
+
+// KeepAliveHook is called on each CRLF received on a stream before the start line of a message.
+// These are the keep-alive pings, double CRLF, and pongs, single CRLF - RFC 5626 3.5.1, which are not parts
+// of the messages and are otherwise ignored - RFC 3261 7.5. The count is the number of CRLFs received since
+// the last message, so the pings are recognized by the even ones.
+// The hook is called on the parser goroutine, so it should return fast.
+type KeepAliveHook func(count int)
+
+// Implements Parser.SetKeepAliveHook.
+func (p *parser) SetKeepAliveHook(hook KeepAliveHook) {
+	p.keepAliveHook = hook
+}
+
+// keepAlive handles the empty line received in place of the start line.
+func (p *parser) keepAlive() {
+	p.crlfs++
+	if p.keepAliveHook != nil {
+		p.keepAliveHook(p.crlfs)
+	}
+}
@@ -0,0 +1,43 @@
+package parser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+func TestKeepAliveHook(t *testing.T) {
+	output := make(chan base.SipMessage, 1)
+	errs := make(chan error, 1)
+	p := NewParser(output, errs, true, log.StandardLogger())
+	defer p.Stop()
+	counts := make(chan int, 8)
+	p.SetKeepAliveHook(func(count int) {
+		counts <- count
+	})
+
+	// The ping, then the message, then the pong are received.
+	p.Write([]byte("\r\n\r\nOPTIONS sip:100@biloxi.com SIP/2.0\r\nCSeq: 1 OPTIONS\r\nContent-Length: 0\r\n\r\n\r\n"))
+	select {
+	case msg := <-output:
+		if req, ok := msg.(*base.Request); !ok || req.Method != base.OPTIONS {
+			t.Errorf("[FAIL] expected OPTIONS, got %s", msg.Short())
+		}
+	case err := <-errs:
+		t.Fatalf("[FAIL] keep-alive CRLFs broke the stream: %s", err)
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] message not parsed")
+	}
+	for _, expected := range []int{1, 2, 1} {
+		select {
+		case count := <-counts:
+			if count != expected {
+				t.Errorf("[FAIL] expected CRLF %d, got %d", expected, count)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] CRLF %d not reported", expected)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+// c_MAX_STREAMED_BODY is the longest body of the streamed messages, longer Content-Length breaks the stream
+// rather than waiting for gigabytes that never come - RFC 4475 clerr.
+const c_MAX_STREAMED_BODY = 1 << 20
+
+// ContentLengthPolicy defines how Content-Length inconsistent with the message body is treated on ingress.
+// Streams are framed by Content-Length, so it can't be checked against the body there,
+// the policy only decides what to do if it is missing - RFC 3261 18.3.
+// Malformed Content-Length, e.g. negative or out of range, breaks the message whatever the policy.
+type ContentLengthPolicy int
+
+const (
+	// ContentLengthIgnore takes the whole datagram as the body whatever Content-Length says,
+	// streams without Content-Length are broken.
+	ContentLengthIgnore ContentLengthPolicy = iota
+	// ContentLengthFix corrects the length: the datagram body is truncated to shorter Content-Length - RFC 3261 18.3,
+	// longer Content-Length is replaced by the length of the body. Messages of streams without Content-Length
+	// are assumed to have no body.
+	ContentLengthFix
+	// ContentLengthReject rejects datagrams with Content-Length not matching the body,
+	// streams without Content-Length are broken.
+	ContentLengthReject
+)
+
+func (policy ContentLengthPolicy) String() string {
+	switch policy {
+	case ContentLengthIgnore:
+		return "Ignore"
+	case ContentLengthFix:
+		return "Fix"
+	case ContentLengthReject:
+		return "Reject"
+	default:
+		return "Unknown"
+	}
+}
+
+// Implements Parser.SetContentLengthPolicy.
+func (p *parser) SetContentLengthPolicy(policy ContentLengthPolicy) {
+	p.lengthPolicy = policy
+}
+
+// ParseDatagram parses the message received in a datagram like ParseMessage,
+// checking its Content-Length against the body by the policy.
+func ParseDatagram(msgData []byte, policy ContentLengthPolicy, logger log.Logger) (base.SipMessage, error) {
+	return ParseDatagramWithHook(msgData, policy, nil, logger)
+}
+
+// isContentLength reports whether the header text is Content-Length header, compact form included.
+func isContentLength(headerText string) bool {
+	colonIdx := strings.Index(headerText, ":")
+	if colonIdx == -1 {
+		return false
+	}
+	return strings.EqualFold(base.ExpandHeaderName(strings.TrimSpace(headerText[:colonIdx])), "Content-Length")
+}
+
+// contentLengths returns the parsed Content-Length headers.
+func contentLengths(headers []base.SipHeader) []base.SipHeader {
+	hdrs := make([]base.SipHeader, 0, 1)
+	for _, h := range headers {
+		if strings.EqualFold(h.Name(), "Content-Length") {
+			hdrs = append(hdrs, h)
+		}
+	}
+	return hdrs
+}
+
+// checkDatagramLength returns the body of the datagram message cut by the policy.
+// hdrs are the parsed Content-Length headers of the message.
+func (p *parser) checkDatagramLength(message base.SipMessage, hdrs []base.SipHeader, body string) (string, error) {
+	if p.lengthPolicy == ContentLengthIgnore || len(hdrs) == 0 {
+		return body, nil
+	}
+	contentLength, ok := hdrs[0].(*base.ContentLength)
+	if !ok || int(*contentLength) == len(body) {
+		return body, nil
+	}
+
+	declared := int(*contentLength)
+	if p.lengthPolicy == ContentLengthReject {
+		return "", base.NewError(
+			base.ErrMalformedMessage,
+			nil,
+			"content-length %d doesn't match body of %d bytes on message %s",
+			declared,
+			len(body),
+			message.Short(),
+		)
+	}
+	if declared < len(body) {
+		p.Log().Debugf("discarding %d bytes beyond content-length %d of message %s",
+			len(body)-declared, declared, message.Short())
+		return body[:declared], nil
+	}
+	p.Log().Warnf("content-length %d exceeds body of %d bytes of message %s, corrected",
+		declared, len(body), message.Short())
+	return body, nil
+}
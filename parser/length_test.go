@@ -0,0 +1,102 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+func TestParseDatagramContentLength(t *testing.T) {
+	datagram := func(contentLength string, body string) []byte {
+		return []byte("MESSAGE sip:bob@biloxi.com SIP/2.0\r\n" +
+			"CSeq: 1 MESSAGE\r\n" +
+			"Content-Length: " + contentLength + "\r\n" +
+			"\r\n" +
+			body)
+	}
+
+	for _, tc := range []struct {
+		policy        ContentLengthPolicy
+		data          []byte
+		body          string
+		contentLength string
+		fails         bool
+	}{
+		{ContentLengthIgnore, datagram("5", "hello world"), "hello world", "11", false},
+		{ContentLengthIgnore, datagram("20", "hello"), "hello", "5", false},
+		{ContentLengthFix, datagram("5", "hello world"), "hello", "5", false},
+		{ContentLengthFix, datagram("20", "hello"), "hello", "5", false},
+		{ContentLengthFix, datagram("5", "hello"), "hello", "5", false},
+		{ContentLengthReject, datagram("5", "hello world"), "", "", true},
+		{ContentLengthReject, datagram("20", "hello"), "", "", true},
+		{ContentLengthReject, datagram("5", "hello"), "hello", "5", false},
+	} {
+		msg, err := ParseDatagram(tc.data, tc.policy, log.StandardLogger())
+		if tc.fails {
+			if err == nil {
+				t.Errorf("[FAIL] %s: expected %q rejected", tc.policy, tc.data)
+			} else if !errors.Is(err, base.ErrMalformedMessage) {
+				t.Errorf("[FAIL] %s: expected malformed message error, got %s", tc.policy, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[FAIL] %s: unexpected error on %q: %s", tc.policy, tc.data, err)
+			continue
+		}
+		if msg.Body() != tc.body {
+			t.Errorf("[FAIL] %s: expected body %q, got %q", tc.policy, tc.body, msg.Body())
+		}
+		if hdrs := msg.Headers("Content-Length"); len(hdrs) != 1 || hdrs[0].String() != "Content-Length: "+tc.contentLength {
+			t.Errorf("[FAIL] %s: expected Content-Length %s, got %v", tc.policy, tc.contentLength, hdrs)
+		}
+	}
+}
+
+func TestStreamedMissingContentLength(t *testing.T) {
+	data := []byte("OPTIONS sip:bob@biloxi.com SIP/2.0\r\n" +
+		"CSeq: 1 OPTIONS\r\n" +
+		"\r\n" +
+		"OPTIONS sip:bob@biloxi.com SIP/2.0\r\n" +
+		"CSeq: 2 OPTIONS\r\n" +
+		"Content-Length: 0\r\n" +
+		"\r\n")
+
+	for _, policy := range []ContentLengthPolicy{ContentLengthIgnore, ContentLengthFix, ContentLengthReject} {
+		output := make(chan base.SipMessage, 2)
+		errs := make(chan error, 1)
+		p := NewParser(output, errs, true, log.StandardLogger())
+		p.SetContentLengthPolicy(policy)
+		p.Write(data)
+
+		if policy == ContentLengthFix {
+			for seqNo := uint32(1); seqNo <= 2; seqNo++ {
+				select {
+				case msg := <-output:
+					if cseq, err := msg.CSeq(); err != nil || cseq.SeqNo != seqNo || msg.Body() != "" {
+						t.Errorf("[FAIL] %s: unexpected message %s", policy, msg.Short())
+					}
+				case err := <-errs:
+					t.Errorf("[FAIL] %s: unexpected error %s", policy, err)
+				case <-time.After(time.Second):
+					t.Errorf("[FAIL] %s: message %d not parsed", policy, seqNo)
+				}
+			}
+		} else {
+			select {
+			case msg := <-output:
+				t.Errorf("[FAIL] %s: unexpected message %s", policy, msg.Short())
+			case err := <-errs:
+				if !errors.Is(err, base.ErrMalformedMessage) {
+					t.Errorf("[FAIL] %s: expected malformed message error, got %s", policy, err)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("[FAIL] %s: missing Content-Length not reported", policy)
+			}
+		}
+		p.Stop()
+	}
+}
@@ -2,9 +2,12 @@ package parser
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"unicode"
 	"unicode/utf8"
 
@@ -38,6 +41,18 @@ type Parser interface {
 	// If a parser is not available for a header type in a message, the parser will produce a base.GenericHeader struct.
 	SetHeaderParser(headerName string, headerParser HeaderParser)
 
+	// Set the policy of checking Content-Length against the body, ContentLengthIgnore by default.
+	// Should be called before the first Write.
+	SetContentLengthPolicy(policy ContentLengthPolicy)
+
+	// Set the hook inspecting messages once their start lines are parsed, nil by default.
+	// Should be called before the first Write.
+	SetStartLineHook(hook StartLineHook)
+
+	// Set the hook notified of the keep-alive CRLFs received on the stream, nil by default.
+	// Should be called before the first Write.
+	SetKeepAliveHook(hook KeepAliveHook)
+
 	Stop()
 }
 
@@ -49,19 +64,30 @@ type HeaderParser func(headerName string, headerData string) (
 
 func defaultHeaderParsers() map[string]HeaderParser {
 	return map[string]HeaderParser{
-		"to":             parseAddressHeader,
-		"t":              parseAddressHeader,
-		"from":           parseAddressHeader,
-		"f":              parseAddressHeader,
-		"contact":        parseAddressHeader,
-		"m":              parseAddressHeader,
-		"call-id":        parseCallId,
-		"cseq":           parseCSeq,
-		"via":            parseViaHeader,
-		"v":              parseViaHeader,
-		"max-forwards":   parseMaxForwards,
-		"content-length": parseContentLength,
-		"l":              parseContentLength,
+		"to":                            parseAddressHeader,
+		"from":                          parseAddressHeader,
+		"contact":                       parseAddressHeader,
+		"route":                         parseRouteHeader,
+		"record-route":                  parseRouteHeader,
+		"call-id":                       parseCallId,
+		"cseq":                          parseCSeq,
+		"via":                           parseViaHeader,
+		"max-forwards":                  parseMaxForwards,
+		"content-length":                parseContentLength,
+		"call-info":                     parseUriParamsHeader,
+		"alert-info":                    parseUriParamsHeader,
+		"geolocation":                   parseUriParamsHeader,
+		"geolocation-routing":           parseGeolocationRouting,
+		"resource-priority":             parseResourcePriority,
+		"accept-resource-priority":      parseResourcePriority,
+		"target-dialog":                 parseTargetDialog,
+		"answer-mode":                   parseAnswerMode,
+		"priv-answer-mode":              parseAnswerMode,
+		"accept-contact":                parseContactPredicate,
+		"reject-contact":                parseContactPredicate,
+		"refer-to":                      parseReferTo,
+		"p-charging-vector":             parseChargingVector,
+		"p-charging-function-addresses": parseChargingFunctionAddresses,
 	}
 }
 
@@ -70,18 +96,7 @@ func defaultHeaderParsers() map[string]HeaderParser {
 // have a guarantee that all messages coming over a connection are from the
 // same endpoint (e.g. UDP).
 func ParseMessage(msgData []byte, logger log.Logger) (base.SipMessage, error) {
-	output := make(chan base.SipMessage, 0)
-	errors := make(chan error, 0)
-	parser := NewParser(output, errors, false, logger)
-	defer parser.Stop()
-
-	parser.Write(msgData)
-	select {
-	case msg := <-output:
-		return msg, nil
-	case err := <-errors:
-		return nil, err
-	}
+	return ParseDatagram(msgData, ContentLengthIgnore, logger)
 }
 
 // Create a new Parser.
@@ -128,11 +143,16 @@ func NewParser(output chan<- base.SipMessage, errs chan<- error, streamed bool,
 type parser struct {
 	headerParsers map[string]HeaderParser
 	streamed      bool
+	lengthPolicy  ContentLengthPolicy
+	startLineHook StartLineHook
+	keepAliveHook KeepAliveHook
+	crlfs         int // CRLFs received on the stream since the last message.
 	input         *parserBuffer
 	bodyLengths   utils.ElasticChan
 	output        chan<- base.SipMessage
 	errs          chan<- error
 	terminalErr   error
+	terminalLock  sync.Mutex
 	stopped       bool
 	log           log.Logger
 }
@@ -141,13 +161,28 @@ func (p *parser) Log() log.Logger {
 	return p.log.WithField("pars-ptr", fmt.Sprintf("%p", p))
 }
 
+// terminal returns the error the parse goroutine stopped on, if any.
+func (p *parser) terminal() error {
+	p.terminalLock.Lock()
+	defer p.terminalLock.Unlock()
+	return p.terminalErr
+}
+
+// fail stops parsing on the terminal error and reports it.
+func (p *parser) fail(err error) {
+	p.terminalLock.Lock()
+	p.terminalErr = err
+	p.terminalLock.Unlock()
+	p.errs <- err
+}
+
 func (p *parser) Write(data []byte) (n int, err error) {
-	if p.terminalErr != nil {
+	if terminalErr := p.terminal(); terminalErr != nil {
 		// The parser has stopped due to a terminal error. Return it.
-		p.Log().Warnf("parser %p ignores %d new bytes due to previous terminal error: %s", p, len(data), p.terminalErr.Error())
-		return 0, p.terminalErr
+		p.Log().Warnf("parser %p ignores %d new bytes due to previous terminal error: %s", p, len(data), terminalErr.Error())
+		return 0, terminalErr
 	} else if p.stopped {
-		return 0, fmt.Errorf("cannot write data to stopped parser %p", p)
+		return 0, base.NewError(base.ErrRejectedMessage, nil, "cannot write data to stopped parser %p", p)
 	}
 
 	if !p.streamed {
@@ -155,7 +190,13 @@ func (p *parser) Write(data []byte) (n int, err error) {
 		p.bodyLengths.In <- l
 	}
 
-	p.input.Write(data)
+	if _, err := p.input.Write(data); err != nil {
+		// The parser stopped on a terminal error before the data was consumed.
+		if terminalErr := p.terminal(); terminalErr != nil {
+			return 0, terminalErr
+		}
+		return 0, err
+	}
 	return len(data), nil
 }
 
@@ -182,21 +223,33 @@ func (p *parser) parse(requireContentLength bool) {
 			break
 		}
 
+		if len(startLine) == 0 && p.streamed {
+			p.keepAlive()
+			continue
+		}
+		p.crlfs = 0
+
+		var lineErr error
 		if isRequest(startLine) {
 			method, recipient, sipVersion, err := parseRequestLine(startLine)
 			message = base.NewRequest(method, recipient, sipVersion, []base.SipHeader{}, "", p.Log())
-			p.terminalErr = err
+			lineErr = err
 		} else if isResponse(startLine) {
 			sipVersion, statusCode, reason, err := parseStatusLine(startLine)
 			message = base.NewResponse(sipVersion, statusCode, reason, []base.SipHeader{}, "", p.Log())
-			p.terminalErr = err
+			lineErr = err
 		} else {
-			p.terminalErr = fmt.Errorf("transmission beginning '%s' is not a SIP message", startLine)
+			lineErr = base.NewError(base.ErrMalformedMessage, nil,
+				"transmission beginning '%s' is not a SIP message", startLine)
+		}
+
+		if lineErr != nil {
+			p.fail(base.NewError(base.ErrMalformedMessage, lineErr, "failed to parse first line of message"))
+			break
 		}
 
-		if p.terminalErr != nil {
-			p.terminalErr = fmt.Errorf("failed to parse first line of message: %s", p.terminalErr.Error())
-			p.errs <- p.terminalErr
+		if err := p.checkStartLine(message); err != nil {
+			p.fail(err)
 			break
 		}
 
@@ -205,12 +258,16 @@ func (p *parser) parse(requireContentLength bool) {
 		// so store lines into a buffer, and then flush and parse it when we hit the end of the header.
 		var buffer bytes.Buffer
 		headers := make([]base.SipHeader, 0)
+		// Malformed Content-Length is not skipped like other headers, the end of the message can't be trusted.
+		var lengthErr error
 
 		flushBuffer := func() {
 			if buffer.Len() > 0 {
 				newHeaders, err := p.parseHeader(buffer.String())
 				if err == nil {
 					headers = append(headers, newHeaders...)
+				} else if isContentLength(buffer.String()) {
+					lengthErr = err
 				} else {
 					p.Log().Debugf("skipping header '%s' due to error: %s", buffer.String(), err.Error())
 				}
@@ -253,8 +310,23 @@ func (p *parser) parse(requireContentLength bool) {
 		}
 
 		// Store the headers in the message object.
+		// The first header of each name replaces the default one set by the message constructor,
+		// repeated headers are preserved so that conflicting duplicates can be detected later.
+		seen := make(map[string]bool)
 		for _, header := range headers {
-			message.SetHeader(header, true)
+			name := strings.ToLower(header.Name())
+			message.SetHeader(header, !seen[name])
+			seen[name] = true
+		}
+
+		if lengthErr != nil {
+			p.fail(base.NewError(
+				base.ErrMalformedMessage,
+				lengthErr,
+				"malformed content-length header on message %s",
+				message.Short(),
+			))
+			break
 		}
 
 		var contentLength int
@@ -262,10 +334,19 @@ func (p *parser) parse(requireContentLength bool) {
 		// Determine the length of the body, so we know when to stop parsing this message.
 		if p.streamed {
 			// Use the content-length header to identify the end of the message.
-			contentLengthHeaders := message.Headers("Content-Length")
+			// The parsed headers are inspected, since the message constructor sets the default one.
+			contentLengthHeaders := contentLengths(headers)
+			if len(contentLengthHeaders) == 0 && p.lengthPolicy == ContentLengthFix {
+				p.Log().Warnf("missing content-length header on message %s, assuming no body", message.Short())
+				contentLengthHeaders = []base.SipHeader{new(base.ContentLength)}
+			}
 			if len(contentLengthHeaders) == 0 {
-				p.terminalErr = fmt.Errorf("missing required content-length header on message %s", message.Short())
-				p.errs <- p.terminalErr
+				p.fail(base.NewError(
+					base.ErrMalformedMessage,
+					nil,
+					"missing required content-length header on message %s",
+					message.Short(),
+				))
 				break
 			} else if len(contentLengthHeaders) > 1 {
 				var errbuf bytes.Buffer
@@ -276,12 +357,22 @@ func (p *parser) parse(requireContentLength bool) {
 					errbuf.WriteString("\t")
 					errbuf.WriteString(header.String())
 				}
-				p.terminalErr = fmt.Errorf(errbuf.String())
-				p.errs <- p.terminalErr
+				p.fail(base.NewError(base.ErrMalformedMessage, nil, "%s", errbuf.String()))
 				break
 			}
 
 			contentLength = int(*(contentLengthHeaders[0].(*base.ContentLength)))
+			if contentLength > c_MAX_STREAMED_BODY {
+				p.fail(base.NewError(
+					base.ErrMalformedMessage,
+					nil,
+					"content-length %d exceeds limit of %d bytes on message %s",
+					contentLength,
+					c_MAX_STREAMED_BODY,
+					message.Short(),
+				))
+				break
+			}
 		} else {
 			// We're not in streaming mode, so the Write method should have calculated the length of the body for us.
 			contentLength = (<-p.bodyLengths.Out).(int)
@@ -295,6 +386,13 @@ func (p *parser) parse(requireContentLength bool) {
 			break
 		}
 
+		if !p.streamed {
+			if body, err = p.checkDatagramLength(message, contentLengths(headers), body); err != nil {
+				p.fail(err)
+				break
+			}
+		}
+
 		switch message.(type) {
 		case *base.Request:
 			message.(*base.Request).SetBody(body)
@@ -306,6 +404,9 @@ func (p *parser) parse(requireContentLength bool) {
 		p.output <- message
 	}
 
+	// Nothing consumes the input anymore, unblock the writers of the rest of the stream.
+	p.input.Stop()
+
 	if !p.streamed {
 		// We're in unstreamed mode, so we created a bodyLengths ElasticChan which
 		// needs to be disposed.
@@ -316,7 +417,7 @@ func (p *parser) parse(requireContentLength bool) {
 
 // Implements ParserFactory.SetHeaderParser.
 func (p *parser) SetHeaderParser(headerName string, headerParser HeaderParser) {
-	headerName = strings.ToLower(headerName)
+	headerName = strings.ToLower(base.ExpandHeaderName(headerName))
 	p.headerParsers[headerName] = headerParser
 }
 
@@ -377,17 +478,30 @@ func parseRequestLine(requestLine string) (
 	method base.Method, recipient base.Uri, sipVersion string, err error) {
 	parts := strings.Split(requestLine, " ")
 	if len(parts) != 3 {
-		err = fmt.Errorf("request line should have 2 spaces: '%s'", requestLine)
+		err = base.NewError(base.ErrMalformedMessage, nil, "request line should have 2 spaces: '%s'", requestLine)
 		return
 	}
 
-	method = base.Method(strings.ToUpper(parts[0]))
+	// Methods are case-sensitive tokens, unknown ones included - RFC 3261 7.1, RFC 4475 intmeth.
+	if !isToken(parts[0]) {
+		err = base.NewError(base.ErrMalformedMessage, nil,
+			"invalid method '%s' in request line: '%s'", parts[0], requestLine)
+		return
+	}
+	method = base.Method(parts[0])
 	recipient, err = ParseUri(parts[1])
+	if err != nil {
+		// Requests to unsupported schemes are well-formed and should be answered with 416 - RFC 3261 8.2.2.1.
+		if absUri, ok := parseAbsoluteUri(parts[1]); ok {
+			recipient, err = absUri, nil
+		}
+	}
 	sipVersion = parts[2]
 
 	switch recipient.(type) {
 	case *base.WildcardUri:
-		err = fmt.Errorf("wildcard URI '*' not permitted in request line: '%s'", requestLine)
+		err = base.NewError(base.ErrMalformedMessage, nil,
+			"wildcard URI '*' not permitted in request line: '%s'", requestLine)
 	}
 
 	return
@@ -400,14 +514,19 @@ func parseStatusLine(statusLine string) (
 	sipVersion string, statusCode uint16, reasonPhrase string, err error) {
 	parts := strings.Split(statusLine, " ")
 	if len(parts) < 3 {
-		err = fmt.Errorf("status line has too few spaces: '%s'", statusLine)
+		err = base.NewError(base.ErrMalformedMessage, nil, "status line has too few spaces: '%s'", statusLine)
 		return
 	}
 
 	sipVersion = parts[0]
+	// Status-Code is 3DIGIT - RFC 3261 25.1, RFC 4475 bigcode.
+	if len(parts[1]) != 3 {
+		err = base.NewError(base.ErrMalformedMessage, nil, "status code should have 3 digits: '%s'", statusLine)
+		return
+	}
 	statusCodeRaw, err := strconv.ParseUint(parts[1], 10, 16)
 	statusCode = uint16(statusCodeRaw)
-	reasonPhrase = strings.Join(parts[2:], "")
+	reasonPhrase = strings.Join(parts[2:], " ")
 
 	return
 }
@@ -423,7 +542,7 @@ func ParseUri(uriStr string) (uri base.Uri, err error) {
 
 	colonIdx := strings.Index(uriStr, ":")
 	if colonIdx == -1 {
-		err = fmt.Errorf("no ':' in URI %s", uriStr)
+		err = base.NewError(base.ErrMalformedMessage, nil, "no ':' in URI %s", uriStr)
 		return
 	}
 
@@ -438,33 +557,55 @@ func ParseUri(uriStr string) (uri base.Uri, err error) {
 		sipUri, err = ParseSipUri(uriStr)
 		uri = &sipUri
 	default:
-		err = fmt.Errorf("unsupported URI schema %s", uriStr[:colonIdx])
+		err = base.NewError(base.ErrMalformedMessage, nil, "unsupported URI schema %s", uriStr[:colonIdx])
 	}
 
 	return
 }
 
+// parseAbsoluteUri accepts URI of any scheme other than sip and sips as base.AbsoluteUri - RFC 3986 3.1.
+func parseAbsoluteUri(uriStr string) (*base.AbsoluteUri, bool) {
+	colonIdx := strings.Index(uriStr, ":")
+	if colonIdx <= 0 || colonIdx == len(uriStr)-1 {
+		return nil, false
+	}
+
+	scheme := uriStr[:colonIdx]
+	switch strings.ToLower(scheme) {
+	case "sip", "sips":
+		return nil, false
+	}
+	for idx, ch := range scheme {
+		isAlpha := (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+		if !isAlpha && (idx == 0 || !strings.ContainsRune("0123456789+-.", ch)) {
+			return nil, false
+		}
+	}
+
+	return &base.AbsoluteUri{Scheme: scheme, Opaque: uriStr[colonIdx+1:]}, true
+}
+
 // ParseSipUri converts a string representation of a SIP or SIPS URI into a SipUri object.
 func ParseSipUri(uriStr string) (uri base.SipUri, err error) {
 	// Store off the original URI in case we need to print it in an error.
 	uriStrCopy := uriStr
 
 	// URI should start 'sip' or 'sips'. Check the first 3 chars.
-	if strings.ToLower(uriStr[:3]) != "sip" {
-		err = fmt.Errorf("invalid SIP uri protocol name in '%s'", uriStrCopy)
+	if len(uriStr) < 3 || strings.ToLower(uriStr[:3]) != "sip" {
+		err = base.NewError(base.ErrMalformedMessage, nil, "invalid SIP uri protocol name in '%s'", uriStrCopy)
 		return
 	}
 	uriStr = uriStr[3:]
 
-	if strings.ToLower(uriStr[0:1]) == "s" {
+	if len(uriStr) > 0 && strings.ToLower(uriStr[0:1]) == "s" {
 		// URI started 'sips', so it's encrypted.
 		uri.IsEncrypted = true
 		uriStr = uriStr[1:]
 	}
 
 	// The 'sip' or 'sips' protocol name should be followed by a ':' character.
-	if uriStr[0] != ':' {
-		err = fmt.Errorf("no ':' after protocol name in SIP uri '%s'", uriStrCopy)
+	if len(uriStr) == 0 || uriStr[0] != ':' {
+		err = base.NewError(base.ErrMalformedMessage, nil, "no ':' after protocol name in SIP uri '%s'", uriStrCopy)
 		return
 	}
 	uriStr = uriStr[1:]
@@ -545,7 +686,7 @@ func ParseSipUri(uriStr string) (uri base.SipUri, err error) {
 	uri.Headers = headers
 	uriStr = uriStr[n:]
 	if len(uriStr) > 0 {
-		err = fmt.Errorf("internal error: parse of SIP uri ended early! '%s'",
+		err = base.NewError(base.ErrMalformedMessage, nil, "internal error: parse of SIP uri ended early! '%s'",
 			uriStrCopy)
 		return // Defensive return
 	}
@@ -557,17 +698,44 @@ func ParseSipUri(uriStr string) (uri base.SipUri, err error) {
 // The port may or may not be present, so we represent it with a *uint16,
 // and return 'nil' if no port was present.
 func parseHostPort(rawText string) (host string, port *uint16, err error) {
-	colonIdx := strings.Index(rawText, ":")
-	if colonIdx == -1 {
-		host = rawText
-		return
+	rawText = strings.TrimSpace(rawText)
+	portText := ""
+	if strings.HasPrefix(rawText, "[") {
+		// IPv6 reference, the host is kept without the brackets - RFC 3261 25.1, RFC 5118 4.1.
+		endIdx := strings.Index(rawText, "]")
+		if endIdx == -1 {
+			err = base.NewError(base.ErrMalformedMessage, nil, "unclosed IPv6 reference in '%s'", rawText)
+			return
+		}
+		host = rawText[1:endIdx]
+		if net.ParseIP(host) == nil {
+			err = base.NewError(base.ErrMalformedMessage, nil, "invalid IPv6 reference in '%s'", rawText)
+			return
+		}
+		rest := rawText[endIdx+1:]
+		if rest == "" {
+			return
+		}
+		if rest[0] != ':' {
+			err = base.NewError(base.ErrMalformedMessage, nil,
+				"unexpected characters after IPv6 reference in '%s'", rawText)
+			return
+		}
+		portText = rest[1:]
+	} else {
+		colonIdx := strings.Index(rawText, ":")
+		if colonIdx == -1 {
+			host = rawText
+			return
+		}
+		host = rawText[:colonIdx]
+		portText = rawText[colonIdx+1:]
 	}
 
 	// Surely there must be a better way..!
 	var portRaw64 uint64
 	var portRaw16 uint16
-	host = rawText[:colonIdx]
-	portRaw64, err = strconv.ParseUint(rawText[colonIdx+1:], 10, 16)
+	portRaw64, err = strconv.ParseUint(portText, 10, 16)
 	portRaw16 = uint16(portRaw64)
 	port = &portRaw16
 
@@ -599,7 +767,8 @@ func parseParams(source string,
 	// Ensure the starting character is correct.
 	if start != 0 {
 		if source[0] != start {
-			err = fmt.Errorf("expected %c at start of key-value section; got %c. section was %s",
+			err = base.NewError(base.ErrMalformedMessage, nil,
+				"expected %c at start of key-value section; got %c. section was %s",
 				start, source[0], source)
 			return
 		}
@@ -613,6 +782,13 @@ func parseParams(source string,
 	inQuotes := false
 parseLoop:
 	for ; consumed < len(source); consumed++ {
+		if inQuotes && source[consumed] == '\\' && consumed+1 < len(source) {
+			// A quoted-pair, the escaped character is a literal part of the value - RFC 3261 25.1.
+			buffer.WriteString(source[consumed : consumed+2])
+			consumed++
+			continue
+		}
+
 		switch source[consumed] {
 		case end:
 			if inQuotes {
@@ -634,7 +810,8 @@ parseLoop:
 			if parsingKey && permitSingletons {
 				params.Add(buffer.String(), base.NoString{})
 			} else if parsingKey {
-				err = fmt.Errorf("singleton param '%s' when parsing params which disallow singletons: \"%s\"",
+				err = base.NewError(base.ErrMalformedMessage, nil,
+					"singleton param '%s' when parsing params which disallow singletons: \"%s\"",
 					buffer.String(), source)
 				return
 			} else {
@@ -653,13 +830,14 @@ parseLoop:
 
 			if parsingKey {
 				// Quotes are never allowed in keys.
-				err = fmt.Errorf("unexpected '\"' in parameter key in params \"%s\"", source)
+				err = base.NewError(base.ErrMalformedMessage, nil,
+					"unexpected '\"' in parameter key in params \"%s\"", source)
 				return
 			}
 
 			if !inQuotes && buffer.Len() != 0 {
 				// We hit an initial quote midway through a value; that's not allowed.
-				err = fmt.Errorf("unexpected '\"' in params \"%s\"", source)
+				err = base.NewError(base.ErrMalformedMessage, nil, "unexpected '\"' in params \"%s\"", source)
 				return
 			}
 
@@ -667,7 +845,8 @@ parseLoop:
 				consumed != len(source)-1 &&
 				source[consumed+1] != sep {
 				// We hit an end-quote midway through a value; that's not allowed.
-				err = fmt.Errorf("unexpected character %c after quoted param in \"%s\"",
+				err = base.NewError(base.ErrMalformedMessage, nil,
+					"unexpected character %c after quoted param in \"%s\"",
 					source[consumed+1], source)
 
 				return
@@ -677,11 +856,11 @@ parseLoop:
 
 		case '=':
 			if buffer.Len() == 0 {
-				err = fmt.Errorf("key of length 0 in params \"%s\"", source)
+				err = base.NewError(base.ErrMalformedMessage, nil, "key of length 0 in params \"%s\"", source)
 				return
 			}
 			if !parsingKey {
-				err = fmt.Errorf("unexpected '=' char in value token: \"%s\"", source)
+				err = base.NewError(base.ErrMalformedMessage, nil, "unexpected '=' char in value token: \"%s\"", source)
 				return
 			}
 			key = buffer.String()
@@ -701,11 +880,12 @@ parseLoop:
 	// The param string has ended. Check that it ended in a valid place, and then store off the
 	// contents of the buffer.
 	if inQuotes {
-		err = fmt.Errorf("unclosed quotes in parameter string: %s", source)
+		err = base.NewError(base.ErrMalformedMessage, nil, "unclosed quotes in parameter string: %s", source)
 	} else if parsingKey && permitSingletons {
 		params.Add(buffer.String(), base.NoString{})
 	} else if parsingKey {
-		err = fmt.Errorf("singleton param '%s' when parsing params which disallow singletons: \"%s\"",
+		err = base.NewError(base.ErrMalformedMessage, nil,
+			"singleton param '%s' when parsing params which disallow singletons: \"%s\"",
 			buffer.String(), source)
 	} else {
 		value := buffer.String()
@@ -723,16 +903,24 @@ func (p *parser) parseHeader(headerText string) (headers []base.SipHeader, err e
 
 	colonIdx := strings.Index(headerText, ":")
 	if colonIdx == -1 {
-		err = fmt.Errorf("field name with no value in header: %s", headerText)
+		err = base.NewError(base.ErrMalformedMessage, nil, "field name with no value in header: %s", headerText)
 		return
 	}
 
-	fieldName := strings.TrimSpace(headerText[:colonIdx])
+	// Compact names are parsed as the full ones, e.g. i as Call-ID - RFC 3261 7.3.3.
+	fieldName := base.ExpandHeaderName(strings.TrimSpace(headerText[:colonIdx]))
+	if fieldName == "" {
+		err = base.NewError(base.ErrMalformedMessage, nil, "empty field name in header: %s", headerText)
+		return
+	}
 	lowerFieldName := strings.ToLower(fieldName)
 	fieldText := strings.TrimSpace(headerText[colonIdx+1:])
 	if headerParser, ok := p.headerParsers[lowerFieldName]; ok {
 		// We have a registered parser for this header type - use it.
 		headers, err = headerParser(lowerFieldName, fieldText)
+		if err != nil && !errors.Is(err, base.ErrMalformedMessage) {
+			err = base.NewError(base.ErrMalformedMessage, err, "invalid %s header", fieldName)
+		}
 	} else {
 		// We have no registered parser for this header type,
 		// so we encapsulate the header data in a GenericHeader struct.
@@ -744,11 +932,46 @@ func (p *parser) parseHeader(headerText string) (headers []base.SipHeader, err e
 	return
 }
 
+// Parse a Route or Record-Route header line, producing a header of every listed route - RFC 3261 20.30, 20.34.
+func parseRouteHeader(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	displayNames, uris, paramSets, err := parseAddressValues(headerText)
+	if err != nil {
+		return nil, err
+	}
+	for idx := range uris {
+		if _, ok := uris[idx].(*base.SipUri); !ok {
+			return nil, base.NewError(base.ErrMalformedMessage, nil,
+				"uri %s not valid in %s header. Must be SIP uri", uris[idx], headerName)
+		}
+		if headerName == "route" {
+			headers = append(headers, &base.RouteHeader{DisplayName: displayNames[idx], Address: uris[idx], Params: paramSets[idx]})
+		} else {
+			headers = append(headers, &base.RecordRouteHeader{DisplayName: displayNames[idx], Address: uris[idx], Params: paramSets[idx]})
+		}
+	}
+	return headers, nil
+}
+
+// ParseContacts parses the value of Contact header listing one or more contacts, e.g. '<sip:a@b>;q=0.7, <sip:c@d>',
+// or the wildcard '*'.
+func ParseContacts(value string) ([]*base.ContactHeader, error) {
+	headers, err := parseAddressHeader("contact", value)
+	if err != nil {
+		return nil, err
+	}
+	contacts := make([]*base.ContactHeader, 0, len(headers))
+	for _, h := range headers {
+		contacts = append(contacts, h.(*base.ContactHeader))
+	}
+	return contacts, nil
+}
+
 // Parse a To, From or Contact header line, producing one or more logical SipHeaders.
 func parseAddressHeader(headerName string, headerText string) (
 	headers []base.SipHeader, err error) {
 	switch headerName {
-	case "to", "from", "contact", "t", "f", "m":
+	case "to", "from", "contact":
 		var displayNames []base.MaybeString
 		var uris []base.Uri
 		var paramSets []base.Params
@@ -761,7 +984,7 @@ func parseAddressHeader(headerName string, headerText string) (
 		}
 		if len(displayNames) != len(uris) || len(uris) != len(paramSets) {
 			// This shouldn't happen unless parseAddressValues is bugged.
-			err = fmt.Errorf("internal parser error: parsed param mismatch. "+
+			err = base.NewError(base.ErrMalformedMessage, nil, "internal parser error: parsed param mismatch. "+
 				"%d display names, %d uris and %d param sets "+
 				"in %s",
 				len(displayNames), len(uris), len(paramSets),
@@ -774,17 +997,17 @@ func parseAddressHeader(headerName string, headerText string) (
 		// although we do not check for this below.
 		for idx := 0; idx < len(displayNames); idx++ {
 			var header base.SipHeader
-			if headerName == "to" || headerName == "t" {
+			if headerName == "to" {
 				if idx > 0 {
 					// Only a single To header is permitted in a SIP message.
 					return nil,
-						fmt.Errorf("multiple to: headers in message:\n%s: %s",
+						base.NewError(base.ErrMalformedMessage, nil, "multiple to: headers in message:\n%s: %s",
 							headerName, headerText)
 				}
 				switch uris[idx].(type) {
 				case base.WildcardUri:
 					// The Wildcard '*' URI is only permitted in Contact headers.
-					err = fmt.Errorf("wildcard uri not permitted in to: "+
+					err = base.NewError(base.ErrMalformedMessage, nil, "wildcard uri not permitted in to: "+
 						"header: %s", headerText)
 					return
 				default:
@@ -793,17 +1016,17 @@ func parseAddressHeader(headerName string, headerText string) (
 						Params:  paramSets[idx]}
 					header = &toHeader
 				}
-			} else if headerName == "from" || headerName == "f" {
+			} else if headerName == "from" {
 				if idx > 0 {
 					// Only a single From header is permitted in a SIP message.
 					return nil,
-						fmt.Errorf("multiple from: headers in message:\n%s: %s",
+						base.NewError(base.ErrMalformedMessage, nil, "multiple from: headers in message:\n%s: %s",
 							headerName, headerText)
 				}
 				switch uris[idx].(type) {
 				case base.WildcardUri:
 					// The Wildcard '*' URI is only permitted in Contact headers.
-					err = fmt.Errorf("wildcard uri not permitted in from: "+
+					err = base.NewError(base.ErrMalformedMessage, nil, "wildcard uri not permitted in from: "+
 						"header: %s", headerText)
 					return
 				default:
@@ -812,19 +1035,21 @@ func parseAddressHeader(headerName string, headerText string) (
 						Params:  paramSets[idx]}
 					header = &fromHeader
 				}
-			} else if headerName == "contact" || headerName == "m" {
+			} else if headerName == "contact" {
 				switch uris[idx].(type) {
 				case base.ContactUri:
 					if uris[idx].(base.ContactUri).IsWildcard() {
 						if paramSets[idx].Length() > 0 {
 							// Wildcard headers do not contain parameters.
-							err = fmt.Errorf("wildcard contact header should contain no parameters: '%s",
+							err = base.NewError(base.ErrMalformedMessage, nil,
+								"wildcard contact header should contain no parameters: '%s",
 								headerText)
 							return
 						}
 						if _, ok := displayNames[idx].(base.String); ok {
 							// Wildcard headers do not contain display names.
-							err = fmt.Errorf("wildcard contact header should contain no display name %s",
+							err = base.NewError(base.ErrMalformedMessage, nil,
+								"wildcard contact header should contain no display name %s",
 								headerText)
 							return
 						}
@@ -836,7 +1061,8 @@ func parseAddressHeader(headerName string, headerText string) (
 				default:
 					// URIs in contact headers are restricted to being either SIP URIs or 'Contact: *'.
 					return nil,
-						fmt.Errorf("uri %s not valid in Contact header. Must be SIP uri or '*'", uris[idx].String())
+						base.NewError(base.ErrMalformedMessage, nil,
+							"uri %s not valid in Contact header. Must be SIP uri or '*'", uris[idx].String())
 				}
 			}
 
@@ -854,7 +1080,8 @@ func parseCSeq(headerName string, headerText string) (
 
 	parts := splitByWhitespace(headerText)
 	if len(parts) != 2 {
-		err = fmt.Errorf("CSeq field should have precisely one whitespace section: '%s'",
+		err = base.NewError(base.ErrMalformedMessage, nil,
+			"CSeq field should have precisely one whitespace section: '%s'",
 			headerText)
 		return
 	}
@@ -866,7 +1093,7 @@ func parseCSeq(headerName string, headerText string) (
 	}
 
 	if seqno > MAX_CSEQ {
-		err = fmt.Errorf("invalid CSeq %d: exceeds maximum permitted value "+
+		err = base.NewError(base.ErrMalformedMessage, nil, "invalid CSeq %d: exceeds maximum permitted value "+
 			"2**31 - 1", seqno)
 		return
 	}
@@ -875,7 +1102,7 @@ func parseCSeq(headerName string, headerText string) (
 	cseq.MethodName = base.Method(strings.TrimSpace(parts[1]))
 
 	if strings.Contains(string(cseq.MethodName), ";") {
-		err = fmt.Errorf("unexpected ';' in CSeq body: %s", headerText)
+		err = base.NewError(base.ErrMalformedMessage, nil, "unexpected ';' in CSeq body: %s", headerText)
 		return
 	}
 
@@ -891,15 +1118,17 @@ func parseCallId(headerName string, headerText string) (
 	var callId base.CallId = base.CallId(headerText)
 
 	if strings.ContainsAny(string(callId), c_ABNF_WS) {
-		err = fmt.Errorf("unexpected whitespace in CallId header body '%s'", headerText)
+		err = base.NewError(base.ErrMalformedMessage, nil,
+			"unexpected whitespace in CallId header body '%s'", headerText)
 		return
 	}
 	if strings.Contains(string(callId), ";") {
-		err = fmt.Errorf("unexpected semicolon in CallId header body '%s'", headerText)
+		err = base.NewError(base.ErrMalformedMessage, nil,
+			"unexpected semicolon in CallId header body '%s'", headerText)
 		return
 	}
 	if len(string(callId)) == 0 {
-		err = fmt.Errorf("empty Call-Id body")
+		err = base.NewError(base.ErrMalformedMessage, nil, "empty Call-Id body")
 		return
 	}
 
@@ -914,14 +1143,14 @@ func parseCallId(headerName string, headerText string) (
 // Via header.
 func parseViaHeader(headerName string, headerText string) (
 	headers []base.SipHeader, err error) {
-	sections := strings.Split(headerText, ",")
+	sections := splitUnquoted(headerText, ',')
 	var via base.ViaHeader = base.ViaHeader{}
 	for _, section := range sections {
 		var hop base.ViaHop
 		parts := strings.Split(section, "/")
 
 		if len(parts) < 3 {
-			err = fmt.Errorf("not enough protocol parts in via header: '%s'",
+			err = base.NewError(base.ErrMalformedMessage, nil, "not enough protocol parts in via header: '%s'",
 				parts)
 			return
 		}
@@ -935,11 +1164,11 @@ func parseViaHeader(headerName string, headerText string) (
 		initialSpaces := len(parts[2]) - len(strings.TrimLeft(parts[2], c_ABNF_WS))
 		sentByIdx := strings.IndexAny(parts[2][initialSpaces:], c_ABNF_WS) + initialSpaces + 1
 		if sentByIdx == 0 {
-			err = fmt.Errorf("expected whitespace after sent-protocol part "+
+			err = base.NewError(base.ErrMalformedMessage, nil, "expected whitespace after sent-protocol part "+
 				"in via header '%s'", section)
 			return
 		} else if sentByIdx == 1 {
-			err = fmt.Errorf("empty transport field in via header '%s'", section)
+			err = base.NewError(base.ErrMalformedMessage, nil, "empty transport field in via header '%s'", section)
 			return
 		}
 
@@ -948,11 +1177,11 @@ func parseViaHeader(headerName string, headerText string) (
 		hop.Transport = strings.TrimSpace(parts[2][:sentByIdx-1])
 
 		if len(hop.ProtocolName) == 0 {
-			err = fmt.Errorf("no protocol name provided in via header '%s'", section)
+			err = base.NewError(base.ErrMalformedMessage, nil, "no protocol name provided in via header '%s'", section)
 		} else if len(hop.ProtocolVersion) == 0 {
-			err = fmt.Errorf("no version provided in via header '%s'", section)
+			err = base.NewError(base.ErrMalformedMessage, nil, "no version provided in via header '%s'", section)
 		} else if len(hop.Transport) == 0 {
-			err = fmt.Errorf("no transport provided in via header '%s'", section)
+			err = base.NewError(base.ErrMalformedMessage, nil, "no transport provided in via header '%s'", section)
 		}
 		if err != nil {
 			return
@@ -1014,6 +1243,300 @@ func parseContentLength(headerName string, headerText string) (
 	return
 }
 
+// Parse a Call-Info, Alert-Info or Geolocation header line, producing one SipHeader per URI.
+func parseUriParamsHeader(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	uris, paramSets, err := parseUriParamsValues(headerText)
+	if err != nil {
+		return
+	}
+
+	for idx := range uris {
+		switch headerName {
+		case "call-info":
+			headers = append(headers, &base.CallInfoHeader{Uri: uris[idx], Params: paramSets[idx]})
+		case "alert-info":
+			headers = append(headers, &base.AlertInfoHeader{Uri: uris[idx], Params: paramSets[idx]})
+		case "geolocation":
+			headers = append(headers, &base.GeolocationHeader{Uri: uris[idx], Params: paramSets[idx]})
+		}
+	}
+
+	return
+}
+
+// Parse a Geolocation-Routing header - RFC 6442 4.2.
+func parseGeolocationRouting(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	value := headerText
+	params := base.NewParams()
+	if idx := strings.Index(headerText, ";"); idx != -1 {
+		value = headerText[:idx]
+		params, _, err = parseParams(headerText[idx:], ';', ';', 0, true, true)
+		if err != nil {
+			return
+		}
+	}
+
+	var header base.GeolocationRoutingHeader
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "yes":
+		header.Allowed = true
+	case "no":
+		header.Allowed = false
+	default:
+		err = base.NewError(base.ErrMalformedMessage, nil, "invalid Geolocation-Routing value '%s'", value)
+		return
+	}
+	header.Params = params
+
+	headers = []base.SipHeader{&header}
+	return
+}
+
+// Parse a Resource-Priority or Accept-Resource-Priority header - RFC 4412 3.
+func parseResourcePriority(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	values := make([]base.ResourcePriority, 0)
+	if len(strings.TrimSpace(headerText)) > 0 {
+		for _, part := range strings.Split(headerText, ",") {
+			var value base.ResourcePriority
+			value, err = base.ParseResourcePriority(part)
+			if err != nil {
+				return
+			}
+			values = append(values, value)
+		}
+	}
+
+	switch headerName {
+	case "resource-priority":
+		if len(values) == 0 {
+			err = base.NewError(base.ErrMalformedMessage, nil, "empty Resource-Priority header")
+			return
+		}
+		headers = []base.SipHeader{&base.ResourcePriorityHeader{Values: values}}
+	case "accept-resource-priority":
+		headers = []base.SipHeader{&base.AcceptResourcePriorityHeader{Values: values}}
+	}
+
+	return
+}
+
+// Parse a Target-Dialog header - RFC 4538 7.
+func parseTargetDialog(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	callId := headerText
+	params := base.NewParams()
+	if idx := strings.Index(headerText, ";"); idx != -1 {
+		callId = headerText[:idx]
+		params, _, err = parseParams(headerText[idx:], ';', ';', 0, true, true)
+		if err != nil {
+			return
+		}
+	}
+
+	callId = strings.TrimSpace(callId)
+	if len(callId) == 0 || strings.ContainsAny(callId, c_ABNF_WS+",") {
+		err = base.NewError(base.ErrMalformedMessage, nil, "invalid Call-ID '%s' in Target-Dialog header", callId)
+		return
+	}
+
+	headers = []base.SipHeader{&base.TargetDialogHeader{CallId: callId, Params: params}}
+	return
+}
+
+// Parse a Refer-To header, it holds exactly one URI - RFC 3515 2.1.
+func parseReferTo(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	displayNames, uris, paramSets, err := parseAddressValues(headerText)
+	if err != nil {
+		return nil, err
+	}
+	if len(uris) != 1 {
+		return nil, base.NewError(base.ErrMalformedMessage, nil,
+			"Refer-To header must hold exactly one URI, got %d", len(uris))
+	}
+	if _, ok := uris[0].(base.WildcardUri); ok {
+		return nil, base.NewError(base.ErrMalformedMessage, nil, "wildcard uri not permitted in Refer-To header")
+	}
+	headers = []base.SipHeader{&base.ReferToHeader{DisplayName: displayNames[0], Address: uris[0], Params: paramSets[0]}}
+	return
+}
+
+// Parse an Answer-Mode or Priv-Answer-Mode header - RFC 5373 7.
+func parseAnswerMode(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	mode := headerText
+	params := base.NewParams()
+	if idx := strings.Index(headerText, ";"); idx != -1 {
+		mode = headerText[:idx]
+		params, _, err = parseParams(headerText[idx:], ';', ';', 0, true, true)
+		if err != nil {
+			return
+		}
+	}
+
+	mode = strings.TrimSpace(mode)
+	if len(mode) == 0 || strings.ContainsAny(mode, c_ABNF_WS+",") {
+		err = base.NewError(base.ErrMalformedMessage, nil, "invalid answer mode '%s'", mode)
+		return
+	}
+
+	header := base.AnswerModeHeader{Private: headerName == "priv-answer-mode", Mode: mode}
+	if _, ok := params.Get("require"); ok {
+		header.Require = true
+		params.Remove("require")
+	}
+	header.Params = params
+
+	headers = []base.SipHeader{&header}
+	return
+}
+
+// Parse an Accept-Contact or Reject-Contact header - RFC 3841 10, producing one SipHeader per predicate.
+func parseContactPredicate(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	text := strings.TrimSpace(headerText)
+	for len(text) > 0 {
+		if text[0] != '*' {
+			err = base.NewError(base.ErrMalformedMessage, nil, "contact predicate '%s' doesn't start with '*'", text)
+			return
+		}
+		text = strings.TrimLeft(text[1:], c_ABNF_WS)
+
+		params := base.NewParams()
+		if len(text) > 0 && text[0] != ',' {
+			var consumed int
+			params, consumed, err = parseParams(text, ';', ';', ',', true, true)
+			if err != nil {
+				return
+			}
+			text = text[consumed:]
+		}
+		text = strings.TrimLeft(strings.TrimPrefix(text, ","), c_ABNF_WS)
+
+		header := base.ContactPredicateHeader{Reject: headerName == "reject-contact"}
+		if _, ok := params.Get("require"); ok {
+			header.Require = true
+			params.Remove("require")
+		}
+		if _, ok := params.Get("explicit"); ok {
+			header.Explicit = true
+			params.Remove("explicit")
+		}
+		header.Features = params
+		headers = append(headers, &header)
+	}
+	if len(headers) == 0 {
+		err = base.NewError(base.ErrMalformedMessage, nil, "empty contact predicate header")
+	}
+	return
+}
+
+// Parse a P-Charging-Vector header - RFC 7315 4.6.
+func parseChargingVector(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	var params base.Params
+	params, _, err = parseParams(";"+strings.TrimSpace(headerText), ';', ';', 0, true, true)
+	if err != nil {
+		return
+	}
+
+	icid, ok := params.Get("icid-value")
+	if !ok || icid == nil || len(icid.String()) == 0 {
+		err = base.NewError(base.ErrMalformedMessage, nil,
+			"missing icid-value in P-Charging-Vector header '%s'", headerText)
+		return
+	}
+	params.Remove("icid-value")
+
+	headers = []base.SipHeader{&base.ChargingVectorHeader{Icid: icid.String(), Params: params}}
+	return
+}
+
+// Parse a P-Charging-Function-Addresses header - RFC 7315 4.5.
+// ccf and ecf parameters may be repeated, so they are collected in order.
+func parseChargingFunctionAddresses(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	header := base.ChargingFunctionAddressesHeader{Params: base.NewParams()}
+	for _, part := range strings.Split(headerText, ";") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+
+		name, value := part, ""
+		if idx := strings.Index(part, "="); idx != -1 {
+			name, value = strings.TrimSpace(part[:idx]), strings.Trim(strings.TrimSpace(part[idx+1:]), "\"")
+		}
+
+		switch strings.ToLower(name) {
+		case "ccf":
+			header.Ccf = append(header.Ccf, value)
+		case "ecf":
+			header.Ecf = append(header.Ecf, value)
+		default:
+			header.Params.Add(name, base.String{S: value})
+		}
+	}
+
+	if len(header.Ccf) == 0 && len(header.Ecf) == 0 {
+		err = base.NewError(base.ErrMalformedMessage, nil, "no charging function addresses in header '%s'", headerText)
+		return
+	}
+
+	headers = []base.SipHeader{&header}
+	return
+}
+
+// parseUriParamsValues parses a comma-separated list of <absoluteURI> *(;param) values,
+// as used by Call-Info, Alert-Info and similar headers.
+// URIs are returned as is, since they are not necessarily SIP URIs.
+func parseUriParamsValues(text string) (uris []string, paramSets []base.Params, err error) {
+	for len(strings.TrimSpace(text)) > 0 {
+		text = strings.TrimSpace(text)
+		if text[0] != '<' {
+			err = base.NewError(base.ErrMalformedMessage, nil, "expected '<' at start of URI in header value: %s", text)
+			return
+		}
+
+		endOfUri := strings.Index(text, ">")
+		if endOfUri == -1 {
+			err = base.NewError(base.ErrMalformedMessage, nil, "'<' without closing '>' in header value: %s", text)
+			return
+		}
+		uri := text[1:endOfUri]
+		text = text[endOfUri+1:]
+
+		endOfValue := findUnescaped(text, ',', quotes_delim)
+		if endOfValue == -1 {
+			endOfValue = len(text)
+		}
+
+		params := base.NewParams()
+		if paramText := strings.TrimSpace(text[:endOfValue]); len(paramText) > 0 {
+			params, _, err = parseParams(paramText, ';', ';', 0, true, true)
+			if err != nil {
+				return
+			}
+		}
+
+		uris = append(uris, uri)
+		paramSets = append(paramSets, params)
+
+		if endOfValue < len(text) {
+			endOfValue++
+		}
+		text = text[endOfValue:]
+	}
+
+	if len(uris) == 0 {
+		err = base.NewError(base.ErrMalformedMessage, nil, "empty header value")
+	}
+	return
+}
+
 // parseAddressValues parses a comma-separated list of addresses, returning
 // any display names and header params, as well as the SIP URIs themselves.
 // parseAddressValues is aware of < > bracketing and quoting, and will not
@@ -1025,13 +1548,19 @@ func parseAddressValues(addresses string) (
 	prevIdx := 0
 	inBrackets := false
 	inQuotes := false
+	escaped := false
 
 	// Append a comma to simplify the parsing code; we split address sections
 	// on commas, so use a comma to signify the end of the final address section.
 	addresses = addresses + ","
 
 	for idx, char := range addresses {
-		if char == '<' && !inQuotes {
+		if escaped {
+			escaped = false
+		} else if char == '\\' && inQuotes {
+			// A quoted-pair in the display name, e.g. \" - RFC 3261 25.1.
+			escaped = true
+		} else if char == '<' && !inQuotes {
 			inBrackets = true
 		} else if char == '>' && !inQuotes {
 			inBrackets = false
@@ -1054,6 +1583,13 @@ func parseAddressValues(addresses string) (
 		}
 	}
 
+	if inQuotes {
+		err = base.NewError(base.ErrMalformedMessage, nil,
+			"unclosed quotes in addresses %s", addresses[:len(addresses)-1])
+	} else if inBrackets {
+		err = base.NewError(base.ErrMalformedMessage, nil,
+			"'<' without closing '>' in addresses %s", addresses[:len(addresses)-1])
+	}
 	return
 }
 
@@ -1073,7 +1609,7 @@ func parseAddressValue(addressText string) (
 	headerParams = base.NewParams()
 
 	if len(addressText) == 0 {
-		err = fmt.Errorf("address-type header has empty body")
+		err = base.NewError(base.ErrMalformedMessage, nil, "address-type header has empty body")
 		return
 	}
 
@@ -1090,11 +1626,11 @@ func parseAddressValue(addressText string) (
 			// The display name is within quotations.
 			// So it is comprised of all text until the closing quote.
 			addressText = addressText[1:]
-			nextQuote := strings.Index(addressText, "\"")
+			nextQuote := findClosingQuote(addressText)
 
 			if nextQuote == -1 {
 				// Unclosed quotes - parse error.
-				err = fmt.Errorf("unclosed quotes in header text: %s",
+				err = base.NewError(base.ErrMalformedMessage, nil, "unclosed quotes in header text: %s",
 					addressTextCopy)
 				return
 			}
@@ -1117,6 +1653,10 @@ func parseAddressValue(addressText string) (
 
 	// Work out where the SIP URI starts and ends.
 	addressText = strings.TrimSpace(addressText)
+	if len(addressText) == 0 {
+		err = base.NewError(base.ErrMalformedMessage, nil, "no URI in address %s", addressTextCopy)
+		return
+	}
 	var endOfUri int
 	var startOfParams int
 	if addressText[0] != '<' {
@@ -1124,7 +1664,7 @@ func parseAddressValue(addressText string) (
 		case base.String:
 			// The address must be in <angle brackets> if a display name is
 			// present, so this is an invalid address line.
-			err = fmt.Errorf("invalid character '%c' following display "+
+			err = base.NewError(base.ErrMalformedMessage, nil, "invalid character '%c' following display "+
 				"name in address line; expected '<': %s",
 				addressText[0], addressTextCopy)
 			return
@@ -1139,10 +1679,13 @@ func parseAddressValue(addressText string) (
 	} else {
 		addressText = addressText[1:]
 		endOfUri = strings.Index(addressText, ">")
-		if endOfUri == 0 {
-			err = fmt.Errorf("'<' without closing '>' in address %s",
+		if endOfUri == -1 {
+			err = base.NewError(base.ErrMalformedMessage, nil, "'<' without closing '>' in address %s",
 				addressTextCopy)
 			return
+		} else if endOfUri == 0 {
+			err = base.NewError(base.ErrMalformedMessage, nil, "empty URI in address %s", addressTextCopy)
+			return
 		}
 		startOfParams = endOfUri + 1
 
@@ -1151,7 +1694,12 @@ func parseAddressValue(addressText string) (
 	// Now parse the SIP URI.
 	uri, err = ParseUri(addressText[:endOfUri])
 	if err != nil {
-		return
+		// Addresses of unsupported schemes are well-formed, the headers decide whether they are allowed - RFC 4475 unkscm.
+		if absUri, ok := parseAbsoluteUri(addressText[:endOfUri]); ok {
+			uri, err = absUri, nil
+		} else {
+			return
+		}
 	}
 
 	if startOfParams >= len(addressText) {
@@ -1159,7 +1707,11 @@ func parseAddressValue(addressText string) (
 	}
 
 	// Finally, parse any header parameters and then return.
-	addressText = addressText[startOfParams:]
+	// The parameters may be preceded by whitespace, e.g. '<sip:bob@biloxi.com> ;tag=1' - RFC 3261 25.1.
+	addressText = strings.TrimLeft(addressText[startOfParams:], c_ABNF_WS)
+	if len(addressText) == 0 {
+		return
+	}
 	headerParams, _, err = parseParams(addressText, ';', ';', ',', true, true)
 	return
 }
@@ -1229,6 +1781,11 @@ func findAnyUnescaped(text string, targets string, delims ...delimiter) int {
 		}
 
 		if escaped {
+			if endEscape == '"' && text[idx] == '\\' {
+				// Skip the quoted-pair, e.g. \" doesn't close the quotes - RFC 3261 25.1.
+				idx++
+				continue
+			}
 			escaped = text[idx] != endEscape
 			continue
 		} else {
@@ -1239,6 +1796,55 @@ func findAnyUnescaped(text string, targets string, delims ...delimiter) int {
 	return -1
 }
 
+// findClosingQuote returns the index of the quote closing the quoted string the text starts within,
+// skipping quoted-pairs like \", or -1 if the quotes are unclosed - RFC 3261 25.1.
+func findClosingQuote(text string) int {
+	for idx := 0; idx < len(text); idx++ {
+		switch text[idx] {
+		case '\\':
+			idx++
+		case '"':
+			return idx
+		}
+	}
+	return -1
+}
+
+// splitUnquoted splits the text by the separator outside quoted strings,
+// e.g. the values of the header listed on one line.
+func splitUnquoted(text string, sep uint8) []string {
+	sections := make([]string, 0, 1)
+	inQuotes := false
+	start := 0
+	for idx := 0; idx < len(text); idx++ {
+		switch {
+		case inQuotes && text[idx] == '\\':
+			idx++
+		case text[idx] == '"':
+			inQuotes = !inQuotes
+		case !inQuotes && text[idx] == sep:
+			sections = append(sections, text[start:idx])
+			start = idx + 1
+		}
+	}
+	return append(sections, text[start:])
+}
+
+// isToken reports whether the text is a non-empty token, e.g. a method - RFC 3261 25.1.
+func isToken(text string) bool {
+	if len(text) == 0 {
+		return false
+	}
+	for idx := 0; idx < len(text); idx++ {
+		ch := text[idx]
+		isAlphaNum := (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9')
+		if !isAlphaNum && !strings.ContainsRune("-.!%*_+`'~", rune(ch)) {
+			return false
+		}
+	}
+	return true
+}
+
 // Splits the given string into sections, separated by one or more characters
 // from c_ABNF_WS.
 func splitByWhitespace(text string) []string {
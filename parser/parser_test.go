@@ -2,6 +2,7 @@ package parser
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -153,6 +154,10 @@ func TestSipUris(t *testing.T) {
 		{sipUriInput("sip:bob@88.88.88.88:5060"), &sipUriResult{pass, base.SipUri{User: base.String{"bob"}, Password: base.NoString{}, Host: "88.88.88.88", Port: &ui16_5060, UriParams: noParams, Headers: noParams}}},
 		{sipUriInput("sip:bob:Hunter2@example.com:5060"), &sipUriResult{pass, base.SipUri{User: base.String{"bob"}, Password: base.String{"Hunter2"},
 			Host: "example.com", Port: &ui16_5060, UriParams: noParams, Headers: noParams}}},
+		{sipUriInput("sip:bob@[2001:db8::10]:5060"), &sipUriResult{pass, base.SipUri{User: base.String{"bob"}, Password: base.NoString{}, Host: "2001:db8::10", Port: &ui16_5060, UriParams: noParams, Headers: noParams}}},
+		{sipUriInput("sip:[2001:db8::10];transport=tcp"), &sipUriResult{pass, base.SipUri{User: base.NoString{}, Password: base.NoString{}, Host: "2001:db8::10",
+			UriParams: base.NewParams().Add("transport", base.String{"tcp"}), Headers: noParams}}},
+		{sipUriInput("sip:bob@[2001:db8::10]x"), &sipUriResult{fail, base.SipUri{}}},
 		{sipUriInput("sip:bob@example.com:5"), &sipUriResult{pass, base.SipUri{User: base.String{"bob"}, Password: base.NoString{}, Host: "example.com", Port: &ui16_5, UriParams: noParams, Headers: noParams}}},
 		{sipUriInput("sip:bob@example.com;foo=bar"), &sipUriResult{pass, base.SipUri{User: base.String{"bob"}, Password: base.NoString{}, Host: "example.com",
 			UriParams: base.NewParams().Add("foo", base.String{"bar"}), Headers: noParams}}},
@@ -887,6 +892,8 @@ func TestCallIds(t *testing.T) {
 		{callIdInput("Call-ID: banana"), &callIdResult{pass, base.CallId("banana")}},
 		{callIdInput("calL-id: banana"), &callIdResult{pass, base.CallId("banana")}},
 		{callIdInput("calL-id: 1banana"), &callIdResult{pass, base.CallId("1banana")}},
+		{callIdInput("i: banana"), &callIdResult{pass, base.CallId("banana")}},
+		{callIdInput("I: banana"), &callIdResult{pass, base.CallId("banana")}},
 		{callIdInput("Call-ID:"), &callIdResult{fail, base.CallId("")}},
 		{callIdInput("Call-ID: banana spaghetti"), &callIdResult{fail, base.CallId("")}},
 		{callIdInput("Call-ID: banana\tspaghetti"), &callIdResult{fail, base.CallId("")}},
@@ -941,6 +948,7 @@ func TestViaHeaders(t *testing.T) {
 	singleFoo := base.NewParams().Add("foo", base.NoString{})
 	doTests([]test{
 		{viaInput("Via: SIP/2.0/UDP pc33.atlanta.com"), &viaResult{pass, &base.ViaHeader{&base.ViaHop{"SIP", "2.0", "UDP", "pc33.atlanta.com", nil, noParams}}}},
+		{viaInput("v: SIP/2.0/UDP pc33.atlanta.com"), &viaResult{pass, &base.ViaHeader{&base.ViaHop{"SIP", "2.0", "UDP", "pc33.atlanta.com", nil, noParams}}}},
 		{viaInput("Via: bAzz/fooo/BAAR pc33.atlanta.com"), &viaResult{pass, &base.ViaHeader{&base.ViaHop{"bAzz", "fooo", "BAAR", "pc33.atlanta.com", nil, noParams}}}},
 		{viaInput("Via: SIP/2.0/UDP pc33.atlanta.com"), &viaResult{pass, &base.ViaHeader{&base.ViaHop{"SIP", "2.0", "UDP", "pc33.atlanta.com", nil, noParams}}}},
 		{viaInput("Via: SIP /\t2.0 / UDP pc33.atlanta.com"), &viaResult{pass, &base.ViaHeader{&base.ViaHop{"SIP", "2.0", "UDP", "pc33.atlanta.com", nil, noParams}}}},
@@ -952,6 +960,10 @@ func TestViaHeaders(t *testing.T) {
 		{viaInput("Via: SIP/2.0/UDP box:5060;foo=bar"), &viaResult{pass, &base.ViaHeader{&base.ViaHop{"SIP", "2.0", "UDP", "box", &ui16_5060, fooEqBar}}}},
 		{viaInput("Via: SIP/2.0/UDP box:5060;foo"), &viaResult{pass, &base.ViaHeader{&base.ViaHop{"SIP", "2.0", "UDP", "box", &ui16_5060, singleFoo}}}},
 		{viaInput("Via: SIP/2.0/UDP box:5060;foo=//bar"), &viaResult{pass, &base.ViaHeader{&base.ViaHop{"SIP", "2.0", "UDP", "box", &ui16_5060, fooEqSlashBar}}}},
+		{viaInput("Via: SIP/2.0/UDP [2001:db8::9:1]:5060;foo=bar"), &viaResult{pass, &base.ViaHeader{&base.ViaHop{"SIP", "2.0", "UDP", "2001:db8::9:1", &ui16_5060, fooEqBar}}}},
+		{viaInput("Via: SIP/2.0/UDP [2001:db8::9:1]"), &viaResult{pass, &base.ViaHeader{&base.ViaHop{"SIP", "2.0", "UDP", "2001:db8::9:1", nil, noParams}}}},
+		{viaInput("Via: SIP/2.0/UDP [2001:db8::9:1:5060"), &viaResult{fail, &base.ViaHeader{}}},
+		{viaInput("Via: SIP/2.0/UDP [example.com]:5060"), &viaResult{fail, &base.ViaHeader{}}},
 		{viaInput("Via: /2.0/UDP box:5060;foo=bar"), &viaResult{fail, &base.ViaHeader{}}},
 		{viaInput("Via: SIP//UDP box:5060;foo=bar"), &viaResult{fail, &base.ViaHeader{}}},
 		{viaInput("Via: SIP/2.0/ box:5060;foo=bar"), &viaResult{fail, &base.ViaHeader{}}},
@@ -1172,6 +1184,45 @@ func TestUnstreamedParse7(t *testing.T) {
 	test.Test(t)
 }
 
+// Unstreamed parsing of compact header names.
+func TestUnstreamedParse8(t *testing.T) {
+	body := "I am a banana"
+	callId := base.CallId("a84b4c76e66710")
+	test := ParserTest{false, []parserTestStep{
+		{"MESSAGE sip:bob@biloxi.com SIP/2.0\r\n" +
+			"i: a84b4c76e66710\r\n" +
+			"c: text/plain\r\n" +
+			"k: timer\r\n" +
+			fmt.Sprintf("l: %d\r\n", len(body)) +
+			"\r\n" +
+			body,
+			base.NewRequest(
+				base.Method("MESSAGE"),
+				&base.SipUri{
+					IsEncrypted: false,
+					User:        base.String{S: "bob"},
+					Password:    base.NoString{},
+					Host:        "biloxi.com",
+					Port:        nil,
+					UriParams:   noParams,
+					Headers:     noParams,
+				},
+				"SIP/2.0",
+				[]base.SipHeader{
+					&callId,
+					&base.GenericHeader{HeaderName: "Content-Type", Contents: "text/plain"},
+					&base.GenericHeader{HeaderName: "Supported", Contents: "timer"},
+				},
+				body,
+				log.StandardLogger(),
+			),
+			nil,
+			nil},
+	}}
+
+	test.Test(t)
+}
+
 // TODO: Error cases for unstreamed parse.
 // TODO: Multiple writes on unstreamed parse.
 
@@ -2002,6 +2053,261 @@ func (step *parserTestStep) Test(parser Parser, msgChan chan base.SipMessage, er
 	return
 }
 
+// Header and URI parameters must be rendered in the order they were parsed.
+func TestParamOrderRoundTrip(t *testing.T) {
+	raw := "INVITE sip:bob@biloxi.com;user=phone;transport=udp;lr;maddr=1.2.3.4 SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP pc33.atlanta.com;rport;branch=z9hG4bK776;received=1.2.3.4;ttl=5;maddr=5.6.7.8\r\n" +
+		"To: <sip:bob@biloxi.com;z=1;y=2;x=3>;tag=abc;zeta;alpha=1\r\n" +
+		"From: \"Alice\" <sip:alice@atlanta.com?subject=project&priority=urgent&a=b>;tag=1928;epid=x;b=2;a=1\r\n" +
+		"Content-Length: 0\r\n" +
+		"\r\n"
+
+	// Repeat a few times, so that any map iteration order leak shows up.
+	for i := 0; i < 20; i++ {
+		testsRun++
+		msg, err := ParseMessage([]byte(raw), log.StandardLogger())
+		if err != nil {
+			t.Fatalf("failed to parse message: %s", err)
+		}
+		if msg.String() != raw {
+			t.Fatalf("parameter order changed:\nexpected:\n%s\ngot:\n%s", raw, msg.String())
+		}
+		testsPassed++
+	}
+
+	params := base.NewParams().
+		Add("c", base.String{S: "1"}).
+		Add("a", base.NoString{}).
+		Add("b", base.String{S: "2"}).
+		Add("a", base.String{S: "3"}).
+		Remove("c").
+		Add("c", base.String{S: "4"})
+	if s := params.ToString(';'); s != "a=3;b=2;c=4" {
+		t.Errorf("unexpected params order after update: %s", s)
+	}
+}
+
+func TestIPv6RoundTrip(t *testing.T) {
+	raw := "INVITE sip:bob@[2001:db8::20]:5060;transport=udp SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP [2001:db8::10]:5060;branch=z9hG4bK776;received=2001:db8::11;rport=5070\r\n" +
+		"To: <sip:bob@[2001:db8::20]>\r\n" +
+		"From: <sip:alice@[2001:db8::10]>;tag=1928\r\n" +
+		"Contact: <sip:alice@[2001:db8::10]:5060>\r\n" +
+		"Content-Length: 0\r\n" +
+		"\r\n"
+
+	testsRun++
+	msg, err := ParseMessage([]byte(raw), log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse message: %s", err)
+	}
+	if msg.String() != raw {
+		t.Fatalf("[FAIL] IPv6 message changed:\nexpected:\n%s\ngot:\n%s", raw, msg.String())
+	}
+	hop, _ := msg.ViaHop()
+	if hop.Host != "2001:db8::10" || base.HopAddr(hop) != "[2001:db8::10]:5060" || base.ResponseAddr(hop) != "[2001:db8::11]:5070" {
+		t.Errorf("[FAIL] unexpected Via hop addresses %s, %s of %s", base.HopAddr(hop), base.ResponseAddr(hop), hop)
+	}
+	if uri := msg.(*base.Request).Recipient.(*base.SipUri); base.UriAddr(uri) != "[2001:db8::20]:5060" {
+		t.Errorf("[FAIL] unexpected Request-URI address %s", base.UriAddr(uri))
+	}
+	testsPassed++
+}
+
+func TestMalformedMessageError(t *testing.T) {
+	testsRun++
+	_, err := ParseMessage([]byte("NOT A SIP MESSAGE\r\n\r\n"), log.StandardLogger())
+	if !errors.Is(err, base.ErrMalformedMessage) {
+		t.Errorf("expected malformed message error, got: %v", err)
+		return
+	}
+	testsPassed++
+}
+
+// Header lines are compared by the string form of the parsed headers.
+type headersInput string
+
+func (data headersInput) String() string {
+	return string(data)
+}
+
+func (data headersInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	strs := make([]string, 0, len(headers))
+	for _, header := range headers {
+		strs = append(strs, header.String())
+	}
+	return &headersResult{err, strs}
+}
+
+type headersResult struct {
+	err     error
+	headers []string
+}
+
+func (expected *headersResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*headersResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got %q", actual.headers)
+	} else if actual.err == nil && strings.Join(expected.headers, "\r\n") != strings.Join(actual.headers, "\r\n") {
+		return false, fmt.Sprintf("unexpected headers: expected %q, got %q", expected.headers, actual.headers)
+	}
+	return true, ""
+}
+
+func TestInfoHeaders(t *testing.T) {
+	doTests([]test{
+		{headersInput("Call-Info: <http://wwww.example.com/alice/photo.jpg> ;purpose=icon, <http://www.example.com/alice/> ;purpose=info"),
+			&headersResult{pass, []string{
+				"Call-Info: <http://wwww.example.com/alice/photo.jpg>;purpose=icon",
+				"Call-Info: <http://www.example.com/alice/>;purpose=info",
+			}}},
+		{headersInput("Call-Info: <sip:alice@example.com>;appearance-index=2;appearance-state=active"),
+			&headersResult{pass, []string{"Call-Info: <sip:alice@example.com>;appearance-index=2;appearance-state=active"}}},
+		{headersInput("Alert-Info: <http://www.example.com/sounds/moo.wav>"),
+			&headersResult{pass, []string{"Alert-Info: <http://www.example.com/sounds/moo.wav>"}}},
+		{headersInput("Alert-Info: <urn:alert:service:call-waiting>, <http://127.0.0.1/Bellcore-dr4>;info=alert-internal"),
+			&headersResult{pass, []string{
+				"Alert-Info: <urn:alert:service:call-waiting>",
+				"Alert-Info: <http://127.0.0.1/Bellcore-dr4>;info=alert-internal",
+			}}},
+		{headersInput("Alert-Info: http://www.example.com/sounds/moo.wav"), &headersResult{fail, nil}},
+		{headersInput("Call-Info: <http://www.example.com/alice/"), &headersResult{fail, nil}},
+		{headersInput("Call-Info: "), &headersResult{fail, nil}},
+	}, t)
+
+	testsRun++
+	headers, err := parseHeader("Call-Info: <sip:alice@example.com>;purpose=info;appearance-index=3")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	info := headers[0].(*base.CallInfoHeader)
+	if index, ok := info.Appearance(); info.Purpose() != "info" || !ok || index != 3 {
+		t.Errorf("unexpected Call-Info parameters: %s", info)
+		return
+	}
+	testsPassed++
+}
+
+func TestGeolocationHeaders(t *testing.T) {
+	doTests([]test{
+		{headersInput("Geolocation: <cid:target123@atlanta.example.com>"),
+			&headersResult{pass, []string{"Geolocation: <cid:target123@atlanta.example.com>"}}},
+		{headersInput("Geolocation: <cid:target123@atlanta.example.com>, <sips:3sdefrhy2jj7@lis.atlanta.example.com;transport=tls>"),
+			&headersResult{pass, []string{
+				"Geolocation: <cid:target123@atlanta.example.com>",
+				"Geolocation: <sips:3sdefrhy2jj7@lis.atlanta.example.com;transport=tls>",
+			}}},
+		{headersInput("Geolocation-Routing: yes"), &headersResult{pass, []string{"Geolocation-Routing: yes"}}},
+		{headersInput("Geolocation-Routing: No;foo=bar"), &headersResult{pass, []string{"Geolocation-Routing: no;foo=bar"}}},
+		{headersInput("Geolocation-Routing: maybe"), &headersResult{fail, nil}},
+		{headersInput("Geolocation: cid:target123@atlanta.example.com"), &headersResult{fail, nil}},
+	}, t)
+}
+
+func TestResourcePriorityHeaders(t *testing.T) {
+	doTests([]test{
+		{headersInput("Resource-Priority: dsn.flash"), &headersResult{pass, []string{"Resource-Priority: dsn.flash"}}},
+		{headersInput("Resource-Priority: DSN.Flash, wps.3"), &headersResult{pass, []string{"Resource-Priority: dsn.flash, wps.3"}}},
+		{headersInput("Accept-Resource-Priority: dsn.routine,dsn.priority, dsn.immediate"),
+			&headersResult{pass, []string{"Accept-Resource-Priority: dsn.routine, dsn.priority, dsn.immediate"}}},
+		{headersInput("Accept-Resource-Priority: "), &headersResult{pass, []string{"Accept-Resource-Priority: "}}},
+		{headersInput("Resource-Priority: "), &headersResult{fail, nil}},
+		{headersInput("Resource-Priority: flash"), &headersResult{fail, nil}},
+		{headersInput("Resource-Priority: dsn."), &headersResult{fail, nil}},
+	}, t)
+}
+
+func TestTargetDialogHeaders(t *testing.T) {
+	doTests([]test{
+		{headersInput("Target-Dialog: fa77as7dad8-sd98ajzz@host.example.com;local-tag=kkaz-;remote-tag=6544"),
+			&headersResult{pass, []string{"Target-Dialog: fa77as7dad8-sd98ajzz@host.example.com;local-tag=kkaz-;remote-tag=6544"}}},
+		{headersInput("Target-Dialog: abc ; remote-tag=1"), &headersResult{pass, []string{"Target-Dialog: abc;remote-tag=1"}}},
+		{headersInput("Target-Dialog: ;local-tag=1"), &headersResult{fail, nil}},
+		{headersInput("Target-Dialog: abc def"), &headersResult{fail, nil}},
+	}, t)
+}
+
+func TestAnswerModeHeaders(t *testing.T) {
+	doTests([]test{
+		{headersInput("Answer-Mode: Auto"), &headersResult{pass, []string{"Answer-Mode: Auto"}}},
+		{headersInput("Answer-Mode: Manual ;require"), &headersResult{pass, []string{"Answer-Mode: Manual;require"}}},
+		{headersInput("Priv-Answer-Mode: Auto;require;foo=bar"), &headersResult{pass, []string{"Priv-Answer-Mode: Auto;require;foo=bar"}}},
+		{headersInput("Answer-Mode: "), &headersResult{fail, nil}},
+		{headersInput("Answer-Mode: ;require"), &headersResult{fail, nil}},
+	}, t)
+}
+
+func TestContactPredicateHeaders(t *testing.T) {
+	doTests([]test{
+		{headersInput("Accept-Contact: *;audio;require"), &headersResult{pass, []string{"Accept-Contact: *;audio;require"}}},
+		{headersInput("a: *;methods=\"INVITE,BYE\";explicit;require, *;video"),
+			&headersResult{pass, []string{"Accept-Contact: *;methods=\"INVITE,BYE\";require;explicit", "Accept-Contact: *;video"}}},
+		{headersInput("Reject-Contact: *;actor=\"msg-taker\";video"), &headersResult{pass, []string{"Reject-Contact: *;actor=msg-taker;video"}}},
+		{headersInput("j: *"), &headersResult{pass, []string{"Reject-Contact: *"}}},
+		{headersInput("Accept-Contact: audio"), &headersResult{fail, nil}},
+		{headersInput("Accept-Contact: "), &headersResult{fail, nil}},
+	}, t)
+}
+
+func TestReferToHeaders(t *testing.T) {
+	doTests([]test{
+		{headersInput("Refer-To: <sip:carol@example.com>"), &headersResult{pass, []string{"Refer-To: <sip:carol@example.com>"}}},
+		{headersInput("r: \"Carol\" <sip:carol@example.com;method=BYE>;foo=bar"),
+			&headersResult{pass, []string{"Refer-To: \"Carol\" <sip:carol@example.com;method=BYE>;foo=bar"}}},
+		{headersInput("Refer-To: sip:carol@example.com"), &headersResult{pass, []string{"Refer-To: <sip:carol@example.com>"}}},
+		{headersInput("Refer-To: <sip:carol@example.com>, <sip:dave@example.com>"), &headersResult{fail, nil}},
+		{headersInput("Refer-To: *"), &headersResult{fail, nil}},
+	}, t)
+}
+
+func TestChargingHeaders(t *testing.T) {
+	doTests([]test{
+		{headersInput("P-Charging-Vector: icid-value=1234bc9876e; icid-generated-at=192.0.6.8; orig-ioi=home1.net"),
+			&headersResult{pass, []string{"P-Charging-Vector: icid-value=1234bc9876e;icid-generated-at=192.0.6.8;orig-ioi=home1.net"}}},
+		{headersInput("P-Charging-Vector: icid-value=\"AyretyU0dm+6O2IrT5tAFrbHLso\""),
+			&headersResult{pass, []string{"P-Charging-Vector: icid-value=AyretyU0dm+6O2IrT5tAFrbHLso"}}},
+		{headersInput("P-Charging-Vector: orig-ioi=home1.net"), &headersResult{fail, nil}},
+		{headersInput("P-Charging-Function-Addresses: ccf=192.1.1.1; ccf=192.1.1.2; ecf=\"[5555::b99:c88:d77:e66]\""),
+			&headersResult{pass, []string{"P-Charging-Function-Addresses: ccf=192.1.1.1;ccf=192.1.1.2;ecf=\"[5555::b99:c88:d77:e66]\""}}},
+		{headersInput("P-Charging-Function-Addresses: foo=bar"), &headersResult{fail, nil}},
+	}, t)
+}
+
+func TestUnsupportedSchemeRequest(t *testing.T) {
+	raw := "OPTIONS http://www.example.com/ SIP/2.0\r\nCSeq: 1 OPTIONS\r\nContent-Length: 0\r\n\r\n"
+	msg, err := ParseMessage([]byte(raw), log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] request to http: URI failed to parse: %s", err)
+	}
+	uri, ok := msg.(*base.Request).Recipient.(*base.AbsoluteUri)
+	if !ok || uri.Scheme != "http" || uri.String() != "http://www.example.com/" {
+		t.Errorf("[FAIL] unexpected Request-URI %v", msg.(*base.Request).Recipient)
+	}
+
+	if _, err := ParseMessage([]byte("OPTIONS 1nvalid SIP/2.0\r\nContent-Length: 0\r\n\r\n"), log.StandardLogger()); err == nil {
+		t.Errorf("[FAIL] request with invalid Request-URI was parsed")
+	}
+}
+
+func TestMalformedHeaderError(t *testing.T) {
+	testsRun++
+	for _, header := range []string{
+		"CSeq: 1 2 INVITE",
+		"Via: SIP/2.0",
+		"To: <sip:bob@example.com",
+		": no name",
+	} {
+		if _, err := parseHeader(header); !errors.Is(err, base.ErrMalformedMessage) {
+			t.Errorf("expected malformed message error on header %q, got: %v", header, err)
+			return
+		}
+	}
+	testsPassed++
+}
+
 func TestZZZCountTests(t *testing.T) {
 	fmt.Printf("\n *** %d tests run ***", testsRun)
 	fmt.Printf("\n *** %d tests passed (%.2f%%) ***\n\n", testsPassed, float32(testsPassed)*100.0/float32(testsRun))
@@ -2033,3 +2339,65 @@ func errToStr(err error) string {
 		return err.Error()
 	}
 }
+
+func TestParseContacts(t *testing.T) {
+	contacts, err := ParseContacts("\"Alice\" <sip:alice@10.0.0.1>;q=0.7;expires=60, " +
+		"<sip:alice@10.0.0.2;transport=tcp>;+sip.instance=\"<urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6>\";reg-id=1")
+	if err != nil {
+		t.Fatalf("[FAIL] unexpected error: %s", err)
+	}
+	if len(contacts) != 2 {
+		t.Fatalf("[FAIL] expected 2 contacts, got %d", len(contacts))
+	}
+	if q, ok := contacts[0].Q(); !ok || q != 0.7 {
+		t.Errorf("[FAIL] expected q-value 0.7, got %v", q)
+	}
+	if expires, ok := contacts[0].Expires(); !ok || expires != time.Minute {
+		t.Errorf("[FAIL] expected expires 1m, got %v", expires)
+	}
+	if q, ok := contacts[1].Q(); ok || q != 1 {
+		t.Errorf("[FAIL] expected default q-value 1, got %v", q)
+	}
+	if instance, ok := contacts[1].Instance(); !ok || instance != "<urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6>" {
+		t.Errorf("[FAIL] unexpected instance %s", instance)
+	}
+	if regId, ok := contacts[1].RegId(); !ok || regId != 1 {
+		t.Errorf("[FAIL] expected reg-id 1, got %d", regId)
+	}
+
+	wildcard, err := ParseContacts("*")
+	if err != nil || len(wildcard) != 1 || !wildcard[0].IsWildcard() {
+		t.Errorf("[FAIL] expected wildcard contact, got %v: %v", wildcard, err)
+	}
+	if _, err := ParseContacts("*;expires=0"); err == nil {
+		t.Errorf("[FAIL] expected wildcard with parameters rejected")
+	}
+}
+
+func TestParseRouteHeaders(t *testing.T) {
+	headers, err := parseRouteHeader("record-route",
+		`<sip:p1.example.com;lr>, "Proxy, Two" <sip:p2.example.com;lr>,<sip:p3.example.com>`)
+	if err != nil {
+		t.Fatalf("[FAIL] unexpected error: %s", err)
+	}
+	expected := []string{
+		"Record-Route: <sip:p1.example.com;lr>",
+		"Record-Route: \"Proxy, Two\" <sip:p2.example.com;lr>",
+		"Record-Route: <sip:p3.example.com>",
+	}
+	if len(headers) != len(expected) {
+		t.Fatalf("[FAIL] expected %v, got %v", expected, headers)
+	}
+	for i := range expected {
+		if headers[i].String() != expected[i] {
+			t.Errorf("[FAIL] expected header %d %s, got %s", i, expected[i], headers[i])
+		}
+	}
+	if rr := headers[2].(*base.RecordRouteHeader); rr.IsLoose() || !headers[0].(*base.RecordRouteHeader).IsLoose() {
+		t.Errorf("[FAIL] expected only the first two routes loose")
+	}
+
+	if _, err := parseRouteHeader("route", "<tel:+15551234>"); err == nil {
+		t.Errorf("[FAIL] expected non-SIP route rejected")
+	}
+}
@@ -0,0 +1,53 @@
+package parser
+
+import (
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+// StartLineHook inspects the message as soon as its start line is parsed, before its headers and body arrive,
+// e.g. to drop requests of scanners by method or Request-URI without buffering the rest of them.
+// The message has no headers and no body yet. Returning an error rejects the message: the parser reports it
+// as a terminal error of kind base.ErrRejectedMessage, wrapping the returned error, and produces nothing.
+// The hook is called on the parser goroutine, so it should return fast.
+type StartLineHook func(msg base.SipMessage) error
+
+// Implements Parser.SetStartLineHook.
+func (p *parser) SetStartLineHook(hook StartLineHook) {
+	p.startLineHook = hook
+}
+
+// checkStartLine runs the start line hook on the message having only the start line parsed.
+func (p *parser) checkStartLine(message base.SipMessage) error {
+	if p.startLineHook == nil {
+		return nil
+	}
+	if err := p.startLineHook(message); err != nil {
+		return base.NewError(base.ErrRejectedMessage, err, "message %s rejected on start line", message.Short())
+	}
+	return nil
+}
+
+// ParseDatagramWithHook parses the message received in a datagram like ParseDatagram,
+// rejecting it by the start line hook before its headers are parsed, see StartLineHook.
+func ParseDatagramWithHook(
+	msgData []byte,
+	policy ContentLengthPolicy,
+	hook StartLineHook,
+	logger log.Logger,
+) (base.SipMessage, error) {
+	output := make(chan base.SipMessage, 0)
+	errors := make(chan error, 0)
+	parser := NewParser(output, errors, false, logger)
+	parser.SetContentLengthPolicy(policy)
+	parser.SetStartLineHook(hook)
+	defer parser.Stop()
+
+	parser.Write(msgData)
+	select {
+	case msg := <-output:
+		return msg, nil
+	case err := <-errors:
+		return nil, err
+	}
+}
@@ -0,0 +1,82 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+var errScanner = errors.New("scanner")
+
+// rejectScanners rejects OPTIONS probes of sipvicious, which sends them to sip:100@host.
+func rejectScanners(msg base.SipMessage) error {
+	if req, ok := msg.(*base.Request); ok && req.Method == base.OPTIONS {
+		if uri, ok := req.Recipient.(*base.SipUri); ok && uri.User != nil && uri.User.String() == "100" {
+			return errScanner
+		}
+	}
+	return nil
+}
+
+func TestParseDatagramWithHook(t *testing.T) {
+	for _, tc := range []struct {
+		data     string
+		rejected bool
+	}{
+		{"OPTIONS sip:100@biloxi.com SIP/2.0\r\nCSeq: 1 OPTIONS\r\n\r\n", true},
+		{"OPTIONS sip:bob@biloxi.com SIP/2.0\r\nCSeq: 1 OPTIONS\r\n\r\n", false},
+		{"SIP/2.0 200 OK\r\nCSeq: 1 OPTIONS\r\n\r\n", false},
+	} {
+		msg, err := ParseDatagramWithHook([]byte(tc.data), ContentLengthIgnore, rejectScanners, log.StandardLogger())
+		if tc.rejected {
+			if err == nil {
+				t.Errorf("[FAIL] expected %q rejected, got %s", tc.data, msg.Short())
+			} else if !errors.Is(err, base.ErrRejectedMessage) || !errors.Is(err, errScanner) {
+				t.Errorf("[FAIL] expected rejected message error wrapping the hook error, got %s", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[FAIL] unexpected error on %q: %s", tc.data, err)
+		}
+	}
+}
+
+func TestStreamedStartLineHook(t *testing.T) {
+	output := make(chan base.SipMessage, 1)
+	errs := make(chan error, 1)
+	p := NewParser(output, errs, true, log.StandardLogger())
+	defer p.Stop()
+	inspected := make(chan base.SipMessage, 1)
+	p.SetStartLineHook(func(msg base.SipMessage) error {
+		inspected <- msg
+		return rejectScanners(msg)
+	})
+
+	// Only the start line arrives, the message is rejected without waiting for the rest of it.
+	p.Write([]byte("OPTIONS sip:100@biloxi.com SIP/2.0\r\n"))
+	select {
+	case msg := <-inspected:
+		if len(msg.Headers("CSeq")) != 0 || msg.Body() != "" {
+			t.Errorf("[FAIL] expected message with start line only, got %q", msg.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] start line not inspected")
+	}
+	select {
+	case msg := <-output:
+		t.Errorf("[FAIL] unexpected message %s", msg.Short())
+	case err := <-errs:
+		if !errors.Is(err, base.ErrRejectedMessage) {
+			t.Errorf("[FAIL] expected rejected message error, got %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("[FAIL] rejection not reported")
+	}
+	if _, err := p.Write([]byte("CSeq: 1 OPTIONS\r\n")); err == nil {
+		t.Errorf("[FAIL] expected parser stopped by the rejection")
+	}
+}
@@ -0,0 +1,133 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/utils"
+)
+
+// Template builds SIP messages from text/template text, e.g. for table-driven tests
+// or canned responses loaded from configuration.
+// Lines may end with LF or CRLF. Content-Length is added when the template has none.
+//
+// Variables are referred as {{.Name}}; Branch, FromTag, ToTag and CallId are generated for every message
+// unless given, any other variable, e.g. LocalAddr, must be given.
+// Functions branch, tag and callId generate fresh values inside the template, e.g. for several Via headers.
+type Template struct {
+	tmpl *template.Template
+}
+
+var templateFuncs = template.FuncMap{
+	"branch": base.GenerateBranch,
+	"tag":    base.GenerateTag,
+	"callId": generateCallId,
+}
+
+// NewTemplate parses the message template.
+func NewTemplate(name string, text string) (*Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message template %s: %s", name, err)
+	}
+	return &Template{tmpl}, nil
+}
+
+// MustTemplate is like NewTemplate but panics on error, for templates defined in code.
+func MustTemplate(name string, text string) *Template {
+	tmpl, err := NewTemplate(name, text)
+	if err != nil {
+		panic(err)
+	}
+	return tmpl
+}
+
+// Render executes the template with the variables, returns the raw message.
+func (tmpl *Template) Render(vars map[string]interface{}) ([]byte, error) {
+	data := map[string]interface{}{
+		"Branch":  base.GenerateBranch(),
+		"FromTag": base.GenerateTag(),
+		"ToTag":   base.GenerateTag(),
+		"CallId":  generateCallId(),
+	}
+	for name, value := range vars {
+		data[name] = value
+	}
+
+	var buffer bytes.Buffer
+	if err := tmpl.tmpl.Execute(&buffer, data); err != nil {
+		return nil, fmt.Errorf("failed to render message template %s: %s", tmpl.tmpl.Name(), err)
+	}
+	return normalizeMessage(buffer.String()), nil
+}
+
+// Message renders the template and parses the message.
+func (tmpl *Template) Message(vars map[string]interface{}, logger log.Logger) (base.SipMessage, error) {
+	raw, err := tmpl.Render(vars)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMessage(raw, logger)
+}
+
+// Request renders the template and parses the request.
+func (tmpl *Template) Request(vars map[string]interface{}, logger log.Logger) (*base.Request, error) {
+	msg, err := tmpl.Message(vars, logger)
+	if err != nil {
+		return nil, err
+	}
+	req, ok := msg.(*base.Request)
+	if !ok {
+		return nil, fmt.Errorf("message template %s renders %s, request expected", tmpl.tmpl.Name(), msg.Short())
+	}
+	return req, nil
+}
+
+// Response renders the template and parses the response.
+func (tmpl *Template) Response(vars map[string]interface{}, logger log.Logger) (*base.Response, error) {
+	msg, err := tmpl.Message(vars, logger)
+	if err != nil {
+		return nil, err
+	}
+	res, ok := msg.(*base.Response)
+	if !ok {
+		return nil, fmt.Errorf("message template %s renders %s, response expected", tmpl.tmpl.Name(), msg.Short())
+	}
+	return res, nil
+}
+
+// normalizeMessage terminates the header lines with CRLF, separates the body with an empty line
+// and adds Content-Length if it's missing.
+func normalizeMessage(text string) []byte {
+	text = strings.TrimLeft(text, "\r\n")
+	head, body := text, ""
+	if idx := strings.Index(text, "\n\n"); idx >= 0 {
+		head, body = text[:idx], text[idx+2:]
+	} else if idx := strings.Index(text, "\r\n\r\n"); idx >= 0 {
+		head, body = text[:idx], text[idx+4:]
+	}
+
+	lines := strings.Split(strings.TrimRight(head, "\r\n"), "\n")
+	hasLength := false
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		lines[i] = line
+		name := strings.ToLower(strings.TrimSpace(strings.SplitN(line, ":", 2)[0]))
+		if name == "content-length" || name == "l" {
+			hasLength = true
+		}
+	}
+	if !hasLength {
+		lines = append(lines, fmt.Sprintf("Content-Length: %d", len(body)))
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n\r\n" + body)
+}
+
+func generateCallId() string {
+	return base.IdSalt() + utils.RandStr(12)
+}
@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+var inviteTemplate = MustTemplate("invite", `INVITE sip:bob@example.com SIP/2.0
+Via: SIP/2.0/UDP {{.LocalAddr}};branch={{.Branch}}
+From: <sip:alice@example.com>;tag={{.FromTag}}
+To: <sip:bob@example.com>
+Call-Id: {{.CallId}}
+CSeq: 1 INVITE
+Content-Type: application/sdp
+
+v=0
+`)
+
+func TestTemplateRequest(t *testing.T) {
+	req, err := inviteTemplate.Request(map[string]interface{}{
+		"LocalAddr": "10.0.0.1:5060",
+		"CallId":    "template-call",
+	}, log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] %s", err)
+	}
+
+	if hop, err := req.ViaHop(); err != nil || hop.Host != "10.0.0.1" || hop.Port == nil || *hop.Port != 5060 {
+		t.Errorf("[FAIL] expected Via sent-by 10.0.0.1:5060, got %v", hop)
+	}
+	if branch, err := req.Branch(); err != nil || len(branch.String()) <= len(base.RFC3261BranchMagicCookie) {
+		t.Errorf("[FAIL] expected generated branch, got %v", branch)
+	}
+	if callId, err := req.CallId(); err != nil || string(*callId) != "template-call" {
+		t.Errorf("[FAIL] expected given Call-Id template-call, got %v", callId)
+	}
+	if req.Body() != "v=0\n" {
+		t.Errorf("[FAIL] unexpected body %q", req.Body())
+	}
+	if length := req.Headers("Content-Length"); len(length) != 1 || length[0].String() != "Content-Length: 4" {
+		t.Errorf("[FAIL] expected generated Content-Length: 4, got %v", length)
+	}
+
+	other, err := inviteTemplate.Request(map[string]interface{}{"LocalAddr": "10.0.0.1:5060"}, log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] %s", err)
+	}
+	tag1, _ := req.FromTag()
+	tag2, _ := other.FromTag()
+	if tag1.String() == tag2.String() {
+		t.Errorf("[FAIL] expected tags generated per message, got %s twice", tag1)
+	}
+}
+
+func TestTemplateResponse(t *testing.T) {
+	tmpl := MustTemplate("busy", "SIP/2.0 503 Service Unavailable\r\n"+
+		"Via: SIP/2.0/UDP proxy.example.com;branch={{branch}}\r\n"+
+		"Retry-After: {{.RetryAfter}}\r\n"+
+		"Content-Length: 0\r\n\r\n")
+
+	res, err := tmpl.Response(map[string]interface{}{"RetryAfter": 30}, log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] %s", err)
+	}
+	if res.StatusCode != 503 || len(res.Headers("Retry-After")) != 1 {
+		t.Errorf("[FAIL] unexpected response:\n%s", res.String())
+	}
+
+	if _, err := tmpl.Request(map[string]interface{}{"RetryAfter": 30}, log.StandardLogger()); err == nil {
+		t.Errorf("[FAIL] expected error rendering response as request")
+	}
+	if _, err := tmpl.Response(nil, log.StandardLogger()); err == nil {
+		t.Errorf("[FAIL] expected error on missing variable")
+	}
+}
@@ -0,0 +1,570 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+// torture builds the message of the lines and the body, formatting Content-Length of the lines with %d
+// by the length of the body.
+func torture(body string, lines ...string) string {
+	var buffer strings.Builder
+	for _, line := range lines {
+		if strings.Contains(line, "%d") {
+			line = fmt.Sprintf(line, len(body))
+		}
+		buffer.WriteString(line)
+		buffer.WriteString("\r\n")
+	}
+	buffer.WriteString("\r\n")
+	buffer.WriteString(body)
+	return buffer.String()
+}
+
+const tortureSdp = "v=0\r\n" +
+	"o=mhandley 29739 7272939 IN IP4 192.0.2.3\r\n" +
+	"s=-\r\n" +
+	"c=IN IP4 192.0.2.4\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 49217 RTP/AVP 0 12\r\n" +
+	"m=video 3227 RTP/AVP 31\r\n" +
+	"a=rtpmap:31 LPC\r\n"
+
+// tortureCase is a torture message, mostly of RFC 4475, parsed or rejected with base.ErrMalformedMessage
+// when received in a datagram and on a stream.
+type tortureCase struct {
+	name     string
+	data     string
+	datagram bool // Parsed from a datagram.
+	stream   bool // Parsed from a stream.
+	check    func(msg base.SipMessage) error
+}
+
+func tortureCases() []tortureCase {
+	longName := strings.Repeat("I have a user name of extreme proportion ", 25)
+	longValue := strings.Repeat("unknown-token-", 300)
+	longVia := strings.TrimSuffix(strings.Repeat("SIP/2.0/TCP sip33.example.com;branch=z9hG4bK1, ", 30), ", ")
+
+	return []tortureCase{
+		{
+			// RFC 4475: case-insensitive names, compact forms, line folding and whitespace everywhere.
+			name: "wsinv",
+			data: torture(tortureSdp,
+				"INVITE sip:vivekg@chair-dnrc.example.com;unknownparam SIP/2.0",
+				"TO :",
+				" sip:vivekg@chair-dnrc.example.com ;   tag    = 1918181833n",
+				`from   : "J Rosenberg \\\""       <sip:jdrosen@example.com>`,
+				"  ;",
+				"  tag = 98asjd8",
+				"MaX-fOrWaRdS: 0068",
+				"Call-ID: wsinv.ndaksdj@192.0.2.1",
+				"Content-Length   : %d",
+				"cseq: 0009",
+				"  INVITE",
+				"Via  : SIP  /   2.0",
+				" /UDP",
+				"    192.0.2.2;rport;branch=390skdjuw",
+				"s :",
+				"NewFangledHeader:   newfangled value",
+				" continued newfangled value",
+				"UnknownHeaderWithUnusualValue: ;;,,;;,;",
+				"Content-Type: application/sdp",
+				"Route:",
+				" <sip:services.example.com;lr;unknownwith=value;unknown-no-value>",
+				"v:  SIP  / 2.0  / TCP     spindle.example.com   ;",
+				"  branch  =   z9hG4bK9ikj8  ,",
+				" SIP  /    2.0   / UDP  192.168.255.111   ; branch=",
+				" z9hG4bK30239",
+				`m:"Quoted string \"\"" <sip:jdrosen@example.com> ; newparam =`,
+				"      newvalue ;",
+				"  secondparam ; q = 0.33",
+			),
+			datagram: true,
+			stream:   true,
+			check: func(msg base.SipMessage) error {
+				if to, err := msg.ToTag(); err != nil || to.String() != "1918181833n" {
+					return fmt.Errorf("unexpected To tag %v: %v", to, err)
+				}
+				from, err := msg.From()
+				if err != nil || from.DisplayName.String() != `J Rosenberg \\\"` {
+					return fmt.Errorf("unexpected From %v: %v", from, err)
+				}
+				if tag, ok := from.Params.Get("tag"); !ok || tag.String() != "98asjd8" {
+					return fmt.Errorf("unexpected From tag in %s", from)
+				}
+				if cseq, err := msg.CSeq(); err != nil || cseq.SeqNo != 9 || cseq.MethodName != base.INVITE {
+					return fmt.Errorf("unexpected CSeq %v: %v", cseq, err)
+				}
+				if hdrs := msg.Headers("Max-Forwards"); len(hdrs) != 1 || *(hdrs[0].(*base.MaxForwards)) != 68 {
+					return fmt.Errorf("unexpected Max-Forwards %v", hdrs)
+				}
+				if hops := viaHops(msg); len(hops) != 3 || hops[2].Host != "192.168.255.111" || hops[1].Transport != "TCP" {
+					return fmt.Errorf("unexpected Via hops %v", hops)
+				}
+				contacts := msg.Contacts()
+				if len(contacts) != 1 || contacts[0].DisplayName.String() != `Quoted string \"\"` {
+					return fmt.Errorf("unexpected Contact %v", contacts)
+				}
+				if q, ok := contacts[0].Params.Get("q"); !ok || q.String() != "0.33" {
+					return fmt.Errorf("unexpected Contact q in %s", contacts[0])
+				}
+				if msg.Body() != tortureSdp {
+					return fmt.Errorf("unexpected body %q", msg.Body())
+				}
+				return nil
+			},
+		},
+		{
+			// RFC 4475: unknown method of all the token characters, case-sensitive.
+			name: "intmeth",
+			data: torture("",
+				"!interesting-Method0123456789_*+`.%indeed'~ sip:1_unusual.URI~(to-be!sure)&isn't+it$/crazy?,/;;*:&it+has=1,weird!*pas$wo~d_too.(doesn't-it)@example.com SIP/2.0",
+				"Via: SIP/2.0/TCP host1.example.com;branch=z9hG4bK-.!%66*_+`'~",
+				"To: \"BEL:\\\x07 NUL:\\\x00 DEL:\\\x7f\" <sip:1_unusual.URI~(to-be!sure)&isn't+it$/crazy?,/;;*@example.com>",
+				"From: token1~` token2'+_ token3*%!.- <sip:mundane@example.com>;fromParam''~+*_!.-%=\"работающий\";tag=_token~1'+`*%!-.",
+				"Call-ID: intmeth.word%ZK-!.*_+'@word`~)(><:\\/\"][?}{",
+				"CSeq: 139122385 !interesting-Method0123456789_*+`.%indeed'~",
+				"Max-Forwards: 255",
+				"extensionHeader-!.%*+_`'~: \ufeff大停電",
+				"Content-Length: %d",
+			),
+			datagram: true,
+			stream:   true,
+			check: func(msg base.SipMessage) error {
+				method := base.Method("!interesting-Method0123456789_*+`.%indeed'~")
+				if req := msg.(*base.Request); req.Method != method {
+					return fmt.Errorf("unexpected method %s", req.Method)
+				}
+				if cseq, err := msg.CSeq(); err != nil || cseq.MethodName != method {
+					return fmt.Errorf("unexpected CSeq %v: %v", cseq, err)
+				}
+				if tag, err := msg.FromTag(); err != nil || tag.String() != "_token~1'+`*%!-." {
+					return fmt.Errorf("unexpected From tag %v: %v", tag, err)
+				}
+				if _, err := msg.To(); err != nil {
+					return err
+				}
+				if hdrs := msg.Headers("extensionHeader-!.%*+_`'~"); len(hdrs) != 1 {
+					return fmt.Errorf("unexpected extension header %v", hdrs)
+				}
+				return nil
+			},
+		},
+		{
+			// RFC 4475: escaped characters in URIs are kept as is.
+			name: "esc01",
+			data: torture(tortureSdp,
+				"INVITE sip:sips%3Auser%40example.com@example.net SIP/2.0",
+				"To: sip:%75se%72@example.com",
+				"From: <sip:I%20have%20spaces@example.net>;tag=938",
+				"Max-Forwards: 87",
+				"i: esc01.239409asdfakjkn23onasd0-3234",
+				"CSeq: 234234 INVITE",
+				"Via: SIP/2.0/UDP host5.example.net;branch=z9hG4bKkdjuw",
+				"C: application/sdp",
+				"Contact:",
+				"  <sip:cal%6Cer@host5.example.net;%6C%72;n%61me=v%61lue%25%34%31>",
+				"Content-Length: %d",
+			),
+			datagram: true,
+			stream:   true,
+			check: func(msg base.SipMessage) error {
+				uri, ok := msg.(*base.Request).Recipient.(*base.SipUri)
+				if !ok || uri.User.String() != "sips%3Auser%40example.com" || uri.Host != "example.net" {
+					return fmt.Errorf("unexpected Request-URI %v", msg.(*base.Request).Recipient)
+				}
+				if callId, err := msg.CallId(); err != nil || string(*callId) != "esc01.239409asdfakjkn23onasd0-3234" {
+					return fmt.Errorf("unexpected Call-ID %v: %v", callId, err)
+				}
+				contacts := msg.Contacts()
+				if len(contacts) != 1 {
+					return fmt.Errorf("unexpected Contact %v", contacts)
+				}
+				if value, ok := contacts[0].Address.(*base.SipUri).UriParams.Get("n%61me"); !ok || value.String() != "v%61lue%25%34%31" {
+					return fmt.Errorf("unexpected Contact URI %s", contacts[0].Address)
+				}
+				return nil
+			},
+		},
+		{
+			// RFC 4475: escaped null characters in URIs.
+			name: "escnull",
+			data: torture("",
+				"REGISTER sip:example.com SIP/2.0",
+				"To: sip:null-%00-null@example.com",
+				"From: sip:null-%00-null@example.com;tag=839923423",
+				"Max-Forwards: 70",
+				"Call-ID: escnull.39203ndfvkjdasfkq3w4otrq0adsfdfnavd",
+				"CSeq: 14398234 REGISTER",
+				"Via: SIP/2.0/UDP host5.example.com;branch=z9hG4bKkdjuw",
+				"Contact: <sip:%00@host5.example.com>",
+				"Contact: <sip:%00%00@host5.example.com>",
+				"L:%d",
+			),
+			datagram: true,
+			stream:   true,
+			check: func(msg base.SipMessage) error {
+				if contacts := msg.Contacts(); len(contacts) != 2 {
+					return fmt.Errorf("unexpected Contact %v", contacts)
+				}
+				if to, err := msg.To(); err != nil || to.Address.(*base.SipUri).User.String() != "null-%00-null" {
+					return fmt.Errorf("unexpected To %v: %v", to, err)
+				}
+				return nil
+			},
+		},
+		{
+			// RFC 4475: long values everywhere.
+			name: "longreq",
+			data: torture("",
+				"INVITE sip:user@example.com SIP/2.0",
+				"To: \""+longName+"\" <sip:user@example.com>;tag=1928301774",
+				"From: sip:amazinglylongcallername"+strings.Repeat("z", 500)+"@example.net;tag=12982424",
+				"Call-ID: longreq."+strings.Repeat("onereallyreallyreallyreallylongcallid", 20),
+				"CSeq: 3882340 INVITE",
+				"Unknown-"+strings.Repeat("Long", 100)+"-Name: "+longValue+";unknown-"+strings.Repeat("long", 100)+"-parameter-name=value",
+				"Via: "+longVia,
+				"Via: SIP/2.0/TCP sip32.example.com;branch=z9hG4bK2",
+				"Max-Forwards: 70",
+				"Contact: <sip:amazinglylongcallername"+strings.Repeat("y", 500)+"@host5.example.net>",
+				"Content-Length: %d",
+			),
+			datagram: true,
+			stream:   true,
+			check: func(msg base.SipMessage) error {
+				if to, err := msg.To(); err != nil || to.DisplayName.String() != longName {
+					return fmt.Errorf("unexpected To %v: %v", to, err)
+				}
+				if hops := viaHops(msg); len(hops) != 31 {
+					return fmt.Errorf("unexpected %d Via hops", len(hops))
+				}
+				return nil
+			},
+		},
+		{
+			// RFC 4475: no whitespace between the display name and '<'.
+			name: "lwsdisp",
+			data: torture("",
+				"OPTIONS sip:user@example.com SIP/2.0",
+				"To: sip:user@example.com",
+				"From: caller<sip:caller@example.com>;tag=323",
+				"Max-Forwards: 70",
+				"Call-ID: lwsdisp.1234abcd@funky.example.com",
+				"CSeq: 60 OPTIONS",
+				"Via: SIP/2.0/UDP funky.example.com;branch=z9hG4bKkdjuw",
+				"l: %d",
+			),
+			datagram: true,
+			stream:   true,
+			check: func(msg base.SipMessage) error {
+				if from, err := msg.From(); err != nil || from.DisplayName.String() != "caller" {
+					return fmt.Errorf("unexpected From %v: %v", from, err)
+				}
+				return nil
+			},
+		},
+		{
+			// RFC 4475: semicolons in the user part of the Request-URI.
+			name: "semiuri",
+			data: torture("",
+				"OPTIONS sip:user;par=u%40example.net@example.com SIP/2.0",
+				"To: sip:j_user@example.com",
+				"From: sip:caller@example.org;tag=33242",
+				"Max-Forwards: 3",
+				"Call-ID: semiuri.0ha0isndaksdj",
+				"CSeq: 8 OPTIONS",
+				"Via: SIP/2.0/UDP 192.0.2.1;branch=z9hG4bKkdjuw",
+				"Content-Length: %d",
+			),
+			datagram: true,
+			stream:   true,
+			check: func(msg base.SipMessage) error {
+				uri, ok := msg.(*base.Request).Recipient.(*base.SipUri)
+				if !ok || uri.User.String() != "user;par=u%40example.net" || uri.Host != "example.com" {
+					return fmt.Errorf("unexpected Request-URI %v", msg.(*base.Request).Recipient)
+				}
+				return nil
+			},
+		},
+		{
+			// RFC 4475: URIs of unknown schemes.
+			name: "unkscm",
+			data: torture("",
+				"OPTIONS nobodyKnowsThisScheme:totallyopaquecontent SIP/2.0",
+				"To: nobodyKnowsThisScheme:totallyopaquecontent",
+				"From: sip:caller@example.net;tag=384",
+				"Max-Forwards: 3",
+				"Call-ID: unkscm.nasdfasser0q239nwsdfasdkl34",
+				"CSeq: 3923423 OPTIONS",
+				"Via: SIP/2.0/TCP host9.example.com;branch=z9hG4bKkdjuw39234",
+				"Content-Length: %d",
+			),
+			datagram: true,
+			stream:   true,
+			check: func(msg base.SipMessage) error {
+				to, err := msg.To()
+				if err != nil {
+					return err
+				}
+				if uri, ok := to.Address.(*base.AbsoluteUri); !ok || uri.Scheme != "nobodyKnowsThisScheme" {
+					return fmt.Errorf("unexpected To %s", to)
+				}
+				return nil
+			},
+		},
+		{
+			// RFC 4475: non-ASCII reason phrase, and empty one below.
+			name: "unreason",
+			data: torture("",
+				"SIP/2.0 200 = 2**3 * 5**2 но сто девяносто девять - простое",
+				"Via: SIP/2.0/UDP 192.0.2.198;branch=z9hG4bK1324923",
+				"Call-ID: unreason.1234ksdfak3j2erwedfsASdf",
+				"CSeq: 35 INVITE",
+				"From: sip:user@example.com;tag=11141343",
+				"To: sip:user@example.edu;tag=2229",
+				"Content-Length: %d",
+			),
+			datagram: true,
+			stream:   true,
+			check: func(msg base.SipMessage) error {
+				if res := msg.(*base.Response); res.StatusCode != 200 || res.Reason != "= 2**3 * 5**2 но сто девяносто девять - простое" {
+					return fmt.Errorf("unexpected status line %d %q", res.StatusCode, res.Reason)
+				}
+				return nil
+			},
+		},
+		{
+			name: "noreason",
+			data: torture("",
+				"SIP/2.0 100 ",
+				"Via: SIP/2.0/UDP 192.0.2.105;branch=z9hG4bK2398ndaoe",
+				"Call-ID: noreason.asndj203insdf99223ndf",
+				"CSeq: 35 INVITE",
+				"From: <sip:user@example.com>;tag=39ansfi3",
+				"To: <sip:user@example.edu>;tag=902jndnke3",
+				"Content-Length: %d",
+			),
+			datagram: true,
+			stream:   true,
+			check: func(msg base.SipMessage) error {
+				if res := msg.(*base.Response); res.StatusCode != 100 || res.Reason != "" {
+					return fmt.Errorf("unexpected status line %d %q", res.StatusCode, res.Reason)
+				}
+				return nil
+			},
+		},
+		{
+			// Several values of the header on one line, with separators quoted.
+			name: "mvalues",
+			data: torture("",
+				"OPTIONS sip:user@example.com SIP/2.0",
+				`Via: SIP/2.0/UDP a.example.com;branch=z9hG4bK1;note="a, b", SIP/2.0/UDP b.example.com;branch=z9hG4bK2`,
+				`Route: <sip:p1.example.com;lr>, "Proxy, the \"second\"" <sip:p2.example.com;lr>`,
+				`Contact: <sip:a@example.com>;q=0.5, "Quoted, \"escaped\"" <sip:b@example.com> ;q=0.1`,
+				"To: sip:user@example.com",
+				"From: sip:caller@example.net;tag=1",
+				"Call-ID: mvalues.1",
+				"CSeq: 1 OPTIONS",
+				"Content-Length: %d",
+			),
+			datagram: true,
+			stream:   true,
+			check: func(msg base.SipMessage) error {
+				if hops := viaHops(msg); len(hops) != 2 || hops[1].Host != "b.example.com" {
+					return fmt.Errorf("unexpected Via hops %v", hops)
+				}
+				if routes := msg.Headers("Route"); len(routes) != 2 {
+					return fmt.Errorf("unexpected Route %v", routes)
+				}
+				if contacts := msg.Contacts(); len(contacts) != 2 || contacts[1].DisplayName.String() != `Quoted, \"escaped\"` {
+					return fmt.Errorf("unexpected Contact %v", contacts)
+				}
+				return nil
+			},
+		},
+		{
+			// RFC 4475: unbalanced quotes, the malformed header is dropped.
+			name: "quotbal",
+			data: torture("",
+				"INVITE sip:user@example.com SIP/2.0",
+				`To: "Mr. J. User <sip:j.user@example.com>`,
+				`From: "Bill" <sip:bill@example.com>;tag=43`,
+				"Call-ID: quotbal.aksdj",
+				"CSeq: 8 INVITE",
+				"Via: SIP/2.0/UDP 192.0.2.59:5050;branch=z9hG4bKkdjuw39234",
+				"Content-Length: %d",
+			),
+			datagram: true,
+			stream:   true,
+			check: func(msg base.SipMessage) error {
+				if hdrs := msg.Headers("To"); len(hdrs) != 0 {
+					return fmt.Errorf("unexpected To %v", hdrs)
+				}
+				return nil
+			},
+		},
+		{
+			// RFC 4475: negative Content-Length.
+			name: "ncl",
+			data: torture("",
+				"INVITE sip:user@example.com SIP/2.0",
+				"Call-ID: ncl.0ha0isndaksdj2193423r542w35",
+				"CSeq: 0 INVITE",
+				"Content-Length: -999",
+			),
+		},
+		{
+			// RFC 4475: Content-Length out of range.
+			name: "scalar02",
+			data: torture("",
+				"REGISTER sip:example.com SIP/2.0",
+				"Call-ID: scalar02.23o0pd9vanlq3wnrlnewofjas9ui32",
+				"CSeq: 36 REGISTER",
+				"Content-Length: 9999999999999999999999",
+			),
+		},
+		{
+			// Content-Length in range but beyond any sane body, the stream must not wait for it.
+			name: "hugecl",
+			data: torture("",
+				"MESSAGE sip:user@example.com SIP/2.0",
+				"Call-ID: hugecl.1",
+				"CSeq: 1 MESSAGE",
+				"Content-Length: 4294967295",
+			),
+			datagram: true,
+		},
+		{
+			// RFC 4475: status code out of range.
+			name: "bigcode",
+			data: torture("",
+				"SIP/2.0 4294967301 better not break the receiver",
+				"Call-ID: bigcode.asdof3uj203asdnf3429uasdhfas3ehjasdfas9i",
+				"CSeq: 353494 INVITE",
+				"Content-Length: %d",
+			),
+		},
+		{
+			// RFC 4475: Request-URI in angle brackets.
+			name: "ltgtruri",
+			data: torture("",
+				"INVITE <sip:user@example.com> SIP/2.0",
+				"Call-ID: ltgtruri.1@192.0.2.5",
+				"CSeq: 1 INVITE",
+				"Content-Length: %d",
+			),
+		},
+		{
+			// RFC 4475: whitespace in the Request-URI.
+			name: "lwsruri",
+			data: torture("",
+				"INVITE sip:user@example.com; lr SIP/2.0",
+				"Call-ID: lwsruri.asdfasdoeoi2323-asdfwrn23-asd834rk423",
+				"CSeq: 2130706432 INVITE",
+				"Content-Length: %d",
+			),
+		},
+		{
+			// RFC 4475: several spaces between the elements of the request line.
+			name: "lwsstart",
+			data: torture("",
+				"INVITE  sip:user@example.com  SIP/2.0",
+				"Call-ID: lwsstart.dfknq234oi243099adsdfnawe3@example.com",
+				"CSeq: 1000000000 INVITE",
+				"Content-Length: %d",
+			),
+		},
+		{
+			// Method is not a token.
+			name: "badmeth",
+			data: torture("",
+				"OPT<IONS> sip:user@example.com SIP/2.0",
+				"Call-ID: badmeth.1",
+				"CSeq: 1 OPT<IONS>",
+				"Content-Length: %d",
+			),
+		},
+	}
+}
+
+// viaHops returns the hops of all Via headers of the message in order.
+func viaHops(msg base.SipMessage) []*base.ViaHop {
+	var hops []*base.ViaHop
+	for _, h := range msg.Headers("Via") {
+		hops = append(hops, *(h.(*base.ViaHeader))...)
+	}
+	return hops
+}
+
+func TestTortureDatagrams(t *testing.T) {
+	for _, tc := range tortureCases() {
+		result := make(chan error, 1)
+		go func(tc tortureCase) {
+			msg, err := ParseMessage([]byte(tc.data), log.StandardLogger())
+			switch {
+			case err != nil && tc.datagram:
+				result <- fmt.Errorf("unexpected error: %s", err)
+			case err != nil && !errors.Is(err, base.ErrMalformedMessage):
+				result <- fmt.Errorf("expected malformed message error, got %s", err)
+			case err == nil && !tc.datagram:
+				result <- fmt.Errorf("unexpected message %s", msg.Short())
+			case err == nil && tc.check != nil:
+				result <- tc.check(msg)
+			default:
+				result <- nil
+			}
+		}(tc)
+
+		select {
+		case err := <-result:
+			if err != nil {
+				t.Errorf("[FAIL] %s: %s", tc.name, err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("[FAIL] %s: datagram not parsed", tc.name)
+		}
+	}
+}
+
+func TestTortureStreams(t *testing.T) {
+	for _, tc := range tortureCases() {
+		output := make(chan base.SipMessage, 1)
+		errs := make(chan error, 1)
+		p := NewParser(output, errs, true, log.StandardLogger())
+		// The stream is written in pieces, as it arrives from the connections.
+		for data := tc.data; len(data) > 0; {
+			n := 100
+			if n > len(data) {
+				n = len(data)
+			}
+			p.Write([]byte(data[:n]))
+			data = data[n:]
+		}
+
+		select {
+		case msg := <-output:
+			if !tc.stream {
+				t.Errorf("[FAIL] %s: unexpected message %s", tc.name, msg.Short())
+			} else if tc.check != nil {
+				if err := tc.check(msg); err != nil {
+					t.Errorf("[FAIL] %s: %s", tc.name, err)
+				}
+			}
+		case err := <-errs:
+			if tc.stream {
+				t.Errorf("[FAIL] %s: unexpected error: %s", tc.name, err)
+			} else if !errors.Is(err, base.ErrMalformedMessage) {
+				t.Errorf("[FAIL] %s: expected malformed message error, got %s", tc.name, err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("[FAIL] %s: stream not parsed", tc.name)
+		}
+		p.Stop()
+	}
+}
@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/transaction"
+)
+
+// responseContext forwards one request to its target set and the responses back - RFC 3261 16.7.
+type responseContext struct {
+	proxy    *Proxy
+	tx       *transaction.ServerTransaction
+	req      *base.Request
+	targets  []Target
+	branches []*branch
+	// best final response received so far.
+	best *base.Response
+	// final is set once a final response was forwarded, later only 2xx responses to INVITE are forwarded.
+	final     bool
+	cancelled bool
+	lock      sync.Mutex
+}
+
+type branch struct {
+	tx    *transaction.ClientTransaction
+	final bool
+}
+
+type branchResponse struct {
+	branch   *branch
+	response *base.Response
+}
+
+func newResponseContext(p *Proxy, tx *transaction.ServerTransaction, req *base.Request, targets []Target) *responseContext {
+	return &responseContext{proxy: p, tx: tx, req: req, targets: targets}
+}
+
+// run forwards the request by the fork mode and answers the server transaction with the best response.
+func (ctx *responseContext) run() {
+	if ctx.proxy.cfg.Mode == ForkSerial {
+		for _, target := range ctx.targets {
+			if ctx.isCancelled() || ctx.fork([]Target{target}) {
+				break
+			}
+		}
+	} else {
+		ctx.fork(ctx.targets)
+	}
+
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	if ctx.final {
+		return
+	}
+	switch {
+	case ctx.best == nil && ctx.cancelled:
+		ctx.send(ctx.tx.NewResponse(487, "Request Terminated"))
+	case ctx.best == nil || ctx.best.StatusCode == 503:
+		// No target was reached, or 503 of the next hop, which doesn't mean the proxy is unavailable
+		// - RFC 3261 16.7 step 6.
+		ctx.send(ctx.tx.NewResponse(500, "Server Internal Error"))
+	default:
+		ctx.forward(ctx.best)
+	}
+}
+
+// fork forwards the request to the targets in parallel and processes the responses until all of them are final.
+// It reports whether the request was decided by 2xx response or by 6xx response to INVITE.
+func (ctx *responseContext) fork(targets []Target) bool {
+	responses := make(chan branchResponse, len(targets))
+	wg := new(sync.WaitGroup)
+	for _, target := range targets {
+		req, addr, err := ctx.proxy.request(ctx.req, target, base.GenerateLoopBranch(ctx.req))
+		if err != nil {
+			ctx.req.Log().Warnf("failed to forward request %s: %s", ctx.req.Short(), err)
+			continue
+		}
+
+		ctx.lock.Lock()
+		if ctx.cancelled {
+			ctx.lock.Unlock()
+			break
+		}
+		b := &branch{tx: ctx.proxy.tm.Send(req, addr)}
+		ctx.branches = append(ctx.branches, b)
+		ctx.lock.Unlock()
+
+		wg.Add(1)
+		go ctx.watch(b, responses, wg)
+	}
+	go func() {
+		wg.Wait()
+		close(responses)
+	}()
+
+	decided := false
+	for br := range responses {
+		decided = ctx.receive(br.branch, br.response) || decided
+	}
+	return decided
+}
+
+// watch passes the responses of the branch until the final one, failures are treated as 408 Request Timeout
+// on timeout and 503 Service Unavailable on transport error - RFC 3261 16.7.
+func (ctx *responseContext) watch(b *branch, responses chan<- branchResponse, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		var res *base.Response
+		select {
+		case res = <-b.tx.Responses():
+		case err := <-b.tx.Errors():
+			if errors.Is(err, base.ErrTimeout) {
+				res = base.NewResponseFromRequest(b.tx.Origin(), 408, "Request Timeout", "")
+			} else {
+				res = base.NewResponseFromRequest(b.tx.Origin(), 503, "Service Unavailable", "")
+			}
+		}
+		responses <- branchResponse{b, res}
+		if !res.IsProvisional() {
+			return
+		}
+	}
+}
+
+// receive processes the response of the branch, reports whether it decided the request.
+func (ctx *responseContext) receive(b *branch, res *base.Response) bool {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	if res.IsProvisional() {
+		// 100 Trying is hop-by-hop, the proxy sent its own one.
+		if res.StatusCode != 100 && !ctx.final {
+			ctx.forward(res)
+		}
+		return false
+	}
+
+	b.final = true
+	if res.IsSuccess() {
+		ctx.forward(res)
+		ctx.cancelPending()
+		return true
+	}
+	if ctx.best == nil || transaction.BetterResponse(res, ctx.best) {
+		ctx.best = res
+	}
+	if res.StatusCode >= 600 && ctx.req.IsInvite() {
+		ctx.cancelPending()
+		return true
+	}
+	return false
+}
+
+// forward removes the Via hop of the proxy from the response of a branch and sends it up the Via stack
+// - RFC 3261 16.7 step 9.
+func (ctx *responseContext) forward(res *base.Response) {
+	res = res.Copy()
+	if via, err := res.Via(); err == nil {
+		via.PopHop()
+		if len(*via) == 0 {
+			res.RemoveHeader(via)
+		}
+	}
+	ctx.send(res)
+}
+
+// send answers the server transaction. Once the final response was sent, 2xx responses to INVITE
+// of the other branches are sent directly, the caller acknowledges all of them.
+func (ctx *responseContext) send(res *base.Response) {
+	if !ctx.final {
+		ctx.final = !res.IsProvisional()
+		ctx.tx.Respond(res)
+		return
+	}
+	if res.IsSuccess() && ctx.req.IsInvite() {
+		if err := ctx.tx.Transport().Send(ctx.tx.Destination(), res); err != nil {
+			res.Log().Warnf("failed to forward %s: %s", res.Short(), err)
+		}
+	}
+}
+
+// cancel cancels the branches without final response and stops forwarding to the next targets.
+func (ctx *responseContext) cancel() {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	ctx.cancelled = true
+	ctx.cancelPending()
+}
+
+func (ctx *responseContext) cancelPending() {
+	if !ctx.req.IsInvite() {
+		return
+	}
+	for _, b := range ctx.branches {
+		if !b.final {
+			b.tx.Cancel()
+		}
+	}
+}
+
+func (ctx *responseContext) isCancelled() bool {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	return ctx.cancelled
+}
@@ -0,0 +1,313 @@
+// Package proxy implements the core of a stateful proxy on top of transaction.Manager - RFC 3261 16.
+// A Proxy validates received requests, forwards them to the target set by client transactions, in parallel or serially,
+// and forwards the responses back up the Via stack, selecting the best final response.
+package proxy
+
+import (
+	"strings"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/transaction"
+)
+
+// Max-Forwards of the forwarded requests without it - RFC 3261 16.6 item 3.
+const DefaultMaxForwards = 70
+
+// ForkMode defines how the request is forwarded to the target set of several targets.
+type ForkMode int
+
+const (
+	// ForkParallel forwards the request to all the targets at once.
+	ForkParallel ForkMode = iota
+	// ForkSerial forwards the request to the next target once the previous one answered with final non-2xx response,
+	// until 2xx or 6xx response is received.
+	ForkSerial
+)
+
+func (mode ForkMode) String() string {
+	switch mode {
+	case ForkParallel:
+		return "Parallel"
+	case ForkSerial:
+		return "Serial"
+	default:
+		return "Unknown"
+	}
+}
+
+// Target is a destination of the forwarded request.
+type Target struct {
+	// Uri is the Request-URI of the forwarded request, nil keeps the Request-URI of the received request.
+	Uri base.Uri
+	// Addr is host:port the request is sent to, empty means the next hop is taken from the top Route header
+	// or the Request-URI.
+	Addr string
+}
+
+// Locator determines the target set of the request, e.g. by the location service of a registrar - RFC 3261 16.5.
+// Empty target set is answered with 480 Temporarily Unavailable.
+type Locator func(req *base.Request) []Target
+
+// Config of the Proxy.
+type Config struct {
+	// Transport, Host and Port of the Via hop and the Record-Route header of the proxy.
+	Transport string
+	Host      string
+	Port      uint16
+	// RecordRoute keeps the proxy on the path of the dialogs by Record-Route header in the requests outside a dialog.
+	RecordRoute bool
+	// Mode of forwarding to several targets.
+	Mode ForkMode
+	// Locator of the targets of the requests without Route headers left to follow.
+	// Requests retargeted by transaction.Router are forwarded to its decision.
+	// nil forwards the requests to their Request-URI.
+	Locator Locator
+}
+
+// Proxy is the stateful proxy core. Serve it on the manager or register Handle as the handler of the methods
+// to forward, and OwnHop as the loop detection of the manager.
+type Proxy struct {
+	tm  *transaction.Manager
+	cfg Config
+}
+
+// NewProxy creates the proxy forwarding requests received by the manager.
+func NewProxy(tm *transaction.Manager, cfg Config) *Proxy {
+	if cfg.Transport == "" {
+		cfg.Transport = "UDP"
+	}
+	return &Proxy{
+		tm:  tm,
+		cfg: cfg,
+	}
+}
+
+// Serve makes the proxy handle all the new requests received by the manager.
+func (p *Proxy) Serve() {
+	p.tm.OnRequest(base.CANCEL, p.Handle)
+	p.tm.SetDefaultHandler(p.Handle)
+}
+
+// OwnHop reports whether the Via hop was added by the proxy, suitable as loop detection of the manager.
+func (p *Proxy) OwnHop(hop *base.ViaHop) bool {
+	return p.isOwn(hop.Host, base.HopPort(hop))
+}
+
+// Handle processes the request of the server transaction, suitable as transaction.RequestHandler.
+// CANCEL requests matching the forwarded INVITE requests are answered by the manager, which signals them
+// on the INVITE transactions, see transaction.ServerTransaction.Cancelled; ACK requests on 2xx are forwarded statelessly.
+// It blocks until the request is answered.
+func (p *Proxy) Handle(tx *transaction.ServerTransaction) {
+	req := tx.Target().Copy()
+	switch req.Method {
+	case base.ACK:
+		p.forwardAck(tx, req)
+		return
+	case base.CANCEL:
+		p.cancel(tx, req)
+		return
+	}
+
+	if status, reason, hdrs := p.validate(req); status != 0 {
+		req.Log().Infof("request %s rejected by proxy: %d %s", req.Short(), status, reason)
+		tx.RespondWithStatus(status, reason, hdrs...)
+		return
+	}
+
+	p.preprocessRoute(req)
+	targets := p.targets(tx, req)
+	if len(targets) == 0 {
+		req.Log().Infof("no targets of request %s", req.Short())
+		tx.RespondWithStatus(480, "Temporarily Unavailable")
+		return
+	}
+
+	ctx := newResponseContext(p, tx, req, targets)
+	if req.IsInvite() {
+		// CANCEL is answered by the manager, the forwarded INVITE is cancelled once it's signalled.
+		forwarded := make(chan struct{})
+		defer close(forwarded)
+		go func() {
+			select {
+			case <-tx.Cancelled():
+				ctx.cancel()
+			case <-forwarded:
+			}
+		}()
+	}
+	ctx.run()
+}
+
+// validate checks the request before forwarding - RFC 3261 16.3.
+// Non-zero status is the status of the response rejecting the request.
+func (p *Proxy) validate(req *base.Request) (status uint16, reason string, hdrs []base.SipHeader) {
+	if maxForwards, ok := maxForwards(req); ok && maxForwards == 0 {
+		return 483, "Too Many Hops", nil
+	}
+
+	// The proxy supports no extensions - RFC 3261 16.3 item 5.
+	options := make([]string, 0)
+	for _, h := range req.Headers("Proxy-Require") {
+		options = append(options, headerValue(h))
+	}
+	if len(options) > 0 {
+		return 420, "Bad Extension", []base.SipHeader{
+			&base.GenericHeader{HeaderName: "Unsupported", Contents: strings.Join(options, ", ")},
+		}
+	}
+	return 0, "", nil
+}
+
+// preprocessRoute restores Request-URI replaced by a strict router and removes the Route of the proxy - RFC 3261 16.4.
+func (p *Proxy) preprocessRoute(req *base.Request) {
+	if p.isOwnUri(req.Recipient) {
+		if routes := req.Routes(); len(routes) > 0 {
+			last := routes[len(routes)-1]
+			req.Recipient = last.Address.Copy()
+			req.RemoveHeader(last)
+		}
+	}
+	if routes := req.Routes(); len(routes) > 0 && p.isOwnUri(routes[0].Address) {
+		base.PopRoute(req)
+	}
+}
+
+// targets determines the target set of the request - RFC 3261 16.5.
+func (p *Proxy) targets(tx *transaction.ServerTransaction, req *base.Request) []Target {
+	// The request follows its route set unchanged.
+	if len(req.Routes()) > 0 {
+		return []Target{{}}
+	}
+	if route := tx.Route(); !route.IsLocal() {
+		return []Target{{Addr: route.Forward}}
+	}
+	if p.cfg.Locator != nil {
+		return p.cfg.Locator(req)
+	}
+	return []Target{{}}
+}
+
+// request builds the request forwarded to the target - RFC 3261 16.6.
+// received is the request after Route preprocessing, the branch of the Via hop of the proxy carries its loop hash.
+func (p *Proxy) request(received *base.Request, target Target, branch string) (*base.Request, string, error) {
+	req := received.Copy()
+	if target.Uri != nil {
+		req.Recipient = target.Uri.Copy()
+	}
+
+	if value, ok := maxForwards(req); ok {
+		setMaxForwards(req, value-1)
+	} else {
+		req.AddHeader(base.MaxForwards(DefaultMaxForwards))
+	}
+
+	if p.cfg.RecordRoute && !req.IsAck() && !base.IsInDialog(req) {
+		base.PushRecordRoute(req, base.NewRecordRouteHeader(p.uri()))
+	}
+
+	hop := base.NewViaHop(p.cfg.Transport, p.cfg.Host, p.cfg.Port, branch)
+	if via, err := req.Via(); err == nil {
+		via.PushHop(hop)
+	} else {
+		req.AddFrontHeader(&base.ViaHeader{hop})
+	}
+
+	addr := target.Addr
+	if addr == "" {
+		var err error
+		if addr, err = base.NextHop(req); err != nil {
+			return nil, "", err
+		}
+	}
+	return req, addr, nil
+}
+
+// forwardAck forwards ACK on 2xx response statelessly, it's not a transaction of its own - RFC 3261 16.11.
+func (p *Proxy) forwardAck(tx *transaction.ServerTransaction, ack *base.Request) {
+	if status, _, _ := p.validate(ack); status != 0 {
+		ack.Log().Infof("ACK %s dropped by proxy", ack.Short())
+		return
+	}
+	p.preprocessRoute(ack)
+	targets := p.targets(tx, ack)
+	if len(targets) == 0 {
+		ack.Log().Infof("no targets of ACK %s, dropped", ack.Short())
+		return
+	}
+
+	fwd, addr, err := p.request(ack, targets[0], base.GenerateLoopBranch(ack))
+	if err != nil {
+		ack.Log().Warnf("failed to forward ACK %s: %s", ack.Short(), err)
+		return
+	}
+	if err := tx.Transport().Send(addr, fwd); err != nil {
+		ack.Log().Warnf("failed to forward ACK %s: %s", ack.Short(), err)
+	}
+}
+
+// cancel answers CANCEL passed by the manager, i.e. matching no INVITE transaction - RFC 3261 16.10.
+func (p *Proxy) cancel(tx *transaction.ServerTransaction, cancel *base.Request) {
+	cancel.Log().Infof("no INVITE matching %s", cancel.Short())
+	tx.RespondWithStatus(481, "Call/Transaction Does Not Exist")
+}
+
+// uri returns SIP URI of the proxy for Record-Route header, it is a loose router.
+func (p *Proxy) uri() *base.SipUri {
+	uri := &base.SipUri{Host: p.cfg.Host, UriParams: base.NewParams(), Headers: base.NewParams()}
+	if p.cfg.Port != 0 {
+		port := p.cfg.Port
+		uri.Port = &port
+	}
+	uri.UriParams.Add("lr", base.NoString{})
+	if !strings.EqualFold(p.cfg.Transport, "UDP") {
+		uri.UriParams.Add("transport", base.String{S: strings.ToLower(p.cfg.Transport)})
+	}
+	return uri
+}
+
+func (p *Proxy) isOwnUri(uri base.Uri) bool {
+	sipUri, ok := uri.(*base.SipUri)
+	return ok && p.isOwn(sipUri.Host, base.UriPort(sipUri))
+}
+
+func (p *Proxy) isOwn(host string, port uint16) bool {
+	if !strings.EqualFold(host, p.cfg.Host) {
+		return false
+	}
+	own := p.cfg.Port
+	if own == 0 {
+		own = base.DefaultPort(p.cfg.Transport)
+	}
+	return port == own
+}
+
+func maxForwards(req *base.Request) (uint32, bool) {
+	hdrs := req.Headers("Max-Forwards")
+	if len(hdrs) == 0 {
+		return 0, false
+	}
+	switch h := hdrs[0].(type) {
+	case *base.MaxForwards:
+		return uint32(*h), true
+	case base.MaxForwards:
+		return uint32(h), true
+	}
+	return 0, false
+}
+
+func setMaxForwards(req *base.Request, value uint32) {
+	if h, ok := req.Headers("Max-Forwards")[0].(*base.MaxForwards); ok {
+		*h = base.MaxForwards(value)
+		return
+	}
+	req.SetHeader(base.MaxForwards(value), true)
+}
+
+// headerValue returns the value of the header without its name.
+func headerValue(h base.SipHeader) string {
+	text := h.String()
+	if idx := strings.Index(text, ":"); idx >= 0 {
+		text = text[idx+1:]
+	}
+	return strings.TrimSpace(text)
+}
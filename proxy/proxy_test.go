@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+	"github.com/ghettovoice/gossip/transaction"
+	"github.com/ghettovoice/gossip/transport"
+)
+
+const (
+	c_UAC   = "uac.test:5060"
+	c_PROXY = "proxy.test:5060"
+	c_UAS1  = "uas1.test:5060"
+	c_UAS2  = "uas2.test:5060"
+)
+
+func newManager(t *testing.T, addr string) *transaction.Manager {
+	tp, err := transport.NewManager("memory")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create transport: %s", err)
+	}
+	tm, err := transaction.NewManager(tp, addr)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create transaction manager: %s", err)
+	}
+	return tm
+}
+
+// proxyTest runs UAC, the proxy and two UAS on memory transports.
+type proxyTest struct {
+	t     *testing.T
+	uac   *transaction.Manager
+	proxy *Proxy
+	uas1  *transaction.Manager
+	uas2  *transaction.Manager
+}
+
+func newProxyTest(t *testing.T, cfg Config) *proxyTest {
+	cfg.Host = "proxy.test"
+	cfg.Port = 5060
+	test := &proxyTest{
+		t:    t,
+		uac:  newManager(t, c_UAC),
+		uas1: newManager(t, c_UAS1),
+		uas2: newManager(t, c_UAS2),
+	}
+	tm := newManager(t, c_PROXY)
+	test.proxy = NewProxy(tm, cfg)
+	test.proxy.Serve()
+	return test
+}
+
+func (test *proxyTest) stop() {
+	test.uac.Stop()
+	test.proxy.tm.Stop()
+	test.uas1.Stop()
+	test.uas2.Stop()
+}
+
+func (test *proxyTest) request(method base.Method, recipient string, extra ...string) *base.Request {
+	lines := []string{
+		fmt.Sprintf("%s %s SIP/2.0", method, recipient),
+		"Via: SIP/2.0/UDP " + c_UAC + ";branch=" + base.GenerateBranch(),
+		"From: <sip:alice@uac.test>;tag=1928301774",
+		"To: <sip:bob@example.com>",
+		"Call-Id: " + base.GenerateBranch(),
+		fmt.Sprintf("CSeq: 1 %s", method),
+		"Max-Forwards: 70",
+	}
+	lines = append(lines, extra...)
+	raw := ""
+	for _, line := range lines {
+		raw += line + "\r\n"
+	}
+	msg, err := parser.ParseMessage([]byte(raw+"Content-Length: 0\r\n\r\n"), log.StandardLogger())
+	if err != nil {
+		test.t.Fatalf("[FAIL] failed to parse request: %s", err)
+	}
+	return msg.(*base.Request)
+}
+
+func (test *proxyTest) serverTx(tm *transaction.Manager) *transaction.ServerTransaction {
+	select {
+	case tx := <-tm.Requests():
+		return tx
+	case <-time.After(time.Second):
+		test.t.Fatalf("[FAIL] request was not received")
+	}
+	return nil
+}
+
+func (test *proxyTest) response(tx *transaction.ClientTransaction) *base.Response {
+	select {
+	case res := <-tx.Responses():
+		return res
+	case err := <-tx.Errors():
+		test.t.Fatalf("[FAIL] client transaction failed: %s", err)
+	case <-time.After(time.Second):
+		test.t.Fatalf("[FAIL] response was not received")
+	}
+	return nil
+}
+
+func (test *proxyTest) finalResponse(tx *transaction.ClientTransaction) *base.Response {
+	for {
+		if res := test.response(tx); !res.IsProvisional() {
+			return res
+		}
+	}
+}
+
+func TestProxyForward(t *testing.T) {
+	test := newProxyTest(t, Config{RecordRoute: true})
+	defer test.stop()
+
+	invite := test.request(base.INVITE, "sip:bob@"+c_UAS1)
+	tx := test.uac.Send(invite, c_PROXY)
+
+	stx := test.serverTx(test.uas1)
+	fwd := stx.Origin()
+	if value, ok := maxForwards(fwd); !ok || value != 69 {
+		t.Errorf("[FAIL] expected Max-Forwards 69, got %d", value)
+	}
+	if via, err := fwd.Via(); err != nil || len(*via) != 2 || (*via)[0].Host != "proxy.test" {
+		t.Errorf("[FAIL] expected Via of the proxy on top, got %v", fwd.Headers("Via"))
+	}
+	if !test.proxy.OwnHop((*mustVia(t, fwd))[0]) {
+		t.Errorf("[FAIL] expected own Via hop recognized")
+	}
+	if rrs := fwd.RecordRoutes(); len(rrs) != 1 || rrs[0].String() != "Record-Route: <sip:proxy.test:5060;lr>" {
+		t.Errorf("[FAIL] unexpected Record-Route headers %v", rrs)
+	}
+
+	stx.Ringing()
+	if res := test.response(tx); res.StatusCode != 100 {
+		t.Errorf("[FAIL] expected 100 Trying of the proxy, got %s", res.Short())
+	}
+	if res := test.response(tx); res.StatusCode != 180 {
+		t.Errorf("[FAIL] expected 180 Ringing, got %s", res.Short())
+	}
+	stx.Ok()
+	res := test.finalResponse(tx)
+	if res.StatusCode != 200 {
+		t.Fatalf("[FAIL] expected 200 OK, got %s", res.Short())
+	}
+	if via := mustVia(t, res); len(*via) != 1 || (*via)[0].Host != "uac.test" {
+		t.Errorf("[FAIL] expected the Via hop of the proxy removed, got %s", via)
+	}
+}
+
+func TestProxyParallelFork(t *testing.T) {
+	targets := []Target{{Addr: c_UAS1}, {Addr: c_UAS2}}
+	test := newProxyTest(t, Config{Mode: ForkParallel, Locator: func(req *base.Request) []Target { return targets }})
+	defer test.stop()
+
+	tx := test.uac.Send(test.request(base.OPTIONS, "sip:bob@example.com"), c_PROXY)
+	stx1 := test.serverTx(test.uas1)
+	stx2 := test.serverTx(test.uas2)
+	stx1.RespondWithStatus(486, "Busy Here")
+	stx2.RespondWithStatus(603, "Decline")
+
+	if res := test.finalResponse(tx); res.StatusCode != 603 {
+		t.Errorf("[FAIL] expected the best response 603 Decline, got %s", res.Short())
+	}
+}
+
+func TestProxySerialFork(t *testing.T) {
+	targets := []Target{{Addr: c_UAS1}, {Addr: c_UAS2}}
+	test := newProxyTest(t, Config{Mode: ForkSerial, Locator: func(req *base.Request) []Target { return targets }})
+	defer test.stop()
+
+	tx := test.uac.Send(test.request(base.SUBSCRIBE, "sip:bob@example.com"), c_PROXY)
+	test.serverTx(test.uas1).NotFound()
+	test.serverTx(test.uas2).Ok()
+
+	if res := test.finalResponse(tx); res.StatusCode != 200 {
+		t.Errorf("[FAIL] expected 200 OK of the second target, got %s", res.Short())
+	}
+}
+
+func TestProxyValidation(t *testing.T) {
+	test := newProxyTest(t, Config{})
+	defer test.stop()
+
+	req := test.request(base.OPTIONS, "sip:bob@"+c_UAS1)
+	req.SetHeader(base.MaxForwards(0), true)
+	tx := test.uac.Send(req, c_PROXY)
+	if res := test.finalResponse(tx); res.StatusCode != 483 {
+		t.Errorf("[FAIL] expected 483 Too Many Hops, got %s", res.Short())
+	}
+
+	tx = test.uac.Send(test.request(base.OPTIONS, "sip:bob@"+c_UAS1, "Proxy-Require: foo"), c_PROXY)
+	res := test.finalResponse(tx)
+	if res.StatusCode != 420 {
+		t.Fatalf("[FAIL] expected 420 Bad Extension, got %s", res.Short())
+	}
+	if hdrs := res.Headers("Unsupported"); len(hdrs) != 1 || headerValue(hdrs[0]) != "foo" {
+		t.Errorf("[FAIL] expected Unsupported: foo, got %v", hdrs)
+	}
+}
+
+func TestProxyRoute(t *testing.T) {
+	test := newProxyTest(t, Config{})
+	defer test.stop()
+
+	tx := test.uac.Send(test.request(base.OPTIONS, "sip:bob@example.com",
+		"Route: <sip:proxy.test;lr>, <sip:"+c_UAS2+";lr>"), c_PROXY)
+	stx := test.serverTx(test.uas2)
+	if routes := stx.Origin().Routes(); len(routes) != 1 || routes[0].String() != "Route: <sip:"+c_UAS2+";lr>" {
+		t.Errorf("[FAIL] expected the Route of the proxy removed, got %v", routes)
+	}
+	if stx.Origin().Recipient.String() != "sip:bob@example.com" {
+		t.Errorf("[FAIL] expected Request-URI kept, got %s", stx.Origin().Recipient)
+	}
+	stx.Ok()
+	if res := test.finalResponse(tx); res.StatusCode != 200 {
+		t.Errorf("[FAIL] expected 200 OK, got %s", res.Short())
+	}
+}
+
+func TestProxyCancel(t *testing.T) {
+	test := newProxyTest(t, Config{})
+	defer test.stop()
+
+	tx := test.uac.Send(test.request(base.INVITE, "sip:bob@"+c_UAS1), c_PROXY)
+	stx := test.serverTx(test.uas1)
+	stx.Ringing()
+	for res := test.response(tx); res.StatusCode != 180; res = test.response(tx) {
+	}
+
+	tx.Cancel()
+	// CANCEL forwarded to the callee is answered by its manager.
+	select {
+	case <-stx.Cancelled():
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] expected INVITE cancelled")
+	}
+	stx.RespondWithStatus(487, "Request Terminated")
+
+	if res := test.finalResponse(tx); res.StatusCode != 487 {
+		t.Errorf("[FAIL] expected 487 Request Terminated, got %s", res.Short())
+	}
+}
+
+func mustVia(t *testing.T, msg base.SipMessage) *base.ViaHeader {
+	via, err := msg.Via()
+	if err != nil {
+		t.Fatalf("[FAIL] no Via in %s: %s", msg.Short(), err)
+	}
+	return via
+}
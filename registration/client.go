@@ -0,0 +1,469 @@
+// Package registration implements UAC registration with a registrar - RFC 3261 10.2.
+package registration
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gossip/auth"
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/timing"
+	"github.com/ghettovoice/gossip/transaction"
+	"github.com/ghettovoice/gossip/transport"
+	"github.com/ghettovoice/gossip/utils"
+)
+
+const (
+	c_DEFAULT_EXPIRES = time.Hour
+	// Delay before the next attempt after a failed registration - RFC 5626 4.5 base time.
+	c_RETRY_INTERVAL = 30 * time.Second
+	// Lower bound of the refresh delay, so that tiny granted intervals don't flood the registrar.
+	c_MIN_REFRESH_INTERVAL = 5 * time.Second
+)
+
+// State of the registration.
+type State int
+
+const (
+	StateUnregistered State = iota
+	StateRegistering
+	StateRegistered
+	StateFailed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateUnregistered:
+		return "Unregistered"
+	case StateRegistering:
+		return "Registering"
+	case StateRegistered:
+		return "Registered"
+	case StateFailed:
+		return "Failed"
+	default:
+		return "Unknown"
+	}
+}
+
+// Config describes the binding maintained by the Client.
+type Config struct {
+	// Address-of-record to register.
+	AOR *base.SipUri
+	// Contact address bound to the AOR. Its host and port are used as the Via sent-by.
+	Contact *base.SipUri
+	// Address as host:port the REGISTER requests are sent to: the registrar itself or an outbound proxy.
+	Registrar string
+	// Transport name used in Via, UDP by default.
+	Transport string
+	// Requested registration interval, c_DEFAULT_EXPIRES by default.
+	Expires time.Duration
+	// Digest credentials used when the registrar challenges the request.
+	// Username defaults to the user part of the AOR.
+	Username string
+	Password string
+	// Initial CSeq number of REGISTER requests, 0 picks a random one - RFC 3261 8.1.1.5.
+	CSeq uint32
+	// PublicAddr returns host:port advertised instead of the Contact ones in Contact and Via,
+	// e.g. PublicAddr of the transport manager implementing transport.PublicAddrDiscoverer.
+	// Empty result keeps the Contact as it is. Optional.
+	PublicAddr func() string
+}
+
+// Client keeps a single binding registered and refreshes it before expiry.
+// Broken flows to the registrar trigger immediate re-registration - RFC 5626 4.4.
+type Client struct {
+	tm     *transaction.Manager
+	cfg    Config
+	callId base.CallId
+	tag    string
+	cseq   *base.CSeqSequence
+
+	state     State
+	expires   time.Duration // Interval granted by the registrar.
+	lastErr   error
+	stateLock sync.RWMutex
+
+	refresh    timing.Timer
+	flowFailed chan transport.FlowFailure
+	stop       chan bool
+	stopOnce   sync.Once
+	started    bool
+	done       chan bool // Closed when the refresh loop exits.
+	log        log.Logger
+}
+
+func NewClient(tm *transaction.Manager, cfg Config) *Client {
+	if cfg.Transport == "" {
+		cfg.Transport = "UDP"
+	}
+	if cfg.Expires == 0 {
+		cfg.Expires = c_DEFAULT_EXPIRES
+	}
+	if cfg.Username == "" {
+		if user, ok := cfg.AOR.User.(base.String); ok {
+			cfg.Username = user.S
+		}
+	}
+
+	return &Client{
+		tm:         tm,
+		cfg:        cfg,
+		callId:     base.CallId(utils.RandStr(16)),
+		cseq:       base.NewCSeqSequence(cfg.CSeq),
+		tag:        base.GenerateTag(),
+		flowFailed: make(chan transport.FlowFailure, 1),
+		stop:       make(chan bool),
+		done:       make(chan bool),
+		log:        log.WithField("aor", cfg.AOR.String()),
+	}
+}
+
+func (c *Client) Log() log.Logger {
+	return c.log
+}
+
+// Start registers the binding and keeps it refreshed until Stop is called.
+// Flow failures reported by the transaction manager are watched as well.
+func (c *Client) Start() {
+	c.start(true)
+}
+
+// start runs the client. Clients owned by the Manager don't watch flows themselves,
+// the Manager passes flow failures to them.
+func (c *Client) start(watchFlows bool) {
+	c.stateLock.Lock()
+	c.started = true
+	c.stateLock.Unlock()
+	go c.loop()
+
+	if !watchFlows {
+		return
+	}
+	if flows := c.tm.FlowFailures(); flows != nil {
+		go c.watchFlows(flows)
+	}
+}
+
+// Status returns the snapshot of the registration status.
+func (c *Client) Status() Status {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	return Status{
+		AOR:       c.cfg.AOR.String(),
+		Registrar: c.cfg.Registrar,
+		State:     c.state,
+		Expires:   c.expires,
+		LastError: c.lastErr,
+	}
+}
+
+// Stop refreshing the binding. The binding is left to expire at the registrar.
+func (c *Client) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// Unregister stops refreshing the binding and removes it at the registrar - RFC 3261 10.2.2.
+// Blocks until the registrar responds.
+func (c *Client) Unregister() error {
+	c.Stop()
+	c.stateLock.RLock()
+	started := c.started
+	c.stateLock.RUnlock()
+	if started {
+		// The refresh in flight must not race with the removal.
+		<-c.done
+	}
+
+	if _, err := c.transact(0); err != nil {
+		c.Log().Warnf("unregistration at %s failed: %s", c.cfg.Registrar, err)
+		c.setState(StateFailed, 0, err)
+		return err
+	}
+	c.Log().Infof("unregistered at %s", c.cfg.Registrar)
+	return nil
+}
+
+// State returns the current registration state.
+func (c *Client) State() State {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	return c.state
+}
+
+// Expires returns the registration interval granted by the registrar.
+func (c *Client) Expires() time.Duration {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	return c.expires
+}
+
+// LastError returns the reason of the last failed registration attempt.
+func (c *Client) LastError() error {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	return c.lastErr
+}
+
+// FlowFailed notifies the client that a flow broke.
+// If the flow leads to the registrar, the client re-registers immediately over a new flow.
+func (c *Client) FlowFailed(failure transport.FlowFailure) {
+	if failure.Addr != c.cfg.Registrar {
+		return
+	}
+
+	select {
+	case c.flowFailed <- failure:
+	default:
+		// Re-registration is already pending.
+	}
+}
+
+func (c *Client) watchFlows(flows <-chan transport.FlowFailure) {
+	for {
+		select {
+		case failure, ok := <-flows:
+			if !ok {
+				return
+			}
+			c.FlowFailed(failure)
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *Client) loop() {
+	defer close(c.done)
+	c.refresh = timing.NewTimer(c.register())
+	for {
+		select {
+		case <-c.refresh.C():
+			c.refresh.Reset(c.register())
+		case failure := <-c.flowFailed:
+			c.Log().Infof("flow to registrar %s failed: %s; re-registering", failure.Addr, failure.Err)
+			c.refresh.Stop()
+			c.refresh.Reset(c.register())
+		case <-c.stop:
+			c.refresh.Stop()
+			c.setState(StateUnregistered, 0, nil)
+			return
+		}
+	}
+}
+
+// register sends REGISTER request, waits for the final response and returns delay until the next attempt.
+func (c *Client) register() time.Duration {
+	c.setState(StateRegistering, c.Expires(), nil)
+
+	res, err := c.transact(c.cfg.Expires)
+	if err != nil {
+		return c.fail(err)
+	}
+
+	expires := c.grantedExpires(res)
+	if expires <= 0 {
+		return c.fail(fmt.Errorf("registrar granted no binding interval in %s", res.Short()))
+	}
+	c.Log().Infof("registered at %s for %s", c.cfg.Registrar, expires)
+	c.setState(StateRegistered, expires, nil)
+	// Refresh in advance so the binding doesn't lapse while the refresh is in flight.
+	refresh := jitter(expires / 2)
+	if refresh < c_MIN_REFRESH_INTERVAL {
+		refresh = c_MIN_REFRESH_INTERVAL
+	}
+	return refresh
+}
+
+// transact sends REGISTER requesting the interval and returns the final 2xx response.
+// A challenge from the registrar is answered once per attempt.
+func (c *Client) transact(expires time.Duration) (*base.Response, error) {
+	var authorization []base.SipHeader
+	for {
+		req := c.request(expires, authorization)
+		res, err := c.send(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if auth.IsChallenge(res) && authorization == nil && c.cfg.Password != "" {
+			authorization, err = auth.Authorize(req, res, auth.Static(auth.Credentials{
+				Username: c.cfg.Username,
+				Password: c.cfg.Password,
+			}))
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !res.IsSuccess() {
+			return nil, fmt.Errorf("registrar responded with %s", res.Short())
+		}
+		return res, nil
+	}
+}
+
+// send starts client transaction for the request and waits for the final response.
+func (c *Client) send(req *base.Request) (*base.Response, error) {
+	tx := c.tm.Send(req, c.cfg.Registrar)
+	for {
+		select {
+		case res, ok := <-tx.Responses():
+			if !ok {
+				return nil, fmt.Errorf("transaction terminated without final response")
+			}
+			if !res.IsProvisional() {
+				return res, nil
+			}
+		case err := <-tx.Errors():
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) fail(err error) time.Duration {
+	c.Log().Warnf("registration at %s failed: %s", c.cfg.Registrar, err)
+	c.setState(StateFailed, 0, err)
+	return jitter(c_RETRY_INTERVAL)
+}
+
+// jitter spreads the delay randomly over [d/2, d], so that many clients started at once
+// don't refresh simultaneously - RFC 5626 4.5.
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (c *Client) setState(state State, expires time.Duration, err error) {
+	c.stateLock.Lock()
+	c.state = state
+	c.expires = expires
+	c.lastErr = err
+	c.stateLock.Unlock()
+}
+
+// request builds the next REGISTER request of the registration - RFC 3261 10.2.
+// expires is the requested interval, 0 removes the binding.
+// authorization is the optional Authorization or Proxy-Authorization headers.
+func (c *Client) request(expires time.Duration, authorization []base.SipHeader) *base.Request {
+	seqNo, err := c.cseq.Next()
+	if err != nil {
+		// CSeq numbers are exhausted, continue the registration as a new one - RFC 3261 10.2.
+		c.Log().Infof("%s, restarting registration with new Call-ID", err)
+		c.callId = base.CallId(utils.RandStr(16))
+		c.cseq = base.NewCSeqSequence(c.cfg.CSeq)
+		seqNo, _ = c.cseq.Next()
+	}
+
+	contact := c.contact()
+	port := uint16(0)
+	if contact.Port != nil {
+		port = *contact.Port
+	}
+	via := &base.ViaHeader{base.NewViaHop(c.cfg.Transport, contact.Host, port, "")}
+
+	registrarUri := &base.SipUri{
+		IsEncrypted: c.cfg.AOR.IsEncrypted,
+		User:        base.NoString{},
+		Password:    base.NoString{},
+		Host:        c.cfg.AOR.Host,
+		Port:        c.cfg.AOR.Port,
+		UriParams:   base.NewParams(),
+		Headers:     base.NewParams(),
+	}
+	callId := c.callId
+
+	req := base.NewRequest(
+		base.REGISTER,
+		registrarUri,
+		"SIP/2.0",
+		[]base.SipHeader{
+			via,
+			&base.ToHeader{
+				DisplayName: base.NoString{},
+				Address:     c.cfg.AOR.Copy(),
+				Params:      base.NewParams(),
+			},
+			&base.FromHeader{
+				DisplayName: base.NoString{},
+				Address:     c.cfg.AOR.Copy(),
+				Params:      base.NewParams().Add("tag", base.String{S: c.tag}),
+			},
+			&callId,
+			&base.CSeq{SeqNo: seqNo, MethodName: base.REGISTER},
+			&base.ContactHeader{
+				DisplayName: base.NoString{},
+				Address:     contact,
+				Params:      base.NewParams(),
+			},
+			&base.GenericHeader{
+				HeaderName: "Expires",
+				Contents:   strconv.Itoa(int(expires / time.Second)),
+			},
+			base.MaxForwards(70),
+			base.ContentLength(0),
+		},
+		"",
+		c.Log(),
+	)
+	for _, h := range authorization {
+		req.AddHeader(h)
+	}
+
+	return req
+}
+
+// contact returns the Contact URI of the requests, with the public address if it is known.
+func (c *Client) contact() *base.SipUri {
+	contact := c.cfg.Contact.Copy().(*base.SipUri)
+	if c.cfg.PublicAddr == nil {
+		return contact
+	}
+	host, portStr, err := net.SplitHostPort(c.cfg.PublicAddr())
+	if err != nil {
+		return contact
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return contact
+	}
+	publicPort := uint16(port)
+	contact.Host = host
+	contact.Port = &publicPort
+	return contact
+}
+
+// grantedExpires extracts the interval granted for our Contact from the 2xx response - RFC 3261 10.2.4.
+func (c *Client) grantedExpires(res *base.Response) time.Duration {
+	ours := c.contact()
+	for _, contact := range res.Contacts() {
+		if !contact.Address.Equals(ours) && !contact.Address.Equals(c.cfg.Contact) {
+			continue
+		}
+		if expires, ok := contact.Expires(); ok {
+			return expires
+		}
+	}
+
+	for _, h := range res.Headers("Expires") {
+		if seconds, err := strconv.Atoi(fieldValue(h)); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	return c.cfg.Expires
+}
+
+func fieldValue(h base.SipHeader) string {
+	if generic, ok := h.(*base.GenericHeader); ok {
+		return generic.Contents
+	}
+	return ""
+}
@@ -0,0 +1,298 @@
+package registration
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/testutils"
+	"github.com/ghettovoice/gossip/timing"
+	"github.com/ghettovoice/gossip/transaction"
+	"github.com/ghettovoice/gossip/transport"
+)
+
+const c_REGISTRAR = "127.0.0.1:5060"
+
+// Dummy transport manager which also reports flow failures.
+type dummyTransport struct {
+	messages chan base.SipMessage
+	toTM     chan base.SipMessage
+	flows    chan transport.FlowFailure
+}
+
+func newDummyTransport() *dummyTransport {
+	return &dummyTransport{
+		messages: make(chan base.SipMessage, 5),
+		toTM:     make(chan base.SipMessage, 5),
+		flows:    make(chan transport.FlowFailure, 5),
+	}
+}
+
+func (t *dummyTransport) Listen(address string) error { return nil }
+
+func (t *dummyTransport) Send(addr string, message base.SipMessage) error {
+	t.messages <- message
+	return nil
+}
+
+func (t *dummyTransport) Stop() {}
+
+func (t *dummyTransport) GetChannel() transport.Listener { return t.toTM }
+
+func (t *dummyTransport) IsReliable() bool { return true }
+
+func (t *dummyTransport) FlowFailures() <-chan transport.FlowFailure { return t.flows }
+
+func (t *dummyTransport) expectRegister(tt *testing.T) *base.Request {
+	select {
+	case msg := <-t.messages:
+		req, ok := msg.(*base.Request)
+		if !ok || req.Method != base.REGISTER {
+			tt.Fatalf("[FAIL] expected REGISTER request, got %s", msg.Short())
+		}
+		return req
+	case <-time.After(time.Second):
+		tt.Fatalf("[FAIL] REGISTER request was not sent")
+	}
+	return nil
+}
+
+func TestReRegisterOnFlowFailure(t *testing.T) {
+	tp, tm := newTestManager(t)
+	defer tm.Stop()
+
+	cfg := testConfig("alice")
+	cfg.Transport = "TCP"
+	client := NewClient(tm, cfg)
+	client.Start()
+	defer client.Stop()
+
+	for seqNo := uint32(1); seqNo <= 2; seqNo++ {
+		req := tp.expectRegister(t)
+		cseq, err := req.CSeq()
+		if err != nil || cseq.SeqNo != seqNo {
+			t.Errorf("[FAIL] expected REGISTER with CSeq %d, got %s", seqNo, req.Short())
+		}
+
+		res := base.NewResponseFromRequest(req, 200, "OK", "")
+		res.AddHeader(&base.GenericHeader{HeaderName: "Expires", Contents: "120"})
+		tp.toTM <- res
+
+		if !testutils.Eventually(func() bool { return client.State() == StateRegistered }) {
+			t.Fatalf("[FAIL] expected state %s, got %s", StateRegistered, client.State())
+		}
+		if client.Expires() != 120*time.Second {
+			t.Errorf("[FAIL] expected granted expires 120s, got %s", client.Expires())
+		}
+
+		if seqNo == 1 {
+			// Failure of unrelated flow must not trigger re-registration.
+			tp.flows <- transport.FlowFailure{Addr: "127.0.0.1:5080"}
+			tp.flows <- transport.FlowFailure{Addr: c_REGISTRAR}
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	timing.MockMode = true
+	log.SetDefaultLogLevel(log.WARN)
+	os.Exit(m.Run())
+}
+
+func newTestManager(t *testing.T) (*dummyTransport, *transaction.Manager) {
+	tp := newDummyTransport()
+	tm, err := transaction.NewManager(tp, "127.0.0.1:5070")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create transaction manager: %s", err)
+	}
+	return tp, tm
+}
+
+func testConfig(user string) Config {
+	port := uint16(5070)
+	return Config{
+		AOR:       &base.SipUri{User: base.String{S: user}, Host: "example.com", UriParams: base.NewParams(), Headers: base.NewParams()},
+		Contact:   &base.SipUri{User: base.String{S: user}, Host: "127.0.0.1", Port: &port, UriParams: base.NewParams(), Headers: base.NewParams()},
+		Registrar: c_REGISTRAR,
+		Password:  "secret",
+		CSeq:      1,
+	}
+}
+
+func TestRegisterWithDigest(t *testing.T) {
+	tp, tm := newTestManager(t)
+	defer tm.Stop()
+
+	client := NewClient(tm, testConfig("bob"))
+	client.Start()
+	defer client.Stop()
+
+	req := tp.expectRegister(t)
+	res := base.NewResponseFromRequest(req, 401, "Unauthorized", "")
+	res.AddHeader(&base.GenericHeader{
+		HeaderName: "WWW-Authenticate",
+		Contents:   `Digest realm="example.com", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", qop="auth,auth-int"`,
+	})
+	tp.toTM <- res
+
+	req = tp.expectRegister(t)
+	hdrs := req.Headers("Authorization")
+	if len(hdrs) != 1 {
+		t.Fatalf("[FAIL] expected Authorization header in %s", req.String())
+	}
+	auth := hdrs[0].(*base.GenericHeader).Contents
+	for _, part := range []string{`username="bob"`, `realm="example.com"`, `uri="sip:example.com"`, "qop=auth,", "nc=00000001"} {
+		if !strings.Contains(auth, part) {
+			t.Errorf("[FAIL] expected '%s' in Authorization header '%s'", part, auth)
+		}
+	}
+
+	tp.toTM <- base.NewResponseFromRequest(req, 200, "OK", "")
+	if !testutils.Eventually(func() bool { return client.State() == StateRegistered }) {
+		t.Fatalf("[FAIL] expected state %s, got %s", StateRegistered, client.State())
+	}
+}
+
+func TestManagerStatus(t *testing.T) {
+	tp, tm := newTestManager(t)
+	defer tm.Stop()
+
+	mng := NewManager(tm)
+	defer mng.Stop()
+
+	for _, name := range []string{"carol", "alice"} {
+		if _, err := mng.Add(name, testConfig(name)); err != nil {
+			t.Fatalf("[FAIL] failed to add account %s: %s", name, err)
+		}
+	}
+	if _, err := mng.Add("alice", testConfig("alice")); err == nil {
+		t.Errorf("[FAIL] expected error on adding account twice")
+	}
+
+	req := tp.expectRegister(t)
+	tp.toTM <- base.NewResponseFromRequest(req, 200, "OK", "")
+	req = tp.expectRegister(t)
+	tp.toTM <- base.NewResponseFromRequest(req, 403, "Forbidden", "")
+
+	if !testutils.Eventually(func() bool {
+		return mng.Registered() == 1 && len(mng.Status()) == 2 &&
+			mng.Status()[0].State != StateRegistering && mng.Status()[1].State != StateRegistering
+	}) {
+		t.Fatalf("[FAIL] expected one account registered, got %v", mng.Status())
+	}
+
+	statuses := mng.Status()
+	if statuses[0].Account != "alice" || statuses[1].Account != "carol" {
+		t.Errorf("[FAIL] expected statuses ordered by account name, got %v", statuses)
+	}
+	for _, status := range statuses {
+		if status.State == StateFailed && status.LastError == nil {
+			t.Errorf("[FAIL] expected error of failed account %s", status.Account)
+		}
+	}
+
+	if err := mng.Remove("alice"); err != nil {
+		t.Errorf("[FAIL] failed to remove account: %s", err)
+	}
+	if mng.Account("alice") != nil || len(mng.Status()) != 1 {
+		t.Errorf("[FAIL] account alice was not removed")
+	}
+
+	mng.Stop()
+}
+
+func TestRegisterZeroExpires(t *testing.T) {
+	tp, tm := newTestManager(t)
+	defer tm.Stop()
+
+	client := NewClient(tm, testConfig("frank"))
+	client.Start()
+	defer client.Stop()
+
+	req := tp.expectRegister(t)
+	res := base.NewResponseFromRequest(req, 200, "OK", "")
+	res.AddHeader(&base.GenericHeader{HeaderName: "Expires", Contents: "0"})
+	tp.toTM <- res
+
+	if !testutils.Eventually(func() bool { return client.State() == StateFailed }) {
+		t.Fatalf("[FAIL] expected state %s on zero granted interval, got %s", StateFailed, client.State())
+	}
+	if client.Status().LastError == nil {
+		t.Errorf("[FAIL] expected error of registration with zero granted interval")
+	}
+}
+
+func TestUnregister(t *testing.T) {
+	tp, tm := newTestManager(t)
+	defer tm.Stop()
+
+	client := NewClient(tm, testConfig("dave"))
+	client.Start()
+
+	req := tp.expectRegister(t)
+	tp.toTM <- base.NewResponseFromRequest(req, 200, "OK", "")
+	if !testutils.Eventually(func() bool { return client.State() == StateRegistered }) {
+		t.Fatalf("[FAIL] expected state %s, got %s", StateRegistered, client.State())
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- client.Unregister() }()
+
+	req = tp.expectRegister(t)
+	if expires := req.Headers("Expires"); len(expires) != 1 || fieldValue(expires[0]) != "0" {
+		t.Errorf("[FAIL] expected REGISTER with Expires 0, got:\n%s", req.String())
+	}
+	if cseq, err := req.CSeq(); err != nil || cseq.SeqNo != 2 {
+		t.Errorf("[FAIL] expected REGISTER with CSeq 2, got %s", req.Short())
+	}
+	tp.toTM <- base.NewResponseFromRequest(req, 200, "OK", "")
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("[FAIL] unregistration failed: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] unregistration did not complete")
+	}
+	if client.State() != StateUnregistered {
+		t.Errorf("[FAIL] expected state %s, got %s", StateUnregistered, client.State())
+	}
+}
+
+func TestRegisterPublicAddr(t *testing.T) {
+	tp, tm := newTestManager(t)
+	defer tm.Stop()
+
+	cfg := testConfig("erin")
+	cfg.PublicAddr = func() string { return "203.0.113.5:40000" }
+	client := NewClient(tm, cfg)
+	client.Start()
+	defer client.Stop()
+
+	req := tp.expectRegister(t)
+	if hop, err := req.ViaHop(); err != nil || hop.Host != "203.0.113.5" || hop.Port == nil || *hop.Port != 40000 {
+		t.Errorf("[FAIL] expected public address in Via, got:\n%s", req.String())
+	}
+	contacts := req.Contacts()
+	if len(contacts) != 1 || contacts[0].Address.String() != "sip:erin@203.0.113.5:40000" {
+		t.Fatalf("[FAIL] expected public address in Contact, got:\n%s", req.String())
+	}
+
+	res := base.NewResponseFromRequest(req, 200, "OK", "")
+	res.AddHeader(&base.ContactHeader{
+		DisplayName: base.NoString{},
+		Address:     contacts[0].Address.Copy().(base.ContactUri),
+		Params:      base.NewParams().Add("expires", base.String{S: "300"}),
+	})
+	tp.toTM <- res
+	if !testutils.Eventually(func() bool { return client.State() == StateRegistered }) {
+		t.Fatalf("[FAIL] expected state %s, got %s", StateRegistered, client.State())
+	}
+	if client.Expires() != 300*time.Second {
+		t.Errorf("[FAIL] expected expires 300s granted to the public Contact, got %s", client.Expires())
+	}
+}
@@ -0,0 +1,174 @@
+package registration
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/transaction"
+	"github.com/ghettovoice/gossip/transport"
+)
+
+// Status is a snapshot of the account registration.
+type Status struct {
+	// Name the account was added with.
+	Account   string
+	AOR       string
+	Registrar string
+	State     State
+	// Interval granted by the registrar, 0 unless registered.
+	Expires   time.Duration
+	LastError error
+}
+
+// Manager keeps many accounts registered over the same transaction manager.
+// Each account has its own credentials, registrar and interval, refreshes are jittered.
+type Manager struct {
+	tm       *transaction.Manager
+	accounts map[string]*Client
+	lock     sync.RWMutex
+	stop     chan bool
+	stopOnce sync.Once
+}
+
+func NewManager(tm *transaction.Manager) *Manager {
+	mng := &Manager{
+		tm:       tm,
+		accounts: make(map[string]*Client),
+		stop:     make(chan bool),
+	}
+
+	if flows := tm.FlowFailures(); flows != nil {
+		go mng.watchFlows(flows)
+	}
+
+	return mng
+}
+
+// Add starts registration of the account under the name.
+func (mng *Manager) Add(name string, cfg Config) (*Client, error) {
+	mng.lock.Lock()
+	defer mng.lock.Unlock()
+
+	if _, ok := mng.accounts[name]; ok {
+		return nil, fmt.Errorf("account %s already registered", name)
+	}
+
+	client := NewClient(mng.tm, cfg)
+	mng.accounts[name] = client
+	client.start(false)
+
+	log.Debugf("registration manager %p added account %s", mng, name)
+	return client, nil
+}
+
+// Remove stops refreshing the account registration.
+func (mng *Manager) Remove(name string) error {
+	mng.lock.Lock()
+	defer mng.lock.Unlock()
+
+	client, ok := mng.accounts[name]
+	if !ok {
+		return fmt.Errorf("account %s not found", name)
+	}
+	client.Stop()
+	delete(mng.accounts, name)
+
+	log.Debugf("registration manager %p removed account %s", mng, name)
+	return nil
+}
+
+// Account returns the client registering the account or nil.
+func (mng *Manager) Account(name string) *Client {
+	mng.lock.RLock()
+	defer mng.lock.RUnlock()
+	return mng.accounts[name]
+}
+
+// Status returns registration status of all accounts ordered by account name.
+func (mng *Manager) Status() []Status {
+	mng.lock.RLock()
+	defer mng.lock.RUnlock()
+
+	statuses := make([]Status, 0, len(mng.accounts))
+	for name, client := range mng.accounts {
+		status := client.Status()
+		status.Account = name
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Account < statuses[j].Account })
+
+	return statuses
+}
+
+// Registered returns the number of accounts currently registered.
+func (mng *Manager) Registered() int {
+	count := 0
+	for _, status := range mng.Status() {
+		if status.State == StateRegistered {
+			count++
+		}
+	}
+	return count
+}
+
+// UnregisterAll removes the bindings of all accounts at their registrars and forgets the accounts,
+// e.g. when the instance drains before shutdown. Returns the first failure, all accounts are tried anyway.
+func (mng *Manager) UnregisterAll() error {
+	mng.lock.Lock()
+	clients := mng.accounts
+	mng.accounts = make(map[string]*Client)
+	mng.lock.Unlock()
+
+	errs := make(chan error, len(clients))
+	for name, client := range clients {
+		go func(name string, client *Client) {
+			if err := client.Unregister(); err != nil {
+				errs <- fmt.Errorf("failed to unregister account %s: %s", name, err)
+				return
+			}
+			errs <- nil
+		}(name, client)
+	}
+
+	var first error
+	for range clients {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Stop refreshing all accounts. Safe to call more than once.
+func (mng *Manager) Stop() {
+	mng.stopOnce.Do(func() { close(mng.stop) })
+
+	mng.lock.Lock()
+	defer mng.lock.Unlock()
+	for name, client := range mng.accounts {
+		client.Stop()
+		delete(mng.accounts, name)
+	}
+}
+
+// watchFlows passes flow failures to every account, each one checks whether the flow is its own.
+func (mng *Manager) watchFlows(flows <-chan transport.FlowFailure) {
+	for {
+		select {
+		case failure, ok := <-flows:
+			if !ok {
+				return
+			}
+			mng.lock.RLock()
+			for _, client := range mng.accounts {
+				client.FlowFailed(failure)
+			}
+			mng.lock.RUnlock()
+		case <-mng.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package routing
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gossip/timing"
+)
+
+// TargetSelector orders the targets of a request in which they are tried, e.g. by trunk cost or load,
+// and learns from the outcomes of the attempts.
+type TargetSelector interface {
+	// Select returns the targets in the order they should be tried, it must not modify the given slice.
+	Select(targets []Target) []Target
+	// Report records the outcome of sending a request to the target, failed on timeout, transport error or 503.
+	Report(addr string, failed bool)
+}
+
+// PriorityFailover tries the targets by their priority, lower first, keeping the order of the targets of the same priority.
+type PriorityFailover struct{}
+
+func (PriorityFailover) Select(targets []Target) []Target {
+	ordered := append([]Target{}, targets...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Priority < ordered[j].Priority })
+	return ordered
+}
+
+func (PriorityFailover) Report(addr string, failed bool) {}
+
+// RoundRobin starts every selection from the next target, spreading the requests evenly.
+type RoundRobin struct {
+	next int
+	lock sync.Mutex
+}
+
+func (rr *RoundRobin) Select(targets []Target) []Target {
+	if len(targets) == 0 {
+		return nil
+	}
+	rr.lock.Lock()
+	start := rr.next % len(targets)
+	rr.next++
+	rr.lock.Unlock()
+
+	return append(append([]Target{}, targets[start:]...), targets[:start]...)
+}
+
+func (rr *RoundRobin) Report(addr string, failed bool) {}
+
+// Weighted picks the targets randomly in proportion to their weights, the rest follow in the same manner.
+// Targets of zero weight are tried last.
+type Weighted struct {
+	rand *rand.Rand
+	lock sync.Mutex
+}
+
+// NewWeighted creates the weighted selector, seed makes the selection reproducible.
+func NewWeighted(seed int64) *Weighted {
+	return &Weighted{rand: rand.New(rand.NewSource(seed))}
+}
+
+func (w *Weighted) Select(targets []Target) []Target {
+	left := append([]Target{}, targets...)
+	ordered := make([]Target, 0, len(targets))
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for len(left) > 0 {
+		total := 0
+		for _, target := range left {
+			total += target.Weight
+		}
+		if total <= 0 {
+			break
+		}
+		pick := w.rand.Intn(total)
+		for i, target := range left {
+			if pick < target.Weight {
+				ordered = append(ordered, target)
+				left = append(left[:i], left[i+1:]...)
+				break
+			}
+			pick -= target.Weight
+		}
+	}
+	return append(ordered, left...)
+}
+
+func (w *Weighted) Report(addr string, failed bool) {}
+
+// LeastRecentFailure tries the targets never failed first, then the ones failed longest ago.
+// A success clears the failure of the target.
+type LeastRecentFailure struct {
+	failures map[string]time.Time
+	lock     sync.Mutex
+}
+
+func (lrf *LeastRecentFailure) Select(targets []Target) []Target {
+	ordered := append([]Target{}, targets...)
+
+	lrf.lock.Lock()
+	defer lrf.lock.Unlock()
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, aFailed := lrf.failures[ordered[i].Addr]
+		b, bFailed := lrf.failures[ordered[j].Addr]
+		if aFailed != bFailed {
+			return bFailed
+		}
+		return aFailed && a.Before(b)
+	})
+	return ordered
+}
+
+func (lrf *LeastRecentFailure) Report(addr string, failed bool) {
+	lrf.lock.Lock()
+	defer lrf.lock.Unlock()
+	if !failed {
+		delete(lrf.failures, addr)
+		return
+	}
+	if lrf.failures == nil {
+		lrf.failures = make(map[string]time.Time)
+	}
+	lrf.failures[addr] = timing.Now()
+}
@@ -0,0 +1,71 @@
+package routing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/timing"
+)
+
+func addrs(targets []Target) []string {
+	result := make([]string, len(targets))
+	for i, target := range targets {
+		result[i] = target.Addr
+	}
+	return result
+}
+
+func TestSelectors(t *testing.T) {
+	timing.MockMode = true
+	targets := []Target{
+		{Addr: "a", Priority: 2, Weight: 1},
+		{Addr: "b", Priority: 1, Weight: 3},
+		{Addr: "c", Priority: 2, Weight: 0},
+	}
+
+	if got := addrs(PriorityFailover{}.Select(targets)); got[0] != "b" || got[1] != "a" || got[2] != "c" {
+		t.Errorf("[FAIL] unexpected priority failover order %v", got)
+	}
+
+	rr := &RoundRobin{}
+	for _, first := range []string{"a", "b", "c", "a"} {
+		if got := addrs(rr.Select(targets)); got[0] != first || len(got) != 3 {
+			t.Errorf("[FAIL] expected round robin to start from %s, got %v", first, got)
+		}
+	}
+
+	w := NewWeighted(1)
+	firsts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		got := addrs(w.Select(targets))
+		if got[2] != "c" {
+			t.Fatalf("[FAIL] expected target of zero weight last, got %v", got)
+		}
+		firsts[got[0]]++
+	}
+	if firsts["b"] < 650 || firsts["b"] > 850 {
+		t.Errorf("[FAIL] expected target of weight 3 first in 3/4 of selections, got %d of 1000", firsts["b"])
+	}
+
+	lrf := &LeastRecentFailure{}
+	lrf.Report("a", true)
+	timing.Elapse(time.Second)
+	lrf.Report("b", true)
+	if got := addrs(lrf.Select(targets)); got[0] != "c" || got[1] != "a" || got[2] != "b" {
+		t.Errorf("[FAIL] unexpected least recent failure order %v", got)
+	}
+	lrf.Report("b", false)
+	if got := addrs(lrf.Select(targets)); got[0] != "b" || got[1] != "c" || got[2] != "a" {
+		t.Errorf("[FAIL] expected recovered target first, got %v", got)
+	}
+
+	table := NewTable()
+	table.SetSelector(rr)
+	if err := table.Add(Rule{Kind: MatchDomain, Pattern: "example.com", Targets: targets}); err != nil {
+		t.Fatalf("[FAIL] failed to add rule: %s", err)
+	}
+	first := table.Lookup(uriOf("bob", "example.com"))[0].Addr
+	if second := table.Lookup(uriOf("bob", "example.com"))[0].Addr; first == second {
+		t.Errorf("[FAIL] expected table lookups rotated by the selector, got %s twice", first)
+	}
+}
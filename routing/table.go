@@ -0,0 +1,277 @@
+// Package routing maps Request-URIs to target sets by prefix, domain and regular expression rules,
+// a minimal dialplan for proxies forwarding requests statelessly or by transaction.Router.
+package routing
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/transaction"
+)
+
+// MatchKind is the kind of the pattern of a rule.
+type MatchKind int
+
+const (
+	// MatchPrefix matches the user part of the Request-URI by prefix, e.g. a dialed number.
+	MatchPrefix MatchKind = iota
+	// MatchDomain matches the host of the Request-URI, *.example.com matches the subdomains.
+	MatchDomain
+	// MatchRegexp matches the whole Request-URI by regular expression.
+	MatchRegexp
+)
+
+func (kind MatchKind) String() string {
+	switch kind {
+	case MatchPrefix:
+		return "Prefix"
+	case MatchDomain:
+		return "Domain"
+	case MatchRegexp:
+		return "Regexp"
+	default:
+		return "Unknown"
+	}
+}
+
+// Target is a destination of the requests matched by a rule.
+type Target struct {
+	// Addr is host:port the requests are forwarded to.
+	Addr string
+	// Priority orders the targets for failover, lower first.
+	Priority int
+	// Weight is the share of the requests of the target among the others, used by Weighted selector.
+	Weight int
+}
+
+// Rule maps the Request-URIs matching the pattern to the targets.
+type Rule struct {
+	Kind    MatchKind
+	Pattern string
+	// Priority orders the matching rules, lower first; among rules of the same priority
+	// the longest matching prefix or domain wins, then the earliest added rule.
+	Priority int
+	Targets  []Target
+	regexp   *regexp.Regexp
+	order    int
+}
+
+// match returns the length of the match, -1 if the URI doesn't match.
+func (rule *Rule) match(uri base.Uri) int {
+	switch rule.Kind {
+	case MatchPrefix:
+		sipUri, ok := uri.(*base.SipUri)
+		if !ok {
+			return -1
+		}
+		user, ok := sipUri.User.(base.String)
+		if !ok || !strings.HasPrefix(user.S, rule.Pattern) {
+			return -1
+		}
+		return len(rule.Pattern)
+	case MatchDomain:
+		sipUri, ok := uri.(*base.SipUri)
+		if !ok {
+			return -1
+		}
+		host := strings.ToLower(sipUri.Host)
+		pattern := strings.ToLower(rule.Pattern)
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) {
+				return len(pattern) - 1
+			}
+			return -1
+		}
+		if host == pattern {
+			return len(pattern)
+		}
+		return -1
+	case MatchRegexp:
+		if rule.regexp.MatchString(uri.String()) {
+			return 0
+		}
+		return -1
+	default:
+		return -1
+	}
+}
+
+// Table is the routing table. The targets of a rule are ordered by the selector, PriorityFailover by default;
+// targets marked down are tried last.
+type Table struct {
+	rules    []*Rule
+	selector TargetSelector
+	down     map[string]bool
+	next     int
+	lock     sync.RWMutex
+}
+
+// NewTable creates the empty routing table.
+func NewTable() *Table {
+	return &Table{selector: PriorityFailover{}, down: make(map[string]bool)}
+}
+
+// SetSelector sets the strategy ordering the targets, nil restores PriorityFailover.
+func (table *Table) SetSelector(selector TargetSelector) {
+	if selector == nil {
+		selector = PriorityFailover{}
+	}
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	table.selector = selector
+}
+
+// Report passes the outcome of sending a request to the target to the selector.
+func (table *Table) Report(addr string, failed bool) {
+	table.lock.RLock()
+	selector := table.selector
+	table.lock.RUnlock()
+	selector.Report(addr, failed)
+}
+
+// Add adds the rule, fails if the regular expression of the rule is invalid or it has no targets.
+func (table *Table) Add(rule Rule) error {
+	compiled, err := compileRule(rule)
+	if err != nil {
+		return err
+	}
+
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	compiled.order = table.next
+	table.next++
+	table.rules = append(table.rules, compiled)
+	return nil
+}
+
+// Replace replaces all the rules at once, e.g. on configuration reload, so requests are never routed
+// by a half updated table. If any of the rules is invalid the table is left unchanged.
+func (table *Table) Replace(rules ...Rule) error {
+	compiled := make([]*Rule, 0, len(rules))
+	for _, rule := range rules {
+		r, err := compileRule(rule)
+		if err != nil {
+			return err
+		}
+		compiled = append(compiled, r)
+	}
+
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	for _, rule := range compiled {
+		rule.order = table.next
+		table.next++
+	}
+	table.rules = compiled
+	return nil
+}
+
+// Rules returns the rules in the order they were added.
+func (table *Table) Rules() []Rule {
+	table.lock.RLock()
+	defer table.lock.RUnlock()
+	rules := make([]Rule, 0, len(table.rules))
+	for _, rule := range table.rules {
+		r := *rule
+		r.Targets = append([]Target{}, rule.Targets...)
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// compileRule validates the rule and returns its copy ready for matching.
+func compileRule(rule Rule) (*Rule, error) {
+	if len(rule.Targets) == 0 {
+		return nil, fmt.Errorf("routing rule %s %s has no targets", rule.Kind, rule.Pattern)
+	}
+	if rule.Kind == MatchRegexp {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid routing rule %s: %s", rule.Pattern, err)
+		}
+		rule.regexp = re
+	}
+	rule.Targets = append([]Target{}, rule.Targets...)
+	return &rule, nil
+}
+
+// Remove removes the rules of the kind and the pattern.
+func (table *Table) Remove(kind MatchKind, pattern string) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	kept := table.rules[:0]
+	for _, rule := range table.rules {
+		if rule.Kind != kind || rule.Pattern != pattern {
+			kept = append(kept, rule)
+		}
+	}
+	table.rules = kept
+}
+
+// MarkDown moves the target to the end of the failover order until MarkUp, e.g. after a timeout.
+func (table *Table) MarkDown(addr string) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	table.down[addr] = true
+}
+
+// MarkUp restores the failover order of the target.
+func (table *Table) MarkUp(addr string) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	delete(table.down, addr)
+}
+
+// Lookup returns the targets of the best rule matching the URI in the order of the selector, nil if no rule matches.
+func (table *Table) Lookup(uri base.Uri) []Target {
+	table.lock.RLock()
+	defer table.lock.RUnlock()
+
+	var best *Rule
+	bestLength := -1
+	for _, rule := range table.rules {
+		length := rule.match(uri)
+		if length < 0 {
+			continue
+		}
+		if best == nil || rule.Priority < best.Priority ||
+			(rule.Priority == best.Priority && (length > bestLength || (length == bestLength && rule.order < best.order))) {
+			best, bestLength = rule, length
+		}
+	}
+	if best == nil {
+		return nil
+	}
+
+	selected := table.selector.Select(best.Targets)
+	targets := make([]Target, 0, len(selected))
+	for _, target := range selected {
+		if !table.down[target.Addr] {
+			targets = append(targets, target)
+		}
+	}
+	for _, target := range selected {
+		if table.down[target.Addr] {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// Router returns transaction.Router forwarding requests to the first target of their Request-URI.
+// Requests matching no rule are passed to the next router, if any.
+func (table *Table) Router(next transaction.Router) transaction.Router {
+	return func(req *base.Request) transaction.Route {
+		if targets := table.Lookup(req.Recipient); len(targets) > 0 {
+			return transaction.Route{Forward: targets[0].Addr}
+		}
+
+		if next != nil {
+			return next(req)
+		}
+		return transaction.Route{}
+	}
+}
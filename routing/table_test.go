@@ -0,0 +1,104 @@
+package routing
+
+import (
+	"testing"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+func uriOf(user, host string) *base.SipUri {
+	return &base.SipUri{User: base.String{S: user}, Host: host, UriParams: base.NewParams(), Headers: base.NewParams()}
+}
+
+func TestTableLookup(t *testing.T) {
+	table := NewTable()
+	rules := []Rule{
+		{Kind: MatchPrefix, Pattern: "1", Targets: []Target{{Addr: "national.trunk:5060", Priority: 0}}},
+		{Kind: MatchPrefix, Pattern: "1800", Targets: []Target{{Addr: "tollfree.backup:5060", Priority: 2}, {Addr: "tollfree.trunk:5060", Priority: 1}}},
+		{Kind: MatchDomain, Pattern: "*.example.com", Targets: []Target{{Addr: "edge.example.com:5060", Priority: 0}}},
+		{Kind: MatchRegexp, Pattern: `^sip:\+?44`, Priority: -1, Targets: []Target{{Addr: "uk.trunk:5060", Priority: 0}}},
+	}
+	for _, rule := range rules {
+		if err := table.Add(rule); err != nil {
+			t.Fatalf("[FAIL] failed to add rule %s: %s", rule.Pattern, err)
+		}
+	}
+	if err := table.Add(Rule{Kind: MatchRegexp, Pattern: "(", Targets: rules[0].Targets}); err == nil {
+		t.Errorf("[FAIL] expected invalid regular expression rejected")
+	}
+
+	lookup := func(target base.Uri, expected ...string) {
+		t.Helper()
+		targets := table.Lookup(target)
+		addrs := make([]string, len(targets))
+		for i, target := range targets {
+			addrs[i] = target.Addr
+		}
+		if len(addrs) != len(expected) {
+			t.Errorf("[FAIL] expected targets %v of %s, got %v", expected, target, addrs)
+			return
+		}
+		for i := range addrs {
+			if addrs[i] != expected[i] {
+				t.Errorf("[FAIL] expected targets %v of %s, got %v", expected, target, addrs)
+				return
+			}
+		}
+	}
+
+	lookup(uriOf("12125551234", "gw.local"), "national.trunk:5060")
+	lookup(uriOf("18005551234", "gw.local"), "tollfree.trunk:5060", "tollfree.backup:5060")
+	lookup(uriOf("bob", "pbx.example.com"), "edge.example.com:5060")
+	lookup(uriOf("bob", "example.com"))
+	lookup(uriOf("+442071234567", "pbx.example.com"), "uk.trunk:5060")
+
+	table.MarkDown("tollfree.trunk:5060")
+	lookup(uriOf("18005551234", "gw.local"), "tollfree.backup:5060", "tollfree.trunk:5060")
+	table.MarkUp("tollfree.trunk:5060")
+
+	table.Remove(MatchPrefix, "1800")
+	lookup(uriOf("18005551234", "gw.local"), "national.trunk:5060")
+
+	router := table.Router(nil)
+	req := base.NewRequest(base.INVITE, uriOf("12125551234", "gw.local"), "SIP/2.0", []base.SipHeader{}, "", log.StandardLogger())
+	if route := router(req); route.Forward != "national.trunk:5060" {
+		t.Errorf("[FAIL] expected request forwarded to national.trunk:5060, got %v", route)
+	}
+	req.Recipient = uriOf("bob", "example.com")
+	if route := router(req); !route.IsLocal() {
+		t.Errorf("[FAIL] expected unmatched request handled locally, got %v", route)
+	}
+}
+
+func TestTableReplace(t *testing.T) {
+	table := NewTable()
+	if err := table.Add(Rule{Kind: MatchPrefix, Pattern: "1", Targets: []Target{{Addr: "old.trunk:5060"}}}); err != nil {
+		t.Fatalf("[FAIL] failed to add rule: %s", err)
+	}
+
+	err := table.Replace(
+		Rule{Kind: MatchPrefix, Pattern: "1", Targets: []Target{{Addr: "new.trunk:5060"}}},
+		Rule{Kind: MatchRegexp, Pattern: "(", Targets: []Target{{Addr: "broken.trunk:5060"}}},
+	)
+	if err == nil {
+		t.Errorf("[FAIL] expected invalid rules rejected")
+	}
+	if targets := table.Lookup(uriOf("1555", "example.com")); len(targets) != 1 || targets[0].Addr != "old.trunk:5060" {
+		t.Errorf("[FAIL] expected the table unchanged, got %v", targets)
+	}
+
+	err = table.Replace(
+		Rule{Kind: MatchPrefix, Pattern: "1", Targets: []Target{{Addr: "new.trunk:5060"}}},
+		Rule{Kind: MatchDomain, Pattern: "example.org", Targets: []Target{{Addr: "edge.example.org:5060"}}},
+	)
+	if err != nil {
+		t.Fatalf("[FAIL] unexpected error: %s", err)
+	}
+	if targets := table.Lookup(uriOf("1555", "example.com")); len(targets) != 1 || targets[0].Addr != "new.trunk:5060" {
+		t.Errorf("[FAIL] expected the new route, got %v", targets)
+	}
+	if rules := table.Rules(); len(rules) != 2 || rules[1].Pattern != "example.org" {
+		t.Errorf("[FAIL] unexpected rules %v", rules)
+	}
+}
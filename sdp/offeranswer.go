@@ -0,0 +1,177 @@
+package sdp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Offer/answer helpers - RFC 3264.
+
+// ReverseDirection returns the direction of the answer to the offered direction, e.g. recvonly to sendonly - RFC 3264 6.1.
+func ReverseDirection(dir string) string {
+	switch dir {
+	case SendOnly:
+		return RecvOnly
+	case RecvOnly:
+		return SendOnly
+	default:
+		return dir
+	}
+}
+
+// IntersectDirection returns the direction both directions allow, e.g. sendrecv and recvonly to recvonly.
+func IntersectDirection(a, b string) string {
+	send := canSend(a) && canSend(b)
+	recv := canRecv(a) && canRecv(b)
+	switch {
+	case send && recv:
+		return SendRecv
+	case send:
+		return SendOnly
+	case recv:
+		return RecvOnly
+	default:
+		return Inactive
+	}
+}
+
+func canSend(dir string) bool {
+	return dir == SendRecv || dir == SendOnly
+}
+
+func canRecv(dir string) bool {
+	return dir == SendRecv || dir == RecvOnly
+}
+
+// NextVersion increments the version of the session, required on every modification of the session - RFC 3264 8.
+func (session *Session) NextVersion() {
+	session.Origin.SessionVersion++
+}
+
+// Answer builds the answer to the offer from the local capabilities - RFC 3264 6.
+// local describes the answerer: its origin, connection and the media it supports with their ports,
+// formats in the order of preference and directions.
+// Every offered media stream is answered in order by the first unused local media of the same type and protocol,
+// with the offered formats the local media supports, in the local order and with the offered payload types.
+// Offered streams without matching local media or common formats are rejected with port 0.
+// Fails if no stream is accepted.
+func Answer(offer *Session, local *Session) (*Session, error) {
+	answer := &Session{
+		Origin:     local.Origin,
+		Name:       local.Name,
+		Connection: local.Connection,
+		Timings:    offer.Timings,
+	}
+	for _, attr := range local.Attributes {
+		if _, isDir := (Attributes{attr}).direction(); !isDir {
+			answer.Attributes = append(answer.Attributes, attr)
+		}
+	}
+
+	used := make(map[*Media]bool)
+	accepted := 0
+	for _, offered := range offer.Media {
+		var media *Media
+		if offered.Port != 0 {
+			for _, candidate := range local.Media {
+				if used[candidate] || candidate.Type != offered.Type || candidate.Proto != offered.Proto {
+					continue
+				}
+				media = answerMedia(offer, offered, local, candidate)
+				if media != nil {
+					used[candidate] = true
+					break
+				}
+			}
+		}
+		if media == nil {
+			// Rejected stream keeps the offered formats - RFC 3264 6.
+			media = &Media{Type: offered.Type, Port: 0, Proto: offered.Proto, Formats: offered.Formats}
+		} else {
+			accepted++
+		}
+		answer.Media = append(answer.Media, media)
+	}
+
+	if accepted == 0 {
+		return nil, fmt.Errorf("no offered media stream is supported")
+	}
+	return answer, nil
+}
+
+// answerMedia answers the offered media by the local one, nil if they have no common formats.
+func answerMedia(offer *Session, offered *Media, local *Session, supported *Media) *Media {
+	media := &Media{
+		Type:       offered.Type,
+		Port:       supported.Port,
+		Proto:      offered.Proto,
+		Connection: supported.Connection,
+	}
+	for _, localFormat := range supported.Formats {
+		for _, format := range offered.Formats {
+			if !sameFormat(offered, format, supported, localFormat) || contains(media.Formats, format) {
+				continue
+			}
+			media.Formats = append(media.Formats, format)
+			if rtpmap, ok := offered.Rtpmap(format); ok {
+				media.Attributes = append(media.Attributes, Attribute{"rtpmap", format + " " + rtpmap})
+			}
+			if fmtp, ok := offered.Fmtp(format); ok {
+				media.Attributes = append(media.Attributes, Attribute{"fmtp", format + " " + fmtp})
+			}
+		}
+	}
+	if len(media.Formats) == 0 {
+		return nil
+	}
+
+	dir := IntersectDirection(ReverseDirection(offer.Direction(offered)), local.Direction(supported))
+	media.Attributes = append(media.Attributes, Attribute{Name: dir})
+	return media
+}
+
+// sameFormat compares the formats by rtpmap encoding if any, otherwise by the format, e.g. static payload type.
+func sameFormat(a *Media, aFormat string, b *Media, bFormat string) bool {
+	aEncoding, aOk := a.Rtpmap(aFormat)
+	bEncoding, bOk := b.Rtpmap(bFormat)
+	if aOk && bOk {
+		return strings.EqualFold(aEncoding, bEncoding)
+	}
+	return aFormat == bFormat
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Hold puts the active media streams on hold: the local side stops receiving, so sendrecv becomes sendonly
+// and recvonly becomes inactive - RFC 3264 8.4. The version is incremented, the result is the new offer.
+func (session *Session) Hold() {
+	for _, media := range session.Media {
+		if media.Port != 0 {
+			media.SetDirection(IntersectDirection(session.Direction(media), SendOnly))
+		}
+	}
+	session.NextVersion()
+}
+
+// Resume takes the media streams put on hold by Hold off it: sendonly becomes sendrecv, inactive becomes recvonly.
+// The version is incremented, the result is the new offer.
+func (session *Session) Resume() {
+	for _, media := range session.Media {
+		if media.Port == 0 {
+			continue
+		}
+		if canSend(session.Direction(media)) {
+			media.SetDirection(SendRecv)
+		} else {
+			media.SetDirection(RecvOnly)
+		}
+	}
+	session.NextVersion()
+}
@@ -0,0 +1,495 @@
+// Package sdp parses and builds session descriptions carried in SIP message bodies - RFC 4566,
+// and helps to negotiate them by the offer/answer model - RFC 3264.
+package sdp
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Content type of session descriptions.
+const ContentType = "application/sdp"
+
+// Media directions - RFC 3264 5.1.
+const (
+	SendRecv = "sendrecv"
+	SendOnly = "sendonly"
+	RecvOnly = "recvonly"
+	Inactive = "inactive"
+)
+
+// Origin is the originator and the version of the session, o= line.
+type Origin struct {
+	Username       string
+	SessionId      uint64
+	SessionVersion uint64
+	NetType        string
+	AddrType       string
+	Address        string
+}
+
+func (origin *Origin) String() string {
+	return fmt.Sprintf("%s %d %d %s %s %s",
+		origin.Username, origin.SessionId, origin.SessionVersion, origin.NetType, origin.AddrType, origin.Address)
+}
+
+// Connection is the connection data, c= line.
+type Connection struct {
+	NetType  string
+	AddrType string
+	Address  string
+}
+
+// NewConnection creates IN IP4 or IN IP6 connection data of the address.
+func NewConnection(address string) *Connection {
+	addrType := "IP4"
+	if strings.Contains(address, ":") {
+		addrType = "IP6"
+	}
+	return &Connection{NetType: "IN", AddrType: addrType, Address: address}
+}
+
+func (conn *Connection) String() string {
+	return fmt.Sprintf("%s %s %s", conn.NetType, conn.AddrType, conn.Address)
+}
+
+// Timing is the active time of the session, t= line with the following r= lines.
+type Timing struct {
+	Start   uint64
+	Stop    uint64
+	Repeats []string
+}
+
+// Attribute is a= line, property attributes have empty Value.
+type Attribute struct {
+	Name  string
+	Value string
+}
+
+func (attr Attribute) String() string {
+	if attr.Value == "" {
+		return attr.Name
+	}
+	return attr.Name + ":" + attr.Value
+}
+
+// Attributes is the ordered list of attributes of the session or the media.
+type Attributes []Attribute
+
+// Get returns the value of the first attribute of the name.
+func (attrs Attributes) Get(name string) (string, bool) {
+	for _, attr := range attrs {
+		if attr.Name == name {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+// Has reports whether there is the attribute of the name.
+func (attrs Attributes) Has(name string) bool {
+	_, ok := attrs.Get(name)
+	return ok
+}
+
+// Values returns the values of all attributes of the name, e.g. of rtpmap.
+func (attrs Attributes) Values(name string) []string {
+	values := make([]string, 0)
+	for _, attr := range attrs {
+		if attr.Name == name {
+			values = append(values, attr.Value)
+		}
+	}
+	return values
+}
+
+// Set replaces the attributes of the name with the one, or adds it.
+func (attrs *Attributes) Set(name string, value string) {
+	attrs.Remove(name)
+	*attrs = append(*attrs, Attribute{name, value})
+}
+
+// Remove removes all attributes of the name.
+func (attrs *Attributes) Remove(name string) {
+	kept := (*attrs)[:0]
+	for _, attr := range *attrs {
+		if attr.Name != name {
+			kept = append(kept, attr)
+		}
+	}
+	*attrs = kept
+}
+
+// direction returns the direction attribute if any.
+func (attrs Attributes) direction() (string, bool) {
+	for _, attr := range attrs {
+		switch attr.Name {
+		case SendRecv, SendOnly, RecvOnly, Inactive:
+			return attr.Name, true
+		}
+	}
+	return "", false
+}
+
+// Media is the media description, m= line with the following lines.
+type Media struct {
+	Type       string // audio, video, application etc.
+	Port       int    // 0 means the media is rejected or disabled - RFC 3264 8.2.
+	PortCount  int    // Number of ports of hierarchically encoded streams, 0 if not given.
+	Proto      string // e.g. RTP/AVP.
+	Formats    []string
+	Info       string
+	Connection *Connection
+	Bandwidths []string
+	Key        string
+	Attributes Attributes
+}
+
+// Rtpmap returns the encoding of the RTP payload format, e.g. PCMU/8000, from rtpmap attribute.
+func (media *Media) Rtpmap(format string) (string, bool) {
+	return media.formatAttribute("rtpmap", format)
+}
+
+// Fmtp returns the format parameters of the payload format from fmtp attribute.
+func (media *Media) Fmtp(format string) (string, bool) {
+	return media.formatAttribute("fmtp", format)
+}
+
+func (media *Media) formatAttribute(name string, format string) (string, bool) {
+	for _, value := range media.Attributes.Values(name) {
+		fields := strings.SplitN(value, " ", 2)
+		if len(fields) == 2 && fields[0] == format {
+			return fields[1], true
+		}
+	}
+	return "", false
+}
+
+// SetDirection replaces the direction attribute of the media, overriding the direction of the session.
+func (media *Media) SetDirection(dir string) {
+	for _, name := range []string{SendRecv, SendOnly, RecvOnly, Inactive} {
+		media.Attributes.Remove(name)
+	}
+	media.Attributes = append(media.Attributes, Attribute{Name: dir})
+}
+
+// Copy returns the deep copy of the media.
+func (media *Media) Copy() *Media {
+	cp := *media
+	cp.Formats = append([]string(nil), media.Formats...)
+	cp.Bandwidths = append([]string(nil), media.Bandwidths...)
+	cp.Attributes = append(Attributes(nil), media.Attributes...)
+	if media.Connection != nil {
+		conn := *media.Connection
+		cp.Connection = &conn
+	}
+	return &cp
+}
+
+// Session is the session description.
+type Session struct {
+	Version    int
+	Origin     Origin
+	Name       string
+	Info       string
+	Uri        string
+	Emails     []string
+	Phones     []string
+	Connection *Connection
+	Bandwidths []string
+	Timings    []Timing
+	TimeZones  string
+	Key        string
+	Attributes Attributes
+	Media      []*Media
+}
+
+// Direction returns the direction of the media, given by the media or by the session, sendrecv by default.
+func (session *Session) Direction(media *Media) string {
+	if dir, ok := media.Attributes.direction(); ok {
+		return dir
+	}
+	if dir, ok := session.Attributes.direction(); ok {
+		return dir
+	}
+	return SendRecv
+}
+
+// Copy returns the deep copy of the session description, e.g. to modify it for a new offer.
+func (session *Session) Copy() *Session {
+	cp := *session
+	cp.Emails = append([]string(nil), session.Emails...)
+	cp.Phones = append([]string(nil), session.Phones...)
+	cp.Bandwidths = append([]string(nil), session.Bandwidths...)
+	cp.Attributes = append(Attributes(nil), session.Attributes...)
+	if session.Connection != nil {
+		conn := *session.Connection
+		cp.Connection = &conn
+	}
+	cp.Timings = make([]Timing, len(session.Timings))
+	for i, timing := range session.Timings {
+		timing.Repeats = append([]string(nil), timing.Repeats...)
+		cp.Timings[i] = timing
+	}
+	cp.Media = make([]*Media, len(session.Media))
+	for i, media := range session.Media {
+		cp.Media[i] = media.Copy()
+	}
+	return &cp
+}
+
+// MediaConnection returns the connection data of the media, given by the media or by the session.
+func (session *Session) MediaConnection(media *Media) *Connection {
+	if media.Connection != nil {
+		return media.Connection
+	}
+	return session.Connection
+}
+
+// Parse parses the session description. Lines may end with LF or CRLF.
+func Parse(text string) (*Session, error) {
+	session := &Session{}
+	var media *Media
+	first := true
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		if len(line) < 2 || line[1] != '=' {
+			return nil, fmt.Errorf("invalid session description line %d: '%s'", i+1, line)
+		}
+		kind, value := line[0], line[2:]
+		if first && kind != 'v' {
+			return nil, fmt.Errorf("session description must start with v= line")
+		}
+		first = false
+
+		var err error
+		switch {
+		case kind == 'm':
+			media, err = parseMedia(value)
+			if err == nil {
+				session.Media = append(session.Media, media)
+			}
+		case media != nil:
+			err = media.parseLine(kind, value)
+		default:
+			err = session.parseLine(kind, value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid session description line %d '%s': %s", i+1, line, err)
+		}
+	}
+	if first {
+		return nil, fmt.Errorf("empty session description")
+	}
+	return session, nil
+}
+
+func (session *Session) parseLine(kind byte, value string) error {
+	var err error
+	switch kind {
+	case 'v':
+		session.Version, err = strconv.Atoi(value)
+	case 'o':
+		err = session.Origin.parse(value)
+	case 's':
+		session.Name = value
+	case 'i':
+		session.Info = value
+	case 'u':
+		session.Uri = value
+	case 'e':
+		session.Emails = append(session.Emails, value)
+	case 'p':
+		session.Phones = append(session.Phones, value)
+	case 'c':
+		session.Connection, err = parseConnection(value)
+	case 'b':
+		session.Bandwidths = append(session.Bandwidths, value)
+	case 't':
+		var timing Timing
+		fields := strings.Fields(value)
+		if len(fields) != 2 {
+			return fmt.Errorf("expected start and stop time")
+		}
+		if timing.Start, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+			return err
+		}
+		if timing.Stop, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+			return err
+		}
+		session.Timings = append(session.Timings, timing)
+	case 'r':
+		if len(session.Timings) == 0 {
+			return fmt.Errorf("repeat time without t= line")
+		}
+		last := &session.Timings[len(session.Timings)-1]
+		last.Repeats = append(last.Repeats, value)
+	case 'z':
+		session.TimeZones = value
+	case 'k':
+		session.Key = value
+	case 'a':
+		session.Attributes = append(session.Attributes, parseAttribute(value))
+	default:
+		return fmt.Errorf("unknown line type %c", kind)
+	}
+	return err
+}
+
+func (media *Media) parseLine(kind byte, value string) error {
+	var err error
+	switch kind {
+	case 'i':
+		media.Info = value
+	case 'c':
+		media.Connection, err = parseConnection(value)
+	case 'b':
+		media.Bandwidths = append(media.Bandwidths, value)
+	case 'k':
+		media.Key = value
+	case 'a':
+		media.Attributes = append(media.Attributes, parseAttribute(value))
+	default:
+		return fmt.Errorf("unexpected line type %c in media description", kind)
+	}
+	return err
+}
+
+func (origin *Origin) parse(value string) error {
+	fields := strings.Fields(value)
+	if len(fields) != 6 {
+		return fmt.Errorf("expected 6 fields of origin")
+	}
+	var err error
+	if origin.SessionId, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+		return err
+	}
+	if origin.SessionVersion, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+		return err
+	}
+	origin.Username, origin.NetType, origin.AddrType, origin.Address = fields[0], fields[3], fields[4], fields[5]
+	return nil
+}
+
+func parseConnection(value string) (*Connection, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("expected 3 fields of connection data")
+	}
+	return &Connection{NetType: fields[0], AddrType: fields[1], Address: fields[2]}, nil
+}
+
+func parseMedia(value string) (*Media, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("expected media type, port and protocol")
+	}
+	media := &Media{Type: fields[0], Proto: fields[2], Formats: fields[3:]}
+	port := fields[1]
+	if idx := strings.Index(port, "/"); idx >= 0 {
+		count, err := strconv.Atoi(port[idx+1:])
+		if err != nil {
+			return nil, err
+		}
+		media.PortCount = count
+		port = port[:idx]
+	}
+	var err error
+	if media.Port, err = strconv.Atoi(port); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+func parseAttribute(value string) Attribute {
+	if idx := strings.Index(value, ":"); idx >= 0 {
+		return Attribute{Name: value[:idx], Value: value[idx+1:]}
+	}
+	return Attribute{Name: value}
+}
+
+// String renders the session description with CRLF line endings.
+func (session *Session) String() string {
+	var buffer bytes.Buffer
+	line := func(kind byte, value string) {
+		buffer.WriteByte(kind)
+		buffer.WriteByte('=')
+		buffer.WriteString(value)
+		buffer.WriteString("\r\n")
+	}
+
+	line('v', strconv.Itoa(session.Version))
+	line('o', session.Origin.String())
+	name := session.Name
+	if name == "" {
+		// Session name must not be empty - RFC 4566 5.3.
+		name = "-"
+	}
+	line('s', name)
+	if session.Info != "" {
+		line('i', session.Info)
+	}
+	if session.Uri != "" {
+		line('u', session.Uri)
+	}
+	for _, email := range session.Emails {
+		line('e', email)
+	}
+	for _, phone := range session.Phones {
+		line('p', phone)
+	}
+	if session.Connection != nil {
+		line('c', session.Connection.String())
+	}
+	for _, bandwidth := range session.Bandwidths {
+		line('b', bandwidth)
+	}
+	if len(session.Timings) == 0 {
+		line('t', "0 0")
+	}
+	for _, timing := range session.Timings {
+		line('t', fmt.Sprintf("%d %d", timing.Start, timing.Stop))
+		for _, repeat := range timing.Repeats {
+			line('r', repeat)
+		}
+	}
+	if session.TimeZones != "" {
+		line('z', session.TimeZones)
+	}
+	if session.Key != "" {
+		line('k', session.Key)
+	}
+	for _, attr := range session.Attributes {
+		line('a', attr.String())
+	}
+
+	for _, media := range session.Media {
+		port := strconv.Itoa(media.Port)
+		if media.PortCount > 0 {
+			port += "/" + strconv.Itoa(media.PortCount)
+		}
+		line('m', strings.Join(append([]string{media.Type, port, media.Proto}, media.Formats...), " "))
+		if media.Info != "" {
+			line('i', media.Info)
+		}
+		if media.Connection != nil {
+			line('c', media.Connection.String())
+		}
+		for _, bandwidth := range media.Bandwidths {
+			line('b', bandwidth)
+		}
+		if media.Key != "" {
+			line('k', media.Key)
+		}
+		for _, attr := range media.Attributes {
+			line('a', attr.String())
+		}
+	}
+
+	return buffer.String()
+}
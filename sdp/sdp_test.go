@@ -0,0 +1,126 @@
+package sdp
+
+import (
+	"strings"
+	"testing"
+)
+
+const offerText = "v=0\r\n" +
+	"o=alice 2890844526 2890844526 IN IP4 host.atlanta.example.com\r\n" +
+	"s=-\r\n" +
+	"c=IN IP4 host.atlanta.example.com\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 49170 RTP/AVP 0 8 97\r\n" +
+	"a=rtpmap:0 PCMU/8000\r\n" +
+	"a=rtpmap:8 PCMA/8000\r\n" +
+	"a=rtpmap:97 iLBC/8000\r\n" +
+	"a=fmtp:97 mode=30\r\n" +
+	"a=sendonly\r\n" +
+	"m=video 51372 RTP/AVP 31 32\r\n" +
+	"a=rtpmap:31 H261/90000\r\n" +
+	"a=rtpmap:32 MPV/90000\r\n"
+
+func TestParseAndString(t *testing.T) {
+	session, err := Parse(strings.Replace(offerText, "\r\n", "\n", -1))
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse session description: %s", err)
+	}
+	if session.Origin.Username != "alice" || session.Origin.SessionVersion != 2890844526 {
+		t.Errorf("[FAIL] unexpected origin %s", session.Origin.String())
+	}
+	if len(session.Media) != 2 || session.Media[0].Port != 49170 || len(session.Media[0].Formats) != 3 {
+		t.Fatalf("[FAIL] unexpected media descriptions %v", session.Media)
+	}
+	if encoding, ok := session.Media[0].Rtpmap("97"); !ok || encoding != "iLBC/8000" {
+		t.Errorf("[FAIL] unexpected rtpmap of format 97: %s", encoding)
+	}
+	if dir := session.Direction(session.Media[0]); dir != SendOnly {
+		t.Errorf("[FAIL] expected audio direction %s, got %s", SendOnly, dir)
+	}
+	if conn := session.MediaConnection(session.Media[1]); conn == nil || conn.Address != "host.atlanta.example.com" {
+		t.Errorf("[FAIL] expected video connection inherited from the session, got %v", conn)
+	}
+	if text := session.String(); text != offerText {
+		t.Errorf("[FAIL] session description changed by round trip:\n%s", text)
+	}
+
+	for _, invalid := range []string{"", "o=alice 1 1 IN IP4 host\r\n", "v=0\r\nm=audio x RTP/AVP 0\r\n", "v=0\r\nbroken\r\n"} {
+		if _, err := Parse(invalid); err == nil {
+			t.Errorf("[FAIL] expected error parsing '%s'", invalid)
+		}
+	}
+}
+
+func TestAnswer(t *testing.T) {
+	offer, err := Parse(offerText)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse offer: %s", err)
+	}
+	local := &Session{
+		Origin:     Origin{"bob", 1, 1, "IN", "IP4", "host.biloxi.example.com"},
+		Connection: NewConnection("192.0.2.2"),
+		Media: []*Media{{
+			Type:    "audio",
+			Port:    3456,
+			Proto:   "RTP/AVP",
+			Formats: []string{"98", "0"},
+			Attributes: Attributes{
+				{"rtpmap", "98 iLBC/8000"},
+				{"rtpmap", "0 PCMU/8000"},
+			},
+		}},
+	}
+
+	answer, err := Answer(offer, local)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to answer: %s", err)
+	}
+	if len(answer.Media) != 2 {
+		t.Fatalf("[FAIL] expected every offered stream answered, got %d", len(answer.Media))
+	}
+	audio, video := answer.Media[0], answer.Media[1]
+	if audio.Port != 3456 || strings.Join(audio.Formats, " ") != "97 0" {
+		t.Errorf("[FAIL] expected audio answered with offered payload types in local order, got %v on port %d", audio.Formats, audio.Port)
+	}
+	if fmtp, ok := audio.Fmtp("97"); !ok || fmtp != "mode=30" {
+		t.Errorf("[FAIL] expected offered fmtp of format 97 kept, got '%s'", fmtp)
+	}
+	if dir := answer.Direction(audio); dir != RecvOnly {
+		t.Errorf("[FAIL] expected sendonly offer answered with %s, got %s", RecvOnly, dir)
+	}
+	if video.Port != 0 {
+		t.Errorf("[FAIL] expected unsupported video rejected, got port %d", video.Port)
+	}
+
+	local.Media = local.Media[:0]
+	if _, err := Answer(offer, local); err == nil {
+		t.Errorf("[FAIL] expected error answering without supported streams")
+	}
+}
+
+func TestHoldAndResume(t *testing.T) {
+	offer, err := Parse(offerText)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse offer: %s", err)
+	}
+	offer.Media[0].SetDirection(SendRecv)
+	offer.Media[1].SetDirection(RecvOnly)
+	version := offer.Origin.SessionVersion
+
+	held := offer.Copy()
+	held.Hold()
+	if dirs := []string{held.Direction(held.Media[0]), held.Direction(held.Media[1])}; dirs[0] != SendOnly || dirs[1] != Inactive {
+		t.Errorf("[FAIL] expected sendrecv and recvonly held as sendonly and inactive, got %v", dirs)
+	}
+	if held.Origin.SessionVersion != version+1 {
+		t.Errorf("[FAIL] expected version %d, got %d", version+1, held.Origin.SessionVersion)
+	}
+	if dir := offer.Direction(offer.Media[0]); dir != SendRecv {
+		t.Errorf("[FAIL] expected the original session untouched by the copy, got %s", dir)
+	}
+
+	held.Resume()
+	if dirs := []string{held.Direction(held.Media[0]), held.Direction(held.Media[1])}; dirs[0] != SendRecv || dirs[1] != RecvOnly {
+		t.Errorf("[FAIL] expected resumed sendrecv and recvonly, got %v", dirs)
+	}
+}
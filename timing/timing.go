@@ -1,6 +1,9 @@
 package timing
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 // Controls whether library calls should be mocked, or whether we should use the standard Go time library.
 // If we're in Mock Mode, then time does not pass as normal, but only progresses when Elapse is called.
@@ -9,6 +12,9 @@ var MockMode bool = false
 var currentTimeMock time.Time = time.Unix(0, 0)
 var mockTimers []*mockTimer = make([]*mockTimer, 0)
 
+// mockLock guards the mocked time and timers, which are used from many goroutines.
+var mockLock sync.Mutex
+
 // Interface over Golang's built-in Timers, allowing them to be swapped out for mocked timers.
 type Timer interface {
 	// Returns a channel which sends the current time immediately when the timer expires.
@@ -34,8 +40,13 @@ func (t *realTimer) C() <-chan time.Time {
 }
 
 func (t *realTimer) Reset(d time.Duration) bool {
-	if !t.Timer.Stop() {
-		<-t.Timer.C
+	// Timers created by AfterFunc have no channel, and a fired timer may already have been drained,
+	// so never block here.
+	if !t.Timer.Stop() && t.Timer.C != nil {
+		select {
+		case <-t.Timer.C:
+		default:
+		}
 	}
 	return t.Timer.Reset(d)
 }
@@ -58,6 +69,8 @@ func (t *mockTimer) C() <-chan time.Time {
 }
 
 func (t *mockTimer) Reset(d time.Duration) bool {
+	mockLock.Lock()
+	defer mockLock.Unlock()
 	wasActive := removeMockTimer(t)
 
 	t.EndTime = currentTimeMock.Add(d)
@@ -66,20 +79,33 @@ func (t *mockTimer) Reset(d time.Duration) bool {
 	} else {
 		// The new timer has an expiry time of 0.
 		// Fire it right away, and don't bother tracking it.
-		t.Chan <- currentTimeMock
+		t.fire()
 	}
 
 	return wasActive
 }
 
 func (t *mockTimer) Stop() bool {
+	mockLock.Lock()
+	defer mockLock.Unlock()
 	return removeMockTimer(t)
 }
 
+// fire sends the current time on the channel, replacing the time of the previous expiry if nobody received it.
+func (t *mockTimer) fire() {
+	select {
+	case <-t.Chan:
+	default:
+	}
+	t.Chan <- currentTimeMock
+}
+
 // Creates a new Timer; either a wrapper around a standard Go time.Timer, or a mocked-out Timer,
 // depending on whether MockMode is set.
 func NewTimer(d time.Duration) Timer {
 	if MockMode {
+		mockLock.Lock()
+		defer mockLock.Unlock()
 		t := mockTimer{currentTimeMock.Add(d), make(chan time.Time, 1), false, nil}
 		if d == 0 {
 			t.Chan <- currentTimeMock
@@ -100,6 +126,8 @@ func After(d time.Duration) <-chan time.Time {
 // See built-in time.AfterFunc() function.
 func AfterFunc(d time.Duration, f func()) Timer {
 	if MockMode {
+		mockLock.Lock()
+		defer mockLock.Unlock()
 		t := mockTimer{currentTimeMock.Add(d), make(chan time.Time, 1), false, f}
 		if d == 0 {
 			go f()
@@ -122,6 +150,8 @@ func Sleep(d time.Duration) {
 // This function can only be called in Mock Mode, otherwise we will panic.
 func Elapse(d time.Duration) {
 	requireMockMode()
+	mockLock.Lock()
+	defer mockLock.Unlock()
 	currentTimeMock = currentTimeMock.Add(d)
 
 	// Fire any timers whose time has come up.
@@ -132,13 +162,7 @@ func Elapse(d time.Duration) {
 				go t.toRun()
 			}
 
-			// Clear the channel if something is already in it.
-			select {
-			case <-t.Chan:
-			default:
-			}
-
-			t.Chan <- currentTimeMock
+			t.fire()
 			t.fired = true
 		}
 	}
@@ -159,6 +183,8 @@ func Elapse(d time.Duration) {
 // otherwise it will be the true system time.
 func Now() time.Time {
 	if MockMode {
+		mockLock.Lock()
+		defer mockLock.Unlock()
 		return currentTimeMock
 	} else {
 		return time.Now()
@@ -173,6 +199,7 @@ func requireMockMode() {
 }
 
 // Utility method to remove a mockTimer from the list of outstanding timers.
+// Must be called with mockLock held.
 func removeMockTimer(t *mockTimer) bool {
 	// First, find the index of the timer in our list.
 	found := false
@@ -0,0 +1,80 @@
+package transaction
+
+import (
+	"fmt"
+
+	"github.com/ghettovoice/gossip/base"
+)
+
+// SetRetransmit2xx enables retransmission of 2xx responses to INVITE on behalf of the user - RFC 3261 13.3.1.4, RFC 6026.
+// The INVITE server transaction sending 2xx is kept in the Accepted state, retransmitting the last 2xx over unreliable
+// transports with interval starting at T1 and doubling up to T2, until the ACK of the response is received,
+// and then absorbing retransmissions of INVITE until Timer L.
+// If no ACK is received in Timer L, 64*T1 by default, the error of base.ErrTimeout kind is reported on
+// ServerTransaction.Errors and the user should terminate the dialog.
+// ACK requests are passed up as usual. Can be changed at runtime, see Reload.
+func (mng *Manager) SetRetransmit2xx(retransmit bool) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.Retransmit2xx = retransmit
+}
+
+// acceptedKey identifies the INVITE request acknowledged by ACK, which is a transaction of its own for 2xx.
+func acceptedKey(req *base.Request) (string, error) {
+	callId, err := req.CallId()
+	if err != nil {
+		return "", err
+	}
+	fromTag, err := req.FromTag()
+	if err != nil {
+		return "", err
+	}
+	cseq, err := req.CSeq()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s$%s$%d", callId.String(), fromTag.String(), cseq.SeqNo), nil
+}
+
+// putAccepted registers the transaction waiting for ACK of its 2xx.
+func (mng *Manager) putAccepted(tx *ServerTransaction) {
+	key, err := acceptedKey(tx.origin)
+	if err != nil {
+		tx.Log().Warnf("failed to wait for ACK of transaction %p: %s", tx, err)
+		return
+	}
+	mng.acceptedLock.Lock()
+	defer mng.acceptedLock.Unlock()
+	if mng.accepted == nil {
+		mng.accepted = make(map[string]*ServerTransaction)
+	}
+	mng.accepted[key] = tx
+}
+
+// delAccepted unregisters the transaction, if it's the one registered.
+func (mng *Manager) delAccepted(tx *ServerTransaction) {
+	key, err := acceptedKey(tx.origin)
+	if err != nil {
+		return
+	}
+	mng.acceptedLock.Lock()
+	defer mng.acceptedLock.Unlock()
+	if mng.accepted[key] == tx {
+		delete(mng.accepted, key)
+	}
+}
+
+// acknowledge stops 2xx retransmissions of the transaction the ACK request acknowledges, if any.
+func (mng *Manager) acknowledge(ack *base.Request) {
+	key, err := acceptedKey(ack)
+	if err != nil {
+		return
+	}
+	mng.acceptedLock.Lock()
+	tx, ok := mng.accepted[key]
+	mng.acceptedLock.Unlock()
+	if ok {
+		tx.Log().Debugf("server transaction %p received ACK %s of 2xx", tx, ack.Short())
+		tx.fsm.Spin(server_input_ack)
+	}
+}
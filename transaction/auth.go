@@ -0,0 +1,77 @@
+package transaction
+
+import (
+	"github.com/ghettovoice/gossip/auth"
+	"github.com/ghettovoice/gossip/base"
+)
+
+// SetCredentials enables retrying requests challenged with 401 Unauthorized or 407 Proxy Authentication Required
+// with the credentials of the challenge realm, nil disables it - RFC 3261 22.2, 22.3.
+// The retry is a new client transaction of the request with incremented CSeq;
+// its responses and errors are passed to the channels of the challenged transaction instead of the challenge.
+// A request is retried once, a challenge of the retry or without known credentials is passed up as usual.
+// Can be changed at runtime, see Reload.
+func (mng *Manager) SetCredentials(creds auth.CredentialsLookup) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.Credentials = creds
+}
+
+// Retry returns the client transaction retrying the challenged request with credentials, nil if there is none.
+func (tx *ClientTransaction) Retry() *ClientTransaction {
+	tx.cancelLock.Lock()
+	defer tx.cancelLock.Unlock()
+	return tx.retry
+}
+
+// authorize retries the request challenged by the response, reports whether the retry was sent.
+func (tx *ClientTransaction) authorize(res *base.Response) bool {
+	creds := tx.tm.Config().Credentials
+	if creds == nil || tx.authorized || !auth.IsChallenge(res) || tx.IsAck() {
+		return false
+	}
+	tx.cancelLock.Lock()
+	cancelled := tx.cancelled
+	tx.cancelLock.Unlock()
+	if cancelled {
+		return false
+	}
+
+	hdrs, err := auth.Authorize(tx.origin, res, creds)
+	if err != nil {
+		tx.Log().Infof("client transaction %p can't answer challenge %s: %s", tx, res.Short(), err)
+		return false
+	}
+	req, err := authorizedRequest(tx.origin, hdrs)
+	if err != nil {
+		tx.Log().Warnf("client transaction %p failed to retry request %s: %s", tx, tx.origin.Short(), err)
+		return false
+	}
+
+	tx.Log().Infof("client transaction %p retrying request %s with credentials", tx, tx.origin.Short())
+	retry := tx.tm.send(req, tx.dest, tx, tx.times)
+
+	tx.cancelLock.Lock()
+	tx.retry = retry
+	tx.cancelLock.Unlock()
+	return true
+}
+
+// authorizedRequest copies the request with the new branch, the next CSeq and the authorization headers - RFC 3261 22.2.
+func authorizedRequest(origin *base.Request, hdrs []base.SipHeader) (*base.Request, error) {
+	req := origin.Copy()
+	hop, err := req.ViaHop()
+	if err != nil {
+		return nil, err
+	}
+	hop.Params.Add("branch", base.String{S: base.GenerateBranch()})
+	cseq, err := req.CSeq()
+	if err != nil {
+		return nil, err
+	}
+	cseq.SeqNo++
+	for _, h := range hdrs {
+		req.AddHeader(h)
+	}
+	return req, nil
+}
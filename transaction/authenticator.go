@@ -0,0 +1,135 @@
+package transaction
+
+import (
+	"errors"
+
+	"github.com/ghettovoice/gossip/auth"
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/transport"
+)
+
+// Authenticator verifies digest credentials of new out-of-dialog requests before they are delivered
+// to the application, the requests without valid credentials are answered with the challenge - RFC 3261 22.1.
+// In-dialog requests were authenticated with the request created the dialog, so they pass.
+type Authenticator struct {
+	challenger *auth.Challenger
+	proxy      bool
+	methods    map[base.Method]bool
+	failures   chan AuthFailure
+	policy     FailurePolicy
+}
+
+// AuthFailure is the event of a request failed authentication with wrong or replayed credentials.
+// Requests without credentials or with stale ones are challenged as usual and are not failures.
+type AuthFailure struct {
+	// Source is the host the request was sent from, see transport.SourceHost.
+	Source string
+	// User is the username claimed by the credentials.
+	User string
+	// Reason is the verification error, of auth.ErrUnauthorized or auth.ErrReplayed kind.
+	Reason error
+	// Request failed authentication.
+	Request *base.Request
+}
+
+// FailurePolicy acts on authentication failures, e.g. Lockout banning their sources.
+type FailurePolicy func(failure AuthFailure)
+
+// Capacity of the channel of authentication failures, beyond it the failures are not reported on the channel.
+const authFailuresQueueSize = 100
+
+// NewAuthenticator creates the authenticator challenging the requests of the methods with the challenger,
+// with 407 Proxy Authentication Required if proxy is set, or 401 Unauthorized otherwise.
+// No methods means all of them except ACK and CANCEL, which can't be challenged - RFC 3261 22.1.
+func NewAuthenticator(challenger *auth.Challenger, proxy bool, methods ...base.Method) *Authenticator {
+	authenticator := &Authenticator{
+		challenger: challenger,
+		proxy:      proxy,
+		failures:   make(chan AuthFailure, authFailuresQueueSize),
+	}
+	if len(methods) > 0 {
+		authenticator.methods = make(map[base.Method]bool, len(methods))
+		for _, method := range methods {
+			authenticator.methods[method] = true
+		}
+	}
+	return authenticator
+}
+
+// SetFailurePolicy sets the policy acting on authentication failures, nil disables it.
+// Should be called before the authenticator is set to the manager.
+func (a *Authenticator) SetFailurePolicy(policy FailurePolicy) {
+	a.policy = policy
+}
+
+// Failures returns the channel authentication failures are reported on, e.g. for security logging.
+// The failures are dropped while the channel is full.
+func (a *Authenticator) Failures() <-chan AuthFailure {
+	return a.failures
+}
+
+// Requires reports whether the request must be authenticated.
+func (a *Authenticator) Requires(req *base.Request) bool {
+	if req.IsAck() || req.Method == base.CANCEL || base.IsInDialog(req) {
+		return false
+	}
+	return a.methods == nil || a.methods[req.Method]
+}
+
+// Authenticate verifies the credentials of the request, returns the authenticated username,
+// or the challenge to answer the request with. Stale credentials are challenged with stale=true.
+func (a *Authenticator) Authenticate(req *base.Request) (string, *base.Response) {
+	username, err := a.challenger.Verify(req, a.proxy)
+	if err == nil {
+		return username, nil
+	}
+	req.Log().Infof("request %s is not authenticated: %s", req.Short(), err)
+	if errors.Is(err, auth.ErrUnauthorized) || errors.Is(err, auth.ErrReplayed) {
+		a.fail(AuthFailure{Source: transport.SourceHost(req), User: username, Reason: err, Request: req})
+	}
+	return "", a.challenger.Respond(req, a.proxy, errors.Is(err, auth.ErrStaleNonce))
+}
+
+// fail reports the failure on the channel and to the policy.
+func (a *Authenticator) fail(failure AuthFailure) {
+	select {
+	case a.failures <- failure:
+	default:
+		failure.Request.Log().Debugf("authentication failures queue is full, failure of %s dropped", failure.Request.Short())
+	}
+	if a.policy != nil {
+		a.policy(failure)
+	}
+}
+
+// SetAuthenticator enables authentication of new requests before they are passed to the handlers
+// or the Requests channel, nil disables it. The authenticated username is available via ServerTransaction.User.
+// Can be changed at runtime, see Reload.
+func (mng *Manager) SetAuthenticator(authenticator *Authenticator) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.Authenticator = authenticator
+}
+
+// User returns the username the request was authenticated as by the Authenticator of the manager,
+// empty if the request wasn't authenticated.
+func (tx *ServerTransaction) User() string {
+	return tx.user
+}
+
+// authenticate answers the request of the transaction with the challenge unless it's authenticated,
+// reports whether the request may be delivered.
+func (mng *Manager) authenticate(tx *ServerTransaction) bool {
+	authenticator := mng.Config().Authenticator
+	if authenticator == nil || !authenticator.Requires(tx.origin) {
+		return true
+	}
+	user, challenge := authenticator.Authenticate(tx.origin)
+	if challenge != nil {
+		tx.addToTag(challenge)
+		tx.Respond(challenge)
+		return false
+	}
+	tx.user = user
+	return true
+}
@@ -0,0 +1,163 @@
+package transaction
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/auth"
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/transport"
+)
+
+func TestAuthenticator(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	tp := newDummyTransport()
+	tm, err := NewManager(tp, c_SERVER)
+	assertNoError(t, err)
+	defer tm.Stop()
+
+	store := auth.MemoryHA1Store{"alice": auth.HA1(auth.MD5, "alice", "example.com", "secret")}
+	tm.SetAuthenticator(NewAuthenticator(auth.NewHA1Challenger("example.com", store), false, base.REGISTER))
+
+	newRequest := func(method base.Method, toTag string) *base.Request {
+		to := "To: <sip:alice@example.com>"
+		if toTag != "" {
+			to += ";tag=" + toTag
+		}
+		req, err := request([]string{
+			string(method) + " sip:example.com SIP/2.0",
+			"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+			"From: <sip:alice@example.com>;tag=1",
+			to,
+			"Call-Id: auth1",
+			"CSeq: 1 " + string(method),
+			"",
+			"",
+		}, logger)
+		assertNoError(t, err)
+		return req
+	}
+	delivered := func(expected *base.Request, user string) {
+		t.Helper()
+		select {
+		case tx := <-tm.Requests():
+			if tx.Origin().Short() != expected.Short() || tx.User() != user {
+				t.Errorf("[FAIL] expected %s of user '%s' delivered, got %s of '%s'",
+					expected.Short(), user, tx.Origin().Short(), tx.User())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] request %s was not delivered", expected.Short())
+		}
+	}
+	challenged := func() *base.Response {
+		t.Helper()
+		select {
+		case sent := <-tp.messages:
+			res, ok := sent.msg.(*base.Response)
+			if !ok || res.StatusCode != 401 || len(res.Headers("WWW-Authenticate")) != 1 {
+				t.Fatalf("[FAIL] expected 401 challenge, got %s", sent.msg.Short())
+			}
+			if tag, err := res.ToTag(); err != nil || tag == nil {
+				t.Errorf("[FAIL] expected challenge with To tag")
+			}
+			return res
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] request was not challenged")
+		}
+		return nil
+	}
+
+	// Methods not configured and in-dialog requests pass.
+	options := newRequest(base.OPTIONS, "")
+	tp.toTM <- options
+	delivered(options, "")
+	inDialog := newRequest(base.REGISTER, "2")
+	tp.toTM <- inDialog
+	delivered(inDialog, "")
+
+	register := newRequest(base.REGISTER, "")
+	tp.toTM <- register
+	challenge := challenged()
+
+	hdrs, err := auth.Authorize(register, challenge, auth.Static(auth.Credentials{Username: "alice", Password: "wrong"}))
+	assertNoError(t, err)
+	wrong, err := authorizedRequest(register, hdrs)
+	assertNoError(t, err)
+	tp.toTM <- wrong
+	challenge = challenged()
+
+	hdrs, err = auth.Authorize(register, challenge, auth.Static(auth.Credentials{Username: "alice", Password: "secret"}))
+	assertNoError(t, err)
+	authorized, err := authorizedRequest(register, hdrs)
+	assertNoError(t, err)
+	tp.toTM <- authorized
+	delivered(authorized, "alice")
+}
+
+func TestAuthenticatorLockout(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	tp := newDummyTransport()
+	tm, err := NewManager(tp, c_SERVER)
+	assertNoError(t, err)
+	defer tm.Stop()
+
+	challenger := auth.NewChallenger("example.com", auth.MemoryStore{"alice": "secret"})
+	authenticator := NewAuthenticator(challenger, false)
+	blacklist := transport.NewBlacklist()
+	lockout := NewLockout(blacklist, 2, time.Minute, time.Hour)
+	authenticator.SetFailurePolicy(lockout.Policy())
+	tm.SetAuthenticator(authenticator)
+
+	register, err := request([]string{
+		"REGISTER sip:example.com SIP/2.0",
+		"Via: SIP/2.0/UDP 10.0.0.1:5060;branch=" + base.GenerateBranch(),
+		"From: <sip:alice@example.com>;tag=1",
+		"To: <sip:alice@example.com>",
+		"Call-Id: lockout1",
+		"CSeq: 1 REGISTER",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+	challenge := func(req *base.Request) *base.Response {
+		t.Helper()
+		tp.toTM <- req
+		select {
+		case sent := <-tp.messages:
+			res, ok := sent.msg.(*base.Response)
+			if !ok || res.StatusCode != 401 {
+				t.Fatalf("[FAIL] expected 401 challenge, got %s", sent.msg.Short())
+			}
+			return res
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] request was not challenged")
+		}
+		return nil
+	}
+
+	res := challenge(register)
+	for i := 0; i < 2; i++ {
+		if blacklist.Banned("10.0.0.1") {
+			t.Fatalf("[FAIL] source banned after %d failures", i)
+		}
+		hdrs, err := auth.Authorize(register, res, auth.Static(auth.Credentials{Username: "alice", Password: "guess"}))
+		assertNoError(t, err)
+		guess, err := authorizedRequest(register, hdrs)
+		assertNoError(t, err)
+		res = challenge(guess)
+
+		select {
+		case failure := <-authenticator.Failures():
+			if failure.Source != "10.0.0.1" || failure.User != "alice" || !errors.Is(failure.Reason, auth.ErrUnauthorized) {
+				t.Errorf("[FAIL] unexpected failure %+v", failure)
+			}
+		default:
+			t.Errorf("[FAIL] expected the failure reported")
+		}
+	}
+	if !blacklist.Banned("10.0.0.1") || lockout.Failures("10.0.0.1") != 0 {
+		t.Errorf("[FAIL] expected source banned after 2 failures")
+	}
+}
@@ -0,0 +1,41 @@
+package transaction
+
+import (
+	"github.com/ghettovoice/gossip/base"
+)
+
+// Cancelled returns the channel closed once CANCEL of the request is received before the final response.
+// The transaction user should then answer the request with 487 Request Terminated - RFC 3261 9.2.
+// CANCEL itself is answered by the manager and isn't passed to the transaction user.
+func (tx *ServerTransaction) Cancelled() <-chan struct{} {
+	return tx.cancelled
+}
+
+// cancel signals the cancellation to the transaction user unless the final response is already sent.
+func (tx *ServerTransaction) cancel() {
+	if res := tx.LastResponse(); res != nil && !res.IsProvisional() {
+		tx.Log().Debugf("server transaction %p is already answered with %s, CANCEL has no effect", tx, res.Short())
+		return
+	}
+	tx.cancelOnce.Do(func() {
+		tx.Log().Infof("server transaction %p cancelled", tx)
+		close(tx.cancelled)
+	})
+}
+
+// cancelInvite answers CANCEL matching INVITE server transaction with 200 OK and signals the cancellation
+// of INVITE to its transaction user - RFC 3261 9.2. CANCEL gets its own server transaction
+// to absorb its retransmissions, the response has To tag of the responses to INVITE.
+func (mng *Manager) cancelInvite(req *base.Request, dest string, invite *ServerTransaction) {
+	tx := mng.newServerTx(req, dest)
+	if stored, err := mng.storeServerTx(tx); err != nil {
+		tx.Log().Warnf("failed to store server transaction %p: %s", tx, err)
+	} else if !stored {
+		return
+	}
+	tx.toTag = invite.tag()
+
+	req.Log().Debugf("request %s matches server transaction %p of %s", req.Short(), invite, invite.Origin().Short())
+	tx.Ok()
+	invite.cancel()
+}
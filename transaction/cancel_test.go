@@ -0,0 +1,113 @@
+package transaction
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+// step is the action checking the state of the test directly.
+type step func(test *transactionTest) error
+
+func (actn step) Act(test *transactionTest) error {
+	return actn(test)
+}
+
+// recvStatus receives the response sent to the transport, checks its status and CSeq method.
+func recvStatus(test *transactionTest, code uint16, method base.Method) (*base.Response, error) {
+	select {
+	case sent := <-test.transport.messages:
+		res, ok := sent.msg.(*base.Response)
+		if !ok || res.StatusCode != code {
+			return nil, fmt.Errorf("expected %d response, got %s", code, sent.msg.Short())
+		}
+		if cseq, err := res.CSeq(); err != nil || cseq.MethodName != method {
+			return nil, fmt.Errorf("expected response to %s, got %s", method, res.Short())
+		}
+		return res, nil
+	case <-time.After(time.Second):
+		return nil, fmt.Errorf("timed out waiting for %d response", code)
+	}
+}
+
+// CANCEL matching INVITE is answered with 200 OK and signalled on INVITE transaction, other CANCEL requests
+// are answered with 481 and never reach the transaction user - RFC 3261 9.2.
+func TestServerCancelInvite(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	branch := base.GenerateBranch()
+	req := func(method base.Method, branch string) *base.Request {
+		req, err := request([]string{
+			fmt.Sprintf("%s sip:bob@example.com SIP/2.0", method),
+			"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + branch,
+			"From: <sip:alice@example.com>;tag=1928301774",
+			"To: <sip:bob@example.com>",
+			"Call-Id: cancel",
+			fmt.Sprintf("CSeq: 1 %s", method),
+			"",
+			"",
+		}, logger)
+		assertNoError(t, err)
+		return req
+	}
+	invite, cancel, stray := req(base.INVITE, branch), req(base.CANCEL, branch), req(base.CANCEL, base.GenerateBranch())
+
+	var tx *ServerTransaction
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&transportSend{invite},
+			step(func(test *transactionTest) error {
+				select {
+				case tx = <-test.tm.Requests():
+					return nil
+				case <-time.After(time.Second):
+					return fmt.Errorf("timed out waiting for request")
+				}
+			}),
+			&transportRecv{base.NewResponseFromRequest(invite, 100, "Trying", "")},
+			&transportSend{cancel},
+			step(func(test *transactionTest) error {
+				res, err := recvStatus(test, 200, base.CANCEL)
+				if err != nil {
+					return err
+				}
+				if tag, err := res.ToTag(); err != nil || tag.String() != tx.tag() {
+					return fmt.Errorf("expected To tag %s of INVITE transaction, got %s", tx.tag(), res.Short())
+				}
+				select {
+				case <-tx.Cancelled():
+				case <-time.After(time.Second):
+					return fmt.Errorf("INVITE transaction was not cancelled")
+				}
+				return nil
+			}),
+			// The retransmission of CANCEL is absorbed by its transaction.
+			&transportSend{cancel},
+			step(func(test *transactionTest) error {
+				_, err := recvStatus(test, 200, base.CANCEL)
+				return err
+			}),
+			step(func(test *transactionTest) error {
+				tx.RespondWithStatus(487, "Request Terminated")
+				_, err := recvStatus(test, 487, base.INVITE)
+				return err
+			}),
+			&transportSend{stray},
+			step(func(test *transactionTest) error {
+				if _, err := recvStatus(test, 481, base.CANCEL); err != nil {
+					return err
+				}
+				select {
+				case tx := <-test.tm.Requests():
+					return fmt.Errorf("unexpected request %s passed to transaction user", tx.Origin().Short())
+				case <-time.After(50 * time.Millisecond):
+				}
+				return nil
+			}),
+		}}
+	test.Execute()
+}
@@ -2,11 +2,11 @@ package transaction
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/discoviking/fsm"
 	"github.com/ghettovoice/gossip/base"
-	"github.com/ghettovoice/gossip/timing"
 )
 
 // ClientTransaction describes SIP client transaction.
@@ -16,14 +16,24 @@ type ClientTransaction struct {
 	tu           chan *base.Response // Channel to transaction user.
 	tu_err       chan error          // Channel to report up errors to TU.
 	timer_a_time time.Duration       // Current duration of timer A.
-	timer_a      timing.Timer
-	timer_b      timing.Timer
-	timer_d_time time.Duration // Current duration of timer A.
-	timer_d      timing.Timer
+	timer_d_time time.Duration       // Current duration of timer D.
+	timer_e_time time.Duration       // Current duration of timer E.
+	timer_k_time time.Duration       // Current duration of timer K.
+
+	cancelLock    sync.Mutex
+	cancelPending bool // CANCEL waits for a provisional response - RFC 3261 9.1.
+	cancelled     bool
+	authorized    bool               // The transaction retries a challenged request.
+	retry         *ClientTransaction // Retry of the request challenged by this transaction.
 }
 
 func (tx *ClientTransaction) Delete() {
 	tx.Log().Debugf("deleting transaction %p from manager %p", tx, tx.tm)
+	tx.timers.StopAll()
+	// The retry of the challenged request delivers to the same channels, so it's the one to report termination.
+	if tx.Retry() == nil {
+		tx.terminate()
+	}
 	err := tx.tm.delClientTx(tx)
 	if err != nil {
 		tx.Log().Warn(err)
@@ -38,7 +48,11 @@ func (tx *ClientTransaction) Receive(msg base.SipMessage) {
 		return
 	}
 
+	tx.cancelLock.Lock()
 	tx.lastResp = res
+	sendCancel := tx.cancelPending && res.IsProvisional()
+	tx.cancelPending = false
+	tx.cancelLock.Unlock()
 
 	var input fsm.Input
 	switch {
@@ -51,6 +65,78 @@ func (tx *ClientTransaction) Receive(msg base.SipMessage) {
 	}
 
 	tx.fsm.Spin(input)
+
+	if sendCancel {
+		tx.sendCancel()
+	}
+}
+
+// initTimers starts the timers required by the transaction FSM right after the request was sent.
+func (tx *ClientTransaction) initTimers() {
+	reliable := isReliable(tx.transport, tx.dest, tx.origin)
+	if tx.IsInvite() {
+		tx.initInviteTimers(reliable)
+	} else {
+		tx.initNonInviteTimers(reliable)
+	}
+}
+
+// RFC 3261 - 17.1.1.2.
+func (tx *ClientTransaction) initInviteTimers(reliable bool) {
+	// If an unreliable transport is being used, the client transaction MUST start timer A with a value of T1.
+	// If a reliable transport is being used, the client transaction SHOULD NOT
+	// start timer A (Timer A controls request retransmissions).
+	// Timer A - retransmission
+	if !reliable {
+		tx.Log().Debugf("client transaction %p, timer_a set to %v", tx, tx.times.a)
+		tx.timer_a_time = tx.times.a
+		tx.timers.Start(timer_a, tx.timer_a_time, func() {
+			tx.Log().Debugf("client transaction %p, timer_a fired", tx)
+			tx.fsm.Spin(client_input_timer_a)
+		})
+	}
+	// Timer B - timeout
+	tx.Log().Debugf("client transaction %p, timer_b set to %v", tx, tx.times.b)
+	tx.setDeadline(tx.times.b)
+	tx.timers.Start(timer_b, tx.times.b, func() {
+		tx.Log().Debugf("client transaction %p, timer_b fired", tx)
+		tx.fsm.Spin(client_input_timer_b)
+	})
+
+	// Timer D is set to 32 seconds for unreliable transports, and 0 seconds otherwise.
+	if reliable {
+		tx.timer_d_time = 0
+	} else {
+		tx.timer_d_time = tx.times.d
+	}
+}
+
+// RFC 3261 - 17.1.2.2.
+func (tx *ClientTransaction) initNonInviteTimers(reliable bool) {
+	// If an unreliable transport is in use, the client transaction MUST set timer E to fire in T1 seconds.
+	// Timer E - retransmission
+	if !reliable {
+		tx.Log().Debugf("client transaction %p, timer_e set to %v", tx, tx.times.e)
+		tx.timer_e_time = tx.times.e
+		tx.timers.Start(timer_e, tx.timer_e_time, func() {
+			tx.Log().Debugf("client transaction %p, timer_e fired", tx)
+			tx.fsm.Spin(client_input_timer_e)
+		})
+	}
+	// Timer F - timeout
+	tx.Log().Debugf("client transaction %p, timer_f set to %v", tx, tx.times.f)
+	tx.setDeadline(tx.times.f)
+	tx.timers.Start(timer_f, tx.times.f, func() {
+		tx.Log().Debugf("client transaction %p, timer_f fired", tx)
+		tx.fsm.Spin(client_input_timer_f)
+	})
+
+	// Timer K is set to T4 seconds for unreliable transports, and 0 seconds otherwise.
+	if reliable {
+		tx.timer_k_time = 0
+	} else {
+		tx.timer_k_time = tx.times.k
+	}
 }
 
 // Resend the originating request.
@@ -58,30 +144,43 @@ func (tx *ClientTransaction) resend() {
 	tx.Log().Infof("client transaction %p resending request: %v", tx, tx.origin.Short())
 	err := tx.transport.Send(tx.dest, tx.origin)
 	if err != nil {
+		tx.lastErr = err
 		tx.fsm.Spin(client_input_transport_err)
 	}
 }
 
+// lastResponse returns the most recently received response, which Receive swaps under cancelLock.
+func (tx *ClientTransaction) lastResponse() *base.Response {
+	tx.cancelLock.Lock()
+	defer tx.cancelLock.Unlock()
+	return tx.lastResp
+}
+
 // Pass up the most recently received response to the TU.
 func (tx *ClientTransaction) passUp() {
-	tx.Log().Infof("client transaction %p passing up response: %v", tx, tx.lastResp.Short())
-	tx.tu <- tx.lastResp
+	res := tx.lastResponse()
+	if tx.authorize(res) {
+		return
+	}
+	tx.Log().Infof("client transaction %p passing up response: %v", tx, res.Short())
+	if tx.tm.Config().CopyOnPass {
+		tx.tu <- res.Copy()
+		return
+	}
+	tx.tu <- res
 }
 
 // Send an error to the TU.
 func (tx *ClientTransaction) transportError() {
-	err := "failed to send request"
-	if tx.lastErr != nil {
-		err = tx.lastErr.Error()
-	}
+	err := base.NewError(base.ErrTransport, tx.lastErr, "client transaction %p failed to send request", tx)
 	tx.Log().Infof("client transaction %p had a transport-level error: %s", tx, err)
-	tx.tu_err <- fmt.Errorf("transport error occurred: %s", err)
+	tx.tu_err <- err
 }
 
 // Inform the TU that the transaction timed out.
 func (tx *ClientTransaction) timeoutError() {
 	tx.Log().Infof("client transaction %p timed out", tx)
-	tx.tu_err <- fmt.Errorf("client transaction %p timed out", tx)
+	tx.tu_err <- base.NewError(base.ErrTimeout, nil, "client transaction %p timed out", tx)
 }
 
 // Return the channel we send responses on.
@@ -126,7 +225,7 @@ func (tx *ClientTransaction) ack() {
 	via = via.Copy().(*base.ViaHeader)
 	ack.AddHeader(via)
 	// Copy headers from response.
-	base.CopyHeaders("To", tx.lastResp, ack)
+	base.CopyHeaders("To", tx.lastResponse(), ack)
 
 	// Send the ACK.
 	err = tx.transport.Send(tx.dest, ack)
@@ -137,7 +236,109 @@ func (tx *ClientTransaction) ack() {
 	}
 }
 
+// Ack2xx sends ACK on 2xx response to INVITE of the transaction, returns the sent ACK - RFC 3261 13.2.2.4.
+// Unlike ACK on non-2xx responses, it is end-to-end and sent by the TU to every 2xx response,
+// including the retransmissions arriving on Manager.Responses once the transaction is terminated. The ACK is sent to the next hop of its route set or the Contact
+// of the response, body is the answer to the offer of the response, if any.
+func (tx *ClientTransaction) Ack2xx(res *base.Response, body string) (*base.Request, error) {
+	if !tx.IsInvite() || !res.IsSuccess() {
+		return nil, fmt.Errorf("%s is not 2xx response to INVITE", res.Short())
+	}
+	ack, err := base.NewAckRequest(tx.origin, res)
+	if err != nil {
+		return nil, err
+	}
+	if body != "" {
+		ack.SetBody(body)
+	}
+	dest, err := base.NextHop(ack)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.Log().Infof("sending ACK on %s of client transaction %p to %s", res.Short(), tx, dest)
+	if err := tx.transport.Send(dest, ack); err != nil {
+		return nil, err
+	}
+	return ack, nil
+}
+
 // Cancel sends CANCEL request - RFC 3261 - 9.
+// Only INVITE transactions without a final response can be cancelled.
+// If no provisional response was received yet, CANCEL is sent on the first one.
 func (tx *ClientTransaction) Cancel() {
-	// TODO implement
+	if !tx.IsInvite() {
+		return
+	}
+
+	tx.cancelLock.Lock()
+	if tx.retry != nil {
+		retry := tx.retry
+		tx.cancelLock.Unlock()
+		retry.Cancel()
+		return
+	}
+	if tx.cancelled || (tx.lastResp != nil && !tx.lastResp.IsProvisional()) {
+		tx.cancelLock.Unlock()
+		return
+	}
+	tx.cancelled = true
+	if tx.lastResp == nil {
+		tx.Log().Debugf("client transaction %p delays CANCEL until provisional response", tx)
+		tx.cancelPending = true
+		tx.cancelLock.Unlock()
+		return
+	}
+	tx.cancelLock.Unlock()
+
+	tx.sendCancel()
+}
+
+// abandon gives the transaction up as its context is done, see Manager.SendWithContext.
+func (tx *ClientTransaction) abandon(cause error) {
+	if tx.IsInvite() {
+		tx.Cancel()
+		return
+	}
+	if retry := tx.Retry(); retry != nil {
+		retry.abandon(cause)
+		return
+	}
+	tx.Log().Infof("client transaction %p abandoned: %s", tx, cause)
+	tx.lastErr = cause
+	tx.fsm.Spin(client_input_abandon)
+}
+
+// sendCancel starts CANCEL client transaction, its responses are only logged.
+// The final response to the INVITE, normally 487 Request Terminated, is passed up by the INVITE transaction itself;
+// if it doesn't come in 64*T1, the INVITE transaction is considered cancelled and times out - RFC 3261 9.1.
+func (tx *ClientTransaction) sendCancel() {
+	cancel, err := base.NewCancelRequest(tx.origin)
+	if err != nil {
+		tx.Log().Errorf("failed to send CANCEL request on client transaction %p: %s", tx, err)
+		return
+	}
+
+	tx.Log().Debugf("client transaction %p, timer_cancel set to %v", tx, tx.times.b)
+	tx.timers.Start(timer_cancel, tx.times.b, func() {
+		tx.Log().Debugf("client transaction %p, timer_cancel fired", tx)
+		tx.fsm.Spin(client_input_cancel_timeout)
+	})
+	cancelTx := tx.tm.Send(cancel, tx.dest)
+	go func() {
+		for {
+			select {
+			case res := <-cancelTx.Responses():
+				cancelTx.Log().Debugf("CANCEL of client transaction %p answered with %s", tx, res.Short())
+				if !res.IsProvisional() {
+					return
+				}
+			case err := <-cancelTx.Errors():
+				cancelTx.Log().Warnf("CANCEL of client transaction %p failed: %s", tx, err)
+				return
+			case <-cancelTx.Done():
+				return
+			}
+		}
+	}()
 }
@@ -3,7 +3,6 @@ package transaction
 import (
 	"github.com/discoviking/fsm"
 	"github.com/ghettovoice/gossip/base"
-	"github.com/ghettovoice/gossip/timing"
 )
 
 // SIP Client Transaction FSM
@@ -25,7 +24,12 @@ const (
 	client_input_timer_a
 	client_input_timer_b
 	client_input_timer_d
+	client_input_timer_e
+	client_input_timer_f
+	client_input_timer_k
+	client_input_cancel_timeout
 	client_input_transport_err
+	client_input_abandon
 	client_input_delete
 )
 
@@ -45,12 +49,13 @@ func (tx *ClientTransaction) initInviteFSM() {
 	client_state_def_calling := fsm.State{
 		Index: client_state_calling,
 		Outcomes: map[fsm.Input]fsm.Outcome{
-			client_input_1xx:           {client_state_proceeding, tx.act_passup},
-			client_input_2xx:           {client_state_terminated, tx.act_passup_delete},
-			client_input_300_plus:      {client_state_completed, tx.act_invite_final},
-			client_input_timer_a:       {client_state_calling, tx.act_invite_resend},
-			client_input_timer_b:       {client_state_terminated, tx.act_timeout},
-			client_input_transport_err: {client_state_terminated, tx.act_trans_err},
+			client_input_1xx:            {client_state_proceeding, tx.act_passup},
+			client_input_2xx:            {client_state_terminated, tx.act_passup_delete},
+			client_input_300_plus:       {client_state_completed, tx.act_invite_final},
+			client_input_timer_a:        {client_state_calling, tx.act_invite_resend},
+			client_input_timer_b:        {client_state_terminated, tx.act_timeout},
+			client_input_cancel_timeout: {client_state_calling, fsm.NO_ACTION},
+			client_input_transport_err:  {client_state_terminated, tx.act_trans_err},
 		},
 	}
 
@@ -58,11 +63,12 @@ func (tx *ClientTransaction) initInviteFSM() {
 	client_state_def_proceeding := fsm.State{
 		Index: client_state_proceeding,
 		Outcomes: map[fsm.Input]fsm.Outcome{
-			client_input_1xx:      {client_state_proceeding, tx.act_passup},
-			client_input_2xx:      {client_state_terminated, tx.act_passup_delete},
-			client_input_300_plus: {client_state_completed, tx.act_invite_final},
-			client_input_timer_a:  {client_state_proceeding, fsm.NO_ACTION},
-			client_input_timer_b:  {client_state_proceeding, fsm.NO_ACTION},
+			client_input_1xx:            {client_state_proceeding, tx.act_passup},
+			client_input_2xx:            {client_state_terminated, tx.act_passup_delete},
+			client_input_300_plus:       {client_state_completed, tx.act_invite_final},
+			client_input_timer_a:        {client_state_proceeding, fsm.NO_ACTION},
+			client_input_timer_b:        {client_state_proceeding, fsm.NO_ACTION},
+			client_input_cancel_timeout: {client_state_terminated, tx.act_timeout},
 		},
 	}
 
@@ -70,13 +76,14 @@ func (tx *ClientTransaction) initInviteFSM() {
 	client_state_def_completed := fsm.State{
 		Index: client_state_completed,
 		Outcomes: map[fsm.Input]fsm.Outcome{
-			client_input_1xx:           {client_state_completed, fsm.NO_ACTION},
-			client_input_2xx:           {client_state_completed, fsm.NO_ACTION},
-			client_input_300_plus:      {client_state_completed, tx.act_ack},
-			client_input_transport_err: {client_state_terminated, tx.act_trans_err},
-			client_input_timer_a:       {client_state_completed, fsm.NO_ACTION},
-			client_input_timer_b:       {client_state_completed, fsm.NO_ACTION},
-			client_input_timer_d:       {client_state_terminated, tx.act_delete},
+			client_input_1xx:            {client_state_completed, fsm.NO_ACTION},
+			client_input_2xx:            {client_state_completed, fsm.NO_ACTION},
+			client_input_300_plus:       {client_state_completed, tx.act_ack},
+			client_input_transport_err:  {client_state_terminated, tx.act_trans_err},
+			client_input_timer_a:        {client_state_completed, fsm.NO_ACTION},
+			client_input_timer_b:        {client_state_completed, fsm.NO_ACTION},
+			client_input_timer_d:        {client_state_terminated, tx.act_delete},
+			client_input_cancel_timeout: {client_state_completed, fsm.NO_ACTION},
 		},
 	}
 
@@ -84,13 +91,14 @@ func (tx *ClientTransaction) initInviteFSM() {
 	client_state_def_terminated := fsm.State{
 		Index: client_state_terminated,
 		Outcomes: map[fsm.Input]fsm.Outcome{
-			client_input_1xx:      {client_state_terminated, fsm.NO_ACTION},
-			client_input_2xx:      {client_state_terminated, fsm.NO_ACTION},
-			client_input_300_plus: {client_state_terminated, fsm.NO_ACTION},
-			client_input_timer_a:  {client_state_terminated, fsm.NO_ACTION},
-			client_input_timer_b:  {client_state_terminated, fsm.NO_ACTION},
-			client_input_timer_d:  {client_state_terminated, fsm.NO_ACTION},
-			client_input_delete:   {client_state_terminated, tx.act_delete},
+			client_input_1xx:            {client_state_terminated, fsm.NO_ACTION},
+			client_input_2xx:            {client_state_terminated, fsm.NO_ACTION},
+			client_input_300_plus:       {client_state_terminated, fsm.NO_ACTION},
+			client_input_timer_a:        {client_state_terminated, fsm.NO_ACTION},
+			client_input_timer_b:        {client_state_terminated, fsm.NO_ACTION},
+			client_input_timer_d:        {client_state_terminated, fsm.NO_ACTION},
+			client_input_cancel_timeout: {client_state_terminated, fsm.NO_ACTION},
+			client_input_delete:         {client_state_terminated, tx.act_delete},
 		},
 	}
 
@@ -118,9 +126,10 @@ func (tx *ClientTransaction) initNonInviteFSM() {
 			client_input_1xx:           {client_state_proceeding, tx.act_passup},
 			client_input_2xx:           {client_state_completed, tx.act_non_invite_final},
 			client_input_300_plus:      {client_state_completed, tx.act_non_invite_final},
-			client_input_timer_a:       {client_state_calling, tx.act_non_invite_resend},
-			client_input_timer_b:       {client_state_terminated, tx.act_timeout},
+			client_input_timer_e:       {client_state_calling, tx.act_non_invite_resend},
+			client_input_timer_f:       {client_state_terminated, tx.act_timeout},
 			client_input_transport_err: {client_state_terminated, tx.act_trans_err},
+			client_input_abandon:       {client_state_terminated, tx.act_abandon},
 		},
 	}
 
@@ -131,9 +140,10 @@ func (tx *ClientTransaction) initNonInviteFSM() {
 			client_input_1xx:           {client_state_proceeding, tx.act_passup},
 			client_input_2xx:           {client_state_completed, tx.act_non_invite_final},
 			client_input_300_plus:      {client_state_completed, tx.act_non_invite_final},
-			client_input_timer_a:       {client_state_proceeding, tx.act_non_invite_resend},
-			client_input_timer_b:       {client_state_terminated, tx.act_timeout},
+			client_input_timer_e:       {client_state_proceeding, tx.act_non_invite_proceeding_resend},
+			client_input_timer_f:       {client_state_terminated, tx.act_timeout},
 			client_input_transport_err: {client_state_terminated, tx.act_trans_err},
+			client_input_abandon:       {client_state_terminated, tx.act_abandon},
 		},
 	}
 
@@ -144,9 +154,10 @@ func (tx *ClientTransaction) initNonInviteFSM() {
 			client_input_1xx:      {client_state_completed, fsm.NO_ACTION},
 			client_input_2xx:      {client_state_completed, fsm.NO_ACTION},
 			client_input_300_plus: {client_state_completed, fsm.NO_ACTION},
-			client_input_timer_d:  {client_state_terminated, tx.act_delete},
-			client_input_timer_a:  {client_state_completed, fsm.NO_ACTION},
-			client_input_timer_b:  {client_state_completed, fsm.NO_ACTION},
+			client_input_timer_e:  {client_state_completed, fsm.NO_ACTION},
+			client_input_timer_f:  {client_state_completed, fsm.NO_ACTION},
+			client_input_timer_k:  {client_state_terminated, tx.act_delete},
+			client_input_abandon:  {client_state_completed, fsm.NO_ACTION},
 		},
 	}
 
@@ -157,9 +168,10 @@ func (tx *ClientTransaction) initNonInviteFSM() {
 			client_input_1xx:      {client_state_terminated, fsm.NO_ACTION},
 			client_input_2xx:      {client_state_terminated, fsm.NO_ACTION},
 			client_input_300_plus: {client_state_terminated, fsm.NO_ACTION},
-			client_input_timer_a:  {client_state_terminated, fsm.NO_ACTION},
-			client_input_timer_b:  {client_state_terminated, fsm.NO_ACTION},
-			client_input_timer_d:  {client_state_terminated, fsm.NO_ACTION},
+			client_input_timer_e:  {client_state_terminated, fsm.NO_ACTION},
+			client_input_timer_f:  {client_state_terminated, fsm.NO_ACTION},
+			client_input_timer_k:  {client_state_terminated, fsm.NO_ACTION},
+			client_input_abandon:  {client_state_terminated, fsm.NO_ACTION},
 			client_input_delete:   {client_state_terminated, tx.act_delete},
 		},
 	}
@@ -172,7 +184,7 @@ func (tx *ClientTransaction) initNonInviteFSM() {
 	)
 
 	if err != nil {
-		tx.Log().Errorf("failure to define INVITE client transaction %p fsm: %s", tx, err.Error())
+		tx.Log().Errorf("failure to define non-INVITE client transaction %p fsm: %s", tx, err.Error())
 	}
 
 	tx.fsm = fsm_
@@ -182,19 +194,31 @@ func (tx *ClientTransaction) initNonInviteFSM() {
 func (tx *ClientTransaction) act_invite_resend() fsm.Input {
 	tx.Log().Debugf("client transaction %p, act_invite_resend", tx)
 	tx.timer_a_time *= 2
-	tx.timer_a.Reset(tx.timer_a_time)
+	tx.timers.Reset(timer_a, tx.timer_a_time)
 	tx.resend()
 	return fsm.NO_INPUT
 }
 
 func (tx *ClientTransaction) act_non_invite_resend() fsm.Input {
 	tx.Log().Debugf("client transaction %p, act_non_invite_resend", tx)
-	tx.timer_a_time *= 2
-	// For non-INVITE, cap timer A at T2 seconds.
-	if tx.timer_a_time > T2 {
-		tx.timer_a_time = T2
+	// RFC 3261 - 17.1.2.2.
+	// Timer E is reset with a value of MIN(2*T1, T2), and so on up to T2.
+	tx.timer_e_time *= 2
+	if tx.timer_e_time > tx.times.t2 {
+		tx.timer_e_time = tx.times.t2
 	}
-	tx.timer_a.Reset(tx.timer_a_time)
+	tx.timers.Reset(timer_e, tx.timer_e_time)
+	tx.resend()
+	return fsm.NO_INPUT
+}
+
+func (tx *ClientTransaction) act_non_invite_proceeding_resend() fsm.Input {
+	tx.Log().Debugf("client transaction %p, act_non_invite_proceeding_resend", tx)
+	// RFC 3261 - 17.1.2.2.
+	// If Timer E fires while in the "Proceeding" state, the request MUST be passed to the transport layer
+	// for retransmission, and Timer E MUST be reset with a value of T2 seconds.
+	tx.timer_e_time = tx.times.t2
+	tx.timers.Reset(timer_e, tx.timer_e_time)
 	tx.resend()
 	return fsm.NO_INPUT
 }
@@ -207,12 +231,10 @@ func (tx *ClientTransaction) act_passup() fsm.Input {
 
 func (tx *ClientTransaction) act_invite_final() fsm.Input {
 	tx.Log().Debugf("client transaction %p, act_invite_final", tx)
+	tx.clearDeadline()
 	tx.passUp()
 	tx.ack()
-	if tx.timer_d != nil {
-		tx.timer_d.Stop()
-	}
-	tx.timer_d = timing.AfterFunc(tx.timer_d_time, func() {
+	tx.timers.Start(timer_d, tx.timer_d_time, func() {
 		tx.fsm.Spin(client_input_timer_d)
 	})
 	return fsm.NO_INPUT
@@ -220,12 +242,10 @@ func (tx *ClientTransaction) act_invite_final() fsm.Input {
 
 func (tx *ClientTransaction) act_non_invite_final() fsm.Input {
 	tx.Log().Debugf("client transaction %p, act_non_invite_final", tx)
+	tx.clearDeadline()
 	tx.passUp()
-	if tx.timer_d != nil {
-		tx.timer_d.Stop()
-	}
-	tx.timer_d = timing.AfterFunc(tx.timer_d_time, func() {
-		tx.fsm.Spin(client_input_timer_d)
+	tx.timers.Start(timer_k, tx.timer_k_time, func() {
+		tx.fsm.Spin(client_input_timer_k)
 	})
 	return fsm.NO_INPUT
 }
@@ -236,14 +256,23 @@ func (tx *ClientTransaction) act_ack() fsm.Input {
 	return fsm.NO_INPUT
 }
 
+func (tx *ClientTransaction) act_abandon() fsm.Input {
+	tx.Log().Debugf("client transaction %p, act_abandon", tx)
+	tx.clearDeadline()
+	tx.tu_err <- base.NewError(base.ErrCancelled, tx.lastErr, "client transaction %p abandoned", tx)
+	return client_input_delete
+}
+
 func (tx *ClientTransaction) act_trans_err() fsm.Input {
 	tx.Log().Debugf("client transaction %p, act_trans_err", tx)
+	tx.clearDeadline()
 	tx.transportError()
 	return client_input_delete
 }
 
 func (tx *ClientTransaction) act_timeout() fsm.Input {
 	tx.Log().Debugf("client transaction %p, act_timeout", tx)
+	tx.clearDeadline()
 	// todo send 408 to TU?
 	tx.timeoutError()
 	return client_input_delete
@@ -251,6 +280,7 @@ func (tx *ClientTransaction) act_timeout() fsm.Input {
 
 func (tx *ClientTransaction) act_passup_delete() fsm.Input {
 	tx.Log().Debugf("client transaction %p, act_passup_delete", tx)
+	tx.clearDeadline()
 	tx.passUp()
 	return client_input_delete
 }
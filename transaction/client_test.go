@@ -1,10 +1,16 @@
 package transaction
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/ghettovoice/gossip/auth"
+	"github.com/ghettovoice/gossip/base"
 	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/timing"
 )
 
 var c_SERVER string = "localhost:5060"
@@ -93,3 +99,648 @@ func TestReceiveOK(t *testing.T) {
 		}}
 	test.Execute()
 }
+
+func TestNonInviteTimeout(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	register, err := request([]string{
+		"REGISTER sip:bloggs.com SIP/2.0",
+		"CSeq: 1 REGISTER",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776asdreg",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	// Timer E doubles up to T2, Timer F fires after 64*T1.
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&userSend{register},
+			&transportRecv{register},
+			&wait{500 * time.Millisecond},
+			&transportRecv{register},
+			&wait{1000 * time.Millisecond},
+			&transportRecv{register},
+			&wait{2000 * time.Millisecond},
+			&transportRecv{register},
+			&wait{4000 * time.Millisecond},
+			&transportRecv{register},
+			&wait{4000 * time.Millisecond},
+			&transportRecv{register},
+			&wait{4000 * time.Millisecond},
+			&transportRecv{register},
+			&wait{4000 * time.Millisecond},
+			&transportRecv{register},
+			&wait{4000 * time.Millisecond},
+			&transportRecv{register},
+			&wait{4000 * time.Millisecond},
+			&transportRecv{register},
+			&wait{4000 * time.Millisecond},
+			&transportRecv{register},
+			&wait{500 * time.Millisecond},
+			&userRecvErr{base.ErrTimeout},
+		}}
+	test.Execute()
+}
+
+// Dummy transport reliable over TCP only, like a manager of several protocols.
+type perDestinationTransport struct {
+	*dummyTransport
+}
+
+func (t perDestinationTransport) IsReliableTo(addr string, msg base.SipMessage) bool {
+	hop, err := msg.ViaHop()
+	return err == nil && hop.Transport == "TCP"
+}
+
+// Requests are retransmitted only over the unreliable transport of their destination.
+func TestNonInviteReliableDestination(t *testing.T) {
+	timing.MockMode = true
+	logger := log.WithField("test", t.Name())
+	tp := perDestinationTransport{newDummyTransport()}
+	tm, err := NewManager(tp, c_CLIENT)
+	assertNoError(t, err)
+	defer tm.Stop()
+
+	for _, transport := range []string{"TCP", "UDP"} {
+		register, err := request([]string{
+			"REGISTER sip:bloggs.com SIP/2.0",
+			"CSeq: 1 REGISTER",
+			"Via: SIP/2.0/" + transport + " " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+			"",
+			"",
+		}, logger)
+		assertNoError(t, err)
+
+		tx := tm.Send(register, c_SERVER)
+		expectSent(t, tp.dummyTransport, register)
+		timing.Elapse(Timer_E)
+		select {
+		case sent := <-tp.messages:
+			if transport == "TCP" {
+				t.Errorf("[FAIL] unexpected retransmission over reliable %s: %s", transport, sent.msg.Short())
+			}
+		case <-time.After(100 * time.Millisecond):
+			if transport == "UDP" {
+				t.Errorf("[FAIL] expected retransmission over unreliable %s", transport)
+			}
+		}
+		tx.Delete()
+	}
+}
+
+func TestNonInviteProceedingResend(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	register, err := request([]string{
+		"REGISTER sip:bloggs.com SIP/2.0",
+		"CSeq: 1 REGISTER",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776asdprc",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	trying, err := response([]string{
+		"SIP/2.0 100 Trying",
+		"CSeq: 1 REGISTER",
+		"Via: SIP/2.0/UDP " + c_SERVER + ";branch=z9hG4bK776asdprc",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	ok, err := response([]string{
+		"SIP/2.0 200 OK",
+		"CSeq: 1 REGISTER",
+		"Via: SIP/2.0/UDP " + c_SERVER + ";branch=z9hG4bK776asdprc",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	// In the Proceeding state Timer E is reset with T2, and it stops once a final response arrives.
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&userSend{register},
+			&transportRecv{register},
+			&transportSend{trying},
+			&userRecv{trying},
+			&wait{500 * time.Millisecond},
+			&transportRecv{register},
+			&wait{2000 * time.Millisecond},
+			&transportNoRecv{},
+			&wait{2000 * time.Millisecond},
+			&transportRecv{register},
+			&transportSend{ok},
+			&userRecv{ok},
+			&wait{4000 * time.Millisecond},
+			&transportNoRecv{},
+		}}
+	test.Execute()
+}
+
+func TestNonInviteTimeRemaining(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	register, err := request([]string{
+		"REGISTER sip:bloggs.com SIP/2.0",
+		"CSeq: 1 REGISTER",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776asdrem",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	ok, err := response([]string{
+		"SIP/2.0 200 OK",
+		"CSeq: 1 REGISTER",
+		"Via: SIP/2.0/UDP " + c_SERVER + ";branch=z9hG4bK776asdrem",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&userSend{register},
+			&transportRecv{register},
+			&txRemaining{Timer_F},
+			&wait{500 * time.Millisecond},
+			&transportRecv{register},
+			&txRemaining{Timer_F - 500*time.Millisecond},
+			&transportSend{ok},
+			&userRecv{ok},
+			&txRemaining{0},
+		}}
+	test.Execute()
+}
+
+type userCancel struct{}
+
+func (actn *userCancel) Act(test *transactionTest) error {
+	test.t.Logf("Transaction User cancelling transaction %p", test.lastTx)
+	test.lastTx.Cancel()
+	return nil
+}
+
+func cancelMessages(t *testing.T, logger log.Logger, final string) (*base.Request, *base.Response, *base.Request, *base.Response, *base.Response) {
+	invite, err := request([]string{
+		"INVITE sip:joe@bloggs.com SIP/2.0",
+		"CSeq: 1 INVITE",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776ascncl",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+	ringing, err := response([]string{
+		"SIP/2.0 180 Ringing",
+		"CSeq: 1 INVITE",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776ascncl",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+	cancel, err := base.NewCancelRequest(invite)
+	assertNoError(t, err)
+	cancelOk, err := response([]string{
+		"SIP/2.0 200 OK",
+		"CSeq: 1 CANCEL",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776ascncl",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+	terminated, err := response([]string{
+		"SIP/2.0 " + final,
+		"CSeq: 1 INVITE",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776ascncl",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+	return invite, ringing, cancel, cancelOk, terminated
+}
+
+// CANCEL is delayed until the provisional response, the 487 of the INVITE is passed up - RFC 3261 9.1.
+func TestCancelInvite(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	invite, ringing, cancel, cancelOk, terminated := cancelMessages(t, logger, "487 Request Terminated")
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&userSend{invite},
+			&transportRecv{invite},
+			&userCancel{},
+			&transportNoRecv{},
+			&transportSend{ringing},
+			&userRecv{ringing},
+			&transportRecv{cancel},
+			&transportSend{cancelOk},
+			&transportSend{terminated},
+			&userRecv{terminated},
+		}}
+	test.Execute()
+}
+
+// INVITE without the final response in 64*T1 after CANCEL is considered cancelled - RFC 3261 9.1.
+func TestCancelInviteTimeout(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	invite, ringing, cancel, cancelOk, _ := cancelMessages(t, logger, "487 Request Terminated")
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&userSend{invite},
+			&transportRecv{invite},
+			&transportSend{ringing},
+			&userRecv{ringing},
+			&userCancel{},
+			&transportRecv{cancel},
+			&transportSend{cancelOk},
+			&wait{Timer_B},
+			&userRecvErr{base.ErrTimeout},
+		}}
+	test.Execute()
+}
+
+type userRecvCopy struct {
+	sent *base.Response
+}
+
+func (actn *userRecvCopy) Act(test *transactionTest) error {
+	test.tm.SetCopyOnPass(true)
+	test.transport.toTM <- actn.sent
+	select {
+	case res := <-test.lastTx.Responses():
+		if res == actn.sent || res.String() != actn.sent.String() {
+			return fmt.Errorf("expected copy of response:\n%s\ngot:\n%s", actn.sent.String(), res.String())
+		}
+		return nil
+	case <-time.After(time.Second):
+		return fmt.Errorf("timed out waiting for response")
+	}
+}
+
+func TestCopyOnPass(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	register, err := request([]string{
+		"REGISTER sip:bloggs.com SIP/2.0",
+		"CSeq: 1 REGISTER",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776ascopy",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+	ok, err := response([]string{
+		"SIP/2.0 200 OK",
+		"CSeq: 1 REGISTER",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776ascopy",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&userSend{register},
+			&transportRecv{register},
+			&userRecvCopy{ok},
+		}}
+	test.Execute()
+}
+
+type setCredentials struct {
+	cred auth.Credentials
+}
+
+func (actn *setCredentials) Act(test *transactionTest) error {
+	test.tm.SetCredentials(auth.Static(actn.cred))
+	return nil
+}
+
+// transportRecvAuthorized checks the retry of the challenged request and answers it.
+type transportRecvAuthorized struct {
+	challenged *base.Request
+	status     uint16
+}
+
+func (actn *transportRecvAuthorized) Act(test *transactionTest) error {
+	select {
+	case msg := <-test.transport.messages:
+		req, ok := msg.msg.(*base.Request)
+		if !ok {
+			return fmt.Errorf("unexpected message arrived at transport:\n%s", msg.msg.String())
+		}
+		cseq, _ := req.CSeq()
+		origCSeq, _ := actn.challenged.CSeq()
+		hop, _ := req.ViaHop()
+		origHop, _ := actn.challenged.ViaHop()
+		if cseq.SeqNo != origCSeq.SeqNo+1 || hop.String() == origHop.String() || len(req.Headers("Authorization")) != 1 {
+			return fmt.Errorf("unexpected retry of the challenged request:\n%s", req.String())
+		}
+		test.transport.toTM <- base.NewResponseFromRequest(req, actn.status, "Response", "")
+		return nil
+	case <-time.After(time.Second):
+		return fmt.Errorf("timed out waiting for message at transport")
+	}
+}
+
+type userRecvStatus struct {
+	status uint16
+}
+
+func (actn *userRecvStatus) Act(test *transactionTest) error {
+	select {
+	case res := <-test.lastTx.Responses():
+		if res.StatusCode != actn.status {
+			return fmt.Errorf("unexpected response:\n%s", res.String())
+		}
+		return nil
+	case <-time.After(time.Second):
+		return fmt.Errorf("timed out waiting for response")
+	}
+}
+
+func TestRetryChallenged(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	register, err := request([]string{
+		"REGISTER sip:bloggs.com SIP/2.0",
+		"CSeq: 1 REGISTER",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776asauth",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+	challenge := base.NewResponseFromRequest(register, 401, "Unauthorized", "")
+	challenge.AddHeader(&base.GenericHeader{
+		HeaderName: "WWW-Authenticate",
+		Contents:   `Digest realm="bloggs.com", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", qop="auth"`,
+	})
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&setCredentials{auth.Credentials{Username: "joe", Password: "secret"}},
+			&userSend{register},
+			&transportRecv{register},
+			&transportSend{challenge},
+			&transportRecvAuthorized{register, 200},
+			&userRecvStatus{200},
+		}}
+	test.Execute()
+}
+
+type userAck2xx struct {
+	res *base.Response
+}
+
+func (actn *userAck2xx) Act(test *transactionTest) error {
+	_, err := test.lastTx.Ack2xx(actn.res, "")
+	return err
+}
+
+type transportRecvAck struct {
+	addr string
+}
+
+func (actn *transportRecvAck) Act(test *transactionTest) error {
+	select {
+	case msg := <-test.transport.messages:
+		ack, ok := msg.msg.(*base.Request)
+		if !ok || !ack.IsAck() || msg.addr != actn.addr {
+			return fmt.Errorf("expected ACK to %s, got %s to %s", actn.addr, msg.msg.Short(), msg.addr)
+		}
+		return nil
+	case <-time.After(time.Second):
+		return fmt.Errorf("timed out waiting for ACK at transport")
+	}
+}
+
+func TestAck2xx(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	invite, err := request([]string{
+		"INVITE sip:joe@bloggs.com SIP/2.0",
+		"CSeq: 1 INVITE",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776ack2xx",
+		"From: <sip:alice@example.com>;tag=1",
+		"To: <sip:joe@bloggs.com>",
+		"Call-Id: ack2xx",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	ok, err := response([]string{
+		"SIP/2.0 200 OK",
+		"CSeq: 1 INVITE",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776ack2xx",
+		"From: <sip:alice@example.com>;tag=1",
+		"To: <sip:joe@bloggs.com>;tag=2",
+		"Call-Id: ack2xx",
+		"Contact: <sip:joe@192.0.2.4:5070>",
+		"Record-Route: <sip:proxy.bloggs.com;lr>",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&userSend{invite},
+			&transportRecv{invite},
+			&transportSend{ok},
+			&userRecv{ok},
+			&userAck2xx{ok},
+			&transportRecvAck{"proxy.bloggs.com:5060"},
+		}}
+	test.Execute()
+}
+
+type txDone struct {
+	done bool
+}
+
+func (actn *txDone) Act(test *transactionTest) error {
+	select {
+	case <-test.lastTx.Done():
+		if !actn.done {
+			return fmt.Errorf("transaction terminated prematurely")
+		}
+		return nil
+	case <-time.After(100 * time.Millisecond):
+		if actn.done {
+			return fmt.Errorf("timed out waiting for transaction termination")
+		}
+		return nil
+	}
+}
+
+func TestDone(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	register, err := request([]string{
+		"REGISTER sip:bloggs.com SIP/2.0",
+		"CSeq: 1 REGISTER",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776asdone",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+	ok := base.NewResponseFromRequest(register, 200, "OK", "")
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&userSend{register},
+			&transportRecv{register},
+			&transportSend{ok},
+			&userRecvStatus{200},
+			&txDone{false},
+			&wait{Timer_K},
+			&txDone{true},
+		}}
+	test.Execute()
+}
+
+type retryRespond struct {
+	status uint16
+}
+
+func (actn *retryRespond) Act(test *transactionTest) error {
+	retry := test.lastTx.Retry()
+	if retry == nil {
+		return fmt.Errorf("challenged request was not retried")
+	}
+	test.transport.toTM <- base.NewResponseFromRequest(retry.Origin(), actn.status, "Response", "")
+	return nil
+}
+
+func TestDoneAfterRetry(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	register, err := request([]string{
+		"REGISTER sip:bloggs.com SIP/2.0",
+		"CSeq: 1 REGISTER",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776asdoneauth",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+	challenge := base.NewResponseFromRequest(register, 401, "Unauthorized", "")
+	challenge.AddHeader(&base.GenericHeader{
+		HeaderName: "WWW-Authenticate",
+		Contents:   `Digest realm="bloggs.com", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", qop="auth"`,
+	})
+
+	// The challenged transaction ends with timer K as well, but the retry still delivers to its channels.
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&setCredentials{auth.Credentials{Username: "joe", Password: "secret"}},
+			&userSend{register},
+			&transportRecv{register},
+			&transportSend{challenge},
+			&transportRecvAuthorized{register, 100},
+			&userRecvStatus{100},
+			&wait{Timer_K},
+			&txDone{false},
+			&retryRespond{200},
+			&userRecvStatus{200},
+			&wait{Timer_K},
+			&txDone{true},
+		}}
+	test.Execute()
+}
+
+type userSendWithContext struct {
+	ctx context.Context
+	msg *base.Request
+}
+
+func (actn *userSendWithContext) Act(test *transactionTest) error {
+	test.t.Logf("Transaction User sending message with context:\n%v", actn.msg.String())
+	tx, err := test.tm.SendWithContext(actn.ctx, actn.msg, c_SERVER)
+	if err != nil {
+		return err
+	}
+	test.lastTx = tx
+	return nil
+}
+
+type userCancelContext struct {
+	cancel context.CancelFunc
+}
+
+func (actn *userCancelContext) Act(test *transactionTest) error {
+	actn.cancel()
+	return nil
+}
+
+// Non-INVITE request is no longer retransmitted once its context is done.
+func TestSendWithContext(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	register, err := request([]string{
+		"REGISTER sip:bloggs.com SIP/2.0",
+		"CSeq: 1 REGISTER",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776asctx",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&userSendWithContext{ctx, register},
+			&transportRecv{register},
+			&userCancelContext{cancel},
+			&userRecvErr{context.Canceled},
+			&txDone{true},
+			&wait{Timer_E},
+			&transportNoRecv{},
+		}}
+	test.Execute()
+
+	if _, err := test.tm.SendWithContext(ctx, register, c_SERVER); !errors.Is(err, base.ErrCancelled) {
+		t.Errorf("[FAIL] expected request with done context not sent, got error %v", err)
+	}
+}
+
+// INVITE is cancelled with CANCEL once its context is done, the 487 is passed up - RFC 3261 9.1.
+func TestSendInviteWithContext(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	invite, ringing, cancel, cancelOk, terminated := cancelMessages(t, logger, "487 Request Terminated")
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&userSendWithContext{ctx, invite},
+			&transportRecv{invite},
+			&transportSend{ringing},
+			&userRecv{ringing},
+			&userCancelContext{cancelCtx},
+			&transportRecv{cancel},
+			&transportSend{cancelOk},
+			&transportSend{terminated},
+			&userRecv{terminated},
+		}}
+	test.Execute()
+}
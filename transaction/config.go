@@ -0,0 +1,258 @@
+package transaction
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ghettovoice/gossip/auth"
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+// Timers are the base values of the transaction timers - RFC 3261 17, table 4.
+// The other timers are derived from them, Timer D is set on its own since it doesn't depend on T1.
+// Zero T1, T2 and T4 mean the package constants T1, T2, T4 and Timer_A to Timer_L, zero D means Timer_D.
+// E.g. low-latency LANs may use T1 below 500ms, high-latency satellite links T1 and T2 of several seconds.
+type Timers struct {
+	T1 time.Duration
+	T2 time.Duration
+	T4 time.Duration
+	// D is the wait time for response retransmissions of INVITE client transaction over unreliable transports.
+	D time.Duration
+}
+
+// timerValues are the timers of a transaction, fixed when it is created,
+// so the transactions in progress keep their timers when the manager is reloaded.
+type timerValues struct {
+	t2 time.Duration
+	a  time.Duration
+	b  time.Duration
+	d  time.Duration
+	e  time.Duration
+	f  time.Duration
+	k  time.Duration
+	g  time.Duration
+	h  time.Duration
+	i  time.Duration
+	j  time.Duration
+	l  time.Duration
+}
+
+func (timers Timers) values() timerValues {
+	values := timers.baseValues()
+	if timers.D > 0 {
+		values.d = timers.D
+	}
+	return values
+}
+
+func (timers Timers) baseValues() timerValues {
+	if timers.isDefault() {
+		return timerValues{T2, Timer_A, Timer_B, Timer_D, Timer_E, Timer_F, Timer_K, Timer_G, Timer_H, Timer_I, Timer_J, Timer_L}
+	}
+	return timerValues{
+		t2: timers.T2,
+		a:  timers.T1,
+		b:  64 * timers.T1,
+		d:  Timer_D,
+		e:  timers.T1,
+		f:  64 * timers.T1,
+		k:  timers.T4,
+		g:  timers.T1,
+		h:  64 * timers.T1,
+		i:  timers.T4,
+		j:  64 * timers.T1,
+		l:  64 * timers.T1,
+	}
+}
+
+// isDefault reports whether the base values are the package ones.
+func (timers Timers) isDefault() bool {
+	return timers.T1 == 0 && timers.T2 == 0 && timers.T4 == 0
+}
+
+// Validate checks the timers can be used by transactions.
+func (timers Timers) Validate() error {
+	if !timers.isDefault() {
+		if timers.T1 <= 0 || timers.T4 <= 0 {
+			return fmt.Errorf("invalid timers T1 %v, T4 %v: must be positive", timers.T1, timers.T4)
+		}
+		if timers.T2 < timers.T1 {
+			return fmt.Errorf("invalid timer T2 %v: must not be less than T1 %v", timers.T2, timers.T1)
+		}
+	}
+	if timers.D < 0 {
+		return fmt.Errorf("invalid timer D %v: must not be negative", timers.D)
+	}
+	return nil
+}
+
+// Config is the part of the manager configuration that can be swapped at runtime by Reload.
+// The zero Config is the configuration of a new manager.
+type Config struct {
+	// Timers of the new transactions.
+	Timers Timers
+	// MaxServerTransactions and Overload are set by SetMaxServerTransactions.
+	MaxServerTransactions int
+	Overload              OverloadPolicy
+	// MaxServerTransactionsPerSource is set by SetMaxServerTransactionsPerSource.
+	MaxServerTransactionsPerSource int
+	// MaxMessageSize is set by SetMaxMessageSize.
+	MaxMessageSize int
+	// ResponseDeadlines by method are set by SetResponseDeadline.
+	ResponseDeadlines map[base.Method]ResponseDeadline
+	// OverloadRetryAfter is set by SetOverloadRetryAfter.
+	OverloadRetryAfter time.Duration
+	// Admission is set by SetAdmissionPolicy.
+	Admission AdmissionPolicy
+	// Router is set by SetRouter.
+	Router Router
+	// Credentials are set by SetCredentials.
+	Credentials auth.CredentialsLookup
+	// Budget is set by SetResourceBudget.
+	Budget ResourceBudget
+	// Priority is set by SetPriorityPolicy.
+	Priority PriorityPolicy
+	// Sanitizer is set by SetSanitizer.
+	Sanitizer *base.Sanitizer
+	// Dialogs and DialogOverride are set by SetDialogValidation.
+	Dialogs        base.DialogLookup
+	DialogOverride DialogOverride
+	// RejectStray is set by SetRejectStray.
+	RejectStray bool
+	// Schemes is set by SetSchemePolicy.
+	Schemes SchemePolicy
+	// ReadinessChecks are added by AddReadinessCheck.
+	ReadinessChecks []ReadinessCheck
+	// CopyOnPass is set by SetCopyOnPass.
+	CopyOnPass bool
+	// OwnHops is set by SetLoopDetection.
+	OwnHops base.HopMatcher
+	// History and Looping are set by SetRequestHistory.
+	History *RequestHistory
+	Looping int
+	// Stateless is set by SetStatelessHandler.
+	Stateless StatelessHandler
+	// Notify and PendingSubscriptions are set by SetNotifyPolicy.
+	Notify               NotifyPolicy
+	PendingSubscriptions SubscriptionMatcher
+	// Authenticator is set by SetAuthenticator.
+	Authenticator *Authenticator
+	// Retransmit2xx is set by SetRetransmit2xx.
+	Retransmit2xx bool
+	// Throttle is set by SetThrottlePolicy.
+	Throttle ThrottlePolicy
+	// DetectMerged is set by SetMergedRequestDetection.
+	DetectMerged bool
+}
+
+// Validate checks the configuration can be applied.
+func (cfg Config) Validate() error {
+	if err := cfg.Timers.Validate(); err != nil {
+		return err
+	}
+	if cfg.MaxServerTransactions < 0 {
+		return fmt.Errorf("invalid server transactions limit %d", cfg.MaxServerTransactions)
+	}
+	if cfg.MaxServerTransactionsPerSource < 0 {
+		return fmt.Errorf("invalid server transactions limit per source %d", cfg.MaxServerTransactionsPerSource)
+	}
+	if cfg.MaxMessageSize < 0 {
+		return fmt.Errorf("invalid message size limit %d", cfg.MaxMessageSize)
+	}
+	if cfg.OverloadRetryAfter < 0 {
+		return fmt.Errorf("invalid overload Retry-After %v", cfg.OverloadRetryAfter)
+	}
+	for method, deadline := range cfg.ResponseDeadlines {
+		if err := deadline.Validate(); err != nil {
+			return fmt.Errorf("%s: %s", method, err)
+		}
+	}
+	if cfg.Overload != OverloadDrop && cfg.Overload != OverloadReject {
+		return fmt.Errorf("unknown overload policy %d", cfg.Overload)
+	}
+	if cfg.Looping < 0 {
+		return fmt.Errorf("invalid looping threshold %d", cfg.Looping)
+	}
+	if cfg.Notify != NotifyDeliver && cfg.Notify != NotifyReject {
+		return fmt.Errorf("unknown NOTIFY policy %d", cfg.Notify)
+	}
+	if cfg.Schemes != SchemeReject && cfg.Schemes != SchemePass {
+		return fmt.Errorf("unknown scheme policy %d", cfg.Schemes)
+	}
+	if err := cfg.Budget.Validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ReloadHook applies the configuration being reloaded to a component outside the manager,
+// e.g. limits of admission.Controller or auth.Challenger of a new realm.
+// It is called again with the previous configuration if the reload is rolled back.
+type ReloadHook func(cfg Config) error
+
+// OnReload adds the hook called on every Reload, the hooks are called in the order they were added.
+func (mng *Manager) OnReload(hook ReloadHook) {
+	mng.reloadLock.Lock()
+	defer mng.reloadLock.Unlock()
+	mng.reloadHooks = append(mng.reloadHooks, hook)
+}
+
+// Config returns the current configuration.
+func (mng *Manager) Config() Config {
+	mng.configLock.RLock()
+	defer mng.configLock.RUnlock()
+	return mng.cfg
+}
+
+// lockConfig orders the change of a setting with Reload, so neither loses the other
+// and the hooks rolled back never miss a change applied meanwhile.
+// The setters can't be called from the reload hooks.
+func (mng *Manager) lockConfig() {
+	mng.reloadLock.Lock()
+	mng.configLock.Lock()
+}
+
+func (mng *Manager) unlockConfig() {
+	mng.configLock.Unlock()
+	mng.reloadLock.Unlock()
+}
+
+// Reload validates the configuration, applies it by the reload hooks and swaps the configuration of the manager at once,
+// so limits, routes and credentials are tuned without dropping calls. The transport and the transactions in progress
+// are kept, the new timers apply to the new transactions.
+// If the configuration is invalid nothing is changed. If a hook fails, the hooks called so far, including the failed one,
+// are called with the previous configuration, which is kept.
+// The setters called meanwhile wait for the reload and apply to the configuration it leaves.
+func (mng *Manager) Reload(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	mng.reloadLock.Lock()
+	defer mng.reloadLock.Unlock()
+
+	prev := mng.Config()
+	for idx, hook := range mng.reloadHooks {
+		if err := hook(cfg); err != nil {
+			log.Warnf("transaction manager reload failed: %s, rolling back", err)
+			mng.rollback(prev, idx)
+			return fmt.Errorf("reload failed: %s", err)
+		}
+	}
+
+	mng.configLock.Lock()
+	mng.cfg = cfg
+	mng.configLock.Unlock()
+	log.Infof("transaction manager configuration reloaded")
+	return nil
+}
+
+// rollback calls the hooks up to the index with the previous configuration in reverse order.
+func (mng *Manager) rollback(prev Config, last int) {
+	for idx := last; idx >= 0; idx-- {
+		if err := mng.reloadHooks[idx](prev); err != nil {
+			log.Errorf("failed to roll back transaction manager configuration: %s", err)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package transaction
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/timing"
+)
+
+type reload struct {
+	cfg Config
+}
+
+func (actn *reload) Act(test *transactionTest) error {
+	return test.tm.Reload(actn.cfg)
+}
+
+func TestReloadTimers(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	register, err := request([]string{
+		"REGISTER sip:test.com SIP/2.0",
+		"CSeq: 1 REGISTER",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776reload",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&reload{Config{Timers: Timers{T1: 100 * time.Millisecond, T2: time.Second, T4: time.Second}}},
+			&userSend{register},
+			&transportRecv{register},
+			&txRemaining{64 * 100 * time.Millisecond},
+			&wait{100 * time.Millisecond},
+			&transportRecv{register},
+		}}
+	test.Execute()
+}
+
+func TestReload(t *testing.T) {
+	tm, err := NewManager(newDummyTransport(), c_CLIENT)
+	assertNoError(t, err)
+	defer tm.Stop()
+	tm.SetMaxServerTransactions(10, OverloadReject)
+
+	for _, cfg := range []Config{
+		{MaxServerTransactions: -1},
+		{Overload: OverloadPolicy(7)},
+		{Schemes: SchemePolicy(7)},
+		{Looping: -1},
+		{Notify: NotifyPolicy(7)},
+		{MaxServerTransactionsPerSource: -1},
+		{OverloadRetryAfter: -time.Second},
+		{MaxMessageSize: -1},
+		{ResponseDeadlines: map[base.Method]ResponseDeadline{base.INVITE: {}}},
+		{Timers: Timers{T1: time.Second, T2: 500 * time.Millisecond, T4: time.Second}},
+		{Timers: Timers{T2: time.Second}},
+	} {
+		if err := tm.Reload(cfg); err == nil {
+			t.Errorf("[FAIL] expected invalid configuration %+v rejected", cfg)
+		}
+	}
+	if tm.Config().MaxServerTransactions != 10 {
+		t.Errorf("[FAIL] expected the configuration kept, got %+v", tm.Config())
+	}
+
+	var applied []string
+	limit := 0
+	tm.OnReload(func(cfg Config) error {
+		limit = cfg.MaxServerTransactions
+		applied = append(applied, fmt.Sprintf("limits %d", cfg.MaxServerTransactions))
+		return nil
+	})
+	tm.OnReload(func(cfg Config) error {
+		applied = append(applied, fmt.Sprintf("routes %d", cfg.MaxServerTransactions))
+		if cfg.MaxServerTransactions > 100 {
+			return fmt.Errorf("too many transactions")
+		}
+		return nil
+	})
+
+	if err := tm.Reload(Config{MaxServerTransactions: 200}); err == nil {
+		t.Errorf("[FAIL] expected the reload failed")
+	}
+	expected := fmt.Sprint([]string{"limits 200", "routes 200", "routes 10", "limits 10"})
+	if fmt.Sprint(applied) != expected {
+		t.Errorf("[FAIL] expected hooks %s, got %v", expected, applied)
+	}
+	if limit != 10 || tm.Config().MaxServerTransactions != 10 {
+		t.Errorf("[FAIL] expected the configuration rolled back, got %d, %+v", limit, tm.Config())
+	}
+
+	applied = nil
+	if err := tm.Reload(Config{MaxServerTransactions: 50, Overload: OverloadDrop}); err != nil {
+		t.Fatalf("[FAIL] unexpected error: %s", err)
+	}
+	if limit != 50 || tm.Config().MaxServerTransactions != 50 || tm.Config().Overload != OverloadDrop {
+		t.Errorf("[FAIL] expected the configuration reloaded, got %d, %+v", limit, tm.Config())
+	}
+}
+
+// The setting changed during the reload applies to the reloaded configuration, not to the replaced one.
+func TestReloadSetter(t *testing.T) {
+	tm, err := NewManager(newDummyTransport(), c_CLIENT)
+	assertNoError(t, err)
+	defer tm.Stop()
+
+	reloading, release := make(chan struct{}), make(chan struct{})
+	tm.OnReload(func(cfg Config) error {
+		close(reloading)
+		<-release
+		return nil
+	})
+
+	reloaded := make(chan error)
+	go func() {
+		reloaded <- tm.Reload(Config{MaxServerTransactions: 50})
+	}()
+	<-reloading
+
+	set := make(chan struct{})
+	go func() {
+		tm.SetMaxServerTransactionsPerSource(5)
+		close(set)
+	}()
+	select {
+	case <-set:
+		t.Errorf("[FAIL] expected the setter waited for the reload")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-reloaded; err != nil {
+		t.Fatalf("[FAIL] unexpected error: %s", err)
+	}
+	<-set
+	if cfg := tm.Config(); cfg.MaxServerTransactions != 50 || cfg.MaxServerTransactionsPerSource != 5 {
+		t.Errorf("[FAIL] expected both the reload and the setter applied, got %+v", cfg)
+	}
+}
+
+func TestTransactionTimers(t *testing.T) {
+	timing.MockMode = true
+	logger := log.WithField("test", t.Name())
+	if _, err := NewManagerWithConfig(newDummyTransport(), c_CLIENT, Config{Timers: Timers{D: -time.Second}}); err == nil {
+		t.Errorf("[FAIL] expected manager with invalid timers rejected")
+	}
+	tp := newDummyTransport()
+	lan := Timers{T1: 100 * time.Millisecond, T2: time.Second, T4: time.Second, D: 5 * time.Second}
+	tm, err := NewManagerWithConfig(tp, c_CLIENT, Config{Timers: lan})
+	assertNoError(t, err)
+	defer tm.Stop()
+
+	newRequest := func(method base.Method) *base.Request {
+		req, err := request([]string{
+			string(method) + " sip:bob@example.com SIP/2.0",
+			"Via: SIP/2.0/UDP " + c_SERVER + ";branch=" + base.GenerateBranch(),
+			"CSeq: 1 " + string(method),
+			"",
+			"",
+		}, logger)
+		assertNoError(t, err)
+		return req
+	}
+	remaining := func(tx Transaction, expected time.Duration) {
+		t.Helper()
+		if got := tx.TimeRemaining(); got != expected {
+			t.Errorf("[FAIL] expected %v remaining on transaction of %s, got %v", expected, tx.Origin().Short(), got)
+		}
+	}
+
+	tx := tm.Send(newRequest(base.REGISTER), c_SERVER)
+	<-tp.messages
+	remaining(tx, 64*lan.T1)
+	if tx.times.d != lan.D {
+		t.Errorf("[FAIL] expected timer D %v, got %v", lan.D, tx.times.d)
+	}
+
+	satellite := Timers{T1: 2 * time.Second, T2: 8 * time.Second, T4: 10 * time.Second}
+	if _, err := tm.SendWithTimers(newRequest(base.REGISTER), c_SERVER, Timers{T2: time.Second}); err == nil {
+		t.Errorf("[FAIL] expected transaction with invalid timers rejected")
+	}
+	tx, err = tm.SendWithTimers(newRequest(base.REGISTER), c_SERVER, satellite)
+	assertNoError(t, err)
+	<-tp.messages
+	remaining(tx, 64*satellite.T1)
+	if tx.times.d != Timer_D {
+		t.Errorf("[FAIL] expected timer D %v, got %v", Timer_D, tx.times.d)
+	}
+
+	tp.toTM <- newRequest(base.OPTIONS)
+	var srv *ServerTransaction
+	select {
+	case srv = <-tm.Requests():
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] timed out waiting for request")
+	}
+	assertNoError(t, srv.SetTimers(satellite))
+	srv.Ok()
+	<-tp.messages
+	remaining(srv, 64*satellite.T1)
+}
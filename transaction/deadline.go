@@ -0,0 +1,122 @@
+package transaction
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+)
+
+// timer_response is the name of the timer of the response deadline of the server transaction, see SetResponseDeadline.
+const timer_response = "RESPONSE"
+
+// c_RESPONSE_TIMEOUTS_QUEUE_SIZE is the number of the response timeouts kept until the application takes them.
+const c_RESPONSE_TIMEOUTS_QUEUE_SIZE = 100
+
+// ResponseDeadline is the longest time the TU may take to send the final response to the requests of a method.
+// Once it passes the manager responds itself, so the server transactions of hung handlers don't stay open.
+type ResponseDeadline struct {
+	Timeout time.Duration
+	// Status is the status code of the response sent on expiry, e.g. 408 Request Timeout; 0 means 500 Server Internal Error.
+	Status uint16
+}
+
+// Validate checks the deadline can be applied.
+func (deadline ResponseDeadline) Validate() error {
+	if deadline.Timeout <= 0 {
+		return fmt.Errorf("invalid response deadline %v: must be positive", deadline.Timeout)
+	}
+	if deadline.Status != 0 && (deadline.Status < 300 || deadline.Status > 699) {
+		return fmt.Errorf("invalid response deadline status %d: must be final non-2xx", deadline.Status)
+	}
+	return nil
+}
+
+func (deadline ResponseDeadline) status() (uint16, string) {
+	switch deadline.Status {
+	case 0, 500:
+		return 500, "Server Internal Error"
+	case 408:
+		return 408, "Request Timeout"
+	case 503:
+		return 503, "Service Unavailable"
+	case 504:
+		return 504, "Server Time-out"
+	default:
+		return deadline.Status, "Request Failed"
+	}
+}
+
+// ResponseTimeout is the event of the server transaction answered by the manager because the TU missed the deadline,
+// see ResponseTimeouts.
+type ResponseTimeout struct {
+	Tx       *ServerTransaction
+	Deadline ResponseDeadline
+	// Response is the response sent by the manager.
+	Response *base.Response
+}
+
+// SetResponseDeadline sets the deadline of the final response to the requests of the method,
+// zero timeout removes it. ACK is never answered, so it has no deadline.
+// The deadline is set on the server transactions passed to the TU afterwards. Can be changed at runtime, see Reload.
+func (mng *Manager) SetResponseDeadline(method base.Method, deadline ResponseDeadline) error {
+	if method == base.ACK {
+		return fmt.Errorf("%s is never answered", method)
+	}
+	if deadline.Timeout != 0 {
+		if err := deadline.Validate(); err != nil {
+			return err
+		}
+	}
+
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	// The configuration is shared by the snapshots returned by Config, so the map is replaced rather than changed.
+	deadlines := make(map[base.Method]ResponseDeadline, len(mng.cfg.ResponseDeadlines)+1)
+	for m, d := range mng.cfg.ResponseDeadlines {
+		deadlines[m] = d
+	}
+	if deadline.Timeout == 0 {
+		delete(deadlines, method)
+	} else {
+		deadlines[method] = deadline
+	}
+	mng.cfg.ResponseDeadlines = deadlines
+	return nil
+}
+
+// ResponseTimeouts returns the channel of the server transactions answered by the manager on the response deadline,
+// e.g. to alert on hung handlers. The events are dropped while the channel is full.
+func (mng *Manager) ResponseTimeouts() <-chan ResponseTimeout {
+	return mng.responseTimeouts
+}
+
+// startResponseDeadline starts the timer of the response deadline of the method of the server transaction, if any.
+func (mng *Manager) startResponseDeadline(tx *ServerTransaction) {
+	if tx.IsAck() {
+		return
+	}
+	deadline, ok := mng.Config().ResponseDeadlines[tx.origin.Method]
+	if !ok {
+		return
+	}
+	tx.timers.Start(timer_response, deadline.Timeout, func() {
+		mng.responseTimeout(tx, deadline)
+	})
+}
+
+// responseTimeout answers the server transaction left without the final response.
+func (mng *Manager) responseTimeout(tx *ServerTransaction, deadline ResponseDeadline) {
+	if last := tx.LastResponse(); last != nil && !last.IsProvisional() {
+		return
+	}
+	code, reason := deadline.status()
+	tx.Log().Warnf("no final response to %s in %v, responding %d %s", tx.origin.Short(), deadline.Timeout, code, reason)
+	res := tx.RespondWithStatus(code, reason)
+
+	select {
+	case mng.responseTimeouts <- ResponseTimeout{Tx: tx, Deadline: deadline, Response: res}:
+	default:
+		tx.Log().Debugf("response timeouts queue is full, timeout of %s dropped", tx.origin.Short())
+	}
+}
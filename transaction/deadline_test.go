@@ -0,0 +1,87 @@
+package transaction
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/timing"
+)
+
+func TestResponseDeadline(t *testing.T) {
+	timing.MockMode = true
+	logger := log.WithField("test", t.Name())
+	tp := newDummyTransport()
+	tm, err := NewManager(tp, c_CLIENT)
+	assertNoError(t, err)
+	defer tm.Stop()
+
+	if err := tm.SetResponseDeadline(base.ACK, ResponseDeadline{Timeout: time.Second}); err == nil {
+		t.Errorf("[FAIL] expected deadline of ACK rejected")
+	}
+	if err := tm.SetResponseDeadline(base.OPTIONS, ResponseDeadline{Timeout: time.Second, Status: 200}); err == nil {
+		t.Errorf("[FAIL] expected deadline with 2xx status rejected")
+	}
+	assertNoError(t, tm.SetResponseDeadline(base.OPTIONS, ResponseDeadline{Timeout: time.Second, Status: 408}))
+
+	newOptions := func() *base.Request {
+		req, err := request([]string{
+			"OPTIONS sip:bob@example.com SIP/2.0",
+			"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+			"From: <sip:alice@example.com>;tag=1",
+			"CSeq: 1 OPTIONS",
+			"",
+			"",
+		}, logger)
+		assertNoError(t, err)
+		return req
+	}
+	received := func() *ServerTransaction {
+		select {
+		case tx := <-tm.Requests():
+			return tx
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] timed out waiting for request")
+		}
+		return nil
+	}
+	sent := func() *base.Response {
+		select {
+		case msg := <-tp.messages:
+			return msg.msg.(*base.Response)
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] timed out waiting for response")
+		}
+		return nil
+	}
+
+	// The handler answering in time stops the deadline.
+	tp.toTM <- newOptions()
+	received().Ok()
+	if res := sent(); res.StatusCode != 200 {
+		t.Errorf("[FAIL] expected 200 of the handler, got %s", res.Short())
+	}
+	timing.Elapse(time.Second)
+
+	// The hung handler is answered by the manager.
+	tp.toTM <- newOptions()
+	tx := received()
+	timing.Elapse(time.Second)
+	if res := sent(); res.StatusCode != 408 {
+		t.Errorf("[FAIL] expected 408 on the deadline, got %s", res.Short())
+	}
+	select {
+	case timeout := <-tm.ResponseTimeouts():
+		if timeout.Tx != tx || timeout.Response.StatusCode != 408 {
+			t.Errorf("[FAIL] expected timeout of the transaction with 408, got %+v", timeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] response timeout was not reported")
+	}
+	select {
+	case timeout := <-tm.ResponseTimeouts():
+		t.Errorf("[FAIL] expected single response timeout, got %+v", timeout)
+	default:
+	}
+}
@@ -0,0 +1,65 @@
+package transaction
+
+import (
+	"github.com/ghettovoice/gossip/base"
+)
+
+// DialogHandler receives server transactions of in-dialog requests of the dialog it's registered for.
+type DialogHandler func(tx *ServerTransaction)
+
+// HandleDialog registers the handler of in-dialog requests of the dialog, given from the local perspective.
+// Requests of registered dialogs are passed to the handler instead of the Requests channel.
+func (mng *Manager) HandleDialog(id base.DialogId, handler DialogHandler) {
+	mng.handlersLock.Lock()
+	defer mng.handlersLock.Unlock()
+
+	if mng.dialogHandlers == nil {
+		mng.dialogHandlers = make(map[base.DialogId]DialogHandler)
+	}
+	mng.dialogHandlers[id] = handler
+}
+
+// RemoveDialogHandler unregisters the handler of the dialog, e.g. when the dialog is terminated.
+func (mng *Manager) RemoveDialogHandler(id base.DialogId) {
+	mng.handlersLock.Lock()
+	defer mng.handlersLock.Unlock()
+	delete(mng.dialogHandlers, id)
+}
+
+// HasDialog reports whether a handler is registered for the dialog, so the manager can be used as base.DialogLookup.
+func (mng *Manager) HasDialog(id base.DialogId) bool {
+	mng.handlersLock.RLock()
+	defer mng.handlersLock.RUnlock()
+	_, ok := mng.dialogHandlers[id]
+	return ok
+}
+
+// SetRejectStray enables answering stray mid-dialog requests with 481 Call/Transaction Does Not Exist - RFC 3261 12.2.2.
+// A request is stray if it's a non-INVITE request with To tag, matching no server transaction and no registered dialog handler.
+// Can be changed at runtime, see Reload.
+func (mng *Manager) SetRejectStray(reject bool) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.RejectStray = reject
+}
+
+// dialogHandler returns the handler registered for the dialog of the request or nil.
+func (mng *Manager) dialogHandler(req *base.Request) DialogHandler {
+	if !base.IsInDialog(req) {
+		return nil
+	}
+	id, err := base.RequestDialogId(req)
+	if err != nil {
+		return nil
+	}
+
+	mng.handlersLock.RLock()
+	defer mng.handlersLock.RUnlock()
+	return mng.dialogHandlers[id]
+}
+
+// isStray reports whether the request without a dialog handler should be rejected.
+// ACK is never answered, re-INVITE is left to the application.
+func (mng *Manager) isStray(req *base.Request) bool {
+	return mng.Config().RejectStray && !req.IsInvite() && !req.IsAck() && base.IsInDialog(req)
+}
@@ -0,0 +1,79 @@
+package transaction
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+type handleDialog struct {
+	id       base.DialogId
+	received chan *ServerTransaction
+}
+
+func (actn *handleDialog) Act(test *transactionTest) error {
+	test.tm.HandleDialog(actn.id, func(tx *ServerTransaction) { actn.received <- tx })
+	test.tm.SetRejectStray(true)
+	return nil
+}
+
+type dialogHandlerRecv struct {
+	received chan *ServerTransaction
+	expected *base.Request
+}
+
+func (actn *dialogHandlerRecv) Act(test *transactionTest) error {
+	select {
+	case tx := <-actn.received:
+		if tx.Origin().String() != actn.expected.String() {
+			return fmt.Errorf("unexpected request at dialog handler:\n%s", tx.Origin().String())
+		}
+		return nil
+	case <-time.After(time.Second):
+		return fmt.Errorf("timed out waiting for request at dialog handler")
+	}
+}
+
+func TestRejectStrayRequests(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	inDialog := func(method base.Method, toTag string) *base.Request {
+		req, err := request([]string{
+			string(method) + " sip:bob@example.com SIP/2.0",
+			"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+			"From: <sip:alice@example.com>;tag=remote",
+			"To: <sip:bob@example.com>;tag=" + toTag,
+			"Call-Id: dialog1",
+			"CSeq: 2 " + string(method),
+			"",
+			"",
+		}, logger)
+		assertNoError(t, err)
+		return req
+	}
+
+	bye := inDialog(base.BYE, "local")
+	stray := inDialog(base.BYE, "gone")
+	reinvite := inDialog(base.INVITE, "gone")
+
+	handler := &handleDialog{
+		id:       base.DialogId{CallId: "dialog1", LocalTag: "local", RemoteTag: "remote"},
+		received: make(chan *ServerTransaction, 1),
+	}
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			handler,
+			&transportSend{bye},
+			&dialogHandlerRecv{handler.received, bye},
+			&transportSend{stray},
+			&transportRecv{base.NewResponseFromRequest(stray, 481, "Call/Transaction Does Not Exist", "")},
+			&transportSend{reinvite},
+			&userRecvSrv{reinvite},
+		}}
+	test.Execute()
+}
@@ -0,0 +1,70 @@
+package transaction
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+)
+
+// Drain switches the manager to drain mode before the instance is removed from a cluster:
+// new out-of-dialog INVITE and REGISTER requests are rejected with 503 Service Unavailable,
+// carrying Retry-After of the retryAfter interval unless it is 0, so that clients turn to other instances.
+// In-dialog requests, other methods and the transactions in progress are served as usual.
+// The manager reports not ready while draining.
+// Bindings at the upstream registrars are removed separately, see registration.Manager.UnregisterAll.
+func (mng *Manager) Drain(retryAfter time.Duration) {
+	mng.drainLock.Lock()
+	defer mng.drainLock.Unlock()
+	mng.draining = true
+	mng.retryAfter = retryAfter
+}
+
+// Resume leaves the drain mode.
+func (mng *Manager) Resume() {
+	mng.drainLock.Lock()
+	defer mng.drainLock.Unlock()
+	mng.draining = false
+}
+
+// Draining reports whether the manager is in drain mode.
+func (mng *Manager) Draining() bool {
+	mng.drainLock.RLock()
+	defer mng.drainLock.RUnlock()
+	return mng.draining
+}
+
+// drained checks whether the request starts a new dialog or registration the draining manager turns away.
+func (mng *Manager) drained(req *base.Request) bool {
+	if req.Method != base.INVITE && req.Method != base.REGISTER {
+		return false
+	}
+	if tag, err := req.ToTag(); err == nil && tag != nil {
+		return false
+	}
+	return mng.Draining()
+}
+
+func (mng *Manager) rejectDrained(req *base.Request, dest string) {
+	req.Log().Infof("manager is draining, request %s rejected", req.Short())
+	res := base.NewResponseFromRequest(req, 503, "Service Unavailable", "")
+
+	mng.drainLock.RLock()
+	retryAfter := mng.retryAfter
+	mng.drainLock.RUnlock()
+	if retryAfter > 0 {
+		res.AddHeader(retryAfterHeader(retryAfter))
+	}
+
+	if err := mng.transport.Send(dest, res); err != nil {
+		req.Log().Warnf("failed to send %s: %s", res.Short(), err)
+	}
+}
+
+// retryAfterHeader builds Retry-After header of the interval rounded up to seconds - RFC 3261 20.33.
+func retryAfterHeader(retryAfter time.Duration) base.SipHeader {
+	return &base.GenericHeader{
+		HeaderName: "Retry-After",
+		Contents:   strconv.Itoa(int((retryAfter + time.Second - 1) / time.Second)),
+	}
+}
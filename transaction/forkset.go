@@ -0,0 +1,167 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ghettovoice/gossip/base"
+)
+
+// ForkResponse is a response received on one of the branches of ForkSet.
+type ForkResponse struct {
+	// Branch is the branch parameter of the top Via hop of the forked request.
+	Branch string
+	// Dest is the address the branch was sent to.
+	Dest     string
+	Response *base.Response
+	// Err is set if the branch failed, Response then holds the response the failure is treated as:
+	// 408 Request Timeout on timeout and 503 Service Unavailable on transport error - RFC 3261 16.7.
+	Err error
+}
+
+// ForkSet sends the same request to several destinations as separate client transactions - RFC 3261 16.6,
+// and aggregates their responses - RFC 3261 16.7.
+// When a branch of INVITE request receives 2xx or 6xx response, the remaining branches are cancelled.
+type ForkSet struct {
+	origin    *base.Request
+	branches  []*forkBranch
+	responses chan ForkResponse
+	lock      sync.Mutex
+	best      *base.Response
+	decided   bool
+}
+
+type forkBranch struct {
+	tx     *ClientTransaction
+	dest   string
+	branch string
+	final  bool
+}
+
+// Fork sends a copy of the request with a unique Via branch to each of the destinations.
+func (mng *Manager) Fork(req *base.Request, dests []string) (*ForkSet, error) {
+	if len(dests) == 0 {
+		return nil, fmt.Errorf("no destinations to fork request %s to", req.Short())
+	}
+
+	fs := &ForkSet{
+		origin:    req,
+		responses: make(chan ForkResponse, 2*len(dests)),
+	}
+	for _, dest := range dests {
+		fork := req.Copy()
+		hop, err := fork.ViaHop()
+		if err != nil {
+			return nil, err
+		}
+		branch := base.GenerateBranch()
+		hop.Params.Add("branch", base.String{S: branch})
+
+		fs.branches = append(fs.branches, &forkBranch{dest: dest, branch: branch})
+		fs.branches[len(fs.branches)-1].tx = mng.Send(fork, dest)
+	}
+
+	wg := new(sync.WaitGroup)
+	for _, b := range fs.branches {
+		wg.Add(1)
+		go fs.watch(b, wg)
+	}
+	go func() {
+		wg.Wait()
+		close(fs.responses)
+	}()
+
+	return fs, nil
+}
+
+// Responses returns channel where responses of all branches arrive, labeled with the branch.
+// The channel is closed when all branches received final responses or failed.
+// It must be drained, otherwise branches block.
+func (fs *ForkSet) Responses() <-chan ForkResponse {
+	return fs.responses
+}
+
+// Best returns the best final response selected among the branches - RFC 3261 16.7 step 6,
+// or nil if there is no final response yet. It is final once Responses channel is closed.
+func (fs *ForkSet) Best() *base.Response {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return fs.best
+}
+
+// Cancel cancels all branches without a final response, e.g. when the forked request was cancelled itself.
+func (fs *ForkSet) Cancel() {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	fs.decided = true
+	fs.cancelPending()
+}
+
+func (fs *ForkSet) cancelPending() {
+	for _, b := range fs.branches {
+		if !b.final {
+			b.tx.Cancel()
+		}
+	}
+}
+
+// watch forwards responses of the branch until the final one.
+func (fs *ForkSet) watch(b *forkBranch, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		fr := ForkResponse{Branch: b.branch, Dest: b.dest}
+		select {
+		case res := <-b.tx.Responses():
+			fr.Response = res
+		case err := <-b.tx.Errors():
+			fr.Err = err
+			if errors.Is(err, base.ErrTimeout) {
+				fr.Response = base.NewResponseFromRequest(b.tx.Origin(), 408, "Request Timeout", "")
+			} else {
+				fr.Response = base.NewResponseFromRequest(b.tx.Origin(), 503, "Service Unavailable", "")
+			}
+		}
+
+		final := !fr.Response.IsProvisional()
+		if final {
+			fs.receiveFinal(b, fr.Response)
+		}
+		fs.responses <- fr
+		if final {
+			return
+		}
+	}
+}
+
+func (fs *ForkSet) receiveFinal(b *forkBranch, res *base.Response) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	b.final = true
+	if fs.best == nil || BetterResponse(res, fs.best) {
+		fs.best = res
+	}
+
+	if !fs.decided && fs.origin.IsInvite() && (res.IsSuccess() || res.StatusCode >= 600) {
+		b.tx.Log().Debugf("fork of %s decided by %s, cancelling remaining branches", fs.origin.Short(), res.Short())
+		fs.decided = true
+		fs.cancelPending()
+	}
+}
+
+// BetterResponse reports whether the final response res is preferred over the current best one - RFC 3261 16.7 step 6.
+// 2xx responses win, then 6xx ones, then the lowest response class, the earliest response wins a tie.
+func BetterResponse(res, best *base.Response) bool {
+	rank := func(r *base.Response) int {
+		switch {
+		case r.IsSuccess():
+			return 0
+		case r.StatusCode >= 600:
+			return 1
+		default:
+			return int(r.StatusCode / 100)
+		}
+	}
+	return rank(res) < rank(best)
+}
@@ -0,0 +1,186 @@
+package transaction
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/timing"
+)
+
+func TestForkSetSelectsBestResponse(t *testing.T) {
+	timing.MockMode = true
+	logger := log.WithField("test", t.Name())
+	tp := newDummyTransport()
+	tm, err := NewManager(tp, c_CLIENT)
+	assertNoError(t, err)
+	defer tm.Stop()
+
+	invite, err := request([]string{
+		"INVITE sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"From: <sip:alice@example.com>;tag=1",
+		"To: <sip:bob@example.com>",
+		"Call-Id: fork1",
+		"CSeq: 1 INVITE",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	recv := func(method base.Method, addr string) *base.Request {
+		select {
+		case sent := <-tp.messages:
+			req, ok := sent.msg.(*base.Request)
+			if !ok || req.Method != method || sent.addr != addr {
+				t.Fatalf("[FAIL] expected %s to %s, got %s to %s", method, addr, sent.msg.Short(), sent.addr)
+			}
+			return req
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] timed out waiting for %s to %s", method, addr)
+		}
+		return nil
+	}
+
+	fs, err := tm.Fork(invite, []string{"a.example.com:5060", "b.example.com:5060"})
+	assertNoError(t, err)
+	forkA := recv(base.INVITE, "a.example.com:5060")
+	forkB := recv(base.INVITE, "b.example.com:5060")
+	branchA, _ := forkA.Branch()
+	branchB, _ := forkB.Branch()
+	if branchA.String() == branchB.String() {
+		t.Fatalf("[FAIL] forked requests share branch %s", branchA)
+	}
+
+	tp.toTM <- base.NewResponseFromRequest(forkA, 180, "Ringing", "")
+	tp.toTM <- base.NewResponseFromRequest(forkB, 486, "Busy Here", "")
+	recv(base.ACK, "b.example.com:5060")
+	time.Sleep(50 * time.Millisecond)
+	tp.toTM <- base.NewResponseFromRequest(forkA, 200, "OK", "")
+
+	finals := make(map[string]uint16)
+	timeout := time.After(time.Second)
+	for done := false; !done; {
+		select {
+		case fr, ok := <-fs.Responses():
+			if !ok {
+				done = true
+			} else if !fr.Response.IsProvisional() {
+				finals[fr.Branch] = fr.Response.StatusCode
+			}
+		case <-timeout:
+			t.Fatalf("[FAIL] fork set was not completed")
+		}
+	}
+
+	if finals[branchA.String()] != 200 || finals[branchB.String()] != 486 {
+		t.Errorf("[FAIL] unexpected final responses of the branches: %v", finals)
+	}
+	if best := fs.Best(); best == nil || best.StatusCode != 200 {
+		t.Errorf("[FAIL] expected 200 OK selected as the best response, got %v", best)
+	}
+}
+
+func TestForkSetCancelsRestOn2xx(t *testing.T) {
+	timing.MockMode = true
+	logger := log.WithField("test", t.Name())
+	tp := newDummyTransport()
+	tm, err := NewManager(tp, c_CLIENT)
+	assertNoError(t, err)
+	defer tm.Stop()
+
+	invite, err := request([]string{
+		"INVITE sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"From: <sip:alice@example.com>;tag=1",
+		"To: <sip:bob@example.com>",
+		"Call-Id: fork1",
+		"CSeq: 1 INVITE",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	recv := func(method base.Method, addr string) *base.Request {
+		select {
+		case sent := <-tp.messages:
+			req, ok := sent.msg.(*base.Request)
+			if !ok || req.Method != method || sent.addr != addr {
+				t.Fatalf("[FAIL] expected %s to %s, got %s to %s", method, addr, sent.msg.Short(), sent.addr)
+			}
+			return req
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] timed out waiting for %s to %s", method, addr)
+		}
+		return nil
+	}
+
+	fs, err := tm.Fork(invite, []string{"a.example.com:5060", "b.example.com:5060"})
+	assertNoError(t, err)
+	forkA := recv(base.INVITE, "a.example.com:5060")
+	forkB := recv(base.INVITE, "b.example.com:5060")
+	branchA, _ := forkA.Branch()
+	branchB, _ := forkB.Branch()
+	if branchA.String() == branchB.String() {
+		t.Fatalf("[FAIL] forked requests share branch %s", branchA)
+	}
+
+	tp.toTM <- base.NewResponseFromRequest(forkB, 180, "Ringing", "")
+	tp.toTM <- base.NewResponseFromRequest(forkA, 180, "Ringing", "")
+	time.Sleep(50 * time.Millisecond)
+	tp.toTM <- base.NewResponseFromRequest(forkA, 200, "OK", "")
+
+	cancel := recv(base.CANCEL, "b.example.com:5060")
+	if branch, _ := cancel.Branch(); branch.String() != branchB.String() {
+		t.Errorf("[FAIL] CANCEL branch %s does not match the cancelled branch %s", branch, branchB)
+	}
+	tp.toTM <- base.NewResponseFromRequest(cancel, 200, "OK", "")
+	tp.toTM <- base.NewResponseFromRequest(forkB, 487, "Request Terminated", "")
+	recv(base.ACK, "b.example.com:5060")
+
+	finals := make(map[string]uint16)
+	timeout := time.After(time.Second)
+	for done := false; !done; {
+		select {
+		case fr, ok := <-fs.Responses():
+			if !ok {
+				done = true
+			} else if !fr.Response.IsProvisional() {
+				finals[fr.Branch] = fr.Response.StatusCode
+			}
+		case <-timeout:
+			t.Fatalf("[FAIL] fork set was not completed")
+		}
+	}
+
+	if finals[branchA.String()] != 200 || finals[branchB.String()] != 487 {
+		t.Errorf("[FAIL] unexpected final responses of the branches: %v", finals)
+	}
+	if best := fs.Best(); best == nil || best.StatusCode != 200 {
+		t.Errorf("[FAIL] expected 200 OK selected as the best response, got %v", best)
+	}
+}
+
+func TestBetterResponse(t *testing.T) {
+	newResponse := func(code uint16) *base.Response {
+		return base.NewResponse("SIP/2.0", code, "", []base.SipHeader{}, "", log.StandardLogger())
+	}
+
+	tests := []struct {
+		res, best uint16
+		better    bool
+	}{
+		{200, 486, true},
+		{603, 404, true},
+		{404, 603, false},
+		{302, 404, true},
+		{486, 404, false},
+		{503, 408, false},
+	}
+	for _, test := range tests {
+		if BetterResponse(newResponse(test.res), newResponse(test.best)) != test.better {
+			t.Errorf("[FAIL] expected %d better than %d to be %v", test.res, test.best, test.better)
+		}
+	}
+}
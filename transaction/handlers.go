@@ -0,0 +1,122 @@
+package transaction
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ghettovoice/gossip/base"
+)
+
+// RequestHandler receives server transactions of new requests of the method it's registered for.
+type RequestHandler func(tx *ServerTransaction)
+
+// OnRequest registers the handler of new requests of the method, nil handler unregisters it.
+// Once a handler is registered, requests not belonging to a registered dialog are dispatched by method
+// instead of the Requests channel, requests of other methods are passed to the default handler,
+// see SetAllowedMethods and SetPassUnallowed.
+func (mng *Manager) OnRequest(method base.Method, handler RequestHandler) {
+	mng.handlersLock.Lock()
+	defer mng.handlersLock.Unlock()
+
+	if handler == nil {
+		delete(mng.requestHandlers, method)
+		return
+	}
+	if mng.requestHandlers == nil {
+		mng.requestHandlers = make(map[base.Method]RequestHandler)
+	}
+	mng.requestHandlers[method] = handler
+}
+
+// SetAllowedMethods declares the methods handled by the application without a registered handler,
+// e.g. from the Requests channel or by dialog handlers. Requests of the methods go to the Requests channel
+// and the methods are listed in Allow header of 405 Method Not Allowed responses.
+func (mng *Manager) SetAllowedMethods(methods ...base.Method) {
+	mng.handlersLock.Lock()
+	defer mng.handlersLock.Unlock()
+
+	mng.allowedMethods = make(map[base.Method]bool, len(methods))
+	for _, method := range methods {
+		mng.allowedMethods[method] = true
+	}
+}
+
+// SetPassUnallowed disables answering requests of methods neither registered nor allowed with 405 Method Not Allowed,
+// they are passed to the Requests channel instead, e.g. by a proxy forwarding requests of any method.
+// The default handler, if set, still receives them.
+func (mng *Manager) SetPassUnallowed(pass bool) {
+	mng.handlersLock.Lock()
+	defer mng.handlersLock.Unlock()
+	mng.passUnallowed = pass
+}
+
+// SetDefaultHandler sets the handler of requests of methods without a registered handler,
+// nil restores the default answering 405 Method Not Allowed with Allow header of AllowedMethods - RFC 3261 8.2.1.
+func (mng *Manager) SetDefaultHandler(handler RequestHandler) {
+	mng.handlersLock.Lock()
+	defer mng.handlersLock.Unlock()
+	mng.defaultHandler = handler
+}
+
+// AllowedMethods returns the methods with a registered handler and the allowed ones, sorted.
+func (mng *Manager) AllowedMethods() []base.Method {
+	mng.handlersLock.RLock()
+	defer mng.handlersLock.RUnlock()
+
+	methods := make([]base.Method, 0, len(mng.requestHandlers)+len(mng.allowedMethods))
+	for method := range mng.requestHandlers {
+		methods = append(methods, method)
+	}
+	for method := range mng.allowedMethods {
+		if _, ok := mng.requestHandlers[method]; !ok {
+			methods = append(methods, method)
+		}
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i] < methods[j] })
+	return methods
+}
+
+// requestHandler returns the handler of the request by its method,
+// nil if the request goes to the Requests channel: no handlers are registered nor methods allowed,
+// the method is allowed or requests of unallowed methods are passed.
+func (mng *Manager) requestHandler(req *base.Request) RequestHandler {
+	mng.handlersLock.RLock()
+	defer mng.handlersLock.RUnlock()
+
+	if len(mng.requestHandlers) == 0 && len(mng.allowedMethods) == 0 {
+		return nil
+	}
+	if handler, ok := mng.requestHandlers[req.Method]; ok {
+		return handler
+	}
+	if mng.allowedMethods[req.Method] {
+		return nil
+	}
+	if mng.defaultHandler != nil {
+		return mng.defaultHandler
+	}
+	if mng.passUnallowed {
+		return nil
+	}
+	return mng.methodNotAllowed
+}
+
+// methodNotAllowed answers the request of the method without a handler with 405 Method Not Allowed.
+// ACK is never answered, it's just dropped.
+func (mng *Manager) methodNotAllowed(tx *ServerTransaction) {
+	if tx.Origin().IsAck() {
+		tx.Log().Warnf("no handler of request %s, dropped", tx.Origin().Short())
+		return
+	}
+
+	methods := mng.AllowedMethods()
+	allow := make([]string, len(methods))
+	for i, method := range methods {
+		allow[i] = string(method)
+	}
+	tx.Log().Warnf("no handler of request %s, rejected", tx.Origin().Short())
+	tx.RespondWithStatus(405, "Method Not Allowed", &base.GenericHeader{
+		HeaderName: "Allow",
+		Contents:   strings.Join(allow, ", "),
+	})
+}
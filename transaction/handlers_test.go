@@ -0,0 +1,131 @@
+package transaction
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+type onRequest struct {
+	method   base.Method
+	received chan *ServerTransaction
+}
+
+func (actn *onRequest) Act(test *transactionTest) error {
+	test.tm.OnRequest(actn.method, func(tx *ServerTransaction) { actn.received <- tx })
+	return nil
+}
+
+type transportRecvAllow struct {
+	status uint16
+	allow  string
+}
+
+func (actn *transportRecvAllow) Act(test *transactionTest) error {
+	select {
+	case msg := <-test.transport.messages:
+		res, ok := msg.msg.(*base.Response)
+		if !ok || res.StatusCode != actn.status {
+			return fmt.Errorf("unexpected message arrived at transport:\n%s", msg.msg.String())
+		}
+		allow := res.Headers("Allow")
+		if len(allow) != 1 || allow[0].(*base.GenericHeader).Contents != actn.allow {
+			return fmt.Errorf("expected Allow: %s, got %v", actn.allow, allow)
+		}
+		return nil
+	case <-time.After(time.Second):
+		return fmt.Errorf("timed out waiting for message at transport")
+	}
+}
+
+func TestRequestHandlers(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	newRequest := func(method base.Method) *base.Request {
+		req, err := request([]string{
+			string(method) + " sip:bob@example.com SIP/2.0",
+			"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+			"From: <sip:alice@example.com>;tag=1",
+			"To: <sip:bob@example.com>",
+			"Call-Id: handlers1",
+			"CSeq: 1 " + string(method),
+			"",
+			"",
+		}, logger)
+		assertNoError(t, err)
+		return req
+	}
+
+	options := newRequest(base.OPTIONS)
+	register := newRequest(base.REGISTER)
+	subscribe := newRequest(base.SUBSCRIBE)
+
+	onOptions := &onRequest{base.OPTIONS, make(chan *ServerTransaction, 1)}
+	onRegister := &onRequest{base.REGISTER, make(chan *ServerTransaction, 1)}
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			onOptions,
+			onRegister,
+			&transportSend{options},
+			&dialogHandlerRecv{onOptions.received, options},
+			&transportSend{register},
+			&dialogHandlerRecv{onRegister.received, register},
+			&transportSend{subscribe},
+			&transportRecvAllow{405, "OPTIONS, REGISTER"},
+		}}
+	test.Execute()
+}
+
+type setAllowedMethods struct {
+	methods []base.Method
+	pass    bool
+}
+
+func (actn *setAllowedMethods) Act(test *transactionTest) error {
+	test.tm.SetAllowedMethods(actn.methods...)
+	test.tm.SetPassUnallowed(actn.pass)
+	return nil
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	newRequest := func(method base.Method) *base.Request {
+		req, err := request([]string{
+			string(method) + " sip:bob@example.com SIP/2.0",
+			"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+			"From: <sip:alice@example.com>;tag=1",
+			"To: <sip:bob@example.com>",
+			"Call-Id: allowed1",
+			"CSeq: 1 " + string(method),
+			"",
+			"",
+		}, logger)
+		assertNoError(t, err)
+		return req
+	}
+
+	invite := newRequest(base.INVITE)
+	message := newRequest(base.NOTIFY)
+	forwarded := newRequest(base.NOTIFY)
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&setAllowedMethods{[]base.Method{base.INVITE, base.BYE, base.ACK}, false},
+			&transportSend{invite},
+			&userRecvSrv{invite},
+			&transportRecv{base.NewResponseFromRequest(invite, 100, "Trying", "")},
+			&transportSend{message},
+			&transportRecvAllow{405, "ACK, BYE, INVITE"},
+			&setAllowedMethods{[]base.Method{base.INVITE, base.BYE, base.ACK}, true},
+			&transportSend{forwarded},
+			&userRecvSrv{forwarded},
+		}}
+	test.Execute()
+}
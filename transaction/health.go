@@ -0,0 +1,91 @@
+package transaction
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ghettovoice/gossip/transport"
+)
+
+// HealthCheck reports a problem of a dependency of the manager, e.g. unreachable DNS resolver.
+// nil means the dependency is fine.
+type HealthCheck func() error
+
+// ReadinessCheck is the named check of a dependency, the name prefixes the problem reported by Ready.
+type ReadinessCheck struct {
+	Name  string
+	Check HealthCheck
+}
+
+// AddReadinessCheck adds the check of a dependency the manager can't serve requests without.
+// Can be called at runtime, see Reload.
+func (mng *Manager) AddReadinessCheck(name string, check HealthCheck) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	// Copy, so the configurations returned by Config so far don't share the checks.
+	checks := make([]ReadinessCheck, 0, len(mng.cfg.ReadinessChecks)+1)
+	checks = append(checks, mng.cfg.ReadinessChecks...)
+	mng.cfg.ReadinessChecks = append(checks, ReadinessCheck{name, check})
+}
+
+// Healthy checks that the manager is alive, i.e. the transport still listens.
+// Returns nil if the manager is healthy, otherwise the reason why not.
+func (mng *Manager) Healthy() error {
+	if status, ok := mng.transport.(transport.ListenerStatus); ok && !status.Listening() {
+		return fmt.Errorf("transport is not listening")
+	}
+	return nil
+}
+
+// Ready checks that the manager is able to take new requests: it is healthy, not draining,
+// its queues and server transactions are not saturated, and all the readiness checks pass.
+// Returns nil if the manager is ready, otherwise the reason why not.
+func (mng *Manager) Ready() error {
+	if err := mng.Healthy(); err != nil {
+		return err
+	}
+	if mng.Draining() {
+		return fmt.Errorf("manager is draining")
+	}
+	if len(mng.requests) == cap(mng.requests) {
+		return fmt.Errorf("requests queue is full")
+	}
+	if len(mng.responses) == cap(mng.responses) {
+		return fmt.Errorf("unmatched responses queue is full")
+	}
+	cfg := mng.Config()
+	if max := cfg.MaxServerTransactions; max > 0 && mng.countServerTx() >= max {
+		return fmt.Errorf("server transactions limit %d reached", max)
+	}
+	for _, check := range cfg.ReadinessChecks {
+		if err := check.Check(); err != nil {
+			return fmt.Errorf("%s: %s", check.Name, err)
+		}
+	}
+	return nil
+}
+
+// HealthHandler returns HTTP handler of the liveness probe at /healthz and the readiness probe at /readyz,
+// e.g. for Kubernetes deployments. Probes respond 200 OK, or 503 Service Unavailable with the reason.
+// The handler also serves the snapshot of the resources, see Resources, as JSON at /debug/resources
+// and as gauges in Prometheus text format at /metrics.
+func (mng *Manager) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", probeHandler(mng.Healthy))
+	mux.HandleFunc("/readyz", probeHandler(mng.Ready))
+	mux.HandleFunc("/debug/resources", mng.resourcesHandler)
+	mux.HandleFunc("/metrics", mng.metricsHandler)
+	return mux
+}
+
+func probeHandler(probe func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := probe(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
@@ -0,0 +1,53 @@
+package transaction
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ghettovoice/gossip/transport"
+)
+
+func TestHealthProbes(t *testing.T) {
+	tp, err := transport.NewManager("memory")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create transport manager: %s", err)
+	}
+	tm, err := NewManager(tp, "health-uas")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create transaction manager: %s", err)
+	}
+
+	if err := tm.Healthy(); err != nil {
+		t.Errorf("[FAIL] listening manager reported unhealthy: %s", err)
+	}
+	if err := tm.Ready(); err != nil {
+		t.Errorf("[FAIL] idle manager reported not ready: %s", err)
+	}
+
+	resolverDown := fmt.Errorf("timeout")
+	tm.AddReadinessCheck("resolver", func() error { return resolverDown })
+	if err := tm.Ready(); err == nil {
+		t.Errorf("[FAIL] manager reported ready with failed readiness check")
+	}
+
+	handler := tm.HealthHandler()
+	for path, expected := range map[string]int{"/healthz": http.StatusOK, "/readyz": http.StatusServiceUnavailable} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != expected {
+			t.Errorf("[FAIL] expected status %d of %s, got %d: %s", expected, path, rec.Code, rec.Body.String())
+		}
+	}
+
+	resolverDown = nil
+	if err := tm.Ready(); err != nil {
+		t.Errorf("[FAIL] manager reported not ready after the check recovered: %s", err)
+	}
+
+	tm.Stop()
+	if err := tm.Healthy(); err == nil {
+		t.Errorf("[FAIL] stopped manager reported healthy")
+	}
+}
@@ -0,0 +1,218 @@
+package transaction
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/timing"
+)
+
+// Sighting is a receipt of a new request by the element.
+type Sighting struct {
+	At time.Time
+	// Source is the address of the top Via of the request.
+	Source string
+	Branch string
+	// MaxForwards is the value of Max-Forwards header, -1 if the request has none.
+	MaxForwards int
+}
+
+// HistoryEntry holds the sightings of the requests of the same Call-ID and CSeq within the window of the history,
+// oldest first. The same request seen again is a spiral or a loop, retransmissions are not recorded.
+type HistoryEntry struct {
+	CallId    string
+	SeqNo     uint32
+	Method    base.Method
+	Sightings []Sighting
+}
+
+// Count returns the number of sightings.
+func (entry HistoryEntry) Count() int {
+	return len(entry.Sightings)
+}
+
+// Looping reports whether the request returned through other elements at least n times:
+// it was seen n times or more, each time with less Max-Forwards than before.
+func (entry HistoryEntry) Looping(n int) bool {
+	if n < 2 || len(entry.Sightings) < n {
+		return false
+	}
+	for i := 1; i < len(entry.Sightings); i++ {
+		prev, cur := entry.Sightings[i-1].MaxForwards, entry.Sightings[i].MaxForwards
+		if prev < 0 || cur < 0 || cur >= prev {
+			return false
+		}
+	}
+	return true
+}
+
+func (entry HistoryEntry) String() string {
+	hops := make([]string, 0, len(entry.Sightings))
+	for _, sighting := range entry.Sightings {
+		hops = append(hops, fmt.Sprintf("%s from %s max-forwards %d", sighting.Branch, sighting.Source, sighting.MaxForwards))
+	}
+	return fmt.Sprintf("%s %d %s seen %d times: %s",
+		entry.CallId, entry.SeqNo, entry.Method, entry.Count(), strings.Join(hops, ", "))
+}
+
+type historyKey struct {
+	callId string
+	seqNo  uint32
+	method base.Method
+}
+
+// RequestHistory tracks how many times the element has seen the requests of the same Call-ID and CSeq
+// within the time window, a breadcrumb trail for debugging routing loops
+// answered with 482 Loop Detected or 483 Too Many Hops.
+type RequestHistory struct {
+	window  time.Duration
+	entries map[historyKey]*HistoryEntry
+	lock    sync.Mutex
+}
+
+// NewRequestHistory creates the history keeping the sightings for the window.
+func NewRequestHistory(window time.Duration) *RequestHistory {
+	return &RequestHistory{
+		window:  window,
+		entries: make(map[historyKey]*HistoryEntry),
+	}
+}
+
+// Record adds the sighting of the request received from the source and returns the entry of the request.
+func (history *RequestHistory) Record(req *base.Request, source string) (HistoryEntry, error) {
+	key, err := makeHistoryKey(req)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+	sighting := Sighting{At: timing.Now(), Source: source, MaxForwards: -1}
+	if branch, err := req.Branch(); err == nil && branch != nil {
+		sighting.Branch = branch.String()
+	}
+	if hdrs := req.Headers("Max-Forwards"); len(hdrs) > 0 {
+		switch maxForwards := hdrs[0].(type) {
+		case *base.MaxForwards:
+			sighting.MaxForwards = int(*maxForwards)
+		case base.MaxForwards:
+			sighting.MaxForwards = int(maxForwards)
+		}
+	}
+
+	history.lock.Lock()
+	defer history.lock.Unlock()
+	history.sweep(sighting.At)
+	entry, ok := history.entries[key]
+	if !ok {
+		entry = &HistoryEntry{CallId: key.callId, SeqNo: key.seqNo, Method: key.method}
+		history.entries[key] = entry
+	}
+	entry.Sightings = append(entry.Sightings, sighting)
+	return entry.copy(), nil
+}
+
+// Lookup returns the entry of the requests of the Call-ID and CSeq, false if they were not seen within the window.
+func (history *RequestHistory) Lookup(callId string, seqNo uint32, method base.Method) (HistoryEntry, bool) {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+	history.sweep(timing.Now())
+	entry, ok := history.entries[historyKey{callId, seqNo, method}]
+	if !ok {
+		return HistoryEntry{}, false
+	}
+	return entry.copy(), true
+}
+
+// Len returns the number of tracked entries, including expired ones not yet swept.
+func (history *RequestHistory) Len() int {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+	return len(history.entries)
+}
+
+// sweep drops the sightings older than the window, must be called under the lock.
+func (history *RequestHistory) sweep(now time.Time) {
+	for key, entry := range history.entries {
+		kept := entry.Sightings[:0]
+		for _, sighting := range entry.Sightings {
+			if now.Sub(sighting.At) < history.window {
+				kept = append(kept, sighting)
+			}
+		}
+		if len(kept) == 0 {
+			delete(history.entries, key)
+			continue
+		}
+		entry.Sightings = kept
+	}
+}
+
+func (entry *HistoryEntry) copy() HistoryEntry {
+	clone := *entry
+	clone.Sightings = append([]Sighting{}, entry.Sightings...)
+	return clone
+}
+
+func makeHistoryKey(req *base.Request) (historyKey, error) {
+	callId, err := req.CallId()
+	if err != nil {
+		return historyKey{}, err
+	}
+	cseq, err := req.CSeq()
+	if err != nil {
+		return historyKey{}, err
+	}
+	return historyKey{string(*callId), cseq.SeqNo, cseq.MethodName}, nil
+}
+
+// SetRequestHistory enables tracking of the new requests within the window, 0 disables it.
+// If looping is 2 or more, requests seen that many times, each time with less Max-Forwards,
+// are rejected with 483 Too Many Hops at once instead of running around the loop until Max-Forwards is exhausted.
+// Can be changed at runtime, see Reload.
+func (mng *Manager) SetRequestHistory(window time.Duration, looping int) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	if window <= 0 {
+		mng.cfg.History = nil
+		mng.cfg.Looping = 0
+		return
+	}
+	mng.cfg.History = NewRequestHistory(window)
+	mng.cfg.Looping = looping
+}
+
+// RequestHistory returns the history of the received requests, nil if it is not enabled.
+func (mng *Manager) RequestHistory() *RequestHistory {
+	return mng.Config().History
+}
+
+// recordHistory records the request and reports whether it is certainly looping.
+func (mng *Manager) recordHistory(req *base.Request, source string) bool {
+	cfg := mng.Config()
+	if cfg.History == nil {
+		return false
+	}
+	entry, err := cfg.History.Record(req, source)
+	if err != nil {
+		req.Log().Debugf("request %s not recorded in history: %s", req.Short(), err)
+		return false
+	}
+	if entry.Count() > 1 {
+		req.Log().Debugf("request %s is seen again: %s", req.Short(), entry)
+	}
+	return cfg.Looping > 0 && entry.Looping(cfg.Looping)
+}
+
+func (mng *Manager) rejectTooManyHops(req *base.Request, dest string) {
+	if req.IsAck() {
+		req.Log().Warnf("looping request %s dropped", req.Short())
+		return
+	}
+
+	req.Log().Warnf("looping request %s rejected", req.Short())
+	res := base.NewResponseFromRequest(req, 483, "Too Many Hops", "")
+	if err := mng.transport.Send(dest, res); err != nil {
+		req.Log().Warnf("failed to send %s: %s", res.Short(), err)
+	}
+}
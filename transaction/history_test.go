@@ -0,0 +1,101 @@
+package transaction
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/timing"
+)
+
+func historyRequest(t *testing.T, callId string, maxForwards int) *base.Request {
+	req, err := request([]string{
+		"OPTIONS sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"From: <sip:alice@example.com>;tag=1",
+		"To: <sip:bob@example.com>",
+		"Call-Id: " + callId,
+		"CSeq: 1 OPTIONS",
+		fmt.Sprintf("Max-Forwards: %d", maxForwards),
+		"",
+		"",
+	}, log.WithField("test", t.Name()))
+	assertNoError(t, err)
+	return req
+}
+
+func TestRequestHistory(t *testing.T) {
+	timing.MockMode = true
+	history := NewRequestHistory(10 * time.Second)
+
+	for _, maxForwards := range []int{70, 69, 68} {
+		_, err := history.Record(historyRequest(t, "history1", maxForwards), c_CLIENT)
+		assertNoError(t, err)
+	}
+	entry, ok := history.Lookup("history1", 1, base.OPTIONS)
+	if !ok || entry.Count() != 3 {
+		t.Fatalf("[FAIL] expected 3 sightings, got %v", entry)
+	}
+	if entry.Sightings[2].MaxForwards != 68 || entry.Sightings[0].Source != c_CLIENT {
+		t.Errorf("[FAIL] unexpected sightings %v", entry.Sightings)
+	}
+	if !entry.Looping(3) || entry.Looping(4) {
+		t.Errorf("[FAIL] expected the request looping 3 times, got %s", entry)
+	}
+	if _, ok := history.Lookup("history1", 2, base.OPTIONS); ok {
+		t.Errorf("[FAIL] unexpected entry of the other CSeq")
+	}
+
+	// Max-Forwards not decreasing means a new request of the same CSeq, not a loop.
+	_, err := history.Record(historyRequest(t, "history2", 70), c_CLIENT)
+	assertNoError(t, err)
+	entry, err = history.Record(historyRequest(t, "history2", 70), c_CLIENT)
+	assertNoError(t, err)
+	if entry.Count() != 2 || entry.Looping(2) {
+		t.Errorf("[FAIL] expected the request seen twice without looping, got %s", entry)
+	}
+
+	timing.Elapse(10 * time.Second)
+	if _, ok := history.Lookup("history1", 1, base.OPTIONS); ok {
+		t.Errorf("[FAIL] expected the sightings expired")
+	}
+	if history.Len() != 0 {
+		t.Errorf("[FAIL] expected the expired entries swept, got %d", history.Len())
+	}
+}
+
+type setRequestHistory struct {
+	window  time.Duration
+	looping int
+}
+
+func (actn *setRequestHistory) Act(test *transactionTest) error {
+	test.tm.SetRequestHistory(actn.window, actn.looping)
+	return nil
+}
+
+func TestTooManyHops(t *testing.T) {
+	first := historyRequest(t, "hops1", 70)
+	second := historyRequest(t, "hops1", 69)
+	third := historyRequest(t, "hops1", 68)
+
+	test := transactionTest{
+		t:   t,
+		log: log.WithField("test", t.Name()),
+		actions: []action{
+			&setRequestHistory{time.Minute, 3},
+			&transportSend{first},
+			&userRecvSrv{first},
+			&transportSend{second},
+			&userRecvSrv{second},
+			&transportSend{third},
+			&transportRecv{base.NewResponseFromRequest(third, 483, "Too Many Hops", "")},
+		}}
+	test.Execute()
+
+	if entry, ok := test.tm.RequestHistory().Lookup("hops1", 1, base.OPTIONS); !ok || entry.Count() != 3 {
+		t.Errorf("[FAIL] expected 3 sightings in the history, got %v", entry)
+	}
+}
@@ -0,0 +1,81 @@
+package transaction
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gossip/timing"
+	"github.com/ghettovoice/gossip/transport"
+)
+
+// Lockout is the FailurePolicy banning the source of too many authentication failures in a time window
+// for a while, to blunt brute-force attacks on registrations. The requests of the banned sources are dropped
+// by the transports the blacklist is set to, see transport.BlacklistApplier.
+type Lockout struct {
+	blacklist   *transport.Blacklist
+	maxFailures int
+	window      time.Duration
+	ban         time.Duration
+	failures    map[string][]time.Time // Moments of the failures in the window by source.
+	lock        sync.Mutex
+}
+
+// NewLockout creates the policy banning the source for ban after maxFailures failures within window.
+func NewLockout(blacklist *transport.Blacklist, maxFailures int, window time.Duration, ban time.Duration) *Lockout {
+	return &Lockout{
+		blacklist:   blacklist,
+		maxFailures: maxFailures,
+		window:      window,
+		ban:         ban,
+		failures:    make(map[string][]time.Time),
+	}
+}
+
+// Policy returns the failure policy of the authenticator backed by the lockout.
+func (lockout *Lockout) Policy() FailurePolicy {
+	return lockout.Record
+}
+
+// Record counts the failure of its source, bans the source once it reaches the limit.
+func (lockout *Lockout) Record(failure AuthFailure) {
+	if failure.Source == "" {
+		return
+	}
+	now := timing.Now()
+
+	lockout.lock.Lock()
+	recent := lockout.failures[failure.Source][:0]
+	for _, moment := range lockout.failures[failure.Source] {
+		if now.Sub(moment) < lockout.window {
+			recent = append(recent, moment)
+		}
+	}
+	recent = append(recent, now)
+	banned := len(recent) >= lockout.maxFailures
+	if banned {
+		delete(lockout.failures, failure.Source)
+	} else {
+		lockout.failures[failure.Source] = recent
+	}
+	lockout.lock.Unlock()
+
+	if banned {
+		failure.Request.Log().Warnf("source %s banned for %v after %d authentication failures, last of user %s",
+			failure.Source, lockout.ban, len(recent), failure.User)
+		lockout.blacklist.Ban(failure.Source, lockout.ban)
+	}
+}
+
+// Failures returns the number of failures of the source counted in the current window.
+func (lockout *Lockout) Failures(source string) int {
+	now := timing.Now()
+	lockout.lock.Lock()
+	defer lockout.lock.Unlock()
+	count := 0
+	for _, moment := range lockout.failures[source] {
+		if now.Sub(moment) < lockout.window {
+			count++
+		}
+	}
+	return count
+}
@@ -1,36 +1,148 @@
 package transaction
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/ghettovoice/gossip/base"
 	"github.com/ghettovoice/gossip/log"
-	"github.com/ghettovoice/gossip/timing"
 	"github.com/ghettovoice/gossip/transport"
 )
 
 var (
 	global *Manager = &Manager{
-		store: newStore(),
+		store: newStore(NewMapStore()),
 	}
 )
 
+// OverloadPolicy defines how new non-INVITE requests are treated when the server transaction limit is reached.
+// New INVITE requests are always rejected with 503 Service Unavailable.
+type OverloadPolicy int
+
+const (
+	// OverloadDrop silently drops the request.
+	OverloadDrop OverloadPolicy = iota
+	// OverloadReject responds with 503 Service Unavailable.
+	OverloadReject
+)
+
+// PriorityAction is the decision of PriorityPolicy on a new request.
+type PriorityAction int
+
+const (
+	// PriorityNormal handles the request as usual.
+	PriorityNormal PriorityAction = iota
+	// PriorityAdmit admits the request even when the server transaction limit is reached.
+	PriorityAdmit
+	// PriorityReject rejects the request with 417 Unknown Resource-Priority - RFC 4412 4.6.2.
+	PriorityReject
+)
+
+// PriorityPolicy decides how a new request with Resource-Priority header is handled - RFC 4412.
+// It's called for every request which would create a server transaction and carries resource priorities.
+type PriorityPolicy func(req *base.Request, priorities []base.ResourcePriority) PriorityAction
+
+// AdmissionPolicy decides whether a new dialog creating INVITE request is admitted, e.g. by the trunk capacity.
+// Zero status admits the request, otherwise it's rejected with the status and the reason.
+type AdmissionPolicy func(req *base.Request) (status uint16, reason string)
+
+// ThrottlePolicy limits the rate of new out-of-dialog requests, e.g. by the AOR of the endpoint sending them.
+// Zero status passes the request, otherwise it's rejected with the status and the reason,
+// carrying Retry-After of the retryAfter interval unless it is 0.
+type ThrottlePolicy func(req *base.Request) (status uint16, reason string, retryAfter time.Duration)
+
+// Route is the decision of Router on a new request.
+type Route struct {
+	// Recipient replaces the Request-URI of the request if not nil, e.g. after local number translation.
+	Recipient base.Uri
+	// Forward is the address the request should be forwarded to, empty means the request is handled locally.
+	Forward string
+}
+
+// IsLocal reports whether the request should be handled locally.
+func (route Route) IsLocal() bool {
+	return route.Forward == ""
+}
+
+// Router retargets a new request before the server transaction is created, e.g. by ENUM lookup.
+// It must not modify the request, the decision is available via ServerTransaction.Route.
+type Router func(req *base.Request) Route
+
+// DialogOverride decides whether the in-dialog request failed the dialog validation is handled anyway,
+// e.g. by a B2BUA that re-creates dialogs of the other leg lazily.
+// err is the validation error of base.ErrNoDialog kind.
+type DialogOverride func(req *base.Request, err error) bool
+
+// SchemePolicy defines how requests with Request-URI of unsupported scheme are treated.
+type SchemePolicy int
+
+const (
+	// SchemeReject responds with 416 Unsupported URI Scheme - RFC 3261 8.2.2.1.
+	SchemeReject SchemePolicy = iota
+	// SchemePass passes the request to the user, e.g. to a proxy able to route it.
+	SchemePass
+)
+
 type Manager struct {
 	*store
 	transport transport.Manager
 	requests  chan *ServerTransaction
 	// not matched responses
 	responses chan *base.Response
+	// configuration swapped by Reload
+	cfg         Config
+	configLock  sync.RWMutex
+	reloadHooks []ReloadHook
+	reloadLock  sync.Mutex
+	// handlers of in-dialog requests by dialog
+	dialogHandlers map[base.DialogId]DialogHandler
+	// handlers of new requests by method
+	requestHandlers map[base.Method]RequestHandler
+	defaultHandler  RequestHandler
+	allowedMethods  map[base.Method]bool
+	passUnallowed   bool
+	handlersLock    sync.RWMutex
+	draining        bool
+	retryAfter      time.Duration
+	drainLock       sync.RWMutex
+	// counters of NOTIFY requests without a subscription
+	notifyStats NotifyStats
+	notifyLock  sync.Mutex
+	// INVITE server transactions retransmitting 2xx until ACK, by acceptedKey
+	accepted     map[string]*ServerTransaction
+	acceptedLock sync.Mutex
+	// server transactions answered on the response deadline
+	responseTimeouts chan ResponseTimeout
 }
 
 func NewManager(t transport.Manager, addr string) (*Manager, error) {
+	return NewManagerWithConfig(t, addr, Config{})
+}
+
+// NewManagerWithConfig creates the manager with the configuration, e.g. the transaction timers tuned for the network.
+// The configuration can be changed later by Reload.
+func NewManagerWithConfig(t transport.Manager, addr string, cfg Config) (*Manager, error) {
+	return NewManagerWithStore(t, addr, cfg, NewMapStore())
+}
+
+// NewManagerWithStore creates the manager keeping its transactions in the store,
+// e.g. a sharded map for high loads or a store limiting its size. The store must be empty and not shared with other managers.
+func NewManagerWithStore(t transport.Manager, addr string, cfg Config, txs Store) (*Manager, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	mng := &Manager{
 		transport: t,
-		store:     newStore(),
+		store:     newStore(txs),
+		cfg:       cfg,
 	}
 
 	mng.requests = make(chan *ServerTransaction, 5)
 	mng.responses = make(chan *base.Response, 5)
+	mng.responseTimeouts = make(chan ResponseTimeout, c_RESPONSE_TIMEOUTS_QUEUE_SIZE)
 	log.Debug("run transaction manager")
 	// Spin up a goroutine to pull messages up from the depths.
 	c := mng.transport.GetChannel()
@@ -55,6 +167,135 @@ func (mng *Manager) Stop() {
 	mng.transport.Stop()
 }
 
+// SetMaxServerTransactions limits the number of simultaneous server transactions, 0 disables the limit.
+// Beyond the limit new INVITE requests are rejected with 503 Service Unavailable,
+// other requests are handled according to the policy.
+// Can be changed at runtime, see Reload.
+func (mng *Manager) SetMaxServerTransactions(max int, policy OverloadPolicy) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.MaxServerTransactions = max
+	mng.cfg.Overload = policy
+}
+
+// SetMaxServerTransactionsPerSource limits the number of simultaneous server transactions of a single source host,
+// 0 disables the limit, so a flood from one host can't take the transactions of the others.
+// The source is the received parameter of the top Via hop or its sent-by host, see transport.SourceHost.
+// Beyond the limit the requests of the source are handled like beyond SetMaxServerTransactions.
+// Can be changed at runtime, see Reload.
+func (mng *Manager) SetMaxServerTransactionsPerSource(max int) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.MaxServerTransactionsPerSource = max
+}
+
+// SetOverloadRetryAfter sets Retry-After of 503 Service Unavailable rejecting requests beyond the server transaction limits,
+// so that clients back off or turn to other servers for the interval - RFC 3261 21.5.4, 0 omits Retry-After.
+// Can be changed at runtime, see Reload.
+func (mng *Manager) SetOverloadRetryAfter(retryAfter time.Duration) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.OverloadRetryAfter = retryAfter
+}
+
+// SetMaxMessageSize limits the size of the received messages in bytes, 0 disables the limit.
+// Larger requests are rejected with 413 Request Entity Too Large, larger responses are dropped.
+// Can be changed at runtime, see Reload.
+func (mng *Manager) SetMaxMessageSize(size int) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.MaxMessageSize = size
+}
+
+// SetPriorityPolicy sets the hook prioritizing requests by their resource priorities, nil disables it.
+// Can be changed at runtime, see Reload.
+func (mng *Manager) SetPriorityPolicy(policy PriorityPolicy) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.Priority = policy
+}
+
+// SetAdmissionPolicy sets the hook admitting new dialogs, nil admits all of them.
+// Can be changed at runtime, see Reload.
+func (mng *Manager) SetAdmissionPolicy(policy AdmissionPolicy) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.Admission = policy
+}
+
+// SetThrottlePolicy sets the hook limiting the rate of new out-of-dialog requests other than ACK and CANCEL,
+// nil disables it. Can be changed at runtime, see Reload.
+func (mng *Manager) SetThrottlePolicy(policy ThrottlePolicy) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.Throttle = policy
+}
+
+// SetSanitizer sets the checker of received messages against header smuggling, nil disables it.
+// Failed requests are rejected with 400 Bad Request stating the violated rule, failed responses are dropped.
+// Can be changed at runtime, see Reload.
+func (mng *Manager) SetSanitizer(sanitizer *base.Sanitizer) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.Sanitizer = sanitizer
+}
+
+// SetRouter sets the hook retargeting new requests, nil handles all requests locally.
+// Can be changed at runtime, see Reload.
+func (mng *Manager) SetRouter(router Router) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.Router = router
+}
+
+// SetDialogValidation enables strict validation of in-dialog requests against the dialogs, nil disables it.
+// New in-dialog requests not matching a dialog by Call-ID, To and From tags
+// are answered with 481 Call/Transaction Does Not Exist, unless the override accepts them.
+// Can be changed at runtime, see Reload.
+func (mng *Manager) SetDialogValidation(dialogs base.DialogLookup, override DialogOverride) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.Dialogs = dialogs
+	mng.cfg.DialogOverride = override
+}
+
+// SetSchemePolicy sets how requests to unsupported URI schemes, e.g. http:, are treated.
+// They are rejected by default. Can be changed at runtime, see Reload.
+func (mng *Manager) SetSchemePolicy(policy SchemePolicy) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.Schemes = policy
+}
+
+// SetLoopDetection enables rejecting looped requests with 482 Loop Detected, nil disables it - RFC 3261 16.3 item 4.
+// own recognizes Via hops of this proxy, which must be added with base.GenerateLoopBranch;
+// spiraled requests, passed the proxy before but changed since, are handled as usual.
+// Can be changed at runtime, see Reload.
+func (mng *Manager) SetLoopDetection(own base.HopMatcher) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.OwnHops = own
+}
+
+// SetMergedRequestDetection enables answering merged requests with 482 Loop Detected - RFC 3261 8.2.2.2:
+// a new request without To tag with From tag, Call-ID and CSeq of a request in progress arrived by another path,
+// e.g. forked by a proxy, so only the first copy is processed. Enable it on UAS only: spirals through a proxy look the same.
+// Can be changed at runtime, see Reload.
+func (mng *Manager) SetMergedRequestDetection(enabled bool) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.DetectMerged = enabled
+}
+
+// SetCopyOnPass enables passing responses up to the TU as copies, so the TU owns its snapshot
+// and can read or change it while the transaction keeps using the received response.
+// Can be changed at runtime, see Reload.
+func (mng *Manager) SetCopyOnPass(copyOnPass bool) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.CopyOnPass = copyOnPass
+}
+
 func (mng *Manager) Requests() <-chan *ServerTransaction {
 	return (<-chan *ServerTransaction)(mng.requests)
 }
@@ -64,10 +305,33 @@ func (mng *Manager) Responses() <-chan *base.Response {
 	return (<-chan *base.Response)(mng.responses)
 }
 
+// FlowFailures returns channel where the transport layer reports broken flows,
+// nil channel if the transport doesn't maintain flows.
+func (mng *Manager) FlowFailures() <-chan transport.FlowFailure {
+	if monitor, ok := mng.transport.(transport.FlowMonitor); ok {
+		return monitor.FlowFailures()
+	}
+	return nil
+}
+
 func (mng *Manager) handle(msg base.SipMessage) {
 	msg.Log().Infof("received message: %s", msg.Short())
 	msg.Log().Debugf("received message:\r\n%s", msg.String())
 
+	cfg := mng.Config()
+	if max := cfg.MaxMessageSize; max > 0 {
+		if size := len(msg.String()); size > max {
+			mng.rejectTooLarge(msg, size, max)
+			return
+		}
+	}
+	if cfg.Sanitizer != nil {
+		if err := cfg.Sanitizer.Check(msg); err != nil {
+			mng.rejectMalformed(msg, err)
+			return
+		}
+	}
+
 	switch m := msg.(type) {
 	// acts as UAS, Server Transaction - RFC 3261 17.2
 	case *base.Request:
@@ -82,6 +346,40 @@ func (mng *Manager) handle(msg base.SipMessage) {
 
 // Create Client transaction.
 func (mng *Manager) Send(req *base.Request, dest string) *ClientTransaction {
+	return mng.send(req, dest, nil, mng.Config().Timers.values())
+}
+
+// SendWithTimers creates client transaction with its own timers instead of the ones of the manager configuration,
+// e.g. for the destination known to be reached over a high-latency link.
+func (mng *Manager) SendWithTimers(req *base.Request, dest string, timers Timers) (*ClientTransaction, error) {
+	if err := timers.Validate(); err != nil {
+		return nil, err
+	}
+	return mng.send(req, dest, nil, timers.values()), nil
+}
+
+// SendWithContext creates client transaction bound to the context, the context done gives the transaction up:
+// INVITE is cancelled with CANCEL and completes with its final response as usual,
+// other requests are no longer retransmitted and the transaction is terminated with ErrCancelled error
+// wrapping the context error, unless the final response was received before.
+// The request is not sent if the context is already done.
+func (mng *Manager) SendWithContext(ctx context.Context, req *base.Request, dest string) (*ClientTransaction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, base.NewError(base.ErrCancelled, err, "request %s not sent", req.Short())
+	}
+	tx := mng.Send(req, dest)
+	go func() {
+		select {
+		case <-ctx.Done():
+			tx.abandon(ctx.Err())
+		case <-tx.Done():
+		}
+	}()
+	return tx, nil
+}
+
+// send creates client transaction, the retry of the challenged transaction shares its channels to the TU.
+func (mng *Manager) send(req *base.Request, dest string, challenged *ClientTransaction, times timerValues) *ClientTransaction {
 	req.Log().Infof("sending request to %v: %v", dest, req.Short())
 	req.Log().Debugf("sending request:\r\n%s", req.String())
 
@@ -90,37 +388,19 @@ func (mng *Manager) Send(req *base.Request, dest string) *ClientTransaction {
 	tx.dest = dest
 	tx.transport = mng.transport
 	tx.tm = mng
+	tx.times = times
 
 	tx.initFSM()
 
-	tx.tu = make(chan *base.Response, 3)
-	tx.tu_err = make(chan error, 1)
-
-	// RFC 3261 - 17.1.1.2
-	// If an unreliable transport is being used, the client transaction MUST start timer A with a value of T1.
-	// If a reliable transport is being used, the client transaction SHOULD NOT
-	// start timer A (Timer A controls request retransmissions).
-	// Timer A - retransmission
-	if !tx.transport.IsReliable() {
-		tx.Log().Debugf("client transaction %p, timer_a set to %v", tx, Timer_A)
-		tx.timer_a_time = Timer_A
-		tx.timer_a = timing.AfterFunc(tx.timer_a_time, func() {
-			tx.Log().Debugf("client transaction %p, timer_a fired", tx)
-			tx.fsm.Spin(client_input_timer_a)
-		})
-	}
-	// Timer B - timeout
-	tx.Log().Debugf("client transaction %p, timer_b set to %v", tx, Timer_B)
-	tx.timer_b = timing.AfterFunc(Timer_B, func() {
-		tx.Log().Debugf("client transaction %p, timer_b fired", tx)
-		tx.fsm.Spin(client_input_timer_b)
-	})
-
-	// Timer D is set to 32 seconds for unreliable transports, and 0 seconds otherwise.
-	if tx.transport.IsReliable() {
-		tx.timer_d_time = 0
+	if challenged != nil {
+		tx.tu = challenged.tu
+		tx.tu_err = challenged.tu_err
+		tx.done = challenged.done
+		tx.authorized = true
 	} else {
-		tx.timer_d_time = Timer_D
+		tx.tu = make(chan *base.Response, 3)
+		tx.tu_err = make(chan error, 1)
+		tx.done = make(chan struct{})
 	}
 
 	err := mng.transport.Send(dest, req)
@@ -128,6 +408,10 @@ func (mng *Manager) Send(req *base.Request, dest string) *ClientTransaction {
 		tx.Log().Warnf("failed to send request %s: %s", req.Short(), err)
 		tx.lastErr = err
 		tx.fsm.Spin(client_input_transport_err)
+	} else {
+		// The timers are started once the request is sent, since the transport may have switched
+		// the request to a reliable protocol, see transport.ReliabilityReporter.
+		tx.initTimers()
 	}
 
 	if err := mng.putClientTx(tx); err != nil {
@@ -147,6 +431,9 @@ func (mng *Manager) correlate(res *base.Response) {
 		res.Log().Warn(err)
 		// RFC 3261 - 17.1.1.2.
 		// Not matched responses should be passed directly to the UA
+		if mng.Config().CopyOnPass {
+			res = res.Copy()
+		}
 		mng.responses <- res
 		return
 	}
@@ -163,34 +450,104 @@ func (mng *Manager) request(req *base.Request) {
 		tx.Receive(req)
 		return
 	}
+	if req.IsAck() {
+		mng.acknowledge(req)
+	}
 
-	req.Log().Debugf("creating new server transaction for request %s", req.Short())
-	// Create a new transaction
-	tx = &ServerTransaction{}
-	tx.tm = mng
-	tx.origin = req
-	tx.transport = mng.transport
-
-	// Use the remote address in the top Via header.  This is not correct behaviour.
-	port := uint16(5060)
-	hop, err := req.ViaHop()
+	dest, err := viaAddr(req)
 	if err != nil {
-		tx.Log().Warnf("failed to process request %s: %s transaction will be dropped", req.Short(), err)
+		req.Log().Warnf("failed to process request %s: %s transaction will be dropped", req.Short(), err)
 		return
 	}
 
-	if hop.Port != nil {
-		port = *hop.Port
+	if req.Method == base.CANCEL {
+		if invite, ok := mng.getCancelledTx(req); ok {
+			mng.cancelInvite(req, dest, invite)
+			return
+		}
+		mng.rejectCancel(req, dest)
+		return
 	}
 
-	tx.dest = fmt.Sprintf("%s:%d", hop.Host, port)
-	tx.transport = mng.transport
+	if _, ok := req.Recipient.(*base.AbsoluteUri); ok && mng.Config().Schemes == SchemeReject {
+		mng.rejectScheme(req, dest)
+		return
+	}
 
-	tx.initFSM()
+	if err := mng.validateDialog(req); err != nil {
+		mng.rejectNoDialog(req, dest, err)
+		return
+	}
 
-	tx.tu = make(chan *base.Response, 3)
-	tx.tu_err = make(chan error, 1)
-	tx.ack = make(chan *base.Request, 1)
+	handler := mng.dialogHandler(req)
+	if handler == nil && mng.isStray(req) {
+		mng.rejectNoDialog(req, dest, base.NewError(base.ErrNoDialog, nil, "no handler of dialog of request %s", req.Short()))
+		return
+	}
+	if handler == nil && mng.unsolicitedNotify(req, dest) {
+		return
+	}
+
+	if own := mng.Config().OwnHops; own != nil && base.DetectLoop(req, own) == base.Loop {
+		mng.rejectLoop(req, dest)
+		return
+	}
+
+	if mng.recordHistory(req, dest) {
+		mng.rejectTooManyHops(req, dest)
+		return
+	}
+
+	if mng.Config().DetectMerged && !req.IsAck() && req.Method != base.CANCEL {
+		if merged, ok := mng.getMergedTx(req); ok {
+			mng.rejectMerged(req, dest, merged)
+			return
+		}
+	}
+
+	if stateless := mng.Config().Stateless; stateless != nil && stateless(req) {
+		req.Log().Debugf("request %s handled statelessly", req.Short())
+		return
+	}
+
+	if mng.drained(req) {
+		mng.rejectDrained(req, dest)
+		return
+	}
+
+	if status, reason, retryAfter := mng.throttled(req); status != 0 {
+		mng.rejectThrottled(req, dest, status, reason, retryAfter)
+		return
+	}
+
+	action := mng.prioritize(req)
+	if action == PriorityReject {
+		mng.rejectPriority(req, dest)
+		return
+	}
+
+	if action != PriorityAdmit {
+		if reason := mng.overloaded(req); reason != "" {
+			mng.rejectOverloaded(req, dest, reason)
+			return
+		}
+	}
+
+	if status, reason := mng.admit(req); status != 0 {
+		mng.rejectAdmission(req, dest, status, reason)
+		return
+	}
+
+	var route Route
+	if router := mng.Config().Router; router != nil && !req.IsAck() {
+		route = router(req)
+		if !route.IsLocal() || route.Recipient != nil {
+			req.Log().Debugf("request %s retargeted to %v via %s", req.Short(), route.Recipient, route.Forward)
+		}
+	}
+
+	tx = mng.newServerTx(req, dest)
+	tx.route = route
 
 	// RFC 3261 8.2.6.1
 	// UASs SHOULD NOT issue a provisional response for a non-INVITE request.
@@ -207,11 +564,302 @@ func (mng *Manager) request(req *base.Request) {
 
 	// put tx to store, to match retransmitting requests later
 	// todo check RFC for ACK
-	mng.putServerTx(tx)
+	stored, err := mng.storeServerTx(tx)
+	if err != nil {
+		// The store refused the transaction, e.g. it's full, retransmissions couldn't be matched.
+		tx.Log().Warnf("failed to store server transaction %p: %s", tx, err)
+		tx.RespondWithStatus(503, "Service Unavailable")
+		return
+	}
+	if !stored {
+		return
+	}
 
+	if !mng.authenticate(tx) {
+		return
+	}
+	mng.startResponseDeadline(tx)
+	if handler != nil {
+		handler(tx)
+		return
+	}
+	if handler := mng.requestHandler(req); handler != nil {
+		handler(tx)
+		return
+	}
 	mng.requests <- tx
 }
 
+// newServerTx creates the server transaction of the request received from dest.
+func (mng *Manager) newServerTx(req *base.Request, dest string) *ServerTransaction {
+	req.Log().Debugf("creating new server transaction for request %s", req.Short())
+	tx := &ServerTransaction{}
+	tx.tm = mng
+	tx.origin = req
+	tx.dest = dest
+	tx.transport = mng.transport
+	cfg := mng.Config()
+	tx.times = cfg.Timers.values()
+	tx.retransmit2xx = cfg.Retransmit2xx && req.IsInvite()
+
+	tx.initFSM()
+
+	tx.tu = make(chan *base.Response, 3)
+	tx.tu_err = make(chan error, 1)
+	tx.ack = make(chan *base.Request, 1)
+	tx.done = make(chan struct{})
+	tx.cancelled = make(chan struct{})
+	return tx
+}
+
+// storeServerTx puts the new server transaction to the store to match the retransmissions, reports whether it's stored.
+// If a copy of the request received concurrently has stored its transaction first, the new one is discarded
+// and the request is received by the stored one.
+func (mng *Manager) storeServerTx(tx *ServerTransaction) (bool, error) {
+	err := mng.putServerTx(tx)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, base.ErrTransactionExists) {
+		return false, err
+	}
+
+	tx.discard()
+	stored, err := mng.getServerTx(tx.origin)
+	if err != nil {
+		// The stored transaction has terminated meanwhile, the request is a late retransmission.
+		tx.Log().Debugf("request %s dropped: %s", tx.origin.Short(), err)
+		return false, nil
+	}
+	stored.Log().Debugf("found server transaction %p, receive request %s", stored, tx.origin.Short())
+	stored.Receive(tx.origin)
+	return false, nil
+}
+
+// viaAddr returns the address the responses are sent to by the top Via hop, stamped by the transport
+// with the source of the request - RFC 3261 18.2.2, see base.ResponseAddr.
+func viaAddr(msg base.SipMessage) (string, error) {
+	hop, err := msg.ViaHop()
+	if err != nil {
+		return "", err
+	}
+	return base.ResponseAddr(hop), nil
+}
+
+// overloaded checks whether a new server transaction for the request exceeds the limits, returns the exceeded one.
+// ACK requests never get a response, so they are not limited.
+func (mng *Manager) overloaded(req *base.Request) string {
+	if req.IsAck() {
+		return ""
+	}
+	cfg := mng.Config()
+	if max := cfg.MaxServerTransactions; max > 0 && mng.countServerTx() >= max {
+		return fmt.Sprintf("server transactions limit %d", max)
+	}
+	if max := cfg.MaxServerTransactionsPerSource; max > 0 {
+		if source := transport.SourceHost(req); mng.countSourceTx(source) >= max {
+			return fmt.Sprintf("server transactions limit %d of source %s", max, source)
+		}
+	}
+	return ""
+}
+
+func (mng *Manager) rejectOverloaded(req *base.Request, dest string, limit string) {
+	cfg := mng.Config()
+	if !req.IsInvite() && cfg.Overload == OverloadDrop {
+		req.Log().Warnf("%s reached, request %s dropped", limit, req.Short())
+		return
+	}
+
+	req.Log().Warnf("%s reached, request %s rejected", limit, req.Short())
+	res := base.NewResponseFromRequest(req, 503, "Service Unavailable", "")
+	if cfg.OverloadRetryAfter > 0 {
+		res.AddHeader(retryAfterHeader(cfg.OverloadRetryAfter))
+	}
+	if err := mng.transport.Send(dest, res); err != nil {
+		req.Log().Warnf("failed to send %s: %s", res.Short(), err)
+	}
+}
+
+// prioritize applies the priority policy to the request carrying resource priorities.
+func (mng *Manager) prioritize(req *base.Request) PriorityAction {
+	priority := mng.Config().Priority
+	if priority == nil || req.IsAck() {
+		return PriorityNormal
+	}
+
+	priorities := base.ResourcePriorities(req)
+	if len(priorities) == 0 {
+		return PriorityNormal
+	}
+
+	return priority(req, priorities)
+}
+
+func (mng *Manager) rejectPriority(req *base.Request, dest string) {
+	req.Log().Warnf("request %s rejected by resource priority policy", req.Short())
+	res := base.NewResponseFromRequest(req, 417, "Unknown Resource-Priority", "")
+	if err := mng.transport.Send(dest, res); err != nil {
+		req.Log().Warnf("failed to send %s: %s", res.Short(), err)
+	}
+}
+
+// throttled applies the throttle policy to the new request outside of a dialog.
+func (mng *Manager) throttled(req *base.Request) (uint16, string, time.Duration) {
+	throttle := mng.Config().Throttle
+	if throttle == nil || req.IsAck() || req.Method == base.CANCEL || base.IsInDialog(req) {
+		return 0, "", 0
+	}
+	return throttle(req)
+}
+
+func (mng *Manager) rejectThrottled(req *base.Request, dest string, status uint16, reason string, retryAfter time.Duration) {
+	req.Log().Warnf("request %s throttled: %d %s", req.Short(), status, reason)
+	res := base.NewResponseFromRequest(req, status, reason, "")
+	if retryAfter > 0 {
+		res.AddHeader(retryAfterHeader(retryAfter))
+	}
+	if err := mng.transport.Send(dest, res); err != nil {
+		req.Log().Warnf("failed to send %s: %s", res.Short(), err)
+	}
+}
+
+// admit applies the admission policy to the new INVITE request outside of a dialog.
+func (mng *Manager) admit(req *base.Request) (uint16, string) {
+	admission := mng.Config().Admission
+	if admission == nil || !req.IsInvite() || base.IsInDialog(req) {
+		return 0, ""
+	}
+	return admission(req)
+}
+
+func (mng *Manager) rejectAdmission(req *base.Request, dest string, status uint16, reason string) {
+	req.Log().Warnf("request %s rejected by admission policy: %d %s", req.Short(), status, reason)
+	res := base.NewResponseFromRequest(req, status, reason, "")
+	if err := mng.transport.Send(dest, res); err != nil {
+		req.Log().Warnf("failed to send %s: %s", res.Short(), err)
+	}
+}
+
+// rejectMalformed answers the request failed the sanitizer with 400 Bad Request.
+// The response is sent statelessly, with the violated rule in Warning header - RFC 3261 20.43.
+func (mng *Manager) rejectMalformed(msg base.SipMessage, reason error) {
+	req, ok := msg.(*base.Request)
+	if !ok || req.IsAck() {
+		msg.Log().Warnf("message %s dropped: %s", msg.Short(), reason)
+		return
+	}
+
+	dest, err := viaAddr(req)
+	if err != nil {
+		req.Log().Warnf("request %s dropped: %s", req.Short(), reason)
+		return
+	}
+
+	req.Log().Warnf("request %s rejected: %s", req.Short(), reason)
+	res := base.NewResponseFromRequest(req, 400, "Bad Request", "")
+	res.AddHeader(&base.GenericHeader{
+		HeaderName: "Warning",
+		Contents:   fmt.Sprintf("399 gossip %q", reason.Error()),
+	})
+	if err := mng.transport.Send(dest, res); err != nil {
+		req.Log().Warnf("failed to send %s: %s", res.Short(), err)
+	}
+}
+
+// rejectTooLarge answers the request exceeding the message size limit with 413 Request Entity Too Large statelessly.
+func (mng *Manager) rejectTooLarge(msg base.SipMessage, size int, max int) {
+	req, ok := msg.(*base.Request)
+	if !ok || req.IsAck() {
+		msg.Log().Warnf("message %s of %d bytes over limit %d dropped", msg.Short(), size, max)
+		return
+	}
+
+	dest, err := viaAddr(req)
+	if err != nil {
+		req.Log().Warnf("request %s of %d bytes over limit %d dropped", req.Short(), size, max)
+		return
+	}
+
+	req.Log().Warnf("request %s of %d bytes over limit %d rejected", req.Short(), size, max)
+	res := base.NewResponseFromRequest(req, 413, "Request Entity Too Large", "")
+	if err := mng.transport.Send(dest, res); err != nil {
+		req.Log().Warnf("failed to send %s: %s", res.Short(), err)
+	}
+}
+
+func (mng *Manager) rejectMerged(req *base.Request, dest string, merged *ServerTransaction) {
+	req.Log().Warnf("request %s merged with the one of server transaction %p rejected", req.Short(), merged)
+	res := base.NewResponseFromRequest(req, 482, "Loop Detected", "")
+	if err := mng.transport.Send(dest, res); err != nil {
+		req.Log().Warnf("failed to send %s: %s", res.Short(), err)
+	}
+}
+
+func (mng *Manager) rejectLoop(req *base.Request, dest string) {
+	if req.IsAck() {
+		req.Log().Warnf("looped request %s dropped", req.Short())
+		return
+	}
+
+	req.Log().Warnf("looped request %s rejected", req.Short())
+	res := base.NewResponseFromRequest(req, 482, "Loop Detected", "")
+	if err := mng.transport.Send(dest, res); err != nil {
+		req.Log().Warnf("failed to send %s: %s", res.Short(), err)
+	}
+}
+
+func (mng *Manager) rejectScheme(req *base.Request, dest string) {
+	if req.IsAck() {
+		req.Log().Warnf("request %s to unsupported URI scheme dropped", req.Short())
+		return
+	}
+
+	req.Log().Warnf("request %s to unsupported URI scheme rejected", req.Short())
+	res := base.NewResponseFromRequest(req, 416, "Unsupported URI Scheme", "")
+	if err := mng.transport.Send(dest, res); err != nil {
+		req.Log().Warnf("failed to send %s: %s", res.Short(), err)
+	}
+}
+
+// validateDialog checks the new in-dialog request against the dialogs.
+// ACK requests are validated too, since they are never answered, they are just dropped.
+func (mng *Manager) validateDialog(req *base.Request) error {
+	cfg := mng.Config()
+	if cfg.Dialogs == nil {
+		return nil
+	}
+
+	err := base.ValidateInDialog(req, cfg.Dialogs)
+	if err != nil && cfg.DialogOverride != nil && cfg.DialogOverride(req, err) {
+		req.Log().Debugf("request %s accepted by dialog override: %s", req.Short(), err)
+		return nil
+	}
+	return err
+}
+
+func (mng *Manager) rejectNoDialog(req *base.Request, dest string, reason error) {
+	if req.IsAck() {
+		req.Log().Warnf("request %s dropped: %s", req.Short(), reason)
+		return
+	}
+
+	req.Log().Warnf("request %s rejected: %s", req.Short(), reason)
+	res := base.NewResponseFromRequest(req, 481, "Call/Transaction Does Not Exist", "")
+	if err := mng.transport.Send(dest, res); err != nil {
+		req.Log().Warnf("failed to send %s: %s", res.Short(), err)
+	}
+}
+
+// rejectCancel answers CANCEL matching no INVITE transaction statelessly - RFC 3261 9.2.
+func (mng *Manager) rejectCancel(req *base.Request, dest string) {
+	req.Log().Warnf("request %s rejected: no transaction to cancel", req.Short())
+	res := base.NewResponseFromRequest(req, 481, "Call/Transaction Does Not Exist", "")
+	if err := mng.transport.Send(dest, res); err != nil {
+		req.Log().Warnf("failed to send %s: %s", res.Short(), err)
+	}
+}
+
 func (mng *Manager) sendPresumptiveTrying(tx *ServerTransaction) {
 	tx.Log().Infof("sending '100 Trying' auto response on transaction %p", tx)
 	// Pretend the user sent us a 100 to send.
@@ -0,0 +1,87 @@
+package transaction
+
+import (
+	"github.com/ghettovoice/gossip/base"
+)
+
+// NotifyPolicy defines how unsolicited NOTIFY requests are treated, the ones matching no subscription,
+// e.g. message waiting indications of legacy PBXes sent without SUBSCRIBE - RFC 3265 3.2.
+type NotifyPolicy int
+
+const (
+	// NotifyDeliver passes unsolicited NOTIFY requests to the application as other new requests.
+	NotifyDeliver NotifyPolicy = iota
+	// NotifyReject answers unsolicited NOTIFY requests with 481 Subscription Does Not Exist - RFC 3265 3.2.4.
+	NotifyReject
+)
+
+func (policy NotifyPolicy) String() string {
+	switch policy {
+	case NotifyDeliver:
+		return "Deliver"
+	case NotifyReject:
+		return "Reject"
+	default:
+		return "Unknown"
+	}
+}
+
+// SubscriptionMatcher reports whether NOTIFY belongs to a subscription the manager has no dialog handler of yet,
+// e.g. NOTIFY racing 2xx response to SUBSCRIBE - RFC 3265 3.1.4.4.
+type SubscriptionMatcher func(req *base.Request) bool
+
+// NotifyStats is the snapshot of the counters of unsolicited NOTIFY requests.
+type NotifyStats struct {
+	// Delivered is the number of unsolicited NOTIFY requests passed to the application.
+	Delivered uint64
+	// Rejected is the number of unsolicited NOTIFY requests answered with 481.
+	Rejected uint64
+}
+
+// SetNotifyPolicy sets how NOTIFY requests matching neither a registered dialog handler nor the pending subscriptions
+// are treated, nil pending matches none. They are delivered to the application by default.
+// Stray NOTIFY requests with To tag are still rejected if SetRejectStray is enabled.
+// Can be changed at runtime, see Reload.
+func (mng *Manager) SetNotifyPolicy(policy NotifyPolicy, pending SubscriptionMatcher) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.Notify = policy
+	mng.cfg.PendingSubscriptions = pending
+}
+
+// NotifyStats returns the snapshot of the counters of unsolicited NOTIFY requests.
+func (mng *Manager) NotifyStats() NotifyStats {
+	mng.notifyLock.Lock()
+	defer mng.notifyLock.Unlock()
+	return mng.notifyStats
+}
+
+// unsolicitedNotify applies the policy to NOTIFY request without a dialog handler,
+// reports whether the request was rejected.
+func (mng *Manager) unsolicitedNotify(req *base.Request, dest string) bool {
+	cfg := mng.Config()
+	if req.Method != base.NOTIFY || (cfg.PendingSubscriptions != nil && cfg.PendingSubscriptions(req)) {
+		return false
+	}
+
+	reject := cfg.Notify == NotifyReject
+	mng.notifyLock.Lock()
+	if reject {
+		mng.notifyStats.Rejected++
+	} else {
+		mng.notifyStats.Delivered++
+	}
+	mng.notifyLock.Unlock()
+
+	if !reject {
+		req.Log().Debugf("unsolicited %s delivered", req.Short())
+		return false
+	}
+
+	req.Log().Infof("unsolicited %s rejected", req.Short())
+	res := base.NewResponseFromRequest(req, 481, "Subscription Does Not Exist", "")
+	if err := mng.transport.Send(dest, res); err != nil {
+		req.Log().Warnf("failed to send %s: %s", res.Short(), err)
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package transaction
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+type setNotifyPolicy struct {
+	policy  NotifyPolicy
+	pending SubscriptionMatcher
+}
+
+func (actn *setNotifyPolicy) Act(test *transactionTest) error {
+	test.tm.SetNotifyPolicy(actn.policy, actn.pending)
+	return nil
+}
+
+type notifyStats struct {
+	expected NotifyStats
+}
+
+func (actn *notifyStats) Act(test *transactionTest) error {
+	if stats := test.tm.NotifyStats(); stats != actn.expected {
+		return fmt.Errorf("expected NOTIFY stats %+v, got %+v", actn.expected, stats)
+	}
+	return nil
+}
+
+func TestUnsolicitedNotify(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	notify := func(callId string) *base.Request {
+		req, err := request([]string{
+			"NOTIFY sip:bob@example.com SIP/2.0",
+			"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+			"From: <sip:voicemail@pbx.example.com>;tag=pbx",
+			"To: <sip:bob@example.com>",
+			"Call-Id: " + callId,
+			"CSeq: 1 NOTIFY",
+			"Event: message-summary",
+			"",
+			"",
+		}, logger)
+		assertNoError(t, err)
+		return req
+	}
+
+	mwi := notify("mwi1")
+	rejected := notify("mwi2")
+	pending := notify("subscribed")
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&transportSend{mwi},
+			&userRecvSrv{mwi},
+			&notifyStats{NotifyStats{Delivered: 1}},
+			&setNotifyPolicy{NotifyReject, func(req *base.Request) bool {
+				callId, err := req.CallId()
+				return err == nil && string(*callId) == "subscribed"
+			}},
+			&transportSend{rejected},
+			&transportRecv{base.NewResponseFromRequest(rejected, 481, "Subscription Does Not Exist", "")},
+			&transportSend{pending},
+			&userRecvSrv{pending},
+			&notifyStats{NotifyStats{Delivered: 1, Rejected: 1}},
+		}}
+	test.Execute()
+}
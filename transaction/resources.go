@@ -0,0 +1,212 @@
+package transaction
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/transport"
+)
+
+// libraryPrefix is the prefix of the functions of the library packages, e.g. github.com/ghettovoice/gossip/.
+var libraryPrefix = strings.TrimSuffix(reflect.TypeOf(Manager{}).PkgPath(), "transaction")
+
+// ResourceBudget is the expected resource usage of the service, set by SetResourceBudget.
+// Exceeding it limits nothing, it's reported by Resources, so the leaks of long-running services are noticed
+// before they exhaust the host. Zero fields mean no budget.
+type ResourceBudget struct {
+	// Goroutines is the number of goroutines of the process.
+	Goroutines int
+	// Memory is the estimated memory held by the messages of the stored transactions, in bytes.
+	Memory int64
+}
+
+// Validate checks the budget is not negative.
+func (budget ResourceBudget) Validate() error {
+	if budget.Goroutines < 0 || budget.Memory < 0 {
+		return fmt.Errorf("invalid resource budget %+v", budget)
+	}
+	return nil
+}
+
+// QueueDepth is the number of the queued items and the capacity of the queue.
+type QueueDepth struct {
+	Len int
+	Cap int
+}
+
+// Resources is the snapshot of the resources held by the manager and the process, to size deployments.
+type Resources struct {
+	// Goroutines is the number of goroutines of the process.
+	Goroutines int
+	// GoroutinesBySubsystem counts the goroutines by the library package running in them, e.g. transaction
+	// or transport, by the innermost frame of the library in their stacks; the other goroutines are counted as other.
+	GoroutinesBySubsystem map[string]int
+	// Queues are the queues of the manager by name: requests of new server transactions and unmatched responses.
+	Queues map[string]QueueDepth
+	// ClientTransactions and ServerTransactions are the numbers of the stored transactions.
+	ClientTransactions int
+	ServerTransactions int
+	// DialogHandlers is the number of the dialogs handling their requests, see HandleDialog.
+	DialogHandlers int
+	// Connections is the number of the connections open by connection oriented transports.
+	Connections int
+	// Memory is the estimated memory held by the messages of the stored transactions, in bytes:
+	// the size of their requests and the last responses.
+	Memory int64
+	// HeapInUse is the heap memory in use by the process, in bytes, see runtime.MemStats.
+	HeapInUse uint64
+	// OverBudget lists the exceeded budgets, see ResourceBudget.
+	OverBudget []string
+}
+
+// SetResourceBudget sets the resource usage reported as exceeded by Resources, see ResourceBudget.
+func (mng *Manager) SetResourceBudget(budget ResourceBudget) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.Budget = budget
+}
+
+// Resources takes the snapshot of the resources. It walks the stored transactions and the stacks of the goroutines,
+// and stops the world to read the memory statistics, so it's meant for the debug endpoints rather than hot paths.
+func (mng *Manager) Resources() Resources {
+	res := Resources{
+		Goroutines:            runtime.NumGoroutine(),
+		GoroutinesBySubsystem: goroutinesBySubsystem(),
+		Queues: map[string]QueueDepth{
+			"requests":  {len(mng.requests), cap(mng.requests)},
+			"responses": {len(mng.responses), cap(mng.responses)},
+		},
+	}
+
+	mng.store.txs.Range(func(key string, tx Transaction) bool {
+		switch tx.(type) {
+		case *ClientTransaction:
+			res.ClientTransactions++
+		case *ServerTransaction:
+			res.ServerTransactions++
+		}
+		res.Memory += messageSize(tx.Origin())
+		if last := tx.LastResponse(); last != nil {
+			res.Memory += messageSize(last)
+		}
+		return true
+	})
+
+	mng.handlersLock.RLock()
+	res.DialogHandlers = len(mng.dialogHandlers)
+	mng.handlersLock.RUnlock()
+
+	if connections, ok := mng.transport.(transport.ConnectionManager); ok {
+		res.Connections = len(connections.Connections())
+	}
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	res.HeapInUse = stats.HeapInuse
+
+	budget := mng.Config().Budget
+	if budget.Goroutines > 0 && res.Goroutines > budget.Goroutines {
+		res.OverBudget = append(res.OverBudget, fmt.Sprintf("goroutines %d over %d", res.Goroutines, budget.Goroutines))
+	}
+	if budget.Memory > 0 && res.Memory > budget.Memory {
+		res.OverBudget = append(res.OverBudget, fmt.Sprintf("memory %d over %d", res.Memory, budget.Memory))
+	}
+	return res
+}
+
+func messageSize(msg base.SipMessage) int64 {
+	if msg == nil || reflect.ValueOf(msg).IsNil() {
+		return 0
+	}
+	return int64(len(msg.String()))
+}
+
+// goroutinesBySubsystem counts the goroutines by the innermost library package in their stacks.
+func goroutinesBySubsystem() map[string]int {
+	var records []runtime.StackRecord
+	n, ok := runtime.GoroutineProfile(nil)
+	for !ok {
+		// More goroutines may start meanwhile, so leave some room.
+		records = make([]runtime.StackRecord, n+n/10+10)
+		n, ok = runtime.GoroutineProfile(records)
+	}
+
+	counts := make(map[string]int)
+	for _, record := range records[:n] {
+		subsystem := "other"
+		frames := runtime.CallersFrames(record.Stack())
+		for {
+			frame, more := frames.Next()
+			if name := strings.TrimPrefix(frame.Function, libraryPrefix); name != frame.Function {
+				if end := strings.IndexAny(name, "/."); end > 0 {
+					name = name[:end]
+				}
+				subsystem = name
+				break
+			}
+			if !more {
+				break
+			}
+		}
+		counts[subsystem]++
+	}
+	return counts
+}
+
+// resourcesHandler serves the snapshot of the resources as JSON.
+func (mng *Manager) resourcesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mng.Resources())
+}
+
+// metricsHandler serves the snapshot of the resources as gauges in Prometheus text format.
+func (mng *Manager) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	res := mng.Resources()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	gauge := func(name, help string) {
+		fmt.Fprintf(w, "# HELP gossip_%s %s\n# TYPE gossip_%s gauge\n", name, help, name)
+	}
+	gauge("goroutines", "Number of goroutines by library subsystem.")
+	subsystems := make([]string, 0, len(res.GoroutinesBySubsystem))
+	for subsystem := range res.GoroutinesBySubsystem {
+		subsystems = append(subsystems, subsystem)
+	}
+	sort.Strings(subsystems)
+	for _, subsystem := range subsystems {
+		fmt.Fprintf(w, "gossip_goroutines{subsystem=%q} %d\n", subsystem, res.GoroutinesBySubsystem[subsystem])
+	}
+	queues := make([]string, 0, len(res.Queues))
+	for name := range res.Queues {
+		queues = append(queues, name)
+	}
+	sort.Strings(queues)
+	// Samples of a family must follow its HELP and TYPE lines.
+	gauge("queue_length", "Number of items in the queues of the transaction manager.")
+	for _, name := range queues {
+		fmt.Fprintf(w, "gossip_queue_length{queue=%q} %d\n", name, res.Queues[name].Len)
+	}
+	gauge("queue_capacity", "Capacity of the queues of the transaction manager.")
+	for _, name := range queues {
+		fmt.Fprintf(w, "gossip_queue_capacity{queue=%q} %d\n", name, res.Queues[name].Cap)
+	}
+	gauge("transactions", "Number of stored transactions.")
+	fmt.Fprintf(w, "gossip_transactions{kind=\"client\"} %d\n", res.ClientTransactions)
+	fmt.Fprintf(w, "gossip_transactions{kind=\"server\"} %d\n", res.ServerTransactions)
+	gauge("dialog_handlers", "Number of dialogs handling their requests.")
+	fmt.Fprintf(w, "gossip_dialog_handlers %d\n", res.DialogHandlers)
+	gauge("connections", "Number of open transport connections.")
+	fmt.Fprintf(w, "gossip_connections %d\n", res.Connections)
+	gauge("transaction_memory_bytes", "Estimated memory held by the messages of the stored transactions.")
+	fmt.Fprintf(w, "gossip_transaction_memory_bytes %d\n", res.Memory)
+	gauge("heap_inuse_bytes", "Heap memory in use by the process.")
+	fmt.Fprintf(w, "gossip_heap_inuse_bytes %d\n", res.HeapInUse)
+	gauge("over_budget", "Number of the exceeded resource budgets.")
+	fmt.Fprintf(w, "gossip_over_budget %d\n", len(res.OverBudget))
+}
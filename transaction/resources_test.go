@@ -0,0 +1,90 @@
+package transaction
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+func TestResources(t *testing.T) {
+	tm, err := NewManager(newDummyTransport(), c_CLIENT)
+	assertNoError(t, err)
+	defer tm.Stop()
+
+	if err := tm.Reload(Config{Budget: ResourceBudget{Memory: -1}}); err == nil {
+		t.Errorf("[FAIL] expected negative resource budget rejected")
+	}
+
+	req, err := request([]string{
+		"REGISTER sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_SERVER + ";branch=" + base.GenerateBranch(),
+		"CSeq: 1 REGISTER",
+		"",
+		"",
+	}, log.WithField("test", t.Name()))
+	assertNoError(t, err)
+	tx := tm.Send(req, c_SERVER)
+	defer tx.Delete()
+
+	res := tm.Resources()
+	if res.ClientTransactions != 1 || res.ServerTransactions != 0 {
+		t.Errorf("[FAIL] expected 1 client and 0 server transactions, got %d and %d",
+			res.ClientTransactions, res.ServerTransactions)
+	}
+	if res.Memory != int64(len(req.String())) {
+		t.Errorf("[FAIL] expected memory %d of the request, got %d", len(req.String()), res.Memory)
+	}
+	if res.GoroutinesBySubsystem["transaction"] == 0 {
+		t.Errorf("[FAIL] expected goroutines of transaction subsystem, got %v", res.GoroutinesBySubsystem)
+	}
+	if depth := res.Queues["requests"]; depth.Cap != cap(tm.requests) {
+		t.Errorf("[FAIL] expected requests queue capacity %d, got %d", cap(tm.requests), depth.Cap)
+	}
+	if len(res.OverBudget) != 0 {
+		t.Errorf("[FAIL] expected no exceeded budgets without budget, got %v", res.OverBudget)
+	}
+
+	tm.SetResourceBudget(ResourceBudget{Goroutines: 1, Memory: 1})
+	if res := tm.Resources(); len(res.OverBudget) != 2 {
+		t.Errorf("[FAIL] expected goroutines and memory over budget, got %v", res.OverBudget)
+	}
+
+	handler := tm.HealthHandler()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/resources", nil))
+	var served Resources
+	if err := json.Unmarshal(rec.Body.Bytes(), &served); err != nil || served.ClientTransactions != 1 {
+		t.Errorf("[FAIL] expected resources with 1 client transaction served, got %s: %v", rec.Body.String(), err)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`gossip_transactions{kind="client"} 1`,
+		`gossip_queue_capacity{queue="requests"}`,
+		`gossip_goroutines{subsystem="transaction"}`,
+		"gossip_over_budget 2",
+	} {
+		if !strings.Contains(rec.Body.String(), line) {
+			t.Errorf("[FAIL] expected metrics with %s, got %s", line, rec.Body.String())
+		}
+	}
+	// Samples of each family are grouped under its TYPE line.
+	family := ""
+	for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			family = strings.Fields(line)[2]
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if name := strings.FieldsFunc(line, func(r rune) bool { return r == '{' || r == ' ' })[0]; name != family {
+			t.Errorf("[FAIL] sample %s outside of its family, under TYPE of %s", line, family)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package transaction
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/timing"
+)
+
+// ResponseCache keeps responses recently sent by a stateless component, e.g. a registrar answering REGISTER
+// or OPTIONS without server transactions, so that retransmitted requests get the identical response
+// without invoking the application logic again. Entries are keyed like server transactions - RFC 3261 17.2.3.
+type ResponseCache struct {
+	ttl     time.Duration
+	entries map[txKey]cachedResponse
+	lock    sync.Mutex
+}
+
+type cachedResponse struct {
+	res     *base.Response
+	expires time.Time
+}
+
+// NewResponseCache creates the cache keeping responses for ttl, e.g. 64*T1 to cover all retransmissions of the request.
+func NewResponseCache(ttl time.Duration) *ResponseCache {
+	return &ResponseCache{
+		ttl:     ttl,
+		entries: make(map[txKey]cachedResponse),
+	}
+}
+
+// Put remembers the response sent to the request. Provisional responses are not cached.
+func (cache *ResponseCache) Put(req *base.Request, res *base.Response) error {
+	if res.IsProvisional() {
+		return nil
+	}
+	key, err := makeServerTxKey(req)
+	if err != nil {
+		return err
+	}
+
+	now := timing.Now()
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.sweep(now)
+	cache.entries[key] = cachedResponse{res, now.Add(cache.ttl)}
+	return nil
+}
+
+// Get returns the response sent to the earlier copy of the request, or nil if there is none or it has expired.
+func (cache *ResponseCache) Get(req *base.Request) *base.Response {
+	key, err := makeServerTxKey(req)
+	if err != nil {
+		return nil
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	entry, ok := cache.entries[key]
+	if !ok {
+		return nil
+	}
+	if !timing.Now().Before(entry.expires) {
+		delete(cache.entries, key)
+		return nil
+	}
+	return entry.res
+}
+
+// Len returns the number of cached responses, including expired ones not yet swept.
+func (cache *ResponseCache) Len() int {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	return len(cache.entries)
+}
+
+// sweep drops the expired entries, must be called under the lock.
+func (cache *ResponseCache) sweep(now time.Time) {
+	for key, entry := range cache.entries {
+		if !now.Before(entry.expires) {
+			delete(cache.entries, key)
+		}
+	}
+}
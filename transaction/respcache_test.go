@@ -0,0 +1,56 @@
+package transaction
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/timing"
+)
+
+func TestResponseCacheAnswersRetransmissions(t *testing.T) {
+	timing.MockMode = true
+	logger := log.WithField("test", t.Name())
+	register := func(branch string) *base.Request {
+		req, err := request([]string{
+			"REGISTER sip:example.com SIP/2.0",
+			"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + branch,
+			"From: <sip:alice@example.com>;tag=1",
+			"To: <sip:alice@example.com>",
+			"Call-Id: cache1",
+			"CSeq: 1 REGISTER",
+			"",
+			"",
+		}, logger)
+		assertNoError(t, err)
+		return req
+	}
+
+	cache := NewResponseCache(32 * time.Second)
+	req := register("z9hG4bK-cache-1")
+	if res := cache.Get(req); res != nil {
+		t.Fatalf("[FAIL] unexpected cached response %s", res.Short())
+	}
+	assertNoError(t, cache.Put(req, base.NewResponseFromRequest(req, 100, "Trying", "")))
+	if res := cache.Get(req); res != nil {
+		t.Errorf("[FAIL] provisional response %s should not be cached", res.Short())
+	}
+
+	ok := base.NewResponseFromRequest(req, 200, "OK", "")
+	assertNoError(t, cache.Put(req, ok))
+	if res := cache.Get(register("z9hG4bK-cache-1")); res != ok {
+		t.Errorf("[FAIL] expected cached response to the retransmission, got %v", res)
+	}
+	if res := cache.Get(register("z9hG4bK-cache-2")); res != nil {
+		t.Errorf("[FAIL] unexpected cached response to the new request: %s", res.Short())
+	}
+
+	timing.Elapse(32 * time.Second)
+	if res := cache.Get(req); res != nil {
+		t.Errorf("[FAIL] expected response expired, got %s", res.Short())
+	}
+	if cache.Len() != 0 {
+		t.Errorf("[FAIL] expected expired response dropped, %d left", cache.Len())
+	}
+}
@@ -0,0 +1,108 @@
+package transaction
+
+import (
+	"github.com/ghettovoice/gossip/base"
+)
+
+// ResponseOption customizes the response built by RespondWith.
+type ResponseOption func(opts *responseOptions)
+
+type responseOptions struct {
+	body        string
+	contentType string
+	hdrs        []base.SipHeader
+	toTag       string
+	noToTag     bool
+	recordRoute bool
+}
+
+// WithBody sets the body of the response and its Content-Type, e.g. application/sdp.
+func WithBody(contentType string, body string) ResponseOption {
+	return func(opts *responseOptions) {
+		opts.contentType = contentType
+		opts.body = body
+	}
+}
+
+// WithHeaders adds the headers to the response.
+func WithHeaders(hdrs ...base.SipHeader) ResponseOption {
+	return func(opts *responseOptions) {
+		opts.hdrs = append(opts.hdrs, hdrs...)
+	}
+}
+
+// WithToTag sets To tag of the response instead of the one of the transaction, e.g. of another fork of the dialog.
+func WithToTag(tag string) ResponseOption {
+	return func(opts *responseOptions) {
+		opts.toTag = tag
+		opts.noToTag = false
+	}
+}
+
+// WithoutToTag leaves the response without To tag, e.g. a response establishing no dialog sent statelessly.
+func WithoutToTag() ResponseOption {
+	return func(opts *responseOptions) {
+		opts.toTag = ""
+		opts.noToTag = true
+	}
+}
+
+// WithRecordRoute copies Record-Route of the request to the response, as the dialog creating responses must - RFC 3261 12.1.1.
+func WithRecordRoute() ResponseOption {
+	return func(opts *responseOptions) {
+		opts.recordRoute = true
+	}
+}
+
+// BuildResponse builds the response to the request customized by the options. Unless it's 100 Trying
+// or the options say otherwise, To tag of the response is the given one, the tag of the server transaction.
+func BuildResponse(req *base.Request, toTag string, code uint16, reason string, opts ...ResponseOption) *base.Response {
+	var options responseOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	res := base.NewResponseFromRequest(req, code, reason, "")
+	switch {
+	case options.noToTag:
+		toTag = ""
+	case options.toTag != "":
+		toTag = options.toTag
+	case code == 100:
+		toTag = ""
+	}
+	if to, err := res.To(); err == nil && toTag != "" {
+		if _, ok := to.Params.Get("tag"); !ok {
+			to.Params.Add("tag", base.String{S: toTag})
+		}
+	}
+	if options.recordRoute {
+		base.CopyHeaders("Record-Route", req, res)
+	}
+	for _, h := range options.hdrs {
+		res.AddHeader(h)
+	}
+	if options.contentType != "" {
+		res.SetHeader(&base.GenericHeader{HeaderName: "Content-Type", Contents: options.contentType}, true)
+	}
+	if options.body != "" {
+		res.SetBody(options.body)
+	}
+	return res
+}
+
+// NewResponseWith builds the response to the origin request like NewResponse, customized by the options.
+func (tx *ServerTransaction) NewResponseWith(code uint16, reason string, opts ...ResponseOption) *base.Response {
+	return BuildResponse(tx.origin, tx.tag(), code, reason, opts...)
+}
+
+// RespondWith builds the response with NewResponseWith and sends it on the transaction, e.g.
+//
+//	tx.RespondWith(200, "OK", WithBody("application/sdp", answer), WithRecordRoute())
+//
+// Returns the sent response, e.g. to create the dialog from it.
+func (tx *ServerTransaction) RespondWith(code uint16, reason string, opts ...ResponseOption) *base.Response {
+	res := tx.NewResponseWith(code, reason, opts...)
+	tx.Respond(res)
+	return res
+}
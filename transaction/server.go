@@ -1,9 +1,11 @@
 package transaction
 
 import (
+	"sync"
+	"time"
+
 	"github.com/discoviking/fsm"
 	"github.com/ghettovoice/gossip/base"
-	"github.com/ghettovoice/gossip/timing"
 )
 
 // ServerTransaction describes SIP server transaction.
@@ -13,13 +15,26 @@ type ServerTransaction struct {
 	tu      chan *base.Response // Channel to transaction user.
 	tu_err  chan error          // Channel to report up errors to TU.
 	ack     chan *base.Request  // Channel we send the ACK up on.
-	timer_g timing.Timer
-	timer_h timing.Timer
-	timer_i timing.Timer
+	route   Route
+	user    string // Username authenticated by the Authenticator.
+	toTag   string // To tag of the responses built by the transaction.
+	tagLock sync.Mutex
+	// Closed once CANCEL of the request is received, see Cancelled.
+	cancelled  chan struct{}
+	cancelOnce sync.Once
+	// INVITE transaction retransmits 2xx until ACK, see Manager.SetRetransmit2xx.
+	retransmit2xx bool
+	timer_g_time  time.Duration // Current duration of timer G.
+	acked         bool          // ACK of 2xx is received in Accepted state.
 }
 
 func (tx *ServerTransaction) Delete() {
 	tx.Log().Debugf("deleting transaction %p from manager %p", tx, tx.tm)
+	tx.timers.StopAll()
+	if tx.retransmit2xx {
+		tx.tm.delAccepted(tx)
+	}
+	tx.terminate()
 	err := tx.tm.delServerTx(tx)
 	if err != nil {
 		tx.Log().Warn(err)
@@ -27,6 +42,38 @@ func (tx *ServerTransaction) Delete() {
 	}
 }
 
+// discard stops the transaction that was never stored, e.g. created for a copy of the request of a stored one.
+func (tx *ServerTransaction) discard() {
+	tx.timers.StopAll()
+	tx.terminate()
+}
+
+// Route returns the decision of the manager's Router on the request.
+func (tx *ServerTransaction) Route() Route {
+	return tx.route
+}
+
+// SetTimers overrides the timers of the transaction set by the manager configuration.
+// They apply to the timers started afterwards, so it should be called before the final response is sent.
+func (tx *ServerTransaction) SetTimers(timers Timers) error {
+	if err := timers.Validate(); err != nil {
+		return err
+	}
+	tx.times = timers.values()
+	return nil
+}
+
+// Target returns the request to handle or forward, i.e. the origin request with Request-URI replaced by the Router.
+// The origin request is kept intact for transaction matching.
+func (tx *ServerTransaction) Target() *base.Request {
+	if tx.route.Recipient == nil {
+		return tx.origin
+	}
+	target := tx.origin.Copy()
+	target.Recipient = tx.route.Recipient.Copy()
+	return target
+}
+
 func (tx *ServerTransaction) Receive(msg base.SipMessage) {
 	req, ok := msg.(*base.Request)
 	if !ok {
@@ -51,6 +98,9 @@ func (tx *ServerTransaction) Receive(msg base.SipMessage) {
 
 func (tx *ServerTransaction) Respond(res *base.Response) {
 	tx.lastResp = res
+	if !res.IsProvisional() {
+		tx.timers.Stop(timer_response)
+	}
 
 	var input fsm.Input
 	switch {
@@ -77,20 +127,7 @@ func (tx *ServerTransaction) Errors() <-chan error {
 
 // Trying sends 100 Trying response - RFC 3261 - 17.2.1.
 func (tx *ServerTransaction) Trying(hdrs ...base.SipHeader) {
-	trying := base.NewResponse(
-		tx.origin.SipVersion(),
-		100,
-		"Trying",
-		[]base.SipHeader{},
-		"",
-		tx.Log(),
-	)
-
-	base.CopyHeaders("Via", tx.origin, trying)
-	base.CopyHeaders("From", tx.origin, trying)
-	base.CopyHeaders("To", tx.origin, trying)
-	base.CopyHeaders("Call-Id", tx.origin, trying)
-	base.CopyHeaders("CSeq", tx.origin, trying)
+	trying := base.NewResponseFromRequest(tx.origin, 100, "Trying", "")
 	// RFC 3261 - 8.2.6.1
 	// Any Timestamp header field present in the request MUST be copied into this 100 (Trying) response.
 	// TODO delay?
@@ -105,6 +142,62 @@ func (tx *ServerTransaction) Trying(hdrs ...base.SipHeader) {
 	tx.fsm.Spin(server_input_user_1xx)
 }
 
-func (tx *ServerTransaction) Ok() {
+// NewResponse builds the response to the origin request - RFC 3261 - 8.2.6.
+// Via, From, To, Call-Id and CSeq are copied from the request. Responses other than 100 Trying get To tag,
+// generated once per transaction unless the request already has one, so all the responses establish the same dialog.
+func (tx *ServerTransaction) NewResponse(code uint16, reason string, hdrs ...base.SipHeader) *base.Response {
+	res := base.NewResponseFromRequest(tx.origin, code, reason, "")
+	if code != 100 {
+		tx.addToTag(res)
+	}
+	for _, h := range hdrs {
+		res.AddHeader(h)
+	}
+	return res
+}
+
+// addToTag adds To tag of the transaction to the response without one.
+func (tx *ServerTransaction) addToTag(res *base.Response) {
+	if to, err := res.To(); err == nil {
+		if _, ok := to.Params.Get("tag"); !ok {
+			to.Params.Add("tag", base.String{S: tx.tag()})
+		}
+	}
+}
+
+// RespondWithStatus builds the response with NewResponse and sends it on the transaction.
+// Returns the sent response, e.g. to create the dialog from it.
+func (tx *ServerTransaction) RespondWithStatus(code uint16, reason string, hdrs ...base.SipHeader) *base.Response {
+	res := tx.NewResponse(code, reason, hdrs...)
+	tx.Respond(res)
+	return res
+}
+
+// Ok sends 200 OK response.
+func (tx *ServerTransaction) Ok(hdrs ...base.SipHeader) *base.Response {
+	return tx.RespondWithStatus(200, "OK", hdrs...)
+}
 
+// Ringing sends 180 Ringing response.
+func (tx *ServerTransaction) Ringing(hdrs ...base.SipHeader) *base.Response {
+	return tx.RespondWithStatus(180, "Ringing", hdrs...)
+}
+
+// NotFound sends 404 Not Found response.
+func (tx *ServerTransaction) NotFound(hdrs ...base.SipHeader) *base.Response {
+	return tx.RespondWithStatus(404, "Not Found", hdrs...)
+}
+
+// ServerError sends 500 Server Internal Error response.
+func (tx *ServerTransaction) ServerError(hdrs ...base.SipHeader) *base.Response {
+	return tx.RespondWithStatus(500, "Server Internal Error", hdrs...)
+}
+
+func (tx *ServerTransaction) tag() string {
+	tx.tagLock.Lock()
+	defer tx.tagLock.Unlock()
+	if tx.toTag == "" {
+		tx.toTag = base.GenerateTag()
+	}
+	return tx.toTag
 }
@@ -1,11 +1,8 @@
 package transaction
 
 import (
-	"errors"
-
 	"github.com/discoviking/fsm"
 	"github.com/ghettovoice/gossip/base"
-	"github.com/ghettovoice/gossip/timing"
 )
 
 // SIP Server Transaction FSM
@@ -17,6 +14,7 @@ const (
 	server_state_proceeding
 	server_state_completed
 	server_state_confirmed
+	server_state_accepted
 	server_state_terminated
 )
 
@@ -30,6 +28,7 @@ const (
 	server_input_timer_g
 	server_input_timer_h
 	server_input_timer_i
+	server_input_timer_l
 	server_input_transport_err
 	server_input_delete
 )
@@ -47,14 +46,20 @@ func (tx *ServerTransaction) initInviteFSM() {
 	// Define States
 	tx.Log().Debugf("initialising server INVITE transaction %p FSM", tx)
 
+	// The transaction terminates on 2xx, unless it retransmits 2xx until ACK - RFC 6026 - 8.5.
+	on2xx := fsm.Outcome{server_state_terminated, tx.act_respond_delete}
+	if tx.retransmit2xx {
+		on2xx = fsm.Outcome{server_state_accepted, tx.act_accept}
+	}
+
 	// Proceeding
 	server_state_def_proceeding := fsm.State{
 		Index: server_state_proceeding,
 		Outcomes: map[fsm.Input]fsm.Outcome{
 			server_input_request:       {server_state_proceeding, tx.act_respond},
 			server_input_user_1xx:      {server_state_proceeding, tx.act_respond},
-			server_input_user_2xx:      {server_state_terminated, tx.act_respond_delete},
-			server_input_user_300_plus: {server_state_completed, tx.act_respond},
+			server_input_user_2xx:      on2xx,
+			server_input_user_300_plus: {server_state_completed, tx.act_final},
 			server_input_transport_err: {server_state_terminated, tx.act_trans_err},
 		},
 	}
@@ -64,11 +69,11 @@ func (tx *ServerTransaction) initInviteFSM() {
 		Index: server_state_completed,
 		Outcomes: map[fsm.Input]fsm.Outcome{
 			server_input_request:       {server_state_completed, tx.act_respond},
-			server_input_ack:           {server_state_confirmed, fsm.NO_ACTION},
+			server_input_ack:           {server_state_confirmed, tx.act_confirm},
 			server_input_user_1xx:      {server_state_completed, fsm.NO_ACTION},
 			server_input_user_2xx:      {server_state_completed, fsm.NO_ACTION},
 			server_input_user_300_plus: {server_state_completed, fsm.NO_ACTION},
-			server_input_timer_g:       {server_state_completed, tx.act_respond},
+			server_input_timer_g:       {server_state_completed, tx.act_retransmit},
 			server_input_timer_h:       {server_state_terminated, tx.act_timeout},
 			server_input_transport_err: {server_state_terminated, tx.act_trans_err},
 		},
@@ -82,10 +87,28 @@ func (tx *ServerTransaction) initInviteFSM() {
 			server_input_user_1xx:      {server_state_confirmed, fsm.NO_ACTION},
 			server_input_user_2xx:      {server_state_confirmed, fsm.NO_ACTION},
 			server_input_user_300_plus: {server_state_confirmed, fsm.NO_ACTION},
+			server_input_timer_h:       {server_state_confirmed, fsm.NO_ACTION},
 			server_input_timer_i:       {server_state_terminated, tx.act_delete},
 		},
 	}
 
+	// Accepted
+	// Retransmitted INVITE requests are absorbed, 2xx retransmissions of the user are passed,
+	// ACK stops retransmissions of 2xx and the transaction lasts until timer L - RFC 6026 - 8.7.
+	server_state_def_accepted := fsm.State{
+		Index: server_state_accepted,
+		Outcomes: map[fsm.Input]fsm.Outcome{
+			server_input_request:       {server_state_accepted, fsm.NO_ACTION},
+			server_input_ack:           {server_state_accepted, tx.act_acked},
+			server_input_user_1xx:      {server_state_accepted, fsm.NO_ACTION},
+			server_input_user_2xx:      {server_state_accepted, tx.act_respond},
+			server_input_user_300_plus: {server_state_accepted, fsm.NO_ACTION},
+			server_input_timer_g:       {server_state_accepted, tx.act_retransmit},
+			server_input_timer_l:       {server_state_terminated, tx.act_ack_timeout},
+			server_input_transport_err: {server_state_terminated, tx.act_trans_err},
+		},
+	}
+
 	// Terminated
 	server_state_def_terminated := fsm.State{
 		Index: server_state_terminated,
@@ -104,6 +127,7 @@ func (tx *ServerTransaction) initInviteFSM() {
 		server_state_def_proceeding,
 		server_state_def_completed,
 		server_state_def_confirmed,
+		server_state_def_accepted,
 		server_state_def_terminated,
 	)
 	if err != nil {
@@ -124,8 +148,8 @@ func (tx *ServerTransaction) initNonInviteFSM() {
 		Outcomes: map[fsm.Input]fsm.Outcome{
 			server_input_request:       {server_state_trying, fsm.NO_ACTION},
 			server_input_user_1xx:      {server_state_proceeding, tx.act_respond},
-			server_input_user_2xx:      {server_state_completed, tx.act_respond},
-			server_input_user_300_plus: {server_state_completed, tx.act_respond},
+			server_input_user_2xx:      {server_state_completed, tx.act_final},
+			server_input_user_300_plus: {server_state_completed, tx.act_final},
 		},
 	}
 
@@ -149,7 +173,7 @@ func (tx *ServerTransaction) initNonInviteFSM() {
 			server_input_user_1xx:      {server_state_completed, fsm.NO_ACTION},
 			server_input_user_2xx:      {server_state_completed, fsm.NO_ACTION},
 			server_input_user_300_plus: {server_state_completed, fsm.NO_ACTION},
-			server_input_timer_h:       {server_state_terminated, tx.act_timeout},
+			server_input_timer_h:       {server_state_terminated, tx.act_delete},
 			server_input_transport_err: {server_state_terminated, tx.act_trans_err},
 		},
 	}
@@ -187,6 +211,7 @@ func (tx *ServerTransaction) initNonInviteFSM() {
 func (tx *ServerTransaction) act_respond() fsm.Input {
 	err := tx.transport.Send(tx.dest, tx.lastResp)
 	if err != nil {
+		tx.lastErr = err
 		return server_input_transport_err
 	}
 
@@ -197,41 +222,132 @@ func (tx *ServerTransaction) act_respond() fsm.Input {
 func (tx *ServerTransaction) act_final() fsm.Input {
 	err := tx.transport.Send(tx.dest, tx.lastResp)
 	if err != nil {
+		tx.lastErr = err
 		return server_input_transport_err
 	}
 
-	// Start timer J (we just reuse timer h)
-	tx.timer_h = timing.AfterFunc(64*T1, func() {
+	// Start timer H for INVITE or timer J for non-INVITE (we just reuse timer h)
+	timeout := tx.times.j
+	if tx.IsInvite() {
+		timeout = tx.times.h
+		// Start timer G retransmitting the response over unreliable transports - RFC 3261 - 17.2.1.
+		if !isReliable(tx.transport, tx.dest, tx.lastResp) {
+			tx.timer_g_time = tx.times.g
+			tx.timers.Start(timer_g, tx.timer_g_time, func() {
+				tx.fsm.Spin(server_input_timer_g)
+			})
+		}
+	}
+	tx.setDeadline(timeout)
+	tx.timers.Start(timer_h, timeout, func() {
 		tx.fsm.Spin(server_input_timer_h)
 	})
 
 	return fsm.NO_INPUT
 }
 
+// ACK received on final non-2xx response
+func (tx *ServerTransaction) act_confirm() fsm.Input {
+	tx.clearDeadline()
+	tx.timers.Stop(timer_g)
+	tx.timers.Stop(timer_h)
+
+	// Start timer I, which is zero for reliable transports - RFC 3261 - 17.2.1.
+	timeout := tx.times.i
+	if isReliable(tx.transport, tx.dest, tx.lastResp) {
+		timeout = 0
+	}
+	tx.timers.Start(timer_i, timeout, func() {
+		tx.fsm.Spin(server_input_timer_i)
+	})
+
+	return fsm.NO_INPUT
+}
+
 // Inform user of transport error
 func (tx *ServerTransaction) act_trans_err() fsm.Input {
-	tx.tu_err <- errors.New("failed to send response")
+	tx.tu_err <- base.NewError(base.ErrTransport, tx.lastErr, "server transaction %p failed to send response", tx)
 	return server_input_delete
 }
 
 // Inform user of timeout error
 func (tx *ServerTransaction) act_timeout() fsm.Input {
-	tx.tu_err <- errors.New("transaction timed out")
+	tx.clearDeadline()
+	tx.tu_err <- base.NewError(base.ErrTimeout, nil, "server transaction %p timed out", tx)
 	return server_input_delete
 }
 
 // Just delete the transaction.
 func (tx *ServerTransaction) act_delete() fsm.Input {
+	tx.clearDeadline()
 	tx.Delete()
 	return fsm.NO_INPUT
 }
 
+// Send 2xx and wait for ACK, retransmitting 2xx on unreliable transports - RFC 3261 - 13.3.1.4.
+func (tx *ServerTransaction) act_accept() fsm.Input {
+	tx.tm.putAccepted(tx)
+	err := tx.transport.Send(tx.dest, tx.lastResp)
+	if err != nil {
+		tx.lastErr = err
+		return server_input_transport_err
+	}
+
+	if !isReliable(tx.transport, tx.dest, tx.lastResp) {
+		tx.timer_g_time = tx.times.g
+		tx.timers.Start(timer_g, tx.timer_g_time, func() {
+			tx.fsm.Spin(server_input_timer_g)
+		})
+	}
+	tx.setDeadline(tx.times.l)
+	tx.timers.Start(timer_l, tx.times.l, func() {
+		tx.fsm.Spin(server_input_timer_l)
+	})
+
+	return fsm.NO_INPUT
+}
+
+// Retransmit the final response on timer G, the interval doubles up to T2 - RFC 3261 - 17.2.1, 13.3.1.4.
+func (tx *ServerTransaction) act_retransmit() fsm.Input {
+	tx.timer_g_time *= 2
+	if tx.timer_g_time > tx.times.t2 {
+		tx.timer_g_time = tx.times.t2
+	}
+	tx.timers.Reset(timer_g, tx.timer_g_time)
+
+	err := tx.transport.Send(tx.dest, tx.lastResp)
+	if err != nil {
+		tx.lastErr = err
+		return server_input_transport_err
+	}
+	return fsm.NO_INPUT
+}
+
+// ACK of 2xx received, stop retransmitting 2xx - RFC 6026 - 8.7.
+func (tx *ServerTransaction) act_acked() fsm.Input {
+	tx.acked = true
+	tx.timers.Stop(timer_g)
+	return fsm.NO_INPUT
+}
+
+// Inform user of 2xx not acknowledged, the dialog should be terminated with BYE - RFC 3261 - 13.3.1.4.
+// The acknowledged transaction is just deleted.
+func (tx *ServerTransaction) act_ack_timeout() fsm.Input {
+	if tx.acked {
+		return server_input_delete
+	}
+	tx.clearDeadline()
+	tx.tu_err <- base.NewError(base.ErrTimeout, nil, "server transaction %p received no ACK of %s", tx, tx.lastResp.Short())
+	return server_input_delete
+}
+
 // Send response and delete the transaction.
 func (tx *ServerTransaction) act_respond_delete() fsm.Input {
 	tx.Delete()
 
 	err := tx.transport.Send(tx.dest, tx.lastResp)
 	if err != nil {
+		tx.lastErr = err
 		return server_input_transport_err
 	}
 	return fsm.NO_INPUT
@@ -1,16 +1,267 @@
 package transaction
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/ghettovoice/gossip/base"
 	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/timing"
 )
 
 func TestResendInviteOK(t *testing.T) {
 }
 
+func TestInviteServerConfirmed(t *testing.T) {
+	timing.MockMode = true
+	logger := log.WithField("test", t.Name())
+	branch := base.GenerateBranch()
+	invite, err := request([]string{
+		"INVITE sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + branch,
+		"CSeq: 1 INVITE",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+	busy, err := response([]string{
+		"SIP/2.0 486 Busy Here",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + branch,
+		"CSeq: 1 INVITE",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+	ack, err := request([]string{
+		"ACK sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + branch,
+		"CSeq: 1 ACK",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	tp := newDummyTransport()
+	tm, err := NewManager(tp, c_SERVER)
+	assertNoError(t, err)
+	defer tm.Stop()
+
+	tx := receiveServerTx(t, tm, tp, invite)
+	tx.Respond(busy)
+	expectSent(t, tp, busy)
+	if remaining := tx.TimeRemaining(); remaining != Timer_H {
+		t.Errorf("[FAIL] time remaining after final response: %v, expected timer H %v", remaining, Timer_H)
+	}
+
+	tp.toTM <- ack
+	select {
+	case <-tx.Ack():
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] timed out waiting for ACK")
+	}
+	// Timer I terminates the confirmed transaction - RFC 3261 - 17.2.1.
+	expectServerTxDeleted(t, tm, invite, Timer_I)
+	select {
+	case err := <-tx.Errors():
+		t.Errorf("[FAIL] unexpected error on confirmed transaction: %s", err)
+	default:
+	}
+}
+
+// Final non-2xx response to INVITE is retransmitted over unreliable transports on timer G,
+// its interval doubles up to T2 until ACK - RFC 3261 - 17.2.1.
+func TestInviteServerRetransmitsFinal(t *testing.T) {
+	timing.MockMode = true
+	logger := log.WithField("test", t.Name())
+	branch := base.GenerateBranch()
+	invite, err := request([]string{
+		"INVITE sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + branch,
+		"CSeq: 1 INVITE",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+	busy, err := response([]string{
+		"SIP/2.0 486 Busy Here",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + branch,
+		"CSeq: 1 INVITE",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+	ack, err := request([]string{
+		"ACK sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + branch,
+		"CSeq: 1 ACK",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	tp := newDummyTransport()
+	tm, err := NewManager(tp, c_SERVER)
+	assertNoError(t, err)
+	defer tm.Stop()
+	notSent := func() {
+		t.Helper()
+		select {
+		case msg := <-tp.messages:
+			t.Errorf("[FAIL] unexpected message sent %s", msg.msg.Short())
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	tx := receiveServerTx(t, tm, tp, invite)
+	tx.Respond(busy)
+	expectSent(t, tp, busy)
+	for _, interval := range []time.Duration{T1, 2 * T1, 4 * T1, T2, T2} {
+		timing.Elapse(interval - time.Millisecond)
+		notSent()
+		timing.Elapse(time.Millisecond)
+		expectSent(t, tp, busy)
+	}
+
+	tp.toTM <- ack
+	select {
+	case <-tx.Ack():
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] timed out waiting for ACK")
+	}
+	timing.Elapse(T2)
+	notSent()
+}
+
+func TestNonInviteServerCompleted(t *testing.T) {
+	timing.MockMode = true
+	logger := log.WithField("test", t.Name())
+	branch := base.GenerateBranch()
+	options, err := request([]string{
+		"OPTIONS sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + branch,
+		"CSeq: 1 OPTIONS",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+	ok, err := response([]string{
+		"SIP/2.0 200 OK",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + branch,
+		"CSeq: 1 OPTIONS",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	tp := newDummyTransport()
+	tm, err := NewManager(tp, c_SERVER)
+	assertNoError(t, err)
+	defer tm.Stop()
+
+	tx := receiveServerTx(t, tm, tp, options)
+	tx.Respond(ok)
+	expectSent(t, tp, ok)
+	if remaining := tx.TimeRemaining(); remaining != Timer_J {
+		t.Errorf("[FAIL] time remaining after final response: %v, expected timer J %v", remaining, Timer_J)
+	}
+
+	// Timer J just terminates the completed transaction, it is not a timeout - RFC 3261 - 17.2.2.
+	expectServerTxDeleted(t, tm, options, Timer_J)
+	select {
+	case err := <-tx.Errors():
+		t.Errorf("[FAIL] unexpected error on completed transaction: %s", err)
+	default:
+	}
+}
+
+// A copy of the request racing the first one past the transaction matching is received by the stored transaction.
+func TestServerTxCopyReceivedByStored(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	branch := base.GenerateBranch()
+	options, err := request([]string{
+		"OPTIONS sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + branch,
+		"CSeq: 1 OPTIONS",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+	ok, err := response([]string{
+		"SIP/2.0 200 OK",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + branch,
+		"CSeq: 1 OPTIONS",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	tp := newDummyTransport()
+	tm, err := NewManager(tp, c_SERVER)
+	assertNoError(t, err)
+	defer tm.Stop()
+
+	tx := receiveServerTx(t, tm, tp, options)
+	tx.Respond(ok)
+	expectSent(t, tp, ok)
+
+	dup := tm.newServerTx(options, c_CLIENT)
+	if stored, err := tm.storeServerTx(dup); stored || err != nil {
+		t.Fatalf("[FAIL] expected the copy not stored without error, got %v, %v", stored, err)
+	}
+	// The stored transaction absorbs the copy as a retransmission.
+	expectSent(t, tp, ok)
+	select {
+	case <-dup.Done():
+	default:
+		t.Errorf("[FAIL] the transaction of the copy was not discarded")
+	}
+	if stored, err := tm.getServerTx(options); err != nil || stored != tx {
+		t.Errorf("[FAIL] expected the first transaction kept, got %p, %v", stored, err)
+	}
+}
+
+// receiveServerTx passes the request to the manager and returns the created server transaction.
+func receiveServerTx(t *testing.T, tm *Manager, tp *dummyTransport, req *base.Request) *ServerTransaction {
+	tp.toTM <- req
+	select {
+	case tx := <-tm.Requests():
+		if req.IsInvite() {
+			// skip presumptive 100 Trying
+			<-tp.messages
+		}
+		return tx
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] timed out waiting for server transaction of %s", req.Short())
+		return nil
+	}
+}
+
+func expectSent(t *testing.T, tp *dummyTransport, expected base.SipMessage) {
+	select {
+	case msg := <-tp.messages:
+		if msg.msg.String() != expected.String() {
+			t.Errorf("[FAIL] unexpected message sent:\n%s", msg.msg.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] timed out waiting for %s", expected.Short())
+	}
+}
+
+// expectServerTxDeleted elapses the timer until the server transaction of the request is gone.
+func expectServerTxDeleted(t *testing.T, tm *Manager, req *base.Request, timer time.Duration) {
+	for i := 0; i < 100; i++ {
+		timing.Elapse(timer)
+		if _, err := tm.getServerTx(req); err != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("[FAIL] server transaction of %s was not deleted", req.Short())
+}
+
 func TestInviteOk(t *testing.T) {
 	branch := base.GenerateBranch()
 	logger := log.WithField("test", t.Name())
@@ -128,3 +379,1015 @@ func TestInviteNotOk(t *testing.T) {
 		}}
 	test.Execute()
 }
+
+type setServerTxLimit struct {
+	max    int
+	policy OverloadPolicy
+}
+
+func (actn *setServerTxLimit) Act(test *transactionTest) error {
+	test.tm.SetMaxServerTransactions(actn.max, actn.policy)
+	return nil
+}
+
+func TestServerTxLimit(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	invite, err := request([]string{
+		"INVITE sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"CSeq: 1 INVITE",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	trying := base.NewResponseFromRequest(invite, 100, "Trying", "")
+
+	invite2, err := request([]string{
+		"INVITE sip:alice@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"CSeq: 1 INVITE",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	unavailable := base.NewResponseFromRequest(invite2, 503, "Service Unavailable", "")
+
+	options, err := request([]string{
+		"OPTIONS sip:alice@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"CSeq: 1 OPTIONS",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&setServerTxLimit{1, OverloadDrop},
+			&transportSend{invite},
+			&userRecvSrv{invite},
+			&transportRecv{trying},
+			&transportSend{invite2},
+			&transportRecv{unavailable},
+			&transportSend{options},
+			&transportNoRecv{},
+		}}
+	test.Execute()
+}
+
+type setSourceTxLimit struct {
+	max        int
+	retryAfter time.Duration
+}
+
+func (actn *setSourceTxLimit) Act(test *transactionTest) error {
+	test.tm.SetMaxServerTransactionsPerSource(actn.max)
+	test.tm.SetOverloadRetryAfter(actn.retryAfter)
+	return nil
+}
+
+// The flood of one source is rejected with Retry-After, requests of the other sources are handled.
+func TestServerTxSourceLimit(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	invite := func(source string) *base.Request {
+		req, err := request([]string{
+			"INVITE sip:bob@example.com SIP/2.0",
+			"Via: SIP/2.0/UDP " + source + ";branch=" + base.GenerateBranch(),
+			"CSeq: 1 INVITE",
+			"",
+			"",
+		}, logger)
+		assertNoError(t, err)
+		return req
+	}
+	first, flood, other := invite(c_CLIENT), invite(c_CLIENT), invite("other.example.com:5060")
+
+	unavailable := base.NewResponseFromRequest(flood, 503, "Service Unavailable", "")
+	unavailable.AddHeader(&base.GenericHeader{HeaderName: "Retry-After", Contents: "30"})
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&setSourceTxLimit{1, 30 * time.Second},
+			&transportSend{first},
+			&userRecvSrv{first},
+			&transportRecv{base.NewResponseFromRequest(first, 100, "Trying", "")},
+			&transportSend{flood},
+			&transportRecv{unavailable},
+			&transportSend{other},
+			&userRecvSrv{other},
+			&transportRecv{base.NewResponseFromRequest(other, 100, "Trying", "")},
+		}}
+	test.Execute()
+}
+
+type setMergedDetection struct{}
+
+func (actn *setMergedDetection) Act(test *transactionTest) error {
+	test.tm.SetMergedRequestDetection(true)
+	return nil
+}
+
+// The copy of INVITE forked by a proxy and arriving by another path is rejected, other requests of the call are not.
+func TestMergedRequest(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	invite := func(proxy string, seqNo int) *base.Request {
+		req, err := request([]string{
+			"INVITE sip:bob@example.com SIP/2.0",
+			"Via: SIP/2.0/UDP " + proxy + ";branch=" + base.GenerateBranch(),
+			"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776merged",
+			"From: <sip:alice@example.com>;tag=1928301774",
+			"To: <sip:bob@example.com>",
+			"Call-Id: merged",
+			fmt.Sprintf("CSeq: %d INVITE", seqNo),
+			"",
+			"",
+		}, logger)
+		assertNoError(t, err)
+		return req
+	}
+	first, merged, next := invite("p1.example.com:5060", 1), invite("p2.example.com:5060", 1), invite("p2.example.com:5060", 2)
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&setMergedDetection{},
+			&transportSend{first},
+			&userRecvSrv{first},
+			&transportRecv{base.NewResponseFromRequest(first, 100, "Trying", "")},
+			&transportSend{merged},
+			&transportRecv{base.NewResponseFromRequest(merged, 482, "Loop Detected", "")},
+			&transportSend{next},
+			&userRecvSrv{next},
+			&transportRecv{base.NewResponseFromRequest(next, 100, "Trying", "")},
+		}}
+	test.Execute()
+}
+
+type setPriorityPolicy struct {
+	policy PriorityPolicy
+}
+
+func (actn *setPriorityPolicy) Act(test *transactionTest) error {
+	test.tm.SetPriorityPolicy(actn.policy)
+	return nil
+}
+
+func TestResourcePriorityPolicy(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	invite, err := request([]string{
+		"INVITE sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"CSeq: 1 INVITE",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	flash, err := request([]string{
+		"INVITE sip:alice@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"CSeq: 1 INVITE",
+		"Resource-Priority: dsn.flash",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	unknown, err := request([]string{
+		"OPTIONS sip:alice@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"CSeq: 1 OPTIONS",
+		"Resource-Priority: foo.bar",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	// Admit dsn requests of flash priority and above, reject unknown namespaces.
+	policy := func(req *base.Request, priorities []base.ResourcePriority) PriorityAction {
+		for _, rp := range priorities {
+			if !rp.IsKnown() {
+				return PriorityReject
+			}
+			if cmp, ok := rp.Compare(base.ResourcePriority{Namespace: "dsn", Priority: "flash"}); ok && cmp >= 0 {
+				return PriorityAdmit
+			}
+		}
+		return PriorityNormal
+	}
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&setServerTxLimit{1, OverloadReject},
+			&setPriorityPolicy{policy},
+			&transportSend{invite},
+			&userRecvSrv{invite},
+			&transportRecv{base.NewResponseFromRequest(invite, 100, "Trying", "")},
+			&transportSend{flash},
+			&userRecvSrv{flash},
+			&transportRecv{base.NewResponseFromRequest(flash, 100, "Trying", "")},
+			&transportSend{unknown},
+			&transportRecv{base.NewResponseFromRequest(unknown, 417, "Unknown Resource-Priority", "")},
+		}}
+	test.Execute()
+}
+
+type setSanitizer struct {
+	sanitizer *base.Sanitizer
+}
+
+func (actn *setSanitizer) Act(test *transactionTest) error {
+	test.tm.SetSanitizer(actn.sanitizer)
+	return nil
+}
+
+func TestSanitizerRejectsSmuggledHeaders(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	invite, err := request([]string{
+		"INVITE sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"From: <sip:alice@example.com>;tag=1",
+		"From: <sip:mallory@example.com>;tag=2",
+		"CSeq: 1 INVITE",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	options, err := request([]string{
+		"OPTIONS sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"From: <sip:alice@example.com>;tag=1",
+		"CSeq: 1 OPTIONS",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	rejected := base.NewResponseFromRequest(invite, 400, "Bad Request", "")
+	rejected.AddHeader(&base.GenericHeader{
+		HeaderName: "Warning",
+		Contents:   `399 gossip "duplicate-header: From appears 2 times"`,
+	})
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&setSanitizer{&base.Sanitizer{}},
+			&transportSend{invite},
+			&transportRecv{rejected},
+			&transportSend{options},
+			&userRecvSrv{options},
+		}}
+	test.Execute()
+}
+
+type setMaxMessageSize struct {
+	size int
+}
+
+func (actn *setMaxMessageSize) Act(test *transactionTest) error {
+	test.tm.SetMaxMessageSize(actn.size)
+	return nil
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	options, err := request([]string{
+		"OPTIONS sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"From: <sip:alice@example.com>;tag=1",
+		"CSeq: 1 OPTIONS",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	large, err := request([]string{
+		"MESSAGE sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"From: <sip:alice@example.com>;tag=1",
+		"CSeq: 1 MESSAGE",
+		"Content-Length: 400",
+		"",
+		strings.Repeat("x", 400),
+	}, logger)
+	assertNoError(t, err)
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&setMaxMessageSize{300},
+			&transportSend{large},
+			&transportRecv{base.NewResponseFromRequest(large, 413, "Request Entity Too Large", "")},
+			&transportSend{options},
+			&userRecvSrv{options},
+		}}
+	test.Execute()
+}
+
+type setRouter struct {
+	router Router
+}
+
+func (actn *setRouter) Act(test *transactionTest) error {
+	test.tm.SetRouter(actn.router)
+	return nil
+}
+
+// userRecvRouted checks the route of the next server transaction.
+type userRecvRouted struct {
+	target  string
+	forward string
+}
+
+func (actn *userRecvRouted) Act(test *transactionTest) error {
+	select {
+	case tx := <-test.tm.Requests():
+		if target := tx.Target().Recipient.String(); target != actn.target {
+			return fmt.Errorf("unexpected request target %s", target)
+		}
+		if tx.Route().Forward != actn.forward {
+			return fmt.Errorf("unexpected forward target '%s'", tx.Route().Forward)
+		}
+		return nil
+	case <-time.After(time.Second):
+		return fmt.Errorf("timed out waiting for request")
+	}
+}
+
+func TestRouterRetargetsRequest(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	invite, err := request([]string{
+		"INVITE sip:1000@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"CSeq: 1 INVITE",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	options, err := request([]string{
+		"OPTIONS sip:alice@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"CSeq: 1 OPTIONS",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	// Translate short numbers to E.164 and route them to the gateway.
+	router := func(req *base.Request) Route {
+		uri, ok := req.Recipient.(*base.SipUri)
+		if !ok || uri.User == nil || len(uri.User.String()) != 4 {
+			return Route{}
+		}
+		target := uri.Copy().(*base.SipUri)
+		target.User = base.String{S: "+1555" + uri.User.String()}
+		return Route{Recipient: target, Forward: "gw.example.com:5060"}
+	}
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&setRouter{router},
+			&transportSend{invite},
+			&userRecvRouted{"sip:+15551000@example.com", "gw.example.com:5060"},
+			&transportRecv{base.NewResponseFromRequest(invite, 100, "Trying", "")},
+			// The retransmission still matches the transaction.
+			&transportSend{invite},
+			&transportRecv{base.NewResponseFromRequest(invite, 100, "Trying", "")},
+			&transportSend{options},
+			&userRecvRouted{"sip:alice@example.com", ""},
+		}}
+	test.Execute()
+}
+
+type dialogSet map[base.DialogId]bool
+
+func (dialogs dialogSet) HasDialog(id base.DialogId) bool {
+	return dialogs[id]
+}
+
+type setDialogValidation struct {
+	dialogs  base.DialogLookup
+	override DialogOverride
+}
+
+func (actn *setDialogValidation) Act(test *transactionTest) error {
+	test.tm.SetDialogValidation(actn.dialogs, actn.override)
+	return nil
+}
+
+func TestDialogValidation(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	bye := func(callId string, toTag string) *base.Request {
+		req, err := request([]string{
+			"BYE sip:bob@example.com SIP/2.0",
+			"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+			"From: <sip:alice@example.com>;tag=remote",
+			"To: <sip:bob@example.com>;tag=" + toTag,
+			"Call-Id: " + callId,
+			"CSeq: 2 BYE",
+			"",
+			"",
+		}, logger)
+		assertNoError(t, err)
+		return req
+	}
+
+	known := bye("dialog1", "local")
+	wrongTag := bye("dialog1", "other")
+	lazy := bye("b2bua-dialog2", "local")
+
+	dialogs := dialogSet{base.DialogId{CallId: "dialog1", LocalTag: "local", RemoteTag: "remote"}: true}
+	override := func(req *base.Request, err error) bool {
+		callId, _ := req.CallId()
+		return strings.HasPrefix(string(*callId), "b2bua-")
+	}
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&setDialogValidation{dialogs, override},
+			&transportSend{known},
+			&userRecvSrv{known},
+			&transportSend{wrongTag},
+			&transportRecv{base.NewResponseFromRequest(wrongTag, 481, "Call/Transaction Does Not Exist", "")},
+			&transportSend{lazy},
+			&userRecvSrv{lazy},
+		}}
+	test.Execute()
+}
+
+type setSchemePolicy struct {
+	policy SchemePolicy
+}
+
+func (actn *setSchemePolicy) Act(test *transactionTest) error {
+	test.tm.SetSchemePolicy(actn.policy)
+	return nil
+}
+
+func TestUnsupportedScheme(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	newOptions := func() *base.Request {
+		req, err := request([]string{
+			"OPTIONS http://www.example.com/ SIP/2.0",
+			"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+			"CSeq: 1 OPTIONS",
+			"",
+			"",
+		}, logger)
+		assertNoError(t, err)
+		return req
+	}
+	rejected, passed := newOptions(), newOptions()
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&transportSend{rejected},
+			&transportRecv{base.NewResponseFromRequest(rejected, 416, "Unsupported URI Scheme", "")},
+			&setSchemePolicy{SchemePass},
+			&transportSend{passed},
+			&userRecvSrv{passed},
+		}}
+	test.Execute()
+}
+
+type drain struct {
+	retryAfter time.Duration
+}
+
+func (actn *drain) Act(test *transactionTest) error {
+	test.tm.Drain(actn.retryAfter)
+	if test.tm.Ready() == nil {
+		return fmt.Errorf("draining manager reported ready")
+	}
+	return nil
+}
+
+func TestDrainRejectsNewDialogs(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	newRequest := func(method string, toTag string) *base.Request {
+		req, err := request([]string{
+			method + " sip:bob@example.com SIP/2.0",
+			"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+			"From: <sip:alice@example.com>;tag=1928301774",
+			"To: <sip:bob@example.com>" + toTag,
+			"Call-Id: " + base.GenerateTag(),
+			"CSeq: 1 " + method,
+			"",
+			"",
+		}, logger)
+		assertNoError(t, err)
+		return req
+	}
+	invite, register := newRequest("INVITE", ""), newRequest("REGISTER", "")
+	options, reinvite := newRequest("OPTIONS", ""), newRequest("INVITE", ";tag=a6c85cf")
+
+	rejected := func(req *base.Request) *base.Response {
+		res := base.NewResponseFromRequest(req, 503, "Service Unavailable", "")
+		res.AddHeader(&base.GenericHeader{HeaderName: "Retry-After", Contents: "30"})
+		return res
+	}
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&drain{30 * time.Second},
+			&transportSend{invite},
+			&transportRecv{rejected(invite)},
+			&transportSend{register},
+			&transportRecv{rejected(register)},
+			&transportSend{options},
+			&userRecvSrv{options},
+			&transportSend{reinvite},
+			&transportRecv{base.NewResponseFromRequest(reinvite, 100, "Trying", "")},
+			&userRecvSrv{reinvite},
+		}}
+	test.Execute()
+}
+
+func TestRespondHelpers(t *testing.T) {
+	timing.MockMode = true
+	logger := log.WithField("test", t.Name())
+	tp := newDummyTransport()
+	tm, err := NewManager(tp, c_CLIENT)
+	assertNoError(t, err)
+	defer tm.Stop()
+
+	invite, err := request([]string{
+		"INVITE sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"From: <sip:alice@example.com>;tag=1",
+		"To: <sip:bob@example.com>",
+		"Call-Id: respond1",
+		"CSeq: 1 INVITE",
+		"Timestamp: 54",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	sent := func() *base.Response {
+		select {
+		case msg := <-tp.messages:
+			return msg.msg.(*base.Response)
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] timed out waiting for response")
+		}
+		return nil
+	}
+	toTag := func(res *base.Response) string {
+		tag, err := res.ToTag()
+		if err != nil {
+			return ""
+		}
+		return tag.String()
+	}
+
+	tp.toTM <- invite
+	var tx *ServerTransaction
+	select {
+	case tx = <-tm.Requests():
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] timed out waiting for request")
+	}
+	if trying := sent(); trying.StatusCode != 100 || toTag(trying) != "" {
+		t.Errorf("[FAIL] expected 100 Trying without To tag, got %s", trying.String())
+	}
+
+	ringing := tx.Ringing()
+	if res := sent(); res != ringing || res.StatusCode != 180 {
+		t.Errorf("[FAIL] expected 180 Ringing sent, got %s", res.Short())
+	}
+	if toTag(ringing) == "" {
+		t.Fatalf("[FAIL] expected To tag in %s", ringing.String())
+	}
+	if len(ringing.Headers("Timestamp")) != 0 {
+		t.Errorf("[FAIL] unexpected Timestamp in %s", ringing.String())
+	}
+
+	contact := &base.GenericHeader{HeaderName: "Contact", Contents: "<sip:bob@" + c_CLIENT + ">"}
+	ok := tx.Ok(contact)
+	if res := sent(); res != ok || res.StatusCode != 200 {
+		t.Errorf("[FAIL] expected 200 OK sent, got %s", res.Short())
+	}
+	if toTag(ok) != toTag(ringing) {
+		t.Errorf("[FAIL] To tag changed between responses: %s, %s", toTag(ringing), toTag(ok))
+	}
+	if len(ok.Headers("Contact")) != 1 {
+		t.Errorf("[FAIL] expected Contact in %s", ok.String())
+	}
+	for _, name := range []string{"Via", "From", "Call-Id", "CSeq"} {
+		if got, expected := ok.Headers(name), invite.Headers(name); len(got) != 1 || got[0].String() != expected[0].String() {
+			t.Errorf("[FAIL] expected %s copied from the request, got %v", name, got)
+		}
+	}
+}
+
+func TestRespondWith(t *testing.T) {
+	timing.MockMode = true
+	logger := log.WithField("test", t.Name())
+	tp := newDummyTransport()
+	tm, err := NewManager(tp, c_CLIENT)
+	assertNoError(t, err)
+	defer tm.Stop()
+
+	invite, err := request([]string{
+		"INVITE sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"Record-Route: <sip:p2.example.com;lr>",
+		"Record-Route: <sip:p1.example.com;lr>",
+		"From: <sip:alice@example.com>;tag=1",
+		"To: <sip:bob@example.com>",
+		"Call-Id: respondwith1",
+		"CSeq: 1 INVITE",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	sent := func() *base.Response {
+		select {
+		case msg := <-tp.messages:
+			return msg.msg.(*base.Response)
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] timed out waiting for response")
+		}
+		return nil
+	}
+	toTag := func(res *base.Response) string {
+		tag, err := res.ToTag()
+		if err != nil {
+			return ""
+		}
+		return tag.String()
+	}
+
+	tp.toTM <- invite
+	var tx *ServerTransaction
+	select {
+	case tx = <-tm.Requests():
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] timed out waiting for request")
+	}
+	sent() // 100 Trying
+
+	progress := tx.RespondWith(183, "Session Progress", WithToTag("fork1"), WithBody("application/sdp", "v=0\r\n"))
+	if res := sent(); res != progress || res.StatusCode != 183 {
+		t.Errorf("[FAIL] expected 183 Session Progress sent, got %s", res.Short())
+	}
+	if toTag(progress) != "fork1" {
+		t.Errorf("[FAIL] expected To tag fork1, got %s", progress.String())
+	}
+	if len(progress.Headers("Record-Route")) != 0 {
+		t.Errorf("[FAIL] unexpected Record-Route in %s", progress.String())
+	}
+
+	supported := &base.GenericHeader{HeaderName: "Supported", Contents: "timer"}
+	ok := tx.RespondWith(200, "OK", WithBody("application/sdp", "v=0\r\no=bob\r\n"), WithHeaders(supported), WithRecordRoute())
+	if res := sent(); res != ok || res.StatusCode != 200 {
+		t.Errorf("[FAIL] expected 200 OK sent, got %s", res.Short())
+	}
+	if toTag(ok) == "" || toTag(ok) == "fork1" {
+		t.Errorf("[FAIL] expected To tag of the transaction in %s", ok.String())
+	}
+	if ok.Body() != "v=0\r\no=bob\r\n" {
+		t.Errorf("[FAIL] unexpected body %q", ok.Body())
+	}
+	if hdrs := ok.Headers("Content-Type"); len(hdrs) != 1 || hdrs[0].(*base.GenericHeader).Contents != "application/sdp" {
+		t.Errorf("[FAIL] expected Content-Type application/sdp, got %v", hdrs)
+	}
+	if hdrs := ok.Headers("Content-Length"); len(hdrs) != 1 || hdrs[0].String() != "Content-Length: 12" {
+		t.Errorf("[FAIL] expected Content-Length 12, got %v", hdrs)
+	}
+	if len(ok.Headers("Supported")) != 1 {
+		t.Errorf("[FAIL] expected Supported in %s", ok.String())
+	}
+	got, expected := ok.Headers("Record-Route"), invite.Headers("Record-Route")
+	if len(got) != len(expected) {
+		t.Fatalf("[FAIL] expected Record-Route copied from the request, got %v", got)
+	}
+	for i := range got {
+		if got[i].String() != expected[i].String() {
+			t.Errorf("[FAIL] expected %s at %d, got %s", expected[i], i, got[i])
+		}
+	}
+
+	stateless := BuildResponse(invite, "", 500, "Server Internal Error", WithoutToTag())
+	if toTag(stateless) != "" {
+		t.Errorf("[FAIL] unexpected To tag in %s", stateless.String())
+	}
+}
+
+type setAdmissionPolicy struct {
+	policy AdmissionPolicy
+}
+
+func (actn *setAdmissionPolicy) Act(test *transactionTest) error {
+	test.tm.SetAdmissionPolicy(actn.policy)
+	return nil
+}
+
+type setThrottlePolicy struct {
+	policy ThrottlePolicy
+}
+
+func (actn *setThrottlePolicy) Act(test *transactionTest) error {
+	test.tm.SetThrottlePolicy(actn.policy)
+	return nil
+}
+
+func TestThrottlePolicy(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	register, err := request([]string{
+		"REGISTER sip:example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"From: <sip:alice@example.com>;tag=1",
+		"To: <sip:alice@example.com>",
+		"CSeq: 1 REGISTER",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	unavailable := base.NewResponseFromRequest(register, 503, "Service Unavailable", "")
+	unavailable.AddHeader(&base.GenericHeader{HeaderName: "Retry-After", Contents: "2"})
+
+	bye, err := request([]string{
+		"BYE sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"From: <sip:alice@example.com>;tag=1",
+		"To: <sip:bob@example.com>;tag=2",
+		"CSeq: 2 BYE",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&setThrottlePolicy{func(req *base.Request) (uint16, string, time.Duration) {
+				return 503, "Service Unavailable", 1500 * time.Millisecond
+			}},
+			&transportSend{register},
+			&transportRecv{unavailable},
+			// In-dialog requests are not throttled.
+			&transportSend{bye},
+			&userRecvSrv{bye},
+		}}
+	test.Execute()
+}
+
+func TestAdmissionPolicy(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	invite, err := request([]string{
+		"INVITE sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"CSeq: 1 INVITE",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	busy := base.NewResponseFromRequest(invite, 486, "Busy Here", "")
+
+	reinvite, err := request([]string{
+		"INVITE sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"From: <sip:alice@example.com>;tag=1",
+		"To: <sip:bob@example.com>;tag=2",
+		"CSeq: 2 INVITE",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	trying := base.NewResponseFromRequest(reinvite, 100, "Trying", "")
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&setAdmissionPolicy{func(req *base.Request) (uint16, string) {
+				return 486, "Busy Here"
+			}},
+			&transportSend{invite},
+			&transportRecv{busy},
+			&transportSend{reinvite},
+			&userRecvSrv{reinvite},
+			&transportRecv{trying},
+		}}
+	test.Execute()
+}
+
+type setLoopDetection struct {
+	own base.HopMatcher
+}
+
+func (actn *setLoopDetection) Act(test *transactionTest) error {
+	test.tm.SetLoopDetection(actn.own)
+	return nil
+}
+
+func TestLoopDetection(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	received, err := request([]string{
+		"OPTIONS sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+		"From: <sip:alice@example.com>;tag=1",
+		"To: <sip:bob@example.com>",
+		"Call-Id: loop1",
+		"CSeq: 1 OPTIONS",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+	ownBranch := base.GenerateLoopBranch(received)
+
+	proxied := func(recipient string) *base.Request {
+		req, err := request([]string{
+			"OPTIONS " + recipient + " SIP/2.0",
+			"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch(),
+			"Via: SIP/2.0/UDP proxy.example.com;branch=" + ownBranch,
+			"From: <sip:alice@example.com>;tag=1",
+			"To: <sip:bob@example.com>",
+			"Call-Id: loop1",
+			"CSeq: 1 OPTIONS",
+			"",
+			"",
+		}, logger)
+		assertNoError(t, err)
+		return req
+	}
+	looped := proxied("sip:bob@example.com")
+	spiraled := proxied("sip:bob@10.0.0.5")
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&setLoopDetection{func(hop *base.ViaHop) bool { return hop.Host == "proxy.example.com" }},
+			&transportSend{looped},
+			&transportRecv{base.NewResponseFromRequest(looped, 482, "Loop Detected", "")},
+			&transportSend{spiraled},
+			&userRecvSrv{spiraled},
+		}}
+	test.Execute()
+}
+
+func TestRetransmit2xx(t *testing.T) {
+	timing.MockMode = true
+	logger := log.WithField("test", t.Name())
+	tp := newDummyTransport()
+	tm, err := NewManager(tp, c_CLIENT)
+	assertNoError(t, err)
+	defer tm.Stop()
+	tm.SetRetransmit2xx(true)
+
+	newRequest := func(method base.Method, callId string) *base.Request {
+		req, err := request([]string{
+			string(method) + " sip:bob@example.com SIP/2.0",
+			"Via: SIP/2.0/UDP " + c_SERVER + ";branch=" + base.GenerateBranch(),
+			"From: <sip:alice@example.com>;tag=1",
+			"To: <sip:bob@example.com>",
+			"Call-Id: " + callId,
+			"CSeq: 1 " + string(method),
+			"",
+			"",
+		}, logger)
+		assertNoError(t, err)
+		return req
+	}
+	received := func() *ServerTransaction {
+		t.Helper()
+		select {
+		case tx := <-tm.Requests():
+			return tx
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] timed out waiting for request")
+		}
+		return nil
+	}
+	sent := func(expected *base.Response) {
+		t.Helper()
+		select {
+		case msg := <-tp.messages:
+			if msg.msg != expected {
+				t.Errorf("[FAIL] expected %s sent, got %s", expected.Short(), msg.msg.Short())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] timed out waiting for %s", expected.Short())
+		}
+	}
+	notSent := func() {
+		t.Helper()
+		select {
+		case msg := <-tp.messages:
+			t.Errorf("[FAIL] unexpected message sent %s", msg.msg.Short())
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	accept := func(callId string) (*ServerTransaction, *base.Response) {
+		t.Helper()
+		tp.toTM <- newRequest(base.INVITE, callId)
+		tx := received()
+		<-tp.messages // 100 Trying
+		ok := tx.Ok()
+		sent(ok)
+		return tx, ok
+	}
+
+	// 2xx is retransmitted with doubling interval until ACK.
+	tx, ok := accept("retransmit1")
+	for _, interval := range []time.Duration{T1, 2 * T1, 4 * T1} {
+		timing.Elapse(interval - time.Millisecond)
+		notSent()
+		timing.Elapse(time.Millisecond)
+		sent(ok)
+	}
+	ack := newRequest(base.ACK, "retransmit1")
+	tp.toTM <- ack
+	if acked := received(); acked.Origin() != ack {
+		t.Errorf("[FAIL] expected ACK passed up, got %s", acked.Origin().Short())
+	}
+	// The acknowledged transaction absorbs retransmissions until timer L - RFC 6026 8.7.
+	timing.Elapse(Timer_L - 7*T1 - time.Millisecond)
+	notSent()
+	if _, err := tm.getServerTx(tx.Origin()); err != nil {
+		t.Errorf("[FAIL] expected acknowledged transaction kept until timer L: %s", err)
+	}
+	expectServerTxDeleted(t, tm, tx.Origin(), time.Millisecond)
+	select {
+	case err := <-tx.Errors():
+		t.Errorf("[FAIL] unexpected error of acknowledged transaction: %s", err)
+	default:
+	}
+
+	// 2xx not acknowledged in Timer L is reported.
+	tx, ok = accept("retransmit2")
+	timing.Elapse(Timer_L - time.Millisecond)
+	sent(ok)
+	timing.Elapse(time.Millisecond)
+	select {
+	case err := <-tx.Errors():
+		if !errors.Is(err, base.ErrTimeout) {
+			t.Errorf("[FAIL] expected error of kind '%s', got %s", base.ErrTimeout, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] timed out waiting for ACK timeout error")
+	}
+}
+
+// Responses are sent to the source of the request stamped in the top Via hop - RFC 3261 18.2.2, RFC 3581 4.
+func TestResponseToReceivedAddr(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	invite, err := request([]string{
+		"INVITE sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + base.GenerateBranch() + ";rport=40000;received=10.0.0.1",
+		"CSeq: 1 INVITE",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	test := transactionTest{
+		t:   t,
+		log: logger,
+		actions: []action{
+			&transportSend{invite},
+			&userRecvSrv{invite},
+			step(func(test *transactionTest) error {
+				select {
+				case sent := <-test.transport.messages:
+					if sent.addr != "10.0.0.1:40000" {
+						return fmt.Errorf("expected %s sent to 10.0.0.1:40000, got %s", sent.msg.Short(), sent.addr)
+					}
+					return nil
+				case <-time.After(time.Second):
+					return fmt.Errorf("timed out waiting for 100 Trying")
+				}
+			}),
+		}}
+	test.Execute()
+}
@@ -0,0 +1,57 @@
+package transaction
+
+import (
+	"fmt"
+
+	"github.com/ghettovoice/gossip/base"
+)
+
+// StatelessHandler receives new requests before a server transaction is created for them,
+// e.g. by a load balancer or an edge proxy relaying them by ForwardStateless.
+// It reports whether the request was handled statelessly, otherwise the request is processed as usual.
+// Retransmissions are not absorbed without a server transaction, the handler receives each of them
+// and must handle them the same way - RFC 3261 16.11.
+type StatelessHandler func(req *base.Request) bool
+
+// SetStatelessHandler sets the handler of requests relayed without a server transaction, nil disables it.
+// Requests passed to the handler get no 100 Trying and are not counted by the transaction limits,
+// only the scheme, dialog and loop checks apply to them. Responses to the relayed requests match no client
+// transaction and arrive to the Responses channel, see ForwardResponseStateless.
+// Can be changed at runtime, see Reload.
+func (mng *Manager) SetStatelessHandler(handler StatelessHandler) {
+	mng.lockConfig()
+	defer mng.unlockConfig()
+	mng.cfg.Stateless = handler
+}
+
+// ForwardStateless sends the request to dest without a client transaction, nothing is retransmitted
+// and no response is awaited. The request must be prepared for forwarding by the caller: the Via hop
+// with a branch of base.GenerateStatelessBranch pushed and Max-Forwards decremented - RFC 3261 16.11.
+func (mng *Manager) ForwardStateless(req *base.Request, dest string) error {
+	req.Log().Infof("forwarding request statelessly to %v: %v", dest, req.Short())
+	req.Log().Debugf("forwarding request:\r\n%s", req.String())
+	return mng.transport.Send(dest, req)
+}
+
+// ForwardResponseStateless removes the top Via hop of the response, the one of the stateless proxy,
+// and sends the response to the address of the next Via hop - RFC 3261 16.11.
+func (mng *Manager) ForwardResponseStateless(res *base.Response) error {
+	via, err := res.Via()
+	if err != nil {
+		return err
+	}
+	if _, err := via.PopHop(); err != nil {
+		return err
+	}
+	if len(*via) == 0 {
+		res.RemoveHeader(via)
+	}
+
+	dest, err := viaAddr(res)
+	if err != nil {
+		return fmt.Errorf("no Via hop to forward response %s to: %s", res.Short(), err)
+	}
+
+	res.Log().Infof("forwarding response statelessly to %v: %v", dest, res.Short())
+	return mng.transport.Send(dest, res)
+}
@@ -0,0 +1,116 @@
+package transaction
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+func TestForwardStateless(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	tp := newDummyTransport()
+	tm, err := NewManager(tp, c_SERVER)
+	assertNoError(t, err)
+	defer tm.Stop()
+
+	const next = "uas.example.com:5060"
+	tm.SetStatelessHandler(func(req *base.Request) bool {
+		if req.Method != base.INVITE {
+			return false
+		}
+		fwd := req.Copy()
+		via, _ := fwd.Via()
+		via.PushHop(base.NewViaHop("UDP", "proxy.example.com", 5060, base.GenerateStatelessBranch(req)))
+		assertNoError(t, tm.ForwardStateless(fwd, next))
+		return true
+	})
+
+	invite, err := request([]string{
+		"INVITE sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776stateless",
+		"From: <sip:alice@example.com>;tag=1",
+		"To: <sip:bob@example.com>",
+		"Call-Id: stateless1",
+		"CSeq: 1 INVITE",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+
+	recv := func() sentMessage {
+		select {
+		case sent := <-tp.messages:
+			return sent
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] timed out waiting for a message")
+		}
+		return sentMessage{}
+	}
+
+	var branches []string
+	// The retransmission is relayed again, with the same branch.
+	for i := 0; i < 2; i++ {
+		tp.toTM <- invite
+		sent := recv()
+		fwd, ok := sent.msg.(*base.Request)
+		if !ok || sent.addr != next {
+			t.Fatalf("[FAIL] expected INVITE forwarded to %s, got %s to %s", next, sent.msg.Short(), sent.addr)
+		}
+		branch, _ := fwd.Branch()
+		branches = append(branches, branch.String())
+	}
+	if branches[0] != branches[1] {
+		t.Errorf("[FAIL] expected retransmission forwarded with the same branch, got %v", branches)
+	}
+	if count := tm.countServerTx(); count != 0 {
+		t.Errorf("[FAIL] expected no server transactions, got %d", count)
+	}
+
+	fwd, _ := request([]string{
+		"INVITE sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP proxy.example.com:5060;branch=" + branches[0],
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776stateless",
+		"From: <sip:alice@example.com>;tag=1",
+		"To: <sip:bob@example.com>",
+		"Call-Id: stateless1",
+		"CSeq: 1 INVITE",
+		"",
+		"",
+	}, logger)
+	tp.toTM <- base.NewResponseFromRequest(fwd, 180, "Ringing", "")
+	var res *base.Response
+	select {
+	case res = <-tm.Responses():
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] response was not passed up")
+	}
+	assertNoError(t, tm.ForwardResponseStateless(res))
+	sent := recv()
+	if sent.addr != c_CLIENT {
+		t.Errorf("[FAIL] expected response forwarded to %s, got %s", c_CLIENT, sent.addr)
+	}
+	if via, err := sent.msg.Via(); err != nil || len(*via) != 1 || (*via)[0].Host != "localhost" {
+		t.Errorf("[FAIL] expected the Via hop of the proxy removed, got %v", sent.msg.Headers("Via"))
+	}
+
+	// Requests the handler declines get a server transaction as usual.
+	options, err := request([]string{
+		"OPTIONS sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=z9hG4bK776options",
+		"From: <sip:alice@example.com>;tag=1",
+		"To: <sip:bob@example.com>",
+		"Call-Id: stateless2",
+		"CSeq: 1 OPTIONS",
+		"",
+		"",
+	}, logger)
+	assertNoError(t, err)
+	tp.toTM <- options
+	select {
+	case <-tm.Requests():
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] expected server transaction of the declined request")
+	}
+}
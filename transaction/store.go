@@ -2,16 +2,33 @@ package transaction
 
 import (
 	"fmt"
+	"hash/maphash"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/transport"
 )
 
 type txKey string
 
 // makeServerTxKey creates server transaction key for matching retransmitting requests - RFC 3261 17.2.3.
 func makeServerTxKey(req *base.Request) (txKey, error) {
+	cseq, err := req.CSeq()
+	if err != nil {
+		return "", fmt.Errorf("couldn't create transaction key from request %s: %s", req.Short(), err)
+	}
+	method := cseq.MethodName
+	if method == base.ACK {
+		method = base.INVITE
+	}
+	return makeServerTxKeyOf(req, method)
+}
+
+// makeServerTxKeyOf creates key of the server transaction of the method the request matches, all but the method
+// of the request is matched, e.g. CANCEL matches INVITE it cancels - RFC 3261 9.2.
+func makeServerTxKeyOf(req *base.Request, method base.Method) (txKey, error) {
 	var sep = "$"
 
 	firstViaHop, err := req.ViaHop()
@@ -23,10 +40,6 @@ func makeServerTxKey(req *base.Request) (txKey, error) {
 	if err != nil {
 		return "", fmt.Errorf("couldn't create transaction key from request %s: %s", req.Short(), err)
 	}
-	method := cseq.MethodName
-	if method == base.ACK {
-		method = base.INVITE
-	}
 
 	var isRFC3261 bool
 	branch, err := req.Branch()
@@ -44,9 +57,9 @@ func makeServerTxKey(req *base.Request) (txKey, error) {
 	if isRFC3261 {
 		return txKey(strings.Join([]string{
 			branch.String(),
-			firstViaHop.Host,              // branch
-			fmt.Sprint(*firstViaHop.Port), // sent-by
-			string(method),                // origin method
+			firstViaHop.Host,                      // sent-by
+			fmt.Sprint(base.HopPort(firstViaHop)), // sent-by port, the default one if omitted
+			string(method),                        // origin method
 		}, sep)), nil
 	}
 	// RFC 2543 compliant
@@ -98,41 +111,286 @@ func makeClientTxKey(msg base.SipMessage) (txKey, error) {
 	}, sep)), nil
 }
 
-// store is a mutual exclusive storage for active transactions.
+// Store keeps the active transactions of the manager by their keys, see NewManagerWithStore.
+// It is used concurrently by the transactions, so it must be safe for concurrent use,
+// e.g. a sharded map to reduce the lock contention, or a store limiting its size.
+// The store must not drop the transactions on its own: the transactions delete themselves once terminated,
+// a store limiting its size should fail Put instead.
+type Store interface {
+	// Get returns the transaction stored by the key.
+	Get(key string) (Transaction, bool)
+	// Put stores the transaction by the key.
+	// It fails with base.ErrTransactionExists if another transaction is stored by the key.
+	Put(key string, tx Transaction) error
+	// Delete removes the transaction stored by the key, if any.
+	Delete(key string)
+	// Len returns the number of stored transactions.
+	Len() int
+	// Range calls f for the stored transactions until f returns false.
+	Range(f func(key string, tx Transaction) bool)
+}
+
+// mapStore is the default Store, a map guarded by a mutex.
+type mapStore struct {
+	txs  map[string]Transaction
+	lock sync.RWMutex
+}
+
+// NewMapStore creates the default Store, the one of the managers created by NewManager.
+func NewMapStore() Store {
+	return &mapStore{txs: make(map[string]Transaction)}
+}
+
+func (store *mapStore) Get(key string) (Transaction, bool) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	tx, ok := store.txs[key]
+	return tx, ok
+}
+
+func (store *mapStore) Put(key string, tx Transaction) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if existing, ok := store.txs[key]; ok && existing != tx {
+		return base.NewError(base.ErrTransactionExists, nil, "transaction %p with key %s already exists", existing, key)
+	}
+	store.txs[key] = tx
+	return nil
+}
+
+func (store *mapStore) Delete(key string) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	delete(store.txs, key)
+}
+
+func (store *mapStore) Len() int {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return len(store.txs)
+}
+
+func (store *mapStore) Range(f func(key string, tx Transaction) bool) {
+	store.lock.RLock()
+	txs := make(map[string]Transaction, len(store.txs))
+	for key, tx := range store.txs {
+		txs[key] = tx
+	}
+	store.lock.RUnlock()
+	for key, tx := range txs {
+		if !f(key, tx) {
+			return
+		}
+	}
+}
+
+// shardedStore is the Store split into shards by the hash of the key, each one guarded by a mutex of its own,
+// so the transactions of different keys rarely contend for the same lock.
+type shardedStore struct {
+	shards []*mapStore
+	seed   maphash.Seed
+}
+
+// NewShardedStore creates the Store of the shards, e.g. 64, for managers handling many transactions concurrently,
+// see NewManagerWithStore. Non-positive number means a single shard.
+func NewShardedStore(shards int) Store {
+	if shards < 1 {
+		shards = 1
+	}
+	store := &shardedStore{shards: make([]*mapStore, shards), seed: maphash.MakeSeed()}
+	for i := range store.shards {
+		store.shards[i] = &mapStore{txs: make(map[string]Transaction)}
+	}
+	return store
+}
+
+func (store *shardedStore) shard(key string) *mapStore {
+	return store.shards[maphash.String(store.seed, key)%uint64(len(store.shards))]
+}
+
+func (store *shardedStore) Get(key string) (Transaction, bool) {
+	return store.shard(key).Get(key)
+}
+
+func (store *shardedStore) Put(key string, tx Transaction) error {
+	return store.shard(key).Put(key, tx)
+}
+
+func (store *shardedStore) Delete(key string) {
+	store.shard(key).Delete(key)
+}
+
+func (store *shardedStore) Len() int {
+	count := 0
+	for _, shard := range store.shards {
+		count += shard.Len()
+	}
+	return count
+}
+
+func (store *shardedStore) Range(f func(key string, tx Transaction) bool) {
+	stopped := false
+	for _, shard := range store.shards {
+		shard.Range(func(key string, tx Transaction) bool {
+			stopped = !f(key, tx)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+// store wraps Store of the manager, counting the stored server transactions, in total and by source host,
+// and indexing the ones of requests outside of dialogs by mergeKey.
 type store struct {
-	txs    map[txKey]Transaction
-	txLock *sync.RWMutex
+	txs         Store
+	serverCount int64 // Number of stored server transactions, accessed atomically.
+	sources     map[string]int
+	sourcesLock sync.Mutex
+	merged      map[mergeKey]*ServerTransaction
+	mergedLock  sync.Mutex
+}
+
+func newStore(txs Store) *store {
+	return &store{txs: txs, sources: make(map[string]int), merged: make(map[mergeKey]*ServerTransaction)}
+}
+
+// mergeKey identifies the request outside of a dialog regardless of the path it took:
+// the copies of the request forked by a proxy and merged back share it - RFC 3261 8.2.2.2.
+type mergeKey struct {
+	fromTag string
+	callId  string
+	seqNo   uint32
+	method  base.Method
 }
 
-func newStore() *store {
-	return &store{
-		txs:    make(map[txKey]Transaction),
-		txLock: &sync.RWMutex{},
+// makeMergeKey returns mergeKey of the request, false if it has To tag or lacks the fields.
+func makeMergeKey(req *base.Request) (mergeKey, bool) {
+	if tag, err := req.ToTag(); err == nil && tag != nil {
+		return mergeKey{}, false
+	}
+	fromTag, err := req.FromTag()
+	if err != nil || fromTag == nil {
+		return mergeKey{}, false
+	}
+	callId, err := req.CallId()
+	if err != nil {
+		return mergeKey{}, false
+	}
+	cseq, err := req.CSeq()
+	if err != nil {
+		return mergeKey{}, false
 	}
+	return mergeKey{fromTag.String(), string(*callId), cseq.SeqNo, cseq.MethodName}, true
 }
 
-func (store *store) putTx(key txKey, tx Transaction) {
-	store.txLock.Lock()
-	store.txs[key] = tx
-	store.txLock.Unlock()
+func (store *store) putTx(key txKey, tx Transaction) error {
+	if existing, ok := store.txs.Get(string(key)); ok && existing == tx {
+		return nil
+	}
+	if err := store.txs.Put(string(key), tx); err != nil {
+		return err
+	}
+	if serverTx, isServer := tx.(*ServerTransaction); isServer {
+		atomic.AddInt64(&store.serverCount, 1)
+		store.countSource(tx, 1)
+		store.indexMerged(serverTx)
+	}
+
+	return nil
 }
 
 // Gets a transaction from the transaction store.
-// Should only be called inside the storage handling goroutine to ensure concurrency safety.
 func (store *store) getTx(key txKey) (Transaction, bool) {
-	store.txLock.RLock()
-	tx, ok := store.txs[key]
-	store.txLock.RUnlock()
+	return store.txs.Get(string(key))
+}
 
+// Deletes the transaction from the transaction store, unless another one is stored by its key.
+func (store *store) delTx(key txKey, tx Transaction) {
+	if existing, ok := store.txs.Get(string(key)); !ok || existing != tx {
+		return
+	}
+	store.txs.Delete(string(key))
+	if serverTx, isServer := tx.(*ServerTransaction); isServer {
+		atomic.AddInt64(&store.serverCount, -1)
+		store.countSource(tx, -1)
+		store.unindexMerged(serverTx)
+	}
+}
+
+// countServerTx returns the number of stored server transactions.
+func (store *store) countServerTx() int {
+	return int(atomic.LoadInt64(&store.serverCount))
+}
+
+// countSource adds delta to the number of server transactions of the source host of the transaction.
+func (store *store) countSource(tx Transaction, delta int) {
+	source := transport.SourceHost(tx.Origin())
+	store.sourcesLock.Lock()
+	defer store.sourcesLock.Unlock()
+	if count := store.sources[source] + delta; count > 0 {
+		store.sources[source] = count
+	} else {
+		delete(store.sources, source)
+	}
+}
+
+// countSourceTx returns the number of stored server transactions of the source host.
+func (store *store) countSourceTx(source string) int {
+	store.sourcesLock.Lock()
+	defer store.sourcesLock.Unlock()
+	return store.sources[source]
+}
+
+func (store *store) indexMerged(tx *ServerTransaction) {
+	key, ok := makeMergeKey(tx.Origin())
+	if !ok {
+		return
+	}
+	store.mergedLock.Lock()
+	defer store.mergedLock.Unlock()
+	if _, exists := store.merged[key]; !exists {
+		store.merged[key] = tx
+	}
+}
+
+func (store *store) unindexMerged(tx *ServerTransaction) {
+	key, ok := makeMergeKey(tx.Origin())
+	if !ok {
+		return
+	}
+	store.mergedLock.Lock()
+	defer store.mergedLock.Unlock()
+	if store.merged[key] == tx {
+		delete(store.merged, key)
+	}
+}
+
+// getMergedTx returns the server transaction of another copy of the request outside of a dialog, see mergeKey.
+func (store *store) getMergedTx(req *base.Request) (*ServerTransaction, bool) {
+	key, ok := makeMergeKey(req)
+	if !ok {
+		return nil, false
+	}
+	store.mergedLock.Lock()
+	defer store.mergedLock.Unlock()
+	tx, ok := store.merged[key]
 	return tx, ok
 }
 
-// Deletes a transaction from the transaction store.
-// Should only be called inside the storage handling goroutine to ensure concurrency safety.
-func (store *store) delTx(key txKey) {
-	store.txLock.Lock()
-	delete(store.txs, key)
-	store.txLock.Unlock()
+// getCancelledTx returns INVITE server transaction the CANCEL request cancels - RFC 3261 9.2.
+func (store *store) getCancelledTx(cancel *base.Request) (*ServerTransaction, bool) {
+	key, err := makeServerTxKeyOf(cancel, base.INVITE)
+	if err != nil {
+		return nil, false
+	}
+	tx, ok := store.getTx(key)
+	if !ok {
+		return nil, false
+	}
+	invite, ok := tx.(*ServerTransaction)
+	return invite, ok
 }
 
 /* strong typed helpers */
@@ -175,7 +433,9 @@ func (store *store) putClientTx(tx *ClientTransaction) error {
 	}
 
 	tx.Log().Debugf("trying to store client transaction %p with key %s", tx, key)
-	store.putTx(key, tx)
+	if err := store.putTx(key, tx); err != nil {
+		return fmt.Errorf("failed to put client transaction %p: %w", tx, err)
+	}
 
 	return nil
 }
@@ -188,7 +448,7 @@ func (store *store) delClientTx(tx *ClientTransaction) error {
 	}
 
 	tx.Log().Debugf("trying to delete client transaction %p by key %v", tx, key)
-	store.delTx(key)
+	store.delTx(key, tx)
 
 	return nil
 }
@@ -231,7 +491,9 @@ func (store *store) putServerTx(tx *ServerTransaction) error {
 	}
 
 	tx.Log().Debugf("trying to store server transaction %p with key %s", tx, key)
-	store.putTx(key, tx)
+	if err := store.putTx(key, tx); err != nil {
+		return fmt.Errorf("failed to put server transaction %p: %w", tx, err)
+	}
 
 	return nil
 }
@@ -244,7 +506,7 @@ func (store *store) delServerTx(tx *ServerTransaction) error {
 	}
 
 	tx.Log().Debugf("trying to delete server transaction %p by key %v", tx, key)
-	store.delTx(key)
+	store.delTx(key, tx)
 
 	return nil
 }
@@ -0,0 +1,149 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+// limitedStore refuses the transactions beyond its size.
+type limitedStore struct {
+	Store
+	max int
+}
+
+func (store *limitedStore) Put(key string, tx Transaction) error {
+	if _, ok := store.Get(key); !ok && store.Len() >= store.max {
+		return fmt.Errorf("store is full")
+	}
+	return store.Store.Put(key, tx)
+}
+
+func TestMapStore(t *testing.T) {
+	testStore(t, NewMapStore())
+}
+
+func TestShardedStore(t *testing.T) {
+	testStore(t, NewShardedStore(4))
+}
+
+func testStore(t *testing.T, store Store) {
+	tx, other := &ServerTransaction{}, &ServerTransaction{}
+	assertNoError(t, store.Put("a", tx))
+	assertNoError(t, store.Put("a", tx))
+	if err := store.Put("a", other); !errors.Is(err, base.ErrTransactionExists) {
+		t.Errorf("[FAIL] expected another transaction by the key refused, got %v", err)
+	}
+	assertNoError(t, store.Put("b", other))
+	if got, ok := store.Get("a"); !ok || got != tx {
+		t.Errorf("[FAIL] expected transaction %p by key a, got %v", tx, got)
+	}
+	if store.Len() != 2 {
+		t.Errorf("[FAIL] expected 2 transactions, got %d", store.Len())
+	}
+
+	visited := 0
+	store.Range(func(key string, tx Transaction) bool {
+		visited++
+		return false
+	})
+	if visited != 1 {
+		t.Errorf("[FAIL] expected Range stopped after the first transaction, visited %d", visited)
+	}
+
+	store.Delete("a")
+	store.Delete("c")
+	if _, ok := store.Get("a"); ok || store.Len() != 1 {
+		t.Errorf("[FAIL] expected transaction by key a deleted, %d left", store.Len())
+	}
+}
+
+// Benchmark the stores shared by the goroutines putting, matching and deleting 10k transactions each.
+func BenchmarkMapStore(b *testing.B) {
+	benchmarkStore(b, NewMapStore())
+}
+
+func BenchmarkShardedStore(b *testing.B) {
+	benchmarkStore(b, NewShardedStore(64))
+}
+
+func benchmarkStore(b *testing.B, store Store) {
+	const active = 10000
+	keys := make([]string, active)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("z9hG4bK%d$client.example.com$5060$INVITE", i)
+	}
+	var worker int64
+	b.RunParallel(func(pb *testing.PB) {
+		prefix := fmt.Sprintf("%d:", atomic.AddInt64(&worker, 1))
+		tx := &ServerTransaction{}
+		for i := 0; pb.Next(); i++ {
+			key := prefix + keys[i%active]
+			if err := store.Put(key, tx); err != nil {
+				b.Fatal(err)
+			}
+			store.Get(key)
+			store.Get(key)
+			if i >= active {
+				store.Delete(prefix + keys[(i-active)%active])
+			}
+		}
+	})
+}
+
+// The requests the store refuses to keep transactions of are rejected with 503.
+func TestManagerWithStore(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	tp := newDummyTransport()
+	tm, err := NewManagerWithStore(tp, c_SERVER, Config{}, &limitedStore{NewMapStore(), 1})
+	assertNoError(t, err)
+	defer tm.Stop()
+
+	options := func(branch string) *base.Request {
+		req, err := request([]string{
+			"OPTIONS sip:bob@example.com SIP/2.0",
+			"Via: SIP/2.0/UDP " + c_CLIENT + ";branch=" + branch,
+			"From: <sip:alice@example.com>;tag=1",
+			"To: <sip:bob@example.com>",
+			"Call-Id: " + branch,
+			"CSeq: 1 OPTIONS",
+			"",
+			"",
+		}, logger)
+		assertNoError(t, err)
+		return req
+	}
+
+	tp.toTM <- options("z9hG4bK776store1")
+	select {
+	case tx := <-tm.Requests():
+		if tx.Origin().Method != base.OPTIONS {
+			t.Errorf("[FAIL] expected OPTIONS, got %s", tx.Origin().Short())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] timed out waiting for the request")
+	}
+	if count := tm.countServerTx(); count != 1 {
+		t.Errorf("[FAIL] expected 1 server transaction, got %d", count)
+	}
+
+	tp.toTM <- options("z9hG4bK776store2")
+	select {
+	case sent := <-tp.messages:
+		if res, ok := sent.msg.(*base.Response); !ok || res.StatusCode != 503 {
+			t.Errorf("[FAIL] expected 503 to the request beyond the store size, got %s", sent.msg.Short())
+		}
+	case tx := <-tm.Requests():
+		t.Errorf("[FAIL] expected the request beyond the store size rejected, got %s", tx.Origin().Short())
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] timed out waiting for the response")
+	}
+	if count := tm.countServerTx(); count != 1 {
+		t.Errorf("[FAIL] expected 1 server transaction, got %d", count)
+	}
+}
@@ -0,0 +1,89 @@
+package transaction
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gossip/timing"
+)
+
+// Names of the transaction timers - RFC 3261 - 17, table 4.
+const (
+	timer_a      = "A"
+	timer_b      = "B"
+	timer_d      = "D"
+	timer_e      = "E"
+	timer_f      = "F"
+	timer_k      = "K"
+	timer_g      = "G"
+	timer_h      = "H"
+	timer_i      = "I"
+	timer_l      = "L"
+	timer_cancel = "CANCEL"
+)
+
+// timerBundle holds the running timers of a transaction by name.
+// All of them are stopped at once when the transaction terminates,
+// after that the bundle starts no timers and the stopped ones never fire.
+type timerBundle struct {
+	timers  map[string]timing.Timer
+	stopped bool
+	lock    sync.Mutex
+}
+
+// Start runs f after d, replacing the running timer of the same name.
+func (bundle *timerBundle) Start(name string, d time.Duration, f func()) {
+	bundle.lock.Lock()
+	defer bundle.lock.Unlock()
+	if bundle.stopped {
+		return
+	}
+	if bundle.timers == nil {
+		bundle.timers = make(map[string]timing.Timer)
+	}
+	if timer, ok := bundle.timers[name]; ok {
+		timer.Stop()
+	}
+
+	var timer timing.Timer
+	timer = timing.AfterFunc(d, func() {
+		// The timer may have fired right before it was stopped or replaced.
+		bundle.lock.Lock()
+		current := !bundle.stopped && bundle.timers[name] == timer
+		bundle.lock.Unlock()
+		if current {
+			f()
+		}
+	})
+	bundle.timers[name] = timer
+}
+
+// Reset restarts the running timer of the name to fire after d, does nothing if there is none.
+func (bundle *timerBundle) Reset(name string, d time.Duration) {
+	bundle.lock.Lock()
+	defer bundle.lock.Unlock()
+	if timer, ok := bundle.timers[name]; ok && !bundle.stopped {
+		timer.Reset(d)
+	}
+}
+
+// Stop stops the timer of the name.
+func (bundle *timerBundle) Stop(name string) {
+	bundle.lock.Lock()
+	defer bundle.lock.Unlock()
+	if timer, ok := bundle.timers[name]; ok {
+		timer.Stop()
+		delete(bundle.timers, name)
+	}
+}
+
+// StopAll stops all the timers, the bundle is not usable afterwards.
+func (bundle *timerBundle) StopAll() {
+	bundle.lock.Lock()
+	defer bundle.lock.Unlock()
+	for name, timer := range bundle.timers {
+		timer.Stop()
+		delete(bundle.timers, name)
+	}
+	bundle.stopped = true
+}
@@ -0,0 +1,40 @@
+package transaction
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/timing"
+)
+
+func TestTimerBundleStopAll(t *testing.T) {
+	timing.MockMode = true
+	fired := make(chan string, 3)
+	fire := func(name string) func() {
+		return func() { fired <- name }
+	}
+
+	var bundle timerBundle
+	bundle.Start(timer_a, time.Second, fire("replaced"))
+	bundle.Start(timer_a, time.Second, fire(timer_a))
+	bundle.Start(timer_b, 2*time.Second, fire(timer_b))
+
+	timing.Elapse(time.Second)
+	select {
+	case name := <-fired:
+		if name != timer_a {
+			t.Fatalf("[FAIL] expected timer %s fired, got %s", timer_a, name)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] timer %s was not fired", timer_a)
+	}
+
+	bundle.StopAll()
+	bundle.Start(timer_d, time.Second, fire(timer_d))
+	timing.Elapse(2 * time.Second)
+	select {
+	case name := <-fired:
+		t.Errorf("[FAIL] timer %s fired after the bundle was stopped", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -2,22 +2,39 @@ package transaction
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/discoviking/fsm"
 	"github.com/ghettovoice/gossip/base"
 	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/timing"
 	"github.com/ghettovoice/gossip/transport"
 )
 
+// Default transaction timer values - RFC 3261 - 17, table 4.
+// Config.Timers override them for the transactions of a manager, Manager.SendWithTimers and ServerTransaction.SetTimers
+// for a single transaction.
 const (
-	T1      = 500 * time.Millisecond
-	T2      = 4 * time.Second
-	T4      = 5 * time.Second
+	T1 = 500 * time.Millisecond
+	T2 = 4 * time.Second
+	T4 = 5 * time.Second
+	// INVITE client transaction timers - RFC 3261 - 17.1.1.
 	Timer_A = T1
 	Timer_B = 64 * T1
 	Timer_D = 32 * time.Second
+	// non-INVITE client transaction timers - RFC 3261 - 17.1.2.
+	Timer_E = T1
+	Timer_F = 64 * T1
+	Timer_K = T4
+	// INVITE server transaction timers - RFC 3261 - 17.2.1.
+	Timer_G = T1
 	Timer_H = 64 * T1
+	Timer_I = T4
+	// Accepted state timer of INVITE server transaction retransmitting 2xx - RFC 6026 - 8.7.
+	Timer_L = 64 * T1
+	// non-INVITE server transaction timers - RFC 3261 - 17.2.2.
+	Timer_J = 64 * T1
 )
 
 type Transaction interface {
@@ -30,8 +47,44 @@ type Transaction interface {
 	Delete()
 	IsInvite() bool
 	IsAck() bool
+	// Deadline returns the moment the running timeout timer (B, F, H or J) fires,
+	// or zero time if none is running.
+	Deadline() time.Time
+	// TimeRemaining returns the time left until Deadline, or 0 if there is no deadline.
+	TimeRemaining() time.Duration
+	// Done returns the channel closed once the transaction is terminated,
+	// its channels to the TU deliver nothing afterwards.
+	Done() <-chan struct{}
 }
 
+// ClientTx is the part of ClientTransaction used by the transaction user,
+// depend on it to replace the transaction by transactiontest.FakeClientTx in unit tests.
+type ClientTx interface {
+	Transaction
+	Responses() <-chan *base.Response
+	Errors() <-chan error
+	Cancel()
+}
+
+// ServerTx is the part of ServerTransaction used by the transaction user,
+// depend on it to replace the transaction by transactiontest.FakeServerTx in unit tests.
+type ServerTx interface {
+	Transaction
+	NewResponse(code uint16, reason string, hdrs ...base.SipHeader) *base.Response
+	Respond(res *base.Response)
+	RespondWithStatus(code uint16, reason string, hdrs ...base.SipHeader) *base.Response
+	RespondWith(code uint16, reason string, opts ...ResponseOption) *base.Response
+	Ok(hdrs ...base.SipHeader) *base.Response
+	Ack() <-chan *base.Request
+	Errors() <-chan error
+	Cancelled() <-chan struct{}
+}
+
+var (
+	_ ClientTx = (*ClientTransaction)(nil)
+	_ ServerTx = (*ServerTransaction)(nil)
+)
+
 type transaction struct {
 	fsm       *fsm.FSM       // FSM which governs the behavior of this transaction.
 	origin    *base.Request  // Request that started this transaction.
@@ -40,6 +93,13 @@ type transaction struct {
 	transport transport.Manager
 	tm        *Manager
 	lastErr   error
+	deadline  time.Time // Moment of the running timeout timer expiry, guarded by deadlineLock.
+	timers    timerBundle
+	times     timerValues // Timer values fixed when the transaction is created.
+	done      chan struct{}
+	doneOnce  sync.Once
+
+	deadlineLock sync.Mutex
 }
 
 func (tx *transaction) Log() log.Logger {
@@ -69,3 +129,55 @@ func (tx *transaction) IsInvite() bool {
 func (tx *transaction) IsAck() bool {
 	return tx.origin.IsAck()
 }
+
+func (tx *transaction) Done() <-chan struct{} {
+	return tx.done
+}
+
+// terminate closes the done channel, it's safe to call it more than once.
+func (tx *transaction) terminate() {
+	tx.doneOnce.Do(func() {
+		close(tx.done)
+	})
+}
+
+func (tx *transaction) Deadline() time.Time {
+	tx.deadlineLock.Lock()
+	defer tx.deadlineLock.Unlock()
+	return tx.deadline
+}
+
+func (tx *transaction) TimeRemaining() time.Duration {
+	deadline := tx.Deadline()
+	if deadline.IsZero() {
+		return 0
+	}
+	remaining := deadline.Sub(timing.Now())
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// setDeadline records the expiry of the timeout timer started with duration d.
+func (tx *transaction) setDeadline(d time.Duration) {
+	tx.deadlineLock.Lock()
+	tx.deadline = timing.Now().Add(d)
+	tx.deadlineLock.Unlock()
+}
+
+// clearDeadline should be called once the timeout timer is no longer relevant.
+func (tx *transaction) clearDeadline() {
+	tx.deadlineLock.Lock()
+	tx.deadline = time.Time{}
+	tx.deadlineLock.Unlock()
+}
+
+// isReliable reports whether the message sent to the address goes over a reliable transport,
+// per destination if the transport tells, see transport.ReliabilityReporter.
+func isReliable(tp transport.Manager, addr string, msg base.SipMessage) bool {
+	if reporter, ok := tp.(transport.ReliabilityReporter); ok {
+		return reporter.IsReliableTo(addr, msg)
+	}
+	return tp.IsReliable()
+}
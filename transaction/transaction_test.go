@@ -1,6 +1,7 @@
 package transaction
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -128,6 +129,48 @@ func (actn *userRecv) Act(test *transactionTest) error {
 	}
 }
 
+type userRecvErr struct {
+	kind error
+}
+
+func (actn *userRecvErr) Act(test *transactionTest) error {
+	// check errors on Client transaction
+	select {
+	case err, ok := <-test.lastTx.Errors():
+		if !ok {
+			return fmt.Errorf("errors channel prematurely closed")
+		} else if !errors.Is(err, actn.kind) {
+			return fmt.Errorf("unexpected error: %s", err)
+		}
+		test.t.Logf("transaction User received error: %s", err)
+		return nil
+	case <-time.After(time.Second):
+		return fmt.Errorf("timed out waiting for error")
+	}
+}
+
+type transportNoRecv struct{}
+
+func (actn *transportNoRecv) Act(test *transactionTest) error {
+	select {
+	case msg := <-test.transport.messages:
+		return fmt.Errorf("unexpected message arrived at transport:\n%s", msg.msg.String())
+	case <-time.After(100 * time.Millisecond):
+		return nil
+	}
+}
+
+type txRemaining struct {
+	expected time.Duration
+}
+
+func (actn *txRemaining) Act(test *transactionTest) error {
+	if remaining := test.lastTx.TimeRemaining(); remaining != actn.expected {
+		return fmt.Errorf("unexpected time remaining on transaction: %v, expected %v", remaining, actn.expected)
+	}
+	return nil
+}
+
 type userRecvSrv struct {
 	expected base.SipMessage
 }
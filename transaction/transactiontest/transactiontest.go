@@ -0,0 +1,323 @@
+// Package transactiontest provides fake transactions to unit test transaction users
+// without transaction managers and transports, like net/http/httptest.
+// The fakes implement transaction.ClientTx and transaction.ServerTx, the test scripts what the remote side does:
+// responses and errors of client transactions, ACKs of server transactions; and checks what the code under test did.
+package transactiontest
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/transaction"
+	"github.com/ghettovoice/gossip/transport"
+)
+
+// c_QUEUE_SIZE is the number of scripted messages the fakes keep until the code under test takes them.
+const c_QUEUE_SIZE = 16
+
+var (
+	_ transaction.ClientTx = (*FakeClientTx)(nil)
+	_ transaction.ServerTx = (*FakeServerTx)(nil)
+)
+
+// fake is the part common to the fake transactions.
+type fake struct {
+	origin   *base.Request
+	dest     string
+	errs     chan error
+	done     chan struct{}
+	doneOnce sync.Once
+	lock     sync.Mutex
+}
+
+func newFake(req *base.Request, dest string) fake {
+	return fake{
+		origin: req,
+		dest:   dest,
+		errs:   make(chan error, c_QUEUE_SIZE),
+		done:   make(chan struct{}),
+	}
+}
+
+func (tx *fake) Log() log.Logger {
+	return tx.origin.Log().WithField("tx-ptr", fmt.Sprintf("%p", tx))
+}
+
+func (tx *fake) Origin() *base.Request {
+	return tx.origin
+}
+
+func (tx *fake) Destination() string {
+	return tx.dest
+}
+
+// Transport returns nil, the fakes send nothing.
+func (tx *fake) Transport() transport.Manager {
+	return nil
+}
+
+func (tx *fake) IsInvite() bool {
+	return tx.origin.IsInvite()
+}
+
+func (tx *fake) IsAck() bool {
+	return tx.origin.IsAck()
+}
+
+// Deadline returns zero time, the fakes run no timers.
+func (tx *fake) Deadline() time.Time {
+	return time.Time{}
+}
+
+func (tx *fake) TimeRemaining() time.Duration {
+	return 0
+}
+
+func (tx *fake) Done() <-chan struct{} {
+	return tx.done
+}
+
+func (tx *fake) Errors() <-chan error {
+	return tx.errs
+}
+
+// Delete terminates the transaction.
+func (tx *fake) Delete() {
+	tx.doneOnce.Do(func() {
+		close(tx.done)
+	})
+}
+
+// Terminated reports whether the transaction is terminated.
+func (tx *fake) Terminated() bool {
+	select {
+	case <-tx.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Fail delivers the error on Errors and terminates the transaction, e.g. base.ErrTimeout.
+func (tx *fake) Fail(err error) {
+	tx.errs <- err
+	tx.Delete()
+}
+
+// FakeClientTx is the fake client transaction of the request sent by the code under test.
+// The responses of the remote side are scripted with Respond and RespondWithStatus, and delivered on Responses.
+type FakeClientTx struct {
+	fake
+	responses chan *base.Response
+	lastResp  *base.Response
+	cancelled bool
+}
+
+// NewFakeClientTx creates the fake transaction of the request sent to the destination, e.g. to return from a fake Send.
+func NewFakeClientTx(req *base.Request, dest string) *FakeClientTx {
+	return &FakeClientTx{
+		fake:      newFake(req, dest),
+		responses: make(chan *base.Response, c_QUEUE_SIZE),
+	}
+}
+
+// Receive delivers the response like Respond, other messages are ignored.
+func (tx *FakeClientTx) Receive(msg base.SipMessage) {
+	if res, ok := msg.(*base.Response); ok {
+		tx.Respond(res)
+	}
+}
+
+// Respond delivers the response on Responses as if received from the remote side.
+// Final responses terminate the transaction, the following ones are dropped.
+func (tx *FakeClientTx) Respond(res *base.Response) {
+	tx.lock.Lock()
+	if tx.Terminated() {
+		tx.lock.Unlock()
+		tx.Log().Debugf("fake client transaction %p dropped %s, it's terminated", tx, res.Short())
+		return
+	}
+	tx.lastResp = res
+	tx.lock.Unlock()
+
+	tx.responses <- res
+	if !res.IsProvisional() {
+		tx.Delete()
+	}
+}
+
+// RespondWithStatus builds the response to the request with To tag of the remote side and delivers it with Respond.
+func (tx *FakeClientTx) RespondWithStatus(code uint16, reason string) *base.Response {
+	res := base.NewResponseFromRequest(tx.origin, code, reason, "")
+	if to, err := res.To(); err == nil && code != 100 {
+		if _, ok := to.Params.Get("tag"); !ok {
+			to.Params.Add("tag", base.String{S: base.GenerateTag()})
+		}
+	}
+	tx.Respond(res)
+	return res
+}
+
+func (tx *FakeClientTx) Responses() <-chan *base.Response {
+	return tx.responses
+}
+
+func (tx *FakeClientTx) LastResponse() *base.Response {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	return tx.lastResp
+}
+
+// Cancel records the cancellation, see Cancelled. INVITE without the final response is answered
+// with 487 Request Terminated, as the remote side answers CANCEL - RFC 3261 9.2.
+func (tx *FakeClientTx) Cancel() {
+	if !tx.IsInvite() {
+		return
+	}
+	tx.lock.Lock()
+	answer := !tx.cancelled && (tx.lastResp == nil || tx.lastResp.IsProvisional())
+	tx.cancelled = true
+	tx.lock.Unlock()
+	if answer {
+		tx.RespondWithStatus(487, "Request Terminated")
+	}
+}
+
+// Cancelled reports whether the code under test cancelled the transaction.
+func (tx *FakeClientTx) Cancelled() bool {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	return tx.cancelled
+}
+
+// FakeServerTx is the fake server transaction of the request received by the code under test.
+// The responses of the code under test are recorded, see Responses; ACK and CANCEL of the remote side are scripted
+// with ReceiveAck and ReceiveCancel.
+type FakeServerTx struct {
+	fake
+	acks       chan *base.Request
+	sent       []*base.Response
+	toTag      string
+	confirmed  bool
+	cancelled  chan struct{}
+	cancelOnce sync.Once
+}
+
+// NewFakeServerTx creates the fake transaction of the request received from the source, e.g. to pass to a handler.
+func NewFakeServerTx(req *base.Request, source string) *FakeServerTx {
+	return &FakeServerTx{
+		fake:      newFake(req, source),
+		acks:      make(chan *base.Request, c_QUEUE_SIZE),
+		toTag:     base.GenerateTag(),
+		cancelled: make(chan struct{}),
+	}
+}
+
+// Receive delivers ACK like ReceiveAck and CANCEL like ReceiveCancel,
+// other messages are ignored as retransmissions of the request.
+func (tx *FakeServerTx) Receive(msg base.SipMessage) {
+	req, ok := msg.(*base.Request)
+	switch {
+	case !ok:
+	case req.IsAck():
+		tx.ReceiveAck(req)
+	case req.Method == base.CANCEL:
+		tx.ReceiveCancel()
+	}
+}
+
+// ReceiveAck delivers ACK of the remote side on Ack, it terminates INVITE transaction answered with non-2xx response.
+func (tx *FakeServerTx) ReceiveAck(ack *base.Request) {
+	tx.acks <- ack
+	if res := tx.LastResponse(); res != nil && !res.IsProvisional() && !res.IsSuccess() {
+		tx.Delete()
+	}
+}
+
+func (tx *FakeServerTx) Ack() <-chan *base.Request {
+	return tx.acks
+}
+
+// ReceiveCancel signals CANCEL of the remote side on Cancelled unless the final response is already sent.
+func (tx *FakeServerTx) ReceiveCancel() {
+	if res := tx.LastResponse(); res != nil && !res.IsProvisional() {
+		return
+	}
+	tx.cancelOnce.Do(func() {
+		close(tx.cancelled)
+	})
+}
+
+func (tx *FakeServerTx) Cancelled() <-chan struct{} {
+	return tx.cancelled
+}
+
+// NewResponse builds the response to the request, with To tag of the transaction unless it's 100 Trying.
+func (tx *FakeServerTx) NewResponse(code uint16, reason string, hdrs ...base.SipHeader) *base.Response {
+	res := base.NewResponseFromRequest(tx.origin, code, reason, "")
+	if to, err := res.To(); err == nil && code != 100 {
+		if _, ok := to.Params.Get("tag"); !ok {
+			to.Params.Add("tag", base.String{S: tx.toTag})
+		}
+	}
+	for _, h := range hdrs {
+		res.AddHeader(h)
+	}
+	return res
+}
+
+// Respond records the response. Final responses to non-INVITE requests and 2xx to INVITE terminate the transaction,
+// non-2xx responses to INVITE wait for ACK - RFC 3261 17.2.
+func (tx *FakeServerTx) Respond(res *base.Response) {
+	tx.lock.Lock()
+	if tx.confirmed {
+		tx.lock.Unlock()
+		tx.Log().Warnf("fake server transaction %p dropped %s, final response is already sent", tx, res.Short())
+		return
+	}
+	tx.sent = append(tx.sent, res)
+	final := !res.IsProvisional()
+	tx.confirmed = final
+	tx.lock.Unlock()
+
+	if final && (!tx.IsInvite() || res.IsSuccess()) {
+		tx.Delete()
+	}
+}
+
+func (tx *FakeServerTx) RespondWithStatus(code uint16, reason string, hdrs ...base.SipHeader) *base.Response {
+	res := tx.NewResponse(code, reason, hdrs...)
+	tx.Respond(res)
+	return res
+}
+
+// RespondWith builds the response with transaction.BuildResponse and records it with Respond.
+func (tx *FakeServerTx) RespondWith(code uint16, reason string, opts ...transaction.ResponseOption) *base.Response {
+	res := transaction.BuildResponse(tx.origin, tx.toTag, code, reason, opts...)
+	tx.Respond(res)
+	return res
+}
+
+func (tx *FakeServerTx) Ok(hdrs ...base.SipHeader) *base.Response {
+	return tx.RespondWithStatus(200, "OK", hdrs...)
+}
+
+// Responses returns the responses sent by the code under test in order.
+func (tx *FakeServerTx) Responses() []*base.Response {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	return append([]*base.Response(nil), tx.sent...)
+}
+
+// LastResponse returns the last response sent by the code under test, nil if none.
+func (tx *FakeServerTx) LastResponse() *base.Response {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	if len(tx.sent) == 0 {
+		return nil
+	}
+	return tx.sent[len(tx.sent)-1]
+}
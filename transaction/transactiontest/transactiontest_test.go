@@ -0,0 +1,115 @@
+package transactiontest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+	"github.com/ghettovoice/gossip/transaction"
+)
+
+func request(t *testing.T, method string) *base.Request {
+	msg, err := parser.ParseMessage([]byte(strings.Join([]string{
+		method + " sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP client.example.com:5060;branch=z9hG4bK776asfake",
+		"From: <sip:alice@example.com>;tag=1928301774",
+		"To: <sip:bob@example.com>",
+		"Call-Id: fake",
+		"CSeq: 1 " + method,
+		"Content-Length: 0",
+		"",
+		"",
+	}, "\r\n")), log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse request: %s", err)
+	}
+	return msg.(*base.Request)
+}
+
+// finalStatus is the code under test: it waits for the final response, cancelling the call once it rings.
+func finalStatus(tx transaction.ClientTx) (uint16, error) {
+	for {
+		select {
+		case res := <-tx.Responses():
+			if res.StatusCode == 180 {
+				tx.Cancel()
+			}
+			if !res.IsProvisional() {
+				return res.StatusCode, nil
+			}
+		case err := <-tx.Errors():
+			return 0, err
+		case <-time.After(time.Second):
+			return 0, errors.New("no final response")
+		}
+	}
+}
+
+func TestFakeClientTx(t *testing.T) {
+	tx := NewFakeClientTx(request(t, "INVITE"), "server.example.com:5060")
+	tx.RespondWithStatus(100, "Trying")
+	tx.RespondWithStatus(180, "Ringing")
+	if status, err := finalStatus(tx); err != nil || status != 487 {
+		t.Errorf("[FAIL] expected cancelled INVITE answered with 487, got %d, %v", status, err)
+	}
+	if !tx.Cancelled() || !tx.Terminated() {
+		t.Errorf("[FAIL] expected transaction cancelled and terminated")
+	}
+	tx.RespondWithStatus(200, "OK")
+	if res := tx.LastResponse(); res.StatusCode != 487 {
+		t.Errorf("[FAIL] expected response after the final one dropped, got %s", res.Short())
+	}
+
+	tx = NewFakeClientTx(request(t, "OPTIONS"), "server.example.com:5060")
+	tx.Fail(base.NewError(base.ErrTimeout, nil, "timed out"))
+	if _, err := finalStatus(tx); !errors.Is(err, base.ErrTimeout) {
+		t.Errorf("[FAIL] expected timeout, got %v", err)
+	}
+}
+
+func TestFakeServerTx(t *testing.T) {
+	var tx transaction.ServerTx = NewFakeServerTx(request(t, "INVITE"), "client.example.com:5060")
+	fake := tx.(*FakeServerTx)
+	tx.RespondWithStatus(180, "Ringing")
+	tx.RespondWithStatus(486, "Busy Here")
+	tx.Ok()
+
+	responses := fake.Responses()
+	if len(responses) != 2 || responses[1].StatusCode != 486 {
+		t.Fatalf("[FAIL] expected 180 and 486 recorded, got %v", responses)
+	}
+	if tag, err := responses[1].ToTag(); err != nil || tag == nil {
+		t.Errorf("[FAIL] expected response with To tag, got %v", err)
+	}
+	if fake.Terminated() {
+		t.Errorf("[FAIL] expected INVITE rejected with 486 waiting for ACK")
+	}
+	fake.ReceiveAck(request(t, "ACK"))
+	if ack := <-tx.Ack(); !ack.IsAck() || !fake.Terminated() {
+		t.Errorf("[FAIL] expected ACK delivered and transaction terminated")
+	}
+}
+
+func TestFakeServerTxCancel(t *testing.T) {
+	fake := NewFakeServerTx(request(t, "INVITE"), "client.example.com:5060")
+	fake.RespondWithStatus(180, "Ringing")
+	fake.Receive(request(t, "CANCEL"))
+	select {
+	case <-fake.Cancelled():
+	default:
+		t.Fatalf("[FAIL] expected INVITE cancelled")
+	}
+
+	fake = NewFakeServerTx(request(t, "INVITE"), "client.example.com:5060")
+	fake.Ok()
+	fake.ReceiveCancel()
+	select {
+	case <-fake.Cancelled():
+		t.Errorf("[FAIL] expected CANCEL after the final response ignored")
+	default:
+	}
+}
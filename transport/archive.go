@@ -0,0 +1,324 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/timing"
+)
+
+// ArchiveSink stores the archived messages, e.g. in rotating files, see RotatingFileSink,
+// or in Kafka or S3 by the application implementations.
+type ArchiveSink interface {
+	// Archive stores the batch of messages in order.
+	Archive(entries []CaptureEntry) error
+	// Close flushes and releases the sink, it's called once the archiver is stopped.
+	Close() error
+}
+
+// Sampler decides whether the message is archived.
+type Sampler func(msg base.SipMessage) bool
+
+// SampleCalls archives the messages of the rate of the calls, 0 to 1, by the hash of Call-ID,
+// so all the messages of a sampled call are archived. The messages without Call-ID are archived.
+func SampleCalls(rate float64) Sampler {
+	return func(msg base.SipMessage) bool {
+		callId, err := msg.CallId()
+		if err != nil {
+			return true
+		}
+		hash := fnv.New32a()
+		hash.Write([]byte(*callId))
+		return float64(hash.Sum32())/(1<<32) < rate
+	}
+}
+
+// Redactor returns the text of the message to archive, with the sensitive contents removed.
+type Redactor func(msg base.SipMessage) string
+
+// RedactHeaders replaces the values of the headers by "<redacted>", e.g. Authorization and Proxy-Authorization.
+func RedactHeaders(names ...string) Redactor {
+	redacted := make(map[string]bool)
+	for _, name := range names {
+		redacted[strings.ToLower(name)] = true
+	}
+	return func(msg base.SipMessage) string {
+		text := msg.String()
+		end := strings.Index(text, "\r\n\r\n")
+		if end < 0 {
+			end = len(text)
+		}
+		lines := strings.Split(text[:end], "\r\n")
+		for i, line := range lines {
+			if colon := strings.Index(line, ":"); i > 0 && colon > 0 && redacted[strings.ToLower(strings.TrimSpace(line[:colon]))] {
+				lines[i] = line[:colon] + ": <redacted>"
+			}
+		}
+		return strings.Join(lines, "\r\n") + text[end:]
+	}
+}
+
+// ArchiveConfig configures Archiver.
+type ArchiveConfig struct {
+	// Sampler selects the archived messages, nil archives all.
+	Sampler Sampler
+	// Redactor produces the archived text, nil archives the messages as they are.
+	Redactor Redactor
+	// QueueSize is the number of messages waiting for the sink, 1000 by default.
+	// The messages beyond it are dropped rather than delaying the signaling, see Archiver.Dropped.
+	QueueSize int
+	// BatchSize is the maximum number of messages passed to the sink at once, 100 by default.
+	BatchSize int
+}
+
+// Archiver is a Manager archiving all messages crossing the wrapped one to the sink asynchronously,
+// for troubleshooting and compliance. Use it in place of the wrapped manager, like Recorder.
+type Archiver struct {
+	Manager
+	notifier
+	sink      ArchiveSink
+	cfg       ArchiveConfig
+	queue     chan CaptureEntry
+	done      chan struct{}
+	stopOnce  sync.Once
+	archived  uint64
+	dropped   uint64
+	failed    uint64
+	queueLock sync.RWMutex // Guards sending to the queue against closing it.
+	stopped   bool
+}
+
+// NewArchiver wraps the manager, the messages are archived to the sink until the archiver is stopped.
+func NewArchiver(m Manager, sink ArchiveSink, cfg ArchiveConfig) *Archiver {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	arc := &Archiver{
+		Manager: m,
+		sink:    sink,
+		cfg:     cfg,
+		queue:   make(chan CaptureEntry, cfg.QueueSize),
+		done:    make(chan struct{}),
+	}
+	arc.notifier.init()
+	go arc.write()
+
+	input := m.GetChannel()
+	go func() {
+		for msg := range input {
+			arc.archive(DirectionIn, "", msg)
+			arc.notifier.inputs <- msg
+		}
+		close(arc.notifier.inputs)
+	}()
+	return arc
+}
+
+func (arc *Archiver) Send(addr string, message base.SipMessage) error {
+	arc.archive(DirectionOut, addr, message)
+	return arc.Manager.Send(addr, message)
+}
+
+func (arc *Archiver) GetChannel() Listener {
+	return arc.notifier.GetChannel()
+}
+
+// Stop stops the wrapped manager, then waits until the queued messages are archived and closes the sink.
+func (arc *Archiver) Stop() {
+	arc.Manager.Stop()
+	arc.notifier.stop()
+	arc.stopOnce.Do(func() {
+		arc.queueLock.Lock()
+		arc.stopped = true
+		close(arc.queue)
+		arc.queueLock.Unlock()
+		<-arc.done
+	})
+}
+
+// Archived returns the number of the messages stored by the sink.
+func (arc *Archiver) Archived() uint64 {
+	return atomic.LoadUint64(&arc.archived)
+}
+
+// Dropped returns the number of the messages dropped because the queue was full.
+func (arc *Archiver) Dropped() uint64 {
+	return atomic.LoadUint64(&arc.dropped)
+}
+
+// Failed returns the number of the messages the sink failed to store.
+func (arc *Archiver) Failed() uint64 {
+	return atomic.LoadUint64(&arc.failed)
+}
+
+// archive queues the message unless it's not sampled or the queue is full.
+func (arc *Archiver) archive(direction Direction, addr string, msg base.SipMessage) {
+	if arc.cfg.Sampler != nil && !arc.cfg.Sampler(msg) {
+		return
+	}
+	var text string
+	if arc.cfg.Redactor != nil {
+		text = arc.cfg.Redactor(msg)
+	} else {
+		text = msg.String()
+	}
+	entry := CaptureEntry{Time: timing.Now(), Direction: direction, Addr: addr, Message: text}
+
+	arc.queueLock.RLock()
+	defer arc.queueLock.RUnlock()
+	if arc.stopped {
+		return
+	}
+	select {
+	case arc.queue <- entry:
+	default:
+		atomic.AddUint64(&arc.dropped, 1)
+		msg.Log().Debugf("archive queue is full, message %s dropped", msg.Short())
+	}
+}
+
+// write passes the queued messages to the sink in batches until the queue is closed.
+func (arc *Archiver) write() {
+	defer close(arc.done)
+	batch := make([]CaptureEntry, 0, arc.cfg.BatchSize)
+	for entry := range arc.queue {
+		batch = append(batch[:0], entry)
+	fill:
+		for len(batch) < arc.cfg.BatchSize {
+			select {
+			case next, ok := <-arc.queue:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, next)
+			default:
+				break fill
+			}
+		}
+		if err := arc.sink.Archive(batch); err != nil {
+			atomic.AddUint64(&arc.failed, uint64(len(batch)))
+			log.Warnf("failed to archive %d messages: %s", len(batch), err)
+		} else {
+			atomic.AddUint64(&arc.archived, uint64(len(batch)))
+		}
+	}
+	if err := arc.sink.Close(); err != nil {
+		log.Warnf("failed to close archive sink: %s", err)
+	}
+}
+
+// RotatingFileSink archives the messages to the file as JSON lines of CaptureEntry.
+// Once the file exceeds the size limit it's rotated: renamed to path.1, the previous path.1 to path.2 and so on,
+// the oldest beyond the number of kept files is removed.
+type RotatingFileSink struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	writer   *bufio.Writer
+	size     int64
+	lock     sync.Mutex
+}
+
+// NewRotatingFileSink opens the file for appending, rotated after maxSize bytes, 0 never rotates it.
+// maxFiles is the number of the rotated files kept besides the current one.
+func NewRotatingFileSink(path string, maxSize int64, maxFiles int) (*RotatingFileSink, error) {
+	sink := &RotatingFileSink{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (sink *RotatingFileSink) open() error {
+	file, err := os.OpenFile(sink.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open archive file: %s", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open archive file: %s", err)
+	}
+	sink.file = file
+	sink.writer = bufio.NewWriter(file)
+	sink.size = info.Size()
+	return nil
+}
+
+// Archive implements ArchiveSink, the batch is flushed to the file.
+func (sink *RotatingFileSink) Archive(entries []CaptureEntry) error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	if sink.file == nil {
+		return fmt.Errorf("archive file %s is closed", sink.path)
+	}
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if sink.maxSize > 0 && sink.size > 0 && sink.size+int64(len(line))+1 > sink.maxSize {
+			if err := sink.rotate(); err != nil {
+				return err
+			}
+		}
+		n, err := sink.writer.Write(append(line, '\n'))
+		sink.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return sink.writer.Flush()
+}
+
+// rotate shifts the rotated files and starts the new one.
+func (sink *RotatingFileSink) rotate() error {
+	if err := sink.closeFile(); err != nil {
+		return err
+	}
+	if sink.maxFiles <= 0 {
+		if err := os.Remove(sink.path); err != nil {
+			return fmt.Errorf("failed to rotate archive file: %s", err)
+		}
+		return sink.open()
+	}
+	os.Remove(fmt.Sprintf("%s.%d", sink.path, sink.maxFiles))
+	for n := sink.maxFiles - 1; n > 0; n-- {
+		os.Rename(fmt.Sprintf("%s.%d", sink.path, n), fmt.Sprintf("%s.%d", sink.path, n+1))
+	}
+	if err := os.Rename(sink.path, sink.path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate archive file: %s", err)
+	}
+	return sink.open()
+}
+
+func (sink *RotatingFileSink) closeFile() error {
+	err := sink.writer.Flush()
+	if closeErr := sink.file.Close(); err == nil {
+		err = closeErr
+	}
+	sink.file = nil
+	return err
+}
+
+// Close implements ArchiveSink.
+func (sink *RotatingFileSink) Close() error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	if sink.file == nil {
+		return nil
+	}
+	return sink.closeFile()
+}
@@ -0,0 +1,134 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+)
+
+// memorySink keeps the archived messages.
+type memorySink struct {
+	entries []CaptureEntry
+	closed  bool
+	lock    sync.Mutex
+}
+
+func (sink *memorySink) Archive(entries []CaptureEntry) error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	sink.entries = append(sink.entries, entries...)
+	return nil
+}
+
+func (sink *memorySink) Close() error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	sink.closed = true
+	return nil
+}
+
+func TestArchiver(t *testing.T) {
+	req, err := parser.ParseMessage([]byte(captureRequest), log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse request: %s", err)
+	}
+	req.AddHeader(&base.GenericHeader{HeaderName: "Authorization", Contents: `Digest username="uac", response="secret"`})
+
+	sink := new(memorySink)
+	uas := NewArchiver(newMemoryManager(t, "archive-uas"), sink, ArchiveConfig{
+		Redactor: RedactHeaders("authorization"),
+	})
+	uasInput := uas.GetChannel()
+	uac := newMemoryManager(t, "archive-uac")
+	defer uac.Stop()
+	uacInput := uac.GetChannel()
+
+	if err := uac.Send("archive-uas", req); err != nil {
+		t.Fatalf("[FAIL] failed to send request: %s", err)
+	}
+	received := receive(t, uasInput).(*base.Request)
+	if err := uas.Send("archive-uac", base.NewResponseFromRequest(received, 200, "OK", "")); err != nil {
+		t.Fatalf("[FAIL] failed to send response: %s", err)
+	}
+	receive(t, uacInput)
+	uas.Stop()
+
+	if !sink.closed || len(sink.entries) != 2 || uas.Archived() != 2 {
+		t.Fatalf("[FAIL] expected 2 messages archived and the sink closed, got %+v", sink.entries)
+	}
+	if in := sink.entries[0]; in.Direction != DirectionIn ||
+		!strings.Contains(in.Message, "Authorization: <redacted>\r\n") || strings.Contains(in.Message, "secret") {
+		t.Errorf("[FAIL] expected received request with Authorization redacted, got %+v", in)
+	}
+	if out := sink.entries[1]; out.Direction != DirectionOut || out.Addr != "archive-uac" ||
+		!strings.HasPrefix(out.Message, "SIP/2.0 200 OK\r\n") {
+		t.Errorf("[FAIL] expected sent response, got %+v", out)
+	}
+}
+
+func TestArchiverSampling(t *testing.T) {
+	req, _ := parser.ParseMessage([]byte(captureRequest), log.StandardLogger())
+	for _, rate := range []float64{0, 1} {
+		sink := new(memorySink)
+		arc := NewArchiver(newMemoryManager(t, "archive-sampled"), sink, ArchiveConfig{Sampler: SampleCalls(rate)})
+		arc.Send("archive-nowhere", req)
+		arc.Stop()
+		if expected := int(rate); len(sink.entries) != expected {
+			t.Errorf("[FAIL] rate %v: expected %d messages archived, got %d", rate, expected, len(sink.entries))
+		}
+	}
+}
+
+func TestRotatingFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.log")
+	entry := CaptureEntry{Direction: DirectionIn, Message: captureRequest}
+	line, _ := json.Marshal(entry)
+
+	// Every file holds two entries, two rotated files are kept.
+	sink, err := NewRotatingFileSink(path, int64(2*(len(line)+1)), 2)
+	if err != nil {
+		t.Fatalf("[FAIL] %s", err)
+	}
+	for i := 0; i < 7; i++ {
+		if err := sink.Archive([]CaptureEntry{entry}); err != nil {
+			t.Fatalf("[FAIL] failed to archive: %s", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("[FAIL] failed to close: %s", err)
+	}
+
+	for name, expected := range map[string]int{path: 1, path + ".1": 2, path + ".2": 2, path + ".3": -1} {
+		file, err := os.Open(name)
+		if expected < 0 {
+			if err == nil {
+				file.Close()
+				t.Errorf("[FAIL] expected %s removed", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[FAIL] %s", err)
+			continue
+		}
+		count := 0
+		for scanner := bufio.NewScanner(file); scanner.Scan(); count++ {
+			var read CaptureEntry
+			if err := json.Unmarshal(scanner.Bytes(), &read); err != nil || read.Message != captureRequest {
+				t.Errorf("[FAIL] unexpected line in %s: %s", name, scanner.Text())
+			}
+		}
+		file.Close()
+		if count != expected {
+			t.Errorf("[FAIL] expected %d entries in %s, got %d", expected, name, count)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package transport
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/timing"
+)
+
+// Blacklist keeps the hosts requests are dropped from on receipt, e.g. banned for repeated authentication failures.
+// The sender of a request is identified by the host of its top Via hop, or its received parameter if there is one.
+type Blacklist struct {
+	hosts map[string]time.Time // Expiry of the ban by host, zero time means forever.
+	lock  sync.RWMutex
+}
+
+// BlacklistApplier is implemented by transports dropping the requests of blacklisted hosts.
+type BlacklistApplier interface {
+	// SetBlacklist sets the blacklist checked on receipt, nil disables it.
+	SetBlacklist(blacklist *Blacklist)
+}
+
+func NewBlacklist() *Blacklist {
+	return &Blacklist{hosts: make(map[string]time.Time)}
+}
+
+// Ban blacklists the host for the duration, 0 bans it until Unban. Banning the banned host extends its ban.
+func (blacklist *Blacklist) Ban(host string, duration time.Duration) {
+	var expires time.Time
+	if duration > 0 {
+		expires = timing.Now().Add(duration)
+	}
+	blacklist.lock.Lock()
+	defer blacklist.lock.Unlock()
+	blacklist.hosts[host] = expires
+}
+
+// Unban removes the host from the blacklist.
+func (blacklist *Blacklist) Unban(host string) {
+	blacklist.lock.Lock()
+	defer blacklist.lock.Unlock()
+	delete(blacklist.hosts, host)
+}
+
+// Banned reports whether the host is blacklisted now.
+func (blacklist *Blacklist) Banned(host string) bool {
+	blacklist.lock.RLock()
+	expires, ok := blacklist.hosts[host]
+	blacklist.lock.RUnlock()
+	if !ok {
+		return false
+	}
+	if !expires.IsZero() && !timing.Now().Before(expires) {
+		blacklist.Unban(host)
+		return false
+	}
+	return true
+}
+
+// Hosts returns the hosts blacklisted now, sorted.
+func (blacklist *Blacklist) Hosts() []string {
+	blacklist.lock.RLock()
+	defer blacklist.lock.RUnlock()
+	now := timing.Now()
+	hosts := make([]string, 0, len(blacklist.hosts))
+	for host, expires := range blacklist.hosts {
+		if expires.IsZero() || now.Before(expires) {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Blocks reports whether the message is a request of a blacklisted host.
+// Responses are never blocked, their top Via hop is the one of the receiver.
+func (blacklist *Blacklist) Blocks(msg base.SipMessage) bool {
+	if _, ok := msg.(*base.Request); !ok {
+		return false
+	}
+	host := SourceHost(msg)
+	return host != "" && blacklist.Banned(host)
+}
+
+// SourceHost returns the host the message was sent from as far as the top Via hop tells:
+// its received parameter if there is one, otherwise its sent-by host - RFC 3261 18.2.1.
+func SourceHost(msg base.SipMessage) string {
+	hop, err := msg.ViaHop()
+	if err != nil {
+		return ""
+	}
+	if received, ok := hop.Params.Get("received"); ok && received != nil && received.String() != "" {
+		return received.String()
+	}
+	return hop.Host
+}
+
+// SetBlacklist implements BlacklistApplier, requests of the blacklisted hosts are dropped before the listeners.
+func (manager *manager) SetBlacklist(blacklist *Blacklist) {
+	manager.notifier.listenerLock.Lock()
+	defer manager.notifier.listenerLock.Unlock()
+	manager.notifier.blacklist = blacklist
+}
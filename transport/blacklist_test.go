@@ -0,0 +1,74 @@
+package transport
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+	"github.com/ghettovoice/gossip/timing"
+)
+
+func TestBlacklist(t *testing.T) {
+	blacklist := NewBlacklist()
+	blacklist.Ban("10.0.0.1", time.Minute)
+	blacklist.Ban("10.0.0.2", 0)
+	if hosts := fmt.Sprint(blacklist.Hosts()); hosts != "[10.0.0.1 10.0.0.2]" {
+		t.Errorf("[FAIL] unexpected banned hosts %s", hosts)
+	}
+
+	timing.Elapse(time.Minute)
+	if blacklist.Banned("10.0.0.1") {
+		t.Errorf("[FAIL] expected the ban of 10.0.0.1 expired")
+	}
+	if !blacklist.Banned("10.0.0.2") {
+		t.Errorf("[FAIL] expected 10.0.0.2 banned until unbanned")
+	}
+	blacklist.Unban("10.0.0.2")
+	if hosts := blacklist.Hosts(); len(hosts) != 0 {
+		t.Errorf("[FAIL] expected no banned hosts, got %v", hosts)
+	}
+}
+
+func TestBlacklistDropsRequests(t *testing.T) {
+	uas := newMemoryManager(t, "blacklist-uas")
+	defer uas.Stop()
+	uac := newMemoryManager(t, "blacklist-uac")
+	defer uac.Stop()
+	blacklist := NewBlacklist()
+	uas.(BlacklistApplier).SetBlacklist(blacklist)
+	received := uas.GetChannel()
+
+	request := func(via string) base.SipMessage {
+		msg, err := parser.ParseMessage([]byte("REGISTER sip:example.com SIP/2.0\r\n"+
+			"Via: SIP/2.0/UDP "+via+";branch="+base.GenerateBranch()+"\r\n"+
+			"CSeq: 1 REGISTER\r\n"+
+			"Content-Length: 0\r\n\r\n"), log.StandardLogger())
+		if err != nil {
+			t.Fatalf("[FAIL] failed to parse request: %s", err)
+		}
+		return msg
+	}
+
+	blacklist.Ban("10.0.0.1", 0)
+	for _, via := range []string{"10.0.0.1:5060", "10.0.0.2:5060;received=10.0.0.1", "10.0.0.2:5060"} {
+		if err := uac.Send("blacklist-uas", request(via)); err != nil {
+			t.Fatalf("[FAIL] failed to send request: %s", err)
+		}
+	}
+	select {
+	case msg := <-received:
+		if host := SourceHost(msg); host != "10.0.0.2" {
+			t.Errorf("[FAIL] expected only the request of 10.0.0.2 received, got one of %s", host)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] request was not received")
+	}
+	select {
+	case msg := <-received:
+		t.Errorf("[FAIL] unexpected request of %s received", SourceHost(msg))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,162 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+	"github.com/ghettovoice/gossip/timing"
+)
+
+// Direction of the captured message relative to the recorded manager.
+type Direction string
+
+const (
+	DirectionIn  Direction = "in"
+	DirectionOut Direction = "out"
+)
+
+// CaptureEntry is a single message crossing the recorded manager.
+type CaptureEntry struct {
+	Time      time.Time `json:"time"`
+	Direction Direction `json:"direction"`
+	// Destination address of the sent messages, empty for the received ones.
+	Addr    string `json:"addr,omitempty"`
+	Message string `json:"message"`
+}
+
+// Capture is a recorded conversation, portable as JSON to attach to bug reports.
+type Capture struct {
+	Entries []CaptureEntry `json:"entries"`
+}
+
+// ReadCapture decodes the capture written by Capture.Write.
+func ReadCapture(r io.Reader) (*Capture, error) {
+	capture := new(Capture)
+	if err := json.NewDecoder(r).Decode(capture); err != nil {
+		return nil, fmt.Errorf("failed to read capture: %s", err)
+	}
+	return capture, nil
+}
+
+// Write encodes the capture as indented JSON.
+func (capture *Capture) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(capture)
+}
+
+// Recorder is a Manager capturing all messages crossing the wrapped one.
+// Use it in place of the wrapped manager, e.g. when creating the transaction manager.
+type Recorder struct {
+	Manager
+	notifier
+	entries []CaptureEntry
+	lock    sync.Mutex
+}
+
+func NewRecorder(m Manager) *Recorder {
+	rec := &Recorder{Manager: m}
+	rec.notifier.init()
+
+	input := m.GetChannel()
+	go func() {
+		for msg := range input {
+			rec.record(DirectionIn, "", msg)
+			rec.notifier.inputs <- msg
+		}
+		close(rec.notifier.inputs)
+	}()
+	return rec
+}
+
+func (rec *Recorder) Send(addr string, message base.SipMessage) error {
+	rec.record(DirectionOut, addr, message)
+	return rec.Manager.Send(addr, message)
+}
+
+func (rec *Recorder) GetChannel() Listener {
+	return rec.notifier.GetChannel()
+}
+
+func (rec *Recorder) Stop() {
+	rec.Manager.Stop()
+	rec.notifier.stop()
+}
+
+// Capture returns the messages recorded so far.
+func (rec *Recorder) Capture() *Capture {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	return &Capture{Entries: append([]CaptureEntry(nil), rec.entries...)}
+}
+
+func (rec *Recorder) record(direction Direction, addr string, msg base.SipMessage) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	rec.entries = append(rec.entries, CaptureEntry{
+		Time:      timing.Now(),
+		Direction: direction,
+		Addr:      addr,
+		Message:   msg.String(),
+	})
+}
+
+// Replayer plays the remote side of the captured conversation over the memory transport.
+// It listens on the addresses the recorded manager sent messages to,
+// the messages the replayed manager sends there are available through GetChannel.
+type Replayer struct {
+	Manager
+	capture *Capture
+	// Factor of the recorded pauses between the received messages, 1 keeps the recorded timing, 0 disables pauses.
+	PauseScale float64
+}
+
+func NewReplayer(capture *Capture) (*Replayer, error) {
+	m, err := NewManager("memory")
+	if err != nil {
+		return nil, err
+	}
+
+	bound := make(map[string]bool)
+	for _, entry := range capture.Entries {
+		if entry.Direction != DirectionOut || bound[entry.Addr] {
+			continue
+		}
+		if err := m.Listen(entry.Addr); err != nil {
+			m.Stop()
+			return nil, err
+		}
+		bound[entry.Addr] = true
+	}
+
+	return &Replayer{Manager: m, capture: capture, PauseScale: 1}, nil
+}
+
+// Run injects the received messages of the capture, in order, into the memory transport listening on the target.
+func (rep *Replayer) Run(target string) error {
+	var last time.Time
+	for idx, entry := range rep.capture.Entries {
+		if entry.Direction != DirectionIn {
+			continue
+		}
+		if !last.IsZero() && rep.PauseScale > 0 {
+			timing.Sleep(time.Duration(float64(entry.Time.Sub(last)) * rep.PauseScale))
+		}
+		last = entry.Time
+
+		msg, err := parser.ParseMessage([]byte(entry.Message), log.StandardLogger())
+		if err != nil {
+			return fmt.Errorf("failed to parse message %d of the capture: %s", idx, err)
+		}
+		if err := rep.Send(target, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+)
+
+const captureRequest = "OPTIONS sip:uas@example.com SIP/2.0\r\n" +
+	"Via: SIP/2.0/UDP uac;branch=z9hG4bK776asdhds\r\n" +
+	"From: <sip:uac@example.com>;tag=1928301774\r\n" +
+	"To: <sip:uas@example.com>\r\n" +
+	"Call-Id: a84b4c76e66710\r\n" +
+	"CSeq: 1 OPTIONS\r\n" +
+	"Content-Length: 0\r\n" +
+	"\r\n"
+
+func receive(t *testing.T, c Listener) base.SipMessage {
+	select {
+	case msg := <-c:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] message was not received")
+	}
+	return nil
+}
+
+func newMemoryManager(t *testing.T, addr string) Manager {
+	m, err := NewManager("memory")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create memory transport manager: %s", err)
+	}
+	if err := m.Listen(addr); err != nil {
+		t.Fatalf("[FAIL] failed to listen on %s: %s", addr, err)
+	}
+	return m
+}
+
+// Test that the conversation recorded on one manager is replayed against another one.
+func TestRecordAndReplay(t *testing.T) {
+	req, err := parser.ParseMessage([]byte(captureRequest), log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse request: %s", err)
+	}
+
+	uas := NewRecorder(newMemoryManager(t, "capture-uas"))
+	uasInput := uas.GetChannel()
+	uac := newMemoryManager(t, "capture-uac")
+	uacInput := uac.GetChannel()
+
+	if err := uac.Send("capture-uas", req); err != nil {
+		t.Fatalf("[FAIL] failed to send request: %s", err)
+	}
+	received := receive(t, uasInput).(*base.Request)
+	res := base.NewResponseFromRequest(received, 200, "OK", "")
+	if err := uas.Send("capture-uac", res); err != nil {
+		t.Fatalf("[FAIL] failed to send response: %s", err)
+	}
+	receive(t, uacInput)
+	uas.Stop()
+	uac.Stop()
+
+	var buffer bytes.Buffer
+	if err := uas.Capture().Write(&buffer); err != nil {
+		t.Fatalf("[FAIL] failed to write capture: %s", err)
+	}
+	capture, err := ReadCapture(&buffer)
+	if err != nil {
+		t.Fatalf("[FAIL] %s", err)
+	}
+	if len(capture.Entries) != 2 ||
+		capture.Entries[0].Direction != DirectionIn ||
+		capture.Entries[1].Direction != DirectionOut || capture.Entries[1].Addr != "capture-uac" {
+		t.Fatalf("[FAIL] unexpected capture entries: %+v", capture.Entries)
+	}
+
+	replayed := newMemoryManager(t, "capture-uas")
+	defer replayed.Stop()
+	replayedInput := replayed.GetChannel()
+	replayer, err := NewReplayer(capture)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create replayer: %s", err)
+	}
+	defer replayer.Stop()
+	replayer.PauseScale = 0
+	output := replayer.GetChannel()
+
+	if err := replayer.Run("capture-uas"); err != nil {
+		t.Fatalf("[FAIL] replay failed: %s", err)
+	}
+	injected := receive(t, replayedInput)
+	if injected.String() != capture.Entries[0].Message {
+		t.Errorf("[FAIL] expected replayed message:\n%s\ngot:\n%s", capture.Entries[0].Message, injected.String())
+	}
+
+	res = base.NewResponseFromRequest(injected.(*base.Request), 200, "OK", "")
+	if err := replayed.Send("capture-uac", res); err != nil {
+		t.Fatalf("[FAIL] failed to send response to replayer: %s", err)
+	}
+	if got := receive(t, output); got.String() != capture.Entries[1].Message {
+		t.Errorf("[FAIL] expected response:\n%s\ngot:\n%s", capture.Entries[1].Message, got.String())
+	}
+}
+
+func TestMemorySendToUnboundAddress(t *testing.T) {
+	m := newMemoryManager(t, "memory-unbound-sender")
+	defer m.Stop()
+
+	req, _ := parser.ParseMessage([]byte(captureRequest), log.StandardLogger())
+	if err := m.Send("memory-nowhere", req); !errors.Is(err, base.ErrTransport) {
+		t.Errorf("[FAIL] expected sending to unbound memory address to fail with transport error, got: %v", err)
+	}
+}
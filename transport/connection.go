@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net"
+	"sync"
 
 	"github.com/ghettovoice/gossip/base"
 	"github.com/ghettovoice/gossip/log"
@@ -18,9 +19,34 @@ type connection struct {
 	parserErrors   chan error
 	output         chan base.SipMessage
 	log            log.Logger
+	addr           string             // Address the connection is known by in the connection table.
+	failures       chan<- FlowFailure // Where to report the unexpected loss of the connection, may be nil.
+	lengthPolicy   parser.ContentLengthPolicy
+	startLineHook  parser.StartLineHook
+	received       func(conn *connection, msg base.SipMessage) // Called on the received messages before passing them up, may be nil.
+	inbound        bool                                        // Accepted on a listening point rather than opened locally.
+	pongs          chan struct{}                               // Keep-alive pongs received over the connection.
+	done           chan struct{}                               // Closed once the connection is closed locally.
+	closeOnce      sync.Once
 }
 
 func NewConn(baseConn net.Conn, output chan base.SipMessage, logger log.Logger) *connection {
+	return newMonitoredConn(baseConn, output, "", nil, parser.ContentLengthIgnore, nil, nil, logger)
+}
+
+// newMonitoredConn creates a connection which reports to failures channel when the remote side
+// breaks the connection, as opposed to it being closed locally.
+// The connection opened to the address is the client side of the flow, without the address it's an accepted one.
+func newMonitoredConn(
+	baseConn net.Conn,
+	output chan base.SipMessage,
+	addr string,
+	failures chan<- FlowFailure,
+	lengthPolicy parser.ContentLengthPolicy,
+	startLineHook parser.StartLineHook,
+	received func(conn *connection, msg base.SipMessage),
+	logger log.Logger,
+) *connection {
 	var isStreamed bool
 	switch baseConn.(type) {
 	case *net.UDPConn:
@@ -29,6 +55,8 @@ func NewConn(baseConn net.Conn, output chan base.SipMessage, logger log.Logger)
 		isStreamed = true
 	case *tls.Conn:
 		isStreamed = true
+	case *wsConn:
+		isStreamed = true
 	default:
 		logger.Errorf(
 			"conn object %v is not a known connection type. "+
@@ -36,17 +64,28 @@ func NewConn(baseConn net.Conn, output chan base.SipMessage, logger log.Logger)
 			baseConn,
 		)
 	}
-	connection := connection{baseConn: baseConn, isStreamed: isStreamed, log: logger}
+	inbound := addr == ""
+	if inbound {
+		addr = baseConn.RemoteAddr().String()
+	}
+	connection := connection{
+		baseConn:      baseConn,
+		isStreamed:    isStreamed,
+		log:           logger,
+		addr:          addr,
+		failures:      failures,
+		lengthPolicy:  lengthPolicy,
+		startLineHook: startLineHook,
+		received:      received,
+		inbound:       inbound,
+		pongs:         make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
 
 	connection.parsedMessages = make(chan base.SipMessage)
 	connection.parserErrors = make(chan error)
 	connection.output = output
-	connection.parser = parser.NewParser(
-		connection.parsedMessages,
-		connection.parserErrors,
-		connection.isStreamed,
-		logger,
-	)
+	connection.parser = connection.newParser(logger)
 
 	go connection.read()
 	go connection.pipeOutput()
@@ -59,7 +98,7 @@ func (connection *connection) Log() log.Logger {
 }
 
 func (connection *connection) Send(msg base.SipMessage) (err error) {
-	connection.Log().Debugf("sending message over connection %p: %s", connection, msg.Short())
+	msg.Log().Debugf("sending message over connection %p: %s", connection, msg.Short())
 	msgData := msg.String()
 	n, err := connection.baseConn.Write([]byte(msgData))
 
@@ -77,10 +116,23 @@ func (connection *connection) Send(msg base.SipMessage) (err error) {
 
 func (connection *connection) Close() error {
 	connection.Log().Debugf("connection for address %s expired, will be removed", connection.baseConn.RemoteAddr())
+	connection.closeOnce.Do(func() {
+		close(connection.done)
+	})
 	connection.parser.Stop()
 	return connection.baseConn.Close()
 }
 
+// isClosed reports whether the connection was closed locally.
+func (connection *connection) isClosed() bool {
+	select {
+	case <-connection.done:
+		return true
+	default:
+		return false
+	}
+}
+
 func (connection *connection) read() {
 	buffer := make([]byte, c_BUFSIZE)
 	for {
@@ -93,6 +145,9 @@ func (connection *connection) read() {
 				connection.baseConn.RemoteAddr().String(),
 				connection.baseConn.LocalAddr().String(),
 			)
+			if connection.failures != nil && !connection.isClosed() {
+				connection.failures <- FlowFailure{Addr: connection.addr, Err: err, conn: connection}
+			}
 			return
 		}
 
@@ -114,6 +169,10 @@ func (connection *connection) pipeOutput() {
 					connection.baseConn.LocalAddr(),
 					message.Short(),
 				)
+				stampSource(message, connection.baseConn.RemoteAddr().String())
+				if connection.received != nil {
+					connection.received(connection, message)
+				}
 				connection.output <- message
 			} else {
 				break
@@ -122,12 +181,7 @@ func (connection *connection) pipeOutput() {
 			if ok {
 				// The parser has hit a terminal error. We need to restart it.
 				connection.Log().Warnf("failed to parse SIP message: %s", err.Error())
-				connection.parser = parser.NewParser(
-					connection.parsedMessages,
-					connection.parserErrors,
-					connection.isStreamed,
-					connection.Log(),
-				)
+				connection.parser = connection.newParser(connection.Log())
 			} else {
 				break
 			}
@@ -141,3 +195,16 @@ func (connection *connection) pipeOutput() {
 		connection.baseConn.RemoteAddr(),
 	)
 }
+
+func (connection *connection) newParser(logger log.Logger) parser.Parser {
+	p := parser.NewParser(
+		connection.parsedMessages,
+		connection.parserErrors,
+		connection.isStreamed,
+		logger,
+	)
+	p.SetContentLengthPolicy(connection.lengthPolicy)
+	p.SetStartLineHook(connection.startLineHook)
+	p.SetKeepAliveHook(connection.receiveCRLF)
+	return p
+}
@@ -1,29 +1,74 @@
 package transport
 
 import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/ghettovoice/gossip/log"
 	"github.com/ghettovoice/gossip/timing"
 )
 
+// ConnectionLimits configures the connections of connection oriented transports.
+type ConnectionLimits struct {
+	// IdleTimeout closes the connections neither sending nor receiving messages for the time, 1 hour by default.
+	IdleTimeout time.Duration
+	// MaxConnections is the number of the connections kept open, 0 means no limit.
+	// Beyond it the least recently used connection is closed.
+	MaxConnections int
+}
+
+// ConnectionInfo describes an open connection, see ConnectionManager.
+type ConnectionInfo struct {
+	// Address the connection is known by: the address it's opened to, or the remote address of the accepted ones.
+	Addr       string
+	LocalAddr  string
+	RemoteAddr string
+	// Inbound reports whether the connection was accepted on a listening point rather than opened by the transport.
+	Inbound bool
+	// Addresses the responses to the requests received over the connection are sent to, see base.ResponseAddr.
+	Aliases  []string
+	Created  time.Time
+	LastUsed time.Time
+}
+
+// ConnectionManager is implemented by connection oriented transports managing their open connections.
+type ConnectionManager interface {
+	// SetConnectionLimits applies the limits to the open connections and the ones opened later.
+	SetConnectionLimits(limits ConnectionLimits) error
+	// Connections returns the open connections, the most recently used first.
+	Connections() []ConnectionInfo
+}
+
 // Fields of connTable should only be modified by the dedicated goroutine called by Init().
 // All other callers should use connTable's associated public methods to access it.
 type connTable struct {
 	conns        map[string]*connWatcher
+	aliases      map[string]string // Response addresses to the addresses of the connections, see Alias.
+	limits       ConnectionLimits
 	connRequests chan *connRequest
 	updates      chan *connUpdate
-	expiries     chan string
-	stop         chan bool
-	stopped      bool
+	touches      chan *connUpdate
+	aliasUpdates chan *connUpdate
+	drops        chan *connUpdate
+	expiries     chan *connWatcher
+	limitUpdates chan ConnectionLimits
+	infoRequests chan chan []ConnectionInfo
+	stop         chan struct{} // Closed by Stop.
+	stopOnce     sync.Once
 }
 
 type connWatcher struct {
 	addr       string
 	conn       *connection
+	aliases    []string
+	created    time.Time
+	lastUsed   time.Time
 	timer      timing.Timer
 	expiryTime time.Time
-	expiry     chan<- string
+	expiry     chan<- *connWatcher
 	stop       chan bool
 }
 
@@ -31,10 +76,17 @@ type connWatcher struct {
 func (t *connTable) Init() {
 	log.Infof("init conntable %p", t)
 	t.conns = make(map[string]*connWatcher)
+	t.aliases = make(map[string]string)
+	t.limits = ConnectionLimits{IdleTimeout: c_SOCKET_EXPIRY}
 	t.connRequests = make(chan *connRequest)
 	t.updates = make(chan *connUpdate)
-	t.expiries = make(chan string)
-	t.stop = make(chan bool)
+	t.touches = make(chan *connUpdate)
+	t.aliasUpdates = make(chan *connUpdate)
+	t.drops = make(chan *connUpdate)
+	t.expiries = make(chan *connWatcher)
+	t.limitUpdates = make(chan ConnectionLimits)
+	t.infoRequests = make(chan chan []ConnectionInfo)
+	t.stop = make(chan struct{})
 	go t.manage()
 }
 
@@ -46,6 +98,12 @@ func (t *connTable) manage() {
 		select {
 		case request := <-t.connRequests:
 			watcher := t.conns[request.addr]
+			if request.response {
+				// Responses go over the connection the request was received on - RFC 3261 18.2.2.
+				if addr, ok := t.aliases[request.addr]; ok {
+					watcher = t.conns[addr]
+				}
+			}
 			if watcher != nil {
 				request.responseChan <- watcher.conn
 			} else {
@@ -53,26 +111,45 @@ func (t *connTable) manage() {
 			}
 		case update := <-t.updates:
 			t.handleUpdate(update)
-		case addr := <-t.expiries:
-			watcher := t.conns[addr]
-			if watcher.expiryTime.Before(time.Now()) {
-				log.Debugf("conntable %p notified that the watcher for address %s has expired. Remove it.", t, addr)
-				watcher.stop <- true
-				watcher.conn.Close()
-				delete(t.conns, addr)
+		case touch := <-t.touches:
+			if watcher := t.conns[touch.addr]; watcher != nil && watcher.conn == touch.conn {
+				watcher.Update(touch.conn, t.limits.IdleTimeout)
+			}
+		case update := <-t.aliasUpdates:
+			t.handleAlias(update)
+		case drop := <-t.drops:
+			watcher := t.conns[drop.addr]
+			if watcher != nil && watcher.conn == drop.conn {
+				log.Debugf("conntable %p notified that the connection for address %s is broken. Remove it.", t, drop.addr)
+				t.remove(watcher)
+			}
+		case watcher := <-t.expiries:
+			if t.conns[watcher.addr] != watcher {
+				// The watcher was removed while notifying of the expiry.
+				continue
+			}
+			if !watcher.expiryTime.After(timing.Now()) {
+				log.Debugf("conntable %p notified that the watcher for address %s has expired. Remove it.", t, watcher.addr)
+				t.remove(watcher)
 			} else {
 				// Due to a race condition, the socket has been updated since this expiry happened.
 				// Ignore the expiry since we already have a new socket for this address.
-				log.Warnf("ignored spurious expiry for address %s in conntable %p", t, addr)
+				log.Warnf("ignored spurious expiry for address %s in conntable %p", watcher.addr, t)
 			}
+		case limits := <-t.limitUpdates:
+			t.limits = limits
+			for _, watcher := range t.conns {
+				watcher.Reset(t.limits.IdleTimeout)
+			}
+			t.evict(nil)
+		case infos := <-t.infoRequests:
+			infos <- t.infos()
 		case <-t.stop:
-			log.Infof("conntable %p stopped")
-			t.stopped = true
+			log.Infof("conntable %p stopped", t)
 			for _, watcher := range t.conns {
-				watcher.stop <- true
-				watcher.conn.Close()
+				t.remove(watcher)
 			}
-			break
+			return
 		}
 	}
 }
@@ -81,12 +158,11 @@ func (t *connTable) manage() {
 // If it is a new connection, start the socket expiry timer.
 // If it is a known connection, restart the timer.
 func (t *connTable) Notify(addr string, conn *connection) {
-	if t.stopped {
+	select {
+	case t.updates <- &connUpdate{canonicalAddr(addr), conn}:
+	case <-t.stop:
 		log.Debugf("ignoring conn notification for address %s after table stop.", addr)
-		return
 	}
-
-	t.updates <- &connUpdate{addr, conn}
 }
 
 func (t *connTable) handleUpdate(update *connUpdate) {
@@ -97,70 +173,248 @@ func (t *connTable) handleUpdate(update *connUpdate) {
 		watcher = &connWatcher{
 			addr:       update.addr,
 			conn:       update.conn,
-			timer:      timing.NewTimer(c_SOCKET_EXPIRY),
-			expiryTime: timing.Now().Add(c_SOCKET_EXPIRY),
+			created:    timing.Now(),
+			timer:      timing.NewTimer(t.limits.IdleTimeout),
+			expiryTime: timing.Now().Add(t.limits.IdleTimeout),
 			expiry:     t.expiries,
 			stop:       make(chan bool),
 		}
 		t.conns[update.addr] = watcher
 		go watcher.loop()
+	} else if watcher.conn != update.conn {
+		// The connection is replaced, responses can't be sent over the new one.
+		t.unalias(watcher)
+		watcher.created = timing.Now()
+	}
+
+	watcher.Update(update.conn, t.limits.IdleTimeout)
+	t.evict(watcher)
+}
+
+// Touch restarts the expiry timer of the connection registered under the address, e.g. once it receives a message.
+// Unlike Notify it never registers the connection.
+func (t *connTable) Touch(addr string, conn *connection) {
+	select {
+	case t.touches <- &connUpdate{canonicalAddr(addr), conn}:
+	case <-t.stop:
+	}
+}
+
+// Alias registers the connection the request was received on for the address its responses are sent to.
+func (t *connTable) Alias(alias string, conn *connection) {
+	select {
+	case t.aliasUpdates <- &connUpdate{canonicalAddr(alias), conn}:
+	case <-t.stop:
+	}
+}
+
+func (t *connTable) handleAlias(update *connUpdate) {
+	watcher := t.conns[canonicalAddr(update.conn.addr)]
+	if watcher == nil || watcher.conn != update.conn || update.addr == watcher.addr {
+		return
+	}
+	if addr, ok := t.aliases[update.addr]; ok {
+		if addr == watcher.addr {
+			return
+		}
+		if previous := t.conns[addr]; previous != nil {
+			previous.aliases = removeString(previous.aliases, update.addr)
+		}
+	}
+	t.aliases[update.addr] = watcher.addr
+	watcher.aliases = append(watcher.aliases, update.addr)
+}
+
+// evict closes the least recently used connections beyond the limit, other than the one just used.
+func (t *connTable) evict(keep *connWatcher) {
+	for t.limits.MaxConnections > 0 && len(t.conns) > t.limits.MaxConnections {
+		var lru *connWatcher
+		for _, watcher := range t.conns {
+			if watcher != keep && (lru == nil || watcher.lastUsed.Before(lru.lastUsed)) {
+				lru = watcher
+			}
+		}
+		if lru == nil {
+			return
+		}
+		log.Debugf("conntable %p has more than %d connections, evict the least recently used one for address %s",
+			t, t.limits.MaxConnections, lru.addr)
+		t.remove(lru)
+	}
+}
+
+// remove stops the watcher, closes its connection and forgets both.
+func (t *connTable) remove(watcher *connWatcher) {
+	close(watcher.stop)
+	watcher.conn.Close()
+	t.unalias(watcher)
+	delete(t.conns, watcher.addr)
+}
+
+func (t *connTable) unalias(watcher *connWatcher) {
+	for _, alias := range watcher.aliases {
+		if t.aliases[alias] == watcher.addr {
+			delete(t.aliases, alias)
+		}
+	}
+	watcher.aliases = nil
+}
+
+func (t *connTable) infos() []ConnectionInfo {
+	infos := make([]ConnectionInfo, 0, len(t.conns))
+	for _, watcher := range t.conns {
+		info := ConnectionInfo{
+			Addr:     watcher.addr,
+			Inbound:  watcher.conn.inbound,
+			Aliases:  append([]string(nil), watcher.aliases...),
+			Created:  watcher.created,
+			LastUsed: watcher.lastUsed,
+		}
+		if addr := watcher.conn.baseConn.LocalAddr(); addr != nil {
+			info.LocalAddr = addr.String()
+		}
+		if addr := watcher.conn.baseConn.RemoteAddr(); addr != nil {
+			info.RemoteAddr = addr.String()
+		}
+		infos = append(infos, info)
 	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].LastUsed.After(infos[j].LastUsed)
+	})
+	return infos
+}
 
-	watcher.Update(update.conn)
+// Remove the broken connection from the table if it is still registered under the address.
+func (t *connTable) Drop(addr string, conn *connection) {
+	select {
+	case t.drops <- &connUpdate{canonicalAddr(addr), conn}:
+	case <-t.stop:
+	}
 }
 
 // Return an existing open socket for the given address, or nil if no such socket
 // exists.
 func (t *connTable) GetConn(addr string) *connection {
+	return t.getConn(addr, false)
+}
+
+// GetResponseConn returns the connection the request was received on if the response address is its alias,
+// otherwise the connection registered under the address like GetConn.
+func (t *connTable) GetResponseConn(addr string) *connection {
+	return t.getConn(addr, true)
+}
+
+func (t *connTable) getConn(addr string, response bool) *connection {
 	responseChan := make(chan *connection)
-	t.connRequests <- &connRequest{addr, responseChan}
+	select {
+	case t.connRequests <- &connRequest{canonicalAddr(addr), response, responseChan}:
+	case <-t.stop:
+		return nil
+	}
 	conn := <-responseChan
 
-	log.Debugf("query connection for address %s returns %p", conn)
+	log.Debugf("query connection for address %s returns %p", addr, conn)
 	return conn
 }
 
+// SetLimits applies the limits to the registered connections and the ones registered later.
+func (t *connTable) SetLimits(limits ConnectionLimits) error {
+	if limits.IdleTimeout < 0 || limits.MaxConnections < 0 {
+		return fmt.Errorf("invalid connection limits %+v", limits)
+	}
+	if limits.IdleTimeout == 0 {
+		limits.IdleTimeout = c_SOCKET_EXPIRY
+	}
+	select {
+	case t.limitUpdates <- limits:
+		return nil
+	case <-t.stop:
+		return fmt.Errorf("connection table is stopped")
+	}
+}
+
+// Infos returns the registered connections, the most recently used first.
+func (t *connTable) Infos() []ConnectionInfo {
+	infos := make(chan []ConnectionInfo)
+	select {
+	case t.infoRequests <- infos:
+	case <-t.stop:
+		return nil
+	}
+	return <-infos
+}
+
+// canonicalAddr formats IP addresses the way the connections report their remote addresses,
+// so the connections are found whatever the form of the address, e.g. [2001:DB8:0::1]:5060 as [2001:db8::1]:5060.
+func canonicalAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return net.JoinHostPort(ip.String(), port)
+	}
+	return addr
+}
+
+func removeString(values []string, value string) []string {
+	for i, v := range values {
+		if v == value {
+			return append(values[:i], values[i+1:]...)
+		}
+	}
+	return values
+}
+
 // Close all sockets and stop socket management.
 // The table cannot be restarted after Stop() has been called, and GetConn() will return nil.
 func (t *connTable) Stop() {
-	t.stop <- true
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
 }
 
-// Update the connection associated with a given connWatcher, and reset the
+// Update the connection associated with a given connWatcher, mark it used and reset the
 // timeout timer.
 // Must only be called from the connTable goroutine (and in particular, must
 // *not* be called from the connWatcher goroutine).
-func (watcher *connWatcher) Update(c *connection) {
-	watcher.expiryTime = timing.Now().Add(c_SOCKET_EXPIRY)
-	watcher.timer.Reset(c_SOCKET_EXPIRY)
+func (watcher *connWatcher) Update(c *connection, idleTimeout time.Duration) {
+	watcher.lastUsed = timing.Now()
 	watcher.conn = c
+	watcher.Reset(idleTimeout)
+}
+
+// Reset restarts the timeout timer to expire the idle timeout after the connection was last used.
+// Must only be called from the connTable goroutine.
+func (watcher *connWatcher) Reset(idleTimeout time.Duration) {
+	watcher.expiryTime = watcher.lastUsed.Add(idleTimeout)
+	remaining := watcher.expiryTime.Sub(timing.Now())
+	if remaining <= 0 {
+		remaining = time.Nanosecond
+	}
+	watcher.timer.Reset(remaining)
 }
 
 // connWatcher main loop. Waits for the connection to expire, and notifies the connTable
-// when it does.
+// when it does, until the table stops the watcher.
 func (watcher *connWatcher) loop() {
-	// We expect to close off connections explicitly, but let's be safe and clean up
-	// if we close unexpectedly.
-	defer func(c *connection) {
-		if c != nil {
-			c.Close()
-		}
-	}(watcher.conn)
-
 	for {
 		select {
 		case <-watcher.timer.C():
 			// Socket expiry timer has run out. Close the connection.
-			log.Debugf("socket %p (%s) inactive for too long; close it", watcher.conn, watcher.addr)
-			watcher.expiry <- watcher.addr
-
-		case stop := <-watcher.stop:
-			// We've received a termination signal; stop managing this connection.
-			if stop {
-				log.Infof("connection watcher for address %s got the kill signal. Stopping.", watcher.addr)
+			log.Debugf("socket for address %s inactive for too long; close it", watcher.addr)
+			select {
+			case watcher.expiry <- watcher:
+			case <-watcher.stop:
 				watcher.timer.Stop()
-				break
+				return
 			}
+
+		case <-watcher.stop:
+			// We've received a termination signal; stop managing this connection.
+			log.Debugf("connection watcher for address %s got the kill signal. Stopping.", watcher.addr)
+			watcher.timer.Stop()
+			return
 		}
 	}
 }
@@ -172,5 +426,6 @@ type connUpdate struct {
 
 type connRequest struct {
 	addr         string
+	response     bool
 	responseChan chan *connection
 }
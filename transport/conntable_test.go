@@ -130,18 +130,116 @@ func TestReuse2(t *testing.T) {
 	}
 }
 
+// Test that beyond the maximum number of connections the least recently used one is closed.
+func TestMaxConnections(t *testing.T) {
+	var table connTable
+	table.Init()
+	defer table.Stop()
+	table.SetLimits(ConnectionLimits{MaxConnections: 2})
+
+	// The queries wait until the table handles the updates before the time passes.
+	foo, bar, baz := makeTestConn(), makeTestConn(), makeTestConn()
+	table.Notify("foo", foo)
+	table.GetConn("foo")
+	timing.Elapse(time.Second)
+	table.Notify("bar", bar)
+	table.GetConn("bar")
+	timing.Elapse(time.Second)
+	// Receiving a message makes foo used more recently than bar.
+	table.Touch("foo", foo)
+	table.GetConn("foo")
+	timing.Elapse(time.Second)
+	table.Notify("baz", baz)
+
+	if table.GetConn("bar") != nil {
+		t.Errorf("[FAIL] expected the least recently used connection evicted")
+	}
+	if table.GetConn("foo") != foo || table.GetConn("baz") != baz {
+		t.Errorf("[FAIL] expected the recently used connections kept")
+	}
+	infos := table.Infos()
+	if len(infos) != 2 || infos[0].Addr != "baz" || infos[1].Addr != "foo" {
+		t.Errorf("[FAIL] expected connections baz and foo, got %+v", infos)
+	}
+
+	// Lowering the limit evicts the open connections.
+	table.SetLimits(ConnectionLimits{MaxConnections: 1})
+	if infos := table.Infos(); len(infos) != 1 || infos[0].Addr != "baz" {
+		t.Errorf("[FAIL] expected connection baz, got %+v", infos)
+	}
+}
+
+// Test that the connections expire after the configured idle timeout since they were last used.
+func TestIdleTimeout(t *testing.T) {
+	var table connTable
+	table.Init()
+	defer table.Stop()
+	if err := table.SetLimits(ConnectionLimits{IdleTimeout: -time.Second}); err == nil {
+		t.Errorf("[FAIL] expected negative idle timeout rejected")
+	}
+	table.SetLimits(ConnectionLimits{IdleTimeout: time.Minute})
+
+	conn := makeTestConn()
+	table.Notify("foo", conn)
+	table.GetConn("foo")
+	timing.Elapse(30 * time.Second)
+	table.Touch("foo", conn)
+	table.GetConn("foo") // Wait until the touch is handled.
+	timing.Elapse(45 * time.Second)
+	time.Sleep(10 * time.Millisecond)
+	if table.GetConn("foo") != conn {
+		t.Errorf("[FAIL] expected the used connection kept")
+	}
+
+	timing.Elapse(15 * time.Second)
+	if !testutils.Eventually(func() bool { return table.GetConn("foo") == nil }) {
+		t.Errorf("[FAIL] expected the idle connection expired")
+	}
+}
+
+// Test that the responses are sent over the connection the request was received on.
+func TestResponseAlias(t *testing.T) {
+	var table connTable
+	table.Init()
+	defer table.Stop()
+
+	conn := makeTestConn()
+	conn.addr = "192.0.2.1:40000"
+	conn.inbound = true
+	table.Notify(conn.addr, conn)
+	table.Alias("192.0.2.1:5060", conn)
+
+	if table.GetConn("192.0.2.1:5060") != nil {
+		t.Errorf("[FAIL] expected the alias not used for requests")
+	}
+	if table.GetResponseConn("192.0.2.1:5060") != conn || table.GetResponseConn("192.0.2.1:40000") != conn {
+		t.Errorf("[FAIL] expected the connection the request was received on used for responses")
+	}
+	if infos := table.Infos(); len(infos) != 1 || !infos[0].Inbound || len(infos[0].Aliases) != 1 || infos[0].Aliases[0] != "192.0.2.1:5060" {
+		t.Errorf("[FAIL] unexpected connections %+v", infos)
+	}
+
+	table.Drop(conn.addr, conn)
+	if table.GetResponseConn("192.0.2.1:5060") != nil {
+		t.Errorf("[FAIL] expected the alias removed with the connection")
+	}
+}
+
 // Construct a dummy connection object to use to populate the connTable for tests.
 func makeTestConn() *connection {
 	parsedMessages := make(chan base.SipMessage)
 	errors := make(chan error)
 	streamed := true
 	return &connection{
-		&testutils.DummyConn{},
-		true,
-		parser.NewParser(parsedMessages, errors, streamed, log.StandardLogger()),
-		parsedMessages,
-		errors,
-		make(chan base.SipMessage),
-		log.StandardLogger(),
+		baseConn:       &testutils.DummyConn{},
+		isStreamed:     true,
+		parser:         parser.NewParser(parsedMessages, errors, streamed, log.StandardLogger()),
+		parsedMessages: parsedMessages,
+		parserErrors:   errors,
+		output:         make(chan base.SipMessage),
+		log:            log.StandardLogger(),
+		lengthPolicy:   parser.ContentLengthIgnore,
+		pongs:          make(chan struct{}, 1),
+		done:           make(chan struct{}),
 	}
 }
@@ -0,0 +1,19 @@
+package transport
+
+// FlowFailure reports that a flow to the remote address was broken by the remote side or the network.
+// Users holding registrations over the flow should re-register over a new one - RFC 5626 4.4.
+type FlowFailure struct {
+	// Address of the remote side the flow was established with.
+	Addr string
+	// Error the flow failed with.
+	Err error
+
+	conn *connection
+}
+
+// FlowMonitor is implemented by transports that detect flow failures.
+type FlowMonitor interface {
+	// FlowFailures returns the channel flow failures are reported on,
+	// nil channel if the transport doesn't maintain flows.
+	FlowFailures() <-chan FlowFailure
+}
@@ -0,0 +1,103 @@
+package transport
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+// Test that the TCP transport reports the connection closed by the remote side as the flow failure.
+func TestTcpFlowFailure(t *testing.T) {
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to start TCP server: %s", err)
+	}
+	defer server.Close()
+
+	go func() {
+		conn, err := server.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	m, err := NewManager("tcp")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create transport manager: %s", err)
+	}
+	defer m.Stop()
+
+	monitor, ok := m.(FlowMonitor)
+	if !ok {
+		t.Fatalf("[FAIL] TCP transport manager does not implement FlowMonitor")
+	}
+
+	addr := server.Addr().String()
+	uri := base.SipUri{User: base.String{S: "alice"}, Host: "127.0.0.1", UriParams: base.NewParams(), Headers: base.NewParams()}
+	msg := base.NewRequest(base.OPTIONS, &uri, "SIP/2.0", []base.SipHeader{base.ContentLength(0)}, "", log.StandardLogger())
+	m.Send(addr, msg)
+
+	select {
+	case failure := <-monitor.FlowFailures():
+		if failure.Addr != addr {
+			t.Errorf("[FAIL] expected failure of flow to %s, got %s", addr, failure.Addr)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("[FAIL] flow failure was not reported")
+	}
+}
+
+// Test that the response goes over the TCP connection the request was received on rather than to Via sent-by - RFC 3261 18.2.2.
+func TestTcpResponseOverInboundConnection(t *testing.T) {
+	m, err := NewManager("tcp")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create transport manager: %s", err)
+	}
+	defer m.Stop()
+	if err := m.Listen("127.0.0.1:10874"); err != nil {
+		t.Fatalf("[FAIL] failed to listen: %s", err)
+	}
+	input := m.GetChannel()
+
+	client, err := net.Dial("tcp", "127.0.0.1:10874")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to connect: %s", err)
+	}
+	defer client.Close()
+	// Nothing listens on the sent-by port.
+	client.Write([]byte("OPTIONS sip:uas@127.0.0.1:10874;transport=tcp SIP/2.0\r\n" +
+		"Via: SIP/2.0/TCP 127.0.0.1:10875;branch=z9hG4bK776asdhds\r\n" +
+		"From: <sip:uac@example.com>;tag=1928301774\r\n" +
+		"To: <sip:uas@example.com>\r\n" +
+		"Call-Id: a84b4c76e66710\r\n" +
+		"CSeq: 1 OPTIONS\r\n" +
+		"Content-Length: 0\r\n\r\n"))
+
+	var req *base.Request
+	select {
+	case msg := <-input:
+		req = msg.(*base.Request)
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] request was not received")
+	}
+	hop, _ := req.ViaHop()
+	res := base.NewResponseFromRequest(req, 200, "OK", "")
+	if err := m.Send(base.ResponseAddr(hop), res); err != nil {
+		t.Fatalf("[FAIL] failed to send response: %s", err)
+	}
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if line, err := bufio.NewReader(client).ReadString('\n'); err != nil || strings.TrimSpace(line) != "SIP/2.0 200 OK" {
+		t.Errorf("[FAIL] expected 200 OK over the connection of the request, got %q: %v", line, err)
+	}
+
+	connections := m.(ConnectionManager).Connections()
+	if len(connections) != 1 || !connections[0].Inbound || connections[0].RemoteAddr != client.LocalAddr().String() {
+		t.Errorf("[FAIL] expected the inbound connection only, got %+v", connections)
+	}
+}
@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/ghettovoice/gossip/timing"
+)
+
+// Keep-alive ping and pong of connection oriented transports - RFC 5626 3.5.1.
+const (
+	c_KEEPALIVE_PING = "\r\n\r\n"
+	c_KEEPALIVE_PONG = "\r\n"
+)
+
+// The flow fails unless the pong arrives in time after the ping - RFC 5626 4.4.1.
+const c_KEEPALIVE_PONG_TIMEOUT time.Duration = 10 * time.Second
+
+// KeepAliver is implemented by connection oriented transports keeping their flows alive by CRLF pings - RFC 5626 4.4.1.
+// The pings received from the remote side are always answered with pongs.
+type KeepAliver interface {
+	// SetKeepAliveInterval enables the pings over the connections opened afterwards, 0 disables them, by default.
+	// The pings are sent randomly between 80% and 100% of the interval, the flows not answering them in 10 seconds
+	// are closed and reported as failed, see FlowMonitor.
+	SetKeepAliveInterval(interval time.Duration) error
+}
+
+// SetKeepAliveInterval implements KeepAliver if the underlying transport supports it.
+func (manager *manager) SetKeepAliveInterval(interval time.Duration) error {
+	keepAliver, ok := manager.transport.(KeepAliver)
+	if !ok {
+		return fmt.Errorf("transport %T does not support keep-alive pings", manager.transport)
+	}
+	return keepAliver.SetKeepAliveInterval(interval)
+}
+
+// SetKeepAliveInterval implements KeepAliver.
+func (tcp *Tcp) SetKeepAliveInterval(interval time.Duration) error {
+	if interval < 0 {
+		return fmt.Errorf("invalid keep-alive interval %s", interval)
+	}
+	tcp.keepAlive = interval
+	return nil
+}
+
+// receiveCRLF answers the pings received over the accepted connections and signals the pongs
+// received over the opened ones.
+func (connection *connection) receiveCRLF(count int) {
+	if !connection.inbound {
+		select {
+		case connection.pongs <- struct{}{}:
+		default:
+		}
+		return
+	}
+	if count%2 == 0 {
+		connection.Log().Debugf("connection %p received keep-alive ping, answering", connection)
+		if _, err := connection.baseConn.Write([]byte(c_KEEPALIVE_PONG)); err != nil {
+			connection.Log().Debugf("failed to answer keep-alive ping over connection %p: %s", connection, err)
+		}
+	}
+}
+
+// keepAlive pings the remote side until the connection is closed or fails to answer in time.
+func (connection *connection) keepAlive(interval time.Duration) {
+	for {
+		// The pings are spread to avoid the flows of the restarted clients pinging at once - RFC 5626 4.4.1.
+		jitter := time.Duration(rand.Int63n(int64(interval)/5 + 1))
+		select {
+		case <-timing.After(interval - jitter):
+		case <-connection.done:
+			return
+		}
+
+		// Pongs left from the previous ping don't answer this one.
+		select {
+		case <-connection.pongs:
+		default:
+		}
+		connection.Log().Debugf("connection %p sends keep-alive ping", connection)
+		if _, err := connection.baseConn.Write([]byte(c_KEEPALIVE_PING)); err != nil {
+			// The broken connection is reported by the read loop.
+			return
+		}
+
+		select {
+		case <-connection.pongs:
+		case <-timing.After(c_KEEPALIVE_PONG_TIMEOUT):
+			err := fmt.Errorf("no keep-alive pong in %s", c_KEEPALIVE_PONG_TIMEOUT)
+			connection.Log().Infof("connection %p to %s failed: %s", connection, connection.addr, err)
+			if connection.failures != nil {
+				connection.failures <- FlowFailure{Addr: connection.addr, Err: err, conn: connection}
+			}
+			return
+		case <-connection.done:
+			return
+		}
+	}
+}
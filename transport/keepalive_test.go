@@ -0,0 +1,132 @@
+package transport
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/timing"
+)
+
+// Test that the pings are answered with pongs and don't break the parsing of the following messages.
+func TestKeepAlivePong(t *testing.T) {
+	m, err := NewManager("tcp")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create transport manager: %s", err)
+	}
+	defer m.Stop()
+	if err := m.Listen("127.0.0.1:10876"); err != nil {
+		t.Fatalf("[FAIL] failed to listen: %s", err)
+	}
+	input := m.GetChannel()
+
+	client, err := net.Dial("tcp", "127.0.0.1:10876")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to connect: %s", err)
+	}
+	defer client.Close()
+
+	client.Write([]byte(c_KEEPALIVE_PING))
+	pong := make([]byte, 8)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := client.Read(pong); err != nil || string(pong[:n]) != c_KEEPALIVE_PONG {
+		t.Errorf("[FAIL] expected pong, got %q: %v", pong[:n], err)
+	}
+
+	client.Write([]byte(captureRequest))
+	select {
+	case msg := <-input:
+		if req, ok := msg.(*base.Request); !ok || req.Method != base.OPTIONS {
+			t.Errorf("[FAIL] expected OPTIONS, got %s", msg.Short())
+		}
+	case <-time.After(time.Second):
+		t.Errorf("[FAIL] request after the ping was not received")
+	}
+}
+
+// Test that the opened connections are pinged and fail once the remote side stops answering - RFC 5626 4.4.1.
+func TestKeepAlivePing(t *testing.T) {
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to start TCP server: %s", err)
+	}
+	defer server.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := server.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	m, err := NewManager("tcp")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create transport manager: %s", err)
+	}
+	defer m.Stop()
+	if err := m.(KeepAliver).SetKeepAliveInterval(30 * time.Second); err != nil {
+		t.Fatalf("[FAIL] failed to set keep-alive interval: %s", err)
+	}
+	uri := base.SipUri{User: base.String{S: "alice"}, Host: "127.0.0.1", UriParams: base.NewParams(), Headers: base.NewParams()}
+	m.Send(server.Addr().String(), base.NewRequest(base.OPTIONS, &uri, "SIP/2.0", []base.SipHeader{base.ContentLength(0)}, "", log.StandardLogger()))
+
+	var conn net.Conn
+	select {
+	case conn = <-accepted:
+		defer conn.Close()
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] connection was not opened")
+	}
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("[FAIL] failed to read request: %s", err)
+		}
+		if line == "\r\n" {
+			break
+		}
+	}
+
+	// expectPing lets the time pass until the ping arrives, the pinging goroutine may start its timer late.
+	expectPing := func() {
+		ping := make([]byte, len(c_KEEPALIVE_PING))
+		for attempt := 0; attempt < 10; attempt++ {
+			timing.Elapse(30 * time.Second)
+			conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+			if n, _ := reader.Read(ping); n > 0 {
+				if n < len(ping) {
+					reader.Read(ping[n:])
+				}
+				if string(ping) != c_KEEPALIVE_PING {
+					t.Fatalf("[FAIL] expected ping, got %q", ping)
+				}
+				return
+			}
+		}
+		t.Fatalf("[FAIL] ping was not sent")
+	}
+
+	expectPing()
+	conn.Write([]byte(c_KEEPALIVE_PONG))
+	// Let the pong arrive before the time passes beyond the pong timeout.
+	time.Sleep(50 * time.Millisecond)
+	expectPing()
+
+	// The second ping is not answered.
+	monitor := m.(FlowMonitor)
+	for attempt := 0; attempt < 10; attempt++ {
+		timing.Elapse(c_KEEPALIVE_PONG_TIMEOUT)
+		select {
+		case failure := <-monitor.FlowFailures():
+			if failure.Addr != server.Addr().String() {
+				t.Errorf("[FAIL] expected failure of flow to %s, got %s", server.Addr(), failure.Addr)
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	t.Errorf("[FAIL] flow not answering the ping was not reported failed")
+}
@@ -0,0 +1,36 @@
+package transport
+
+import (
+	"github.com/ghettovoice/gossip/parser"
+)
+
+// ContentLengthEnforcer is implemented by transports checking Content-Length of the received messages against their bodies.
+// Peers lying about the length break interop in subtle ways, e.g. trailing garbage passed as part of SDP.
+type ContentLengthEnforcer interface {
+	// SetContentLengthPolicy sets the policy, parser.ContentLengthIgnore by default.
+	// Should be called before the transport starts listening.
+	SetContentLengthPolicy(policy parser.ContentLengthPolicy)
+}
+
+// SetContentLengthPolicy implements ContentLengthEnforcer if the underlying transport supports it.
+func (manager *manager) SetContentLengthPolicy(policy parser.ContentLengthPolicy) {
+	if enforcer, ok := manager.transport.(ContentLengthEnforcer); ok {
+		enforcer.SetContentLengthPolicy(policy)
+	}
+}
+
+// SetContentLengthPolicy implements ContentLengthEnforcer, the datagrams are checked by the policy.
+func (udp *Udp) SetContentLengthPolicy(policy parser.ContentLengthPolicy) {
+	udp.lengthPolicy = policy
+}
+
+// SetContentLengthPolicy implements ContentLengthEnforcer.
+// Messages are framed by Content-Length on the connections, the policy applies to the messages without it.
+func (tcp *Tcp) SetContentLengthPolicy(policy parser.ContentLengthPolicy) {
+	tcp.lengthPolicy = policy
+}
+
+// SetContentLengthPolicy implements ContentLengthEnforcer, the policy applies to the messages sent to the transport.
+func (mem *Memory) SetContentLengthPolicy(policy parser.ContentLengthPolicy) {
+	mem.lengthPolicy = policy
+}
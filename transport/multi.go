@@ -0,0 +1,243 @@
+package transport
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ghettovoice/gossip/base"
+)
+
+// ProtocolSelector returns the network of the protocol the message is sent over to the address, e.g. TCP,
+// e.g. by RFC 3263 resolution of the destination. Empty network leaves the choice to the manager, see NewMultiManager.
+type ProtocolSelector func(addr string, msg base.SipMessage) string
+
+// MultiProtocol is implemented by the managers of several protocols, see NewMultiManager.
+type MultiProtocol interface {
+	// ListenOn opens the listening point of the protocol of the network, e.g. TLS, on the address.
+	ListenOn(network string, address string) error
+	// SetProtocolSelector sets the choice of the protocol of the sent messages, nil restores the default one.
+	SetProtocolSelector(selector ProtocolSelector)
+}
+
+// ReliabilityReporter is implemented by the managers whose reliability depends on the destination,
+// e.g. sending over both UDP and TCP, see NewMultiManager.
+type ReliabilityReporter interface {
+	// IsReliableTo reports whether the message sent to the address goes over a reliable protocol.
+	IsReliableTo(addr string, message base.SipMessage) bool
+}
+
+// multiProtocol is the Protocol sending and receiving over several protocols.
+type multiProtocol struct {
+	protocols map[string]Protocol // By network.
+	networks  []string            // In the order of creation, the first one is the default.
+	selector  ProtocolSelector
+	mtu       int             // Path MTU, see SetPathMTU.
+	fallbacks map[string]bool // Destinations the last large request fell back to UDP to, see sendLarge.
+	lock      sync.RWMutex
+}
+
+// NewMultiManager creates manager of the registered protocols by their names, e.g. udp, tcp, tls and ws,
+// see Register. The messages received over all of them are delivered on the one channel.
+// Listen opens the listening points of all the protocols on the address, ListenOn of a single one,
+// e.g. of TLS on another port. The protocol of the sent message is chosen by the selector, see MultiProtocol,
+// then by the transport of the top Via hop, then by the transport parameter of the Request-URI;
+// the first protocol is used otherwise. Requests too large for UDP are sent over TCP if it's among the protocols,
+// see PathMTUConfigurer.
+func NewMultiManager(names ...string) (Manager, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no transport types")
+	}
+	factories := make([]ProtocolFactory, 0, len(names))
+	for _, name := range names {
+		factory, ok := lookupProtocol(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown transport type '%s'", name)
+		}
+		factories = append(factories, factory)
+	}
+
+	return newManager(func(inputs chan base.SipMessage) (Protocol, error) {
+		multi := &multiProtocol{protocols: make(map[string]Protocol), fallbacks: make(map[string]bool)}
+		for _, factory := range factories {
+			protocol, err := factory(inputs)
+			if err == nil && multi.protocols[protocol.Network()] != nil {
+				protocol.Stop()
+				err = fmt.Errorf("duplicate transport %s", protocol.Network())
+			}
+			if err != nil {
+				multi.Stop()
+				return nil, err
+			}
+			multi.protocols[protocol.Network()] = protocol
+			multi.networks = append(multi.networks, protocol.Network())
+		}
+		return multi, nil
+	})
+}
+
+// Network returns the networks of the protocols separated by commas, e.g. UDP,TCP.
+func (multi *multiProtocol) Network() string {
+	return strings.Join(multi.networks, ",")
+}
+
+// IsReliable reports whether all the protocols are reliable, see IsReliableTo.
+func (multi *multiProtocol) IsReliable() bool {
+	for _, protocol := range multi.protocols {
+		if !protocol.IsReliable() {
+			return false
+		}
+	}
+	return true
+}
+
+// IsReliableTo implements ReliabilityReporter by the protocol the message is sent over,
+// requests too large for UDP are reliable unless the last one to the address fell back to UDP.
+func (multi *multiProtocol) IsReliableTo(addr string, message base.SipMessage) bool {
+	protocol, err := multi.protocol(addr, message)
+	if err != nil {
+		return false
+	}
+	if tcp, _, ok := multi.tooLargeForUdp(protocol, message); ok {
+		multi.lock.RLock()
+		defer multi.lock.RUnlock()
+		return tcp.IsReliable() && !multi.fallbacks[addr]
+	}
+	return protocol.IsReliable()
+}
+
+func (multi *multiProtocol) Listen(address string) error {
+	for _, network := range multi.networks {
+		if err := multi.protocols[network].Listen(address); err != nil {
+			return fmt.Errorf("failed to listen %s on %s: %s", network, address, err)
+		}
+	}
+	return nil
+}
+
+func (multi *multiProtocol) ListenOn(network string, address string) error {
+	protocol, ok := multi.protocols[strings.ToUpper(network)]
+	if !ok {
+		return fmt.Errorf("no transport %s", network)
+	}
+	return protocol.Listen(address)
+}
+
+func (multi *multiProtocol) SetProtocolSelector(selector ProtocolSelector) {
+	multi.lock.Lock()
+	defer multi.lock.Unlock()
+	multi.selector = selector
+}
+
+func (multi *multiProtocol) Send(addr string, message base.SipMessage) error {
+	protocol, err := multi.protocol(addr, message)
+	if err != nil {
+		return err
+	}
+	tcp, text, large := multi.tooLargeForUdp(protocol, message)
+	if large {
+		return multi.sendLarge(tcp, protocol, addr, message, text)
+	}
+	if _, ok := message.(*renderedMessage); !ok && text != "" {
+		// Don't serialize the request checked against the size limit once again.
+		message = &renderedMessage{SipMessage: message, text: text}
+	}
+	return protocol.Send(addr, message)
+}
+
+// protocol chooses the protocol the message is sent over, see NewMultiManager.
+func (multi *multiProtocol) protocol(addr string, message base.SipMessage) (Protocol, error) {
+	multi.lock.RLock()
+	selector := multi.selector
+	multi.lock.RUnlock()
+	if selector != nil {
+		if network := selector(addr, message); network != "" {
+			protocol, ok := multi.protocols[strings.ToUpper(network)]
+			if !ok {
+				return nil, fmt.Errorf("no transport %s to send %s", network, message.Short())
+			}
+			return protocol, nil
+		}
+	}
+
+	// Via transport is the one the message is sent over - RFC 3261 18.1.1, responses go back over it - RFC 3261 18.2.2.
+	if hop, err := message.ViaHop(); err == nil {
+		if protocol, ok := multi.protocols[strings.ToUpper(hop.Transport)]; ok {
+			return protocol, nil
+		}
+	}
+	if req, ok := message.(*base.Request); ok {
+		if uri, ok := req.Recipient.(*base.SipUri); ok && uri.UriParams != nil {
+			if _, ok := uri.UriParams.Get("transport"); ok {
+				if protocol, ok := multi.protocols[base.UriTransport(uri)]; ok {
+					return protocol, nil
+				}
+			}
+		}
+	}
+	return multi.protocols[multi.networks[0]], nil
+}
+
+func (multi *multiProtocol) Stop() {
+	for _, network := range multi.networks {
+		multi.protocols[network].Stop()
+	}
+}
+
+// Listening implements ListenerStatus, the manager is listening if any of the protocols is.
+func (multi *multiProtocol) Listening() bool {
+	for _, protocol := range multi.protocols {
+		if status, ok := protocol.(ListenerStatus); !ok || status.Listening() {
+			return true
+		}
+	}
+	return false
+}
+
+// DiscoverPublicAddr implements PublicAddrDiscoverer with the first protocol supporting it, e.g. UDP.
+func (multi *multiProtocol) DiscoverPublicAddr(stunServer string) (string, error) {
+	for _, network := range multi.networks {
+		if discoverer, ok := multi.protocols[network].(PublicAddrDiscoverer); ok {
+			return discoverer.DiscoverPublicAddr(stunServer)
+		}
+	}
+	return "", fmt.Errorf("transports %s do not support public address discovery", multi.Network())
+}
+
+// PublicAddr implements PublicAddrDiscoverer, returns the address discovered by the first protocol supporting it.
+func (multi *multiProtocol) PublicAddr() string {
+	for _, network := range multi.networks {
+		if discoverer, ok := multi.protocols[network].(PublicAddrDiscoverer); ok {
+			return discoverer.PublicAddr()
+		}
+	}
+	return ""
+}
+
+// SetConnectionLimits implements ConnectionManager, the limits are set on every connection oriented protocol.
+func (multi *multiProtocol) SetConnectionLimits(limits ConnectionLimits) error {
+	found := false
+	for _, network := range multi.networks {
+		if connections, ok := multi.protocols[network].(ConnectionManager); ok {
+			if err := connections.SetConnectionLimits(limits); err != nil {
+				return err
+			}
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("transports %s do not manage connections", multi.Network())
+	}
+	return nil
+}
+
+// Connections implements ConnectionManager, returns the connections of all the protocols.
+func (multi *multiProtocol) Connections() []ConnectionInfo {
+	var infos []ConnectionInfo
+	for _, network := range multi.networks {
+		if connections, ok := multi.protocols[network].(ConnectionManager); ok {
+			infos = append(infos, connections.Connections()...)
+		}
+	}
+	return infos
+}
@@ -0,0 +1,218 @@
+package transport
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+func multiTestRequest(transport string, sentBy string) *base.Request {
+	host, port := "127.0.0.1", uint16(5060)
+	uri := base.SipUri{User: base.String{S: "bob"}, Host: host, UriParams: base.NewParams(), Headers: base.NewParams()}
+	hop := base.NewViaHop(transport, sentBy, port, base.GenerateBranch())
+	callId := base.CallId("multi-" + transport)
+	return base.NewRequest(base.OPTIONS, &uri, "SIP/2.0", []base.SipHeader{
+		&base.ViaHeader{hop},
+		&callId,
+		&base.CSeq{SeqNo: 1, MethodName: base.OPTIONS},
+		base.ContentLength(0),
+	}, "", log.StandardLogger())
+}
+
+// Test that the multi-protocol manager receives over all its protocols into one channel
+// and sends over the protocol of Via transport.
+func TestMultiManager(t *testing.T) {
+	if _, err := NewMultiManager("udp", "sctp"); err == nil {
+		t.Errorf("[FAIL] expected unknown transport type rejected")
+	}
+	if _, err := NewMultiManager("tcp", "TCP"); err == nil {
+		t.Errorf("[FAIL] expected duplicate transport rejected")
+	}
+
+	addr := freeAddr(t)
+	m, err := NewMultiManager("udp", "tcp")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create multi-protocol manager: %s", err)
+	}
+	defer m.Stop()
+	if err := m.Listen(addr); err != nil {
+		t.Fatalf("[FAIL] failed to listen on %s: %s", addr, err)
+	}
+	if m.IsReliable() {
+		t.Errorf("[FAIL] expected manager with UDP unreliable")
+	}
+	reporter, ok := m.(ReliabilityReporter)
+	if !ok {
+		t.Fatalf("[FAIL] multi-protocol manager does not implement ReliabilityReporter")
+	}
+	if reporter.IsReliableTo(addr, multiTestRequest("UDP", "127.0.0.1")) {
+		t.Errorf("[FAIL] expected request over UDP unreliable")
+	}
+	if !reporter.IsReliableTo(addr, multiTestRequest("TCP", "127.0.0.1")) {
+		t.Errorf("[FAIL] expected request over TCP reliable")
+	}
+	input := m.GetChannel()
+
+	udpPeer := newListeningManager(t, "udp", freeAddr(t))
+	defer udpPeer.Stop()
+	tcpPeerAddr := freeAddr(t)
+	tcpPeer := newListeningManager(t, "tcp", tcpPeerAddr)
+	defer tcpPeer.Stop()
+
+	for transport, peer := range map[string]Manager{"UDP": udpPeer, "TCP": tcpPeer} {
+		if err := peer.Send(addr, multiTestRequest(transport, "127.0.0.1")); err != nil {
+			t.Fatalf("[FAIL] failed to send over %s: %s", transport, err)
+		}
+		msg := receive(t, input)
+		if hop, _ := msg.ViaHop(); hop.Transport != transport {
+			t.Errorf("[FAIL] expected request over %s, got %s", transport, msg.Short())
+		}
+	}
+
+	// The TCP peer receives over TCP only, so the request reaches it only if Via transport selects TCP.
+	if err := m.Send(tcpPeerAddr, multiTestRequest("TCP", "127.0.0.1")); err != nil {
+		t.Fatalf("[FAIL] failed to send over TCP: %s", err)
+	}
+	if msg := receive(t, tcpPeer.GetChannel()); msg == nil {
+		t.Errorf("[FAIL] expected request over TCP received")
+	}
+
+	multi, ok := m.(MultiProtocol)
+	if !ok {
+		t.Fatalf("[FAIL] multi-protocol manager does not implement MultiProtocol")
+	}
+	multi.SetProtocolSelector(func(addr string, msg base.SipMessage) string {
+		return "tcp"
+	})
+	if err := m.Send(tcpPeerAddr, multiTestRequest("UDP", "127.0.0.1")); err != nil {
+		t.Fatalf("[FAIL] failed to send over selected TCP: %s", err)
+	}
+	if msg := receive(t, tcpPeer.GetChannel()); msg == nil {
+		t.Errorf("[FAIL] expected request over selected TCP received")
+	}
+	if err := multi.ListenOn("tls", addr); err == nil {
+		t.Errorf("[FAIL] expected listening on missing TLS failed")
+	}
+}
+
+// Test that the request too large for UDP goes over TCP with TCP in Via - RFC 3261 18.1.1,
+// and falls back to UDP if TCP fails.
+func TestMultiManagerLargeRequest(t *testing.T) {
+	m, err := NewMultiManager("udp", "tcp")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create multi-protocol manager: %s", err)
+	}
+	defer m.Stop()
+	configurer, ok := m.(PathMTUConfigurer)
+	if !ok {
+		t.Fatalf("[FAIL] multi-protocol manager does not implement PathMTUConfigurer")
+	}
+	if err := configurer.SetPathMTU(100); err == nil {
+		t.Errorf("[FAIL] expected path MTU within the margin rejected")
+	}
+
+	peerAddr := freeAddr(t)
+	udpPeer := newListeningManager(t, "udp", peerAddr)
+	defer udpPeer.Stop()
+
+	// Nothing listens on TCP of the peer, so the request falls back to UDP.
+	large := multiTestRequest("UDP", "127.0.0.1")
+	large.SetBody(strings.Repeat("x", 1400))
+	if err := m.Send(peerAddr, large); err != nil {
+		t.Fatalf("[FAIL] failed to send large request: %s", err)
+	}
+	msg := receive(t, udpPeer.GetChannel())
+	if hop, _ := msg.ViaHop(); hop.Transport != "UDP" {
+		t.Errorf("[FAIL] expected request fallen back to UDP, got Via transport %s", hop.Transport)
+	}
+	reporter := m.(ReliabilityReporter)
+	if reporter.IsReliableTo(peerAddr, large) {
+		t.Errorf("[FAIL] expected large request fallen back to UDP unreliable")
+	}
+
+	tcpPeer := newListeningManager(t, "tcp", peerAddr)
+	defer tcpPeer.Stop()
+	if err := m.Send(peerAddr, large); err != nil {
+		t.Fatalf("[FAIL] failed to send large request: %s", err)
+	}
+	msg = receive(t, tcpPeer.GetChannel())
+	if hop, _ := msg.ViaHop(); hop.Transport != "TCP" {
+		t.Errorf("[FAIL] expected request over TCP, got Via transport %s", hop.Transport)
+	}
+	if hop, _ := large.ViaHop(); hop.Transport != "UDP" {
+		t.Errorf("[FAIL] expected the sent request unchanged, got Via transport %s", hop.Transport)
+	}
+	if !reporter.IsReliableTo(peerAddr, large) {
+		t.Errorf("[FAIL] expected large request switched to TCP reliable")
+	}
+
+	// With the path MTU known the limit is 200 bytes below it.
+	if err := configurer.SetPathMTU(9000); err != nil {
+		t.Fatalf("[FAIL] failed to set path MTU: %s", err)
+	}
+	if reporter.IsReliableTo(peerAddr, large) {
+		t.Errorf("[FAIL] expected request below path MTU unreliable")
+	}
+	if err := m.Send(peerAddr, large); err != nil {
+		t.Fatalf("[FAIL] failed to send request: %s", err)
+	}
+	msg = receive(t, udpPeer.GetChannel())
+	if hop, _ := msg.ViaHop(); hop.Transport != "UDP" {
+		t.Errorf("[FAIL] expected request below path MTU over UDP, got Via transport %s", hop.Transport)
+	}
+}
+
+// Test that the size of the request rendered with the quirks of the peer decides the switch to TCP,
+// and the reliability reported agrees with the protocol the request is sent over.
+func TestMultiManagerLargeRequestQuirks(t *testing.T) {
+	m, err := NewMultiManager("udp", "tcp")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create multi-protocol manager: %s", err)
+	}
+	defer m.Stop()
+
+	peerAddr := freeAddr(t)
+	registry := NewQuirksRegistry()
+	quirks := Quirks{CompactHeaders: true}
+	registry.Add(QuirksRule{Addr: peerAddr, Quirks: quirks})
+	m.(QuirksApplier).SetQuirks(registry)
+	reporter := m.(ReliabilityReporter)
+
+	udpPeer := newListeningManager(t, "udp", peerAddr)
+	defer udpPeer.Stop()
+	tcpPeer := newListeningManager(t, "tcp", peerAddr)
+	defer tcpPeer.Stop()
+
+	large := multiTestRequest("UDP", "127.0.0.1")
+	large.SetBody(strings.Repeat("x", 1400))
+	if !reporter.IsReliableTo(peerAddr, large) {
+		t.Errorf("[FAIL] expected large quirked request reliable")
+	}
+	if err := m.Send(peerAddr, large); err != nil {
+		t.Fatalf("[FAIL] failed to send large request: %s", err)
+	}
+	msg := receive(t, tcpPeer.GetChannel())
+	if hop, _ := msg.ViaHop(); hop.Transport != "TCP" {
+		t.Errorf("[FAIL] expected large quirked request over TCP, got Via transport %s", hop.Transport)
+	}
+
+	// Compact header names take the request below the limit.
+	small := multiTestRequest("UDP", "127.0.0.1")
+	small.SetBody(strings.Repeat("x", c_UDP_SIZE_LIMIT-len(small.String())+1))
+	if len(small.String()) <= c_UDP_SIZE_LIMIT || len(quirks.Format(small)) > c_UDP_SIZE_LIMIT {
+		t.Fatalf("[FAIL] expected request of %d bytes above the limit and of %d bytes quirked below it",
+			len(small.String()), len(quirks.Format(small)))
+	}
+	if reporter.IsReliableTo(peerAddr, small) {
+		t.Errorf("[FAIL] expected quirked request below the limit unreliable")
+	}
+	if err := m.Send(peerAddr, small); err != nil {
+		t.Fatalf("[FAIL] failed to send request: %s", err)
+	}
+	msg = receive(t, udpPeer.GetChannel())
+	if hop, _ := msg.ViaHop(); hop.Transport != "UDP" {
+		t.Errorf("[FAIL] expected quirked request below the limit over UDP, got Via transport %s", hop.Transport)
+	}
+}
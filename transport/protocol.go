@@ -0,0 +1,81 @@
+package transport
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ghettovoice/gossip/base"
+)
+
+// Protocol is the transport protocol of the manager, e.g. UDP or TCP.
+// The built-in protocols are registered by their names, third parties add their own, e.g. SCTP, QUIC
+// or test transports, by Register without modifying the package.
+type Protocol interface {
+	// Network returns the transport token of the protocol in Via headers, e.g. UDP or TLS.
+	Network() string
+	// IsReliable reports whether the protocol delivers the messages reliably, so the transactions don't retransmit them.
+	IsReliable() bool
+	// Listen opens the listening point on the address.
+	Listen(address string) error
+	// Send sends the message to the address, opening the connection if the protocol needs one.
+	Send(addr string, message base.SipMessage) error
+	// Stop closes the listening points and the connections.
+	Stop()
+}
+
+// ProtocolFactory creates the protocol delivering the received messages on the inputs channel.
+// The received requests should be stamped with their source like by the built-in protocols, see StampSource.
+type ProtocolFactory func(inputs chan base.SipMessage) (Protocol, error)
+
+// Protocols by lower case names, see Register.
+var (
+	protocols = map[string]ProtocolFactory{
+		"udp": func(inputs chan base.SipMessage) (Protocol, error) {
+			return NewUdp(inputs)
+		},
+		"tcp": func(inputs chan base.SipMessage) (Protocol, error) {
+			return NewTcp(inputs)
+		},
+		"tls": func(inputs chan base.SipMessage) (Protocol, error) {
+			return NewTls(inputs, nil)
+		},
+		"ws": func(inputs chan base.SipMessage) (Protocol, error) {
+			return NewWs(inputs)
+		},
+		"wss": func(inputs chan base.SipMessage) (Protocol, error) {
+			return NewWss(inputs, nil)
+		},
+		"memory": func(inputs chan base.SipMessage) (Protocol, error) {
+			return NewMemory(inputs)
+		},
+	}
+	protocolsLock sync.RWMutex
+)
+
+// Register makes the protocol created by the factory available to NewManager by the case-insensitive name.
+// It replaces the protocol registered by the name before, built-in ones included, e.g. to wrap them in tests.
+func Register(name string, factory ProtocolFactory) {
+	protocolsLock.Lock()
+	defer protocolsLock.Unlock()
+	protocols[strings.ToLower(name)] = factory
+}
+
+// Protocols returns the sorted names of the registered protocols.
+func Protocols() []string {
+	protocolsLock.RLock()
+	defer protocolsLock.RUnlock()
+	names := make([]string, 0, len(protocols))
+	for name := range protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupProtocol(name string) (ProtocolFactory, bool) {
+	protocolsLock.RLock()
+	defer protocolsLock.RUnlock()
+	factory, ok := protocols[strings.ToLower(name)]
+	return factory, ok
+}
@@ -0,0 +1,88 @@
+package transport
+
+import (
+	"testing"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+)
+
+// loopback is the protocol delivering the sent messages back to its own manager.
+type loopback struct {
+	inputs  chan base.SipMessage
+	stopped bool
+}
+
+func (lo *loopback) Network() string {
+	return "LOOP"
+}
+
+func (lo *loopback) IsReliable() bool {
+	return true
+}
+
+func (lo *loopback) Listen(address string) error {
+	return nil
+}
+
+func (lo *loopback) Send(addr string, message base.SipMessage) error {
+	go func() {
+		lo.inputs <- message
+	}()
+	return nil
+}
+
+func (lo *loopback) Stop() {
+	lo.stopped = true
+}
+
+// Test that the protocols added by Register are created by NewManager.
+func TestRegisterProtocol(t *testing.T) {
+	if _, err := NewManager("loop"); err == nil {
+		t.Fatalf("[FAIL] expected unknown transport type rejected")
+	}
+
+	var created *loopback
+	Register("LOOP", func(inputs chan base.SipMessage) (Protocol, error) {
+		created = &loopback{inputs: inputs}
+		return created, nil
+	})
+	defer func() {
+		protocolsLock.Lock()
+		delete(protocols, "loop")
+		protocolsLock.Unlock()
+	}()
+
+	found := false
+	for _, name := range Protocols() {
+		found = found || name == "loop"
+	}
+	if !found {
+		t.Errorf("[FAIL] expected loop among protocols %v", Protocols())
+	}
+
+	m, err := NewManager("loop")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create loop transport manager: %s", err)
+	}
+	if !m.IsReliable() {
+		t.Errorf("[FAIL] expected manager of reliable protocol reliable")
+	}
+
+	req, err := parser.ParseMessage([]byte(captureRequest), log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse request: %s", err)
+	}
+	if err := m.Send("peer", req); err != nil {
+		t.Fatalf("[FAIL] failed to send request: %s", err)
+	}
+	if msg := receive(t, m.GetChannel()); msg != req {
+		t.Errorf("[FAIL] expected request delivered back, got %v", msg)
+	}
+
+	m.Stop()
+	if !created.stopped {
+		t.Errorf("[FAIL] expected protocol stopped with the manager")
+	}
+}
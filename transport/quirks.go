@@ -0,0 +1,205 @@
+package transport
+
+import (
+	"bytes"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/ghettovoice/gossip/base"
+)
+
+// Characters of display names which may be written without quotes - RFC 3261 25.1, token and LWS.
+var tokenDisplayName = regexp.MustCompile(`^[A-Za-z0-9\-.!%*_+` + "`" + `'~]+( [A-Za-z0-9\-.!%*_+` + "`" + `'~]+)*$`)
+
+// Quirks adjust serialization of messages for peers with known parsing bugs.
+// The zero value changes nothing.
+type Quirks struct {
+	// Write compact forms of header names, e.g. 'v' instead of 'Via'.
+	CompactHeaders bool
+	// Names of headers written first, in this order; the rest keep their order.
+	HeaderOrder []string
+	// Write display names consisting of tokens without quotes.
+	UnquotedDisplayNames bool
+	// Strip 'rport' parameter from Via hops.
+	NoRport bool
+}
+
+// Format serializes the message the way the peer expects it.
+func (quirks *Quirks) Format(msg base.SipMessage) string {
+	var buffer bytes.Buffer
+	buffer.WriteString(msg.StartLine() + "\r\n")
+	for _, h := range quirks.order(msg.AllHeaders()) {
+		buffer.WriteString(quirks.formatHeader(h) + "\r\n")
+	}
+	buffer.WriteString("\r\n" + msg.Body())
+
+	return buffer.String()
+}
+
+func (quirks *Quirks) order(hdrs []base.SipHeader) []base.SipHeader {
+	if len(quirks.HeaderOrder) == 0 {
+		return hdrs
+	}
+
+	ordered := make([]base.SipHeader, 0, len(hdrs))
+	taken := make([]bool, len(hdrs))
+	for _, name := range quirks.HeaderOrder {
+		for idx, h := range hdrs {
+			if !taken[idx] && strings.EqualFold(h.Name(), name) {
+				ordered = append(ordered, h)
+				taken[idx] = true
+			}
+		}
+	}
+	for idx, h := range hdrs {
+		if !taken[idx] {
+			ordered = append(ordered, h)
+		}
+	}
+	return ordered
+}
+
+func (quirks *Quirks) formatHeader(h base.SipHeader) string {
+	var displayName base.MaybeString
+	switch header := h.(type) {
+	case *base.ViaHeader:
+		if quirks.NoRport {
+			h = stripRport(*header)
+		}
+	case base.ViaHeader:
+		if quirks.NoRport {
+			h = stripRport(header)
+		}
+	case *base.ToHeader:
+		displayName = header.DisplayName
+	case *base.FromHeader:
+		displayName = header.DisplayName
+	case *base.ContactHeader:
+		displayName = header.DisplayName
+	}
+
+	text := h.String()
+	if name, ok := displayName.(base.String); ok && quirks.UnquotedDisplayNames && tokenDisplayName.MatchString(name.S) {
+		text = strings.Replace(text, "\""+name.S+"\"", name.S, 1)
+	}
+	if quirks.CompactHeaders {
+		if idx := strings.Index(text, ":"); idx > 0 {
+			if compact, ok := base.CompactHeaderName(text[:idx]); ok {
+				text = compact + text[idx:]
+			}
+		}
+	}
+	return text
+}
+
+func stripRport(via base.ViaHeader) base.SipHeader {
+	dup := via.Copy().(*base.ViaHeader)
+	for _, hop := range *dup {
+		hop.Params.Remove("rport")
+	}
+	return dup
+}
+
+// QuirksApplier is implemented by transport managers adjusting the sent messages to the peer quirks.
+type QuirksApplier interface {
+	// SetQuirks sets the registry of peer quirks, nil disables them.
+	SetQuirks(registry *QuirksRegistry)
+}
+
+// QuirksRule selects the peers the quirks apply to.
+type QuirksRule struct {
+	// Address of the peer, host:port or host for any port; empty matches any address.
+	Addr string
+	// Pattern of the peer's User-Agent header; nil matches any agent.
+	UserAgent *regexp.Regexp
+	Quirks    Quirks
+}
+
+func (rule *QuirksRule) matches(addr string, userAgent string) bool {
+	if rule.Addr != "" && rule.Addr != addr {
+		if host, _, err := net.SplitHostPort(addr); err != nil || rule.Addr != host {
+			return false
+		}
+	}
+	return rule.UserAgent == nil || rule.UserAgent.MatchString(userAgent)
+}
+
+// QuirksRegistry keeps the quirks profiles of peers.
+// User agents of the peers are learned from the received requests, or set explicitly with Learn.
+type QuirksRegistry struct {
+	rules  []QuirksRule
+	agents map[string]string // User agents by the peer address.
+	lock   sync.RWMutex
+}
+
+func NewQuirksRegistry() *QuirksRegistry {
+	return &QuirksRegistry{agents: make(map[string]string)}
+}
+
+// Add appends the rule, rules are matched in the order they were added.
+func (registry *QuirksRegistry) Add(rule QuirksRule) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.rules = append(registry.rules, rule)
+}
+
+// Learn records the user agent of the peer at the address.
+func (registry *QuirksRegistry) Learn(addr string, userAgent string) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.agents[addr] = userAgent
+}
+
+// Observe learns the user agent of the sender of the request from its User-Agent header.
+// The sender is identified by the address responses are sent to by the top Via hop, see base.ResponseAddr.
+func (registry *QuirksRegistry) Observe(msg base.SipMessage) {
+	req, ok := msg.(*base.Request)
+	if !ok {
+		return
+	}
+	agents := req.Headers("User-Agent")
+	hop, err := req.ViaHop()
+	if len(agents) == 0 || err != nil {
+		return
+	}
+
+	registry.Learn(base.ResponseAddr(hop), headerValue(agents[0]))
+}
+
+// Lookup returns the quirks of the first rule matching the peer, nil if there is none.
+func (registry *QuirksRegistry) Lookup(addr string) *Quirks {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	userAgent := registry.agents[addr]
+	for idx := range registry.rules {
+		if registry.rules[idx].matches(addr, userAgent) {
+			return &registry.rules[idx].Quirks
+		}
+	}
+	return nil
+}
+
+func headerValue(h base.SipHeader) string {
+	if generic, ok := h.(*base.GenericHeader); ok {
+		return generic.Contents
+	}
+	text := h.String()
+	if idx := strings.Index(text, ":"); idx >= 0 {
+		return strings.TrimSpace(text[idx+1:])
+	}
+	return text
+}
+
+// renderedMessage is the message serialized once for sending, e.g. with quirks of the peer.
+type renderedMessage struct {
+	base.SipMessage
+	text   string
+	quirks *Quirks // Quirks the text is formatted with, nil if none.
+}
+
+func (msg *renderedMessage) String() string {
+	return msg.text
+}
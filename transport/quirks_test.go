@@ -0,0 +1,124 @@
+package transport
+
+import (
+	"bufio"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+)
+
+const quirksRequest = "INVITE sip:bob@example.com SIP/2.0\r\n" +
+	"Via: SIP/2.0/TCP 10.0.0.1:5070;branch=z9hG4bK776asdhds;rport\r\n" +
+	"From: \"Alice Liddell\" <sip:alice@example.com>;tag=1928301774\r\n" +
+	"To: \"Bob, Jr.\" <sip:bob@example.com>\r\n" +
+	"Call-Id: a84b4c76e66710\r\n" +
+	"CSeq: 314159 INVITE\r\n" +
+	"User-Agent: Acme Phone/1.2\r\n" +
+	"Content-Length: 0\r\n" +
+	"\r\n"
+
+func quirksMessage(t *testing.T) base.SipMessage {
+	msg, err := parser.ParseMessage([]byte(quirksRequest), log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse request: %s", err)
+	}
+	return msg
+}
+
+func TestQuirksFormat(t *testing.T) {
+	msg := quirksMessage(t)
+
+	if got := (&Quirks{}).Format(msg); got != msg.String() {
+		t.Errorf("[FAIL] zero quirks changed the message:\n%s", got)
+	}
+
+	quirks := Quirks{
+		CompactHeaders:       true,
+		HeaderOrder:          []string{"Call-Id", "CSeq"},
+		UnquotedDisplayNames: true,
+		NoRport:              true,
+	}
+	expected := "INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"i: a84b4c76e66710\r\n" +
+		"CSeq: 314159 INVITE\r\n" +
+		"v: SIP/2.0/TCP 10.0.0.1:5070;branch=z9hG4bK776asdhds\r\n" +
+		"f: Alice Liddell <sip:alice@example.com>;tag=1928301774\r\n" +
+		"t: \"Bob, Jr.\" <sip:bob@example.com>\r\n" +
+		"User-Agent: Acme Phone/1.2\r\n" +
+		"l: 0\r\n" +
+		"\r\n"
+	if got := quirks.Format(msg); got != expected {
+		t.Errorf("[FAIL] expected:\n%s\ngot:\n%s", expected, got)
+	}
+	if !strings.Contains(msg.String(), "rport") {
+		t.Errorf("[FAIL] formatting modified the original message")
+	}
+}
+
+func TestQuirksRegistryLookup(t *testing.T) {
+	registry := NewQuirksRegistry()
+	registry.Add(QuirksRule{Addr: "10.0.0.2", Quirks: Quirks{NoRport: true}})
+	registry.Add(QuirksRule{UserAgent: regexp.MustCompile(`^Acme Phone/1\.`), Quirks: Quirks{CompactHeaders: true}})
+
+	if quirks := registry.Lookup("10.0.0.2:5060"); quirks == nil || !quirks.NoRport {
+		t.Errorf("[FAIL] expected quirks of host rule for 10.0.0.2:5060, got %v", quirks)
+	}
+	if quirks := registry.Lookup("10.0.0.1:5070"); quirks != nil {
+		t.Errorf("[FAIL] expected no quirks of unknown peer, got %v", quirks)
+	}
+
+	registry.Observe(quirksMessage(t))
+	if quirks := registry.Lookup("10.0.0.1:5070"); quirks == nil || !quirks.CompactHeaders {
+		t.Errorf("[FAIL] expected quirks of user agent rule for the observed peer, got %v", quirks)
+	}
+}
+
+// Test that the quirks are applied to the messages sent by the transport manager.
+func TestQuirksAppliedOnSend(t *testing.T) {
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to start TCP server: %s", err)
+	}
+	defer server.Close()
+
+	lines := make(chan string, 1)
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reader.ReadString('\n')
+		line, _ := reader.ReadString('\n')
+		lines <- line
+	}()
+
+	m, err := NewManager("tcp")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create transport manager: %s", err)
+	}
+	defer m.Stop()
+
+	registry := NewQuirksRegistry()
+	registry.Add(QuirksRule{Addr: server.Addr().String(), Quirks: Quirks{CompactHeaders: true}})
+	m.(QuirksApplier).SetQuirks(registry)
+
+	if err := m.Send(server.Addr().String(), quirksMessage(t)); err != nil {
+		t.Fatalf("[FAIL] failed to send request: %s", err)
+	}
+	select {
+	case line := <-lines:
+		if !strings.HasPrefix(line, "v: ") {
+			t.Errorf("[FAIL] expected compact Via header, got '%s'", strings.TrimSpace(line))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] request was not received")
+	}
+}
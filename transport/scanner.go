@@ -0,0 +1,243 @@
+package transport
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/timing"
+)
+
+// ScanAction is what ScannerDetector does with the requests of the detected scanners.
+type ScanAction int
+
+const (
+	// ScanReport only reports the detections, the requests pass.
+	ScanReport ScanAction = iota
+	// ScanDrop drops the requests.
+	ScanDrop
+	// ScanBan drops the requests and bans their sources on the blacklist of the detector.
+	ScanBan
+)
+
+func (action ScanAction) String() string {
+	switch action {
+	case ScanReport:
+		return "Report"
+	case ScanDrop:
+		return "Drop"
+	case ScanBan:
+		return "Ban"
+	default:
+		return "Unknown"
+	}
+}
+
+// RegisterStorm is the name of the detections of REGISTER storms, see ScannerDetector.SetRegisterStormLimit.
+const RegisterStorm = "register-storm"
+
+// ScannerSignature recognizes the requests of a scanner by their contents.
+type ScannerSignature struct {
+	// Name identifies the signature in the detections.
+	Name string
+	// Match reports whether the request is sent by the scanner.
+	Match func(req *base.Request) bool
+}
+
+// UserAgentSignature recognizes the scanner by User-Agent containing any of the substrings, case insensitive.
+func UserAgentSignature(name string, substrings ...string) ScannerSignature {
+	lowered := make([]string, len(substrings))
+	for i, substring := range substrings {
+		lowered[i] = strings.ToLower(substring)
+	}
+	return ScannerSignature{
+		Name: name,
+		Match: func(req *base.Request) bool {
+			for _, hdr := range req.Headers("User-Agent") {
+				contents := strings.ToLower(hdr.String())
+				for _, substring := range lowered {
+					if strings.Contains(contents, substring) {
+						return true
+					}
+				}
+			}
+			return false
+		},
+	}
+}
+
+// DefaultScannerSignatures recognize the common SIP scanners: SIPVicious and its "friendly-scanner" disguise,
+// sipcli, sip-scan and a few other tools seen probing SIP services.
+var DefaultScannerSignatures = []ScannerSignature{
+	UserAgentSignature("sipvicious", "friendly-scanner", "sipvicious"),
+	UserAgentSignature("sipcli", "sipcli"),
+	UserAgentSignature("sip-scan", "sip-scan", "sipscan"),
+	UserAgentSignature("iwar", "iwar"),
+	UserAgentSignature("sundayddr", "sundayddr"),
+	UserAgentSignature("vaxsip", "vaxsipuseragent"),
+	UserAgentSignature("pplsip", "pplsip"),
+}
+
+// ScannerDetection describes the request recognized as the one of a scanner.
+type ScannerDetection struct {
+	// Signature is the name of the matched signature, or RegisterStorm.
+	Signature string
+	// Host is the source of the request, see SourceHost.
+	Host string
+	// Request is the detected request.
+	Request *base.Request
+	// Action is what the detector did with the request.
+	Action ScanAction
+}
+
+// ScannerDetector recognizes the requests of SIP scanners and other abusers by signatures and REGISTER storms,
+// and reports, drops or bans them depending on its action. It's applied by the transports on receipt,
+// see ScannerDetectorApplier.
+type ScannerDetector struct {
+	action     ScanAction
+	blacklist  *Blacklist
+	ban        time.Duration
+	signatures []ScannerSignature
+	handler    func(detection ScannerDetection)
+	stormLimit int
+	stormSpan  time.Duration
+	storms     map[string]*stormWindow // REGISTER counts by source.
+	swept      time.Time               // Moment the expired storm windows were last removed.
+	detected   uint64
+	lock       sync.Mutex
+}
+
+// stormWindow is the count of REGISTER requests of a source in the current window.
+type stormWindow struct {
+	start     time.Time
+	registers int
+}
+
+// ScannerDetectorApplier is implemented by transports checking the received requests with the scanner detector.
+type ScannerDetectorApplier interface {
+	// SetScannerDetector sets the detector checking the requests on receipt, nil disables it.
+	SetScannerDetector(detector *ScannerDetector)
+}
+
+// NewScannerDetector creates the detector with DefaultScannerSignatures taking the action on the detected requests.
+// ScanBan bans their sources on the blacklist for ban, 0 bans them until unbanned;
+// the blacklist should be applied to the same transports, see BlacklistApplier.
+func NewScannerDetector(action ScanAction, blacklist *Blacklist, ban time.Duration) *ScannerDetector {
+	return &ScannerDetector{
+		action:     action,
+		blacklist:  blacklist,
+		ban:        ban,
+		signatures: DefaultScannerSignatures,
+		storms:     make(map[string]*stormWindow),
+		swept:      timing.Now(),
+	}
+}
+
+// SetSignatures replaces the signatures, e.g. by DefaultScannerSignatures extended by the application ones.
+func (detector *ScannerDetector) SetSignatures(signatures []ScannerSignature) {
+	detector.lock.Lock()
+	defer detector.lock.Unlock()
+	detector.signatures = signatures
+}
+
+// SetRegisterStormLimit detects the sources sending more than limit REGISTER requests within the window,
+// e.g. password crackers and the scanners probing extensions. 0 disables the detection, the default.
+func (detector *ScannerDetector) SetRegisterStormLimit(limit int, window time.Duration) {
+	detector.lock.Lock()
+	defer detector.lock.Unlock()
+	detector.stormLimit = limit
+	detector.stormSpan = window
+}
+
+// SetHandler sets the handler of the detections, e.g. to log or count them by signature.
+// It's called on the receiving goroutine of the transport, so it should return fast.
+func (detector *ScannerDetector) SetHandler(handler func(detection ScannerDetection)) {
+	detector.lock.Lock()
+	defer detector.lock.Unlock()
+	detector.handler = handler
+}
+
+// Blocks inspects the received message, reports whether it's a request of a scanner to drop.
+// Responses are never inspected.
+func (detector *ScannerDetector) Blocks(msg base.SipMessage) bool {
+	req, ok := msg.(*base.Request)
+	if !ok {
+		return false
+	}
+	host := SourceHost(req)
+
+	detector.lock.Lock()
+	signature := detector.match(req, host)
+	if signature == "" {
+		detector.lock.Unlock()
+		return false
+	}
+	detector.detected++
+	detection := ScannerDetection{Signature: signature, Host: host, Request: req, Action: detector.action}
+	handler := detector.handler
+	detector.lock.Unlock()
+
+	req.Log().Infof("request %s of host %s matches scanner signature %s, action %s",
+		req.Short(), host, signature, detection.Action)
+	if detection.Action == ScanBan && detector.blacklist != nil && host != "" {
+		detector.blacklist.Ban(host, detector.ban)
+	}
+	if handler != nil {
+		handler(detection)
+	}
+	return detection.Action != ScanReport
+}
+
+// match returns the name of the signature the request matches, or RegisterStorm, empty string if none.
+func (detector *ScannerDetector) match(req *base.Request, host string) string {
+	for _, signature := range detector.signatures {
+		if signature.Match(req) {
+			return signature.Name
+		}
+	}
+	if req.Method != base.REGISTER || detector.stormLimit <= 0 || host == "" {
+		return ""
+	}
+
+	now := timing.Now()
+	detector.sweep(now)
+	window, ok := detector.storms[host]
+	if !ok || !now.Before(window.start.Add(detector.stormSpan)) {
+		window = &stormWindow{start: now}
+		detector.storms[host] = window
+	}
+	window.registers++
+	if window.registers > detector.stormLimit {
+		return RegisterStorm
+	}
+	return ""
+}
+
+// sweep removes the storm windows of the past, once a window.
+func (detector *ScannerDetector) sweep(now time.Time) {
+	if now.Sub(detector.swept) < detector.stormSpan {
+		return
+	}
+	for host, window := range detector.storms {
+		if !now.Before(window.start.Add(detector.stormSpan)) {
+			delete(detector.storms, host)
+		}
+	}
+	detector.swept = now
+}
+
+// Detected returns the number of the detected requests so far.
+func (detector *ScannerDetector) Detected() uint64 {
+	detector.lock.Lock()
+	defer detector.lock.Unlock()
+	return detector.detected
+}
+
+// SetScannerDetector implements ScannerDetectorApplier, the requests blocked by the detector are dropped
+// before the listeners.
+func (manager *manager) SetScannerDetector(detector *ScannerDetector) {
+	manager.notifier.listenerLock.Lock()
+	defer manager.notifier.listenerLock.Unlock()
+	manager.notifier.scanners = detector
+}
@@ -0,0 +1,113 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+	"github.com/ghettovoice/gossip/timing"
+)
+
+func scannerRequest(t *testing.T, method string, host string, userAgent string) base.SipMessage {
+	t.Helper()
+	msg, err := parser.ParseMessage([]byte(method+" sip:100@example.com SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP "+host+":5060;branch="+base.GenerateBranch()+"\r\n"+
+		"CSeq: 1 "+method+"\r\n"+
+		"User-Agent: "+userAgent+"\r\n"+
+		"Content-Length: 0\r\n\r\n"), log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse request: %s", err)
+	}
+	return msg
+}
+
+func TestScannerDetector(t *testing.T) {
+	blacklist := NewBlacklist()
+	detector := NewScannerDetector(ScanBan, blacklist, time.Minute)
+	detector.SetRegisterStormLimit(2, time.Second)
+	var detections []ScannerDetection
+	detector.SetHandler(func(detection ScannerDetection) {
+		detections = append(detections, detection)
+	})
+
+	for _, tc := range []struct {
+		msg       base.SipMessage
+		signature string
+	}{
+		{scannerRequest(t, "OPTIONS", "10.0.0.1", "friendly-scanner"), "sipvicious"},
+		{scannerRequest(t, "INVITE", "10.0.0.2", "SIPCLI/v1.8"), "sipcli"},
+		{scannerRequest(t, "OPTIONS", "10.0.0.3", "Linphone/3.6.1"), ""},
+		{scannerRequest(t, "REGISTER", "10.0.0.3", "Linphone/3.6.1"), ""},
+		{scannerRequest(t, "REGISTER", "10.0.0.3", "Linphone/3.6.1"), ""},
+		{scannerRequest(t, "REGISTER", "10.0.0.3", "Linphone/3.6.1"), RegisterStorm},
+		{scannerRequest(t, "REGISTER", "10.0.0.4", "Linphone/3.6.1"), ""},
+	} {
+		detected := len(detections)
+		blocked := detector.Blocks(tc.msg)
+		if blocked != (tc.signature != "") {
+			t.Errorf("[FAIL] %s of %s: expected blocked %t, got %t", tc.msg.Short(), SourceHost(tc.msg), tc.signature != "", blocked)
+		}
+		if tc.signature == "" {
+			if len(detections) != detected {
+				t.Errorf("[FAIL] %s of %s: unexpected detection %+v", tc.msg.Short(), SourceHost(tc.msg), detections[detected])
+			}
+			continue
+		}
+		if len(detections) != detected+1 {
+			t.Errorf("[FAIL] %s of %s: expected detection", tc.msg.Short(), SourceHost(tc.msg))
+			continue
+		}
+		if detection := detections[detected]; detection.Signature != tc.signature || detection.Host != SourceHost(tc.msg) ||
+			detection.Action != ScanBan {
+			t.Errorf("[FAIL] %s of %s: unexpected detection %+v", tc.msg.Short(), SourceHost(tc.msg), detection)
+		}
+	}
+	if hosts := blacklist.Hosts(); len(hosts) != 3 || hosts[0] != "10.0.0.1" || hosts[1] != "10.0.0.2" || hosts[2] != "10.0.0.3" {
+		t.Errorf("[FAIL] expected the scanners banned, got %v", hosts)
+	}
+	if detector.Detected() != 3 {
+		t.Errorf("[FAIL] expected 3 detections, got %d", detector.Detected())
+	}
+
+	// The storm window has passed.
+	timing.Elapse(time.Second)
+	if detector.Blocks(scannerRequest(t, "REGISTER", "10.0.0.4", "Linphone/3.6.1")) {
+		t.Errorf("[FAIL] unexpected REGISTER storm detected in new window")
+	}
+	// Responses are never inspected.
+	res := base.NewResponse("SIP/2.0", 200, "OK", []base.SipHeader{}, "", log.StandardLogger())
+	res.AddHeader(&base.GenericHeader{HeaderName: "User-Agent", Contents: "friendly-scanner"})
+	if detector.Blocks(res) {
+		t.Errorf("[FAIL] unexpected response blocked")
+	}
+}
+
+func TestScannerDetectorDropsRequests(t *testing.T) {
+	uas := newMemoryManager(t, "scanner-uas")
+	defer uas.Stop()
+	uac := newMemoryManager(t, "scanner-uac")
+	defer uac.Stop()
+	uas.(ScannerDetectorApplier).SetScannerDetector(NewScannerDetector(ScanDrop, nil, 0))
+	received := uas.GetChannel()
+
+	for _, userAgent := range []string{"sipvicious", "Linphone/3.6.1"} {
+		if err := uac.Send("scanner-uas", scannerRequest(t, "OPTIONS", "10.0.0.1", userAgent)); err != nil {
+			t.Fatalf("[FAIL] failed to send request: %s", err)
+		}
+	}
+	select {
+	case msg := <-received:
+		if hdrs := msg.Headers("User-Agent"); len(hdrs) != 1 || hdrs[0].String() != "User-Agent: Linphone/3.6.1" {
+			t.Errorf("[FAIL] expected only the request of Linphone received, got %v", hdrs)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("[FAIL] request was not received")
+	}
+	select {
+	case msg := <-received:
+		t.Errorf("[FAIL] unexpected request %s received", msg.Short())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,106 @@
+package transport
+
+import (
+	"fmt"
+
+	"github.com/ghettovoice/gossip/base"
+)
+
+// Requests over UDP larger than c_UDP_SIZE_LIMIT bytes if the path MTU is unknown, or within c_MTU_MARGIN bytes
+// of the path MTU, are sent over TCP instead - RFC 3261 18.1.1.
+const (
+	c_UDP_SIZE_LIMIT = 1300
+	c_MTU_MARGIN     = 200
+)
+
+// PathMTUConfigurer is implemented by the managers sending the requests too large for UDP over TCP, see NewMultiManager.
+type PathMTUConfigurer interface {
+	// SetPathMTU sets the path MTU the size of the requests sent over UDP is checked against, 0 means unknown.
+	SetPathMTU(mtu int) error
+}
+
+// SetPathMTU implements PathMTUConfigurer if the underlying transport supports it.
+func (manager *manager) SetPathMTU(mtu int) error {
+	configurer, ok := manager.transport.(PathMTUConfigurer)
+	if !ok {
+		return fmt.Errorf("transport %T does not switch large requests to TCP", manager.transport)
+	}
+	return configurer.SetPathMTU(mtu)
+}
+
+// SetPathMTU implements PathMTUConfigurer.
+func (multi *multiProtocol) SetPathMTU(mtu int) error {
+	if mtu < 0 || (mtu > 0 && mtu <= c_MTU_MARGIN) {
+		return fmt.Errorf("invalid path MTU %d", mtu)
+	}
+	multi.lock.Lock()
+	defer multi.lock.Unlock()
+	multi.mtu = mtu
+	return nil
+}
+
+// tooLargeForUdp reports whether the request chosen to go over UDP is sent over TCP instead - RFC 3261 18.1.1,
+// returns the TCP protocol then. The text of the request is returned if it was serialized for the check.
+// The size is of the text sent, e.g. rendered with the quirks of the peer.
+func (multi *multiProtocol) tooLargeForUdp(protocol Protocol, message base.SipMessage) (Protocol, string, bool) {
+	if _, _, ok := sentRequest(message); !ok || protocol.Network() != "UDP" {
+		return nil, "", false
+	}
+	tcp, ok := multi.protocols["TCP"]
+	if !ok {
+		return nil, "", false
+	}
+	multi.lock.RLock()
+	limit := c_UDP_SIZE_LIMIT
+	if multi.mtu > 0 {
+		limit = multi.mtu - c_MTU_MARGIN
+	}
+	multi.lock.RUnlock()
+	text := message.String()
+	return tcp, text, len(text) > limit
+}
+
+// sendLarge sends the copy of the request too large for UDP over TCP, with TCP transport in its top Via hop,
+// so the request itself, e.g. kept by its transaction, is not changed. The copy is rendered with the quirks
+// of the request, if any. If TCP fails the text of the request is sent over UDP - RFC 3261 18.1.1,
+// and the destination is reported unreliable for large requests, see IsReliableTo,
+// until a large request reaches it over TCP again.
+func (multi *multiProtocol) sendLarge(tcp Protocol, udp Protocol, addr string, message base.SipMessage, text string) error {
+	req, quirks, _ := sentRequest(message)
+	rendered := &renderedMessage{SipMessage: req, text: text, quirks: quirks}
+	large := req.Copy()
+	hop, err := large.ViaHop()
+	if err != nil {
+		return udp.Send(addr, rendered)
+	}
+	hop.Transport = tcp.Network()
+	if quirks != nil {
+		err = tcp.Send(addr, &renderedMessage{SipMessage: large, text: quirks.Format(large), quirks: quirks})
+	} else {
+		err = tcp.Send(addr, large)
+	}
+
+	multi.lock.Lock()
+	if err != nil {
+		multi.fallbacks[addr] = true
+	} else {
+		delete(multi.fallbacks, addr)
+	}
+	multi.lock.Unlock()
+
+	if err != nil {
+		req.Log().Warnf("failed to send large request %s over TCP, falling back to UDP: %s", req.Short(), err)
+		return udp.Send(addr, rendered)
+	}
+	return nil
+}
+
+// sentRequest returns the request of the sent message, rendered or not, and the quirks it was rendered with.
+func sentRequest(message base.SipMessage) (*base.Request, *Quirks, bool) {
+	if rendered, ok := message.(*renderedMessage); ok {
+		req, ok := rendered.SipMessage.(*base.Request)
+		return req, rendered.quirks, ok
+	}
+	req, ok := message.(*base.Request)
+	return req, nil, ok
+}
@@ -0,0 +1,118 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/ghettovoice/gossip/log"
+)
+
+// errUnsupportedOption is returned by the platform implementations of the socket options they don't support.
+var errUnsupportedOption = errors.New("socket option is not supported on this platform")
+
+// SocketOptions are the options of the sockets opened by the transports.
+// The options the platform doesn't support are logged and ignored, so the same configuration works everywhere.
+type SocketOptions struct {
+	// DSCP marks the sent packets with the differentiated services code point, 0 to 63 - RFC 2474,
+	// e.g. 46 (EF) or 24 (CS3) commonly used for signaling. 0 leaves the default marking.
+	DSCP int
+	// ReusePort lets several sockets, e.g. of several processes, listen on the same address with SO_REUSEPORT,
+	// the kernel balances the received datagrams and connections between them.
+	ReusePort bool
+	// KeepAlive is the interval of TCP keep-alive probes of the connections, 0 uses the default of the platform,
+	// negative disables them. Unlike CRLF keep-alives, see KeepAliver, they aren't seen by the remote SIP stack.
+	KeepAlive time.Duration
+}
+
+// SocketConfigurer is implemented by transports applying SocketOptions to their sockets.
+type SocketConfigurer interface {
+	// SetSocketOptions applies the options to the sockets opened afterwards, so it should be called before Listen.
+	SetSocketOptions(opts SocketOptions) error
+}
+
+// SetSocketOptions implements SocketConfigurer if the underlying transport supports it.
+func (manager *manager) SetSocketOptions(opts SocketOptions) error {
+	configurer, ok := manager.transport.(SocketConfigurer)
+	if !ok {
+		return fmt.Errorf("transport %T does not support socket options", manager.transport)
+	}
+	return configurer.SetSocketOptions(opts)
+}
+
+// SetSocketOptions implements SocketConfigurer.
+func (udp *Udp) SetSocketOptions(opts SocketOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	udp.sockets = opts
+	return nil
+}
+
+// SetSocketOptions implements SocketConfigurer.
+func (tcp *Tcp) SetSocketOptions(opts SocketOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	tcp.sockets = opts
+	return nil
+}
+
+func (opts SocketOptions) validate() error {
+	if opts.DSCP < 0 || opts.DSCP > 63 {
+		return fmt.Errorf("invalid DSCP %d, must be 0 to 63", opts.DSCP)
+	}
+	return nil
+}
+
+// listen opens the listening socket of the stream network, e.g. tcp, with the options.
+func (opts SocketOptions) listen(network, address string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: opts.control, KeepAlive: opts.KeepAlive}
+	return lc.Listen(context.Background(), network, address)
+}
+
+// listenPacket opens the socket of the datagram network, e.g. udp, with the options.
+func (opts SocketOptions) listenPacket(network, address string) (net.PacketConn, error) {
+	lc := net.ListenConfig{Control: opts.control}
+	return lc.ListenPacket(context.Background(), network, address)
+}
+
+// dialer returns the dialer opening the sockets with the options, 0 timeout waits as long as the platform does.
+func (opts SocketOptions) dialer(timeout time.Duration) *net.Dialer {
+	// Reusing the port only matters to the listening sockets.
+	dialOpts := opts
+	dialOpts.ReusePort = false
+	return &net.Dialer{Timeout: timeout, KeepAlive: opts.KeepAlive, Control: dialOpts.control}
+}
+
+// control sets the options on the socket before it's bound, see net.ListenConfig.Control.
+func (opts SocketOptions) control(network, address string, c syscall.RawConn) error {
+	if opts.DSCP == 0 && !opts.ReusePort {
+		return nil
+	}
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if opts.DSCP != 0 {
+			if err := setDSCP(fd, network, opts.DSCP); errors.Is(err, errUnsupportedOption) {
+				log.Warnf("DSCP of %s socket on %s ignored: %s", network, address, err)
+			} else if err != nil {
+				sockErr = fmt.Errorf("failed to set DSCP of %s socket on %s: %s", network, address, err)
+				return
+			}
+		}
+		if opts.ReusePort {
+			if err := setReusePort(fd); errors.Is(err, errUnsupportedOption) {
+				log.Warnf("SO_REUSEPORT of %s socket on %s ignored: %s", network, address, err)
+			} else if err != nil {
+				sockErr = fmt.Errorf("failed to set SO_REUSEPORT of %s socket on %s: %s", network, address, err)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,6 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+
+package transport
+
+// SO_REUSEPORT of Linux on MIPS, the syscall package doesn't define it.
+const soReusePort = 0x200
@@ -0,0 +1,50 @@
+package transport
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/ghettovoice/gossip/base"
+)
+
+func TestSocketOptions(t *testing.T) {
+	if err := new(Udp).SetSocketOptions(SocketOptions{DSCP: 64}); err == nil {
+		t.Errorf("[FAIL] expected DSCP 64 rejected")
+	}
+
+	// Both transports listen on the same address with SO_REUSEPORT.
+	opts := SocketOptions{DSCP: 46, ReusePort: true}
+	var udps []*Udp
+	for i := 0; i < 2; i++ {
+		udp, _ := NewUdp(make(chan base.SipMessage))
+		if err := udp.SetSocketOptions(opts); err != nil {
+			t.Fatalf("[FAIL] failed to set socket options: %s", err)
+		}
+		if err := udp.Listen("127.0.0.1:10878"); err != nil {
+			t.Fatalf("[FAIL] failed to listen with SO_REUSEPORT: %s", err)
+		}
+		defer udp.Stop()
+		udps = append(udps, udp)
+	}
+
+	tcp, _ := NewTcp(make(chan base.SipMessage))
+	defer tcp.Stop()
+	tcp.SetSocketOptions(opts)
+	lp, err := tcp.listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to listen: %s", err)
+	}
+	defer lp.Close()
+
+	for _, conn := range []syscall.Conn{udps[0].listeningPoints[0], lp.(*net.TCPListener)} {
+		raw, _ := conn.SyscallConn()
+		var tos int
+		raw.Control(func(fd uintptr) {
+			tos, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS)
+		})
+		if err != nil || tos != 46<<2 {
+			t.Errorf("[FAIL] expected TOS %d of %T, got %d: %v", 46<<2, conn, tos, err)
+		}
+	}
+}
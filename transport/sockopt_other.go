@@ -0,0 +1,14 @@
+//go:build !unix
+
+package transport
+
+// Windows marks the packets only by the QoS policies and the qWAVE API, and has no SO_REUSEPORT,
+// so the options are ignored there like on the other platforms without Berkeley sockets.
+
+func setDSCP(fd uintptr, network string, dscp int) error {
+	return errUnsupportedOption
+}
+
+func setReusePort(fd uintptr) error {
+	return errUnsupportedOption
+}
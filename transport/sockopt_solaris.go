@@ -0,0 +1,4 @@
+package transport
+
+// SO_REUSEPORT is not available on Solaris and illumos, see setReusePort.
+const soReusePort = 0
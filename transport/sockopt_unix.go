@@ -0,0 +1,25 @@
+//go:build unix
+
+package transport
+
+import (
+	"strings"
+	"syscall"
+)
+
+// setDSCP sets the DSCP in the traffic class of IPv6 sockets and the TOS of IPv4 ones, the lower two bits
+// belong to ECN - RFC 3168 5.
+func setDSCP(fd uintptr, network string, dscp int) error {
+	if strings.HasSuffix(network, "6") {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, dscp<<2)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, dscp<<2)
+}
+
+// setReusePort sets SO_REUSEPORT, its value differs between the platforms, 0 where it's not available.
+func setReusePort(fd uintptr) error {
+	if soReusePort == 0 {
+		return errUnsupportedOption
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+}
@@ -0,0 +1,37 @@
+package transport
+
+import (
+	"github.com/ghettovoice/gossip/parser"
+)
+
+// StartLineInspector is implemented by transports inspecting the received messages by their start lines,
+// before the rest of them is read and parsed, see parser.StartLineHook.
+// Defensive services use it to drop the requests of scanners early, e.g. OPTIONS probes of sipvicious.
+type StartLineInspector interface {
+	// SetStartLineHook sets the hook, nil disables it. The messages rejected by the hook are dropped.
+	// Should be called before the transport starts listening.
+	SetStartLineHook(hook parser.StartLineHook)
+}
+
+// SetStartLineHook implements StartLineInspector if the underlying transport supports it.
+func (manager *manager) SetStartLineHook(hook parser.StartLineHook) {
+	if inspector, ok := manager.transport.(StartLineInspector); ok {
+		inspector.SetStartLineHook(hook)
+	}
+}
+
+// SetStartLineHook implements StartLineInspector, the rejected datagrams are dropped without parsing their headers.
+func (udp *Udp) SetStartLineHook(hook parser.StartLineHook) {
+	udp.startLineHook = hook
+}
+
+// SetStartLineHook implements StartLineInspector.
+// The rejected message breaks the framing of the connection, its parser is restarted like on a malformed message.
+func (tcp *Tcp) SetStartLineHook(hook parser.StartLineHook) {
+	tcp.startLineHook = hook
+}
+
+// SetStartLineHook implements StartLineInspector, the hook applies to the messages sent to the transport.
+func (mem *Memory) SetStartLineHook(hook parser.StartLineHook) {
+	mem.startLineHook = hook
+}
@@ -0,0 +1,294 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/timing"
+)
+
+// Minimal STUN client (RFC 5389) used to discover the public address of a UDP socket behind NAT.
+// Only the Binding method without authentication is supported.
+
+const (
+	c_STUN_HEADER_SIZE  = 20
+	c_STUN_MAGIC_COOKIE = 0x2112A442
+	// Initial retransmission timeout, doubled on every retry - RFC 5389 7.2.1.
+	c_STUN_RTO     = 500 * time.Millisecond
+	c_STUN_RETRIES = 4
+
+	stunBindingRequest  uint16 = 0x0001
+	stunBindingResponse uint16 = 0x0101
+	stunBindingError    uint16 = 0x0111
+
+	stunAttrMappedAddress    uint16 = 0x0001
+	stunAttrXorMappedAddress uint16 = 0x0020
+
+	stunFamilyIPv4 = 0x01
+	stunFamilyIPv6 = 0x02
+)
+
+type stunTxId [12]byte
+
+// PublicAddrDiscoverer is implemented by transports that can learn their public address.
+type PublicAddrDiscoverer interface {
+	// DiscoverPublicAddr sends a STUN Binding request to the server and
+	// returns the public host:port of the listening socket as seen by the server.
+	DiscoverPublicAddr(stunServer string) (string, error)
+	// PublicAddr returns the last discovered public address, or empty string.
+	PublicAddr() string
+}
+
+// PublicAddrAdvertiser is implemented by transports advertising the discovered public address in the sent requests.
+type PublicAddrAdvertiser interface {
+	// SetAdvertisePublicAddr enables replacing the sent-by of the top Via hop of the sent requests with the public address
+	// discovered by PublicAddrDiscoverer, disabled by default. Contact URIs with the same host and port as the replaced
+	// sent-by are updated too, so that the requests and the responses from outside of NAT reach us.
+	SetAdvertisePublicAddr(enabled bool)
+}
+
+// advertisePublicAddr replaces the local address in the top Via hop and the Contact headers of the request
+// with the public one.
+func advertisePublicAddr(msg base.SipMessage, publicAddr string) {
+	if _, ok := msg.(*base.Request); !ok || publicAddr == "" {
+		return
+	}
+	host, portStr, err := net.SplitHostPort(publicAddr)
+	if err != nil {
+		return
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return
+	}
+	hop, err := msg.ViaHop()
+	if err != nil {
+		return
+	}
+
+	localHost, localPort := hop.Host, base.DefaultPort(hop.Transport)
+	if hop.Port != nil {
+		localPort = *hop.Port
+	}
+	publicPort := uint16(port)
+	hop.Host = host
+	hop.Port = &publicPort
+
+	for _, contact := range msg.Contacts() {
+		uri, ok := contact.Address.(*base.SipUri)
+		if !ok {
+			continue
+		}
+		contactPort := base.DefaultPort(hop.Transport)
+		if uri.Port != nil {
+			contactPort = *uri.Port
+		}
+		if uri.Host != localHost || contactPort != localPort {
+			continue
+		}
+		uri.Host = host
+		uri.Port = &publicPort
+	}
+}
+
+// stunClient matches STUN responses received on a listening socket to pending Binding requests.
+type stunClient struct {
+	pending map[stunTxId]chan []byte
+	lock    sync.Mutex
+}
+
+// isStunMessage checks whether the packet looks like a STUN message rather than a SIP one - RFC 5389 6.
+func isStunMessage(pkt []byte) bool {
+	return len(pkt) >= c_STUN_HEADER_SIZE &&
+		pkt[0]&0xC0 == 0 &&
+		binary.BigEndian.Uint32(pkt[4:8]) == c_STUN_MAGIC_COOKIE
+}
+
+func newStunBindingRequest() (stunTxId, []byte, error) {
+	var id stunTxId
+	if _, err := rand.Read(id[:]); err != nil {
+		return id, nil, err
+	}
+
+	pkt := make([]byte, c_STUN_HEADER_SIZE)
+	binary.BigEndian.PutUint16(pkt[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint16(pkt[2:4], 0)
+	binary.BigEndian.PutUint32(pkt[4:8], c_STUN_MAGIC_COOKIE)
+	copy(pkt[8:20], id[:])
+
+	return id, pkt, nil
+}
+
+func stunMessageTxId(pkt []byte) stunTxId {
+	var id stunTxId
+	copy(id[:], pkt[8:20])
+	return id
+}
+
+// parseStunBindingResponse extracts the mapped address from a Binding success response.
+// XOR-MAPPED-ADDRESS is preferred over MAPPED-ADDRESS.
+func parseStunBindingResponse(pkt []byte) (*net.UDPAddr, error) {
+	if !isStunMessage(pkt) {
+		return nil, fmt.Errorf("not a STUN message")
+	}
+
+	msgType := binary.BigEndian.Uint16(pkt[0:2])
+	switch msgType {
+	case stunBindingResponse:
+	case stunBindingError:
+		return nil, fmt.Errorf("STUN server responded with Binding error")
+	default:
+		return nil, fmt.Errorf("unexpected STUN message type 0x%04x", msgType)
+	}
+
+	length := int(binary.BigEndian.Uint16(pkt[2:4]))
+	if len(pkt) < c_STUN_HEADER_SIZE+length {
+		return nil, fmt.Errorf("truncated STUN message")
+	}
+
+	var mapped *net.UDPAddr
+	attrs := pkt[c_STUN_HEADER_SIZE : c_STUN_HEADER_SIZE+length]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if len(attrs) < 4+attrLen {
+			return nil, fmt.Errorf("truncated STUN attribute 0x%04x", attrType)
+		}
+		value := attrs[4 : 4+attrLen]
+
+		switch attrType {
+		case stunAttrXorMappedAddress:
+			addr, err := parseStunAddress(value, pkt[4:20])
+			if err != nil {
+				return nil, err
+			}
+			return addr, nil
+		case stunAttrMappedAddress:
+			addr, err := parseStunAddress(value, nil)
+			if err != nil {
+				return nil, err
+			}
+			mapped = addr
+		}
+
+		// Attributes are padded to a multiple of 4 bytes.
+		next := 4 + (attrLen+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+
+	if mapped == nil {
+		return nil, fmt.Errorf("no mapped address in STUN Binding response")
+	}
+	return mapped, nil
+}
+
+// parseStunAddress decodes (XOR-)MAPPED-ADDRESS value.
+// xorKey is the magic cookie followed by the transaction ID, or nil for a plain MAPPED-ADDRESS.
+func parseStunAddress(value []byte, xorKey []byte) (*net.UDPAddr, error) {
+	if len(value) < 4 {
+		return nil, fmt.Errorf("malformed STUN address attribute")
+	}
+
+	var ipLen int
+	switch value[1] {
+	case stunFamilyIPv4:
+		ipLen = net.IPv4len
+	case stunFamilyIPv6:
+		ipLen = net.IPv6len
+	default:
+		return nil, fmt.Errorf("unknown STUN address family 0x%02x", value[1])
+	}
+	if len(value) < 4+ipLen {
+		return nil, fmt.Errorf("malformed STUN address attribute")
+	}
+
+	port := binary.BigEndian.Uint16(value[2:4])
+	ip := make(net.IP, ipLen)
+	copy(ip, value[4:4+ipLen])
+	if xorKey != nil {
+		port ^= uint16(c_STUN_MAGIC_COOKIE >> 16)
+		for i := range ip {
+			ip[i] ^= xorKey[i]
+		}
+	}
+
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// handle passes a received STUN message to the waiting request.
+// Returns false if nobody waits for it.
+func (c *stunClient) handle(pkt []byte) bool {
+	id := stunMessageTxId(pkt)
+	c.lock.Lock()
+	ch, ok := c.pending[id]
+	c.lock.Unlock()
+	if !ok {
+		return false
+	}
+
+	select {
+	case ch <- pkt:
+	default:
+	}
+	return true
+}
+
+// binding performs STUN Binding transaction over the conn, retransmitting the request - RFC 5389 7.2.1.
+// Responses must be passed to the handle method by the socket reader.
+func (c *stunClient) binding(conn *net.UDPConn, server string) (*net.UDPAddr, error) {
+	raddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return nil, err
+	}
+
+	id, req, err := newStunBindingRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan []byte, 1)
+	c.lock.Lock()
+	if c.pending == nil {
+		c.pending = make(map[stunTxId]chan []byte)
+	}
+	c.pending[id] = ch
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		delete(c.pending, id)
+		c.lock.Unlock()
+	}()
+
+	rto := c_STUN_RTO
+	for attempt := 0; attempt <= c_STUN_RETRIES; attempt++ {
+		log.Debugf("sending STUN Binding request %x from %s to %s", id, conn.LocalAddr(), raddr)
+		if _, err := conn.WriteToUDP(req, raddr); err != nil {
+			return nil, err
+		}
+
+		timer := timing.NewTimer(rto)
+		select {
+		case res := <-ch:
+			timer.Stop()
+			if !bytes.Equal(res[8:20], id[:]) {
+				return nil, fmt.Errorf("STUN transaction ID mismatch")
+			}
+			return parseStunBindingResponse(res)
+		case <-timer.C():
+			rto *= 2
+		}
+	}
+
+	return nil, fmt.Errorf("STUN Binding request to %s timed out", server)
+}
@@ -0,0 +1,145 @@
+package transport
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+)
+
+// Build Binding success response for the request carrying the address of the requester.
+func makeStunResponse(req []byte, addr *net.UDPAddr, xor bool) []byte {
+	ip := addr.IP.To4()
+	value := make([]byte, 8)
+	value[1] = stunFamilyIPv4
+	binary.BigEndian.PutUint16(value[2:4], uint16(addr.Port))
+	copy(value[4:8], ip)
+
+	attrType := stunAttrMappedAddress
+	if xor {
+		attrType = stunAttrXorMappedAddress
+		binary.BigEndian.PutUint16(value[2:4], uint16(addr.Port)^uint16(c_STUN_MAGIC_COOKIE>>16))
+		for i := range value[4:8] {
+			value[4+i] ^= req[4+i]
+		}
+	}
+
+	res := make([]byte, c_STUN_HEADER_SIZE+4+len(value))
+	binary.BigEndian.PutUint16(res[0:2], stunBindingResponse)
+	binary.BigEndian.PutUint16(res[2:4], uint16(4+len(value)))
+	copy(res[4:20], req[4:20])
+	binary.BigEndian.PutUint16(res[20:22], attrType)
+	binary.BigEndian.PutUint16(res[22:24], uint16(len(value)))
+	copy(res[24:], value)
+
+	return res
+}
+
+func TestParseStunBindingResponse(t *testing.T) {
+	_, req, err := newStunBindingRequest()
+	if err != nil {
+		t.Fatalf("[FAIL] failed to build STUN request: %s", err)
+	}
+	if !isStunMessage(req) {
+		t.Errorf("[FAIL] STUN request is not recognized as STUN message")
+	}
+	if isStunMessage([]byte("OPTIONS sip:bob@example.com SIP/2.0\r\n\r\n")) {
+		t.Errorf("[FAIL] SIP message is recognized as STUN message")
+	}
+
+	expected := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 40123}
+	for _, xor := range []bool{true, false} {
+		addr, err := parseStunBindingResponse(makeStunResponse(req, expected, xor))
+		if err != nil {
+			t.Errorf("[FAIL] xor %v: unexpected error: %s", xor, err)
+		} else if addr.String() != expected.String() {
+			t.Errorf("[FAIL] xor %v: expected address %s, got %s", xor, expected, addr)
+		}
+	}
+}
+
+func TestDiscoverPublicAddr(t *testing.T) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("[FAIL] failed to start STUN server: %s", err)
+	}
+	defer server.Close()
+
+	go func() {
+		buf := make([]byte, c_BUFSIZE)
+		for {
+			num, addr, err := server.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if isStunMessage(buf[:num]) {
+				server.WriteToUDP(makeStunResponse(buf[:num], addr, true), addr)
+			}
+		}
+	}()
+
+	m, err := NewManager("udp")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create transport manager: %s", err)
+	}
+	defer m.Stop()
+
+	local := "127.0.0.1:10864"
+	if err := m.Listen(local); err != nil {
+		t.Fatalf("[FAIL] failed to listen: %s", err)
+	}
+
+	discoverer, ok := m.(PublicAddrDiscoverer)
+	if !ok {
+		t.Fatalf("[FAIL] UDP transport manager does not implement PublicAddrDiscoverer")
+	}
+
+	addr, err := discoverer.DiscoverPublicAddr(server.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("[FAIL] failed to discover public address: %s", err)
+	}
+	if addr != local {
+		t.Errorf("[FAIL] expected public address %s, got %s", local, addr)
+	}
+	if addr := discoverer.PublicAddr(); addr != local {
+		t.Errorf("[FAIL] expected remembered public address %s, got %s", local, addr)
+	}
+}
+
+func TestAdvertisePublicAddr(t *testing.T) {
+	msg, err := parser.ParseMessage([]byte("REGISTER sip:example.com SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP 10.0.0.1;branch=z9hG4bK776asdhds\r\n"+
+		"From: <sip:alice@example.com>;tag=1928301774\r\n"+
+		"To: <sip:alice@example.com>\r\n"+
+		"Call-Id: a84b4c76e66710\r\n"+
+		"CSeq: 1 REGISTER\r\n"+
+		"Contact: <sip:alice@10.0.0.1>, <sip:alice@10.0.0.1:5070>, <sip:alice@example.com>\r\n"+
+		"Content-Length: 0\r\n"+
+		"\r\n"), log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse request: %s", err)
+	}
+
+	advertisePublicAddr(msg, "203.0.113.5:40000")
+	hop, _ := msg.ViaHop()
+	if hop.Host != "203.0.113.5" || hop.Port == nil || *hop.Port != 40000 {
+		t.Errorf("[FAIL] expected public sent-by in Via, got %s", hop)
+	}
+	expected := []string{"sip:alice@203.0.113.5:40000", "sip:alice@10.0.0.1:5070", "sip:alice@example.com"}
+	for i, contact := range msg.Contacts() {
+		if i < len(expected) && contact.Address.String() != expected[i] {
+			t.Errorf("[FAIL] expected Contact %s, got %s", expected[i], contact.Address)
+		}
+	}
+
+	res := base.NewResponseFromRequest(msg.(*base.Request), 200, "OK", "")
+	hop, _ = res.ViaHop()
+	hop.Host = "10.0.0.1"
+	advertisePublicAddr(res, "203.0.113.5:40000")
+	if hop.Host != "10.0.0.1" {
+		t.Errorf("[FAIL] expected responses left as they are, got Via %s", hop)
+	}
+}
@@ -1,8 +1,9 @@
 package transport
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"strings"
 	"sync"
 	"time"
 
@@ -24,40 +25,51 @@ type Manager interface {
 
 type manager struct {
 	notifier
-	transport transport
+	transport    Protocol
+	quirks       *QuirksRegistry
+	requestRport bool
+	advertise    bool // Advertise the public address, see PublicAddrAdvertiser.
 }
 
-type transport interface {
-	IsStreamed() bool
-	IsReliable() bool
-	Listen(address string) error
-	Send(addr string, message base.SipMessage) error
-	Stop()
+// ListenerStatus is implemented by transports reporting the state of their listening points.
+type ListenerStatus interface {
+	// Listening reports whether the transport has open listening points.
+	Listening() bool
 }
 
+// NewManager creates manager of the protocol registered by the name, e.g. udp, tcp, tls, ws, wss, memory,
+// or the ones added by Register.
 // TODO: manage multiple transports: udp, tcp at once.
 func NewManager(transportType string) (m Manager, err error) {
-	err = fmt.Errorf("unknown transport type '%s'", transportType)
+	factory, ok := lookupProtocol(transportType)
+	if !ok {
+		return nil, fmt.Errorf("unknown transport type '%s'", transportType)
+	}
+	return newManager(factory)
+}
 
-	var n notifier
-	n.init()
+// NewTlsManager creates manager of TLS transport with the config,
+// which holds the certificates and the verification options.
+func NewTlsManager(config *tls.Config) (Manager, error) {
+	return newManager(func(inputs chan base.SipMessage) (Protocol, error) {
+		return NewTls(inputs, config)
+	})
+}
 
-	var transport transport
-	switch strings.ToLower(transportType) {
-	case "udp":
-		transport, err = NewUdp(n.inputs)
-	case "tcp":
-		transport, err = NewTcp(n.inputs)
-	case "tls":
-		// TODO
-	}
+func newManager(create ProtocolFactory) (m Manager, err error) {
+	// The notifier is initialized in place, so that its forwarding goroutine
+	// sees the hooks set on the manager later on.
+	mng := &manager{}
+	mng.notifier.init()
 
+	transport, err := create(mng.notifier.inputs)
 	if transport != nil && err == nil {
-		m = &manager{notifier: n, transport: transport}
+		mng.transport = transport
+		m = mng
 	} else {
 		// Close the input chan in order to stop the notifier; this prevents
 		// us leaking it.
-		close(n.inputs)
+		close(mng.notifier.inputs)
 	}
 
 	return
@@ -68,7 +80,124 @@ func (manager *manager) Listen(address string) error {
 }
 
 func (manager *manager) Send(addr string, message base.SipMessage) error {
-	return manager.transport.Send(addr, message)
+	if manager.requestRport {
+		requestRport(message)
+	}
+	if manager.advertise {
+		advertisePublicAddr(message, manager.PublicAddr())
+	}
+	message = manager.render(addr, message)
+	if err := manager.transport.Send(addr, message); err != nil {
+		if errors.Is(err, base.ErrTransport) {
+			return err
+		}
+		return base.NewError(base.ErrTransport, err, "failed to send %s to %s", message.Short(), addr)
+	}
+	return nil
+}
+
+// render formats the message with the quirks of the peer at the address, if any.
+func (manager *manager) render(addr string, message base.SipMessage) base.SipMessage {
+	if manager.quirks == nil {
+		return message
+	}
+	if quirks := manager.quirks.Lookup(addr); quirks != nil {
+		return &renderedMessage{SipMessage: message, text: quirks.Format(message), quirks: quirks}
+	}
+	return message
+}
+
+// DiscoverPublicAddr implements PublicAddrDiscoverer if the underlying transport supports it.
+func (manager *manager) DiscoverPublicAddr(stunServer string) (string, error) {
+	discoverer, ok := manager.transport.(PublicAddrDiscoverer)
+	if !ok {
+		return "", fmt.Errorf("transport %T does not support public address discovery", manager.transport)
+	}
+	return discoverer.DiscoverPublicAddr(stunServer)
+}
+
+// PublicAddr implements PublicAddrDiscoverer, returns empty string if the underlying transport doesn't support it.
+func (manager *manager) PublicAddr() string {
+	if discoverer, ok := manager.transport.(PublicAddrDiscoverer); ok {
+		return discoverer.PublicAddr()
+	}
+	return ""
+}
+
+// SetAdvertisePublicAddr implements PublicAddrAdvertiser.
+func (manager *manager) SetAdvertisePublicAddr(enabled bool) {
+	manager.advertise = enabled
+}
+
+// SetQuirks implements QuirksApplier, the quirks are applied to the sent messages.
+// The registry learns user agents of the peers from the received requests.
+func (manager *manager) SetQuirks(registry *QuirksRegistry) {
+	manager.quirks = registry
+	manager.notifier.listenerLock.Lock()
+	defer manager.notifier.listenerLock.Unlock()
+	if registry != nil {
+		manager.notifier.observe = registry.Observe
+	} else {
+		manager.notifier.observe = nil
+	}
+}
+
+// Prewarm implements Prewarmer if the underlying transport supports it.
+func (manager *manager) Prewarm(addrs ...string) error {
+	prewarmer, ok := manager.transport.(Prewarmer)
+	if !ok {
+		return fmt.Errorf("transport %T does not support connection pre-warming", manager.transport)
+	}
+	return prewarmer.Prewarm(addrs...)
+}
+
+// SetConnectionLimits implements ConnectionManager if the underlying transport is connection oriented.
+func (manager *manager) SetConnectionLimits(limits ConnectionLimits) error {
+	connections, ok := manager.transport.(ConnectionManager)
+	if !ok {
+		return fmt.Errorf("transport %T does not manage connections", manager.transport)
+	}
+	return connections.SetConnectionLimits(limits)
+}
+
+// Connections implements ConnectionManager, returns nil if the underlying transport is connectionless.
+func (manager *manager) Connections() []ConnectionInfo {
+	if connections, ok := manager.transport.(ConnectionManager); ok {
+		return connections.Connections()
+	}
+	return nil
+}
+
+// ListenOn implements MultiProtocol if the manager was created by NewMultiManager.
+func (manager *manager) ListenOn(network string, address string) error {
+	multi, ok := manager.transport.(MultiProtocol)
+	if !ok {
+		return fmt.Errorf("transport %T is not multi-protocol", manager.transport)
+	}
+	return multi.ListenOn(network, address)
+}
+
+// SetProtocolSelector implements MultiProtocol, it's ignored unless the manager was created by NewMultiManager.
+func (manager *manager) SetProtocolSelector(selector ProtocolSelector) {
+	if multi, ok := manager.transport.(MultiProtocol); ok {
+		multi.SetProtocolSelector(selector)
+	}
+}
+
+// Listening implements ListenerStatus, transports not reporting their state are assumed listening.
+func (manager *manager) Listening() bool {
+	if status, ok := manager.transport.(ListenerStatus); ok {
+		return status.Listening()
+	}
+	return true
+}
+
+// FlowFailures implements FlowMonitor, returns nil channel if the underlying transport doesn't maintain flows.
+func (manager *manager) FlowFailures() <-chan FlowFailure {
+	if monitor, ok := manager.transport.(FlowMonitor); ok {
+		return monitor.FlowFailures()
+	}
+	return nil
 }
 
 func (manager *manager) Stop() {
@@ -80,10 +209,22 @@ func (manager *manager) IsReliable() bool {
 	return manager.transport.IsReliable()
 }
 
+// IsReliableTo implements ReliabilityReporter, the reliability of the underlying transport
+// is used unless it depends on the destination. The message is checked as it is sent, with the quirks of the peer.
+func (manager *manager) IsReliableTo(addr string, message base.SipMessage) bool {
+	if reporter, ok := manager.transport.(ReliabilityReporter); ok {
+		return reporter.IsReliableTo(addr, manager.render(addr, message))
+	}
+	return manager.transport.IsReliable()
+}
+
 type notifier struct {
 	listeners    map[Listener]bool
 	listenerLock sync.Mutex
 	inputs       chan base.SipMessage
+	observe      func(msg base.SipMessage) // Inspects the received messages before the listeners, may be nil.
+	blacklist    *Blacklist                // Requests of the blacklisted hosts are dropped, may be nil.
+	scanners     *ScannerDetector          // Requests of the detected scanners are dropped, may be nil.
 }
 
 func (n *notifier) init() {
@@ -112,6 +253,19 @@ func (n *notifier) forward() {
 	for msg := range n.inputs {
 		deadListeners := make([]chan base.SipMessage, 0)
 		n.listenerLock.Lock()
+		if n.blacklist != nil && n.blacklist.Blocks(msg) {
+			n.listenerLock.Unlock()
+			msg.Log().Infof("dropping %s of blacklisted host %s", msg.Short(), SourceHost(msg))
+			continue
+		}
+		if n.scanners != nil && n.scanners.Blocks(msg) {
+			n.listenerLock.Unlock()
+			msg.Log().Infof("dropping %s of scanner host %s", msg.Short(), SourceHost(msg))
+			continue
+		}
+		if n.observe != nil {
+			n.observe(msg)
+		}
 		msg.Log().Debugf("notify %d listeners of message", len(n.listeners))
 		for listener := range n.listeners {
 			sent := listener.notify(msg)
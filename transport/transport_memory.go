@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+)
+
+// Listening points of memory transports in the process by address.
+var memoryNet = struct {
+	points map[string]*Memory
+	lock   sync.Mutex
+}{points: make(map[string]*Memory)}
+
+// Memory transport delivers messages between managers in the same process, without sockets.
+// Messages are serialized and parsed again, so the receiver sees them exactly as they would arrive over the wire.
+// It is meant for tests and replays of captured conversations.
+type Memory struct {
+	output        chan base.SipMessage
+	addrs         []string
+	lock          sync.Mutex
+	lengthPolicy  parser.ContentLengthPolicy
+	startLineHook parser.StartLineHook
+}
+
+func NewMemory(output chan base.SipMessage) (*Memory, error) {
+	return &Memory{output: output}, nil
+}
+
+// Listen binds the memory transport to the address, which can be any string unique in the process.
+func (mem *Memory) Listen(address string) error {
+	memoryNet.lock.Lock()
+	defer memoryNet.lock.Unlock()
+	if _, ok := memoryNet.points[address]; ok {
+		return fmt.Errorf("memory address %s already in use", address)
+	}
+	memoryNet.points[address] = mem
+
+	mem.lock.Lock()
+	mem.addrs = append(mem.addrs, address)
+	mem.lock.Unlock()
+	return nil
+}
+
+func (mem *Memory) IsStreamed() bool {
+	return false
+}
+
+func (mem *Memory) Network() string {
+	return "MEMORY"
+}
+
+func (mem *Memory) IsReliable() bool {
+	return true
+}
+
+func (mem *Memory) Send(addr string, msg base.SipMessage) error {
+	msg.Log().Infof("sending message to %v: %v", addr, msg.Short())
+	msg.Log().Debugf("sending message:\r\n%v", msg.String())
+
+	memoryNet.lock.Lock()
+	peer, ok := memoryNet.points[addr]
+	memoryNet.lock.Unlock()
+	if !ok {
+		return base.NewError(base.ErrTransport, nil, "no memory transport listens on %s", addr)
+	}
+
+	parsed, err := parser.ParseDatagramWithHook(
+		[]byte(msg.String()),
+		peer.lengthPolicy,
+		peer.startLineHook,
+		log.WithField("conn-tag", addr),
+	)
+	if err != nil {
+		return err
+	}
+	mem.lock.Lock()
+	if len(mem.addrs) > 0 {
+		stampSource(parsed, mem.addrs[0])
+	}
+	mem.lock.Unlock()
+	peer.output <- parsed
+	return nil
+}
+
+// Listening implements ListenerStatus.
+func (mem *Memory) Listening() bool {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+	return len(mem.addrs) > 0
+}
+
+func (mem *Memory) Stop() {
+	memoryNet.lock.Lock()
+	defer memoryNet.lock.Unlock()
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+	for _, addr := range mem.addrs {
+		if memoryNet.points[addr] == mem {
+			delete(memoryNet.points, addr)
+		}
+	}
+	mem.addrs = nil
+}
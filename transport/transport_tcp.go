@@ -1,7 +1,10 @@
 package transport
 
 import (
+	"errors"
+	"fmt"
 	"net"
+	"time"
 
 	"github.com/ghettovoice/gossip/base"
 	"github.com/ghettovoice/gossip/log"
@@ -10,27 +13,45 @@ import (
 
 type Tcp struct {
 	connTable
-	listeningPoints []*net.TCPListener
+	listeningPoints []net.Listener
+	name            string                                     // Transport name used in logs.
+	dial            func(addr string) (net.Conn, error)        // Opens a new connection to the address.
+	listen          func(address string) (net.Listener, error) // Opens a new listening point on the address.
 	parser          *parser.Parser
 	output          chan base.SipMessage
 	stop            bool
+	failures        chan FlowFailure // Failures reported by connections.
+	flowFailures    chan FlowFailure // Failures passed up to the user.
+	lengthPolicy    parser.ContentLengthPolicy
+	startLineHook   parser.StartLineHook
+	keepAlive       time.Duration // Interval of the keep-alive pings over the opened connections, 0 disables them.
+	sockets         SocketOptions
 }
 
 func NewTcp(output chan base.SipMessage) (*Tcp, error) {
-	tcp := Tcp{output: output}
-	tcp.listeningPoints = make([]*net.TCPListener, 0)
+	tcp := newStreamed("TCP", output)
+	tcp.dial = func(addr string) (net.Conn, error) {
+		return tcp.sockets.dialer(0).Dial("tcp", addr)
+	}
+	tcp.listen = func(address string) (net.Listener, error) {
+		return tcp.sockets.listen("tcp", address)
+	}
+	return tcp, nil
+}
+
+// newStreamed creates connection oriented transport, dial and listen functions are set by the caller.
+func newStreamed(name string, output chan base.SipMessage) *Tcp {
+	tcp := Tcp{name: name, output: output}
+	tcp.listeningPoints = make([]net.Listener, 0)
+	tcp.failures = make(chan FlowFailure)
+	tcp.flowFailures = make(chan FlowFailure, c_LISTENER_QUEUE_SIZE)
 	tcp.connTable.Init()
-	return &tcp, nil
+	go tcp.watchFlows()
+	return &tcp
 }
 
 func (tcp *Tcp) Listen(address string) error {
-	var err error = nil
-	addr, err := net.ResolveTCPAddr("tcp", address)
-	if err != nil {
-		return err
-	}
-
-	lp, err := net.ListenTCP("tcp", addr)
+	lp, err := tcp.listen(address)
 	if err != nil {
 		return err
 	}
@@ -46,31 +67,39 @@ func (tcp *Tcp) IsStreamed() bool {
 	return true
 }
 
+// Network returns TCP, or TLS, WS and WSS for the transports created by NewTls, NewWs and NewWss.
+func (tcp *Tcp) Network() string {
+	return tcp.name
+}
+
 func (tcp *Tcp) IsReliable() bool {
 	return true
 }
 
-func (tcp *Tcp) getConnection(addr string) (*connection, error) {
-	conn := tcp.connTable.GetConn(addr)
+// getConnection returns the open connection to the address or opens a new one.
+// Responses are sent over the connection the request was received on if it's still open - RFC 3261 18.2.2, 18.3.
+func (tcp *Tcp) getConnection(addr string, response bool) (*connection, error) {
+	var conn *connection
+	if response {
+		conn = tcp.connTable.GetResponseConn(addr)
+	} else {
+		conn = tcp.connTable.GetConn(addr)
+	}
 
 	if conn == nil {
 		log.Debugf("no stored connection for address %s; generate a new one", addr)
-		raddr, err := net.ResolveTCPAddr("tcp", addr)
+		baseConn, err := tcp.dial(addr)
 		if err != nil {
 			return nil, err
 		}
-
-		baseConn, err := net.DialTCP("tcp", nil, raddr)
-		if err != nil {
-			return nil, err
+		logger := log.WithField("conn-tag", baseConn.RemoteAddr())
+		conn = newMonitoredConn(baseConn, tcp.output, addr, tcp.failures, tcp.lengthPolicy, tcp.startLineHook, tcp.received, logger)
+		if tcp.keepAlive > 0 {
+			go conn.keepAlive(tcp.keepAlive)
 		}
-		logger := log.WithField("conn-tag", raddr)
-		conn = NewConn(baseConn, tcp.output, logger)
-	} else {
-		conn = tcp.connTable.GetConn(addr)
 	}
 
-	tcp.connTable.Notify(addr, conn)
+	tcp.connTable.Notify(conn.addr, conn)
 	return conn, nil
 }
 
@@ -78,24 +107,41 @@ func (tcp *Tcp) Send(addr string, msg base.SipMessage) error {
 	msg.Log().Infof("sending message to %v: %v", addr, msg.Short())
 	msg.Log().Debugf("sending message:\r\n%v", msg.String())
 
-	conn, err := tcp.getConnection(addr)
-	conn.log = msg.Log()
+	_, response := msg.(*base.Response)
+	conn, err := tcp.getConnection(addr, response)
 	if err != nil {
-		return err
+		return base.NewError(base.ErrTransport, err, "failed to connect %s to %s", tcp.name, addr)
+	}
+	if err = conn.Send(msg); err != nil {
+		return base.NewError(base.ErrTransport, err, "failed to send %s over %s to %s", msg.Short(), tcp.name, addr)
 	}
+	return nil
+}
 
-	err = conn.Send(msg)
-	return err
+// received keeps the connection receiving messages open and registers it for the responses to the received requests.
+func (tcp *Tcp) received(conn *connection, msg base.SipMessage) {
+	tcp.connTable.Touch(conn.addr, conn)
+	if _, ok := msg.(*base.Request); !ok {
+		return
+	}
+	if hop, err := msg.ViaHop(); err == nil {
+		tcp.connTable.Alias(base.ResponseAddr(hop), conn)
+	}
 }
 
-func (tcp *Tcp) serve(listeningPoint *net.TCPListener) {
-	log.Infof("begin serving TCP on address %s", listeningPoint.Addr().String())
+func (tcp *Tcp) serve(listeningPoint net.Listener) {
+	log.Infof("begin serving %s on address %s", tcp.name, listeningPoint.Addr().String())
 
-	iter := func(listeningPoint *net.TCPListener) bool {
+	iter := func(listeningPoint net.Listener) bool {
 		baseConn, err := listeningPoint.Accept()
 		if err != nil {
+			if tcp.stop || errors.Is(err, net.ErrClosed) {
+				log.Infof("stop serving %s on address %s", tcp.name, listeningPoint.Addr().String())
+				return false
+			}
 			log.Errorf(
-				"failed to accept TCP conn on address %s: %s",
+				"failed to accept %s conn on address %s: %s",
+				tcp.name,
 				listeningPoint.Addr().String(),
 				err.Error(),
 			)
@@ -103,9 +149,10 @@ func (tcp *Tcp) serve(listeningPoint *net.TCPListener) {
 		}
 
 		logger := log.WithField("conn-tag", baseConn.RemoteAddr())
-		conn := NewConn(baseConn, tcp.output, logger)
+		conn := newMonitoredConn(baseConn, tcp.output, "", tcp.failures, tcp.lengthPolicy, tcp.startLineHook, tcp.received, logger)
 		logger.Debugf(
-			"accepted new TCP conn %p from %s on address %s",
+			"accepted new %s conn %p from %s on address %s",
+			tcp.name,
 			&conn,
 			conn.baseConn.RemoteAddr(),
 			conn.baseConn.LocalAddr(),
@@ -121,6 +168,56 @@ func (tcp *Tcp) serve(listeningPoint *net.TCPListener) {
 	}
 }
 
+// Prewarm implements Prewarmer, opens connections to the addresses unless they are already open.
+func (tcp *Tcp) Prewarm(addrs ...string) error {
+	var failed []string
+	for _, addr := range addrs {
+		if _, err := tcp.getConnection(addr, false); err != nil {
+			log.Warnf("failed to pre-establish %s connection to %s: %s", tcp.name, addr, err)
+			failed = append(failed, addr)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to pre-establish %s connections to %v", tcp.name, failed)
+	}
+	return nil
+}
+
+// SetConnectionLimits implements ConnectionManager.
+func (tcp *Tcp) SetConnectionLimits(limits ConnectionLimits) error {
+	return tcp.connTable.SetLimits(limits)
+}
+
+// Connections implements ConnectionManager.
+func (tcp *Tcp) Connections() []ConnectionInfo {
+	return tcp.connTable.Infos()
+}
+
+// FlowFailures implements FlowMonitor.
+func (tcp *Tcp) FlowFailures() <-chan FlowFailure {
+	return tcp.flowFailures
+}
+
+// watchFlows removes broken connections from the connection table,
+// so that the next message to the address opens a new flow, and passes the failures up.
+func (tcp *Tcp) watchFlows() {
+	for failure := range tcp.failures {
+		log.Infof("%s flow to %s failed: %s", tcp.name, failure.Addr, failure.Err)
+		tcp.connTable.Drop(failure.Addr, failure.conn)
+
+		select {
+		case tcp.flowFailures <- failure:
+		default:
+			log.Warnf("flow failures queue is full, failure of flow to %s dropped", failure.Addr)
+		}
+	}
+}
+
+// Listening implements ListenerStatus.
+func (tcp *Tcp) Listening() bool {
+	return !tcp.stop && len(tcp.listeningPoints) > 0
+}
+
 func (tcp *Tcp) Stop() {
 	tcp.connTable.Stop()
 	tcp.stop = true
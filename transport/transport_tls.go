@@ -1 +1,64 @@
 package transport
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+)
+
+const c_TLS_SESSION_CACHE_SIZE int = 256
+const c_TLS_DIAL_TIMEOUT time.Duration = 10 * time.Second
+
+// Prewarmer is implemented by connection oriented transports able to open connections ahead of time,
+// so the first requests to the next hops don't pay for TCP and TLS handshakes.
+type Prewarmer interface {
+	// Prewarm opens connections to the addresses, already open ones are reused.
+	// Returns an error listing the addresses that couldn't be reached.
+	Prewarm(addrs ...string) error
+}
+
+// NewTls creates TLS transport - RFC 3261 26.2.
+// The config is cloned; if it has no ClientSessionCache an LRU one is set,
+// so the reconnecting flows resume TLS sessions instead of making full handshakes.
+// Empty ServerName is filled with the host of the dialed address.
+func NewTls(output chan base.SipMessage, config *tls.Config) (*Tcp, error) {
+	tcp := newStreamed("TLS", output)
+	tcp.dial, tcp.listen = tlsFuncs(config, &tcp.sockets)
+	return tcp, nil
+}
+
+// tlsFuncs returns dial and listen functions of TLS transport, see NewTls.
+// The sockets are opened with the options of the transport at the moment.
+func tlsFuncs(config *tls.Config, sockets *SocketOptions) (func(addr string) (net.Conn, error), func(address string) (net.Listener, error)) {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	config = config.Clone()
+	if config.ClientSessionCache == nil {
+		config.ClientSessionCache = tls.NewLRUClientSessionCache(c_TLS_SESSION_CACHE_SIZE)
+	}
+
+	dial := func(addr string) (net.Conn, error) {
+		cfg := config
+		if cfg.ServerName == "" {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			// The clone shares the session cache with the original config.
+			cfg = config.Clone()
+			cfg.ServerName = host
+		}
+		return tls.DialWithDialer(sockets.dialer(c_TLS_DIAL_TIMEOUT), "tcp", addr, cfg)
+	}
+	listen := func(address string) (net.Listener, error) {
+		lp, err := sockets.listen("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		return tls.NewListener(lp, config), nil
+	}
+	return dial, listen
+}
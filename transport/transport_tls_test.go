@@ -0,0 +1,124 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// Test that the reconnecting TLS flow resumes the session established by the pre-warmed one.
+func TestTlsPrewarmResumesSession(t *testing.T) {
+	cert := selfSignedCert(t)
+	server, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("[FAIL] failed to start TLS server: %s", err)
+	}
+	defer server.Close()
+
+	resumed := make(chan bool, 2)
+	go func() {
+		for {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			tlsConn := conn.(*tls.Conn)
+			if err := tlsConn.Handshake(); err != nil {
+				conn.Close()
+				continue
+			}
+			resumed <- tlsConn.ConnectionState().DidResume
+			// Let the client read the session ticket, then break the flow.
+			time.Sleep(50 * time.Millisecond)
+			conn.Close()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	m, err := NewTlsManager(&tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create transport manager: %s", err)
+	}
+	defer m.Stop()
+
+	prewarmer, ok := m.(Prewarmer)
+	if !ok {
+		t.Fatalf("[FAIL] TLS transport manager does not implement Prewarmer")
+	}
+	monitor := m.(FlowMonitor)
+
+	addr := server.Addr().String()
+	for i, expected := range []bool{false, true} {
+		if err := prewarmer.Prewarm(addr); err != nil {
+			t.Fatalf("[FAIL] connection %d: prewarm failed: %s", i, err)
+		}
+		select {
+		case got := <-resumed:
+			if got != expected {
+				t.Errorf("[FAIL] connection %d: expected resumed %v, got %v", i, expected, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] connection %d: server did not complete handshake", i)
+		}
+		select {
+		case <-monitor.FlowFailures():
+		case <-time.After(time.Second):
+			t.Fatalf("[FAIL] connection %d: closed flow was not reported", i)
+		}
+	}
+}
+
+// Test that Prewarm reports the addresses it couldn't connect to.
+func TestTcpPrewarmFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to reserve TCP port: %s", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	m, err := NewManager("tcp")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create transport manager: %s", err)
+	}
+	defer m.Stop()
+
+	if err := m.(Prewarmer).Prewarm(addr); err == nil {
+		t.Errorf("[FAIL] expected prewarm to closed port %s to fail", addr)
+	}
+}
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to generate key: %s", err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gossip test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create certificate: %s", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse certificate: %s", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
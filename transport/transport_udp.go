@@ -1,7 +1,9 @@
 package transport
 
 import (
+	"fmt"
 	"net"
+	"sync"
 
 	"github.com/ghettovoice/gossip/base"
 	"github.com/ghettovoice/gossip/log"
@@ -12,6 +14,12 @@ type Udp struct {
 	listeningPoints []*net.UDPConn
 	output          chan base.SipMessage
 	stop            bool
+	stun            stunClient
+	publicAddr      string
+	publicAddrLock  sync.RWMutex
+	lengthPolicy    parser.ContentLengthPolicy
+	startLineHook   parser.StartLineHook
+	sockets         SocketOptions
 }
 
 func NewUdp(output chan base.SipMessage) (*Udp, error) {
@@ -23,15 +31,11 @@ func NewUdp(output chan base.SipMessage) (*Udp, error) {
 }
 
 func (udp *Udp) Listen(address string) error {
-	addr, err := net.ResolveUDPAddr("udp", address)
-	if err != nil {
-		return err
-	}
-
-	lp, err := net.ListenUDP("udp", addr)
+	pc, err := udp.sockets.listenPacket("udp", address)
 	if err != nil {
 		return err
 	}
+	lp := pc.(*net.UDPConn)
 
 	udp.listeningPoints = append(udp.listeningPoints, lp)
 	go udp.listen(lp)
@@ -44,6 +48,10 @@ func (udp *Udp) IsStreamed() bool {
 	return false
 }
 
+func (udp *Udp) Network() string {
+	return "UDP"
+}
+
 func (udp *Udp) IsReliable() bool {
 	return false
 }
@@ -54,19 +62,46 @@ func (udp *Udp) Send(addr string, msg base.SipMessage) error {
 
 	raddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
-		return err
+		return base.NewError(base.ErrTransport, err, "failed to resolve UDP address %s", addr)
 	}
 
-	var conn *net.UDPConn
-	conn, err = net.DialUDP("udp", nil, raddr)
+	// Messages are sent from the listening point, so the responses to the requests come back to it
+	// and the responses reach the peers behind NAT through the binding of their requests - RFC 3581 3, 4.
+	if lp := udp.listeningPointFor(raddr); lp != nil {
+		if _, err = lp.WriteToUDP([]byte(msg.String()), raddr); err == nil {
+			return nil
+		}
+		msg.Log().Debugf("failed to send message from listening point %s, sending from ephemeral port: %s",
+			lp.LocalAddr(), err)
+	}
+
+	var conn net.Conn
+	conn, err = udp.sockets.dialer(0).Dial("udp", raddr.String())
 	if err != nil {
-		return err
+		return base.NewError(base.ErrTransport, err, "failed to dial UDP %s", raddr)
 	}
 	defer conn.Close()
 
-	_, err = conn.Write([]byte(msg.String()))
+	if _, err = conn.Write([]byte(msg.String())); err != nil {
+		return base.NewError(base.ErrTransport, err, "failed to send %s over UDP to %s", msg.Short(), raddr)
+	}
 
-	return err
+	return nil
+}
+
+// listeningPointFor returns the first listening point of the address family of the destination,
+// dual-stack ones, e.g. listening on [::]:5060, serve both families. Returns nil if there is none.
+func (udp *Udp) listeningPointFor(raddr *net.UDPAddr) *net.UDPConn {
+	for _, lp := range udp.listeningPoints {
+		local, ok := lp.LocalAddr().(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		if (local.IP.To4() != nil) == (raddr.IP.To4() != nil) || local.IP.IsUnspecified() && local.IP.To4() == nil {
+			return lp
+		}
+	}
+	return nil
 }
 
 // todo RFC 18.2.1
@@ -88,11 +123,19 @@ func (udp *Udp) listen(conn *net.UDPConn) {
 		}
 		logger := log.WithField("conn-tag", addr)
 		pkt := append([]byte(nil), buffer[:num]...)
+		// STUN and SIP are multiplexed on the same socket - RFC 5626 8.
+		if isStunMessage(pkt) {
+			if !udp.stun.handle(pkt) {
+				logger.Debugf("dropping unexpected STUN message")
+			}
+			return true
+		}
 		go func() {
-			msg, err := parser.ParseMessage(pkt, logger)
+			msg, err := parser.ParseDatagramWithHook(pkt, udp.lengthPolicy, udp.startLineHook, logger)
 			if err != nil {
 				logger.Warnf("failed to parse SIP message: %s", err)
 			} else {
+				stampSource(msg, addr.String())
 				udp.output <- msg
 			}
 		}()
@@ -106,6 +149,39 @@ func (udp *Udp) listen(conn *net.UDPConn) {
 	}
 }
 
+// DiscoverPublicAddr learns the public address of the first listening point using the STUN server.
+// The discovered address is remembered and available through PublicAddr.
+func (udp *Udp) DiscoverPublicAddr(stunServer string) (string, error) {
+	if len(udp.listeningPoints) == 0 {
+		return "", fmt.Errorf("UDP transport is not listening")
+	}
+
+	addr, err := udp.stun.binding(udp.listeningPoints[0], stunServer)
+	if err != nil {
+		return "", err
+	}
+
+	publicAddr := addr.String()
+	log.Infof("discovered public address %s for UDP listening point %s", publicAddr, udp.listeningPoints[0].LocalAddr())
+	udp.publicAddrLock.Lock()
+	udp.publicAddr = publicAddr
+	udp.publicAddrLock.Unlock()
+
+	return publicAddr, nil
+}
+
+// PublicAddr returns the last address discovered with DiscoverPublicAddr, or empty string.
+func (udp *Udp) PublicAddr() string {
+	udp.publicAddrLock.RLock()
+	defer udp.publicAddrLock.RUnlock()
+	return udp.publicAddr
+}
+
+// Listening implements ListenerStatus.
+func (udp *Udp) Listening() bool {
+	return !udp.stop && len(udp.listeningPoints) > 0
+}
+
 func (udp *Udp) Stop() {
 	udp.stop = true
 	for _, lp := range udp.listeningPoints {
@@ -0,0 +1,379 @@
+package transport
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+)
+
+// WebSocket subprotocol of SIP - RFC 7118 4.1.
+const c_WS_SUBPROTOCOL = "sip"
+const c_WS_HANDSHAKE_TIMEOUT time.Duration = 10 * time.Second
+const c_WS_MAX_FRAME_SIZE = 65535
+
+// GUID of the Sec-WebSocket-Accept computation - RFC 6455 1.3.
+const c_WS_GUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket frame opcodes - RFC 6455 5.2.
+const (
+	ws_op_continuation byte = 0x0
+	ws_op_text         byte = 0x1
+	ws_op_binary       byte = 0x2
+	ws_op_close        byte = 0x8
+	ws_op_ping         byte = 0x9
+	ws_op_pong         byte = 0xa
+)
+
+// NewWs creates SIP over WebSocket transport - RFC 7118, e.g. to serve WebRTC clients.
+// The Via transport token of the messages sent over it is "WS".
+// Listening points accept WebSocket handshakes on any path offering the "sip" subprotocol,
+// outgoing connections are opened to ws://addr/.
+func NewWs(output chan base.SipMessage) (*Tcp, error) {
+	ws := newStreamed("WS", output)
+	ws.dial = func(addr string) (net.Conn, error) {
+		conn, err := ws.sockets.dialer(c_WS_HANDSHAKE_TIMEOUT).Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return wsClientHandshake(conn, addr)
+	}
+	ws.listen = func(address string) (net.Listener, error) {
+		lp, err := ws.sockets.listen("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		return newWsListener(lp), nil
+	}
+	return ws, nil
+}
+
+// NewWss creates SIP over secure WebSocket transport - RFC 7118, the Via transport token is "WSS".
+// The config is treated like by NewTls.
+func NewWss(output chan base.SipMessage, config *tls.Config) (*Tcp, error) {
+	wss := newStreamed("WSS", output)
+	dialTls, listenTls := tlsFuncs(config, &wss.sockets)
+	wss.dial = func(addr string) (net.Conn, error) {
+		conn, err := dialTls(addr)
+		if err != nil {
+			return nil, err
+		}
+		return wsClientHandshake(conn, addr)
+	}
+	wss.listen = func(address string) (net.Listener, error) {
+		lp, err := listenTls(address)
+		if err != nil {
+			return nil, err
+		}
+		return newWsListener(lp), nil
+	}
+	return wss, nil
+}
+
+// wsConn is the WebSocket connection carrying SIP messages as frames, seen as a stream of their payloads.
+type wsConn struct {
+	net.Conn
+	reader    *bufio.Reader
+	client    bool   // Frames sent by the client are masked - RFC 6455 5.3.
+	pending   []byte // Payload read but not returned yet.
+	closed    bool
+	writeLock sync.Mutex
+}
+
+func (conn *wsConn) Read(b []byte) (int, error) {
+	for len(conn.pending) == 0 {
+		if conn.closed {
+			return 0, io.EOF
+		}
+		opcode, payload, err := conn.readFrame()
+		if err != nil {
+			return 0, err
+		}
+		switch opcode {
+		case ws_op_continuation, ws_op_text, ws_op_binary:
+			conn.pending = payload
+		case ws_op_ping:
+			if err := conn.writeFrame(ws_op_pong, payload); err != nil {
+				return 0, err
+			}
+		case ws_op_close:
+			conn.closed = true
+			conn.writeFrame(ws_op_close, payload)
+			return 0, io.EOF
+		}
+	}
+
+	n := copy(b, conn.pending)
+	conn.pending = conn.pending[n:]
+	return n, nil
+}
+
+// Write sends the data as a single text frame, SIP messages are UTF-8 text - RFC 7118 5.
+func (conn *wsConn) Write(b []byte) (int, error) {
+	if err := conn.writeFrame(ws_op_text, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (conn *wsConn) Close() error {
+	conn.writeFrame(ws_op_close, []byte{0x03, 0xe8}) // 1000 normal closure
+	return conn.Conn.Close()
+}
+
+func (conn *wsConn) readFrame() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(conn.reader, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(conn.reader, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(conn.reader, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > c_WS_MAX_FRAME_SIZE {
+		return 0, nil, fmt.Errorf("WebSocket frame of %d bytes exceeds the limit of %d", length, c_WS_MAX_FRAME_SIZE)
+	}
+	// The client masks all its frames, the server none of them - RFC 6455 5.1.
+	if masked && conn.client {
+		return 0, nil, fmt.Errorf("masked WebSocket frame received from the server")
+	}
+	if !masked && !conn.client {
+		return 0, nil, fmt.Errorf("unmasked WebSocket frame received from the client")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(conn.reader, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(conn.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
+
+func (conn *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|opcode)
+
+	maskBit := byte(0)
+	if conn.client {
+		maskBit = 0x80
+	}
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xffff:
+		frame = append(frame, maskBit|126, byte(length>>8), byte(length))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(length))
+		frame = append(append(frame, maskBit|127), ext[:]...)
+	}
+
+	if conn.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+	_, err := conn.Conn.Write(frame)
+	return err
+}
+
+// wsAccept computes Sec-WebSocket-Accept of the key - RFC 6455 4.2.2.
+func wsAccept(key string) string {
+	hash := sha1.Sum([]byte(key + c_WS_GUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// hasToken reports whether the comma separated header values contain the token, case insensitively.
+func hasToken(values []string, token string) bool {
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsClientHandshake opens the WebSocket over the connection, requiring the "sip" subprotocol - RFC 7118 4.1.
+func wsClientHandshake(conn net.Conn, addr string) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(c_WS_HANDSHAKE_TIMEOUT))
+	defer conn.SetDeadline(time.Time{})
+
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	handshake := "GET / HTTP/1.1\r\n" +
+		"Host: " + addr + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Protocol: " + c_WS_SUBPROTOCOL + "\r\n\r\n"
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("WebSocket handshake with %s failed: %s", addr, err)
+	}
+	res.Body.Close()
+	switch {
+	case res.StatusCode != http.StatusSwitchingProtocols:
+		err = fmt.Errorf("unexpected status %s", res.Status)
+	case res.Header.Get("Sec-WebSocket-Accept") != wsAccept(key):
+		err = fmt.Errorf("invalid Sec-WebSocket-Accept")
+	case !strings.EqualFold(res.Header.Get("Sec-WebSocket-Protocol"), c_WS_SUBPROTOCOL):
+		err = fmt.Errorf("subprotocol %s not negotiated", c_WS_SUBPROTOCOL)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("WebSocket handshake with %s failed: %s", addr, err)
+	}
+
+	return &wsConn{Conn: conn, reader: reader, client: true}, nil
+}
+
+// wsServerHandshake answers the WebSocket handshake, rejecting clients not offering the "sip" subprotocol.
+func wsServerHandshake(conn net.Conn) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(c_WS_HANDSHAKE_TIMEOUT))
+	defer conn.SetDeadline(time.Time{})
+
+	reader := bufio.NewReader(conn)
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	reject := func(status int, reason string) error {
+		fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", status, http.StatusText(status))
+		return fmt.Errorf("WebSocket handshake from %s rejected: %s", conn.RemoteAddr(), reason)
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case req.Method != http.MethodGet ||
+		!hasToken(req.Header["Upgrade"], "websocket") ||
+		!hasToken(req.Header["Connection"], "upgrade") ||
+		key == "":
+		return nil, reject(http.StatusBadRequest, "not a WebSocket upgrade")
+	case req.Header.Get("Sec-WebSocket-Version") != "13":
+		return nil, reject(http.StatusUpgradeRequired, "unsupported WebSocket version")
+	case !hasToken(req.Header["Sec-Websocket-Protocol"], c_WS_SUBPROTOCOL):
+		return nil, reject(http.StatusBadRequest, "subprotocol "+c_WS_SUBPROTOCOL+" not offered")
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAccept(key) + "\r\n" +
+		"Sec-WebSocket-Protocol: " + c_WS_SUBPROTOCOL + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		return nil, err
+	}
+
+	return &wsConn{Conn: conn, reader: reader}, nil
+}
+
+// wsListener accepts WebSocket connections, handshakes run concurrently so slow clients don't block the others.
+type wsListener struct {
+	net.Listener
+	conns chan net.Conn
+	errs  chan error
+}
+
+func newWsListener(lp net.Listener) *wsListener {
+	ln := &wsListener{
+		Listener: lp,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error, 1),
+	}
+	go ln.accept()
+	return ln
+}
+
+func (ln *wsListener) accept() {
+	for {
+		conn, err := ln.Listener.Accept()
+		if err != nil {
+			ln.errs <- err
+			return
+		}
+		go func() {
+			ws, err := wsServerHandshake(conn)
+			if err != nil {
+				log.Warnf("failed to accept WebSocket connection from %s: %s", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			select {
+			case ln.conns <- ws:
+			case err := <-ln.errs:
+				// The listener is closed.
+				ln.errs <- err
+				ws.Close()
+			}
+		}()
+	}
+}
+
+func (ln *wsListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ln.conns:
+		return conn, nil
+	case err := <-ln.errs:
+		ln.errs <- err
+		return nil, err
+	}
+}
@@ -0,0 +1,129 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+)
+
+func newListeningManager(t *testing.T, transportType string, addr string) Manager {
+	m, err := NewManager(transportType)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to create %s transport manager: %s", transportType, err)
+	}
+	if err := m.Listen(addr); err != nil {
+		t.Fatalf("[FAIL] failed to listen on %s: %s", addr, err)
+	}
+	return m
+}
+
+func freeAddr(t *testing.T) string {
+	lp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[FAIL] failed to find free port: %s", err)
+	}
+	defer lp.Close()
+	return lp.Addr().String()
+}
+
+// Test that SIP messages are exchanged both ways over the WebSocket connection.
+func TestWsSendAndReply(t *testing.T) {
+	addr, clientAddr := freeAddr(t), freeAddr(t)
+	server := newListeningManager(t, "ws", addr)
+	defer server.Stop()
+	client := newListeningManager(t, "ws", clientAddr)
+	defer client.Stop()
+	serverInput, clientInput := server.GetChannel(), client.GetChannel()
+
+	req, err := parser.ParseMessage([]byte(captureRequest), log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse request: %s", err)
+	}
+	if err := client.Send(addr, req); err != nil {
+		t.Fatalf("[FAIL] failed to send request over WebSocket: %s", err)
+	}
+	received := receive(t, serverInput)
+	// The source differs from the sent-by host of the request - RFC 3261 18.2.1.
+	expected := strings.Replace(req.String(), "z9hG4bK776asdhds", "z9hG4bK776asdhds;received=127.0.0.1", 1)
+	if received.String() != expected {
+		t.Errorf("[FAIL] expected request:\n%s\ngot:\n%s", expected, received.String())
+	}
+
+	res := base.NewResponseFromRequest(received.(*base.Request), 200, "OK", "")
+	if err := server.Send(clientAddr, res); err != nil {
+		t.Fatalf("[FAIL] failed to send response over WebSocket: %s", err)
+	}
+	if got := receive(t, clientInput); got.String() != res.String() {
+		t.Errorf("[FAIL] expected response:\n%s\ngot:\n%s", res.String(), got.String())
+	}
+}
+
+// Test that clients not offering the sip subprotocol are rejected - RFC 7118 4.1.
+func TestWsRejectsMissingSubprotocol(t *testing.T) {
+	addr := freeAddr(t)
+	server := newListeningManager(t, "ws", addr)
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to connect: %s", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\n" +
+		"Host: " + addr + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Protocol: chat\r\n\r\n"))
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("[FAIL] failed to read handshake response: %s", err)
+	}
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("[FAIL] expected 400 Bad Request, got %s", res.Status)
+	}
+}
+
+func TestWsAccept(t *testing.T) {
+	// Example of RFC 6455 1.3.
+	if got := wsAccept("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("[FAIL] unexpected Sec-WebSocket-Accept %s", got)
+	}
+	if !hasToken([]string{"keep-alive, Upgrade"}, "upgrade") || hasToken([]string{"chat"}, "sip") {
+		t.Errorf("[FAIL] unexpected token matching")
+	}
+}
+
+// Test that frames masked against the direction of the connection are refused - RFC 6455 5.1.
+func TestWsFrameMasking(t *testing.T) {
+	unmasked := []byte{0x81, 0x02, 'h', 'i'}
+	masked := []byte{0x81, 0x82, 0x01, 0x02, 0x03, 0x04, 'h' ^ 0x01, 'i' ^ 0x02}
+
+	for _, test := range []struct {
+		client bool
+		frame  []byte
+		valid  bool
+	}{
+		{false, masked, true},
+		{false, unmasked, false},
+		{true, unmasked, true},
+		{true, masked, false},
+	} {
+		conn := &wsConn{reader: bufio.NewReader(bytes.NewReader(test.frame)), client: test.client}
+		_, payload, err := conn.readFrame()
+		if test.valid && (err != nil || string(payload) != "hi") {
+			t.Errorf("[FAIL] client %v: expected frame payload hi, got %q, error %v", test.client, payload, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("[FAIL] client %v: expected frame % x refused", test.client, test.frame)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package transport
+
+import (
+	"net"
+
+	"github.com/ghettovoice/gossip/base"
+)
+
+// StampSource records the source address of the received request in its top Via hop like the built-in protocols do,
+// for the protocols added by Register.
+func StampSource(msg base.SipMessage, source string) {
+	stampSource(msg, source)
+}
+
+// stampSource records the source address of the received request in its top Via hop - RFC 3261 18.2.1, RFC 3581 4:
+// received parameter if the source IP differs from the sent-by host or the hop requests symmetric response routing
+// with empty rport, which gets the source port then. Responses are sent back to the stamped address,
+// see base.ResponseAddr. Sources other than IP:port, e.g. of the memory transport, are not stamped.
+func stampSource(msg base.SipMessage, source string) {
+	if _, ok := msg.(*base.Request); !ok {
+		return
+	}
+	host, port, err := net.SplitHostPort(source)
+	if err != nil || net.ParseIP(host) == nil {
+		return
+	}
+	hop, err := msg.ViaHop()
+	if err != nil {
+		return
+	}
+
+	rport, symmetric := hop.Params.Get("rport")
+	if sentBy := net.ParseIP(hop.Host); sentBy == nil || !sentBy.Equal(net.ParseIP(host)) || symmetric {
+		hop.Params.Add("received", base.String{S: host})
+	}
+	if symmetric && (rport == nil || rport.String() == "") {
+		hop.Params.Add("rport", base.String{S: port})
+	}
+}
+
+// RportRequester is implemented by transports requesting symmetric response routing of the sent requests - RFC 3581 3:
+// the responses are sent back to the source IP and port of the request rather than to its Via sent-by,
+// which is unreachable for the clients behind NAT.
+type RportRequester interface {
+	// SetRequestRport enables adding empty rport parameter to the top Via hop of the sent requests, disabled by default.
+	// The hops already having the parameter are left as they are, see base.ViaHop.WithRport to request it per request.
+	SetRequestRport(enabled bool)
+}
+
+// SetRequestRport implements RportRequester.
+func (manager *manager) SetRequestRport(enabled bool) {
+	manager.requestRport = enabled
+}
+
+// requestRport adds empty rport parameter to the top Via hop of the request.
+func requestRport(msg base.SipMessage) {
+	if _, ok := msg.(*base.Request); !ok {
+		return
+	}
+	if hop, err := msg.ViaHop(); err == nil {
+		hop.WithRport()
+	}
+}
@@ -0,0 +1,134 @@
+package transport
+
+import (
+	"testing"
+
+	"github.com/ghettovoice/gossip/base"
+	"github.com/ghettovoice/gossip/log"
+	"github.com/ghettovoice/gossip/parser"
+)
+
+func TestStampSource(t *testing.T) {
+	for _, tc := range []struct {
+		via    string
+		source string
+		hop    string
+	}{
+		{"SIP/2.0/UDP 10.0.0.1:5060;branch=z9hG4bK1", "10.0.0.1:5060", "SIP/2.0/UDP 10.0.0.1:5060;branch=z9hG4bK1"},
+		{"SIP/2.0/UDP client.example.com;branch=z9hG4bK1", "10.0.0.1:5060", "SIP/2.0/UDP client.example.com;branch=z9hG4bK1;received=10.0.0.1"},
+		{"SIP/2.0/UDP 192.168.0.1:5060;branch=z9hG4bK1;rport", "10.0.0.1:40000",
+			"SIP/2.0/UDP 192.168.0.1:5060;branch=z9hG4bK1;rport=40000;received=10.0.0.1"},
+		{"SIP/2.0/UDP 10.0.0.1:5060;rport;branch=z9hG4bK1", "10.0.0.1:5060", "SIP/2.0/UDP 10.0.0.1:5060;rport=5060;branch=z9hG4bK1;received=10.0.0.1"},
+		{"SIP/2.0/UDP client.example.com;branch=z9hG4bK1", "memory-uac", "SIP/2.0/UDP client.example.com;branch=z9hG4bK1"},
+		{"SIP/2.0/UDP [2001:DB8::1]:5060;branch=z9hG4bK1", "[2001:db8::1]:5060", "SIP/2.0/UDP [2001:DB8::1]:5060;branch=z9hG4bK1"},
+		{"SIP/2.0/UDP [2001:db8::1]:5060;branch=z9hG4bK1;rport", "[2001:db8::2]:40000",
+			"SIP/2.0/UDP [2001:db8::1]:5060;branch=z9hG4bK1;rport=40000;received=2001:db8::2"},
+	} {
+		msg, err := parser.ParseMessage([]byte("OPTIONS sip:bob@example.com SIP/2.0\r\n"+
+			"Via: "+tc.via+"\r\n"+
+			"CSeq: 1 OPTIONS\r\n"+
+			"Content-Length: 0\r\n\r\n"), log.StandardLogger())
+		if err != nil {
+			t.Fatalf("[FAIL] failed to parse request: %s", err)
+		}
+		stampSource(msg, tc.source)
+		if hop, _ := msg.ViaHop(); hop.String() != tc.hop {
+			t.Errorf("[FAIL] expected Via hop %q received from %s, got %q", tc.hop, tc.source, hop)
+		}
+	}
+
+	// Responses are never stamped.
+	res := base.NewResponse("SIP/2.0", 200, "OK", []base.SipHeader{&base.ViaHeader{base.NewViaHop("UDP", "client.example.com", 0, "")}},
+		"", log.StandardLogger())
+	stampSource(res, "10.0.0.1:5060")
+	if hop, _ := res.ViaHop(); hop.Params.Length() != 1 {
+		t.Errorf("[FAIL] unexpected response Via hop %q", hop)
+	}
+}
+
+// Test that the requests sent with rport requested get the responses back through the listening point.
+func TestRequestRport(t *testing.T) {
+	uac, _ := NewManager("udp")
+	uas, _ := NewManager("udp")
+	defer uac.Stop()
+	defer uas.Stop()
+	if err := uac.Listen("127.0.0.1:10870"); err != nil {
+		t.Fatalf("[FAIL] failed to listen: %s", err)
+	}
+	if err := uas.Listen("127.0.0.1:10871"); err != nil {
+		t.Fatalf("[FAIL] failed to listen: %s", err)
+	}
+	uac.(RportRequester).SetRequestRport(true)
+
+	// The Via sent-by is unreachable, like the private address of the client behind NAT.
+	req, err := parser.ParseMessage([]byte(captureRequest), log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse request: %s", err)
+	}
+	if err := uac.Send("127.0.0.1:10871", req); err != nil {
+		t.Fatalf("[FAIL] failed to send request: %s", err)
+	}
+	received := receive(t, uas.GetChannel())
+	hop, _ := received.ViaHop()
+	if hop.String() != "SIP/2.0/UDP uac;branch=z9hG4bK776asdhds;rport=10870;received=127.0.0.1" {
+		t.Errorf("[FAIL] unexpected Via hop of the received request %q", hop)
+	}
+
+	res := base.NewResponseFromRequest(received.(*base.Request), 200, "OK", "")
+	if err := uas.Send(base.ResponseAddr(hop), res); err != nil {
+		t.Fatalf("[FAIL] failed to send response: %s", err)
+	}
+	if msg := receive(t, uac.GetChannel()); msg.Short() != res.Short() {
+		t.Errorf("[FAIL] expected %s, got %s", res.Short(), msg.Short())
+	}
+}
+
+func TestCanonicalAddr(t *testing.T) {
+	for addr, expected := range map[string]string{
+		"[2001:DB8:0::1]:5060": "[2001:db8::1]:5060",
+		"[2001:db8::1]:5060":   "[2001:db8::1]:5060",
+		"192.0.2.1:5060":       "192.0.2.1:5060",
+		"example.com:5060":     "example.com:5060",
+		"memory-uac":           "memory-uac",
+	} {
+		if canonical := canonicalAddr(addr); canonical != expected {
+			t.Errorf("[FAIL] expected canonical address of %s %s, got %s", addr, expected, canonical)
+		}
+	}
+}
+
+// Test that the responses to the requests received over IPv6 find their way back.
+func TestUdpIPv6(t *testing.T) {
+	uac, _ := NewManager("udp")
+	uas, _ := NewManager("udp")
+	defer uac.Stop()
+	defer uas.Stop()
+	if err := uac.Listen("[::1]:10872"); err != nil {
+		t.Skipf("IPv6 loopback is not available: %s", err)
+	}
+	if err := uas.Listen("[::1]:10873"); err != nil {
+		t.Skipf("IPv6 loopback is not available: %s", err)
+	}
+
+	req, err := parser.ParseMessage([]byte("OPTIONS sip:uas@[::1]:10873 SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP [::1]:10872;rport;branch=z9hG4bK776asdhds\r\n"+
+		"CSeq: 1 OPTIONS\r\n"+
+		"Content-Length: 0\r\n\r\n"), log.StandardLogger())
+	if err != nil {
+		t.Fatalf("[FAIL] failed to parse request: %s", err)
+	}
+	if err := uac.Send(base.UriAddr(req.(*base.Request).Recipient.(*base.SipUri)), req); err != nil {
+		t.Fatalf("[FAIL] failed to send request: %s", err)
+	}
+	received := receive(t, uas.GetChannel())
+	hop, _ := received.ViaHop()
+	if addr := base.ResponseAddr(hop); addr != "[::1]:10872" {
+		t.Fatalf("[FAIL] expected response address [::1]:10872, got %s", addr)
+	}
+
+	res := base.NewResponseFromRequest(received.(*base.Request), 200, "OK", "")
+	if err := uas.Send(base.ResponseAddr(hop), res); err != nil {
+		t.Fatalf("[FAIL] failed to send response: %s", err)
+	}
+	receive(t, uac.GetChannel())
+}